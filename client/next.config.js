@@ -1,16 +1,24 @@
+// NEXT_OUTPUT=export writes a static build to out/ for embedding in the
+// server binary (pnpm build:embed). Static builds cannot use rewrites.
+const staticExport = process.env.NEXT_OUTPUT === 'export';
+
 /** @type {import('next').NextConfig} */
 const nextConfig = {
   env: {
-    API_URL: process.env.API_URL || 'http://localhost:8080',
-  },
-  async rewrites() {
-    return [
-      {
-        source: '/api/:path*',
-        destination: '/api/:path*',
-      },
-    ];
+    API_URL: process.env.API_URL || (staticExport ? '' : 'http://localhost:8080'),
   },
+  ...(staticExport
+    ? { output: 'export', trailingSlash: false, images: { unoptimized: true } }
+    : {
+        async rewrites() {
+          return [
+            {
+              source: '/api/:path*',
+              destination: '/api/:path*',
+            },
+          ];
+        },
+      }),
 };
 
 module.exports = nextConfig; 
\ No newline at end of file
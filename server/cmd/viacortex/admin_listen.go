@@ -0,0 +1,74 @@
+package main
+
+import (
+    "fmt"
+    "net"
+    "os"
+    "strconv"
+    "strings"
+)
+
+// openAdminListener opens the admin API listener on addr, which is either a
+// TCP address ("127.0.0.1:8080", "10.0.0.5:8080", ":8080") or a Unix socket
+// ("unix:/run/viacortex/admin.sock"). Socket permissions default to 0660 and
+// can be changed with ADMIN_SOCKET_MODE (octal).
+func openAdminListener(addr string) (net.Listener, string, error) {
+    addr = strings.TrimSpace(addr)
+
+    if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+        ln, err := listenUnixSocket(path)
+        return ln, addr, err
+    }
+
+    ln, err := net.Listen("tcp", addr)
+    if err != nil {
+        return nil, addr, err
+    }
+    return ln, ln.Addr().String(), nil
+}
+
+func listenUnixSocket(path string) (net.Listener, error) {
+    if path == "" {
+        return nil, fmt.Errorf("ADMIN_ADDR unix socket path is empty")
+    }
+
+    mode := os.FileMode(0660)
+    if m := os.Getenv("ADMIN_SOCKET_MODE"); m != "" {
+        parsed, err := strconv.ParseUint(m, 8, 32)
+        if err != nil {
+            return nil, fmt.Errorf("invalid ADMIN_SOCKET_MODE %q: %w", m, err)
+        }
+        mode = os.FileMode(parsed)
+    }
+
+    // Remove a socket left behind by an unclean shutdown
+    if info, err := os.Stat(path); err == nil {
+        if info.Mode()&os.ModeSocket == 0 {
+            return nil, fmt.Errorf("%s exists and is not a socket", path)
+        }
+        if err := os.Remove(path); err != nil {
+            return nil, err
+        }
+    }
+
+    ln, err := net.Listen("unix", path)
+    if err != nil {
+        return nil, err
+    }
+    if err := os.Chmod(path, mode); err != nil {
+        ln.Close()
+        return nil, err
+    }
+    return ln, nil
+}
+
+// isLocalOnly reports whether a listener can only be reached from this host
+func isLocalOnly(ln net.Listener) bool {
+    switch addr := ln.Addr().(type) {
+    case *net.UnixAddr:
+        return true
+    case *net.TCPAddr:
+        return addr.IP != nil && addr.IP.IsLoopback()
+    }
+    return false
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+    "context"
+    "crypto/tls"
+    "errors"
+    "fmt"
+    "os"
+    "strings"
+    "sync"
+    "time"
+
+    "viacortex/internal/proxy"
+)
+
+// adminTLSFromEnv decides how the admin API is served:
+//
+//   - ADMIN_TLS_CERT and ADMIN_TLS_KEY: serve the given certificate, picking
+//     up renewals written to the same files
+//   - ADMIN_HOSTNAME: obtain and renew a certificate for that hostname with
+//     certmagic, through the proxy's ACME setup
+//   - ADMIN_TLS=off: serve plain HTTP, for deployments where the admin API is
+//     only reachable from localhost or behind a TLS-terminating proxy
+//
+// It returns nil when the admin API should be served without TLS. Outside
+// production, plain HTTP is the default when nothing is configured.
+func adminTLSFromEnv(ctx context.Context, base *tls.Config, proxyServer *proxy.ProxyServer) (*tls.Config, error) {
+    mode := strings.ToLower(os.Getenv("ADMIN_TLS"))
+    certFile, keyFile := os.Getenv("ADMIN_TLS_CERT"), os.Getenv("ADMIN_TLS_KEY")
+    hostname := os.Getenv("ADMIN_HOSTNAME")
+
+    if mode == "off" || mode == "false" || mode == "0" {
+        logger.Warn("Admin API TLS is disabled (ADMIN_TLS=off); tokens are sent in cleartext")
+        return nil, nil
+    }
+
+    config := base.Clone()
+    switch {
+    case certFile != "" || keyFile != "":
+        if certFile == "" || keyFile == "" {
+            return nil, errors.New("ADMIN_TLS_CERT and ADMIN_TLS_KEY must be set together")
+        }
+        certs := &fileCertificate{certFile: certFile, keyFile: keyFile}
+        if _, err := certs.GetCertificate(nil); err != nil {
+            return nil, err
+        }
+        config.GetCertificate = certs.GetCertificate
+        logger.Info("Admin API TLS using certificate", "cert", certFile)
+
+    case hostname != "":
+        if err := proxyServer.ManageCertificate(ctx, hostname); err != nil {
+            return nil, fmt.Errorf("managing admin certificate for %s: %w", hostname, err)
+        }
+        config.GetCertificate = proxyServer.GetCertificate
+        logger.Info("Admin API TLS using a managed certificate", "host", hostname)
+
+    case os.Getenv("ENV") == "production":
+        return nil, errors.New("admin API TLS is not configured: set ADMIN_TLS_CERT/ADMIN_TLS_KEY or ADMIN_HOSTNAME, or ADMIN_TLS=off to serve plain HTTP")
+
+    default:
+        logger.Warn("Admin API TLS is not configured; serving plain HTTP outside production")
+        return nil, nil
+    }
+
+    return config, nil
+}
+
+// fileCertificate serves a certificate from disk and reloads it when the
+// files change, so renewals by external tooling need no restart
+type fileCertificate struct {
+    certFile string
+    keyFile  string
+
+    mu      sync.Mutex
+    cert    *tls.Certificate
+    modTime time.Time
+}
+
+func (f *fileCertificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    modTime, err := latestModTime(f.certFile, f.keyFile)
+    if err != nil {
+        if f.cert != nil {
+            return f.cert, nil
+        }
+        return nil, err
+    }
+    if f.cert != nil && !modTime.After(f.modTime) {
+        return f.cert, nil
+    }
+
+    cert, err := tls.LoadX509KeyPair(f.certFile, f.keyFile)
+    if err != nil {
+        if f.cert != nil {
+            // Keep serving the old pair while files are half written
+            logger.Error("Reloading admin certificate failed", "error", err)
+            return f.cert, nil
+        }
+        return nil, fmt.Errorf("loading admin certificate: %w", err)
+    }
+    f.cert, f.modTime = &cert, modTime
+    return f.cert, nil
+}
+
+func latestModTime(paths ...string) (time.Time, error) {
+    var latest time.Time
+    for _, path := range paths {
+        info, err := os.Stat(path)
+        if err != nil {
+            return time.Time{}, err
+        }
+        if info.ModTime().After(latest) {
+            latest = info.ModTime()
+        }
+    }
+    return latest, nil
+}
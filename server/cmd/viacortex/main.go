@@ -3,7 +3,7 @@ package main
 import (
 	"context"
 	"crypto/tls"
-	"log"
+	"flag"
 	"net"
 	"net/http"
 	"os"
@@ -13,48 +13,283 @@ import (
 	"time"
 
 	"viacortex/internal/api"
+	"viacortex/internal/audit"
+	"viacortex/internal/auth"
+	"viacortex/internal/cluster"
+	"viacortex/internal/config"
 	"viacortex/internal/db"
+	"viacortex/internal/events"
+	"viacortex/internal/grpcapi"
 	"viacortex/internal/healthcheck"
+	"viacortex/internal/jobs"
+	"viacortex/internal/ldap"
+	"viacortex/internal/logging"
+	"viacortex/internal/mailer"
 	"viacortex/internal/middleware"
+	"viacortex/internal/oidc"
 	"viacortex/internal/proxy"
+	"viacortex/internal/reporting"
+	"viacortex/internal/threatfeed"
+	"viacortex/internal/ui"
+	"viacortex/internal/usage"
+	"viacortex/internal/webauthn"
+	"viacortex/internal/webhooks"
 
 	"github.com/go-chi/chi/v5"
 	chimiddleware "github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"google.golang.org/grpc"
 )
 
+var logger = logging.For("server")
+
+// fatal logs an error that keeps the server from starting and exits
+func fatal(msg string, args ...any) {
+    logger.Error(msg, args...)
+    os.Exit(1)
+}
+
 func main() {
+    // "viacortex migrate ..." manages the schema and exits
+    if len(os.Args) > 1 && os.Args[1] == "migrate" {
+        os.Exit(runMigrate(os.Args[2:]))
+    }
+    // "viacortex service ..." registers the Windows service and exits
+    if len(os.Args) > 1 && os.Args[1] == "service" {
+        os.Exit(runService(os.Args[2:]))
+    }
+
+    configPath := flag.String("config", os.Getenv("VIACORTEX_CONFIG"), "path to the YAML config file (default: viacortex.yaml in the platform config directory, if present)")
+    printConfig := flag.Bool("print-config", false, "print the effective configuration and exit")
+    checkOnly := flag.Bool("check", false, "run the preflight checks and exit")
+    flag.Parse()
+
+    // Settings from the config file, overridden by the environment
+    cfg, err := config.Load(*configPath)
+    if err != nil {
+        fatal("Invalid configuration", "error", err)
+    }
+    if *printConfig {
+        if err := cfg.Print(os.Stdout); err != nil {
+            fatal("Printing configuration failed", "error", err)
+        }
+        return
+    }
+
+    // Structured logging; the config was validated, so the levels parse
+    logLevel, _ := logging.ParseLevel(cfg.LogLevel)
+    if err := logging.Setup(os.Stderr, cfg.LogFormat, logLevel); err != nil {
+        fatal("Invalid logging configuration", "error", err)
+    }
+    for module, level := range cfg.LogModules {
+        l, _ := logging.ParseLevel(level)
+        logging.SetModuleLevel(module, l)
+    }
+
+    // Report panics and background errors once the tracker is configured
+    if cfg.SentryDSN != "" {
+        sentry, err := reporting.NewSentry(cfg.SentryDSN, cfg.SentryEnvironment, cfg.SentryRelease)
+        if err != nil {
+            fatal("Invalid Sentry configuration", "error", err)
+        }
+        reporting.SetReporter(sentry)
+    }
+
     // Create a context that we'll cancel on shutdown
     ctx, cancel := context.WithCancel(context.Background())
     defer cancel()
 
-    // Initialize DB connection
-    dbpool, err := db.InitDB()
+    // Initialize DB connection, waiting for the database if it is still
+    // starting up
+    dbpool, err := db.InitDB(ctx, cfg.DatabaseURL, poolOptions(cfg), cfg.AutoMigrate, time.Duration(cfg.DBConnectTimeout))
     if err != nil {
-        log.Fatalf("Unable to connect to database: %v\n", err)
+        fatal("Unable to connect to database", "error", err)
     }
     defer dbpool.Close()
 
+    // Log and metrics queries go to the read-only DSN when one is set
+    readPool := dbpool
+    if cfg.DatabaseReadURL != "" {
+        readPool, err = db.Connect(ctx, cfg.DatabaseReadURL, poolOptions(cfg), time.Duration(cfg.DBConnectTimeout))
+        if err != nil {
+            fatal("Unable to connect to read database", "error", err)
+        }
+        defer readPool.Close()
+    }
+
+    // Live admin events, fed by webhook events and audited user actions
+    eventBroker := events.NewBroker()
+
+    // Singleton background jobs run on the cluster leader only
+    leader := cluster.NewLeader(dbpool, time.Duration(cfg.ClusterHeartbeatInterval))
+    leader.Start(ctx)
+
+    // Periodic background work, listed and triggered under /api/jobs
+    scheduler := jobs.NewScheduler()
+    scheduler.SetLeader(leader.IsLeader)
+
+    // Start webhook delivery worker
+    webhookDispatcher := webhooks.NewDispatcher(dbpool)
+    webhookDispatcher.SetLeader(leader.IsLeader)
+    webhookDispatcher.SetBroker(eventBroker)
+    webhookDispatcher.Start(ctx)
+
+    // JWT signing keys, shared through the database and rotated on schedule
+    keyRing, err := auth.KeyRingFromEnv(dbpool)
+    if err != nil {
+        fatal("Invalid signing key configuration", "error", err)
+    }
+    if err := keyRing.Load(ctx); err != nil {
+        fatal("Unable to load JWT signing keys", "error", err)
+    }
+
+    // Fail fast on a broken environment rather than starting half working
+    if cfg.Preflight || *checkOnly {
+        if err := preflight(ctx, cfg, dbpool, keyRing); err != nil {
+            fatal("Preflight checks failed", "error", err)
+        }
+        if *checkOnly {
+            logger.Info("Preflight checks passed")
+            return
+        }
+    }
+    keyRing.Start(ctx)
+
+    // Audit log retention, if configured
+    auditRetention, err := audit.RetentionFromEnv(dbpool)
+    if err != nil {
+        fatal("Invalid audit retention configuration", "error", err)
+    }
+    if auditRetention != nil {
+        scheduler.Add("audit_retention", audit.RetentionInterval, auditRetention.Run,
+            jobs.LeaderOnly(), jobs.WithJitter(10*time.Minute))
+    }
+
     // Initialize proxy server
     proxyServer, err := proxy.NewProxyServer()
     if err != nil {
-        log.Fatal(err)
+        fatal("Creating proxy server failed", "error", err)
+    }
+    if cfg.ACMEEmail == "" {
+        logger.Warn("No ACME account email configured (acme_email / ACME_EMAIL); certificate expiry notices will not be sent")
+    }
+    // Nodes of a cluster keep certificates in the shared database
+    nodeID := cfg.NodeID
+    if nodeID == "" {
+        nodeID = cluster.DefaultNodeID()
+    }
+    if cfg.RedisURL != "" {
+        redisClient, err := cluster.ConnectRedis(ctx, cfg.RedisURL)
+        if err != nil {
+            fatal("Unable to connect to Redis", "error", err)
+        }
+        defer redisClient.Close()
+        proxyServer.SetRateLimiter(cluster.NewRedisRateLimiter(redisClient))
+        if cfg.CertStorage == "redis" {
+            proxyServer.SetCertStorage(cluster.NewRedisCertStorage(redisClient, nodeID))
+        }
+    }
+    if cfg.CertStorage == "database" {
+        proxyServer.SetCertStorage(cluster.NewCertStorage(dbpool, nodeID))
+    }
+    acmeHTTPPort := cfg.ACMEHTTPPort
+    if acmeHTTPPort == 0 {
+        acmeHTTPPort = cfg.HTTPPort
+    }
+    proxyServer.SetACMEChallenge(cfg.ACMEListenHost, acmeHTTPPort)
+    if err := proxyServer.ConfigureCertmagic(cfg.ACMEEmail, cfg.StorageDir); err != nil {
+        fatal("Configuring certmagic failed", "error", err)
     }
-	if err := proxyServer.ConfigureCertmagic("geeth0924@gmail.com"); err != nil {
-    log.Fatalf("Failed to configure certmagic: %v", err)
-}
     proxyServer.Metrics().SetDB(dbpool)
+    proxyServer.Metrics().SetNodeID(nodeID)
+    proxyServer.SetWebhooks(webhookDispatcher)
+    proxyServer.SetPublicIPs(proxy.PublicIPsFromEnv())
+    proxyServer.SetTCPPorts(cfg.TCPPorts)
+    proxyServer.SetTraceStart(cfg.TraceStart)
 
     // Initialize and do first load of domains
     loader := proxy.NewLoader(dbpool, proxyServer)
+    // Periodic reloads run as a job; the loader only reacts to change events
+    loader.SetInterval(0)
+
+    // Config and certificate events between nodes go over NATS when it is
+    // configured
+    var bus *cluster.NATSBus
+    if cfg.NATSURL != "" {
+        bus, err = cluster.ConnectNATS(cfg.NATSURL, nodeID)
+        if err != nil {
+            fatal("Unable to connect to NATS", "error", err)
+        }
+        defer bus.Close()
+        if err := loader.SetBus(bus); err != nil {
+            fatal("Unable to subscribe to config changes", "error", err)
+        }
+        if err := proxyServer.SetBus(bus); err != nil {
+            fatal("Unable to subscribe to certificate events", "error", err)
+        }
+    }
 	if err := loader.LoadAllDomains(); err != nil {
-		log.Printf("Initial domain load error: %v", err)
+		logger.Error("Initial domain load failed", "error", err)
 	}
     // Start background domain loading
     go loader.Start(ctx)
+    reloadInterval := time.Duration(cfg.DomainReloadInterval)
+    scheduler.Add("domain_reload", reloadInterval, func(context.Context) error {
+        return loader.LoadAllDomains()
+    }, jobs.Delayed(), jobs.WithJitter(reloadInterval/10))
+    scheduler.Add("metrics_flush", time.Minute, func(context.Context) error {
+        proxyServer.Metrics().Flush()
+        return nil
+    }, jobs.Delayed())
+
+    // Watch the database connection; the proxy keeps serving the last loaded
+    // domains while it is down and reloads as soon as it is back
+    dbMonitor := db.NewMonitor(dbpool, time.Duration(cfg.DBHealthCheckInterval))
+    dbMonitor.OnRecover(func() {
+        if err := loader.LoadAllDomains(); err != nil {
+            logger.Error("Domain reload failed", "error", err)
+        }
+    })
+    dbMonitor.Start(ctx)
+
+    // Prune stale challenge tokens and keep certificate storage within its
+    // quota
+    cleanInterval := time.Duration(cfg.StorageCleanInterval)
+    storageJanitor := proxy.NewStorageJanitor(proxyServer, cleanInterval,
+        time.Duration(cfg.ChallengeTTL), int64(cfg.CertStorageQuotaMB)<<20)
+    storageJanitor.SetLeader(leader.IsLeader)
+    scheduler.Add("cert_storage_cleanup", cleanInterval, storageJanitor.Clean, jobs.WithJitter(cleanInterval/10))
+
+    // Register this node so the cluster view shows it and its status
+    registry := cluster.NewRegistry(dbpool, nodeID, time.Duration(cfg.ClusterHeartbeatInterval))
+    registry.SetStatus(func() map[string]interface{} {
+        return map[string]interface{}{
+            "loader":       loader.Status(),
+            "database":     dbMonitor.Status(),
+            "listeners":    proxyServer.Listeners(),
+            "domains":      proxyServer.DomainCount(),
+            "cert_storage": cfg.CertStorage,
+            "leader":       leader.IsLeader(),
+            "drain":        proxyServer.DrainStatus(),
+            "storage":      storageJanitor.Usage(),
+            "blocklist":    proxyServer.BlocklistSize(),
+        }
+    })
+    registry.Start(ctx)
+
+    // Report each finished month's usage to webhooks, for billing
+    usageReporter := usage.NewReporter(dbpool, webhookDispatcher)
+    scheduler.Add("usage_report", usage.ReportInterval, usageReporter.Run, jobs.LeaderOnly())
+
+    // Threat feeds: the leader fetches them, every node loads the blocklist
+    scheduler.Add("threat_feed_sync", threatfeed.SyncInterval, threatfeed.NewSyncer(dbpool).Run, jobs.LeaderOnly())
+    scheduler.Add("threat_blocklist_load", threatfeed.LoadInterval, threatfeed.NewRefresher(dbpool, proxyServer).Run)
 
 	healthChecker := healthcheck.NewChecker(dbpool)
-    healthChecker.Start(ctx)
+    healthChecker.SetWebhooks(webhookDispatcher)
+    scheduler.Add("health_check", time.Duration(cfg.HealthCheckInterval), healthChecker.CheckAll, jobs.LeaderOnly())
+
+    scheduler.Start(ctx)
 
     // Initialize admin router with middleware
     r := chi.NewRouter()
@@ -64,15 +299,14 @@ func main() {
     r.Use(chimiddleware.RealIP)
     r.Use(chimiddleware.Logger)
     r.Use(chimiddleware.Recoverer)
-    r.Use(chimiddleware.Timeout(60 * time.Second))
 
     // Security middleware
     r.Use(middleware.SecurityHeaders)
     r.Use(cors.Handler(cors.Options{
-        AllowedOrigins:   []string{"http://localhost:*", "https://*.viacortex.com"},
-        AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-        AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-Refresh-Token"},
-        ExposedHeaders:   []string{"Link"},
+        AllowedOrigins:   cfg.CORSOrigins,
+        AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+        AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-Refresh-Token", "X-API-Key", "X-Organization-ID", "If-Match", "If-None-Match"},
+        ExposedHeaders:   []string{"Link", "API-Version", "Deprecation", "ETag"},
         AllowCredentials: true,
         MaxAge:          300,
     }))
@@ -80,7 +314,90 @@ func main() {
     r.Use(chimiddleware.Compress(5))
 
     // Initialize handlers and routes
-    handlers := api.NewHandlers(dbpool)
+    handlers := api.NewHandlers(dbpool, webhookDispatcher)
+    handlers.SetProxy(proxyServer)
+    handlers.SetReadDB(readPool)
+    handlers.SetCORSOrigins(cfg.CORSOrigins)
+    handlers.SetEvents(eventBroker)
+    handlers.SetLoader(loader)
+    handlers.SetCluster(registry)
+    handlers.SetLeader(leader)
+    handlers.SetStorageJanitor(storageJanitor)
+    handlers.SetJobs(scheduler)
+    handlers.SetQuotaDefaults(cfg.QuotaMaxDomains, cfg.QuotaMaxBackends, cfg.QuotaMaxIPRules)
+    handlers.SetProber(proxy.NewProber(dbpool, proxyServer, nodeID, cfg.HTTPPort, cfg.HTTPSPort))
+    if bus != nil {
+        handlers.SetBus(bus)
+    }
+    handlers.SetKeyRing(keyRing)
+    handlers.SetWebAuthn(webauthn.FromEnv())
+
+    // Role permissions, loaded before any role names are validated below
+    if err := handlers.LoadRoles(ctx); err != nil {
+        fatal("Unable to load roles", "error", err)
+    }
+    handlers.StartRoleRefresh(ctx)
+
+    // The first admin account needs a bootstrap token
+    bootstrap, err := auth.BootstrapFromEnv(dbpool)
+    if err != nil {
+        fatal("Invalid registration configuration", "error", err)
+    }
+    if err := bootstrap.Prepare(ctx); err != nil {
+        fatal("Unable to prepare bootstrap token", "error", err)
+    }
+    handlers.SetBootstrap(bootstrap)
+
+    // Local development key, only when explicitly enabled
+    devKey, err := auth.DevAuthKeyFromEnv()
+    if err != nil {
+        fatal("Invalid development auth configuration", "error", err)
+    }
+    if devKey != "" {
+        keyPath, err := auth.WriteDevAuthKey(cfg.StorageDir, devKey)
+        if err != nil {
+            fatal("Unable to write development auth key", "error", err)
+        }
+        handlers.SetDevAuth(devKey)
+        logger.Warn("VIACORTEX_DEV_AUTH is enabled; requests with the X-API-Key in this file act as the first admin. Do not use in production.", "file", keyPath)
+    }
+
+    // Single sign-on, enabled when an identity provider is configured
+    ssoFlow, err := oidc.FromEnv(middleware.IsValidRole)
+    if err != nil {
+        fatal("Invalid single sign-on configuration", "error", err)
+    }
+    if ssoFlow != nil {
+        handlers.SetSSO(ssoFlow)
+    }
+
+    // Password logins against LDAP / Active Directory when AUTH_BACKEND=ldap
+    directory, err := ldap.FromEnv(middleware.IsValidRole)
+    if err != nil {
+        fatal("Invalid LDAP configuration", "error", err)
+    }
+    if directory != nil {
+        handlers.SetDirectory(directory)
+        logger.Info("Authenticating users against directory", "url", directory.URL.Redacted(), "fallback_local", directory.FallbackLocal)
+    }
+
+    // Password reset emails, enabled when SMTP is configured
+    resetMailer, err := mailer.FromEnv()
+    if err != nil {
+        fatal("Invalid SMTP configuration", "error", err)
+    }
+    if resetMailer != nil {
+        handlers.SetPasswordReset(resetMailer, os.Getenv("PASSWORD_RESET_URL"))
+    }
+    // Country of each login for new-location alerts, from a header set by
+    // a fronting CDN or load balancer such as Cloudflare's CF-IPCountry
+    handlers.SetCountryHeader(os.Getenv("LOGIN_COUNTRY_HEADER"))
+    if cfg.ServeUI {
+        handlers.SetUI(ui.Handler())
+        if !ui.Built() {
+            logger.Warn("No dashboard build is embedded; / serves a placeholder page")
+        }
+    }
     api.SetupRoutes(r, handlers)
 
     // TLS configuration
@@ -98,77 +415,145 @@ func main() {
         },
     }
 
+    // Serve the admin API over TLS unless explicitly opted out
+    adminTLS, err := adminTLSFromEnv(ctx, tlsConfig, proxyServer)
+    if err != nil {
+        fatal("Invalid admin TLS configuration", "error", err)
+    }
+
+    // Bind the admin API where configured (all interfaces by default)
+    adminListener, adminAddr, err := openAdminListener(cfg.AdminAddr)
+    if err != nil {
+        fatal("Unable to listen on admin address", "addr", adminAddr, "error", err)
+    }
+    if adminTLS == nil && !isLocalOnly(adminListener) {
+        logger.Warn("Admin API is served over plain HTTP, which is reachable from other hosts", "addr", adminAddr)
+    }
+
+    // The gRPC management API, when enabled, shares the admin API's TLS
+    var grpcListener net.Listener
+    if cfg.GRPCAddr != "" {
+        grpcListener, err = net.Listen("tcp", cfg.GRPCAddr)
+        if err != nil {
+            fatal("Unable to listen on gRPC address", "addr", cfg.GRPCAddr, "error", err)
+        }
+        if adminTLS == nil && !isLocalOnly(grpcListener) {
+            logger.Warn("gRPC API is served without TLS, which is reachable from other hosts", "addr", grpcListener.Addr().String())
+        }
+    }
+
+    // With every port bound, root is no longer needed
+    if cfg.RunAsUser != "" {
+        if err := proxyServer.Bind(cfg.HTTPPort, cfg.HTTPSPort); err != nil {
+            fatal("Unable to bind proxy ports", "error", err)
+        }
+        if err := dropPrivileges(cfg.RunAsUser, cfg.StorageDir); err != nil {
+            fatal("Unable to drop privileges", "error", err)
+        }
+    }
+
     // Create admin server
     adminServer := &http.Server{
-        Addr:         ":8080",
         Handler:      r,
-        TLSConfig:    tlsConfig,
+        TLSConfig:    adminTLS,
         ReadTimeout:  5 * time.Second,
         WriteTimeout: 10 * time.Second,
         IdleTimeout:  120 * time.Second,
     }
 
+    var grpcServer *grpc.Server
+    if grpcListener != nil {
+        grpcServer = grpcapi.NewGRPCServer(r, adminTLS)
+        go func() {
+            logger.Info("gRPC server starting", "addr", grpcListener.Addr().String())
+            if err := grpcServer.Serve(grpcListener); err != nil {
+                logger.Error("gRPC server failed", "error", err)
+            }
+        }()
+    }
+
     // Create a WaitGroup to manage our servers
     var wg sync.WaitGroup
     wg.Add(2)
 
-    // Start admin server (8080)
+    // Start admin server
     go func() {
         defer wg.Done()
-        log.Println("Admin server starting on port 8080")
-        if err := adminServer.ListenAndServe(); err != http.ErrServerClosed {
-            log.Printf("Admin server error: %v", err)
+        var err error
+        if adminTLS != nil {
+            logger.Info("Admin server starting", "addr", adminAddr, "tls", true)
+            err = adminServer.ServeTLS(adminListener, "", "")
+        } else {
+            logger.Info("Admin server starting", "addr", adminAddr)
+            err = adminServer.Serve(adminListener)
+        }
+        if err != http.ErrServerClosed {
+            logger.Error("Admin server failed", "error", err)
         }
     }()
 
     // Start proxy server (80/443)
     go func() {
         defer wg.Done()
-        log.Println("Proxy server starting on ports 80 and 443")
-        log.Println("TCP proxy for Minecraft should also be starting on port 25565")
-        
-        // Debug DNS resolution
-        go func() {
-            time.Sleep(5 * time.Second) // Wait for everything to start
-            testDomains := []string{"mc.maxbrowser.win", "vc.maxbrowser.win"}
-            for _, domain := range testDomains {
-                ips, err := net.LookupIP(domain)
-                if err != nil {
-                    log.Printf("DNS lookup for %s failed: %v", domain, err)
-                } else {
-                    log.Printf("DNS lookup for %s succeeded: %v", domain, ips)
-                }
-            }
-        }()
-        
-        if err := proxyServer.Run(80, 443); err != nil {
-            log.Printf("Proxy server error: %v", err)
+        logger.Info("Proxy server starting", "http_port", cfg.HTTPPort, "https_port", cfg.HTTPSPort)
+
+        if err := proxyServer.Run(cfg.HTTPPort, cfg.HTTPSPort); err != nil {
+            logger.Error("Proxy server failed", "error", err)
         }
     }()
 
-    // Set up graceful shutdown
+    // Set up graceful shutdown, on signals or a Windows service stop
     stop := make(chan os.Signal, 1)
     signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+    serviceStopped := watchServiceManager(stop)
 
     go func() {
         <-stop
-        log.Println("Shutting down servers...")
+        logger.Info("Shutting down servers")
         
         // Cancel context to stop the loader
         cancel()
 
-		// Stop health checker
-		 healthChecker.Stop()
-		 
+        // Let running jobs, such as health checks, finish
+        scheduler.Wait()
+
+        // Stop webhook delivery
+        webhookDispatcher.Stop()
+
         // Create shutdown context with timeout
         shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
         defer shutdownCancel()
 
         // Shutdown admin server
         if err := adminServer.Shutdown(shutdownCtx); err != nil {
-            log.Printf("Admin server shutdown error: %v", err)
+            logger.Error("Admin server shutdown failed", "error", err)
         }
 
+        // Shutdown gRPC server, ending metric streams once the timeout passes
+        if grpcServer != nil {
+            stopped := make(chan struct{})
+            go func() {
+                grpcServer.GracefulStop()
+                close(stopped)
+            }()
+            select {
+            case <-stopped:
+            case <-shutdownCtx.Done():
+                grpcServer.Stop()
+            }
+        }
+
+        // Keep the traffic counted since the last periodic flush
+        proxyServer.Metrics().Flush()
+
+        // Leave the cluster rather than showing up as offline
+        if err := registry.Leave(shutdownCtx); err != nil {
+            logger.Error("Leaving cluster failed", "error", err)
+        }
+
+        // Deliver error reports still queued
+        reporting.Flush(shutdownCtx)
+
         // Signal WaitGroup that we're done
         wg.Done()
         wg.Done()
@@ -176,5 +561,17 @@ func main() {
 
     // Wait for clean shutdown
     wg.Wait()
-    log.Println("Servers shut down gracefully")
-}
\ No newline at end of file
+    logger.Info("Servers shut down gracefully")
+    serviceStopped()
+}
+
+// poolOptions sizes database pools from the configuration
+func poolOptions(cfg *config.Config) db.PoolOptions {
+    return db.PoolOptions{
+        MaxConns:        int32(cfg.DBMaxConns),
+        MinConns:        int32(cfg.DBMinConns),
+        MaxConnLifetime: time.Duration(cfg.DBMaxConnLifetime),
+        MaxConnIdleTime: time.Duration(cfg.DBMaxConnIdleTime),
+        SimpleProtocol:  cfg.DBSimpleProtocol,
+    }
+}
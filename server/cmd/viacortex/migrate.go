@@ -0,0 +1,94 @@
+package main
+
+import (
+    "context"
+    "flag"
+    "fmt"
+    "os"
+    "time"
+
+    "viacortex/internal/config"
+    "viacortex/internal/db"
+)
+
+const migrateUsage = `usage: viacortex migrate <command> [-config file] [-steps n]
+
+commands:
+  up       apply every pending migration
+  down     roll back applied migrations (-steps, default 1)
+  status   list migrations and when they were applied
+  version  print the current schema version
+`
+
+// runMigrate implements "viacortex migrate", which runs or rolls back schema
+// migrations without starting the servers. It returns the exit code.
+func runMigrate(args []string) int {
+    if len(args) == 0 {
+        fmt.Fprint(os.Stderr, migrateUsage)
+        return 2
+    }
+    command := args[0]
+
+    fs := flag.NewFlagSet("migrate "+command, flag.ContinueOnError)
+    steps := fs.Int("steps", 1, "number of migrations to roll back (down only)")
+    configPath := fs.String("config", os.Getenv("VIACORTEX_CONFIG"), "path to the YAML config file")
+    if err := fs.Parse(args[1:]); err != nil {
+        return 2
+    }
+
+    cfg, err := config.Load(*configPath)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        return 1
+    }
+
+    ctx := context.Background()
+    pool, err := db.Connect(ctx, cfg.DatabaseURL, poolOptions(cfg), time.Duration(cfg.DBConnectTimeout))
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Unable to connect to database: %v\n", err)
+        return 1
+    }
+    defer pool.Close()
+
+    m, err := db.NewMigrator(pool)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Invalid migrations: %v\n", err)
+        return 1
+    }
+
+    switch command {
+    case "up":
+        err = m.Up(ctx)
+    case "down":
+        if *steps < 1 {
+            fmt.Fprintln(os.Stderr, "-steps must be at least 1")
+            return 2
+        }
+        err = m.Down(ctx, *steps)
+    case "status":
+        var status []db.MigrationStatus
+        status, err = m.Status(ctx)
+        for _, s := range status {
+            applied := "pending"
+            if s.AppliedAt != nil {
+                applied = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05 MST")
+            }
+            fmt.Printf("%4d  %-32s %s\n", s.Version, s.Name, applied)
+        }
+    case "version":
+        var version int64
+        version, err = m.Version(ctx)
+        if err == nil {
+            fmt.Printf("%d (latest %d)\n", version, m.Latest())
+        }
+    default:
+        fmt.Fprint(os.Stderr, migrateUsage)
+        return 2
+    }
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "migrate %s: %v\n", command, err)
+        return 1
+    }
+    return 0
+}
+
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"viacortex/internal/auth"
+	"viacortex/internal/config"
+	"viacortex/internal/db"
+	"viacortex/internal/proxy"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// minJWTSecretLength is the shortest JWT_SECRET accepted when one is set
+const minJWTSecretLength = 32
+
+// acmeProbeTimeout bounds the request to the ACME directory
+const acmeProbeTimeout = 10 * time.Second
+
+// preflightCheck is one startup check. hint tells the operator how to fix
+// a failure.
+type preflightCheck struct {
+    name string
+    hint string
+    run  func(ctx context.Context) error
+}
+
+// preflight verifies the environment the server is about to run in and
+// reports every failed check with a hint, so misconfiguration stops startup
+// with one clear message instead of warnings scattered through the log
+func preflight(ctx context.Context, cfg *config.Config, pool *pgxpool.Pool, keyRing *auth.KeyRing) error {
+    checks := []preflightCheck{
+        {
+            name: "database schema",
+            hint: `run "viacortex migrate up" or enable auto_migrate`,
+            run: func(ctx context.Context) error {
+                m, err := db.NewMigrator(pool)
+                if err != nil {
+                    return err
+                }
+                return m.CheckCurrent(ctx)
+            },
+        },
+        {
+            name: "JWT signing keys",
+            hint: fmt.Sprintf("unset JWT_SECRET or set it to at least %d random bytes", minJWTSecretLength),
+            run: func(ctx context.Context) error {
+                return checkSigningKeys(ctx, keyRing)
+            },
+        },
+        {
+            name: "certificate storage",
+            hint: "make storage_dir writable by the server user",
+            run: func(ctx context.Context) error {
+                return checkWritableDir(cfg.StorageDir)
+            },
+        },
+        {
+            name: "listen ports",
+            hint: "stop the process holding the port, pick another port, or grant the binary CAP_NET_BIND_SERVICE for ports below 1024",
+            run: func(ctx context.Context) error {
+                return checkPorts(cfg)
+            },
+        },
+        {
+            name: "ACME reachability",
+            hint: "allow outbound HTTPS to the ACME CA, or disable SSL on the domains",
+            run: func(ctx context.Context) error {
+                return checkACME(ctx, pool)
+            },
+        },
+    }
+
+    var failures []string
+    for _, c := range checks {
+        if err := c.run(ctx); err != nil {
+            logger.Error("Preflight check failed", "check", c.name, "error", err)
+            failures = append(failures, fmt.Sprintf("%s: %v (%s)", c.name, err, c.hint))
+            continue
+        }
+        logger.Info("Preflight check passed", "check", c.name)
+    }
+    if len(failures) > 0 {
+        return fmt.Errorf("preflight failed:\n  - %s", strings.Join(failures, "\n  - "))
+    }
+    return nil
+}
+
+func checkSigningKeys(ctx context.Context, keyRing *auth.KeyRing) error {
+    if secret := os.Getenv("JWT_SECRET"); secret != "" && len(secret) < minJWTSecretLength {
+        return fmt.Errorf("JWT_SECRET is only %d bytes", len(secret))
+    }
+    keys, err := keyRing.Keys(ctx)
+    if err != nil {
+        return err
+    }
+    for _, k := range keys {
+        if k.Active {
+            return nil
+        }
+    }
+    return errors.New("no active signing key")
+}
+
+func checkWritableDir(dir string) error {
+    if err := os.MkdirAll(dir, 0700); err != nil {
+        return err
+    }
+    f, err := os.CreateTemp(dir, ".preflight-*")
+    if err != nil {
+        return err
+    }
+    name := f.Name()
+    f.Close()
+    return os.Remove(filepath.Clean(name))
+}
+
+// checkPorts binds every configured port briefly. The admin listener is
+// checked too unless it is a Unix socket, and so is the gRPC listener when
+// enabled.
+func checkPorts(cfg *config.Config) error {
+    addrs := []string{
+        fmt.Sprintf(":%d", cfg.HTTPPort),
+        fmt.Sprintf(":%d", cfg.HTTPSPort),
+    }
+    for _, port := range cfg.TCPPorts {
+        addrs = append(addrs, fmt.Sprintf(":%d", port))
+    }
+    if !strings.HasPrefix(cfg.AdminAddr, "unix:") {
+        addrs = append(addrs, cfg.AdminAddr)
+    }
+    if cfg.GRPCAddr != "" {
+        addrs = append(addrs, cfg.GRPCAddr)
+    }
+
+    var problems []string
+    for _, addr := range addrs {
+        ln, err := net.Listen("tcp", addr)
+        if err != nil {
+            problems = append(problems, err.Error())
+            continue
+        }
+        ln.Close()
+    }
+    if len(problems) > 0 {
+        return errors.New(strings.Join(problems, "; "))
+    }
+    return nil
+}
+
+// checkACME fetches the CA's directory. It only fails when a domain needs a
+// certificate, so servers without SSL domains can start offline.
+func checkACME(ctx context.Context, pool *pgxpool.Pool) error {
+    var sslDomains int
+    if err := pool.QueryRow(ctx, "SELECT COUNT(*) FROM domains WHERE ssl_enabled").Scan(&sslDomains); err != nil {
+        return err
+    }
+
+    reqCtx, cancel := context.WithTimeout(ctx, acmeProbeTimeout)
+    defer cancel()
+    req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, proxy.ACMEDirectory(), nil)
+    if err != nil {
+        return err
+    }
+    resp, err := http.DefaultClient.Do(req)
+    if err == nil {
+        resp.Body.Close()
+        if resp.StatusCode != http.StatusOK {
+            err = fmt.Errorf("%s returned %s", proxy.ACMEDirectory(), resp.Status)
+        }
+    }
+    if err != nil && sslDomains == 0 {
+        logger.Warn("ACME CA not reachable, ignored as no domain uses SSL", "error", err)
+        return nil
+    }
+    return err
+}
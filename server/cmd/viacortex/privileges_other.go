@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "fmt"
+
+// dropPrivileges is only supported on Unix; elsewhere run the service under
+// the intended account instead
+func dropPrivileges(name string, ownedDirs ...string) error {
+    return fmt.Errorf("run_as_user is not supported on this platform")
+}
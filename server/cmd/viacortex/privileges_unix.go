@@ -0,0 +1,79 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// dropPrivileges switches the process to the named user once the listeners
+// are bound. The directories the server writes to are handed over to the
+// user first, as they may have been created by root.
+func dropPrivileges(name string, ownedDirs ...string) error {
+    if os.Geteuid() != 0 {
+        return fmt.Errorf("run_as_user %q needs the server to start as root", name)
+    }
+
+    u, err := user.Lookup(name)
+    if err != nil {
+        return err
+    }
+    uid, err := strconv.Atoi(u.Uid)
+    if err != nil {
+        return fmt.Errorf("user %s has non-numeric uid %q", name, u.Uid)
+    }
+    gid, err := strconv.Atoi(u.Gid)
+    if err != nil {
+        return fmt.Errorf("user %s has non-numeric gid %q", name, u.Gid)
+    }
+    if uid == 0 {
+        return fmt.Errorf("run_as_user %q is root", name)
+    }
+
+    groups := []int{gid}
+    if ids, err := u.GroupIds(); err == nil {
+        for _, id := range ids {
+            if g, err := strconv.Atoi(id); err == nil && g != gid {
+                groups = append(groups, g)
+            }
+        }
+    }
+
+    for _, dir := range ownedDirs {
+        if err := chownTree(dir, uid, gid); err != nil {
+            return fmt.Errorf("handing %s to %s: %w", dir, name, err)
+        }
+    }
+
+    // The group must change while the process is still root. Since Go 1.16
+    // these apply to every thread of the process.
+    if err := syscall.Setgroups(groups); err != nil {
+        return fmt.Errorf("setgroups: %w", err)
+    }
+    if err := syscall.Setgid(gid); err != nil {
+        return fmt.Errorf("setgid: %w", err)
+    }
+    if err := syscall.Setuid(uid); err != nil {
+        return fmt.Errorf("setuid: %w", err)
+    }
+    // HOME still points at root's, which the user cannot read
+    os.Setenv("HOME", u.HomeDir)
+
+    logger.Info("Dropped privileges", "user", name, "uid", uid, "gid", gid)
+    return nil
+}
+
+func chownTree(root string, uid, gid int) error {
+    return filepath.WalkDir(root, func(path string, _ fs.DirEntry, err error) error {
+        if err != nil {
+            return err
+        }
+        return os.Lchown(path, uid, gid)
+    })
+}
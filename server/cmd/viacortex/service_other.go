@@ -0,0 +1,20 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// watchServiceManager is a no-op outside Windows: systemd and launchd stop
+// the server with SIGTERM
+func watchServiceManager(stop chan<- os.Signal) func() {
+    return func() {}
+}
+
+// runService explains where the service definitions for this platform are
+func runService(args []string) int {
+    fmt.Fprintln(os.Stderr, "viacortex service is only available on Windows; use the systemd unit or launchd plist under deploy/ instead")
+    return 2
+}
@@ -0,0 +1,106 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// serviceName is the name the server is registered under with the Windows
+// service manager
+const serviceName = "viacortex"
+
+// watchServiceManager lets the Windows service manager stop the server.
+// When the process runs as a service, stop requests are delivered on stop
+// like Ctrl+C; the returned function must be called once shutdown is
+// complete so the service reports as stopped.
+func watchServiceManager(stop chan<- os.Signal) func() {
+    isService, err := svc.IsWindowsService()
+    if err != nil || !isService {
+        return func() {}
+    }
+
+    h := &serviceHandler{stop: stop, done: make(chan struct{})}
+    go svc.Run(serviceName, h)
+    return func() { close(h.done) }
+}
+
+type serviceHandler struct {
+    stop chan<- os.Signal
+    done chan struct{}
+}
+
+func (h *serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+    status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+    for req := range requests {
+        switch req.Cmd {
+        case svc.Interrogate:
+            status <- req.CurrentStatus
+        case svc.Stop, svc.Shutdown:
+            status <- svc.Status{State: svc.StopPending}
+            h.stop <- os.Interrupt
+            <-h.done
+            return false, 0
+        }
+    }
+    return false, 0
+}
+
+const serviceUsage = `usage: viacortex service <install|uninstall> [server flags]
+
+install registers the server with the Windows service manager, starting
+automatically with the given flags, e.g. --config C:\ProgramData\viacortex\viacortex.yaml
+`
+
+// runService implements "viacortex service", which registers or removes
+// the Windows service. It returns the exit code.
+func runService(args []string) int {
+    if len(args) == 0 || (args[0] != "install" && args[0] != "uninstall") {
+        fmt.Fprint(os.Stderr, serviceUsage)
+        return 2
+    }
+
+    m, err := mgr.Connect()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Unable to connect to the service manager: %v\n", err)
+        return 1
+    }
+    defer m.Disconnect()
+
+    if args[0] == "install" {
+        exe, err := os.Executable()
+        if err != nil {
+            fmt.Fprintln(os.Stderr, err)
+            return 1
+        }
+        s, err := m.CreateService(serviceName, exe, mgr.Config{
+            DisplayName: "ViaCortex",
+            Description: "ViaCortex reverse proxy and admin API",
+            StartType:   mgr.StartAutomatic,
+        }, args[1:]...)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Unable to install service: %v\n", err)
+            return 1
+        }
+        s.Close()
+        fmt.Printf("Service %s installed\n", serviceName)
+        return 0
+    }
+
+    s, err := m.OpenService(serviceName)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Service %s is not installed: %v\n", serviceName, err)
+        return 1
+    }
+    defer s.Close()
+    if err := s.Delete(); err != nil {
+        fmt.Fprintf(os.Stderr, "Unable to remove service: %v\n", err)
+        return 1
+    }
+    fmt.Printf("Service %s removed\n", serviceName)
+    return 0
+}
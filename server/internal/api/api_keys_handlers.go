@@ -0,0 +1,243 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"viacortex/internal/auth"
+	"viacortex/internal/db"
+	"viacortex/internal/middleware"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v4"
+)
+
+// getAPIKeys returns the API keys owned by the current user
+func (h *Handlers) getAPIKeys(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    userID := getUserIDFromContext(ctx)
+
+    rows, err := h.db.Query(ctx, `
+        SELECT id, user_id, name, key_prefix, scopes, expires_at,
+               last_used_at, revoked_at, created_at, updated_at
+        FROM api_keys
+        WHERE user_id = $1
+        ORDER BY created_at DESC
+    `, userID)
+    if err != nil {
+        logger.Error("Fetching API keys failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch API keys")
+        return
+    }
+    defer rows.Close()
+
+    keys := []db.APIKey{}
+    for rows.Next() {
+        var k db.APIKey
+        err := rows.Scan(
+            &k.ID, &k.UserID, &k.Name, &k.KeyPrefix, &k.Scopes, &k.ExpiresAt,
+            &k.LastUsedAt, &k.RevokedAt, &k.CreatedAt, &k.UpdatedAt,
+        )
+        if err != nil {
+            logger.Error("Scanning API key failed", "error", err)
+            continue
+        }
+        keys = append(keys, k)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(keys)
+}
+
+// createAPIKey issues a new API key for the current user. The raw key is
+// returned once and only its hash is stored.
+func (h *Handlers) createAPIKey(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    userID := getUserIDFromContext(ctx)
+
+    var req struct {
+        Name      string     `json:"name"`
+        Scopes    []string   `json:"scopes"`
+        ExpiresAt *time.Time `json:"expires_at"`
+    }
+
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+
+    req.Name = strings.TrimSpace(req.Name)
+    if req.Name == "" {
+        writeError(w, r, http.StatusBadRequest, "Name is required")
+        return
+    }
+    if len(req.Scopes) == 0 {
+        writeError(w, r, http.StatusBadRequest, "At least one scope is required")
+        return
+    }
+    for _, scope := range req.Scopes {
+        if !middleware.IsValidScope(scope) {
+            writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid scope %q", scope))
+            return
+        }
+    }
+    if req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()) {
+        writeError(w, r, http.StatusBadRequest, "Expiry must be in the future")
+        return
+    }
+
+    rawKey, prefix, hash, err := auth.GenerateAPIKey()
+    if err != nil {
+        logger.Error("Generating API key failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+
+    var key db.APIKey
+    err = h.db.QueryRow(ctx, `
+        INSERT INTO api_keys (user_id, name, key_prefix, key_hash, scopes, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING id, user_id, name, key_prefix, scopes, expires_at, created_at, updated_at
+    `, userID, req.Name, prefix, hash, req.Scopes, req.ExpiresAt).Scan(
+        &key.ID, &key.UserID, &key.Name, &key.KeyPrefix, &key.Scopes,
+        &key.ExpiresAt, &key.CreatedAt, &key.UpdatedAt,
+    )
+    if err != nil {
+        logger.Error("Creating API key failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to create API key")
+        return
+    }
+
+    // Record audit log
+    if err := h.recordAudit(ctx, userID, "create", "api_key", key.ID, nil, key); err != nil {
+        logger.Error("Recording audit failed", "error", err)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "key":     rawKey,
+        "api_key": key,
+        "message": "Store this key now, it will not be shown again",
+    })
+}
+
+// revokeAPIKey revokes an API key. Admins may revoke any key, other users
+// only their own.
+func (h *Handlers) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    keyID := chi.URLParam(r, "keyID")
+    userID := getUserIDFromContext(ctx)
+    isAdmin := middleware.GetRoleFromContext(ctx) == middleware.RoleAdmin
+
+    result, err := h.db.Exec(ctx, `
+        UPDATE api_keys
+        SET revoked_at = CURRENT_TIMESTAMP
+        WHERE id = $1 AND revoked_at IS NULL AND (user_id = $2 OR $3::boolean)
+    `, keyID, userID, isAdmin)
+    if err != nil {
+        logger.Error("Revoking API key failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to revoke API key")
+        return
+    }
+
+    if rowsAffected := result.RowsAffected(); rowsAffected == 0 {
+        writeError(w, r, http.StatusNotFound, "API key not found")
+        return
+    }
+
+    // Record audit log
+    after, _ := snapshotEntity(ctx, h.db, "api_keys", keyID)
+    before := map[string]interface{}{}
+    for field, v := range after {
+        before[field] = v
+    }
+    before["revoked_at"] = nil
+    if err := h.recordAudit(ctx, userID, "revoke", "api_key",
+        mustParseInt64(keyID), before, after); err != nil {
+        logger.Error("Recording audit failed", "error", err)
+    }
+
+    w.WriteHeader(http.StatusOK)
+    json.NewEncoder(w).Encode(map[string]string{
+        "message": "API key revoked successfully",
+    })
+}
+
+// SetDevAuth accepts rawKey as a local development API key acting as the
+// first active admin with every scope
+func (h *Handlers) SetDevAuth(rawKey string) {
+    h.devKeyHash = auth.HashAPIKey(rawKey)
+}
+
+// devPrincipal resolves the development key to the first active admin
+func (h *Handlers) devPrincipal(ctx context.Context) (*middleware.APIKeyPrincipal, error) {
+    p := middleware.APIKeyPrincipal{Scopes: middleware.AllScopes()}
+    err := h.db.QueryRow(ctx, `
+        SELECT id, email, role FROM users
+        WHERE role = $1 AND active = true
+        ORDER BY id
+        LIMIT 1
+    `, middleware.RoleAdmin).Scan(&p.UserID, &p.Email, &p.Role)
+    if err == pgx.ErrNoRows {
+        return nil, fmt.Errorf("development key used before an admin exists")
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Warn("Request authenticated with the development auth key", "email", p.Email)
+    return &p, nil
+}
+
+// lookupAPIKey resolves a raw API key for the auth middleware. Revoked and
+// expired keys, and keys of deactivated users, are rejected.
+func (h *Handlers) lookupAPIKey(ctx context.Context, rawKey string) (*middleware.APIKeyPrincipal, error) {
+    if h.devKeyHash != "" && subtle.ConstantTimeCompare([]byte(auth.HashAPIKey(rawKey)), []byte(h.devKeyHash)) == 1 {
+        return h.devPrincipal(ctx)
+    }
+
+    var p middleware.APIKeyPrincipal
+    var expiresAt, revokedAt *time.Time
+    var active bool
+
+    err := h.db.QueryRow(ctx, `
+        SELECT k.id, k.user_id, u.email, u.role, u.active, k.scopes,
+               k.expires_at, k.revoked_at
+        FROM api_keys k
+        JOIN users u ON u.id = k.user_id
+        WHERE k.key_hash = $1
+    `, auth.HashAPIKey(rawKey)).Scan(
+        &p.KeyID, &p.UserID, &p.Email, &p.Role, &active, &p.Scopes,
+        &expiresAt, &revokedAt,
+    )
+    if err == pgx.ErrNoRows {
+        return nil, fmt.Errorf("unknown api key")
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    if revokedAt != nil {
+        return nil, fmt.Errorf("api key %d is revoked", p.KeyID)
+    }
+    if expiresAt != nil && expiresAt.Before(time.Now()) {
+        return nil, fmt.Errorf("api key %d has expired", p.KeyID)
+    }
+    if !active {
+        return nil, fmt.Errorf("owner of api key %d is deactivated", p.KeyID)
+    }
+
+    if _, err := h.db.Exec(ctx,
+        "UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP WHERE id = $1", p.KeyID,
+    ); err != nil {
+        logger.Error("Updating API key last use failed", "error", err)
+    }
+
+    return &p, nil
+}
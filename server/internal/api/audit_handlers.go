@@ -2,35 +2,107 @@ package api
 
 import (
 	"context"
+	"encoding/csv"
 	"encoding/json"
-	"log"
+	"net"
 	"net/http"
+	"reflect"
 	"strconv"
 	"time"
 
+	"viacortex/internal/audit"
+	"viacortex/internal/db"
+	"viacortex/internal/proxy"
+
 	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+    defaultAuditPageSize = 100
+    maxAuditPageSize     = 1000
 )
 
-// getAuditLogs returns all audit logs with filtering options
+// auditLogRow is one audit log entry as returned by getAuditLogs
+type auditLogRow struct {
+    ID         int64           `json:"id"`
+    UserID     int64           `json:"user_id"`
+    UserEmail  string          `json:"user_email"`
+    Action     string          `json:"action"`
+    EntityType string          `json:"entity_type"`
+    EntityID   int64           `json:"entity_id"`
+    OrgID      int64           `json:"org_id,omitempty"`
+    Changes    json.RawMessage `json:"changes"`
+    IPAddress  string          `json:"ip_address"`
+    UserAgent  string          `json:"user_agent"`
+    Timestamp  time.Time       `json:"timestamp"`
+}
+
+// parseAuditTime accepts RFC 3339 timestamps or plain dates. A plain date
+// used as the upper bound includes that whole day.
+func parseAuditTime(value string, upper bool) (time.Time, error) {
+    if t, err := time.Parse(time.RFC3339, value); err == nil {
+        return t, nil
+    }
+    t, err := time.Parse("2006-01-02", value)
+    if err != nil {
+        return time.Time{}, err
+    }
+    if upper {
+        t = t.AddDate(0, 0, 1)
+    }
+    return t, nil
+}
+
+// getAuditLogs returns audit logs with filtering options, newest first.
+//
+// Query parameters:
+//   - entity_type, action, user_id, org_id: exact match filters
+//   - from, to: time range, RFC 3339 or YYYY-MM-DD (to is exclusive)
+//   - limit, before_id: page size and cursor; the next page is linked in the
+//     Link header
+//   - format: json (default), csv or ndjson. Exports return every matching
+//     entry unless limit is given.
 func (h *Handlers) getAuditLogs(w http.ResponseWriter, r *http.Request) {
     ctx := r.Context()
-    
+    q := r.URL.Query()
+
+    format := q.Get("format")
+    if format == "" {
+        format = "json"
+    }
+    if format != "json" && format != "csv" && format != "ndjson" {
+        writeError(w, r, http.StatusBadRequest, "format must be json, csv or ndjson")
+        return
+    }
+    export := format != "json"
+
     // Parse query parameters
-    limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
-    if limit == 0 {
-        limit = 100 // Default limit
+    limit := 0
+    if v := q.Get("limit"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n < 1 {
+            writeError(w, r, http.StatusBadRequest, "Invalid limit")
+            return
+        }
+        limit = n
     }
-    
-    entityType := r.URL.Query().Get("entity_type")
-    action := r.URL.Query().Get("action")
-    userID := r.URL.Query().Get("user_id")
-    
+    if !export {
+        if limit == 0 {
+            limit = defaultAuditPageSize
+        }
+        if limit > maxAuditPageSize {
+            limit = maxAuditPageSize
+        }
+    }
+
     // Build query with filters
     query := `
         SELECT 
-            al.id, al.user_id, u.email as user_email,
-            al.action, al.entity_type, al.entity_id,
-            al.changes, al.timestamp
+            al.id, al.user_id, COALESCE(u.email, '') as user_email,
+            al.action, COALESCE(al.entity_type, ''), COALESCE(al.entity_id, 0),
+            COALESCE(al.org_id, 0), al.changes, COALESCE(host(al.ip_address), ''),
+            COALESCE(al.user_agent, ''), al.timestamp
         FROM audit_logs al
         LEFT JOIN users u ON al.user_id = u.id
         WHERE 1=1
@@ -38,74 +110,161 @@ func (h *Handlers) getAuditLogs(w http.ResponseWriter, r *http.Request) {
     args := []interface{}{}
     argCount := 1
 
-    if entityType != "" {
-        query += ` AND al.entity_type = $` + strconv.Itoa(argCount)
-        args = append(args, entityType)
+    for _, filter := range []struct{ param, column string }{
+        {"entity_type", "al.entity_type"},
+        {"action", "al.action"},
+        {"user_id", "al.user_id"},
+        {"org_id", "al.org_id"},
+    } {
+        if v := q.Get(filter.param); v != "" {
+            query += ` AND ` + filter.column + ` = $` + strconv.Itoa(argCount)
+            args = append(args, v)
+            argCount++
+        }
+    }
+
+    // Within an organization only that organization's trail is shown
+    if org := selectedOrg(ctx); org.ID != 0 {
+        query += ` AND al.org_id = $` + strconv.Itoa(argCount)
+        args = append(args, org.ID)
         argCount++
     }
-    
-    if action != "" {
-        query += ` AND al.action = $` + strconv.Itoa(argCount)
-        args = append(args, action)
+
+    if v := q.Get("from"); v != "" {
+        from, err := parseAuditTime(v, false)
+        if err != nil {
+            writeError(w, r, http.StatusBadRequest, "Invalid from, use RFC 3339 or YYYY-MM-DD")
+            return
+        }
+        query += ` AND al.timestamp >= $` + strconv.Itoa(argCount)
+        args = append(args, from)
         argCount++
     }
-    
-    if userID != "" {
-        query += ` AND al.user_id = $` + strconv.Itoa(argCount)
-        args = append(args, userID)
+
+    if v := q.Get("to"); v != "" {
+        to, err := parseAuditTime(v, true)
+        if err != nil {
+            writeError(w, r, http.StatusBadRequest, "Invalid to, use RFC 3339 or YYYY-MM-DD")
+            return
+        }
+        query += ` AND al.timestamp < $` + strconv.Itoa(argCount)
+        args = append(args, to)
         argCount++
     }
-    
-    query += ` ORDER BY al.timestamp DESC LIMIT $` + strconv.Itoa(argCount)
-    args = append(args, limit)
+
+    if v := q.Get("before_id"); v != "" {
+        beforeID, err := strconv.ParseInt(v, 10, 64)
+        if err != nil {
+            writeError(w, r, http.StatusBadRequest, "Invalid before_id")
+            return
+        }
+        query += ` AND al.id < $` + strconv.Itoa(argCount)
+        args = append(args, beforeID)
+        argCount++
+    }
+
+    // Ids grow with time and, unlike timestamps, are unique, so they make a
+    // stable cursor
+    query += ` ORDER BY al.id DESC`
+    if limit > 0 {
+        query += ` LIMIT $` + strconv.Itoa(argCount)
+        args = append(args, limit)
+    }
 
     rows, err := h.db.Query(ctx, query, args...)
     if err != nil {
-        log.Printf("Error fetching audit logs: %v", err)
-        http.Error(w, "Failed to fetch audit logs", http.StatusInternalServerError)
+        logger.Error("Fetching audit logs failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch audit logs")
         return
     }
     defer rows.Close()
 
-    logs := []map[string]interface{}{}
-    for rows.Next() {
-        var l struct {
-            ID          int64           `json:"id"`
-            UserID      int64           `json:"user_id"`
-            UserEmail   string          `json:"user_email"`
-            Action      string          `json:"action"`
-            EntityType  string          `json:"entity_type"`
-            EntityID    int64           `json:"entity_id"`
-            Changes     json.RawMessage `json:"changes"`
-            Timestamp   time.Time       `json:"timestamp"`
-        }
-        
-        err := rows.Scan(
-            &l.ID, &l.UserID, &l.UserEmail,
-            &l.Action, &l.EntityType, &l.EntityID,
-            &l.Changes, &l.Timestamp,
-        )
-        if err != nil {
-            log.Printf("Error scanning audit log: %v", err)
-            continue
+    next := func() (auditLogRow, bool) {
+        for rows.Next() {
+            var l auditLogRow
+            err := rows.Scan(
+                &l.ID, &l.UserID, &l.UserEmail,
+                &l.Action, &l.EntityType, &l.EntityID,
+                &l.OrgID, &l.Changes, &l.IPAddress, &l.UserAgent, &l.Timestamp,
+            )
+            if err != nil {
+                logger.Error("Scanning audit log failed", "error", err)
+                continue
+            }
+            return l, true
         }
-        
-        logs = append(logs, map[string]interface{}{
-            "id":           l.ID,
-            "user_id":      l.UserID,
-            "user_email":   l.UserEmail,
-            "action":       l.Action,
-            "entity_type":  l.EntityType,
-            "entity_id":    l.EntityID,
-            "changes":      l.Changes,
-            "timestamp":    l.Timestamp,
-        })
+        return auditLogRow{}, false
+    }
+
+    if export {
+        writeAuditExport(w, r, format, next)
+        return
+    }
+
+    logs := []auditLogRow{}
+    for l, ok := next(); ok; l, ok = next() {
+        logs = append(logs, l)
+    }
+
+    if len(logs) == limit {
+        nextQuery := r.URL.Query()
+        nextQuery.Set("before_id", strconv.FormatInt(logs[len(logs)-1].ID, 10))
+        w.Header().Set("Link", "<"+r.URL.Path+"?"+nextQuery.Encode()+`>; rel="next"`)
     }
 
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(logs)
 }
 
+// writeAuditExport streams audit log entries as a CSV or NDJSON download
+func writeAuditExport(w http.ResponseWriter, r *http.Request, format string, next func() (auditLogRow, bool)) {
+    // Large exports can outlast the admin server's write timeout
+    if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(5 * time.Minute)); err != nil {
+        logger.Warn("Could not extend write deadline for audit export", "error", err)
+    }
+
+    filename := "audit-logs-" + time.Now().UTC().Format("20060102-150405") + "." + format
+    w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+    if format == "ndjson" {
+        w.Header().Set("Content-Type", "application/x-ndjson")
+        enc := json.NewEncoder(w)
+        for l, ok := next(); ok; l, ok = next() {
+            if err := enc.Encode(l); err != nil {
+                logger.Error("Writing audit export failed", "error", err)
+                return
+            }
+        }
+        return
+    }
+
+    w.Header().Set("Content-Type", "text/csv")
+    cw := csv.NewWriter(w)
+    cw.Write([]string{
+        "id", "timestamp", "user_id", "user_email", "action",
+        "entity_type", "entity_id", "ip_address", "user_agent", "changes", "org_id",
+    })
+    for l, ok := next(); ok; l, ok = next() {
+        cw.Write([]string{
+            strconv.FormatInt(l.ID, 10),
+            l.Timestamp.UTC().Format(time.RFC3339Nano),
+            strconv.FormatInt(l.UserID, 10),
+            l.UserEmail,
+            l.Action,
+            l.EntityType,
+            strconv.FormatInt(l.EntityID, 10),
+            l.IPAddress,
+            l.UserAgent,
+            string(l.Changes),
+            strconv.FormatInt(l.OrgID, 10),
+        })
+    }
+    cw.Flush()
+    if err := cw.Error(); err != nil {
+        logger.Error("Writing audit export failed", "error", err)
+    }
+}
+
 // getEntityAuditLogs returns audit logs for a specific entity
 func (h *Handlers) getEntityAuditLogs(w http.ResponseWriter, r *http.Request) {
     ctx := r.Context()
@@ -121,7 +280,8 @@ func (h *Handlers) getEntityAuditLogs(w http.ResponseWriter, r *http.Request) {
     rows, err := h.db.Query(ctx, `
         SELECT 
             al.id, al.user_id, u.email as user_email,
-            al.action, al.changes, al.timestamp
+            al.action, al.changes, COALESCE(host(al.ip_address), ''),
+            COALESCE(al.user_agent, ''), al.timestamp
         FROM audit_logs al
         LEFT JOIN users u ON al.user_id = u.id
         WHERE al.entity_type = $1 AND al.entity_id = $2
@@ -130,8 +290,8 @@ func (h *Handlers) getEntityAuditLogs(w http.ResponseWriter, r *http.Request) {
     `, entityType, entityID, limit)
     
     if err != nil {
-        log.Printf("Error fetching entity audit logs: %v", err)
-        http.Error(w, "Failed to fetch audit logs", http.StatusInternalServerError)
+        logger.Error("Fetching entity audit logs failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch audit logs")
         return
     }
     defer rows.Close()
@@ -144,15 +304,17 @@ func (h *Handlers) getEntityAuditLogs(w http.ResponseWriter, r *http.Request) {
             UserEmail   string          `json:"user_email"`
             Action      string          `json:"action"`
             Changes     json.RawMessage `json:"changes"`
+            IPAddress   string          `json:"ip_address"`
+            UserAgent   string          `json:"user_agent"`
             Timestamp   time.Time       `json:"timestamp"`
         }
         
         err := rows.Scan(
             &l.ID, &l.UserID, &l.UserEmail,
-            &l.Action, &l.Changes, &l.Timestamp,
+            &l.Action, &l.Changes, &l.IPAddress, &l.UserAgent, &l.Timestamp,
         )
         if err != nil {
-            log.Printf("Error scanning entity audit log: %v", err)
+            logger.Error("Scanning entity audit log failed", "error", err)
             continue
         }
         
@@ -162,6 +324,8 @@ func (h *Handlers) getEntityAuditLogs(w http.ResponseWriter, r *http.Request) {
             "user_email":   l.UserEmail,
             "action":       l.Action,
             "changes":      l.Changes,
+            "ip_address":   l.IPAddress,
+            "user_agent":   l.UserAgent,
             "timestamp":    l.Timestamp,
         })
     }
@@ -170,17 +334,214 @@ func (h *Handlers) getEntityAuditLogs(w http.ResponseWriter, r *http.Request) {
     json.NewEncoder(w).Encode(logs)
 }
 
-// Helper function to record an audit log entry
-func (h *Handlers) recordAudit(ctx context.Context, userID int64, action, entityType string, entityID int64, changes interface{}) error {
+// verifyAuditLogs walks the audit log hash chain and reports the first entry
+// that was modified or whose predecessor was removed
+func (h *Handlers) verifyAuditLogs(w http.ResponseWriter, r *http.Request) {
+    result, err := audit.Verify(r.Context(), h.db)
+    if err != nil {
+        logger.Error("Verifying audit logs failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to verify audit logs")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(result)
+}
+
+// getAuditArchives lists the batches removed by the retention job
+func (h *Handlers) getAuditArchives(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+
+    rows, err := h.db.Query(ctx, `
+        SELECT id, first_id, last_id, row_count, last_hash, location, created_at
+        FROM audit_log_archives
+        ORDER BY last_id DESC
+    `)
+    if err != nil {
+        logger.Error("Fetching audit archives failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch audit archives")
+        return
+    }
+    defer rows.Close()
+
+    archives := []db.AuditLogArchive{}
+    for rows.Next() {
+        var a db.AuditLogArchive
+        err := rows.Scan(&a.ID, &a.FirstID, &a.LastID, &a.RowCount, &a.LastHash, &a.Location, &a.CreatedAt)
+        if err != nil {
+            logger.Error("Scanning audit archive failed", "error", err)
+            continue
+        }
+        archives = append(archives, a)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(archives)
+}
+
+// auditQuerier is satisfied by both the pool and a transaction so audit
+// entries can be written inside the transaction that made the change
+type auditQuerier interface {
+    QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// auditRequestKey carries the client address and user agent of the request
+// being audited
+type auditRequestKey struct{}
+
+type auditRequest struct {
+    IP        string
+    UserAgent string
+}
+
+// withAuditRequest stores the client IP and user agent in the request
+// context for recordAudit. RemoteAddr has already been rewritten by RealIP.
+func withAuditRequest(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        ip := r.RemoteAddr
+        if host, _, err := net.SplitHostPort(ip); err == nil {
+            ip = host
+        }
+        if net.ParseIP(ip) == nil {
+            ip = ""
+        }
+        info := auditRequest{IP: ip, UserAgent: r.UserAgent()}
+        next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), auditRequestKey{}, info)))
+    })
+}
+
+// auditFieldChange is the before and after value of one changed field
+type auditFieldChange struct {
+    Old interface{} `json:"old"`
+    New interface{} `json:"new"`
+}
+
+// auditChanges is the structured payload stored in audit_logs.changes. Old
+// is nil for creations and New is nil for deletions; Diff lists the top
+// level fields that differ.
+type auditChanges struct {
+    Old  interface{}                 `json:"old"`
+    New  interface{}                 `json:"new"`
+    Diff map[string]auditFieldChange `json:"diff"`
+}
+
+// redactedAuditFields never appear in audit entries
+var redactedAuditFields = map[string]bool{
+    "password":      true,
+    "password_hash": true,
+    "secret":        true,
+    "key_hash":      true,
+}
+
+// auditValue normalizes v into plain JSON values with secrets removed
+func auditValue(v interface{}) (interface{}, error) {
+    if v == nil {
+        return nil, nil
+    }
+    data, err := json.Marshal(v)
+    if err != nil {
+        return nil, err
+    }
+    var out interface{}
+    if err := json.Unmarshal(data, &out); err != nil {
+        return nil, err
+    }
+    if m, ok := out.(map[string]interface{}); ok {
+        for field := range m {
+            if redactedAuditFields[field] {
+                delete(m, field)
+            }
+        }
+    }
+    return out, nil
+}
+
+// buildAuditChanges diffs before and after into an auditChanges payload
+func buildAuditChanges(before, after interface{}) (*auditChanges, error) {
+    oldVal, err := auditValue(before)
+    if err != nil {
+        return nil, err
+    }
+    newVal, err := auditValue(after)
+    if err != nil {
+        return nil, err
+    }
+
+    changes := &auditChanges{Old: oldVal, New: newVal, Diff: map[string]auditFieldChange{}}
+    oldMap, _ := oldVal.(map[string]interface{})
+    newMap, _ := newVal.(map[string]interface{})
+    for field, v := range newMap {
+        if old, ok := oldMap[field]; !ok || !reflect.DeepEqual(old, v) {
+            changes.Diff[field] = auditFieldChange{Old: oldMap[field], New: v}
+        }
+    }
+    for field, old := range oldMap {
+        if _, ok := newMap[field]; !ok {
+            changes.Diff[field] = auditFieldChange{Old: old}
+        }
+    }
+    return changes, nil
+}
+
+// snapshotEntity returns a row of table as a JSON object, for use as the
+// before or after state of an audit entry. pgx.ErrNoRows means the row does
+// not exist.
+func snapshotEntity(ctx context.Context, q auditQuerier, table string, id interface{}) (map[string]interface{}, error) {
+    var snapshot map[string]interface{}
+    err := q.QueryRow(ctx, `SELECT row_to_json(t) FROM `+table+` t WHERE id = $1`, id).Scan(&snapshot)
+    if err != nil {
+        return nil, err
+    }
+    return snapshot, nil
+}
+
+// recordAudit writes an audit entry with the old and new state of an entity
+func (h *Handlers) recordAudit(ctx context.Context, userID int64, action, entityType string, entityID int64, before, after interface{}) error {
+    if err := writeAudit(ctx, h.db, userID, action, entityType, entityID, before, after); err != nil {
+        return err
+    }
+    h.publishAudit(userID, action, entityType, entityID)
+    return nil
+}
+
+// publishAudit announces a user action on the live event stream. Callers
+// that write the audit entry inside a transaction call it after commit.
+func (h *Handlers) publishAudit(userID int64, action, entityType string, entityID int64) {
+    event := map[string]interface{}{
+        "user_id":     userID,
+        "action":      action,
+        "entity_type": entityType,
+        "entity_id":   entityID,
+    }
+    h.events.Publish("audit."+entityType+"."+action, event)
+
+    // Every audited action is a configuration change; reloads are cheap and
+    // merged, so the nodes need not know which ones affect routing
+    if h.bus != nil {
+        data, _ := json.Marshal(event)
+        if err := h.bus.Publish(proxy.SubjectConfigChanged, data); err != nil {
+            logger.Error("Publishing config change failed", "error", err)
+        }
+    }
+}
+
+// writeAudit writes an audit entry through q, which may be a transaction
+func writeAudit(ctx context.Context, q auditQuerier, userID int64, action, entityType string, entityID int64, before, after interface{}) error {
+    changes, err := buildAuditChanges(before, after)
+    if err != nil {
+        return err
+    }
     changesJSON, err := json.Marshal(changes)
     if err != nil {
         return err
     }
 
-    _, err = h.db.Exec(ctx, `
-        INSERT INTO audit_logs (user_id, action, entity_type, entity_id, changes)
-        VALUES ($1, $2, $3, $4, $5)
-    `, userID, action, entityType, entityID, changesJSON)
+    info, _ := ctx.Value(auditRequestKey{}).(auditRequest)
 
-    return err
-}
\ No newline at end of file
+    var id int64
+    return q.QueryRow(ctx, `
+        INSERT INTO audit_logs (user_id, action, entity_type, entity_id, changes, ip_address, user_agent, org_id)
+        VALUES ($1, $2, $3, $4, $5, NULLIF($6, '')::inet, NULLIF($7, ''), NULLIF($8::integer, 0))
+        RETURNING id
+    `, userID, action, entityType, entityID, changesJSON, info.IP, info.UserAgent, auditOrg(ctx)).Scan(&id)
+}
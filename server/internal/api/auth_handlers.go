@@ -4,12 +4,15 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"viacortex/internal/auth"
 	"viacortex/internal/db"
+	"viacortex/internal/middleware"
+	"viacortex/internal/webhooks"
 
 	"github.com/jackc/pgx/v4"
 	"golang.org/x/crypto/bcrypt"
@@ -21,51 +24,76 @@ type loginRequest struct {
 }
 
 type registerRequest struct {
-    Email    string `json:"email"`
-    Password string `json:"password"`
-    Role     string `json:"role"`
+    Email          string `json:"email"`
+    Password       string `json:"password"`
+    Role           string `json:"role"`
+    BootstrapToken string `json:"bootstrap_token"`
 }
 
+// SetBootstrap guards registration: the first account, which becomes admin,
+// needs the bootstrap token and later ones need open registration. Without
+// it only admins can create accounts.
+func (h *Handlers) SetBootstrap(b *auth.Bootstrap) {
+    h.bootstrap = b
+}
+
+// registrationRole is the role of accounts created by open registration
+const registrationRole = middleware.RoleUser
 
 func (h *Handlers) handleRegister(w http.ResponseWriter, r *http.Request) {
     ctx := r.Context()
 
     var req registerRequest
     if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-        log.Printf("Error decoding request: %v", err)
-        http.Error(w, "Invalid request", http.StatusBadRequest)
+        logger.Error("Decoding request failed", "error", err)
+        writeError(w, r, http.StatusBadRequest, "Invalid request")
         return
     }
 
-    // if users are = 0, then we need to create an admin user
-    var count int
-    err := h.db.QueryRow(ctx, "SELECT COUNT(*) FROM users").Scan(&count)
-    if err != nil {
-        log.Printf("Error checking users: %v", err)
-        http.Error(w, "Server error", http.StatusInternalServerError)
+    if h.bootstrap == nil {
+        writeError(w, r, http.StatusForbidden, "Registration is disabled")
         return
     }
-    if count == 0 {
-        req.Role = "admin"
-    }
-    // Validate role
-    if req.Role == "" {
-        req.Role = "user" // Default role
-    }
-    if !isValidRole(req.Role) {
-        http.Error(w, "Invalid role", http.StatusBadRequest)
+    if req.Email == "" || len(req.Password) < minPasswordLength {
+        writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Email and a password of at least %d characters are required", minPasswordLength))
         return
     }
 
     // Start transaction
     tx, err := h.db.Begin(ctx)
     if err != nil {
-        log.Printf("Error starting transaction: %v", err)
-        http.Error(w, "Server error", http.StatusInternalServerError)
+        logger.Error("Starting transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
         return
     }
     defer tx.Rollback(ctx)
 
+    // The first account becomes admin, but only with the bootstrap token
+    first, err := h.bootstrap.Claim(ctx, tx, req.BootstrapToken)
+    if err == auth.ErrBootstrapToken {
+        writeError(w, r, http.StatusForbidden, "A valid bootstrap token is required to create the first admin")
+        return
+    }
+    if err != nil {
+        logger.Error("Checking users failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    if !first && !h.bootstrap.OpenRegistration {
+        writeError(w, r, http.StatusForbidden, "Registration is disabled")
+        return
+    }
+
+    role := registrationRole
+    if first {
+        role = middleware.RoleAdmin
+    }
+    if req.Role != "" && req.Role != role {
+        writeError(w, r, http.StatusForbidden, "Only admins can assign roles")
+        return
+    }
+    req.Role = role
+
     // Check if email already exists
     var exists bool
     err = tx.QueryRow(ctx, 
@@ -74,20 +102,20 @@ func (h *Handlers) handleRegister(w http.ResponseWriter, r *http.Request) {
     ).Scan(&exists)
     
     if err != nil {
-        log.Printf("Error checking email existence: %v", err)
-        http.Error(w, "Server error", http.StatusInternalServerError)
+        logger.Error("Checking email existence failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
         return
     }
     if exists {
-        http.Error(w, "Email already exists", http.StatusConflict)
+        writeError(w, r, http.StatusConflict, "Email already exists")
         return
     }
 
     // Hash password
     hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
     if err != nil {
-        log.Printf("Error hashing password: %v", err)
-        http.Error(w, "Server error", http.StatusInternalServerError)
+        logger.Error("Hashing password failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
         return
     }
 
@@ -105,39 +133,26 @@ func (h *Handlers) handleRegister(w http.ResponseWriter, r *http.Request) {
     `, req.Email, string(hashedPassword), req.Role).Scan(&userID)
 
     if err != nil {
-        log.Printf("Error inserting user: %v", err)
-        http.Error(w, "Server error", http.StatusInternalServerError)
+        logger.Error("Inserting user failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
         return
     }
 
     // Add audit log
-    changes := map[string]interface{}{
-        "email": req.Email,
-        "role":  req.Role,
-    }
-    changesJSON, _ := json.Marshal(changes)
-    
-    _, err = tx.Exec(ctx, `
-        INSERT INTO audit_logs (
-            user_id, 
-            action, 
-            entity_type, 
-            entity_id, 
-            changes
-        ) VALUES ($1, $2, $3, $4, $5)
-    `, userID, "register", "user", userID, changesJSON)
-
-    if err != nil {
-        log.Printf("Error creating audit log: %v", err)
+    after, _ := snapshotEntity(ctx, tx, "users", userID)
+    if err := writeAudit(ctx, tx, userID, "register", "user", userID, nil, after); err != nil {
+        logger.Error("Creating audit log failed", "error", err)
     }
 
     // Commit transaction
     if err := tx.Commit(ctx); err != nil {
-        log.Printf("Error committing transaction: %v", err)
-        http.Error(w, "Server error", http.StatusInternalServerError)
+        logger.Error("Committing transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
         return
     }
 
+    h.publishAudit(userID, "register", "user", userID)
+
     // Get the created user's data
     var user db.User
     var nullableName sql.NullString
@@ -152,8 +167,8 @@ func (h *Handlers) handleRegister(w http.ResponseWriter, r *http.Request) {
     )
 
     if err != nil {
-        log.Printf("Error fetching created user: %v", err)
-        http.Error(w, "Server error", http.StatusInternalServerError)
+        logger.Error("Fetching created user failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
         return
     }
 
@@ -165,10 +180,10 @@ func (h *Handlers) handleRegister(w http.ResponseWriter, r *http.Request) {
     }
 
     // Generate tokens
-    tokens, err := auth.GenerateTokenPair(fmt.Sprintf("%d", userID), req.Email, req.Role)
+    tokens, err := h.issueTokens(ctx, userID, req.Email, req.Role)
     if err != nil {
-        log.Printf("Error generating tokens: %v", err)
-        http.Error(w, "Failed to generate tokens", http.StatusInternalServerError)
+        logger.Error("Generating tokens failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to generate tokens")
         return
     }
 
@@ -181,6 +196,7 @@ func (h *Handlers) handleRegister(w http.ResponseWriter, r *http.Request) {
             "role": user.Role,
             "active": user.Active,
             "name": user.Name,
+            "permissions": middleware.RolePermissions(user.Role),
         },
     }
 
@@ -198,85 +214,104 @@ func (h *Handlers) handleLogin(w http.ResponseWriter, r *http.Request) {
 
     var req loginRequest
     if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-        http.Error(w, "Invalid request", http.StatusBadRequest)
+        writeError(w, r, http.StatusBadRequest, "Invalid request")
+        return
+    }
+
+    // Directory users log in with their directory password; anyone else
+    // falls through to local accounts when fallback is enabled
+    if h.directory != nil && h.directoryLogin(w, r, req) {
         return
     }
 
     // Start transaction
     tx, err := h.db.Begin(ctx)
     if err != nil {
-        log.Printf("Error starting transaction: %v", err)
-        http.Error(w, "Server error", http.StatusInternalServerError)
+        logger.Error("Starting transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
         return
     }
     defer tx.Rollback(ctx)
 
     var user db.User
     var nullableName sql.NullString
+    var lockedUntil sql.NullTime
+    var webauthnRequired bool
 
     err = tx.QueryRow(ctx, `
-        SELECT id, email, password_hash, role, active, name 
+        SELECT id, email, password_hash, role, active, name, locked_until, webauthn_required
         FROM users 
         WHERE email = $1
-    `, req.Email).Scan(&user.ID, &user.Email, &user.Password, &user.Role, &user.Active, &nullableName)
+        FOR UPDATE
+    `, req.Email).Scan(&user.ID, &user.Email, &user.Password, &user.Role, &user.Active, &nullableName, &lockedUntil, &webauthnRequired)
 
     if err == pgx.ErrNoRows {
-        http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+        h.emitLoginFailed(r, req.Email, "unknown_user")
+        writeError(w, r, http.StatusUnauthorized, "Invalid credentials")
         return
     }
     if err != nil {
-        log.Printf("Error querying user: %v", err)
-        http.Error(w, "Server error", http.StatusInternalServerError)
+        logger.Error("Querying user failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
         return
     }
 
     // Check if user is active
     if !user.Active {
-        http.Error(w, "Account is deactivated", http.StatusForbidden)
+        h.emitLoginFailed(r, req.Email, "deactivated")
+        writeError(w, r, http.StatusForbidden, "Account is deactivated")
+        return
+    }
+
+    // Refuse logins while the account is locked out
+    if lockedUntil.Valid && lockedUntil.Time.After(time.Now()) {
+        h.emitLoginFailed(r, req.Email, "locked")
+        writeLockedOut(w, r, lockedUntil.Time)
         return
     }
 
     // Verify password
     if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
-        http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+        h.limits.failedLogins.Add(1)
+        h.emitLoginFailed(r, req.Email, "invalid_password")
+        if h.recordFailedLogin(w, r, tx, user.ID) {
+            return
+        }
+        writeError(w, r, http.StatusUnauthorized, "Invalid credentials")
+        return
+    }
+
+    // Users who require a security key get a WebAuthn challenge instead of
+    // tokens; the login completes at /webauthn/login/finish
+    if webauthnRequired {
+        h.beginSecondFactor(w, r, tx, user.ID)
         return
     }
 
-    // Update last login time
+    // Update last login time and clear any failed attempts
     _, err = tx.Exec(ctx, `
         UPDATE users 
-        SET last_login = CURRENT_TIMESTAMP 
+        SET last_login = CURRENT_TIMESTAMP, failed_login_count = 0, locked_until = NULL
         WHERE id = $1
     `, user.ID)
     
     if err != nil {
-        log.Printf("Error updating last login: %v", err)
+        logger.Error("Updating last login failed", "error", err)
     }
 
     // Add audit log
-    changes := map[string]string{"action": "login"}
-    changesJSON, _ := json.Marshal(changes)
-    
-    _, err = tx.Exec(ctx, `
-        INSERT INTO audit_logs (
-            user_id, 
-            action, 
-            entity_type, 
-            entity_id, 
-            changes
-        ) VALUES ($1, $2, $3, $4, $5)
-    `, user.ID, "login", "user", user.ID, changesJSON)
-
-    if err != nil {
-        log.Printf("Error creating audit log: %v", err)
+    if err := writeAudit(ctx, tx, user.ID, "login", "user", user.ID, nil, nil); err != nil {
+        logger.Error("Creating audit log failed", "error", err)
     }
 
     // Commit transaction
     if err := tx.Commit(ctx); err != nil {
-        log.Printf("Error committing transaction: %v", err)
-        http.Error(w, "Server error", http.StatusInternalServerError)
+        logger.Error("Committing transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
         return
     }
+
+    h.publishAudit(user.ID, "login", "user", user.ID)
     
     // After the scan, set the name
     if nullableName.Valid {
@@ -285,10 +320,14 @@ func (h *Handlers) handleLogin(w http.ResponseWriter, r *http.Request) {
         user.Name = "" // Set empty string if NULL
     }
 
-    // Generate tokens
-    tokens, err := auth.GenerateTokenPair(fmt.Sprintf("%d", user.ID), user.Email, user.Role)
+    h.writeLoginResponse(w, r, user)
+}
+
+// writeLoginResponse issues a token pair for a user who has just logged in
+func (h *Handlers) writeLoginResponse(w http.ResponseWriter, r *http.Request, user db.User) {
+    tokens, err := h.loginTokens(r, user)
     if err != nil {
-        http.Error(w, "Failed to generate tokens", http.StatusInternalServerError)
+        writeError(w, r, http.StatusInternalServerError, "Failed to generate tokens")
         return
     }
 
@@ -301,6 +340,7 @@ func (h *Handlers) handleLogin(w http.ResponseWriter, r *http.Request) {
             "role": user.Role,
             "active": user.Active,
             "name": user.Name,
+            "permissions": middleware.RolePermissions(user.Role),
         },
     }
 
@@ -313,47 +353,107 @@ func (h *Handlers) handleLogin(w http.ResponseWriter, r *http.Request) {
     json.NewEncoder(w).Encode(response)
 }
 
+// loginTokens generates the token pair for a completed login and announces
+// the login to webhooks
+func (h *Handlers) loginTokens(r *http.Request, user db.User) (*auth.TokenPair, error) {
+    // Generate tokens
+    tokens, err := h.issueTokens(r.Context(), user.ID, user.Email, user.Role)
+    if err != nil {
+        return nil, err
+    }
+
+    h.recordLogin(r, user, tokens.SessionID)
+
+    h.webhooks.Emit(webhooks.EventUserLogin, map[string]interface{}{
+        "user_id":   user.ID,
+        "email":     user.Email,
+        "client_ip": r.RemoteAddr,
+    })
+    return tokens, nil
+}
+
 func (h *Handlers) handleRefresh(w http.ResponseWriter, r *http.Request) {
     ctx := r.Context()
     
     refreshToken := r.Header.Get("X-Refresh-Token")
     if refreshToken == "" {
-        http.Error(w, "Refresh token required", http.StatusBadRequest)
+        writeError(w, r, http.StatusBadRequest, "Refresh token required")
         return
     }
 
     // Validate refresh token
     claims, err := auth.ValidateToken(refreshToken)
     if err != nil {
-        http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+        writeError(w, r, http.StatusUnauthorized, "Invalid refresh token")
         return
     }
 
     // Verify user still exists and is active
     var active bool
+    var tokensValidAfter sql.NullTime
     err = h.db.QueryRow(ctx, `
-        SELECT active FROM users WHERE id = $1
-    `, claims.UserID).Scan(&active)
+        SELECT active, tokens_valid_after FROM users WHERE id = $1
+    `, claims.UserID).Scan(&active, &tokensValidAfter)
 
     if err == pgx.ErrNoRows {
-        http.Error(w, "User not found", http.StatusUnauthorized)
+        writeError(w, r, http.StatusUnauthorized, "User not found")
         return
     }
     if err != nil {
-        log.Printf("Error querying user: %v", err)
-        http.Error(w, "Server error", http.StatusInternalServerError)
+        logger.Error("Querying user failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
         return
     }
 
     if !active {
-        http.Error(w, "Account is deactivated", http.StatusForbidden)
-        return
+        writeError(w, r, http.StatusForbidden, "Account is deactivated")
+        return
+    }
+
+    // Tokens issued before the last password change are revoked
+    if tokensValidAfter.Valid && (claims.IssuedAt == nil || claims.IssuedAt.Time.Before(tokensValidAfter.Time)) {
+        writeError(w, r, http.StatusUnauthorized, "Refresh token has been revoked")
+        return
+    }
+
+    // Sessions that were logged out or revoked cannot be refreshed; using
+    // one keeps it alive for another refresh token lifetime
+    sessionID := claims.SessionID
+    if sessionID != "" {
+        info, _ := ctx.Value(auditRequestKey{}).(auditRequest)
+        tag, err := h.db.Exec(ctx, `
+            UPDATE sessions
+            SET last_used_at = CURRENT_TIMESTAMP, expires_at = $3,
+                user_agent = NULLIF($4, ''), ip_address = NULLIF($5, '')::inet
+            WHERE id = $1 AND user_id = $2 AND expires_at > CURRENT_TIMESTAMP
+        `, sessionID, claims.UserID, time.Now().Add(auth.RefreshTokenTTL), info.UserAgent, info.IP)
+        if err != nil {
+            logger.Error("Updating session failed", "error", err)
+            writeError(w, r, http.StatusInternalServerError, "Server error")
+            return
+        }
+        if tag.RowsAffected() == 0 {
+            writeError(w, r, http.StatusUnauthorized, "Session has been revoked")
+            return
+        }
+    } else {
+        // Tokens issued before sessions existed join a new one
+        userID, err := strconv.ParseInt(claims.UserID, 10, 64)
+        if err != nil {
+            writeError(w, r, http.StatusUnauthorized, "Invalid refresh token")
+            return
+        }
+        if sessionID, err = h.newSession(ctx, userID); err != nil {
+            logger.Error("Creating session failed", "error", err)
+            writeError(w, r, http.StatusInternalServerError, "Server error")
+            return
+        }
     }
 
     // Generate new token pair
-    tokens, err := auth.GenerateTokenPair(claims.UserID, claims.Email, claims.Role)
+    tokens, err := auth.GenerateSessionTokenPair(claims.UserID, claims.Email, claims.Role, sessionID)
     if err != nil {
-        http.Error(w, "Failed to generate tokens", http.StatusInternalServerError)
+        writeError(w, r, http.StatusInternalServerError, "Failed to generate tokens")
         return
     }
 
@@ -366,19 +466,19 @@ func (h *Handlers) verifyToken(w http.ResponseWriter, r *http.Request) {
     
     authHeader := r.Header.Get("Authorization")
     if authHeader == "" {
-        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        writeError(w, r, http.StatusUnauthorized, "Unauthorized")
         return
     }
 
     tokenParts := strings.Split(authHeader, " ")
     if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
-        http.Error(w, "Invalid authorization header", http.StatusUnauthorized)
+        writeError(w, r, http.StatusUnauthorized, "Invalid authorization header")
         return
     }
 
     claims, err := auth.ValidateToken(tokenParts[1])
     if err != nil {
-        http.Error(w, "Invalid token", http.StatusUnauthorized)
+        writeError(w, r, http.StatusUnauthorized, "Invalid token")
         return
     }
 
@@ -396,8 +496,8 @@ func (h *Handlers) verifyToken(w http.ResponseWriter, r *http.Request) {
     )
 
     if err != nil {
-        log.Printf("Error fetching user: %v", err)
-        http.Error(w, "User not found", http.StatusUnauthorized)
+        logger.Error("Fetching user failed", "error", err)
+        writeError(w, r, http.StatusUnauthorized, "User not found")
         return
     }
 
@@ -416,6 +516,7 @@ func (h *Handlers) verifyToken(w http.ResponseWriter, r *http.Request) {
             "role": user.Role,
             "active": user.Active,
             "name": user.Name,
+            "permissions": middleware.RolePermissions(user.Role),
         },
     }
 
@@ -434,13 +535,17 @@ func (h *Handlers) checkUsers(w http.ResponseWriter, r *http.Request) {
     var count int
     err := h.db.QueryRow(ctx, "SELECT COUNT(*) FROM users").Scan(&count)
     if err != nil {
-        log.Printf("Error checking users: %v", err)
-        http.Error(w, "Server error", http.StatusInternalServerError)
+        logger.Error("Checking users failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
         return
     }
 
     w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(map[string]int{"count": count})
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "count":              count,
+        "bootstrap_required": count == 0,
+        "registration_open":  h.bootstrap != nil && (count == 0 || h.bootstrap.OpenRegistration),
+    })
 }
 
 func (h *Handlers) handleVerify(w http.ResponseWriter, r *http.Request) {
@@ -449,20 +554,20 @@ func (h *Handlers) handleVerify(w http.ResponseWriter, r *http.Request) {
     // Get token from Authorization header
     authHeader := r.Header.Get("Authorization")
     if authHeader == "" {
-        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        writeError(w, r, http.StatusUnauthorized, "Unauthorized")
         return
     }
 
     tokenParts := strings.Split(authHeader, " ")
     if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
-        http.Error(w, "Invalid authorization header", http.StatusUnauthorized)
+        writeError(w, r, http.StatusUnauthorized, "Invalid authorization header")
         return
     }
 
     // Validate token
     claims, err := auth.ValidateToken(tokenParts[1])
     if err != nil {
-        http.Error(w, "Invalid token", http.StatusUnauthorized)
+        writeError(w, r, http.StatusUnauthorized, "Invalid token")
         return
     }
 
@@ -480,8 +585,8 @@ func (h *Handlers) handleVerify(w http.ResponseWriter, r *http.Request) {
     )
 
     if err != nil {
-        log.Printf("Error fetching user: %v", err)
-        http.Error(w, "User not found", http.StatusNotFound)
+        logger.Error("Fetching user failed", "error", err)
+        writeError(w, r, http.StatusNotFound, "User not found")
         return
     }
 
@@ -499,6 +604,7 @@ func (h *Handlers) handleVerify(w http.ResponseWriter, r *http.Request) {
             "role": user.Role,
             "active": user.Active,
             "name": user.Name,
+            "permissions": middleware.RolePermissions(user.Role),
         },
     }
 
@@ -508,4 +614,68 @@ func (h *Handlers) handleVerify(w http.ResponseWriter, r *http.Request) {
 
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(response)
+}
+
+// emitLoginFailed notifies webhooks about a rejected login attempt
+// recordFailedLogin counts a wrong password against the user and locks the
+// account once maxFailedLogins is reached. It commits tx itself and reports
+// whether it already wrote the response.
+func (h *Handlers) recordFailedLogin(w http.ResponseWriter, r *http.Request, tx pgx.Tx, userID int64) bool {
+    ctx := r.Context()
+
+    var failed int
+    var lockedUntil sql.NullTime
+    err := tx.QueryRow(ctx, `
+        UPDATE users
+        SET failed_login_count = CASE WHEN failed_login_count + 1 >= $2 THEN 0 ELSE failed_login_count + 1 END,
+            locked_until = CASE WHEN failed_login_count + 1 >= $2
+                THEN CURRENT_TIMESTAMP + make_interval(secs => $3) ELSE locked_until END
+        WHERE id = $1
+        RETURNING failed_login_count, locked_until
+    `, userID, maxFailedLogins, loginLockout.Seconds()).Scan(&failed, &lockedUntil)
+    if err != nil {
+        logger.Error("Recording failed login failed", "error", err)
+        return false
+    }
+
+    locked := failed == 0 && lockedUntil.Valid
+    if locked {
+        // Record audit log
+        if err := writeAudit(ctx, tx, userID, "lockout", "user", userID, nil, map[string]interface{}{
+            "locked_until": lockedUntil.Time,
+            "client_ip":    r.RemoteAddr,
+        }); err != nil {
+            logger.Error("Creating audit log failed", "error", err)
+        }
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        logger.Error("Committing transaction failed", "error", err)
+        return false
+    }
+    if !locked {
+        return false
+    }
+
+    h.limits.lockouts.Add(1)
+    h.publishAudit(userID, "lockout", "user", userID)
+    logger.Warn("Locked user after failed logins", "user_id", userID, "failed_logins", maxFailedLogins)
+    writeLockedOut(w, r, lockedUntil.Time)
+    return true
+}
+
+// writeLockedOut tells the client when a locked account can log in again
+func writeLockedOut(w http.ResponseWriter, r *http.Request, until time.Time) {
+    seconds := int(time.Until(until).Seconds()) + 1
+    w.Header().Set("Retry-After", strconv.Itoa(seconds))
+    writeError(w, r, http.StatusTooManyRequests, "Too many failed login attempts; try again later")
+}
+
+func (h *Handlers) emitLoginFailed(r *http.Request, email, reason string) {
+    h.webhooks.Emit(webhooks.EventUserLoginFailed, map[string]interface{}{
+        "email":      email,
+        "reason":     reason,
+        "client_ip":  r.RemoteAddr,
+        "user_agent": r.UserAgent(),
+    })
 }
\ No newline at end of file
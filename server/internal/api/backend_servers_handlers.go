@@ -2,7 +2,6 @@ package api
 
 import (
 	"encoding/json"
-	"log"
 	"net/http"
 	"strconv"
 
@@ -17,8 +16,8 @@ func (h *Handlers) getBackendServers(w http.ResponseWriter, r *http.Request) {
     domainID := chi.URLParam(r, "id")
 	domainIDInt, err := strconv.Atoi(domainID)
 	if err != nil {
-		log.Printf("Invalid domain ID: %v", err)
-		http.Error(w, "Invalid domain ID", http.StatusBadRequest)
+		logger.Debug("Invalid domain ID", "error", err)
+		writeError(w, r, http.StatusBadRequest, "Invalid domain ID")
 		return
 	}
     rows, err := h.db.Query(ctx, `
@@ -32,8 +31,8 @@ func (h *Handlers) getBackendServers(w http.ResponseWriter, r *http.Request) {
 
     
     if err != nil {
-        log.Printf("Error fetching backend servers: %v", err)
-        http.Error(w, "Failed to fetch backend servers", http.StatusInternalServerError)
+        logger.Error("Fetching backend servers failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch backend servers")
         return
     }
     defer rows.Close()
@@ -48,7 +47,7 @@ func (h *Handlers) getBackendServers(w http.ResponseWriter, r *http.Request) {
             &server.CreatedAt, &server.UpdatedAt,
         )
         if err != nil {
-            log.Printf("Error scanning backend server: %v", err)
+            logger.Error("Scanning backend server failed", "error", err)
             continue
         }
         servers = append(servers, server)
@@ -65,21 +64,39 @@ func (h *Handlers) addBackendServer(w http.ResponseWriter, r *http.Request) {
 
     var server db.BackendServer
     if err := json.NewDecoder(r.Body).Decode(&server); err != nil {
-        http.Error(w, "Invalid request body", http.StatusBadRequest)
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
         return
     }
 
     // Validate server Scheme, IP, Port and weight
     if server.Scheme == "" || server.IP.String() == "" || server.Port == 0 {
-		http.Error(w, "Invalid server details", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "Invalid server details")
 		return
 	}
     if server.Weight < 1 {
         server.Weight = 1 // Set default weight if invalid
     }
 
+    // The quota is checked and the server inserted in one transaction
+    tx, err := h.db.Begin(ctx)
+    if err != nil {
+        logger.Error("Starting transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    defer tx.Rollback(ctx)
+
+    owner, err := domainTenant(ctx, tx, mustParseInt64(domainID))
+    if err == nil {
+        err = h.checkQuota(ctx, tx, owner, quotaBackends, 1)
+    }
+    if err != nil {
+        writeQuotaError(w, r, err)
+        return
+    }
+
     var serverID int64
-    err := h.db.QueryRow(ctx, `
+    err = tx.QueryRow(ctx, `
 		INSERT INTO backend_servers (domain_id, scheme, ip, port, weight, is_active)
 		VALUES ($1, $2, $3, $4, $5, $6)
 		RETURNING id
@@ -87,15 +104,22 @@ func (h *Handlers) addBackendServer(w http.ResponseWriter, r *http.Request) {
 
 
     if err != nil {
-        log.Printf("Error creating backend server: %v", err)
-        http.Error(w, "Failed to create backend server", http.StatusInternalServerError)
+        logger.Error("Creating backend server failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to create backend server")
+        return
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        logger.Error("Committing transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to create backend server")
         return
     }
 
     // Record audit log
     userID := getUserIDFromContext(ctx)
-    if err := h.recordAudit(ctx, userID, "create", "backend_server", serverID, server); err != nil {
-        log.Printf("Error recording audit: %v", err)
+    after, _ := snapshotEntity(ctx, h.db, "backend_servers", serverID)
+    if err := h.recordAudit(ctx, userID, "create", "backend_server", serverID, nil, after); err != nil {
+        logger.Error("Recording audit failed", "error", err)
     }
 
     w.WriteHeader(http.StatusCreated)
@@ -112,13 +136,13 @@ func (h *Handlers) updateBackendServer(w http.ResponseWriter, r *http.Request) {
 
     var server db.BackendServer
     if err := json.NewDecoder(r.Body).Decode(&server); err != nil {
-        http.Error(w, "Invalid request body", http.StatusBadRequest)
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
         return
     }
 
     // Validate server scheme, IP, port and weight
 	if server.Scheme == "" || server.IP.String() == "" || server.Port == 0 {
-		http.Error(w, "Invalid server details", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "Invalid server details")
 		return
 	}
     if server.Weight < 1 {
@@ -126,43 +150,35 @@ func (h *Handlers) updateBackendServer(w http.ResponseWriter, r *http.Request) {
     }
 
     // Get old values for audit log
-    var oldServer db.BackendServer
-    err := h.db.QueryRow(ctx, `
-        SELECT scheme, ip, port, weight, is_active, health_status
-		FROM backend_servers WHERE id = $1
-	`, serverID).Scan(&oldServer.Scheme, &oldServer.IP, &oldServer.Port, &oldServer.Weight, &oldServer.IsActive, &oldServer.HealthStatus)
-
+    before, err := snapshotEntity(ctx, h.db, "backend_servers", serverID)
     if err != nil {
-        log.Printf("Error fetching backend server: %v", err)
-        http.Error(w, "Backend server not found", http.StatusNotFound)
+        logger.Error("Fetching backend server failed", "error", err)
+        writeError(w, r, http.StatusNotFound, "Backend server not found")
         return
     }
 
     result, err := h.db.Exec(ctx, `
         UPDATE backend_servers 
         SET scheme = $1, ip = $2, port = $3, weight = $4, is_active = $5
-		WHERE id = $6
-	`, server.Scheme, server.IP.String(), server.Port, server.Weight, server.IsActive, serverID)
+		WHERE id = $6 AND domain_id = $7
+	`, server.Scheme, server.IP.String(), server.Port, server.Weight, server.IsActive, serverID, chi.URLParam(r, "id"))
     if err != nil {
-        log.Printf("Error updating backend server: %v", err)
-        http.Error(w, "Failed to update backend server", http.StatusInternalServerError)
+        logger.Error("Updating backend server failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to update backend server")
         return
     }
 
     if rowsAffected := result.RowsAffected(); rowsAffected == 0 {
-        http.Error(w, "Backend server not found", http.StatusNotFound)
+        writeError(w, r, http.StatusNotFound, "Backend server not found")
         return
     }
 
     // Record audit log
     userID := getUserIDFromContext(ctx)
-    changes := map[string]interface{}{
-        "old": oldServer,
-        "new": server,
-    }
-    if err := h.recordAudit(ctx, userID, "update", "backend_server", 
-        mustParseInt64(serverID), changes); err != nil {
-        log.Printf("Error recording audit: %v", err)
+    after, _ := snapshotEntity(ctx, h.db, "backend_servers", serverID)
+    if err := h.recordAudit(ctx, userID, "update", "backend_server",
+        mustParseInt64(serverID), before, after); err != nil {
+        logger.Error("Recording audit failed", "error", err)
     }
 
     w.WriteHeader(http.StatusOK)
@@ -177,34 +193,30 @@ func (h *Handlers) deleteBackendServer(w http.ResponseWriter, r *http.Request) {
     serverID := chi.URLParam(r, "serverID")
 
     // Get server details for audit log before deletion
-    var oldServer db.BackendServer
-    err := h.db.QueryRow(ctx, `
-        SELECT scheme, ip, port, weight, is_active, health_status
-		FROM backend_servers WHERE id = $1
-	`, serverID).Scan(&oldServer.Scheme, &oldServer.IP, &oldServer.Port, &oldServer.Weight, &oldServer.IsActive, &oldServer.HealthStatus)
+    before, err := snapshotEntity(ctx, h.db, "backend_servers", serverID)
     if err != nil {
-        log.Printf("Error fetching backend server: %v", err)
-        http.Error(w, "Backend server not found", http.StatusNotFound)
+        logger.Error("Fetching backend server failed", "error", err)
+        writeError(w, r, http.StatusNotFound, "Backend server not found")
         return
     }
 
-    result, err := h.db.Exec(ctx, "DELETE FROM backend_servers WHERE id = $1", serverID)
+    result, err := h.db.Exec(ctx, "DELETE FROM backend_servers WHERE id = $1 AND domain_id = $2", serverID, chi.URLParam(r, "id"))
     if err != nil {
-        log.Printf("Error deleting backend server: %v", err)
-        http.Error(w, "Failed to delete backend server", http.StatusInternalServerError)
+        logger.Error("Deleting backend server failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to delete backend server")
         return
     }
 
     if rowsAffected := result.RowsAffected(); rowsAffected == 0 {
-        http.Error(w, "Backend server not found", http.StatusNotFound)
+        writeError(w, r, http.StatusNotFound, "Backend server not found")
         return
     }
 
     // Record audit log
     userID := getUserIDFromContext(ctx)
-    if err := h.recordAudit(ctx, userID, "delete", "backend_server", 
-        mustParseInt64(serverID), oldServer); err != nil {
-        log.Printf("Error recording audit: %v", err)
+    if err := h.recordAudit(ctx, userID, "delete", "backend_server",
+        mustParseInt64(serverID), before, nil); err != nil {
+        logger.Error("Recording audit failed", "error", err)
     }
 
     w.WriteHeader(http.StatusOK)
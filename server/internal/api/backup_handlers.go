@@ -0,0 +1,199 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"viacortex/internal/backup"
+	"viacortex/internal/db"
+	"viacortex/internal/proxy"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// maxBackupSize bounds the size of an uploaded backup archive
+const maxBackupSize = 100 << 20
+
+// backupPassphraseHeader carries the passphrase of an uploaded archive, as
+// the body is the archive itself
+const backupPassphraseHeader = "X-Backup-Passphrase"
+
+// createBackup downloads an encrypted archive of the server configuration
+func (h *Handlers) createBackup(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+
+    var req struct {
+        Passphrase string `json:"passphrase"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+    if len(req.Passphrase) < backup.MinPassphraseLength {
+        writeError(w, r, http.StatusBadRequest,
+            fmt.Sprintf("Passphrase must be at least %d characters", backup.MinPassphraseLength))
+        return
+    }
+
+    migrator, err := db.NewMigrator(h.db)
+    if err != nil {
+        logger.Error("Loading migrations failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to create backup")
+        return
+    }
+    version, err := migrator.Version(ctx)
+    if err != nil {
+        logger.Error("Reading schema version failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to create backup")
+        return
+    }
+
+    // A single snapshot keeps the tables consistent with each other
+    tx, err := h.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+    if err != nil {
+        logger.Error("Starting transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    defer tx.Rollback(ctx)
+
+    archive := &backup.Archive{CreatedAt: time.Now().UTC(), SchemaVersion: version}
+    if archive.Tables, err = backup.Dump(ctx, tx); err != nil {
+        logger.Error("Dumping configuration failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to create backup")
+        return
+    }
+
+    if h.proxy != nil {
+        certificates := []proxy.CertificateStatus{}
+        rows, err := tx.Query(ctx, "SELECT name FROM domains WHERE ssl_enabled ORDER BY name")
+        if err != nil {
+            logger.Error("Fetching domains failed", "error", err)
+            writeError(w, r, http.StatusInternalServerError, "Failed to create backup")
+            return
+        }
+        var names []string
+        for rows.Next() {
+            var name string
+            if err := rows.Scan(&name); err == nil {
+                names = append(names, name)
+            }
+        }
+        rows.Close()
+        for _, name := range names {
+            certificates = append(certificates, h.proxy.CertificateStatus(ctx, proxy.HostKey(name)))
+        }
+        archive.Certificates = certificates
+    }
+
+    sealed, err := backup.Seal(archive, req.Passphrase)
+    if err != nil {
+        logger.Error("Encrypting backup failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to create backup")
+        return
+    }
+
+    // Record audit log
+    userID := getUserIDFromContext(ctx)
+    if err := h.recordAudit(ctx, userID, "backup", "config", 0, nil, map[string]interface{}{
+        "schema_version": version,
+        "size":           len(sealed),
+    }); err != nil {
+        logger.Error("Recording audit failed", "error", err)
+    }
+
+    w.Header().Set("Content-Type", "application/octet-stream")
+    w.Header().Set("Content-Disposition",
+        fmt.Sprintf(`attachment; filename="viacortex-backup-%s.vcbak"`, archive.CreatedAt.Format("20060102-150405")))
+    w.Write(sealed)
+}
+
+// restoreBackup replaces the server configuration with the content of an
+// archive made by createBackup. The archive is the request body and its
+// passphrase is sent in the X-Backup-Passphrase header. With dry_run=true
+// the restore is computed and rolled back.
+func (h *Handlers) restoreBackup(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    dryRun := r.URL.Query().Get("dry_run") == "true"
+
+    data, err := io.ReadAll(io.LimitReader(r.Body, maxBackupSize))
+    if err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+
+    archive, err := backup.Open(data, r.Header.Get(backupPassphraseHeader))
+    if err != nil {
+        if errors.Is(err, backup.ErrBadPassphrase) {
+            writeError(w, r, http.StatusUnauthorized, "Wrong passphrase or corrupted backup")
+            return
+        }
+        writeError(w, r, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    migrator, err := db.NewMigrator(h.db)
+    if err != nil {
+        logger.Error("Loading migrations failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to restore backup")
+        return
+    }
+    version, err := migrator.Version(ctx)
+    if err != nil {
+        logger.Error("Reading schema version failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to restore backup")
+        return
+    }
+    // Older archives are fine: missing columns take their defaults
+    if archive.SchemaVersion > version {
+        writeError(w, r, http.StatusConflict, fmt.Sprintf(
+            "Backup was made with schema version %d, this server is at %d; upgrade it first",
+            archive.SchemaVersion, version))
+        return
+    }
+
+    tx, err := h.db.Begin(ctx)
+    if err != nil {
+        logger.Error("Starting transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    defer tx.Rollback(ctx)
+
+    summary, err := backup.Restore(ctx, tx, archive.Tables)
+    if err != nil {
+        logger.Error("Restoring backup failed", "error", err)
+        writeError(w, r, http.StatusConflict, "Failed to restore backup: "+err.Error())
+        return
+    }
+
+    if !dryRun {
+        if err := tx.Commit(ctx); err != nil {
+            logger.Error("Committing transaction failed", "error", err)
+            writeError(w, r, http.StatusInternalServerError, "Server error")
+            return
+        }
+
+        // Record audit log
+        userID := getUserIDFromContext(ctx)
+        if err := h.recordAudit(ctx, userID, "restore", "config", 0, nil, map[string]interface{}{
+            "backup_created_at": archive.CreatedAt,
+            "schema_version":    archive.SchemaVersion,
+            "summary":           summary,
+        }); err != nil {
+            logger.Error("Recording audit failed", "error", err)
+        }
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "dry_run":           dryRun,
+        "backup_created_at": archive.CreatedAt,
+        "schema_version":    archive.SchemaVersion,
+        "summary":           summary,
+    })
+}
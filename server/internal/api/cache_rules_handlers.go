@@ -0,0 +1,150 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"viacortex/internal/db"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxCacheRules bounds the rules tried on each response of a domain
+const maxCacheRules = 50
+
+// loadDomainCacheRules returns a domain's cache rules in the order they are
+// tried
+func loadDomainCacheRules(ctx context.Context, q sessionQuerier, domainID int64) ([]db.DomainCacheRule, error) {
+    rows, err := q.Query(ctx, `
+        SELECT id, position, path_prefix, content_type, cache_control, override
+        FROM domain_cache_rules
+        WHERE domain_id = $1
+        ORDER BY position, id
+    `, domainID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    rules := []db.DomainCacheRule{}
+    for rows.Next() {
+        rule := db.DomainCacheRule{DomainID: domainID}
+        if err := rows.Scan(&rule.ID, &rule.Position, &rule.PathPrefix, &rule.ContentType,
+            &rule.CacheControl, &rule.Override); err != nil {
+            return nil, err
+        }
+        rules = append(rules, rule)
+    }
+    return rules, rows.Err()
+}
+
+// validateCacheRule returns a message for the client when a rule is invalid
+func validateCacheRule(rule db.DomainCacheRule) string {
+    switch {
+    case rule.PathPrefix == nil && rule.ContentType == nil:
+        return "Each rule needs a path_prefix, a content_type or both"
+    case rule.PathPrefix != nil && !strings.HasPrefix(*rule.PathPrefix, "/"):
+        return "path_prefix must start with /"
+    case rule.PathPrefix != nil && len(*rule.PathPrefix) > 255:
+        return "path_prefix must be at most 255 characters"
+    case rule.ContentType != nil && (*rule.ContentType == "" || len(*rule.ContentType) > 255):
+        return "content_type must be between 1 and 255 characters"
+    case rule.CacheControl == "" || len(rule.CacheControl) > 255:
+        return "cache_control must be between 1 and 255 characters"
+    case strings.ContainsAny(rule.CacheControl, "\r\n"):
+        return "cache_control must be a single line"
+    }
+    return ""
+}
+
+// getDomainCacheRules returns a domain's cache rules
+func (h *Handlers) getDomainCacheRules(w http.ResponseWriter, r *http.Request) {
+    rules, err := loadDomainCacheRules(r.Context(), h.db, mustParseInt64(chi.URLParam(r, "id")))
+    if err != nil {
+        logger.Error("Fetching cache rules failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch cache rules")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(rules)
+}
+
+// setDomainCacheRules replaces a domain's cache rules. They are tried in the
+// given order and the first match applies.
+func (h *Handlers) setDomainCacheRules(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    domainID := mustParseInt64(chi.URLParam(r, "id"))
+
+    var req []db.DomainCacheRule
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+    if len(req) > maxCacheRules {
+        writeError(w, r, http.StatusBadRequest, "A domain can have at most 50 cache rules")
+        return
+    }
+    for _, rule := range req {
+        if msg := validateCacheRule(rule); msg != "" {
+            writeError(w, r, http.StatusBadRequest, msg)
+            return
+        }
+    }
+
+    tx, err := h.db.Begin(ctx)
+    if err != nil {
+        logger.Error("Starting transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    defer tx.Rollback(ctx)
+
+    before, err := loadDomainCacheRules(ctx, tx, domainID)
+    if err != nil {
+        logger.Error("Fetching cache rules failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to set cache rules")
+        return
+    }
+
+    if _, err := tx.Exec(ctx, "DELETE FROM domain_cache_rules WHERE domain_id = $1", domainID); err != nil {
+        logger.Error("Clearing cache rules failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to set cache rules")
+        return
+    }
+    for i, rule := range req {
+        _, err := tx.Exec(ctx, `
+            INSERT INTO domain_cache_rules (domain_id, position, path_prefix, content_type, cache_control, override)
+            VALUES ($1, $2, $3, $4, $5, $6)
+        `, domainID, i, rule.PathPrefix, rule.ContentType, rule.CacheControl, rule.Override)
+        if err != nil {
+            logger.Error("Adding cache rule failed", "error", err)
+            writeError(w, r, http.StatusInternalServerError, "Failed to set cache rules")
+            return
+        }
+    }
+
+    after, err := loadDomainCacheRules(ctx, tx, domainID)
+    if err != nil {
+        logger.Error("Fetching cache rules failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to set cache rules")
+        return
+    }
+
+    // Record audit log
+    if err := writeAudit(ctx, tx, getUserIDFromContext(ctx), "set_cache_rules", "domain", domainID, before, after); err != nil {
+        logger.Error("Creating audit log failed", "error", err)
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        logger.Error("Committing transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    h.publishAudit(getUserIDFromContext(ctx), "set_cache_rules", "domain", domainID)
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(after)
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+    "strings"
+    "testing"
+
+    "viacortex/internal/db"
+)
+
+func TestValidateCacheRule(t *testing.T) {
+    str := func(s string) *string { return &s }
+    tests := []struct {
+        name string
+        rule db.DomainCacheRule
+        want string
+    }{
+        {"path prefix", db.DomainCacheRule{PathPrefix: str("/static/"), CacheControl: "max-age=60"}, ""},
+        {"content type", db.DomainCacheRule{ContentType: str("image/"), CacheControl: "max-age=60"}, ""},
+        {"no criteria", db.DomainCacheRule{CacheControl: "max-age=60"}, "Each rule needs a path_prefix, a content_type or both"},
+        {"relative path", db.DomainCacheRule{PathPrefix: str("static/"), CacheControl: "max-age=60"}, "path_prefix must start with /"},
+        {"long path", db.DomainCacheRule{PathPrefix: str("/" + strings.Repeat("a", 255)), CacheControl: "max-age=60"}, "path_prefix must be at most 255 characters"},
+        {"empty content type", db.DomainCacheRule{ContentType: str(""), CacheControl: "max-age=60"}, "content_type must be between 1 and 255 characters"},
+        {"no cache control", db.DomainCacheRule{PathPrefix: str("/")}, "cache_control must be between 1 and 255 characters"},
+        {"header injection", db.DomainCacheRule{PathPrefix: str("/"), CacheControl: "max-age=60\r\nSet-Cookie: a=b"}, "cache_control must be a single line"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := validateCacheRule(tt.rule); got != tt.want {
+                t.Errorf("validateCacheRule() = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
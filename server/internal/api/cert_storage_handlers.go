@@ -0,0 +1,39 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"viacortex/internal/proxy"
+)
+
+// SetStorageJanitor exposes certificate storage usage and cleanup
+func (h *Handlers) SetStorageJanitor(j *proxy.StorageJanitor) {
+    h.janitor = j
+}
+
+// getCertStorageUsage returns the certificate storage usage found by the
+// janitor's last run
+func (h *Handlers) getCertStorageUsage(w http.ResponseWriter, r *http.Request) {
+    if h.janitor == nil {
+        writeError(w, r, http.StatusNotFound, "Certificate storage cleanup is not enabled on this server")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(h.janitor.Usage())
+}
+
+// cleanCertStorage prunes stale challenge tokens and enforces the storage
+// quota now instead of at the next scheduled run
+func (h *Handlers) cleanCertStorage(w http.ResponseWriter, r *http.Request) {
+    if h.janitor == nil {
+        writeError(w, r, http.StatusNotFound, "Certificate storage cleanup is not enabled on this server")
+        return
+    }
+
+    usage := h.janitor.Run(r.Context())
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(usage)
+}
@@ -0,0 +1,294 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"viacortex/internal/cluster"
+	"viacortex/internal/proxy"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// SetCluster enables the cluster node view
+func (h *Handlers) SetCluster(c *cluster.Registry) {
+    h.cluster = c
+}
+
+// SetLeader lets a draining node hand its singleton jobs to another node
+func (h *Handlers) SetLeader(l *cluster.Leader) {
+    h.leader = l
+}
+
+// SetBus makes every audited change ask the cluster's nodes to reload
+func (h *Handlers) SetBus(b proxy.Bus) {
+    h.bus = b
+}
+
+// getClusterNodes lists the nodes sharing this database with the status each
+// last reported
+func (h *Handlers) getClusterNodes(w http.ResponseWriter, r *http.Request) {
+    if h.cluster == nil {
+        writeError(w, r, http.StatusNotFound, "Cluster mode is not enabled on this server")
+        return
+    }
+
+    nodes, err := h.cluster.Nodes(r.Context())
+    if err != nil {
+        logger.Error("Fetching cluster nodes failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch cluster nodes")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(nodes)
+}
+
+// nodeTraffic is a node's share of the proxied traffic over a time range
+type nodeTraffic struct {
+    Requests          int     `json:"requests"`
+    Errors            int     `json:"errors"`
+    ErrorRate         float64 `json:"error_rate"`
+    RequestsPerSecond float64 `json:"requests_per_second"`
+    AvgLatency        float64 `json:"avg_latency_ms"`
+    MaxP95Latency     float64 `json:"max_p95_latency_ms"`
+    TCPConnections    int     `json:"tcp_connections"`
+}
+
+// getClusterOverview shows each node's status and resource usage next to
+// the traffic it served, plus cluster-wide totals. Traffic recorded before
+// metrics carried a node ID only counts towards the totals.
+func (h *Handlers) getClusterOverview(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    if h.cluster == nil {
+        writeError(w, r, http.StatusNotFound, "Cluster mode is not enabled on this server")
+        return
+    }
+
+    timeRange := r.URL.Query().Get("range")
+    if timeRange == "" {
+        timeRange = "1h"
+    }
+    duration, err := time.ParseDuration(timeRange)
+    if err != nil || duration <= 0 {
+        writeError(w, r, http.StatusBadRequest, "Invalid time range")
+        return
+    }
+    startTime := time.Now().Add(-duration)
+
+    nodes, err := h.cluster.Nodes(ctx)
+    if err != nil {
+        logger.Error("Fetching cluster nodes failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch cluster overview")
+        return
+    }
+
+    traffic := map[string]*nodeTraffic{}
+    rows, err := h.reader().Query(ctx, `
+        SELECT COALESCE(node_id, ''), SUM(request_count), SUM(error_count),
+               COALESCE(SUM(avg_latency_ms * request_count) / NULLIF(SUM(request_count), 0), 0),
+               MAX(p95_latency_ms)
+        FROM request_metrics
+        WHERE timestamp > $1
+        GROUP BY node_id
+    `, startTime)
+    if err != nil {
+        logger.Error("Fetching node metrics failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch cluster overview")
+        return
+    }
+    for rows.Next() {
+        var nodeID string
+        t := &nodeTraffic{}
+        if err := rows.Scan(&nodeID, &t.Requests, &t.Errors, &t.AvgLatency, &t.MaxP95Latency); err != nil {
+            logger.Error("Scanning node metrics failed", "error", err)
+            continue
+        }
+        traffic[nodeID] = t
+    }
+    rows.Close()
+
+    rows, err = h.reader().Query(ctx, `
+        SELECT COALESCE(node_id, ''), SUM(connection_count)
+        FROM tcp_metrics
+        WHERE timestamp > $1
+        GROUP BY node_id
+    `, startTime)
+    if err != nil {
+        logger.Error("Fetching node TCP metrics failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch cluster overview")
+        return
+    }
+    for rows.Next() {
+        var nodeID string
+        var connections int
+        if err := rows.Scan(&nodeID, &connections); err != nil {
+            logger.Error("Scanning node TCP metrics failed", "error", err)
+            continue
+        }
+        if traffic[nodeID] == nil {
+            traffic[nodeID] = &nodeTraffic{}
+        }
+        traffic[nodeID].TCPConnections = connections
+    }
+    rows.Close()
+
+    seconds := duration.Seconds()
+    totals := nodeTraffic{}
+    var latencySum float64
+    for _, t := range traffic {
+        t.ErrorRate = errorRate(t.Errors, t.Requests)
+        t.RequestsPerSecond = float64(t.Requests) / seconds
+        totals.Requests += t.Requests
+        totals.Errors += t.Errors
+        totals.TCPConnections += t.TCPConnections
+        latencySum += t.AvgLatency * float64(t.Requests)
+        if t.MaxP95Latency > totals.MaxP95Latency {
+            totals.MaxP95Latency = t.MaxP95Latency
+        }
+    }
+    totals.ErrorRate = errorRate(totals.Errors, totals.Requests)
+    totals.RequestsPerSecond = float64(totals.Requests) / seconds
+    if totals.Requests > 0 {
+        totals.AvgLatency = latencySum / float64(totals.Requests)
+    }
+
+    overview := []map[string]interface{}{}
+    for _, n := range nodes {
+        t := traffic[n.ID]
+        if t == nil {
+            t = &nodeTraffic{}
+        }
+        overview = append(overview, map[string]interface{}{
+            "node":    n,
+            "traffic": t,
+        })
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "range":  timeRange,
+        "nodes":  overview,
+        "totals": totals,
+    })
+}
+
+// deleteClusterNode removes a node that went away without deregistering.
+// Online nodes would only re-register on their next heartbeat, so they are
+// refused.
+func (h *Handlers) deleteClusterNode(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    if h.cluster == nil {
+        writeError(w, r, http.StatusNotFound, "Cluster mode is not enabled on this server")
+        return
+    }
+    nodeID := chi.URLParam(r, "nodeID")
+
+    nodes, err := h.cluster.Nodes(ctx)
+    if err != nil {
+        logger.Error("Fetching cluster nodes failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to remove cluster node")
+        return
+    }
+    var node *cluster.Node
+    for i := range nodes {
+        if nodes[i].ID == nodeID {
+            node = &nodes[i]
+        }
+    }
+    if node == nil {
+        writeError(w, r, http.StatusNotFound, "Cluster node not found")
+        return
+    }
+    if node.Online {
+        writeError(w, r, http.StatusConflict, "Cluster node is online; stop it first")
+        return
+    }
+
+    if _, err := h.cluster.Forget(ctx, nodeID); err != nil {
+        logger.Error("Removing cluster node failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to remove cluster node")
+        return
+    }
+
+    // Record audit log
+    if err := h.recordAudit(ctx, getUserIDFromContext(ctx), "delete", "cluster_node", 0, node, nil); err != nil {
+        logger.Error("Creating audit log failed", "error", err)
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// getDrainStatus reports whether this node is draining and how many
+// requests it is still serving
+func (h *Handlers) getDrainStatus(w http.ResponseWriter, r *http.Request) {
+    if h.proxy == nil {
+        writeError(w, r, http.StatusNotFound, "This server does not run the proxy")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(h.proxy.DrainStatus())
+}
+
+// startDrain takes this node out of rotation ahead of an upgrade: readiness
+// fails so load balancers move traffic away, keep-alive connections are
+// closed, singleton jobs move to another node, and the final metrics are
+// flushed once in-flight requests finish. Poll GET until drained is true,
+// then stop the node.
+func (h *Handlers) startDrain(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    if h.proxy == nil {
+        writeError(w, r, http.StatusNotFound, "This server does not run the proxy")
+        return
+    }
+
+    h.proxy.Drain()
+    if h.leader != nil {
+        h.leader.SetPaused(true)
+    }
+
+    // Record audit log
+    if err := h.recordAudit(ctx, getUserIDFromContext(ctx), "drain", "cluster_node", 0, nil, map[string]interface{}{
+        "node_id": h.nodeID(),
+    }); err != nil {
+        logger.Error("Creating audit log failed", "error", err)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusAccepted)
+    json.NewEncoder(w).Encode(h.proxy.DrainStatus())
+}
+
+// stopDrain puts this node back into rotation
+func (h *Handlers) stopDrain(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    if h.proxy == nil {
+        writeError(w, r, http.StatusNotFound, "This server does not run the proxy")
+        return
+    }
+
+    h.proxy.Undrain()
+    if h.leader != nil {
+        h.leader.SetPaused(false)
+    }
+
+    // Record audit log
+    if err := h.recordAudit(ctx, getUserIDFromContext(ctx), "undrain", "cluster_node", 0, nil, map[string]interface{}{
+        "node_id": h.nodeID(),
+    }); err != nil {
+        logger.Error("Creating audit log failed", "error", err)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(h.proxy.DrainStatus())
+}
+
+// nodeID identifies this node in audit entries
+func (h *Handlers) nodeID() string {
+    if h.cluster == nil {
+        return ""
+    }
+    return h.cluster.ID()
+}
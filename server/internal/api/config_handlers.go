@@ -0,0 +1,510 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"viacortex/internal/configdoc"
+	"viacortex/internal/db"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// maxImportSize bounds the size of an uploaded configuration document
+const maxImportSize = 10 << 20
+
+// exportConfig returns every domain with its backends, IP rules and rate
+// limits as a single document, or rendered as a Caddyfile or nginx config
+// with format=caddy|nginx
+func (h *Handlers) exportConfig(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    format := configFormat(r)
+
+    doc, err := h.buildConfigDocument(ctx)
+    if err != nil {
+        logger.Error("Exporting configuration failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to export configuration")
+        return
+    }
+
+    body, err := configdoc.Encode(doc, format)
+    if err != nil {
+        writeError(w, r, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    contentType, ext := "application/json", "json"
+    switch format {
+    case "yaml":
+        contentType, ext = "application/yaml", "yaml"
+    case "caddy", "caddyfile":
+        contentType, ext = "text/plain; charset=utf-8", "Caddyfile"
+    case "nginx":
+        contentType, ext = "text/plain; charset=utf-8", "conf"
+    }
+    w.Header().Set("Content-Type", contentType)
+    w.Header().Set("Content-Disposition",
+        fmt.Sprintf(`attachment; filename="viacortex-config-%s.%s"`, time.Now().UTC().Format("20060102-150405"), ext))
+    w.Write(body)
+}
+
+// importConfig applies a configuration document atomically. Domains are
+// matched by name; mode=replace also removes domains missing from the
+// document. With dry_run=true the changes are computed and rolled back.
+func (h *Handlers) importConfig(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    dryRun := r.URL.Query().Get("dry_run") == "true"
+    mode := r.URL.Query().Get("mode")
+    if mode == "" {
+        mode = "merge"
+    }
+    if mode != "merge" && mode != "replace" {
+        writeError(w, r, http.StatusBadRequest, "mode must be merge or replace")
+        return
+    }
+
+    data, err := io.ReadAll(io.LimitReader(r.Body, maxImportSize))
+    if err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+
+    doc, err := configdoc.Decode(data, configFormat(r))
+    if err != nil {
+        writeError(w, r, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    if problems := doc.Validate(); len(problems) > 0 {
+        writeErrorDetails(w, r, http.StatusUnprocessableEntity, "invalid_config", "Configuration document is invalid", map[string]interface{}{
+            "problems": problems,
+        })
+        return
+    }
+
+    tx, err := h.db.Begin(ctx)
+    if err != nil {
+        logger.Error("Starting transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    defer tx.Rollback(ctx)
+
+    summary, err := applyConfigDocument(ctx, tx, doc, mode == "replace")
+    if err != nil {
+        logger.Error("Importing configuration failed", "error", err)
+        writeError(w, r, http.StatusBadRequest, "Failed to import configuration: "+err.Error())
+        return
+    }
+
+    if !dryRun {
+        if err := tx.Commit(ctx); err != nil {
+            logger.Error("Committing transaction failed", "error", err)
+            writeError(w, r, http.StatusInternalServerError, "Server error")
+            return
+        }
+
+        // Record audit log
+        userID := getUserIDFromContext(ctx)
+        if err := h.recordAudit(ctx, userID, "import", "config", 0, nil, summary); err != nil {
+            logger.Error("Recording audit failed", "error", err)
+        }
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "dry_run": dryRun,
+        "mode":    mode,
+        "summary": summary,
+    })
+}
+
+// importServerConfig translates an nginx config or a Caddyfile into domains
+// and backends and applies them like a merge import. The generated document
+// and translation warnings are returned alongside the summary.
+func (h *Handlers) importServerConfig(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    dryRun := r.URL.Query().Get("dry_run") == "true"
+
+    var req struct {
+        Format string `json:"format"`
+        Config string `json:"config"`
+    }
+    if err := json.NewDecoder(io.LimitReader(r.Body, maxImportSize)).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+
+    resolve := func(host string) (net.IP, error) {
+        ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+        if err != nil {
+            return nil, err
+        }
+        for _, ip := range ips {
+            if ip.To4() != nil {
+                return ip, nil
+            }
+        }
+        return ips[0], nil
+    }
+
+    var doc *configdoc.Document
+    var warnings []string
+    var err error
+    switch req.Format {
+    case "nginx":
+        doc, warnings, err = configdoc.ImportNginx(req.Config, resolve)
+    case "caddy", "caddyfile":
+        doc, warnings, err = configdoc.ImportCaddyfile(req.Config, resolve)
+    default:
+        writeError(w, r, http.StatusBadRequest, "format must be nginx or caddy")
+        return
+    }
+    if err != nil {
+        writeError(w, r, http.StatusBadRequest, "Failed to parse configuration: "+err.Error())
+        return
+    }
+    if warnings == nil {
+        warnings = []string{}
+    }
+
+    if problems := doc.Validate(); len(problems) > 0 {
+        writeErrorDetails(w, r, http.StatusUnprocessableEntity, "invalid_config", "Translated configuration is invalid", map[string]interface{}{
+            "problems": problems,
+            "warnings": warnings,
+        })
+        return
+    }
+
+    tx, err := h.db.Begin(ctx)
+    if err != nil {
+        logger.Error("Starting transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    defer tx.Rollback(ctx)
+
+    summary, err := applyConfigDocument(ctx, tx, doc, false)
+    if err != nil {
+        logger.Error("Importing configuration failed", "format", req.Format, "error", err)
+        writeError(w, r, http.StatusBadRequest, "Failed to import configuration: "+err.Error())
+        return
+    }
+
+    if !dryRun {
+        if err := tx.Commit(ctx); err != nil {
+            logger.Error("Committing transaction failed", "error", err)
+            writeError(w, r, http.StatusInternalServerError, "Server error")
+            return
+        }
+
+        // Record audit log
+        userID := getUserIDFromContext(ctx)
+        if err := h.recordAudit(ctx, userID, "import_"+req.Format, "config", 0, nil, summary); err != nil {
+            logger.Error("Recording audit failed", "error", err)
+        }
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "dry_run":  dryRun,
+        "document": doc,
+        "warnings": warnings,
+        "summary":  summary,
+    })
+}
+
+// configFormat picks the document format from the format query parameter or
+// the request content type, defaulting to JSON
+func configFormat(r *http.Request) string {
+    if format := r.URL.Query().Get("format"); format != "" {
+        return format
+    }
+    if strings.Contains(r.Header.Get("Content-Type"), "yaml") {
+        return "yaml"
+    }
+    return "json"
+}
+
+// buildConfigDocument reads the proxy configuration of every domain visible
+// to the user from the database
+func (h *Handlers) buildConfigDocument(ctx context.Context) (*configdoc.Document, error) {
+    doc := &configdoc.Document{
+        Version:    configdoc.CurrentVersion,
+        ExportedAt: time.Now().UTC(),
+        Domains:    []configdoc.Domain{},
+    }
+
+    userID, isAdmin, orgID := domainVisibility(ctx)
+    rows, err := h.db.Query(ctx, `
+        SELECT id, name, target_url, ssl_enabled, health_check_enabled,
+               health_check_interval, custom_error_pages, NOT enabled
+        FROM domains
+        WHERE `+visibleDomainsSQL("id", 1, 2, 3)+`
+        ORDER BY name
+    `, userID, isAdmin, orgID)
+    if err != nil {
+        return nil, err
+    }
+
+    var ids []int64
+    for rows.Next() {
+        var id int64
+        var d configdoc.Domain
+        var errorPages json.RawMessage
+        if err := rows.Scan(&id, &d.Name, &d.TargetURL, &d.SSLEnabled,
+            &d.HealthCheckEnabled, &d.HealthCheckInterval, &errorPages, &d.Disabled); err != nil {
+            rows.Close()
+            return nil, err
+        }
+        if len(errorPages) > 0 && string(errorPages) != "null" {
+            if err := json.Unmarshal(errorPages, &d.CustomErrorPages); err != nil {
+                rows.Close()
+                return nil, err
+            }
+        }
+        d.Backends = []configdoc.Backend{}
+        ids = append(ids, id)
+        doc.Domains = append(doc.Domains, d)
+    }
+    rows.Close()
+
+    index := make(map[int64]*configdoc.Domain, len(ids))
+    for i, id := range ids {
+        index[id] = &doc.Domains[i]
+    }
+
+    backendRows, err := h.db.Query(ctx, `
+        SELECT domain_id, scheme, host(ip), port, weight, is_active
+        FROM backend_servers
+        ORDER BY id
+    `)
+    if err != nil {
+        return nil, err
+    }
+    for backendRows.Next() {
+        var domainID int64
+        var b configdoc.Backend
+        if err := backendRows.Scan(&domainID, &b.Scheme, &b.IP, &b.Port, &b.Weight, &b.IsActive); err != nil {
+            backendRows.Close()
+            return nil, err
+        }
+        if d, ok := index[domainID]; ok {
+            d.Backends = append(d.Backends, b)
+        }
+    }
+    backendRows.Close()
+
+    ruleRows, err := h.db.Query(ctx, `
+        SELECT domain_id, ip_range::text, rule_type, COALESCE(description, '')
+        FROM ip_rules
+        ORDER BY id
+    `)
+    if err != nil {
+        return nil, err
+    }
+    for ruleRows.Next() {
+        var domainID int64
+        var rule configdoc.IPRule
+        if err := ruleRows.Scan(&domainID, &rule.IPRange, &rule.RuleType, &rule.Description); err != nil {
+            ruleRows.Close()
+            return nil, err
+        }
+        if d, ok := index[domainID]; ok {
+            d.IPRules = append(d.IPRules, rule)
+        }
+    }
+    ruleRows.Close()
+
+    limitRows, err := h.db.Query(ctx, `
+        SELECT domain_id, requests_per_second, burst_size, per_ip
+        FROM rate_limits
+        ORDER BY id
+    `)
+    if err != nil {
+        return nil, err
+    }
+    for limitRows.Next() {
+        var domainID int64
+        var limit configdoc.RateLimit
+        if err := limitRows.Scan(&domainID, &limit.RequestsPerSecond, &limit.BurstSize, &limit.PerIP); err != nil {
+            limitRows.Close()
+            return nil, err
+        }
+        if d, ok := index[domainID]; ok {
+            d.RateLimits = append(d.RateLimits, limit)
+        }
+    }
+    limitRows.Close()
+
+    return doc, nil
+}
+
+// importSummary lists the domains an import touched
+type importSummary struct {
+    Created []string `json:"created"`
+    Updated []string `json:"updated"`
+    Deleted []string `json:"deleted"`
+}
+
+// applyConfigDocument writes doc inside tx. Backends are matched by
+// scheme/ip/port so existing ones keep their IDs and health history; IP rules
+// and rate limits are replaced wholesale. With an organization selected the
+// import is confined to that organization's domains.
+func applyConfigDocument(ctx context.Context, tx pgx.Tx, doc *configdoc.Document, replace bool) (*importSummary, error) {
+    summary := &importSummary{Created: []string{}, Updated: []string{}, Deleted: []string{}}
+    keep := map[string]bool{}
+    orgID := selectedOrg(ctx).ID
+
+    for _, d := range doc.Domains {
+        keep[d.Name] = true
+
+        errorPages, err := d.CustomErrorPagesJSON()
+        if err != nil {
+            return nil, fmt.Errorf("domain %s: %w", d.Name, err)
+        }
+
+        var domainID int64
+        var domainOrg *int64
+        err = tx.QueryRow(ctx, "SELECT id, org_id FROM domains WHERE name = $1", d.Name).Scan(&domainID, &domainOrg)
+        if err == nil && orgID != 0 && (domainOrg == nil || *domainOrg != orgID) {
+            return nil, fmt.Errorf("domain %s belongs to another organization", d.Name)
+        }
+        switch {
+        case err == pgx.ErrNoRows:
+            err = tx.QueryRow(ctx, `
+                INSERT INTO domains (
+                    name, target_url, ssl_enabled, health_check_enabled,
+                    health_check_interval, custom_error_pages, enabled, org_id
+                ) VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8::integer, 0))
+                RETURNING id
+            `, d.Name, d.TargetURL, d.SSLEnabled, d.HealthCheckEnabled,
+                d.HealthCheckInterval, errorPages, !d.Disabled, orgID).Scan(&domainID)
+            if err != nil {
+                return nil, fmt.Errorf("domain %s: %w", d.Name, err)
+            }
+            summary.Created = append(summary.Created, d.Name)
+        case err != nil:
+            return nil, fmt.Errorf("domain %s: %w", d.Name, err)
+        default:
+            _, err = tx.Exec(ctx, `
+                UPDATE domains SET
+                    target_url = $1, ssl_enabled = $2, health_check_enabled = $3,
+                    health_check_interval = $4, custom_error_pages = $5,
+                    enabled = $6, updated_at = CURRENT_TIMESTAMP
+                WHERE id = $7
+            `, d.TargetURL, d.SSLEnabled, d.HealthCheckEnabled,
+                d.HealthCheckInterval, errorPages, !d.Disabled, domainID)
+            if err != nil {
+                return nil, fmt.Errorf("domain %s: %w", d.Name, err)
+            }
+            summary.Updated = append(summary.Updated, d.Name)
+        }
+
+        backends, err := matchImportedBackends(ctx, tx, domainID, d.Backends)
+        if err != nil {
+            return nil, fmt.Errorf("domain %s backends: %w", d.Name, err)
+        }
+        if err := reconcileBackends(ctx, tx, domainID, backends); err != nil {
+            return nil, fmt.Errorf("domain %s backends: %w", d.Name, err)
+        }
+
+        if _, err := tx.Exec(ctx, "DELETE FROM ip_rules WHERE domain_id = $1", domainID); err != nil {
+            return nil, fmt.Errorf("domain %s ip rules: %w", d.Name, err)
+        }
+        for _, rule := range d.IPRules {
+            if _, err := tx.Exec(ctx, `
+                INSERT INTO ip_rules (domain_id, ip_range, rule_type, description)
+                VALUES ($1, $2::cidr, $3, $4)
+            `, domainID, rule.IPRange, rule.RuleType, rule.Description); err != nil {
+                return nil, fmt.Errorf("domain %s ip rules: %w", d.Name, err)
+            }
+        }
+
+        if _, err := tx.Exec(ctx, "DELETE FROM rate_limits WHERE domain_id = $1", domainID); err != nil {
+            return nil, fmt.Errorf("domain %s rate limits: %w", d.Name, err)
+        }
+        for _, limit := range d.RateLimits {
+            if _, err := tx.Exec(ctx, `
+                INSERT INTO rate_limits (domain_id, requests_per_second, burst_size, per_ip)
+                VALUES ($1, $2, $3, $4)
+            `, domainID, limit.RequestsPerSecond, limit.BurstSize, limit.PerIP); err != nil {
+                return nil, fmt.Errorf("domain %s rate limits: %w", d.Name, err)
+            }
+        }
+    }
+
+    if replace {
+        rows, err := tx.Query(ctx, "SELECT id, name FROM domains WHERE $1::integer = 0 OR org_id = $1", orgID)
+        if err != nil {
+            return nil, err
+        }
+        stale := map[int64]string{}
+        for rows.Next() {
+            var id int64
+            var name string
+            if err := rows.Scan(&id, &name); err != nil {
+                rows.Close()
+                return nil, err
+            }
+            if !keep[name] {
+                stale[id] = name
+            }
+        }
+        rows.Close()
+
+        for id, name := range stale {
+            if _, err := tx.Exec(ctx, "DELETE FROM domains WHERE id = $1", id); err != nil {
+                return nil, fmt.Errorf("deleting domain %s: %w", name, err)
+            }
+            summary.Deleted = append(summary.Deleted, name)
+        }
+    }
+
+    return summary, nil
+}
+
+// matchImportedBackends converts document backends into backend servers,
+// reusing the ID of an existing backend with the same scheme, IP and port
+func matchImportedBackends(ctx context.Context, tx pgx.Tx, domainID int64, imported []configdoc.Backend) ([]db.BackendServer, error) {
+    rows, err := tx.Query(ctx, `
+        SELECT id, scheme, host(ip), port FROM backend_servers WHERE domain_id = $1
+    `, domainID)
+    if err != nil {
+        return nil, err
+    }
+    existing := map[string]int64{}
+    for rows.Next() {
+        var id int64
+        var scheme, ip string
+        var port int
+        if err := rows.Scan(&id, &scheme, &ip, &port); err != nil {
+            rows.Close()
+            return nil, err
+        }
+        existing[fmt.Sprintf("%s://%s:%d", scheme, ip, port)] = id
+    }
+    rows.Close()
+
+    backends := make([]db.BackendServer, 0, len(imported))
+    for _, b := range imported {
+        ip := net.ParseIP(b.IP)
+        key := fmt.Sprintf("%s://%s:%d", b.Scheme, ip.String(), b.Port)
+        backends = append(backends, db.BackendServer{
+            ID:       existing[key],
+            Scheme:   b.Scheme,
+            IP:       ip,
+            Port:     b.Port,
+            Weight:   b.Weight,
+            IsActive: b.IsActive,
+        })
+        delete(existing, key)
+    }
+    return backends, nil
+}
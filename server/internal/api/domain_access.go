@@ -0,0 +1,344 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"viacortex/internal/db"
+	"viacortex/internal/middleware"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v4"
+)
+
+// Roles a user can hold on a single domain. Admins act as owners of every
+// domain; everyone else only sees the domains they are a member of, either
+// directly or through the domain's organization.
+const (
+    domainRoleViewer = "viewer"
+    domainRoleEditor = "editor"
+    domainRoleOwner  = "owner"
+)
+
+var domainRoleRank = map[string]int{
+    domainRoleViewer: 1,
+    domainRoleEditor: 2,
+    domainRoleOwner:  3,
+}
+
+// domainVisibility returns the arguments for visibleDomainsSQL: the current
+// user, whether they may see every domain and the selected organization
+func domainVisibility(ctx context.Context) (int64, bool, int64) {
+    return getUserIDFromContext(ctx), middleware.GetRoleFromContext(ctx) == middleware.RoleAdmin, selectedOrg(ctx).ID
+}
+
+// visibleDomainsSQL is a condition limiting column, a domain ID, to the
+// domains of the user in placeholder $user unless placeholder $all is true.
+// When placeholder $org is not 0 only that organization's domains match.
+func visibleDomainsSQL(column string, user, all, org int) string {
+    return fmt.Sprintf(`((%[1]s IN (SELECT domain_id FROM domain_members WHERE user_id = $%[2]d)
+            OR %[1]s IN (SELECT d.id FROM domains d JOIN organization_members om ON om.org_id = d.org_id WHERE om.user_id = $%[2]d)
+            OR $%[3]d::boolean)
+        AND ($%[4]d::integer = 0 OR %[1]s IN (SELECT id FROM domains WHERE org_id = $%[4]d)))`, column, user, all, org)
+}
+
+// domainRole returns the current user's role on a domain, or "" when they
+// have none, along with the organization the domain belongs to. Domains
+// outside the selected organization are treated as invisible.
+func (h *Handlers) domainRole(ctx context.Context, domainID int64) (string, int64, error) {
+    userID, isAdmin, selected := domainVisibility(ctx)
+
+    var orgID *int64
+    var direct, inherited string
+    err := h.db.QueryRow(ctx, `
+        SELECT d.org_id, COALESCE(dm.role, ''), COALESCE(om.role, '')
+        FROM domains d
+        LEFT JOIN domain_members dm ON dm.domain_id = d.id AND dm.user_id = $2
+        LEFT JOIN organization_members om ON om.org_id = d.org_id AND om.user_id = $2
+        WHERE d.id = $1
+    `, domainID, userID).Scan(&orgID, &direct, &inherited)
+    if err == pgx.ErrNoRows {
+        return "", 0, nil
+    }
+    if err != nil {
+        return "", 0, err
+    }
+
+    var org int64
+    if orgID != nil {
+        org = *orgID
+    }
+    if selected != 0 && org != selected {
+        return "", org, nil
+    }
+    if isAdmin {
+        return domainRoleOwner, org, nil
+    }
+
+    role := direct
+    if fromOrg := orgDomainRole[inherited]; domainRoleRank[fromOrg] > domainRoleRank[role] {
+        role = fromOrg
+    }
+    return role, org, nil
+}
+
+// requireDomainRole rejects requests for a domain, taken from the {id} or
+// {domainID} URL parameter, unless the user holds at least min on it.
+// Domains the user cannot see at all are reported as not found.
+func (h *Handlers) requireDomainRole(min string) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            param := chi.URLParam(r, "id")
+            if param == "" {
+                param = chi.URLParam(r, "domainID")
+            }
+            domainID, err := strconv.ParseInt(param, 10, 64)
+            if err != nil {
+                writeError(w, r, http.StatusBadRequest, "Invalid domain ID")
+                return
+            }
+
+            role, orgID, err := h.domainRole(r.Context(), domainID)
+            if err != nil {
+                logger.Error("Checking domain access failed", "error", err)
+                writeError(w, r, http.StatusInternalServerError, "Server error")
+                return
+            }
+            if role == "" {
+                writeError(w, r, http.StatusNotFound, "Domain not found")
+                return
+            }
+            if domainRoleRank[role] < domainRoleRank[min] {
+                writeError(w, r, http.StatusForbidden, fmt.Sprintf("Requires the %s role on this domain", min))
+                return
+            }
+            next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), domainOrgKey{}, orgID)))
+        })
+    }
+}
+
+// addDomainOwner makes the creator of a domain its owner
+func addDomainOwner(ctx context.Context, tx pgx.Tx, domainID, userID int64) error {
+    _, err := tx.Exec(ctx, `
+        INSERT INTO domain_members (domain_id, user_id, role)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (domain_id, user_id) DO NOTHING
+    `, domainID, userID, domainRoleOwner)
+    return err
+}
+
+// getDomainMembers lists who has access to a domain
+func (h *Handlers) getDomainMembers(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    domainID := chi.URLParam(r, "id")
+
+    rows, err := h.db.Query(ctx, `
+        SELECT m.domain_id, m.user_id, u.email, m.role, m.created_at
+        FROM domain_members m
+        JOIN users u ON u.id = m.user_id
+        WHERE m.domain_id = $1
+        ORDER BY u.email
+    `, domainID)
+    if err != nil {
+        logger.Error("Fetching domain members failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch domain members")
+        return
+    }
+    defer rows.Close()
+
+    members := []db.DomainMember{}
+    for rows.Next() {
+        var m db.DomainMember
+        if err := rows.Scan(&m.DomainID, &m.UserID, &m.Email, &m.Role, &m.CreatedAt); err != nil {
+            logger.Error("Scanning domain member failed", "error", err)
+            continue
+        }
+        members = append(members, m)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(members)
+}
+
+// setDomainMember grants a user a role on a domain, or changes their role
+func (h *Handlers) setDomainMember(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    domainID, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+    userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+    if err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid user ID")
+        return
+    }
+
+    var req struct {
+        Role string `json:"role"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+    if _, ok := domainRoleRank[req.Role]; !ok {
+        writeError(w, r, http.StatusBadRequest, "Role must be viewer, editor or owner")
+        return
+    }
+
+    tx, err := h.db.Begin(ctx)
+    if err != nil {
+        logger.Error("Starting transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    defer tx.Rollback(ctx)
+
+    previous, ok := lockDomainMember(w, r, tx, domainID, userID)
+    if !ok {
+        return
+    }
+    if previous == domainRoleOwner && req.Role != domainRoleOwner && !checkOtherOwner(w, r, tx, domainID, userID) {
+        return
+    }
+
+    var userExists bool
+    if err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)", userID).Scan(&userExists); err != nil {
+        logger.Error("Fetching user failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    if !userExists {
+        writeError(w, r, http.StatusNotFound, "User not found")
+        return
+    }
+
+    _, err = tx.Exec(ctx, `
+        INSERT INTO domain_members (domain_id, user_id, role)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (domain_id, user_id) DO UPDATE SET role = EXCLUDED.role
+    `, domainID, userID, req.Role)
+    if err != nil {
+        logger.Error("Saving domain member failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to save domain member")
+        return
+    }
+
+    // Record audit log
+    var before interface{}
+    if previous != "" {
+        before = map[string]interface{}{"user_id": userID, "role": previous}
+    }
+    if err := writeAudit(ctx, tx, getUserIDFromContext(ctx), "set_member", "domain", domainID, before,
+        map[string]interface{}{"user_id": userID, "role": req.Role}); err != nil {
+        logger.Error("Creating audit log failed", "error", err)
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        logger.Error("Committing transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    h.publishAudit(getUserIDFromContext(ctx), "set_member", "domain", domainID)
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "domain_id": domainID,
+        "user_id":   userID,
+        "role":      req.Role,
+    })
+}
+
+// removeDomainMember takes a user's access to a domain away
+func (h *Handlers) removeDomainMember(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    domainID, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+    userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+    if err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid user ID")
+        return
+    }
+
+    tx, err := h.db.Begin(ctx)
+    if err != nil {
+        logger.Error("Starting transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    defer tx.Rollback(ctx)
+
+    previous, ok := lockDomainMember(w, r, tx, domainID, userID)
+    if !ok {
+        return
+    }
+    if previous == "" {
+        writeError(w, r, http.StatusNotFound, "Member not found")
+        return
+    }
+    if previous == domainRoleOwner && !checkOtherOwner(w, r, tx, domainID, userID) {
+        return
+    }
+
+    if _, err := tx.Exec(ctx, `
+        DELETE FROM domain_members WHERE domain_id = $1 AND user_id = $2
+    `, domainID, userID); err != nil {
+        logger.Error("Removing domain member failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to remove domain member")
+        return
+    }
+
+    // Record audit log
+    if err := writeAudit(ctx, tx, getUserIDFromContext(ctx), "remove_member", "domain", domainID,
+        map[string]interface{}{"user_id": userID, "role": previous}, nil); err != nil {
+        logger.Error("Creating audit log failed", "error", err)
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        logger.Error("Committing transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    h.publishAudit(getUserIDFromContext(ctx), "remove_member", "domain", domainID)
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// lockDomainMember locks the domain's member list and returns the user's
+// current role on it, "" if they are not a member
+func lockDomainMember(w http.ResponseWriter, r *http.Request, tx pgx.Tx, domainID, userID int64) (string, bool) {
+    ctx := r.Context()
+
+    if _, err := tx.Exec(ctx, "SELECT id FROM domains WHERE id = $1 FOR UPDATE", domainID); err != nil {
+        logger.Error("Locking domain failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return "", false
+    }
+
+    var role string
+    err := tx.QueryRow(ctx, `
+        SELECT role FROM domain_members WHERE domain_id = $1 AND user_id = $2
+    `, domainID, userID).Scan(&role)
+    if err != nil && err != pgx.ErrNoRows {
+        logger.Error("Fetching domain member failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return "", false
+    }
+    return role, true
+}
+
+// checkOtherOwner refuses to leave a domain without an owner
+func checkOtherOwner(w http.ResponseWriter, r *http.Request, tx pgx.Tx, domainID, userID int64) bool {
+    var others int
+    err := tx.QueryRow(r.Context(), `
+        SELECT COUNT(*) FROM domain_members WHERE domain_id = $1 AND user_id <> $2 AND role = $3
+    `, domainID, userID, domainRoleOwner).Scan(&others)
+    if err != nil {
+        logger.Error("Counting domain owners failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return false
+    }
+    if others == 0 {
+        writeError(w, r, http.StatusConflict, "A domain must keep at least one owner")
+        return false
+    }
+    return true
+}
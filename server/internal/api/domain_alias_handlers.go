@@ -0,0 +1,147 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"viacortex/internal/db"
+	"viacortex/internal/proxy"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// getDomainAliases returns the additional hostnames a domain is served under
+func (h *Handlers) getDomainAliases(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    domainID := chi.URLParam(r, "id")
+
+    rows, err := h.db.Query(ctx, `
+        SELECT id, domain_id, hostname, created_at
+        FROM domain_aliases
+        WHERE domain_id = $1
+        ORDER BY id
+    `, domainID)
+    if err != nil {
+        logger.Error("Fetching domain aliases failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch aliases")
+        return
+    }
+    defer rows.Close()
+
+    aliases := []db.DomainAlias{}
+    for rows.Next() {
+        var alias db.DomainAlias
+        if err := rows.Scan(&alias.ID, &alias.DomainID, &alias.Hostname, &alias.CreatedAt); err != nil {
+            logger.Error("Scanning domain alias failed", "error", err)
+            continue
+        }
+        aliases = append(aliases, alias)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(aliases)
+}
+
+// addDomainAlias serves a domain under an additional hostname
+func (h *Handlers) addDomainAlias(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    domainID := chi.URLParam(r, "id")
+
+    var req struct {
+        Hostname string `json:"hostname"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+    hostname := proxy.HostKey(req.Hostname)
+    if hostname == "" || strings.ContainsAny(hostname, "/ ") {
+        writeError(w, r, http.StatusBadRequest, "Invalid hostname")
+        return
+    }
+
+    if err := h.checkHostnameClaim(ctx, hostname); err != nil {
+        writeClaimError(w, r, err)
+        return
+    }
+
+    // A domain's own name always wins over an alias, so an alias that
+    // shadows a domain would never be served
+    var taken bool
+    err := h.db.QueryRow(ctx,
+        "SELECT EXISTS (SELECT 1 FROM domains WHERE lower(name) = $1)", hostname).Scan(&taken)
+    if err != nil {
+        logger.Error("Checking domain names failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to create alias")
+        return
+    }
+    if taken {
+        writeError(w, r, http.StatusConflict, "Hostname is already a domain")
+        return
+    }
+
+    var aliasID int64
+    err = h.db.QueryRow(ctx, `
+        INSERT INTO domain_aliases (domain_id, hostname)
+        VALUES ($1, $2)
+        RETURNING id
+    `, domainID, hostname).Scan(&aliasID)
+    if err != nil {
+        if strings.Contains(err.Error(), "domain_aliases_hostname_key") {
+            writeError(w, r, http.StatusConflict, "Hostname is already an alias")
+            return
+        }
+        logger.Error("Creating domain alias failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to create alias")
+        return
+    }
+
+    // Record audit log
+    userID := getUserIDFromContext(ctx)
+    after, _ := snapshotEntity(ctx, h.db, "domain_aliases", aliasID)
+    if err := h.recordAudit(ctx, userID, "create", "domain_alias", aliasID, nil, after); err != nil {
+        logger.Error("Recording audit failed", "error", err)
+    }
+
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "id":       aliasID,
+        "hostname": hostname,
+        "message":  "Alias created successfully",
+    })
+}
+
+// deleteDomainAlias stops serving a domain under an alias
+func (h *Handlers) deleteDomainAlias(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    aliasID := chi.URLParam(r, "aliasID")
+
+    before, err := snapshotEntity(ctx, h.db, "domain_aliases", aliasID)
+    if err != nil {
+        writeError(w, r, http.StatusNotFound, "Alias not found")
+        return
+    }
+
+    result, err := h.db.Exec(ctx, "DELETE FROM domain_aliases WHERE id = $1 AND domain_id = $2", aliasID, chi.URLParam(r, "id"))
+    if err != nil {
+        logger.Error("Deleting domain alias failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to delete alias")
+        return
+    }
+    if result.RowsAffected() == 0 {
+        writeError(w, r, http.StatusNotFound, "Alias not found")
+        return
+    }
+
+    // Record audit log
+    userID := getUserIDFromContext(ctx)
+    if err := h.recordAudit(ctx, userID, "delete", "domain_alias",
+        mustParseInt64(aliasID), before, nil); err != nil {
+        logger.Error("Recording audit failed", "error", err)
+    }
+
+    json.NewEncoder(w).Encode(map[string]string{
+        "message": "Alias deleted successfully",
+    })
+}
@@ -0,0 +1,335 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"viacortex/internal/db"
+	"viacortex/internal/middleware"
+	"viacortex/internal/proxy"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	// verifyRecordPrefix is prepended to a hostname to get the TXT record name
+	verifyRecordPrefix = "_viacortex-verify."
+	// verifyValuePrefix is prepended to the claim token in the TXT record value
+	verifyValuePrefix = "viacortex-verify="
+)
+
+// errHostnameNotVerified is returned when a non-admin user configures a
+// hostname without a verified claim on it
+var errHostnameNotVerified = errors.New("hostname ownership has not been verified")
+
+// normalizeHostname lowercases a hostname and strips any port and trailing dot
+func normalizeHostname(host string) string {
+    host = strings.TrimSpace(strings.ToLower(host))
+    if h, _, err := net.SplitHostPort(host); err == nil {
+        host = h
+    }
+    return strings.TrimSuffix(host, ".")
+}
+
+// claimInstructions describes the TXT record a claim has to publish
+func claimInstructions(claim db.DomainClaim) map[string]interface{} {
+    return map[string]interface{}{
+        "claim": claim,
+        "record": map[string]string{
+            "type":  "TXT",
+            "name":  verifyRecordPrefix + claim.Hostname,
+            "value": verifyValuePrefix + claim.Token,
+        },
+    }
+}
+
+// getDomainClaims returns the current user's hostname claims, or all claims
+// for admins
+func (h *Handlers) getDomainClaims(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    userID := getUserIDFromContext(ctx)
+    isAdmin := middleware.GetRoleFromContext(ctx) == middleware.RoleAdmin
+
+    rows, err := h.db.Query(ctx, `
+        SELECT id, user_id, hostname, token, verified_at, last_checked_at,
+               created_at, updated_at
+        FROM domain_claims
+        WHERE user_id = $1 OR $2::boolean
+        ORDER BY hostname
+    `, userID, isAdmin)
+    if err != nil {
+        logger.Error("Fetching domain claims failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch domain claims")
+        return
+    }
+    defer rows.Close()
+
+    claims := []db.DomainClaim{}
+    for rows.Next() {
+        var c db.DomainClaim
+        err := rows.Scan(
+            &c.ID, &c.UserID, &c.Hostname, &c.Token, &c.VerifiedAt,
+            &c.LastCheckedAt, &c.CreatedAt, &c.UpdatedAt,
+        )
+        if err != nil {
+            logger.Error("Scanning domain claim failed", "error", err)
+            continue
+        }
+        claims = append(claims, c)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(claims)
+}
+
+// createDomainClaim starts a claim on a hostname and returns the TXT record
+// to publish. Claiming again returns the existing token.
+func (h *Handlers) createDomainClaim(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    userID := getUserIDFromContext(ctx)
+
+    var req struct {
+        Hostname string `json:"hostname"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+
+    hostname := normalizeHostname(req.Hostname)
+    if hostname == "" || strings.ContainsAny(hostname, "/ ") {
+        writeError(w, r, http.StatusBadRequest, "A valid hostname is required")
+        return
+    }
+
+    var ownerID int64
+    err := h.db.QueryRow(ctx, `
+        SELECT user_id FROM domain_claims
+        WHERE hostname = $1 AND verified_at IS NOT NULL
+    `, hostname).Scan(&ownerID)
+    if err != nil && err != pgx.ErrNoRows {
+        logger.Error("Checking domain claim failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    if err == nil && ownerID != userID {
+        writeError(w, r, http.StatusConflict, "Hostname is already claimed by another user")
+        return
+    }
+
+    buf := make([]byte, 16)
+    if _, err := rand.Read(buf); err != nil {
+        logger.Error("Generating claim token failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+
+    var claim db.DomainClaim
+    err = h.db.QueryRow(ctx, `
+        INSERT INTO domain_claims (user_id, hostname, token)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (user_id, hostname) DO UPDATE SET hostname = EXCLUDED.hostname
+        RETURNING id, user_id, hostname, token, verified_at, last_checked_at,
+                  created_at, updated_at
+    `, userID, hostname, hex.EncodeToString(buf)).Scan(
+        &claim.ID, &claim.UserID, &claim.Hostname, &claim.Token, &claim.VerifiedAt,
+        &claim.LastCheckedAt, &claim.CreatedAt, &claim.UpdatedAt,
+    )
+    if err != nil {
+        logger.Error("Creating domain claim failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to create domain claim")
+        return
+    }
+
+    // Record audit log
+    if err := h.recordAudit(ctx, userID, "create", "domain_claim", claim.ID, nil, claim); err != nil {
+        logger.Error("Recording audit failed", "error", err)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(claimInstructions(claim))
+}
+
+// getDomainClaim returns a claim together with the TXT record to publish
+func (h *Handlers) getDomainClaim(w http.ResponseWriter, r *http.Request) {
+    claim, ok := h.loadOwnDomainClaim(w, r)
+    if !ok {
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(claimInstructions(*claim))
+}
+
+// verifyDomainClaim looks up the _viacortex-verify TXT record and marks the
+// claim verified when it carries the claim token
+func (h *Handlers) verifyDomainClaim(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    claim, ok := h.loadOwnDomainClaim(w, r)
+    if !ok {
+        return
+    }
+
+    records, lookupErr := net.DefaultResolver.LookupTXT(ctx, verifyRecordPrefix+claim.Hostname)
+    expected := verifyValuePrefix + claim.Token
+    found := false
+    for _, record := range records {
+        if strings.TrimSpace(record) == expected {
+            found = true
+            break
+        }
+    }
+
+    if !found {
+        if _, err := h.db.Exec(ctx,
+            "UPDATE domain_claims SET last_checked_at = CURRENT_TIMESTAMP WHERE id = $1", claim.ID); err != nil {
+            logger.Error("Updating domain claim failed", "error", err)
+        }
+
+        message := fmt.Sprintf("TXT record %s does not contain %s", verifyRecordPrefix+claim.Hostname, expected)
+        if lookupErr != nil {
+            message = fmt.Sprintf("TXT lookup for %s failed: %v", verifyRecordPrefix+claim.Hostname, lookupErr)
+        }
+        if records == nil {
+            records = []string{}
+        }
+
+        writeErrorDetails(w, r, http.StatusUnprocessableEntity, "claim_not_verified", message, map[string]interface{}{
+            "verified": false,
+            "found":    records,
+        })
+        return
+    }
+
+    before := *claim
+
+    // The partial unique index guarantees a single verified owner
+    err := h.db.QueryRow(ctx, `
+        UPDATE domain_claims
+        SET verified_at = COALESCE(verified_at, CURRENT_TIMESTAMP), last_checked_at = CURRENT_TIMESTAMP
+        WHERE id = $1
+        RETURNING verified_at, last_checked_at
+    `, claim.ID).Scan(&claim.VerifiedAt, &claim.LastCheckedAt)
+    if err != nil {
+        if strings.Contains(err.Error(), "idx_domain_claims_verified") {
+            writeError(w, r, http.StatusConflict, "Hostname is already claimed by another user")
+            return
+        }
+        logger.Error("Verifying domain claim failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to verify domain claim")
+        return
+    }
+
+    // Record audit log
+    userID := getUserIDFromContext(ctx)
+    if err := h.recordAudit(ctx, userID, "verify", "domain_claim", claim.ID, before, claim); err != nil {
+        logger.Error("Recording audit failed", "error", err)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "verified": true,
+        "claim":    claim,
+    })
+}
+
+// deleteDomainClaim releases a claim so the hostname can be claimed by
+// someone else
+func (h *Handlers) deleteDomainClaim(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    claim, ok := h.loadOwnDomainClaim(w, r)
+    if !ok {
+        return
+    }
+
+    if _, err := h.db.Exec(ctx, "DELETE FROM domain_claims WHERE id = $1", claim.ID); err != nil {
+        logger.Error("Deleting domain claim failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to delete domain claim")
+        return
+    }
+
+    // Record audit log
+    userID := getUserIDFromContext(ctx)
+    if err := h.recordAudit(ctx, userID, "delete", "domain_claim", claim.ID, claim, nil); err != nil {
+        logger.Error("Recording audit failed", "error", err)
+    }
+
+    w.WriteHeader(http.StatusOK)
+    json.NewEncoder(w).Encode(map[string]string{
+        "message": "Domain claim deleted successfully",
+    })
+}
+
+// loadOwnDomainClaim fetches the claim named in the URL, writing a 404 when
+// it does not exist or belongs to another user (admins see every claim)
+func (h *Handlers) loadOwnDomainClaim(w http.ResponseWriter, r *http.Request) (*db.DomainClaim, bool) {
+    ctx := r.Context()
+    claimID := chi.URLParam(r, "claimID")
+    userID := getUserIDFromContext(ctx)
+    isAdmin := middleware.GetRoleFromContext(ctx) == middleware.RoleAdmin
+
+    var c db.DomainClaim
+    err := h.db.QueryRow(ctx, `
+        SELECT id, user_id, hostname, token, verified_at, last_checked_at,
+               created_at, updated_at
+        FROM domain_claims
+        WHERE id = $1 AND (user_id = $2 OR $3::boolean)
+    `, claimID, userID, isAdmin).Scan(
+        &c.ID, &c.UserID, &c.Hostname, &c.Token, &c.VerifiedAt,
+        &c.LastCheckedAt, &c.CreatedAt, &c.UpdatedAt,
+    )
+    if err == pgx.ErrNoRows {
+        writeError(w, r, http.StatusNotFound, "Domain claim not found")
+        return nil, false
+    }
+    if err != nil {
+        logger.Error("Fetching domain claim failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch domain claim")
+        return nil, false
+    }
+    return &c, true
+}
+
+// checkHostnameClaim ensures the current user may serve a hostname, the
+// name of a domain or one of its aliases. Admins are exempt; everyone else
+// needs a verified claim.
+func (h *Handlers) checkHostnameClaim(ctx context.Context, host string) error {
+    if middleware.GetRoleFromContext(ctx) == middleware.RoleAdmin {
+        return nil
+    }
+
+    hostname := normalizeHostname(proxy.DomainKey(host))
+    var verified bool
+    err := h.db.QueryRow(ctx, `
+        SELECT EXISTS (
+            SELECT 1 FROM domain_claims
+            WHERE user_id = $1 AND hostname = $2 AND verified_at IS NOT NULL
+        )
+    `, getUserIDFromContext(ctx), hostname).Scan(&verified)
+    if err != nil {
+        return err
+    }
+    if !verified {
+        return fmt.Errorf("%w: %s", errHostnameNotVerified, hostname)
+    }
+    return nil
+}
+
+// writeClaimError maps checkHostnameClaim errors onto HTTP responses
+func writeClaimError(w http.ResponseWriter, r *http.Request, err error) {
+    if errors.Is(err, errHostnameNotVerified) {
+        writeErrorDetails(w, r, http.StatusForbidden, "hostname_not_verified", err.Error()+"; create and verify a domain claim first", nil)
+        return
+    }
+    logger.Error("Checking hostname claim failed", "error", err)
+    writeError(w, r, http.StatusInternalServerError, "Server error")
+}
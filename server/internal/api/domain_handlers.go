@@ -1,32 +1,41 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"fmt"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"viacortex/internal/db"
+	"viacortex/internal/proxy"
+	"viacortex/internal/webhooks"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v4"
 )
 
-// getDomains returns all domains with their associated backend servers
+// getDomains returns the domains visible to the user with their associated
+// backend servers
 func (h *Handlers) getDomains(w http.ResponseWriter, r *http.Request) {
     ctx := r.Context()
+    userID, isAdmin, orgID := domainVisibility(ctx)
     
     domains := []map[string]interface{}{}
     rows, err := h.db.Query(ctx, `
         SELECT 
             d.id, d.name, d.target_url, d.ssl_enabled, 
             d.health_check_enabled, d.health_check_interval,
-            d.custom_error_pages, d.created_at, d.updated_at
+            d.custom_error_pages, d.enabled, d.version, d.created_at, d.updated_at
         FROM domains d
+        WHERE `+visibleDomainsSQL("d.id", 1, 2, 3)+`
         ORDER BY d.name
-    `)
+    `, userID, isAdmin, orgID)
     if err != nil {
-        log.Printf("Error fetching domains: %v", err)
-        http.Error(w, "Failed to fetch domains", http.StatusInternalServerError)
+        logger.Error("Fetching domains failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch domains")
         return
     }
     defer rows.Close()
@@ -36,11 +45,11 @@ func (h *Handlers) getDomains(w http.ResponseWriter, r *http.Request) {
         err := rows.Scan(
             &d.ID, &d.Name, &d.TargetURL, &d.SSLEnabled,
             &d.HealthCheckEnabled, &d.HealthCheckInterval,
-            &d.CustomErrorPages, &d.CreatedAt, &d.UpdatedAt,
+            &d.CustomErrorPages, &d.Enabled, &d.Version, &d.CreatedAt, &d.UpdatedAt,
         )
         if err != nil {
-            log.Printf("Error scanning domain: %v", err)
-            http.Error(w, "Failed to scan domain", http.StatusInternalServerError)
+            logger.Error("Scanning domain failed", "error", err)
+            writeError(w, r, http.StatusInternalServerError, "Failed to scan domain")
             return
         }
         
@@ -51,7 +60,7 @@ func (h *Handlers) getDomains(w http.ResponseWriter, r *http.Request) {
             WHERE domain_id = $1
         `, d.ID)
         if err != nil {
-            log.Printf("Error fetching backend servers: %v", err)
+            logger.Error("Fetching backend servers failed", "error", err)
             continue
         }
         
@@ -64,7 +73,7 @@ func (h *Handlers) getDomains(w http.ResponseWriter, r *http.Request) {
                 &b.LastHealthCheck, &b.HealthStatus,
             )
             if err != nil {
-                log.Printf("Error scanning backend server: %v", err)
+                logger.Error("Scanning backend server failed", "error", err)
                 continue
             }
             backends = append(backends, b)
@@ -83,6 +92,244 @@ func (h *Handlers) getDomains(w http.ResponseWriter, r *http.Request) {
     json.NewEncoder(w).Encode(domains)
 }
 
+// getDomain returns a single domain by ID with its backends, IP rules,
+// rate limits, certificate status and last DNS check
+func (h *Handlers) getDomain(w http.ResponseWriter, r *http.Request) {
+    id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+    if err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid domain ID")
+        return
+    }
+    h.writeDomainDetail(w, r, "id = $1", id)
+}
+
+// getDomainByName returns a single domain looked up by its name or host
+func (h *Handlers) getDomainByName(w http.ResponseWriter, r *http.Request) {
+    name := chi.URLParam(r, "name")
+    h.writeDomainDetail(w, r,
+        "name = $1 OR target_url IN ($1, 'http://' || $1, 'https://' || $1, 'tcp://' || $1)", name)
+}
+
+func (h *Handlers) writeDomainDetail(w http.ResponseWriter, r *http.Request, where string, arg interface{}) {
+    ctx := r.Context()
+
+    detail, err := h.loadDomainDetail(ctx, where, arg)
+    if err == pgx.ErrNoRows {
+        writeError(w, r, http.StatusNotFound, "Domain not found")
+        return
+    }
+    if err != nil {
+        logger.Error("Fetching domain failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch domain")
+        return
+    }
+
+    d := detail["domain"].(db.Domain)
+    role, _, err := h.domainRole(ctx, d.ID)
+    if err != nil {
+        logger.Error("Checking domain access failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    if role == "" {
+        writeError(w, r, http.StatusNotFound, "Domain not found")
+        return
+    }
+
+    etag := domainETag(d.ID, d.Version)
+    w.Header().Set("ETag", etag)
+    if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, etag) {
+        w.WriteHeader(http.StatusNotModified)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(detail)
+}
+
+// loadDomainDetail loads the first domain matching where together with all
+// of its associated configuration
+func (h *Handlers) loadDomainDetail(ctx context.Context, where string, arg interface{}) (map[string]interface{}, error) {
+    var d db.Domain
+    err := h.db.QueryRow(ctx, `
+        SELECT id, name, target_url, ssl_enabled,
+            health_check_enabled, health_check_interval,
+            custom_error_pages, enabled, version, created_at, updated_at
+        FROM domains
+        WHERE `+where+`
+        ORDER BY id
+        LIMIT 1
+    `, arg).Scan(
+        &d.ID, &d.Name, &d.TargetURL, &d.SSLEnabled,
+        &d.HealthCheckEnabled, &d.HealthCheckInterval,
+        &d.CustomErrorPages, &d.Enabled, &d.Version, &d.CreatedAt, &d.UpdatedAt,
+    )
+    if err != nil {
+        return nil, err
+    }
+
+    backends, err := h.domainBackends(ctx, d.ID)
+    if err != nil {
+        return nil, err
+    }
+    rules, err := h.domainIPRules(ctx, d.ID)
+    if err != nil {
+        return nil, err
+    }
+    limits, err := h.domainRateLimits(ctx, d.ID)
+    if err != nil {
+        return nil, err
+    }
+
+    detail := map[string]interface{}{
+        "domain":          d,
+        "backend_servers": backends,
+        "ip_rules":        rules,
+        "rate_limits":     limits,
+    }
+
+    if h.proxy != nil && d.SSLEnabled {
+        key := proxy.HostKey(d.Name)
+        detail["certificate"] = h.proxy.CertificateStatus(ctx, key)
+        if status, ok := h.proxy.LastDNSStatus(key); ok {
+            detail["dns"] = status
+        }
+    }
+
+    return detail, nil
+}
+
+// domainBackends returns the backend servers of a domain
+func (h *Handlers) domainBackends(ctx context.Context, domainID int64) ([]db.BackendServer, error) {
+    rows, err := h.db.Query(ctx, `
+        SELECT id, domain_id, scheme, ip, port, weight, is_active, last_health_check,
+               health_status, created_at, updated_at
+        FROM backend_servers
+        WHERE domain_id = $1
+        ORDER BY id
+    `, domainID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    backends := []db.BackendServer{}
+    for rows.Next() {
+        var b db.BackendServer
+        if err := rows.Scan(
+            &b.ID, &b.DomainID, &b.Scheme, &b.IP, &b.Port, &b.Weight, &b.IsActive,
+            &b.LastHealthCheck, &b.HealthStatus, &b.CreatedAt, &b.UpdatedAt,
+        ); err != nil {
+            logger.Error("Scanning backend server failed", "error", err)
+            continue
+        }
+        backends = append(backends, b)
+    }
+    return backends, rows.Err()
+}
+
+// domainIPRules returns the IP rules of a domain, newest first
+func (h *Handlers) domainIPRules(ctx context.Context, domainID int64) ([]db.IPRule, error) {
+    rows, err := h.db.Query(ctx, `
+        SELECT id, domain_id, ip_range, rule_type, description, created_at, updated_at
+        FROM ip_rules
+        WHERE domain_id = $1
+        ORDER BY created_at DESC
+    `, domainID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    rules := []db.IPRule{}
+    for rows.Next() {
+        var rule db.IPRule
+        if err := rows.Scan(
+            &rule.ID, &rule.DomainID, &rule.IPRange, &rule.RuleType,
+            &rule.Description, &rule.CreatedAt, &rule.UpdatedAt,
+        ); err != nil {
+            logger.Error("Scanning IP rule failed", "error", err)
+            continue
+        }
+        rules = append(rules, rule)
+    }
+    return rules, rows.Err()
+}
+
+// domainRateLimits returns the rate limits of a domain, newest first
+func (h *Handlers) domainRateLimits(ctx context.Context, domainID int64) ([]db.RateLimit, error) {
+    rows, err := h.db.Query(ctx, `
+        SELECT id, domain_id, requests_per_second, burst_size, per_ip, created_at, updated_at
+        FROM rate_limits
+        WHERE domain_id = $1
+        ORDER BY created_at DESC
+    `, domainID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    limits := []db.RateLimit{}
+    for rows.Next() {
+        var limit db.RateLimit
+        if err := rows.Scan(
+            &limit.ID, &limit.DomainID, &limit.RequestsPerSecond, &limit.BurstSize,
+            &limit.PerIP, &limit.CreatedAt, &limit.UpdatedAt,
+        ); err != nil {
+            logger.Error("Scanning rate limit failed", "error", err)
+            continue
+        }
+        limits = append(limits, limit)
+    }
+    return limits, rows.Err()
+}
+
+// domainSnapshot returns a domain with its backends, IP rules and rate limits
+// as the before or after state of an audit entry
+func (h *Handlers) domainSnapshot(ctx context.Context, id int64) (map[string]interface{}, error) {
+    detail, err := h.loadDomainDetail(ctx, "id = $1", id)
+    if err != nil {
+        return nil, err
+    }
+    // Live status is not part of the configuration
+    delete(detail, "certificate")
+    delete(detail, "dns")
+    return detail, nil
+}
+
+// checkDomainDNS runs the DNS pre-check for a domain on demand so users can
+// verify their records before enabling SSL
+func (h *Handlers) checkDomainDNS(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+    if err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid domain ID")
+        return
+    }
+
+    if h.proxy == nil {
+        writeError(w, r, http.StatusServiceUnavailable, "Proxy not available")
+        return
+    }
+
+    var name string
+    err = h.db.QueryRow(ctx, "SELECT name FROM domains WHERE id = $1", id).Scan(&name)
+    if err == pgx.ErrNoRows {
+        writeError(w, r, http.StatusNotFound, "Domain not found")
+        return
+    }
+    if err != nil {
+        logger.Error("Fetching domain failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch domain")
+        return
+    }
+
+    status := h.proxy.CheckDNS(ctx, proxy.HostKey(name))
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(status)
+}
+
 // createDomain creates a new domain with optional backend servers
 func (h *Handlers) createDomain(w http.ResponseWriter, r *http.Request) {
     ctx := r.Context()
@@ -91,35 +338,61 @@ func (h *Handlers) createDomain(w http.ResponseWriter, r *http.Request) {
         BackendServers []db.BackendServer `json:"backend_servers"`
     }
 
+    // Domains are enabled unless the request says otherwise
+    req.Domain.Enabled = true
     if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-        http.Error(w, "Invalid request body", http.StatusBadRequest)
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+
+    if err := h.checkHostnameClaim(ctx, req.Domain.Name); err != nil {
+        writeClaimError(w, r, err)
+        return
+    }
+    if !checkOrgRole(w, r, orgRoleMember) {
         return
     }
 
     // Start transaction
     tx, err := h.db.Begin(ctx)
     if err != nil {
-        log.Printf("Error starting transaction: %v", err)
-        http.Error(w, "Server error", http.StatusInternalServerError)
+        logger.Error("Starting transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
         return
     }
     defer tx.Rollback(ctx)
 
+    owner := requestTenant(ctx)
+    if err := h.checkQuota(ctx, tx, owner, quotaDomains, 1); err != nil {
+        writeQuotaError(w, r, err)
+        return
+    }
+    if err := h.checkQuota(ctx, tx, owner, quotaBackends, len(req.BackendServers)); err != nil {
+        writeQuotaError(w, r, err)
+        return
+    }
+
     // Insert domain
     var domainID int64
     err = tx.QueryRow(ctx, `
         INSERT INTO domains (
             name, target_url, ssl_enabled, health_check_enabled,
-            health_check_interval, custom_error_pages
-        ) VALUES ($1, $2, $3, $4, $5, $6)
+            health_check_interval, custom_error_pages, enabled, org_id
+        ) VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8::integer, 0))
         RETURNING id
     `, req.Domain.Name, req.Domain.TargetURL, req.Domain.SSLEnabled,
        req.Domain.HealthCheckEnabled, req.Domain.HealthCheckInterval,
-       req.Domain.CustomErrorPages).Scan(&domainID)
+       req.Domain.CustomErrorPages, req.Domain.Enabled, selectedOrg(ctx).ID).Scan(&domainID)
 
     if err != nil {
-        log.Printf("Error creating domain: %v", err)
-        http.Error(w, "Failed to create domain", http.StatusInternalServerError)
+        logger.Error("Creating domain failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to create domain")
+        return
+    }
+
+    if err := addDomainOwner(ctx, tx, domainID, getUserIDFromContext(ctx)); err != nil {
+        logger.Error("Adding domain owner failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to create domain")
         return
     }
 
@@ -132,15 +405,15 @@ func (h *Handlers) createDomain(w http.ResponseWriter, r *http.Request) {
 		`, domainID, backend.Scheme, backend.IP.String(), backend.Port, backend.Weight, backend.IsActive, "healthy")
 
         if err != nil {
-            log.Printf("Error creating backend server: %v", err)
-            http.Error(w, "Failed to create backend servers", http.StatusInternalServerError)
+            logger.Error("Creating backend server failed", "error", err)
+            writeError(w, r, http.StatusInternalServerError, "Failed to create backend servers")
             return
         }
     }
 
     if err := tx.Commit(ctx); err != nil {
-        log.Printf("Error committing transaction: %v", err)
-        http.Error(w, "Server error", http.StatusInternalServerError)
+        logger.Error("Committing transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
         return
     }
 
@@ -149,18 +422,19 @@ func (h *Handlers) createDomain(w http.ResponseWriter, r *http.Request) {
     err = h.db.QueryRow(ctx, `
         SELECT id, name, target_url, ssl_enabled, 
             health_check_enabled, health_check_interval,
-            custom_error_pages, created_at, updated_at
+            custom_error_pages, enabled, version, created_at, updated_at
         FROM domains 
         WHERE id = $1
     `, domainID).Scan(
         &createdDomain.ID, &createdDomain.Name, &createdDomain.TargetURL,
         &createdDomain.SSLEnabled, &createdDomain.HealthCheckEnabled,
         &createdDomain.HealthCheckInterval, &createdDomain.CustomErrorPages,
-        &createdDomain.CreatedAt, &createdDomain.UpdatedAt,
+        &createdDomain.Enabled, &createdDomain.Version, &createdDomain.CreatedAt,
+        &createdDomain.UpdatedAt,
     )
     if err != nil {
-        log.Printf("Error fetching created domain: %v", err)
-        http.Error(w, "Server error", http.StatusInternalServerError)
+        logger.Error("Fetching created domain failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
         return
     }
 
@@ -169,7 +443,15 @@ func (h *Handlers) createDomain(w http.ResponseWriter, r *http.Request) {
         "domain":          createdDomain,
         "backend_servers": req.BackendServers,
     }
+    h.webhooks.Emit(webhooks.EventDomainCreated, response)
 
+    // Record audit log
+    after, _ := h.domainSnapshot(ctx, domainID)
+    if err := h.recordAudit(ctx, getUserIDFromContext(ctx), "create", "domain", domainID, nil, after); err != nil {
+        logger.Error("Recording audit failed", "error", err)
+    }
+
+    w.Header().Set("ETag", domainETag(createdDomain.ID, createdDomain.Version))
     w.WriteHeader(http.StatusCreated)
     json.NewEncoder(w).Encode(response)
 }
@@ -184,19 +466,52 @@ func (h *Handlers) updateDomain(w http.ResponseWriter, r *http.Request) {
         BackendServers []db.BackendServer `json:"backend_servers"`
     }
 
+    // Clients that predate the enabled flag do not send it
+    req.Domain.Enabled = true
     if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-        http.Error(w, "Invalid request body", http.StatusBadRequest)
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+
+    if err := h.checkHostnameClaim(ctx, req.Domain.Name); err != nil {
+        writeClaimError(w, r, err)
+        return
+    }
+
+    before, err := h.domainSnapshot(ctx, mustParseInt64(domainID))
+    if err == pgx.ErrNoRows {
+        writeError(w, r, http.StatusNotFound, "Domain not found")
+        return
+    }
+    if err != nil {
+        logger.Error("Fetching domain failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
         return
     }
 
     tx, err := h.db.Begin(ctx)
     if err != nil {
-        log.Printf("Error starting transaction: %v", err)
-        http.Error(w, "Server error", http.StatusInternalServerError)
+        logger.Error("Starting transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
         return
     }
     defer tx.Rollback(ctx)
 
+    version, err := lockDomainVersion(ctx, tx, mustParseInt64(domainID))
+    if err == pgx.ErrNoRows {
+        writeError(w, r, http.StatusNotFound, "Domain not found")
+        return
+    }
+    if err != nil {
+        logger.Error("Locking domain failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    if err := checkIfMatch(r, mustParseInt64(domainID), version); err != nil {
+        writePreconditionFailed(w, r, mustParseInt64(domainID), version)
+        return
+    }
+
     // Update domain
     _, err = tx.Exec(ctx, `
         UPDATE domains SET
@@ -206,53 +521,528 @@ func (h *Handlers) updateDomain(w http.ResponseWriter, r *http.Request) {
             health_check_enabled = $4,
             health_check_interval = $5,
             custom_error_pages = $6,
+            enabled = $7,
             updated_at = CURRENT_TIMESTAMP
-        WHERE id = $7
+        WHERE id = $8
     `, req.Domain.Name, req.Domain.TargetURL, req.Domain.SSLEnabled,
        req.Domain.HealthCheckEnabled, req.Domain.HealthCheckInterval,
-       req.Domain.CustomErrorPages, domainID)
+       req.Domain.CustomErrorPages, req.Domain.Enabled, domainID)
 
     if err != nil {
-        log.Printf("Error updating domain: %v", err)
-        http.Error(w, "Failed to update domain", http.StatusInternalServerError)
+        logger.Error("Updating domain failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to update domain")
         return
     }
 
-    // Delete existing backend servers
-    _, err = tx.Exec(ctx, "DELETE FROM backend_servers WHERE domain_id = $1", domainID)
-    if err != nil {
-        log.Printf("Error deleting backend servers: %v", err)
-        http.Error(w, "Failed to update backend servers", http.StatusInternalServerError)
+    if err := h.checkBackendQuota(ctx, tx, mustParseInt64(domainID), len(req.BackendServers)); err != nil {
+        writeQuotaError(w, r, err)
         return
     }
 
-    // Insert new backend servers
-    for _, backend := range req.BackendServers {
-        _, err := tx.Exec(ctx, `
-            INSERT INTO backend_servers (
-				domain_id, scheme, ip, port, weight, is_active, health_status
-			) VALUES ($1, $2, $3::inet, $4, $5, $6, $7)
-		`, domainID, backend.Scheme, backend.IP.String(), backend.Port, backend.Weight, backend.IsActive, "healthy")
-		
-        if err != nil {
-            log.Printf("Error creating backend server: %v", err)
-            http.Error(w, "Failed to create backend servers", http.StatusInternalServerError)
-            return
-        }
+    // Reconcile backend servers by ID so unchanged backends keep their
+    // identity and health history
+    if err := reconcileBackends(ctx, tx, mustParseInt64(domainID), req.BackendServers); err != nil {
+        writeReconcileError(w, r, err)
+        return
     }
 
     if err := tx.Commit(ctx); err != nil {
-        log.Printf("Error committing transaction: %v", err)
-        http.Error(w, "Server error", http.StatusInternalServerError)
+        logger.Error("Committing transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
         return
     }
 
+    h.webhooks.Emit(webhooks.EventDomainUpdated, map[string]interface{}{
+        "id":              mustParseInt64(domainID),
+        "domain":          req.Domain,
+        "backend_servers": req.BackendServers,
+    })
+
+    // Record audit log
+    after, _ := h.domainSnapshot(ctx, mustParseInt64(domainID))
+    if err := h.recordAudit(ctx, getUserIDFromContext(ctx), "update", "domain",
+        mustParseInt64(domainID), before, after); err != nil {
+        logger.Error("Recording audit failed", "error", err)
+    }
+
+    h.setDomainETag(ctx, w, mustParseInt64(domainID))
     w.WriteHeader(http.StatusOK)
     json.NewEncoder(w).Encode(map[string]string{
         "message": "Domain updated successfully",
     })
 }
 
+// upsertDomainByName creates or replaces the domain with the given name and
+// its backends in one idempotent call, for declarative automation. Repeating
+// the same request changes nothing. If-Match guards against overwriting a
+// concurrent change and If-None-Match: * restricts the call to creation.
+func (h *Handlers) upsertDomainByName(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    name := chi.URLParam(r, "name")
+
+    var req struct {
+        Domain         db.Domain         `json:"domain"`
+        BackendServers []db.BackendServer `json:"backend_servers"`
+    }
+
+    req.Domain.Enabled = true
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+    if req.Domain.Name == "" {
+        req.Domain.Name = name
+    }
+    if req.Domain.Name != name {
+        writeError(w, r, http.StatusBadRequest, "Domain name in the body does not match the URL")
+        return
+    }
+
+    if err := h.checkHostnameClaim(ctx, req.Domain.Name); err != nil {
+        writeClaimError(w, r, err)
+        return
+    }
+
+    tx, err := h.db.Begin(ctx)
+    if err != nil {
+        logger.Error("Starting transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    defer tx.Rollback(ctx)
+
+    var domainID, version int64
+    err = tx.QueryRow(ctx, "SELECT id, version FROM domains WHERE name = $1 FOR UPDATE", name).Scan(&domainID, &version)
+    exists := err == nil
+    if err != nil && err != pgx.ErrNoRows {
+        logger.Error("Fetching domain failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+
+    if exists {
+        role, _, err := h.domainRole(ctx, domainID)
+        if err != nil {
+            logger.Error("Checking domain access failed", "error", err)
+            writeError(w, r, http.StatusInternalServerError, "Server error")
+            return
+        }
+        if domainRoleRank[role] < domainRoleRank[domainRoleEditor] {
+            writeError(w, r, http.StatusForbidden, "Domain is managed by another user")
+            return
+        }
+
+        if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, domainETag(domainID, version)) {
+            writePreconditionFailed(w, r, domainID, version)
+            return
+        }
+        if err := checkIfMatch(r, domainID, version); err != nil {
+            writePreconditionFailed(w, r, domainID, version)
+            return
+        }
+    } else if r.Header.Get("If-Match") != "" {
+        writeError(w, r, http.StatusPreconditionFailed, "Domain not found")
+        return
+    } else if !checkOrgRole(w, r, orgRoleMember) {
+        return
+    } else if err := h.checkQuota(ctx, tx, requestTenant(ctx), quotaDomains, 1); err != nil {
+        writeQuotaError(w, r, err)
+        return
+    }
+
+    var before map[string]interface{}
+    if exists {
+        if before, err = h.domainSnapshot(ctx, domainID); err != nil {
+            logger.Error("Fetching domain failed", "error", err)
+            writeError(w, r, http.StatusInternalServerError, "Server error")
+            return
+        }
+
+        _, err = tx.Exec(ctx, `
+            UPDATE domains SET
+                target_url = $1,
+                ssl_enabled = $2,
+                health_check_enabled = $3,
+                health_check_interval = $4,
+                custom_error_pages = $5,
+                enabled = $6
+            WHERE id = $7
+        `, req.Domain.TargetURL, req.Domain.SSLEnabled,
+            req.Domain.HealthCheckEnabled, req.Domain.HealthCheckInterval,
+            req.Domain.CustomErrorPages, req.Domain.Enabled, domainID)
+    } else {
+        err = tx.QueryRow(ctx, `
+            INSERT INTO domains (
+                name, target_url, ssl_enabled, health_check_enabled,
+                health_check_interval, custom_error_pages, enabled, org_id
+            ) VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8::integer, 0))
+            RETURNING id
+        `, name, req.Domain.TargetURL, req.Domain.SSLEnabled,
+            req.Domain.HealthCheckEnabled, req.Domain.HealthCheckInterval,
+            req.Domain.CustomErrorPages, req.Domain.Enabled, selectedOrg(ctx).ID).Scan(&domainID)
+        if err == nil {
+            err = addDomainOwner(ctx, tx, domainID, getUserIDFromContext(ctx))
+        }
+    }
+    if err != nil {
+        if strings.Contains(err.Error(), "domains_name_key") {
+            // Created concurrently by another request
+            writeError(w, r, http.StatusConflict, "Domain was created concurrently, retry")
+            return
+        }
+        logger.Error("Upserting domain failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to save domain")
+        return
+    }
+
+    if err := h.checkBackendQuota(ctx, tx, domainID, len(req.BackendServers)); err != nil {
+        writeQuotaError(w, r, err)
+        return
+    }
+    if err := reconcileBackends(ctx, tx, domainID, req.BackendServers); err != nil {
+        writeReconcileError(w, r, err)
+        return
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        logger.Error("Committing transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+
+    detail, err := h.loadDomainDetail(ctx, "id = $1", domainID)
+    if err != nil {
+        logger.Error("Fetching saved domain failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    saved := detail["domain"].(db.Domain)
+
+    status := http.StatusOK
+    if !exists {
+        status = http.StatusCreated
+        h.webhooks.Emit(webhooks.EventDomainCreated, detail)
+    } else if saved.Version != version {
+        h.webhooks.Emit(webhooks.EventDomainUpdated, detail)
+    }
+
+    // Record audit log, unless the request was a no-op
+    if !exists || saved.Version != version {
+        action := "update"
+        if !exists {
+            action = "create"
+        }
+        after, _ := h.domainSnapshot(ctx, domainID)
+        if err := h.recordAudit(ctx, getUserIDFromContext(ctx), action, "domain", domainID, before, after); err != nil {
+            logger.Error("Recording audit failed", "error", err)
+        }
+    }
+
+    w.Header().Set("ETag", domainETag(saved.ID, saved.Version))
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(detail)
+}
+
+// domainPatch holds the domain fields a PATCH may change; nil fields are
+// left untouched
+type domainPatch struct {
+    Name                *string          `json:"name"`
+    TargetURL           *string          `json:"target_url"`
+    SSLEnabled          *bool            `json:"ssl_enabled"`
+    HealthCheckEnabled  *bool            `json:"health_check_enabled"`
+    HealthCheckInterval *int             `json:"health_check_interval"`
+    CustomErrorPages    *json.RawMessage `json:"custom_error_pages"`
+    Enabled             *bool            `json:"enabled"`
+}
+
+// patchDomain partially updates a domain. Only supplied fields change, and
+// backends are only reconciled when backend_servers is present.
+func (h *Handlers) patchDomain(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+    if err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid domain ID")
+        return
+    }
+
+    var req struct {
+        Domain         domainPatch         `json:"domain"`
+        BackendServers *[]db.BackendServer `json:"backend_servers"`
+    }
+
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+
+    if req.Domain.Name != nil {
+        if err := h.checkHostnameClaim(ctx, *req.Domain.Name); err != nil {
+            writeClaimError(w, r, err)
+            return
+        }
+    }
+
+    before, err := h.domainSnapshot(ctx, id)
+    if err == pgx.ErrNoRows {
+        writeError(w, r, http.StatusNotFound, "Domain not found")
+        return
+    }
+    if err != nil {
+        logger.Error("Fetching domain failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+
+    tx, err := h.db.Begin(ctx)
+    if err != nil {
+        logger.Error("Starting transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    defer tx.Rollback(ctx)
+
+    version, err := lockDomainVersion(ctx, tx, id)
+    if err == pgx.ErrNoRows {
+        writeError(w, r, http.StatusNotFound, "Domain not found")
+        return
+    }
+    if err != nil {
+        logger.Error("Locking domain failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    if err := checkIfMatch(r, id, version); err != nil {
+        writePreconditionFailed(w, r, id, version)
+        return
+    }
+
+    result, err := tx.Exec(ctx, `
+        UPDATE domains SET
+            name = COALESCE($1, name),
+            target_url = COALESCE($2, target_url),
+            ssl_enabled = COALESCE($3, ssl_enabled),
+            health_check_enabled = COALESCE($4, health_check_enabled),
+            health_check_interval = COALESCE($5, health_check_interval),
+            custom_error_pages = COALESCE($6, custom_error_pages),
+            enabled = COALESCE($7, enabled),
+            updated_at = CURRENT_TIMESTAMP
+        WHERE id = $8
+    `, req.Domain.Name, req.Domain.TargetURL, req.Domain.SSLEnabled,
+       req.Domain.HealthCheckEnabled, req.Domain.HealthCheckInterval,
+       req.Domain.CustomErrorPages, req.Domain.Enabled, id)
+    if err != nil {
+        logger.Error("Patching domain failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to update domain")
+        return
+    }
+
+    if rowsAffected := result.RowsAffected(); rowsAffected == 0 {
+        writeError(w, r, http.StatusNotFound, "Domain not found")
+        return
+    }
+
+    if req.BackendServers != nil {
+        if err := h.checkBackendQuota(ctx, tx, id, len(*req.BackendServers)); err != nil {
+            writeQuotaError(w, r, err)
+            return
+        }
+        if err := reconcileBackends(ctx, tx, id, *req.BackendServers); err != nil {
+            writeReconcileError(w, r, err)
+            return
+        }
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        logger.Error("Committing transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+
+    detail, err := h.loadDomainDetail(ctx, "id = $1", id)
+    if err != nil {
+        logger.Error("Fetching patched domain failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+
+    h.webhooks.Emit(webhooks.EventDomainUpdated, detail)
+
+    // Record audit log
+    after, _ := h.domainSnapshot(ctx, id)
+    if err := h.recordAudit(ctx, getUserIDFromContext(ctx), "update", "domain", id, before, after); err != nil {
+        logger.Error("Recording audit failed", "error", err)
+    }
+
+    patched := detail["domain"].(db.Domain)
+    w.Header().Set("ETag", domainETag(patched.ID, patched.Version))
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(detail)
+}
+
+// setDomainEnabled returns a handler that pauses or resumes a domain. A
+// paused domain keeps its configuration and certificates but the proxy
+// answers its requests with 503.
+func (h *Handlers) setDomainEnabled(enabled bool) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        ctx := r.Context()
+        id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+        if err != nil {
+            writeError(w, r, http.StatusBadRequest, "Invalid domain ID")
+            return
+        }
+
+        before, err := snapshotEntity(ctx, h.db, "domains", id)
+        if err == pgx.ErrNoRows {
+            writeError(w, r, http.StatusNotFound, "Domain not found")
+            return
+        }
+        if err != nil {
+            logger.Error("Fetching domain failed", "error", err)
+            writeError(w, r, http.StatusInternalServerError, "Failed to update domain")
+            return
+        }
+
+        result, err := h.db.Exec(ctx, `
+            UPDATE domains SET enabled = $1, updated_at = CURRENT_TIMESTAMP
+            WHERE id = $2
+        `, enabled, id)
+        if err != nil {
+            logger.Error("Updating domain failed", "error", err)
+            writeError(w, r, http.StatusInternalServerError, "Failed to update domain")
+            return
+        }
+
+        if rowsAffected := result.RowsAffected(); rowsAffected == 0 {
+            writeError(w, r, http.StatusNotFound, "Domain not found")
+            return
+        }
+
+        action := "disable"
+        if enabled {
+            action = "enable"
+        }
+
+        // Record audit log
+        userID := getUserIDFromContext(ctx)
+        after, _ := snapshotEntity(ctx, h.db, "domains", id)
+        if err := h.recordAudit(ctx, userID, action, "domain", id, before, after); err != nil {
+            logger.Error("Recording audit failed", "error", err)
+        }
+
+        h.webhooks.Emit(webhooks.EventDomainUpdated, map[string]interface{}{
+            "id":      id,
+            "enabled": enabled,
+        })
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(map[string]interface{}{
+            "id":      id,
+            "enabled": enabled,
+        })
+    }
+}
+
+// errForeignBackend is returned when a request references a backend ID that
+// belongs to a different domain
+type errForeignBackend struct {
+    id int64
+}
+
+func (e errForeignBackend) Error() string {
+    return fmt.Sprintf("backend server %d does not belong to this domain", e.id)
+}
+
+// backendAddress identifies a backend by its scheme, IP and port
+func backendAddress(scheme, ip string, port int) string {
+    return scheme + "://" + net.JoinHostPort(ip, strconv.Itoa(port))
+}
+
+// reconcileBackends makes the domain's backends match the given list:
+// entries with an ID are updated in place, entries without one reuse an
+// existing backend with the same scheme, IP and port or are added, and
+// existing backends missing from the list are removed. Matching by address
+// keeps repeated declarative updates from recreating unchanged backends.
+func reconcileBackends(ctx context.Context, tx pgx.Tx, domainID int64, backends []db.BackendServer) error {
+    rows, err := tx.Query(ctx, "SELECT id, scheme, host(ip), port FROM backend_servers WHERE domain_id = $1", domainID)
+    if err != nil {
+        return err
+    }
+    existing := map[int64]bool{}
+    byAddress := map[string]int64{}
+    for rows.Next() {
+        var id int64
+        var scheme, ip string
+        var port int
+        if err := rows.Scan(&id, &scheme, &ip, &port); err != nil {
+            rows.Close()
+            return err
+        }
+        existing[id] = true
+        byAddress[backendAddress(scheme, ip, port)] = id
+    }
+    rows.Close()
+
+    keep := map[int64]bool{}
+    for _, backend := range backends {
+        if backend.Weight < 1 {
+            backend.Weight = 1
+        }
+
+        if backend.ID == 0 {
+            if id, ok := byAddress[backendAddress(backend.Scheme, backend.IP.String(), backend.Port)]; ok && !keep[id] {
+                backend.ID = id
+            }
+        }
+
+        if backend.ID != 0 {
+            if !existing[backend.ID] {
+                return errForeignBackend{id: backend.ID}
+            }
+            keep[backend.ID] = true
+
+            if _, err := tx.Exec(ctx, `
+                UPDATE backend_servers
+                SET scheme = $1, ip = $2::inet, port = $3, weight = $4, is_active = $5
+                WHERE id = $6
+            `, backend.Scheme, backend.IP.String(), backend.Port, backend.Weight,
+                backend.IsActive, backend.ID); err != nil {
+                return err
+            }
+            continue
+        }
+
+        if _, err := tx.Exec(ctx, `
+            INSERT INTO backend_servers (
+                domain_id, scheme, ip, port, weight, is_active, health_status
+            ) VALUES ($1, $2, $3::inet, $4, $5, $6, $7)
+        `, domainID, backend.Scheme, backend.IP.String(), backend.Port,
+            backend.Weight, backend.IsActive, "healthy"); err != nil {
+            return err
+        }
+    }
+
+    for id := range existing {
+        if keep[id] {
+            continue
+        }
+        if _, err := tx.Exec(ctx, "DELETE FROM backend_servers WHERE id = $1", id); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+func writeReconcileError(w http.ResponseWriter, r *http.Request, err error) {
+    if _, ok := err.(errForeignBackend); ok {
+        writeError(w, r, http.StatusBadRequest, err.Error())
+        return
+    }
+    logger.Error("Reconciling backend servers failed", "error", err)
+    writeError(w, r, http.StatusInternalServerError, "Failed to update backend servers")
+}
+
 // deleteDomain deletes a domain and all associated data
 func (h *Handlers) deleteDomain(w http.ResponseWriter, r *http.Request) {
     ctx := r.Context()
@@ -260,15 +1050,26 @@ func (h *Handlers) deleteDomain(w http.ResponseWriter, r *http.Request) {
 
     id, err := strconv.ParseInt(domainID, 10, 64)
     if err != nil {
-        http.Error(w, "Invalid domain ID", http.StatusBadRequest)
+        writeError(w, r, http.StatusBadRequest, "Invalid domain ID")
+        return
+    }
+
+    before, err := h.domainSnapshot(ctx, id)
+    if err == pgx.ErrNoRows {
+        writeError(w, r, http.StatusNotFound, "Domain not found")
+        return
+    }
+    if err != nil {
+        logger.Error("Fetching domain failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
         return
     }
 
     // Start transaction
     tx, err := h.db.Begin(ctx)
     if err != nil {
-        log.Printf("Error starting transaction: %v", err)
-        http.Error(w, "Server error", http.StatusInternalServerError)
+        logger.Error("Starting transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
         return
     }
     defer tx.Rollback(ctx)
@@ -278,8 +1079,8 @@ func (h *Handlers) deleteDomain(w http.ResponseWriter, r *http.Request) {
     for _, table := range tables {
         _, err := tx.Exec(ctx, "DELETE FROM "+table+" WHERE domain_id = $1", id)
         if err != nil {
-            log.Printf("Error deleting from %s: %v", table, err)
-            http.Error(w, "Server error", http.StatusInternalServerError)
+            logger.Error("Deleting domain rows failed", "table", table, "error", err)
+            writeError(w, r, http.StatusInternalServerError, "Server error")
             return
         }
     }
@@ -287,22 +1088,29 @@ func (h *Handlers) deleteDomain(w http.ResponseWriter, r *http.Request) {
     // Delete the domain
     result, err := tx.Exec(ctx, "DELETE FROM domains WHERE id = $1", id)
     if err != nil {
-        log.Printf("Error deleting domain: %v", err)
-        http.Error(w, "Server error", http.StatusInternalServerError)
+        logger.Error("Deleting domain failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
         return
     }
 
     if rowsAffected := result.RowsAffected(); rowsAffected == 0 {
-        http.Error(w, "Domain not found", http.StatusNotFound)
+        writeError(w, r, http.StatusNotFound, "Domain not found")
         return
     }
 
     if err := tx.Commit(ctx); err != nil {
-        log.Printf("Error committing transaction: %v", err)
-        http.Error(w, "Server error", http.StatusInternalServerError)
+        logger.Error("Committing transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
         return
     }
 
+    h.webhooks.Emit(webhooks.EventDomainDeleted, map[string]interface{}{"id": id})
+
+    // Record audit log
+    if err := h.recordAudit(ctx, getUserIDFromContext(ctx), "delete", "domain", id, before, nil); err != nil {
+        logger.Error("Recording audit failed", "error", err)
+    }
+
     w.WriteHeader(http.StatusOK)
     json.NewEncoder(w).Encode(map[string]string{
         "message": "Domain deleted successfully",
@@ -0,0 +1,196 @@
+package api
+
+import (
+    "context"
+    "net"
+    "reflect"
+    "strings"
+    "testing"
+
+    "viacortex/internal/db"
+
+    "github.com/jackc/pgconn"
+    "github.com/jackc/pgx/v4"
+)
+
+// backendRow is a backend_servers row as the fake transaction stores it
+type backendRow struct {
+    domainID int64
+    scheme   string
+    ip       string
+    port     int
+    weight   int
+    active   bool
+}
+
+// backendTx is a pgx.Tx over an in-memory backend_servers table that
+// understands the statements reconcileBackends sends
+type backendTx struct {
+    pgx.Tx
+    rows   map[int64]backendRow
+    nextID int64
+}
+
+func (tx *backendTx) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+    domainID := args[0].(int64)
+    result := &backendRows{}
+    for id, row := range tx.rows {
+        if row.domainID == domainID {
+            result.rows = append(result.rows, []interface{}{id, row.scheme, row.ip, row.port})
+        }
+    }
+    return result, nil
+}
+
+func (tx *backendTx) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+    switch sql = strings.TrimSpace(sql); {
+    case strings.HasPrefix(sql, "UPDATE"):
+        id := args[5].(int64)
+        row := tx.rows[id]
+        row.scheme, row.ip, row.port = args[0].(string), args[1].(string), args[2].(int)
+        row.weight, row.active = args[3].(int), args[4].(bool)
+        tx.rows[id] = row
+    case strings.HasPrefix(sql, "INSERT"):
+        tx.nextID++
+        tx.rows[tx.nextID] = backendRow{
+            domainID: args[0].(int64), scheme: args[1].(string), ip: args[2].(string),
+            port: args[3].(int), weight: args[4].(int), active: args[5].(bool),
+        }
+    case strings.HasPrefix(sql, "DELETE"):
+        delete(tx.rows, args[0].(int64))
+    }
+    return nil, nil
+}
+
+type backendRows struct {
+    pgx.Rows
+    rows [][]interface{}
+    pos  int
+}
+
+func (r *backendRows) Next() bool {
+    r.pos++
+    return r.pos <= len(r.rows)
+}
+
+func (r *backendRows) Scan(dest ...interface{}) error {
+    row := r.rows[r.pos-1]
+    *dest[0].(*int64) = row[0].(int64)
+    *dest[1].(*string) = row[1].(string)
+    *dest[2].(*string) = row[2].(string)
+    *dest[3].(*int) = row[3].(int)
+    return nil
+}
+
+func (r *backendRows) Close() {}
+
+func TestReconcileBackends(t *testing.T) {
+    const domainID = 1
+    backend := func(id int64, scheme, ip string, port, weight int) db.BackendServer {
+        return db.BackendServer{ID: id, Scheme: scheme, IP: net.ParseIP(ip), Port: port, Weight: weight, IsActive: true}
+    }
+    row := func(scheme, ip string, port, weight int) backendRow {
+        return backendRow{domainID: domainID, scheme: scheme, ip: ip, port: port, weight: weight, active: true}
+    }
+    initial := func() map[int64]backendRow {
+        return map[int64]backendRow{
+            1: row("http", "10.0.0.1", 8080, 1),
+            2: row("http", "10.0.0.2", 8080, 1),
+            3: {domainID: 2, scheme: "http", ip: "10.0.0.9", port: 80, weight: 1, active: true},
+        }
+    }
+
+    tests := []struct {
+        name     string
+        backends []db.BackendServer
+        want     map[int64]backendRow
+        wantErr  string
+    }{
+        {
+            name:     "same addresses keep their IDs",
+            backends: []db.BackendServer{backend(0, "http", "10.0.0.2", 8080, 5), backend(0, "http", "10.0.0.1", 8080, 1)},
+            want: map[int64]backendRow{
+                1: row("http", "10.0.0.1", 8080, 1),
+                2: row("http", "10.0.0.2", 8080, 5),
+                3: initial()[3],
+            },
+        },
+        {
+            name:     "update by ID",
+            backends: []db.BackendServer{backend(1, "https", "10.0.0.5", 8443, 2), backend(2, "http", "10.0.0.2", 8080, 1)},
+            want: map[int64]backendRow{
+                1: row("https", "10.0.0.5", 8443, 2),
+                2: row("http", "10.0.0.2", 8080, 1),
+                3: initial()[3],
+            },
+        },
+        {
+            name:     "add and remove",
+            backends: []db.BackendServer{backend(0, "http", "10.0.0.1", 8080, 1), backend(0, "http", "10.0.0.3", 8080, 1)},
+            want: map[int64]backendRow{
+                1:  row("http", "10.0.0.1", 8080, 1),
+                3:  initial()[3],
+                11: row("http", "10.0.0.3", 8080, 1),
+            },
+        },
+        {
+            name:     "a different scheme is a different backend",
+            backends: []db.BackendServer{backend(0, "https", "10.0.0.1", 8080, 1)},
+            want: map[int64]backendRow{
+                3:  initial()[3],
+                11: row("https", "10.0.0.1", 8080, 1),
+            },
+        },
+        {
+            name:     "duplicate address adds a second backend",
+            backends: []db.BackendServer{backend(0, "http", "10.0.0.1", 8080, 1), backend(0, "http", "10.0.0.1", 8080, 1)},
+            want: map[int64]backendRow{
+                1:  row("http", "10.0.0.1", 8080, 1),
+                3:  initial()[3],
+                11: row("http", "10.0.0.1", 8080, 1),
+            },
+        },
+        {
+            name:     "weight defaults to 1",
+            backends: []db.BackendServer{backend(1, "http", "10.0.0.1", 8080, 0), backend(0, "http", "10.0.0.4", 80, -3)},
+            want: map[int64]backendRow{
+                1:  row("http", "10.0.0.1", 8080, 1),
+                3:  initial()[3],
+                11: row("http", "10.0.0.4", 80, 1),
+            },
+        },
+        {
+            name:     "empty list removes every backend",
+            backends: nil,
+            want:     map[int64]backendRow{3: initial()[3]},
+        },
+        {
+            name:     "backend of another domain",
+            backends: []db.BackendServer{backend(3, "http", "10.0.0.9", 80, 1)},
+            wantErr:  "backend server 3 does not belong to this domain",
+        },
+        {
+            name:     "unknown backend ID",
+            backends: []db.BackendServer{backend(42, "http", "10.0.0.1", 8080, 1)},
+            wantErr:  "backend server 42 does not belong to this domain",
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            tx := &backendTx{rows: initial(), nextID: 10}
+            err := reconcileBackends(context.Background(), tx, domainID, tt.backends)
+            if tt.wantErr != "" {
+                if _, ok := err.(errForeignBackend); !ok || err.Error() != tt.wantErr {
+                    t.Fatalf("error = %v, want errForeignBackend %q", err, tt.wantErr)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            if !reflect.DeepEqual(tx.rows, tt.want) {
+                t.Errorf("got  %+v\nwant %+v", tx.rows, tt.want)
+            }
+        })
+    }
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"viacortex/internal/db"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxEarlyHints bounds the Link headers a domain sends before its responses
+const maxEarlyHints = 20
+
+// earlyHintRels are the link relations worth sending before a response
+var earlyHintRels = map[string]bool{"preload": true, "modulepreload": true, "preconnect": true}
+
+// loadDomainEarlyHints returns a domain's early hints in the order they are
+// sent
+func loadDomainEarlyHints(ctx context.Context, q sessionQuerier, domainID int64) ([]db.DomainEarlyHint, error) {
+    rows, err := q.Query(ctx, `
+        SELECT id, position, path_prefix, link
+        FROM domain_early_hints
+        WHERE domain_id = $1
+        ORDER BY position, id
+    `, domainID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    hints := []db.DomainEarlyHint{}
+    for rows.Next() {
+        hint := db.DomainEarlyHint{DomainID: domainID}
+        if err := rows.Scan(&hint.ID, &hint.Position, &hint.PathPrefix, &hint.Link); err != nil {
+            return nil, err
+        }
+        hints = append(hints, hint)
+    }
+    return hints, rows.Err()
+}
+
+// validateEarlyHint returns a message for the client when a hint is invalid
+func validateEarlyHint(hint db.DomainEarlyHint) string {
+    if !strings.HasPrefix(hint.PathPrefix, "/") || len(hint.PathPrefix) > 255 {
+        return "path_prefix must start with / and be at most 255 characters"
+    }
+    if len(hint.Link) > 1000 || strings.ContainsAny(hint.Link, "\r\n") {
+        return "link must be a single line of at most 1000 characters"
+    }
+    end := strings.Index(hint.Link, ">")
+    if !strings.HasPrefix(hint.Link, "<") || end < 0 {
+        return `link must look like "</app.css>; rel=preload; as=style"`
+    }
+    for _, param := range strings.Split(hint.Link[end+1:], ";") {
+        name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+        if strings.EqualFold(name, "rel") {
+            for _, rel := range strings.Fields(strings.Trim(value, `"`)) {
+                if !earlyHintRels[strings.ToLower(rel)] {
+                    return "link rel must be preload, modulepreload or preconnect"
+                }
+            }
+            return ""
+        }
+    }
+    return "link needs a rel parameter"
+}
+
+// getDomainEarlyHints returns a domain's early hints
+func (h *Handlers) getDomainEarlyHints(w http.ResponseWriter, r *http.Request) {
+    hints, err := loadDomainEarlyHints(r.Context(), h.db, mustParseInt64(chi.URLParam(r, "id")))
+    if err != nil {
+        logger.Error("Fetching early hints failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch early hints")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(hints)
+}
+
+// setDomainEarlyHints replaces a domain's early hints
+func (h *Handlers) setDomainEarlyHints(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    domainID := mustParseInt64(chi.URLParam(r, "id"))
+
+    var req []db.DomainEarlyHint
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+    if len(req) > maxEarlyHints {
+        writeError(w, r, http.StatusBadRequest, "A domain can have at most 20 early hints")
+        return
+    }
+    for i := range req {
+        if req[i].PathPrefix == "" {
+            req[i].PathPrefix = "/"
+        }
+        if msg := validateEarlyHint(req[i]); msg != "" {
+            writeError(w, r, http.StatusBadRequest, msg)
+            return
+        }
+    }
+
+    tx, err := h.db.Begin(ctx)
+    if err != nil {
+        logger.Error("Starting transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    defer tx.Rollback(ctx)
+
+    before, err := loadDomainEarlyHints(ctx, tx, domainID)
+    if err != nil {
+        logger.Error("Fetching early hints failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to set early hints")
+        return
+    }
+
+    if _, err := tx.Exec(ctx, "DELETE FROM domain_early_hints WHERE domain_id = $1", domainID); err != nil {
+        logger.Error("Clearing early hints failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to set early hints")
+        return
+    }
+    for i, hint := range req {
+        _, err := tx.Exec(ctx, `
+            INSERT INTO domain_early_hints (domain_id, position, path_prefix, link)
+            VALUES ($1, $2, $3, $4)
+        `, domainID, i, hint.PathPrefix, hint.Link)
+        if err != nil {
+            logger.Error("Adding early hint failed", "error", err)
+            writeError(w, r, http.StatusInternalServerError, "Failed to set early hints")
+            return
+        }
+    }
+
+    after, err := loadDomainEarlyHints(ctx, tx, domainID)
+    if err != nil {
+        logger.Error("Fetching early hints failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to set early hints")
+        return
+    }
+
+    // Record audit log
+    if err := writeAudit(ctx, tx, getUserIDFromContext(ctx), "set_early_hints", "domain", domainID, before, after); err != nil {
+        logger.Error("Creating audit log failed", "error", err)
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        logger.Error("Committing transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    h.publishAudit(getUserIDFromContext(ctx), "set_early_hints", "domain", domainID)
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(after)
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+    "strings"
+    "testing"
+
+    "viacortex/internal/db"
+)
+
+func TestValidateEarlyHint(t *testing.T) {
+    format := `link must look like "</app.css>; rel=preload; as=style"`
+    tests := []struct {
+        name string
+        hint db.DomainEarlyHint
+        want string
+    }{
+        {"preload", db.DomainEarlyHint{PathPrefix: "/", Link: "</app.css>; rel=preload; as=style"}, ""},
+        {"quoted rels", db.DomainEarlyHint{PathPrefix: "/", Link: `<https://cdn.example.com>; rel="preconnect"`}, ""},
+        {"module", db.DomainEarlyHint{PathPrefix: "/app/", Link: "</app.js>; REL=ModulePreload"}, ""},
+        {"relative path", db.DomainEarlyHint{PathPrefix: "app", Link: "</app.css>; rel=preload"}, "path_prefix must start with / and be at most 255 characters"},
+        {"long path", db.DomainEarlyHint{PathPrefix: "/" + strings.Repeat("a", 255), Link: "</app.css>; rel=preload"}, "path_prefix must start with / and be at most 255 characters"},
+        {"header injection", db.DomainEarlyHint{PathPrefix: "/", Link: "</a>; rel=preload\r\nSet-Cookie: a=b"}, "link must be a single line of at most 1000 characters"},
+        {"no brackets", db.DomainEarlyHint{PathPrefix: "/", Link: "/app.css; rel=preload"}, format},
+        {"unclosed", db.DomainEarlyHint{PathPrefix: "/", Link: "</app.css; rel=preload"}, format},
+        {"other rel", db.DomainEarlyHint{PathPrefix: "/", Link: "</next>; rel=prefetch"}, "link rel must be preload, modulepreload or preconnect"},
+        {"no rel", db.DomainEarlyHint{PathPrefix: "/", Link: "</app.css>; as=style"}, "link needs a rel parameter"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := validateEarlyHint(tt.hint); got != tt.want {
+                t.Errorf("validateEarlyHint() = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
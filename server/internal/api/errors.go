@@ -0,0 +1,19 @@
+package api
+
+import (
+	"net/http"
+
+	"viacortex/internal/middleware"
+)
+
+// writeError sends an error in the API's standard error shape, using the
+// default code for status
+func writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	middleware.Error(w, r, status, message)
+}
+
+// writeErrorDetails sends an error with a specific code and structured
+// details, such as validation problems
+func writeErrorDetails(w http.ResponseWriter, r *http.Request, status int, code, message string, details map[string]interface{}) {
+	middleware.WriteError(w, r, status, code, message, details)
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "strconv"
+    "strings"
+
+    "github.com/go-chi/chi/v5"
+    "github.com/jackc/pgx/v4"
+)
+
+// errPreconditionFailed is returned when If-Match does not name the current
+// version of a domain
+var errPreconditionFailed = fmt.Errorf("domain was modified by someone else; fetch it again and retry")
+
+// domainETag is the entity tag of a domain's configuration. domains.version
+// covers the domain and its backends, IP rules and rate limits.
+func domainETag(id, version int64) string {
+    return fmt.Sprintf(`"%d-%d"`, id, version)
+}
+
+// etagMatches reports whether an If-Match or If-None-Match header value lists
+// etag. "*" matches any existing resource.
+func etagMatches(header, etag string) bool {
+    for _, candidate := range strings.Split(header, ",") {
+        candidate = strings.TrimSpace(candidate)
+        if candidate == "*" || candidate == etag {
+            return true
+        }
+    }
+    return false
+}
+
+// checkIfMatch enforces If-Match against the domain's current version. A
+// missing header always passes so existing clients are unaffected.
+func checkIfMatch(r *http.Request, id, version int64) error {
+    header := r.Header.Get("If-Match")
+    if header == "" || etagMatches(header, domainETag(id, version)) {
+        return nil
+    }
+    return errPreconditionFailed
+}
+
+// lockDomainVersion locks a domain row for the rest of tx and returns its
+// version, so a conditional update cannot race with another writer
+func lockDomainVersion(ctx context.Context, tx pgx.Tx, id int64) (int64, error) {
+    var version int64
+    err := tx.QueryRow(ctx, "SELECT version FROM domains WHERE id = $1 FOR UPDATE", id).Scan(&version)
+    return version, err
+}
+
+// writePreconditionFailed answers a failed If-Match with 412 and the current
+// ETag so the client knows which version it has to merge with
+func writePreconditionFailed(w http.ResponseWriter, r *http.Request, id, version int64) {
+    w.Header().Set("ETag", domainETag(id, version))
+    writeError(w, r, http.StatusPreconditionFailed, errPreconditionFailed.Error())
+}
+
+// setDomainETag sets the ETag header to the domain's current version
+func (h *Handlers) setDomainETag(ctx context.Context, w http.ResponseWriter, id int64) {
+    var version int64
+    if err := h.db.QueryRow(ctx, "SELECT version FROM domains WHERE id = $1", id).Scan(&version); err != nil {
+        if err != pgx.ErrNoRows {
+            logger.Error("Fetching domain version failed", "error", err)
+        }
+        return
+    }
+    w.Header().Set("ETag", domainETag(id, version))
+}
+
+// domainPrecondition rejects writes below /domains/{id} whose If-Match does
+// not name the domain's current version. Handlers that update the domain
+// itself check again inside their transaction.
+func (h *Handlers) domainPrecondition(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.Header.Get("If-Match") == "" || r.Method == http.MethodGet || r.Method == http.MethodHead {
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+        if err != nil {
+            writeError(w, r, http.StatusBadRequest, "Invalid domain ID")
+            return
+        }
+
+        var version int64
+        err = h.db.QueryRow(r.Context(), "SELECT version FROM domains WHERE id = $1", id).Scan(&version)
+        if err == pgx.ErrNoRows {
+            // If-Match never matches a missing resource
+            writeError(w, r, http.StatusPreconditionFailed, "Domain not found")
+            return
+        }
+        if err != nil {
+            logger.Error("Fetching domain version failed", "error", err)
+            writeError(w, r, http.StatusInternalServerError, "Server error")
+            return
+        }
+
+        if err := checkIfMatch(r, id, version); err != nil {
+            writePreconditionFailed(w, r, id, version)
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
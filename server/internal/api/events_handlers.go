@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"viacortex/internal/events"
+)
+
+// sseHeartbeatInterval keeps idle connections open through proxies
+const sseHeartbeatInterval = 25 * time.Second
+
+// streamEvents streams admin events as server-sent events. The optional
+// types query parameter filters by event type ("domain.created",
+// "certificate.*", ...). Reconnecting clients resume from Last-Event-ID.
+func (h *Handlers) streamEvents(w http.ResponseWriter, r *http.Request) {
+    rc := http.NewResponseController(w)
+    // The stream outlives the admin server's write timeout
+    if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+        logger.Warn("Could not clear write deadline for event stream", "error", err)
+    }
+
+    var filter []string
+    if types := r.URL.Query().Get("types"); types != "" {
+        for _, t := range strings.Split(types, ",") {
+            if t = strings.TrimSpace(t); t != "" {
+                filter = append(filter, t)
+            }
+        }
+    }
+
+    lastID, _ := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+    ch, backlog, unsubscribe := h.events.Subscribe(filter, lastID)
+    defer unsubscribe()
+
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+    w.Header().Set("Connection", "keep-alive")
+    w.Header().Set("X-Accel-Buffering", "no")
+    w.WriteHeader(http.StatusOK)
+
+    fmt.Fprint(w, "retry: 2000\n\n")
+    for _, event := range backlog {
+        if err := writeSSEEvent(w, event); err != nil {
+            return
+        }
+    }
+    if err := rc.Flush(); err != nil {
+        logger.Error("Event stream does not support flushing", "error", err)
+        return
+    }
+
+    heartbeat := time.NewTicker(sseHeartbeatInterval)
+    defer heartbeat.Stop()
+
+    for {
+        select {
+        case <-r.Context().Done():
+            return
+        case event, ok := <-ch:
+            if !ok {
+                return
+            }
+            if err := writeSSEEvent(w, event); err != nil {
+                return
+            }
+        case <-heartbeat.C:
+            if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+                return
+            }
+        }
+        if err := rc.Flush(); err != nil {
+            return
+        }
+    }
+}
+
+// writeSSEEvent writes one event in text/event-stream framing
+func writeSSEEvent(w http.ResponseWriter, event events.Event) error {
+    data, err := json.Marshal(event)
+    if err != nil {
+        logger.Error("Encoding event failed", "type", event.Type, "error", err)
+        return nil
+    }
+    _, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+    return err
+}
+
+// tokenFromQuery lets EventSource clients, which cannot set headers, pass
+// their bearer token as the access_token query parameter
+func tokenFromQuery(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if token := r.URL.Query().Get("access_token"); token != "" &&
+            r.Header.Get("Authorization") == "" && r.Header.Get("X-API-Key") == "" {
+            r.Header.Set("Authorization", "Bearer "+token)
+        }
+        next.ServeHTTP(w, r)
+    })
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+
+	"viacortex/internal/db"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// errAccountDeactivated is returned when an external login maps to a local
+// account that an admin has deactivated
+var errAccountDeactivated = errors.New("account is deactivated")
+
+// externalUser is an identity vouched for by an external provider (an OIDC
+// issuer or an LDAP directory) together with the role it maps to
+type externalUser struct {
+    Email   string
+    Name    string
+    Role    string
+    Source  string
+    Subject string
+}
+
+// provisionExternalUser finds or creates the local account for an external
+// identity inside tx. The provider is the source of truth for roles, so an
+// existing account's role is brought in line with the mapping.
+func provisionExternalUser(ctx context.Context, tx pgx.Tx, ext externalUser) (db.User, bool, error) {
+    user := db.User{Email: ext.Email, Name: ext.Name, Role: ext.Role, Active: true}
+    var currentRole string
+    var webauthnRequired bool
+    err := tx.QueryRow(ctx, `
+        SELECT id, email, role, active, webauthn_required FROM users WHERE lower(email) = lower($1) FOR UPDATE
+    `, ext.Email).Scan(&user.ID, &user.Email, &currentRole, &user.Active, &webauthnRequired)
+
+    if err == pgx.ErrNoRows {
+        // Just-in-time provisioning. The password hash is not a valid bcrypt
+        // hash, so these accounts can never log in with a local password.
+        buf := make([]byte, 16)
+        if _, err := rand.Read(buf); err != nil {
+            return db.User{}, false, err
+        }
+        err = tx.QueryRow(ctx, `
+            INSERT INTO users (email, password_hash, role, name, active)
+            VALUES ($1, $2, $3, NULLIF($4, ''), true)
+            RETURNING id
+        `, ext.Email, "!"+ext.Source+hex.EncodeToString(buf), ext.Role, ext.Name).Scan(&user.ID)
+        if err != nil {
+            return db.User{}, false, err
+        }
+
+        // Record audit log
+        if err := writeAudit(ctx, tx, user.ID, "create", "user", user.ID, nil, map[string]interface{}{
+            "email":   user.Email,
+            "role":    ext.Role,
+            "source":  ext.Source,
+            "subject": ext.Subject,
+        }); err != nil {
+            logger.Error("Creating audit log failed", "error", err)
+        }
+        return user, false, nil
+    }
+    if err != nil {
+        return db.User{}, false, err
+    }
+
+    if !user.Active {
+        return user, false, errAccountDeactivated
+    }
+
+    if currentRole != ext.Role {
+        if _, err := tx.Exec(ctx, `
+            UPDATE users SET role = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1
+        `, user.ID, ext.Role); err != nil {
+            return db.User{}, false, err
+        }
+
+        // Record audit log
+        if err := writeAudit(ctx, tx, user.ID, "update_role", "user", user.ID,
+            map[string]string{"role": currentRole},
+            map[string]string{"role": ext.Role, "source": ext.Source}); err != nil {
+            logger.Error("Creating audit log failed", "error", err)
+        }
+    }
+    return user, webauthnRequired, nil
+}
+
+// completeExternalLogin records a successful external login and commits tx
+func (h *Handlers) completeExternalLogin(w http.ResponseWriter, r *http.Request, tx pgx.Tx, user db.User, method string) bool {
+    ctx := r.Context()
+
+    if _, err := tx.Exec(ctx, `
+        UPDATE users
+        SET last_login = CURRENT_TIMESTAMP, failed_login_count = 0, locked_until = NULL
+        WHERE id = $1
+    `, user.ID); err != nil {
+        logger.Error("Updating last login failed", "error", err)
+    }
+
+    // Record audit log
+    if err := writeAudit(ctx, tx, user.ID, "login", "user", user.ID, nil, map[string]interface{}{
+        "method": method,
+    }); err != nil {
+        logger.Error("Creating audit log failed", "error", err)
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        logger.Error("Committing transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return false
+    }
+    h.publishAudit(user.ID, "login", "user", user.ID)
+    return true
+}
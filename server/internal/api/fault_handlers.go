@@ -0,0 +1,173 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"viacortex/internal/db"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxFaultDuration bounds how long fault injection may run, so a forgotten
+// experiment cannot keep breaking a domain
+const maxFaultDuration = 24 * time.Hour
+
+// maxFaultDelay bounds the latency added to a request, in milliseconds
+const maxFaultDelay = 60000
+
+// loadDomainFault returns a domain's fault injection, or nil when it has none
+func (h *Handlers) loadDomainFault(ctx context.Context, domainID int64) (*db.DomainFault, error) {
+    f := db.DomainFault{DomainID: domainID}
+    err := h.db.QueryRow(ctx, `
+        SELECT percent, delay_ms, error_status, reset_connection, expires_at, created_by, created_at
+        FROM domain_faults
+        WHERE domain_id = $1
+    `, domainID).Scan(
+        &f.Percent, &f.DelayMs, &f.ErrorStatus, &f.ResetConnection, &f.ExpiresAt, &f.CreatedBy, &f.CreatedAt,
+    )
+    if err != nil {
+        if err.Error() == "no rows in result set" {
+            return nil, nil
+        }
+        return nil, err
+    }
+    f.Active = time.Now().Before(f.ExpiresAt)
+    return &f, nil
+}
+
+// getDomainFault returns the fault injection of a domain
+func (h *Handlers) getDomainFault(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    domainID := mustParseInt64(chi.URLParam(r, "id"))
+
+    f, err := h.loadDomainFault(ctx, domainID)
+    if err != nil {
+        logger.Error("Fetching fault injection failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch fault injection")
+        return
+    }
+    if f == nil {
+        writeError(w, r, http.StatusNotFound, "No fault injection configured")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(f)
+}
+
+// setDomainFault starts or replaces fault injection on a domain. Every
+// fault needs a duration, after which the proxy stops applying it.
+func (h *Handlers) setDomainFault(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    domainID := mustParseInt64(chi.URLParam(r, "id"))
+
+    var req struct {
+        Percent         int  `json:"percent"`
+        DelayMs         int  `json:"delay_ms"`
+        ErrorStatus     *int `json:"error_status"`
+        ResetConnection bool `json:"reset_connection"`
+        DurationSeconds int  `json:"duration_seconds"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+
+    duration := time.Duration(req.DurationSeconds) * time.Second
+    switch {
+    case req.Percent < 1 || req.Percent > 100:
+        writeError(w, r, http.StatusBadRequest, "percent must be between 1 and 100")
+        return
+    case req.DelayMs < 0 || req.DelayMs > maxFaultDelay:
+        writeError(w, r, http.StatusBadRequest, "delay_ms must be between 0 and 60000")
+        return
+    case req.ErrorStatus != nil && (*req.ErrorStatus < 400 || *req.ErrorStatus > 599):
+        writeError(w, r, http.StatusBadRequest, "error_status must be between 400 and 599")
+        return
+    case req.ErrorStatus != nil && req.ResetConnection:
+        writeError(w, r, http.StatusBadRequest, "error_status and reset_connection cannot be combined")
+        return
+    case req.DelayMs == 0 && req.ErrorStatus == nil && !req.ResetConnection:
+        writeError(w, r, http.StatusBadRequest, "Set at least one of delay_ms, error_status or reset_connection")
+        return
+    case duration <= 0 || duration > maxFaultDuration:
+        writeError(w, r, http.StatusBadRequest, "duration_seconds must be between 1 and 86400")
+        return
+    }
+
+    before, err := h.loadDomainFault(ctx, domainID)
+    if err != nil {
+        logger.Error("Fetching fault injection failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to set fault injection")
+        return
+    }
+
+    userID := getUserIDFromContext(ctx)
+    _, err = h.db.Exec(ctx, `
+        INSERT INTO domain_faults (
+            domain_id, percent, delay_ms, error_status, reset_connection, expires_at, created_by
+        ) VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, 0))
+        ON CONFLICT (domain_id) DO UPDATE SET
+            percent = EXCLUDED.percent,
+            delay_ms = EXCLUDED.delay_ms,
+            error_status = EXCLUDED.error_status,
+            reset_connection = EXCLUDED.reset_connection,
+            expires_at = EXCLUDED.expires_at,
+            created_by = EXCLUDED.created_by,
+            created_at = CURRENT_TIMESTAMP
+    `, domainID, req.Percent, req.DelayMs, req.ErrorStatus, req.ResetConnection,
+        time.Now().Add(duration), userID)
+    if err != nil {
+        logger.Error("Setting fault injection failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to set fault injection")
+        return
+    }
+
+    after, err := h.loadDomainFault(ctx, domainID)
+    if err != nil {
+        logger.Error("Fetching fault injection failed", "error", err)
+    }
+
+    // Record audit log
+    if err := h.recordAudit(ctx, userID, "update", "domain_fault", domainID, before, after); err != nil {
+        logger.Error("Recording audit failed", "error", err)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(after)
+}
+
+// clearDomainFault stops fault injection on a domain before it expires
+func (h *Handlers) clearDomainFault(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    domainID := mustParseInt64(chi.URLParam(r, "id"))
+
+    before, err := h.loadDomainFault(ctx, domainID)
+    if err != nil {
+        logger.Error("Fetching fault injection failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to clear fault injection")
+        return
+    }
+
+    result, err := h.db.Exec(ctx, "DELETE FROM domain_faults WHERE domain_id = $1", domainID)
+    if err != nil {
+        logger.Error("Clearing fault injection failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to clear fault injection")
+        return
+    }
+
+    if result.RowsAffected() > 0 {
+        // Record audit log
+        userID := getUserIDFromContext(ctx)
+        if err := h.recordAudit(ctx, userID, "delete", "domain_fault", domainID, before, nil); err != nil {
+            logger.Error("Recording audit failed", "error", err)
+        }
+    }
+
+    json.NewEncoder(w).Encode(map[string]string{
+        "message": "Fault injection cleared",
+    })
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+// TestSetDomainFaultValidation covers the requests rejected before the
+// database is touched
+func TestSetDomainFaultValidation(t *testing.T) {
+    tests := []struct {
+        name string
+        body string
+        want string
+    }{
+        {"invalid JSON", `{`, "Invalid request body"},
+        {"percent too low", `{"percent": 0, "delay_ms": 100, "duration_seconds": 60}`, "percent must be between 1 and 100"},
+        {"percent too high", `{"percent": 101, "delay_ms": 100, "duration_seconds": 60}`, "percent must be between 1 and 100"},
+        {"negative delay", `{"percent": 10, "delay_ms": -1, "duration_seconds": 60}`, "delay_ms must be between 0 and 60000"},
+        {"delay too long", `{"percent": 10, "delay_ms": 60001, "duration_seconds": 60}`, "delay_ms must be between 0 and 60000"},
+        {"status not an error", `{"percent": 10, "error_status": 200, "duration_seconds": 60}`, "error_status must be between 400 and 599"},
+        {"status and reset", `{"percent": 10, "error_status": 503, "reset_connection": true, "duration_seconds": 60}`, "error_status and reset_connection cannot be combined"},
+        {"nothing to inject", `{"percent": 10, "duration_seconds": 60}`, "Set at least one of delay_ms, error_status or reset_connection"},
+        {"no duration", `{"percent": 10, "delay_ms": 100}`, "duration_seconds must be between 1 and 86400"},
+        {"duration too long", `{"percent": 10, "reset_connection": true, "duration_seconds": 86401}`, "duration_seconds must be between 1 and 86400"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            h := &Handlers{}
+            rec := httptest.NewRecorder()
+            h.setDomainFault(rec, httptest.NewRequest(http.MethodPut, "/api/v1/domains/1/faults", strings.NewReader(tt.body)))
+            if rec.Code != http.StatusBadRequest {
+                t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+            }
+            if !strings.Contains(rec.Body.String(), tt.want) {
+                t.Errorf("body = %s, want %q", rec.Body.String(), tt.want)
+            }
+        })
+    }
+}
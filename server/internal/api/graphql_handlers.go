@@ -0,0 +1,255 @@
+package api
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "time"
+
+    "viacortex/internal/db"
+    "viacortex/internal/graphql"
+    "viacortex/internal/proxy"
+
+    "github.com/jackc/pgx/v4"
+)
+
+// graphqlQuery serves read-only GraphQL queries over domains, their backends,
+// IP rules, rate limits, certificates and metrics. Field names match the JSON
+// of the REST API. Queries are accepted as a JSON POST body or as query,
+// variables and operationName URL parameters on GET.
+func (h *Handlers) graphqlQuery(w http.ResponseWriter, r *http.Request) {
+    var req graphql.Request
+    if r.Method == http.MethodGet {
+        req.Query = r.URL.Query().Get("query")
+        req.OperationName = r.URL.Query().Get("operationName")
+        if vars := r.URL.Query().Get("variables"); vars != "" {
+            if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+                writeError(w, r, http.StatusBadRequest, "Invalid variables")
+                return
+            }
+        }
+    } else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+
+    if req.Query == "" {
+        writeError(w, r, http.StatusBadRequest, "query is required")
+        return
+    }
+
+    resp := graphql.Execute(r.Context(), h.graphqlRoot(), req)
+
+    w.Header().Set("Content-Type", "application/json")
+    if resp.Data == nil {
+        w.WriteHeader(http.StatusBadRequest)
+    }
+    json.NewEncoder(w).Encode(resp)
+}
+
+// graphqlRoot builds the root Query object
+func (h *Handlers) graphqlRoot() graphql.Object {
+    return graphql.Object{
+        "__typename": "Query",
+
+        // domains(enabled: Boolean): [Domain]
+        "domains": graphql.Resolver(func(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+            enabled, filter, err := graphql.BoolArg(args, "enabled")
+            if err != nil {
+                return nil, err
+            }
+            userID, isAdmin, orgID := domainVisibility(ctx)
+            rows, err := h.db.Query(ctx, `
+                SELECT id, name, target_url, ssl_enabled,
+                    health_check_enabled, health_check_interval,
+                    custom_error_pages, enabled, version, created_at, updated_at
+                FROM domains
+                WHERE (NOT $1::boolean OR enabled = $2) AND `+visibleDomainsSQL("id", 3, 4, 5)+`
+                ORDER BY name
+            `, filter, enabled, userID, isAdmin, orgID)
+            if err != nil {
+                logger.Error("Fetching domains failed", "error", err)
+                return nil, fmt.Errorf("failed to fetch domains")
+            }
+            defer rows.Close()
+
+            domains := []graphql.Object{}
+            for rows.Next() {
+                var d db.Domain
+                if err := rows.Scan(
+                    &d.ID, &d.Name, &d.TargetURL, &d.SSLEnabled,
+                    &d.HealthCheckEnabled, &d.HealthCheckInterval,
+                    &d.CustomErrorPages, &d.Enabled, &d.Version, &d.CreatedAt, &d.UpdatedAt,
+                ); err != nil {
+                    logger.Error("Scanning domain failed", "error", err)
+                    continue
+                }
+                obj, err := h.graphqlDomain(d)
+                if err != nil {
+                    return nil, err
+                }
+                domains = append(domains, obj)
+            }
+            return domains, rows.Err()
+        }),
+
+        // domain(id: Int, name: String): Domain
+        "domain": graphql.Resolver(func(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+            id, hasID, err := graphql.IntArg(args, "id")
+            if err != nil {
+                return nil, err
+            }
+            name, hasName, err := graphql.StringArg(args, "name")
+            if err != nil {
+                return nil, err
+            }
+            if hasID == hasName {
+                return nil, fmt.Errorf("exactly one of id or name is required")
+            }
+
+            where, arg := "id = $1", interface{}(id)
+            if hasName {
+                where, arg = "name = $1", name
+            }
+
+            userID, isAdmin, orgID := domainVisibility(ctx)
+            var d db.Domain
+            err = h.db.QueryRow(ctx, `
+                SELECT id, name, target_url, ssl_enabled,
+                    health_check_enabled, health_check_interval,
+                    custom_error_pages, enabled, version, created_at, updated_at
+                FROM domains
+                WHERE (`+where+`) AND `+visibleDomainsSQL("id", 2, 3, 4)+`
+                ORDER BY id
+                LIMIT 1
+            `, arg, userID, isAdmin, orgID).Scan(
+                &d.ID, &d.Name, &d.TargetURL, &d.SSLEnabled,
+                &d.HealthCheckEnabled, &d.HealthCheckInterval,
+                &d.CustomErrorPages, &d.Enabled, &d.Version, &d.CreatedAt, &d.UpdatedAt,
+            )
+            if err == pgx.ErrNoRows {
+                return nil, nil
+            }
+            if err != nil {
+                logger.Error("Fetching domain failed", "error", err)
+                return nil, fmt.Errorf("failed to fetch domain")
+            }
+            return h.graphqlDomain(d)
+        }),
+
+        // metrics(range: String = "24h"): [DomainMetricsSummary]
+        "metrics": graphql.Resolver(func(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+            since, err := graphqlRangeStart(args)
+            if err != nil {
+                return nil, err
+            }
+            metrics, err := h.metricsSummary(ctx, since)
+            if err != nil {
+                logger.Error("Fetching metrics failed", "error", err)
+                return nil, fmt.Errorf("failed to fetch metrics")
+            }
+            return graphqlObjects(metrics)
+        }),
+    }
+}
+
+// graphqlDomain wraps a domain, resolving its associations only when they
+// are selected
+func (h *Handlers) graphqlDomain(d db.Domain) (graphql.Object, error) {
+    obj, err := graphql.ObjectOf(d)
+    if err != nil {
+        return nil, err
+    }
+    obj["__typename"] = "Domain"
+
+    obj["backend_servers"] = graphql.Resolver(func(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+        backends, err := h.domainBackends(ctx, d.ID)
+        if err != nil {
+            logger.Error("Fetching backend servers failed", "error", err)
+            return nil, fmt.Errorf("failed to fetch backend servers")
+        }
+        return graphqlObjects(backends)
+    })
+
+    obj["ip_rules"] = graphql.Resolver(func(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+        rules, err := h.domainIPRules(ctx, d.ID)
+        if err != nil {
+            logger.Error("Fetching IP rules failed", "error", err)
+            return nil, fmt.Errorf("failed to fetch IP rules")
+        }
+        return graphqlObjects(rules)
+    })
+
+    obj["rate_limits"] = graphql.Resolver(func(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+        limits, err := h.domainRateLimits(ctx, d.ID)
+        if err != nil {
+            logger.Error("Fetching rate limits failed", "error", err)
+            return nil, fmt.Errorf("failed to fetch rate limits")
+        }
+        return graphqlObjects(limits)
+    })
+
+    obj["certificate"] = graphql.Resolver(func(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+        if h.proxy == nil || !d.SSLEnabled {
+            return nil, nil
+        }
+        return graphql.ObjectOf(h.proxy.CertificateStatus(ctx, proxy.HostKey(d.Name)))
+    })
+
+    obj["dns"] = graphql.Resolver(func(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+        if h.proxy == nil {
+            return nil, nil
+        }
+        status, ok := h.proxy.LastDNSStatus(proxy.HostKey(d.Name))
+        if !ok {
+            return nil, nil
+        }
+        return graphql.ObjectOf(status)
+    })
+
+    // metrics(range: String = "24h"): [MetricPoint]
+    obj["metrics"] = graphql.Resolver(func(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+        since, err := graphqlRangeStart(args)
+        if err != nil {
+            return nil, err
+        }
+        metrics, err := h.domainMetricSeries(ctx, d.ID, since)
+        if err != nil {
+            logger.Error("Fetching domain metrics failed", "error", err)
+            return nil, fmt.Errorf("failed to fetch metrics")
+        }
+        return graphqlObjects(metrics)
+    })
+
+    return obj, nil
+}
+
+// graphqlRangeStart reads the range argument used by metrics fields
+func graphqlRangeStart(args map[string]interface{}) (time.Time, error) {
+    timeRange, ok, err := graphql.StringArg(args, "range")
+    if err != nil {
+        return time.Time{}, err
+    }
+    if !ok {
+        timeRange = "24h"
+    }
+    duration, err := time.ParseDuration(timeRange)
+    if err != nil {
+        return time.Time{}, fmt.Errorf("invalid time range %q", timeRange)
+    }
+    return time.Now().Add(-duration), nil
+}
+
+// graphqlObjects converts a slice of models into GraphQL objects
+func graphqlObjects[T any](items []T) ([]graphql.Object, error) {
+    objects := make([]graphql.Object, 0, len(items))
+    for _, item := range items {
+        obj, err := graphql.ObjectOf(item)
+        if err != nil {
+            return nil, err
+        }
+        objects = append(objects, obj)
+    }
+    return objects, nil
+}
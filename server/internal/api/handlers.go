@@ -2,13 +2,94 @@
 package api
 
 import (
+    "net/http"
+
+    "viacortex/internal/auth"
+    "viacortex/internal/cluster"
+    "viacortex/internal/events"
+    "viacortex/internal/jobs"
+    "viacortex/internal/ldap"
+    "viacortex/internal/logging"
+    "viacortex/internal/mailer"
+    "viacortex/internal/oidc"
+    "viacortex/internal/proxy"
+    "viacortex/internal/webauthn"
+    "viacortex/internal/webhooks"
+
     "github.com/jackc/pgx/v4/pgxpool"
 )
 
+var logger = logging.For("api")
+
 type Handlers struct {
-    db *pgxpool.Pool
+    db       *pgxpool.Pool
+    readDB   *pgxpool.Pool
+    webhooks *webhooks.Dispatcher
+    proxy    *proxy.ProxyServer
+    events   *events.Broker
+    limits   *authLimits
+    loader   *proxy.Loader
+    cluster  *cluster.Registry
+    leader   *cluster.Leader
+    bus      proxy.Bus
+    prober   *proxy.Prober
+    janitor  *proxy.StorageJanitor
+    jobs     *jobs.Scheduler
+
+    quotaDefaults quotaLimits
+
+    mailer        mailer.Mailer
+    resetLinkBase string
+    webauthn      *webauthn.RelyingParty
+    sso           *oidc.Flow
+    directory     *ldap.Directory
+    keyRing       *auth.KeyRing
+    bootstrap     *auth.Bootstrap
+    devKeyHash    string
+    countryHeader string
+    corsOrigins   []string
+    ui            http.Handler
+}
+
+func NewHandlers(db *pgxpool.Pool, hooks *webhooks.Dispatcher) *Handlers {
+    h := &Handlers{db: db, webhooks: hooks}
+    h.limits = newAuthLimits(h)
+    return h
+}
+
+// SetProxy gives the handlers access to the running proxy for live state
+// such as certificate status
+func (h *Handlers) SetProxy(p *proxy.ProxyServer) {
+    h.proxy = p
 }
 
-func NewHandlers(db *pgxpool.Pool) *Handlers {
-    return &Handlers{db: db}
-}
\ No newline at end of file
+// SetReadDB routes log and metrics queries to a separate, possibly
+// read-only, pool such as a replica
+func (h *Handlers) SetReadDB(pool *pgxpool.Pool) {
+    h.readDB = pool
+}
+
+// reader returns the pool for heavy read-only queries
+func (h *Handlers) reader() *pgxpool.Pool {
+    if h.readDB != nil {
+        return h.readDB
+    }
+    return h.db
+}
+
+// SetCORSOrigins restricts the origins browsers may call the API from. All
+// origins are allowed when it is not called.
+func (h *Handlers) SetCORSOrigins(origins []string) {
+    h.corsOrigins = origins
+}
+
+// SetUI serves the dashboard at / from h. Without it / only answers 200 for
+// probes.
+func (h *Handlers) SetUI(ui http.Handler) {
+    h.ui = ui
+}
+
+// SetEvents sets the broker that feeds the live event stream
+func (h *Handlers) SetEvents(b *events.Broker) {
+    h.events = b
+}
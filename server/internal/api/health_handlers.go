@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"viacortex/internal/proxy"
+)
+
+const (
+	// readinessTimeout bounds the database ping done by readiness checks
+	readinessTimeout = 2 * time.Second
+	// loaderStaleAfter is how long without a successful domain load before
+	// the instance stops reporting ready. The loader reloads every 30s.
+	loaderStaleAfter = 2 * time.Minute
+)
+
+// requiredListeners must be accepting connections for the instance to be
+// ready. Other listeners (TCP proxies) are reported but do not gate traffic.
+var requiredListeners = []string{"http", "https"}
+
+type healthCheck struct {
+	OK     bool        `json:"ok"`
+	Error  string      `json:"error,omitempty"`
+	Detail interface{} `json:"detail,omitempty"`
+}
+
+// SetLoader gives readiness checks access to the domain loader state
+func (h *Handlers) SetLoader(l *proxy.Loader) {
+	h.loader = l
+}
+
+// healthz is the liveness probe. It only reports that the process is
+// serving requests, so a database outage does not get the pod restarted.
+func (h *Handlers) healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// readyz is the readiness probe. It fails while the database is unreachable,
+// before domains have been loaded (or when loads have gone stale) and while
+// the proxy listeners are not accepting connections.
+func (h *Handlers) readyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]healthCheck{
+		"database": h.checkDatabase(r.Context()),
+	}
+	if h.loader != nil {
+		checks["loader"] = checkLoader(h.loader.Status())
+	}
+	if h.proxy != nil {
+		checks["listeners"] = checkListeners(h.proxy.Listeners())
+		checks["drain"] = checkDrain(h.proxy.DrainStatus())
+	}
+
+	ready := true
+	for _, c := range checks {
+		ready = ready && c.OK
+	}
+
+	status, code := "ok", http.StatusOK
+	if !ready {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": status,
+		"checks": checks,
+	})
+}
+
+func (h *Handlers) checkDatabase(ctx context.Context) healthCheck {
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+
+	if err := h.db.Ping(ctx); err != nil {
+		return healthCheck{Error: err.Error()}
+	}
+	return healthCheck{OK: true}
+}
+
+// checkDrain fails while the node is draining so load balancers take it out
+// of rotation
+func checkDrain(status proxy.DrainStatus) healthCheck {
+	if status.Draining {
+		return healthCheck{Error: "node is draining", Detail: status}
+	}
+	return healthCheck{OK: true}
+}
+
+func checkLoader(status proxy.LoaderStatus) healthCheck {
+	c := healthCheck{Detail: status}
+	switch {
+	case !status.Loaded():
+		c.Error = "domains have not been loaded yet"
+	case time.Since(status.LastSuccess) > loaderStaleAfter:
+		c.Error = "domains have not been reloaded since " + status.LastSuccess.Format(time.RFC3339)
+	default:
+		c.OK = true
+	}
+	return c
+}
+
+func checkListeners(listeners map[string]bool) healthCheck {
+	c := healthCheck{OK: true, Detail: listeners}
+	for _, name := range requiredListeners {
+		if !listeners[name] {
+			c.OK = false
+			c.Error = name + " listener is not accepting connections"
+			break
+		}
+	}
+	return c
+}
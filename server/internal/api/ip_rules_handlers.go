@@ -2,7 +2,6 @@ package api
 
 import (
 	"encoding/json"
-	"log"
 	"net/http"
 
 	"viacortex/internal/db"
@@ -23,8 +22,8 @@ func (h *Handlers) getIPRules(w http.ResponseWriter, r *http.Request) {
     `, domainID)
     
     if err != nil {
-        log.Printf("Error fetching IP rules: %v", err)
-        http.Error(w, "Failed to fetch IP rules", http.StatusInternalServerError)
+        logger.Error("Fetching IP rules failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch IP rules")
         return
     }
     defer rows.Close()
@@ -37,7 +36,7 @@ func (h *Handlers) getIPRules(w http.ResponseWriter, r *http.Request) {
             &rule.Description, &rule.CreatedAt, &rule.UpdatedAt,
         )
         if err != nil {
-            log.Printf("Error scanning IP rule: %v", err)
+            logger.Error("Scanning IP rule failed", "error", err)
             continue
         }
         rules = append(rules, rule)
@@ -54,33 +53,58 @@ func (h *Handlers) addIPRule(w http.ResponseWriter, r *http.Request) {
 
     var rule db.IPRule
     if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
-        http.Error(w, "Invalid request body", http.StatusBadRequest)
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
         return
     }
 
     // Validate rule type
     if rule.RuleType != "whitelist" && rule.RuleType != "blacklist" {
-        http.Error(w, "Invalid rule type", http.StatusBadRequest)
+        writeError(w, r, http.StatusBadRequest, "Invalid rule type")
+        return
+    }
+
+    // The quota is checked and the rule inserted in one transaction
+    tx, err := h.db.Begin(ctx)
+    if err != nil {
+        logger.Error("Starting transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    defer tx.Rollback(ctx)
+
+    owner, err := domainTenant(ctx, tx, mustParseInt64(domainID))
+    if err == nil {
+        err = h.checkQuota(ctx, tx, owner, quotaIPRules, 1)
+    }
+    if err != nil {
+        writeQuotaError(w, r, err)
         return
     }
 
     var ruleID int64
-    err := h.db.QueryRow(ctx, `
+    err = tx.QueryRow(ctx, `
         INSERT INTO ip_rules (domain_id, ip_range, rule_type, description)
         VALUES ($1, $2, $3, $4)
         RETURNING id
     `, domainID, rule.IPRange, rule.RuleType, rule.Description).Scan(&ruleID)
 
     if err != nil {
-        log.Printf("Error creating IP rule: %v", err)
-        http.Error(w, "Failed to create IP rule", http.StatusInternalServerError)
+        logger.Error("Creating IP rule failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to create IP rule")
+        return
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        logger.Error("Committing transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to create IP rule")
         return
     }
 
     // Record audit log
     userID := getUserIDFromContext(ctx)
-    if err := h.recordAudit(ctx, userID, "create", "ip_rule", ruleID, rule); err != nil {
-        log.Printf("Error recording audit: %v", err)
+    after, _ := snapshotEntity(ctx, h.db, "ip_rules", ruleID)
+    if err := h.recordAudit(ctx, userID, "create", "ip_rule", ruleID, nil, after); err != nil {
+        logger.Error("Recording audit failed", "error", err)
     }
 
     w.WriteHeader(http.StatusCreated)
@@ -96,35 +120,30 @@ func (h *Handlers) deleteIPRule(w http.ResponseWriter, r *http.Request) {
     ruleID := chi.URLParam(r, "ruleID")
 
     // Get rule details for audit log before deletion
-    var oldRule db.IPRule
-    err := h.db.QueryRow(ctx, `
-        SELECT ip_range, rule_type, description 
-        FROM ip_rules WHERE id = $1
-    `, ruleID).Scan(&oldRule.IPRange, &oldRule.RuleType, &oldRule.Description)
-    
+    before, err := snapshotEntity(ctx, h.db, "ip_rules", ruleID)
     if err != nil {
-        log.Printf("Error fetching IP rule: %v", err)
-        http.Error(w, "Rule not found", http.StatusNotFound)
+        logger.Error("Fetching IP rule failed", "error", err)
+        writeError(w, r, http.StatusNotFound, "Rule not found")
         return
     }
 
-    result, err := h.db.Exec(ctx, "DELETE FROM ip_rules WHERE id = $1", ruleID)
+    result, err := h.db.Exec(ctx, "DELETE FROM ip_rules WHERE id = $1 AND domain_id = $2", ruleID, chi.URLParam(r, "id"))
     if err != nil {
-        log.Printf("Error deleting IP rule: %v", err)
-        http.Error(w, "Failed to delete IP rule", http.StatusInternalServerError)
+        logger.Error("Deleting IP rule failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to delete IP rule")
         return
     }
 
     if rowsAffected := result.RowsAffected(); rowsAffected == 0 {
-        http.Error(w, "Rule not found", http.StatusNotFound)
+        writeError(w, r, http.StatusNotFound, "Rule not found")
         return
     }
 
     // Record audit log
     userID := getUserIDFromContext(ctx)
-    if err := h.recordAudit(ctx, userID, "delete", "ip_rule", 
-        mustParseInt64(ruleID), oldRule); err != nil {
-        log.Printf("Error recording audit: %v", err)
+    if err := h.recordAudit(ctx, userID, "delete", "ip_rule",
+        mustParseInt64(ruleID), before, nil); err != nil {
+        logger.Error("Recording audit failed", "error", err)
     }
 
     w.WriteHeader(http.StatusOK)
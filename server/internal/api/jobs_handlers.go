@@ -0,0 +1,57 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"viacortex/internal/jobs"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// SetJobs exposes the background job scheduler
+func (h *Handlers) SetJobs(s *jobs.Scheduler) {
+    h.jobs = s
+}
+
+// getJobs lists this node's background jobs and how each last ran
+func (h *Handlers) getJobs(w http.ResponseWriter, r *http.Request) {
+    if h.jobs == nil {
+        writeError(w, r, http.StatusNotFound, "Job scheduling is not enabled on this server")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(h.jobs.Status())
+}
+
+// runJob starts a job on this node now, outside its schedule
+func (h *Handlers) runJob(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    if h.jobs == nil {
+        writeError(w, r, http.StatusNotFound, "Job scheduling is not enabled on this server")
+        return
+    }
+    name := chi.URLParam(r, "name")
+
+    status, err := h.jobs.Trigger(name)
+    switch {
+    case errors.Is(err, jobs.ErrUnknownJob):
+        writeError(w, r, http.StatusNotFound, "Job not found")
+        return
+    case errors.Is(err, jobs.ErrRunning):
+        writeError(w, r, http.StatusConflict, "Job is already running")
+        return
+    }
+
+    // Record audit log
+    userID := getUserIDFromContext(ctx)
+    if err := h.recordAudit(ctx, userID, "run", "job", 0, nil, map[string]string{"name": name}); err != nil {
+        logger.Error("Recording audit failed", "error", err)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusAccepted)
+    json.NewEncoder(w).Encode(status)
+}
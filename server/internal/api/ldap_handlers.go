@@ -0,0 +1,128 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"viacortex/internal/ldap"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// SetDirectory authenticates password logins against an LDAP directory
+func (h *Handlers) SetDirectory(d *ldap.Directory) {
+    h.directory = d
+}
+
+// directoryLogin handles a password login for a directory user. It returns
+// false, having written nothing, when the login should be tried against local
+// accounts instead.
+func (h *Handlers) directoryLogin(w http.ResponseWriter, r *http.Request, req loginRequest) bool {
+    ctx := r.Context()
+
+    entry, err := h.directory.Lookup(ctx, req.Email)
+    switch {
+    case errors.Is(err, ldap.ErrNotFound):
+        if h.directory.FallbackLocal {
+            return false
+        }
+        h.emitLoginFailed(r, req.Email, "unknown_user")
+        writeError(w, r, http.StatusUnauthorized, "Invalid credentials")
+        return true
+    case err != nil:
+        logger.Error("Looking up user in directory failed", "email", req.Email, "error", err)
+        if h.directory.FallbackLocal {
+            return false
+        }
+        writeError(w, r, http.StatusServiceUnavailable, "Directory is unavailable")
+        return true
+    }
+
+    if entry.Email == "" {
+        logger.Warn("Directory entry has no email address", "dn", entry.DN)
+        h.emitLoginFailed(r, req.Email, "no_email")
+        writeError(w, r, http.StatusForbidden, "Directory account has no email address")
+        return true
+    }
+
+    tx, err := h.db.Begin(ctx)
+    if err != nil {
+        logger.Error("Starting transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return true
+    }
+    defer tx.Rollback(ctx)
+
+    // Local lockouts apply to directory users too, and spare the directory
+    // from brute force attempts
+    var userID int64
+    var active bool
+    var lockedUntil sql.NullTime
+    err = tx.QueryRow(ctx, `
+        SELECT id, active, locked_until FROM users WHERE lower(email) = lower($1) FOR UPDATE
+    `, entry.Email).Scan(&userID, &active, &lockedUntil)
+    known := err == nil
+    if err != nil && err != pgx.ErrNoRows {
+        logger.Error("Querying user failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return true
+    }
+    if known && !active {
+        h.emitLoginFailed(r, entry.Email, "deactivated")
+        writeError(w, r, http.StatusForbidden, "Account is deactivated")
+        return true
+    }
+    if known && lockedUntil.Valid && lockedUntil.Time.After(time.Now()) {
+        h.emitLoginFailed(r, entry.Email, "locked")
+        writeLockedOut(w, r, lockedUntil.Time)
+        return true
+    }
+
+    if err := h.directory.Verify(ctx, entry.DN, req.Password); err != nil {
+        if !errors.Is(err, ldap.ErrInvalidCredentials) {
+            logger.Error("Verifying user against directory failed", "dn", entry.DN, "error", err)
+            writeError(w, r, http.StatusServiceUnavailable, "Directory is unavailable")
+            return true
+        }
+        h.limits.failedLogins.Add(1)
+        h.emitLoginFailed(r, entry.Email, "invalid_password")
+        if known && h.recordFailedLogin(w, r, tx, userID) {
+            return true
+        }
+        writeError(w, r, http.StatusUnauthorized, "Invalid credentials")
+        return true
+    }
+
+    role, ok := h.directory.Roles.Role(entry.Groups)
+    if !ok {
+        h.emitLoginFailed(r, entry.Email, "no_role")
+        writeError(w, r, http.StatusForbidden, "This account is not allowed to sign in")
+        return true
+    }
+
+    user, webauthnRequired, err := provisionExternalUser(ctx, tx, externalUser{
+        Email:   entry.Email,
+        Name:    entry.Name,
+        Role:    role,
+        Source:  "ldap",
+        Subject: entry.DN,
+    })
+    if err != nil {
+        logger.Error("Provisioning directory user failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return true
+    }
+
+    // Security keys are enforced on top of the directory password
+    if webauthnRequired {
+        h.beginSecondFactor(w, r, tx, user.ID)
+        return true
+    }
+
+    if h.completeExternalLogin(w, r, tx, user, "ldap") {
+        h.writeLoginResponse(w, r, user)
+    }
+    return true
+}
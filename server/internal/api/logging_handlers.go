@@ -0,0 +1,93 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"viacortex/internal/logging"
+)
+
+// logLevels is the body of the logging endpoints. An empty module level
+// makes the module follow the default level again.
+type logLevels struct {
+    Level   string            `json:"level,omitempty"`
+    Modules map[string]string `json:"modules,omitempty"`
+}
+
+func currentLogLevels() logLevels {
+    level, modules := logging.Levels()
+    current := logLevels{Level: strings.ToLower(level.String()), Modules: map[string]string{}}
+    for module, l := range modules {
+        current.Modules[module] = strings.ToLower(l.String())
+    }
+    return current
+}
+
+// getLogLevels returns the default log level and the per-module overrides
+func (h *Handlers) getLogLevels(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(currentLogLevels())
+}
+
+// updateLogLevels changes log levels until the next restart, e.g.
+// {"modules": {"tcp": "debug"}} while investigating TCP routing
+func (h *Handlers) updateLogLevels(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+
+    var req logLevels
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+
+    // Validate everything before changing anything
+    var level slog.Level
+    if req.Level != "" {
+        l, err := logging.ParseLevel(req.Level)
+        if err != nil {
+            writeError(w, r, http.StatusBadRequest, err.Error())
+            return
+        }
+        level = l
+    }
+    modules := map[string]*slog.Level{}
+    for module, value := range req.Modules {
+        if module == "" {
+            writeError(w, r, http.StatusBadRequest, "Module names must not be empty")
+            return
+        }
+        if value == "" {
+            modules[module] = nil
+            continue
+        }
+        l, err := logging.ParseLevel(value)
+        if err != nil {
+            writeError(w, r, http.StatusBadRequest, module+": "+err.Error())
+            return
+        }
+        modules[module] = &l
+    }
+
+    before := currentLogLevels()
+    if req.Level != "" {
+        logging.SetLevel(level)
+    }
+    for module, l := range modules {
+        if l == nil {
+            logging.ResetModuleLevel(module)
+        } else {
+            logging.SetModuleLevel(module, *l)
+        }
+    }
+    after := currentLogLevels()
+
+    // Record audit log
+    if err := h.recordAudit(ctx, getUserIDFromContext(ctx), "update", "logging", 0, before, after); err != nil {
+        logger.Error("Creating audit log failed", "error", err)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(after)
+}
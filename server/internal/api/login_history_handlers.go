@@ -0,0 +1,153 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"viacortex/internal/db"
+	"viacortex/internal/mailer"
+	"viacortex/internal/middleware"
+)
+
+// loginHistoryRetention is how long login records are kept
+const loginHistoryRetention = 180 * 24 * time.Hour
+
+// SetCountryHeader names the request header carrying the client's ISO
+// country code, e.g. "CF-IPCountry". Logins are not tagged with a country
+// when it is empty.
+func (h *Handlers) SetCountryHeader(header string) {
+    h.countryHeader = header
+}
+
+// loginCountry returns the two-letter country code of the request, or ""
+// when it is unknown
+func (h *Handlers) loginCountry(r *http.Request) string {
+    if h.countryHeader == "" {
+        return ""
+    }
+    code := strings.ToUpper(strings.TrimSpace(r.Header.Get(h.countryHeader)))
+    // Cloudflare uses XX for unknown and T1 for Tor
+    if len(code) != 2 || code == "XX" || code == "T1" {
+        return ""
+    }
+    return code
+}
+
+// recordLogin adds a successful login to the user's history and emails them
+// when it comes from a device or country not seen before, unless they turned
+// login alerts off. The first login of an account is never reported as new.
+func (h *Handlers) recordLogin(r *http.Request, user db.User, sessionID string) {
+    ctx := r.Context()
+    info, _ := ctx.Value(auditRequestKey{}).(auditRequest)
+    device := describeDevice(info.UserAgent)
+    country := h.loginCountry(r)
+
+    var seen, knownDevice, knownCountry, alerts bool
+    err := h.db.QueryRow(ctx, `
+        SELECT EXISTS (SELECT 1 FROM login_history WHERE user_id = $1),
+               EXISTS (SELECT 1 FROM login_history WHERE user_id = $1 AND device = $2),
+               EXISTS (SELECT 1 FROM login_history WHERE user_id = $1 AND country = $3),
+               COALESCE((SELECT (user_settings->'notifications'->>'login_alerts')::boolean
+                         FROM users WHERE id = $1), true)
+    `, user.ID, device, country).Scan(&seen, &knownDevice, &knownCountry, &alerts)
+    if err != nil {
+        logger.Error("Checking login history failed", "error", err)
+        return
+    }
+    newDevice := seen && !knownDevice
+    newCountry := seen && country != "" && !knownCountry
+
+    if _, err := h.db.Exec(ctx, `
+        INSERT INTO login_history (user_id, session_id, device, user_agent, ip_address, country, new_device, new_country)
+        VALUES ($1, NULLIF($2, ''), $3, NULLIF($4, ''), NULLIF($5, '')::inet, NULLIF($6, ''), $7, $8)
+    `, user.ID, sessionID, device, info.UserAgent, info.IP, country, newDevice, newCountry); err != nil {
+        logger.Error("Recording login failed", "error", err)
+        return
+    }
+    if _, err := h.db.Exec(ctx, `
+        DELETE FROM login_history WHERE user_id = $1 AND created_at < $2
+    `, user.ID, time.Now().Add(-loginHistoryRetention)); err != nil {
+        logger.Error("Pruning login history failed", "error", err)
+    }
+
+    if (newDevice || newCountry) && alerts && h.mailer != nil {
+        h.sendLoginAlert(user, device, info.IP, country)
+    }
+}
+
+// sendLoginAlert emails the user about a login from a new device or country
+func (h *Handlers) sendLoginAlert(user db.User, device, ip, country string) {
+    where := ip
+    if country != "" {
+        where = fmt.Sprintf("%s (%s)", ip, country)
+    }
+    msg := mailer.Message{
+        To:      user.Email,
+        Subject: "New sign-in to your ViaCortex account",
+        Body: fmt.Sprintf("Your ViaCortex account was signed in to from a new device or location.\n\n"+
+            "Device: %s\nAddress: %s\nTime: %s\n\n"+
+            "If this was you, no action is needed. Otherwise change your password and "+
+            "sign out your other sessions right away.\n",
+            device, where, time.Now().UTC().Format(time.RFC1123)),
+    }
+    go func() {
+        sendCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+        defer cancel()
+        if err := h.mailer.Send(sendCtx, msg); err != nil {
+            logger.Error("Sending login alert failed", "user_id", user.ID, "error", err)
+        }
+    }()
+}
+
+// getOwnLogins lists the caller's recent logins, newest first
+func (h *Handlers) getOwnLogins(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    userID := getUserIDFromContext(ctx)
+    current := middleware.GetSessionIDFromContext(ctx)
+
+    limit := 50
+    if v := r.URL.Query().Get("limit"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n < 1 || n > 500 {
+            writeError(w, r, http.StatusBadRequest, "Invalid limit")
+            return
+        }
+        limit = n
+    }
+
+    rows, err := h.db.Query(ctx, `
+        SELECT id, COALESCE(session_id, ''), device, COALESCE(user_agent, ''),
+               COALESCE(host(ip_address), ''), COALESCE(country, ''),
+               new_device, new_country, created_at
+        FROM login_history
+        WHERE user_id = $1
+        ORDER BY created_at DESC
+        LIMIT $2
+    `, userID, limit)
+    if err != nil {
+        logger.Error("Fetching login history failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch login history")
+        return
+    }
+    defer rows.Close()
+
+    logins := []db.LoginRecord{}
+    for rows.Next() {
+        var l db.LoginRecord
+        if err := rows.Scan(&l.ID, &l.SessionID, &l.Device, &l.UserAgent, &l.IPAddress, &l.Country,
+            &l.NewDevice, &l.NewCountry, &l.CreatedAt); err != nil {
+            logger.Error("Scanning login record failed", "error", err)
+            continue
+        }
+        l.Current = current != "" && l.SessionID == current
+        logins = append(logins, l)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(logins)
+}
@@ -1,8 +1,8 @@
 package api
 
 import (
+    "context"
     "encoding/json"
-    "log"
     "net/http"
     "strconv"
     "time"
@@ -10,7 +10,7 @@ import (
     "github.com/go-chi/chi/v5"
 )
 
-// getGlobalMetrics returns metrics across all domains
+// getGlobalMetrics returns metrics across the domains visible to the user
 func (h *Handlers) getGlobalMetrics(w http.ResponseWriter, r *http.Request) {
     ctx := r.Context()
     
@@ -22,13 +22,66 @@ func (h *Handlers) getGlobalMetrics(w http.ResponseWriter, r *http.Request) {
     
     duration, err := time.ParseDuration(timeRange)
     if err != nil {
-        http.Error(w, "Invalid time range", http.StatusBadRequest)
+        writeError(w, r, http.StatusBadRequest, "Invalid time range")
         return
     }
 
     startTime := time.Now().Add(-duration)
 
-    rows, err := h.db.Query(ctx, `
+    metrics, err := h.metricsSummary(ctx, startTime)
+    if err != nil {
+        logger.Error("Fetching metrics failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch metrics")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(metrics)
+}
+
+// getDomainMetrics returns metrics for a specific domain
+func (h *Handlers) getDomainMetrics(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    domainID := chi.URLParam(r, "domainID")
+    
+    timeRange := r.URL.Query().Get("range")
+    if timeRange == "" {
+        timeRange = "24h"
+    }
+    
+    duration, err := time.ParseDuration(timeRange)
+    if err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid time range")
+        return
+    }
+
+    startTime := time.Now().Add(-duration)
+    
+    // Get metrics in time series format
+    metrics, err := h.domainMetricSeries(ctx, domainID, startTime)
+    if err != nil {
+        logger.Error("Fetching domain metrics failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch metrics")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(metrics)
+}
+
+// errorRate returns errors/requests, or 0 when there were no requests
+func errorRate(errors, requests int) float64 {
+    if requests == 0 {
+        return 0
+    }
+    return float64(errors) / float64(requests)
+}
+
+// metricsSummary aggregates request metrics per visible domain since
+// startTime
+func (h *Handlers) metricsSummary(ctx context.Context, startTime time.Time) ([]map[string]interface{}, error) {
+    userID, isAdmin, orgID := domainVisibility(ctx)
+    rows, err := h.reader().Query(ctx, `
         SELECT 
             domain_id,
             SUM(request_count) as total_requests,
@@ -37,14 +90,11 @@ func (h *Handlers) getGlobalMetrics(w http.ResponseWriter, r *http.Request) {
             MAX(p95_latency_ms) as max_p95_latency,
             MAX(p99_latency_ms) as max_p99_latency
         FROM request_metrics
-        WHERE timestamp > $1
+        WHERE timestamp > $1 AND `+visibleDomainsSQL("domain_id", 2, 3, 4)+`
         GROUP BY domain_id
-    `, startTime)
-    
+    `, startTime, userID, isAdmin, orgID)
     if err != nil {
-        log.Printf("Error fetching metrics: %v", err)
-        http.Error(w, "Failed to fetch metrics", http.StatusInternalServerError)
-        return
+        return nil, err
     }
     defer rows.Close()
 
@@ -64,7 +114,7 @@ func (h *Handlers) getGlobalMetrics(w http.ResponseWriter, r *http.Request) {
             &m.AvgLatency, &m.MaxP95Latency, &m.MaxP99Latency,
         )
         if err != nil {
-            log.Printf("Error scanning metrics: %v", err)
+            logger.Error("Scanning metrics failed", "error", err)
             continue
         }
         
@@ -72,37 +122,19 @@ func (h *Handlers) getGlobalMetrics(w http.ResponseWriter, r *http.Request) {
             "domain_id":          m.DomainID,
             "total_requests":     m.TotalRequests,
             "total_errors":       m.TotalErrors,
-            "error_rate":         float64(m.TotalErrors) / float64(m.TotalRequests),
+            "error_rate":         errorRate(m.TotalErrors, m.TotalRequests),
             "avg_latency_ms":     m.AvgLatency,
             "max_p95_latency_ms": m.MaxP95Latency,
             "max_p99_latency_ms": m.MaxP99Latency,
         })
     }
-
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(metrics)
+    return metrics, rows.Err()
 }
 
-// getDomainMetrics returns metrics for a specific domain
-func (h *Handlers) getDomainMetrics(w http.ResponseWriter, r *http.Request) {
-    ctx := r.Context()
-    domainID := chi.URLParam(r, "domainID")
-    
-    timeRange := r.URL.Query().Get("range")
-    if timeRange == "" {
-        timeRange = "24h"
-    }
-    
-    duration, err := time.ParseDuration(timeRange)
-    if err != nil {
-        http.Error(w, "Invalid time range", http.StatusBadRequest)
-        return
-    }
-
-    startTime := time.Now().Add(-duration)
-    
-    // Get metrics in time series format
-    rows, err := h.db.Query(ctx, `
+// domainMetricSeries returns the metric time series of a domain since
+// startTime, newest first
+func (h *Handlers) domainMetricSeries(ctx context.Context, domainID interface{}, startTime time.Time) ([]map[string]interface{}, error) {
+    rows, err := h.reader().Query(ctx, `
         SELECT 
             timestamp,
             request_count,
@@ -114,11 +146,8 @@ func (h *Handlers) getDomainMetrics(w http.ResponseWriter, r *http.Request) {
         WHERE domain_id = $1 AND timestamp > $2
         ORDER BY timestamp DESC
     `, domainID, startTime)
-    
     if err != nil {
-        log.Printf("Error fetching domain metrics: %v", err)
-        http.Error(w, "Failed to fetch metrics", http.StatusInternalServerError)
-        return
+        return nil, err
     }
     defer rows.Close()
 
@@ -138,7 +167,7 @@ func (h *Handlers) getDomainMetrics(w http.ResponseWriter, r *http.Request) {
             &m.AvgLatency, &m.P95Latency, &m.P99Latency,
         )
         if err != nil {
-            log.Printf("Error scanning domain metrics: %v", err)
+            logger.Error("Scanning domain metrics failed", "error", err)
             continue
         }
         
@@ -146,15 +175,13 @@ func (h *Handlers) getDomainMetrics(w http.ResponseWriter, r *http.Request) {
             "timestamp":      m.Timestamp,
             "requests":       m.Requests,
             "errors":        m.Errors,
-            "error_rate":    float64(m.Errors) / float64(m.Requests),
+            "error_rate":    errorRate(m.Errors, m.Requests),
             "avg_latency":   m.AvgLatency,
             "p95_latency":   m.P95Latency,
             "p99_latency":   m.P99Latency,
         })
     }
-
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(metrics)
+    return metrics, rows.Err()
 }
 
 // getGlobalLogs returns logs across all domains with filtering
@@ -178,10 +205,11 @@ func (h *Handlers) getGlobalLogs(w http.ResponseWriter, r *http.Request) {
             path, status_code, response_time_ms,
             user_agent, referer
         FROM request_logs
-        WHERE 1=1
+        WHERE ` + visibleDomainsSQL("domain_id", 1, 2, 3) + `
     `
-    args := []interface{}{}
-    argCount := 1
+    userID, isAdmin, orgID := domainVisibility(ctx)
+    args := []interface{}{userID, isAdmin, orgID}
+    argCount := 4
 
     if statusCode != 0 {
         query += ` AND status_code = $` + strconv.Itoa(argCount)
@@ -204,10 +232,10 @@ func (h *Handlers) getGlobalLogs(w http.ResponseWriter, r *http.Request) {
     query += ` ORDER BY timestamp DESC LIMIT $` + strconv.Itoa(argCount)
     args = append(args, limit)
 
-    rows, err := h.db.Query(ctx, query, args...)
+    rows, err := h.reader().Query(ctx, query, args...)
     if err != nil {
-        log.Printf("Error fetching logs: %v", err)
-        http.Error(w, "Failed to fetch logs", http.StatusInternalServerError)
+        logger.Error("Fetching logs failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch logs")
         return
     }
     defer rows.Close()
@@ -233,7 +261,7 @@ func (h *Handlers) getGlobalLogs(w http.ResponseWriter, r *http.Request) {
             &l.UserAgent, &l.Referer,
         )
         if err != nil {
-            log.Printf("Error scanning log: %v", err)
+            logger.Error("Scanning log failed", "error", err)
             continue
         }
         
@@ -301,10 +329,10 @@ func (h *Handlers) getDomainLogs(w http.ResponseWriter, r *http.Request) {
     query += ` ORDER BY timestamp DESC LIMIT $` + strconv.Itoa(argCount)
     args = append(args, limit)
 
-    rows, err := h.db.Query(ctx, query, args...)
+    rows, err := h.reader().Query(ctx, query, args...)
     if err != nil {
-        log.Printf("Error fetching domain logs: %v", err)
-        http.Error(w, "Failed to fetch logs", http.StatusInternalServerError)
+        logger.Error("Fetching domain logs failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch logs")
         return
     }
     defer rows.Close()
@@ -329,7 +357,7 @@ func (h *Handlers) getDomainLogs(w http.ResponseWriter, r *http.Request) {
             &l.UserAgent, &l.Referer,
         )
         if err != nil {
-            log.Printf("Error scanning domain log: %v", err)
+            logger.Error("Scanning domain log failed", "error", err)
             continue
         }
         
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"viacortex/internal/db"
+	"viacortex/internal/oidc"
+)
+
+// SetSSO enables single sign-on through an external identity provider
+func (h *Handlers) SetSSO(flow *oidc.Flow) {
+    h.sso = flow
+}
+
+// beginSSOLogin redirects the browser to the identity provider
+func (h *Handlers) beginSSOLogin(w http.ResponseWriter, r *http.Request) {
+    if h.sso == nil {
+        writeError(w, r, http.StatusNotFound, "Single sign-on is not configured")
+        return
+    }
+
+    target, err := h.sso.Begin(r.Context())
+    if err != nil {
+        logger.Error("Starting SSO login failed", "error", err)
+        writeError(w, r, http.StatusBadGateway, "Identity provider is unavailable")
+        return
+    }
+    http.Redirect(w, r, target, http.StatusFound)
+}
+
+// ssoCallback completes a login at the identity provider, provisioning the
+// user on first sign-in and keeping their role in sync with their groups
+func (h *Handlers) ssoCallback(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    if h.sso == nil {
+        writeError(w, r, http.StatusNotFound, "Single sign-on is not configured")
+        return
+    }
+
+    q := r.URL.Query()
+    if idpErr := q.Get("error"); idpErr != "" {
+        h.emitLoginFailed(r, "", "sso_"+idpErr)
+        writeError(w, r, http.StatusUnauthorized, "Sign-in was not completed: "+idpErr)
+        return
+    }
+
+    identity, role, err := h.sso.Complete(ctx, q.Get("state"), q.Get("code"))
+    if err != nil {
+        email := ""
+        if identity != nil {
+            email = identity.Email
+        }
+        h.emitLoginFailed(r, email, "sso")
+        switch {
+        case errors.Is(err, oidc.ErrUnknownState):
+            writeError(w, r, http.StatusBadRequest, "Sign-in link has expired; start again")
+        case errors.Is(err, oidc.ErrNotAllowed):
+            logger.Warn("SSO login refused", "error", err)
+            writeError(w, r, http.StatusForbidden, "This account is not allowed to sign in")
+        default:
+            logger.Error("SSO login failed", "error", err)
+            writeError(w, r, http.StatusBadGateway, "Could not verify the sign-in with the identity provider")
+        }
+        return
+    }
+
+    user, ok := h.provisionSSOUser(w, r, identity, role)
+    if !ok {
+        return
+    }
+
+    if h.sso.LoginRedirect == "" {
+        h.writeLoginResponse(w, r, user)
+        return
+    }
+
+    // Hand the tokens to the dashboard in the fragment, which browsers do
+    // not send to servers or write to access logs
+    tokens, err := h.loginTokens(r, user)
+    if err != nil {
+        writeError(w, r, http.StatusInternalServerError, "Failed to generate tokens")
+        return
+    }
+    fragment := url.Values{
+        "access_token":  {tokens.AccessToken},
+        "refresh_token": {tokens.RefreshToken},
+    }
+    http.Redirect(w, r, strings.SplitN(h.sso.LoginRedirect, "#", 2)[0]+"#"+fragment.Encode(), http.StatusFound)
+}
+
+// provisionSSOUser finds or creates the local account for an identity and
+// records the login
+func (h *Handlers) provisionSSOUser(w http.ResponseWriter, r *http.Request, identity *oidc.Identity, role string) (db.User, bool) {
+    ctx := r.Context()
+
+    tx, err := h.db.Begin(ctx)
+    if err != nil {
+        logger.Error("Starting transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return db.User{}, false
+    }
+    defer tx.Rollback(ctx)
+
+    user, _, err := provisionExternalUser(ctx, tx, externalUser{
+        Email:   identity.Email,
+        Name:    identity.Name,
+        Role:    role,
+        Source:  "sso",
+        Subject: identity.Subject,
+    })
+    if errors.Is(err, errAccountDeactivated) {
+        h.emitLoginFailed(r, user.Email, "deactivated")
+        writeError(w, r, http.StatusForbidden, "Account is deactivated")
+        return db.User{}, false
+    }
+    if err != nil {
+        logger.Error("Provisioning SSO user failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return db.User{}, false
+    }
+
+    // Second factors are enforced by the identity provider
+    if !h.completeExternalLogin(w, r, tx, user, "sso") {
+        return db.User{}, false
+    }
+    return user, true
+}
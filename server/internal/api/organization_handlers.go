@@ -0,0 +1,670 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"viacortex/internal/db"
+	"viacortex/internal/middleware"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v4"
+)
+
+// Roles a user can hold in an organization. Members of an organization
+// inherit a role on each of its domains through orgDomainRole.
+const (
+    orgRoleViewer = "viewer"
+    orgRoleMember = "member"
+    orgRoleAdmin  = "admin"
+    orgRoleOwner  = "owner"
+)
+
+var orgRoleRank = map[string]int{
+    orgRoleViewer: 1,
+    orgRoleMember: 2,
+    orgRoleAdmin:  3,
+    orgRoleOwner:  4,
+}
+
+// orgDomainRole is the domain role an organization role grants on every
+// domain of the organization
+var orgDomainRole = map[string]string{
+    orgRoleViewer: domainRoleViewer,
+    orgRoleMember: domainRoleEditor,
+    orgRoleAdmin:  domainRoleOwner,
+    orgRoleOwner:  domainRoleOwner,
+}
+
+// OrganizationHeader selects the organization a request acts in. Without it
+// requests span every organization the user belongs to.
+const OrganizationHeader = "X-Organization-ID"
+
+var orgSlugPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// orgKey carries the organization selected for the request
+type orgKey struct{}
+
+// domainOrgKey carries the organization of the domain a request targets
+type domainOrgKey struct{}
+
+type orgContext struct {
+    ID   int64
+    Role string
+}
+
+// selectedOrg returns the organization the request acts in; the zero value
+// when none was selected
+func selectedOrg(ctx context.Context) orgContext {
+    org, _ := ctx.Value(orgKey{}).(orgContext)
+    return org
+}
+
+// auditOrg returns the organization an audit entry belongs to: that of the
+// domain being changed, else the selected one
+func auditOrg(ctx context.Context) int64 {
+    if id, _ := ctx.Value(domainOrgKey{}).(int64); id != 0 {
+        return id
+    }
+    return selectedOrg(ctx).ID
+}
+
+// orgRole returns the current user's role in an organization, or "" when
+// they are not a member or it does not exist. Admins act as owners.
+func (h *Handlers) orgRole(ctx context.Context, orgID int64) (string, error) {
+    var role string
+    err := h.db.QueryRow(ctx, `
+        SELECT CASE WHEN $3::boolean THEN $4 ELSE COALESCE(m.role, '') END
+        FROM organizations o
+        LEFT JOIN organization_members m ON m.org_id = o.id AND m.user_id = $2
+        WHERE o.id = $1
+    `, orgID, getUserIDFromContext(ctx), middleware.GetRoleFromContext(ctx) == middleware.RoleAdmin, orgRoleOwner).Scan(&role)
+    if err == pgx.ErrNoRows {
+        return "", nil
+    }
+    return role, err
+}
+
+// withOrganization selects the organization named in the X-Organization-ID
+// header for the rest of the request. Users may only select organizations
+// they belong to.
+func (h *Handlers) withOrganization(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        header := r.Header.Get(OrganizationHeader)
+        if header == "" {
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        orgID, err := strconv.ParseInt(header, 10, 64)
+        if err != nil || orgID <= 0 {
+            writeError(w, r, http.StatusBadRequest, "Invalid "+OrganizationHeader+" header")
+            return
+        }
+
+        role, err := h.orgRole(r.Context(), orgID)
+        if err != nil {
+            logger.Error("Checking organization access failed", "error", err)
+            writeError(w, r, http.StatusInternalServerError, "Server error")
+            return
+        }
+        if role == "" {
+            writeError(w, r, http.StatusNotFound, "Organization not found")
+            return
+        }
+
+        ctx := context.WithValue(r.Context(), orgKey{}, orgContext{ID: orgID, Role: role})
+        next.ServeHTTP(w, r.WithContext(ctx))
+    })
+}
+
+// checkOrgRole rejects the request unless the user holds at least min in the
+// selected organization. It passes when no organization is selected.
+func checkOrgRole(w http.ResponseWriter, r *http.Request, min string) bool {
+    org := selectedOrg(r.Context())
+    if org.ID != 0 && orgRoleRank[org.Role] < orgRoleRank[min] {
+        writeError(w, r, http.StatusForbidden, fmt.Sprintf("Requires the %s role in this organization", min))
+        return false
+    }
+    return true
+}
+
+// requireOrgRole rejects requests for the organization in the {orgID} URL
+// parameter unless the user holds at least min in it, and selects that
+// organization for the rest of the request. Organizations the user does not
+// belong to are reported as not found.
+func (h *Handlers) requireOrgRole(min string) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            orgID, err := strconv.ParseInt(chi.URLParam(r, "orgID"), 10, 64)
+            if err != nil {
+                writeError(w, r, http.StatusBadRequest, "Invalid organization ID")
+                return
+            }
+
+            role, err := h.orgRole(r.Context(), orgID)
+            if err != nil {
+                logger.Error("Checking organization access failed", "error", err)
+                writeError(w, r, http.StatusInternalServerError, "Server error")
+                return
+            }
+            if role == "" {
+                writeError(w, r, http.StatusNotFound, "Organization not found")
+                return
+            }
+            if orgRoleRank[role] < orgRoleRank[min] {
+                writeError(w, r, http.StatusForbidden, fmt.Sprintf("Requires the %s role in this organization", min))
+                return
+            }
+
+            ctx := context.WithValue(r.Context(), orgKey{}, orgContext{ID: orgID, Role: role})
+            next.ServeHTTP(w, r.WithContext(ctx))
+        })
+    }
+}
+
+// organizationRequest is the body of create and update requests. The slug
+// is derived from the name when left out.
+type organizationRequest struct {
+    Name string `json:"name"`
+    Slug string `json:"slug"`
+}
+
+func decodeOrganization(w http.ResponseWriter, r *http.Request) (organizationRequest, bool) {
+    var req organizationRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return req, false
+    }
+
+    req.Name = strings.TrimSpace(req.Name)
+    if req.Name == "" {
+        writeError(w, r, http.StatusBadRequest, "Name is required")
+        return req, false
+    }
+    if req.Slug == "" {
+        req.Slug = slugify(req.Name)
+    }
+    if !orgSlugPattern.MatchString(req.Slug) {
+        writeError(w, r, http.StatusBadRequest, "Slug must be 1-63 lowercase letters, digits or dashes")
+        return req, false
+    }
+    return req, true
+}
+
+// slugify turns a display name into a URL-safe slug
+func slugify(name string) string {
+    var b strings.Builder
+    dash := false
+    for _, c := range strings.ToLower(name) {
+        if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') {
+            b.WriteRune(c)
+            dash = false
+        } else if !dash && b.Len() > 0 {
+            b.WriteByte('-')
+            dash = true
+        }
+    }
+    slug := strings.TrimRight(b.String(), "-")
+    if len(slug) > 63 {
+        slug = strings.TrimRight(slug[:63], "-")
+    }
+    return slug
+}
+
+// getOrganizations lists the organizations the user belongs to, or every
+// organization for admins, with the user's role in each
+func (h *Handlers) getOrganizations(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    userID, isAdmin, _ := domainVisibility(ctx)
+
+    rows, err := h.db.Query(ctx, `
+        SELECT o.id, o.name, o.slug, CASE WHEN $2::boolean THEN $3 ELSE m.role END,
+               o.created_at, o.updated_at
+        FROM organizations o
+        LEFT JOIN organization_members m ON m.org_id = o.id AND m.user_id = $1
+        WHERE m.user_id IS NOT NULL OR $2::boolean
+        ORDER BY o.name
+    `, userID, isAdmin, orgRoleOwner)
+    if err != nil {
+        logger.Error("Fetching organizations failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch organizations")
+        return
+    }
+    defer rows.Close()
+
+    orgs := []db.Organization{}
+    for rows.Next() {
+        var o db.Organization
+        if err := rows.Scan(&o.ID, &o.Name, &o.Slug, &o.Role, &o.CreatedAt, &o.UpdatedAt); err != nil {
+            logger.Error("Scanning organization failed", "error", err)
+            continue
+        }
+        orgs = append(orgs, o)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(orgs)
+}
+
+// createOrganization creates an organization owned by the current user
+func (h *Handlers) createOrganization(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    req, ok := decodeOrganization(w, r)
+    if !ok {
+        return
+    }
+    userID := getUserIDFromContext(ctx)
+
+    tx, err := h.db.Begin(ctx)
+    if err != nil {
+        logger.Error("Starting transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    defer tx.Rollback(ctx)
+
+    var org db.Organization
+    err = tx.QueryRow(ctx, `
+        INSERT INTO organizations (name, slug)
+        VALUES ($1, $2)
+        RETURNING id, name, slug, created_at, updated_at
+    `, req.Name, req.Slug).Scan(&org.ID, &org.Name, &org.Slug, &org.CreatedAt, &org.UpdatedAt)
+    if err != nil {
+        if strings.Contains(err.Error(), "organizations_slug_key") {
+            writeError(w, r, http.StatusConflict, "Slug is already taken")
+            return
+        }
+        logger.Error("Creating organization failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to create organization")
+        return
+    }
+    org.Role = orgRoleOwner
+
+    if _, err := tx.Exec(ctx, `
+        INSERT INTO organization_members (org_id, user_id, role) VALUES ($1, $2, $3)
+    `, org.ID, userID, orgRoleOwner); err != nil {
+        logger.Error("Adding organization owner failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to create organization")
+        return
+    }
+
+    // Record audit log
+    ctx = context.WithValue(ctx, orgKey{}, orgContext{ID: org.ID, Role: orgRoleOwner})
+    if err := writeAudit(ctx, tx, userID, "create", "organization", org.ID, nil,
+        map[string]interface{}{"name": org.Name, "slug": org.Slug}); err != nil {
+        logger.Error("Creating audit log failed", "error", err)
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        logger.Error("Committing transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    h.publishAudit(userID, "create", "organization", org.ID)
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(org)
+}
+
+// getOrganization returns an organization with the user's role in it
+func (h *Handlers) getOrganization(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    org := selectedOrg(ctx)
+
+    o := db.Organization{ID: org.ID, Role: org.Role}
+    err := h.db.QueryRow(ctx, `
+        SELECT name, slug, created_at, updated_at FROM organizations WHERE id = $1
+    `, org.ID).Scan(&o.Name, &o.Slug, &o.CreatedAt, &o.UpdatedAt)
+    if err == pgx.ErrNoRows {
+        writeError(w, r, http.StatusNotFound, "Organization not found")
+        return
+    }
+    if err != nil {
+        logger.Error("Fetching organization failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch organization")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(o)
+}
+
+// updateOrganization renames an organization
+func (h *Handlers) updateOrganization(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    org := selectedOrg(ctx)
+    req, ok := decodeOrganization(w, r)
+    if !ok {
+        return
+    }
+
+    var before organizationRequest
+    err := h.db.QueryRow(ctx, "SELECT name, slug FROM organizations WHERE id = $1", org.ID).Scan(&before.Name, &before.Slug)
+    if err == pgx.ErrNoRows {
+        writeError(w, r, http.StatusNotFound, "Organization not found")
+        return
+    }
+    if err != nil {
+        logger.Error("Fetching organization failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to update organization")
+        return
+    }
+
+    o := db.Organization{ID: org.ID, Role: org.Role}
+    err = h.db.QueryRow(ctx, `
+        UPDATE organizations SET name = $1, slug = $2, updated_at = CURRENT_TIMESTAMP
+        WHERE id = $3
+        RETURNING name, slug, created_at, updated_at
+    `, req.Name, req.Slug, org.ID).Scan(&o.Name, &o.Slug, &o.CreatedAt, &o.UpdatedAt)
+    if err != nil {
+        if strings.Contains(err.Error(), "organizations_slug_key") {
+            writeError(w, r, http.StatusConflict, "Slug is already taken")
+            return
+        }
+        logger.Error("Updating organization failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to update organization")
+        return
+    }
+
+    // Record audit log
+    if err := h.recordAudit(ctx, getUserIDFromContext(ctx), "update", "organization", org.ID,
+        map[string]interface{}{"name": before.Name, "slug": before.Slug},
+        map[string]interface{}{"name": o.Name, "slug": o.Slug}); err != nil {
+        logger.Error("Creating audit log failed", "error", err)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(o)
+}
+
+// deleteOrganization deletes an organization that no longer has domains
+func (h *Handlers) deleteOrganization(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    org := selectedOrg(ctx)
+
+    tx, err := h.db.Begin(ctx)
+    if err != nil {
+        logger.Error("Starting transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    defer tx.Rollback(ctx)
+
+    var before organizationRequest
+    err = tx.QueryRow(ctx, "SELECT name, slug FROM organizations WHERE id = $1 FOR UPDATE", org.ID).Scan(&before.Name, &before.Slug)
+    if err == pgx.ErrNoRows {
+        writeError(w, r, http.StatusNotFound, "Organization not found")
+        return
+    }
+    if err != nil {
+        logger.Error("Fetching organization failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to delete organization")
+        return
+    }
+
+    var domains int
+    if err := tx.QueryRow(ctx, "SELECT COUNT(*) FROM domains WHERE org_id = $1", org.ID).Scan(&domains); err != nil {
+        logger.Error("Counting organization domains failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to delete organization")
+        return
+    }
+    if domains > 0 {
+        writeError(w, r, http.StatusConflict, "Delete the organization's domains first")
+        return
+    }
+
+    if _, err := tx.Exec(ctx, "DELETE FROM organizations WHERE id = $1", org.ID); err != nil {
+        logger.Error("Deleting organization failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to delete organization")
+        return
+    }
+
+    // Record audit log
+    userID := getUserIDFromContext(ctx)
+    if err := writeAudit(ctx, tx, userID, "delete", "organization", org.ID,
+        map[string]interface{}{"name": before.Name, "slug": before.Slug}, nil); err != nil {
+        logger.Error("Creating audit log failed", "error", err)
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        logger.Error("Committing transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    h.publishAudit(userID, "delete", "organization", org.ID)
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// getOrganizationMembers lists the members of an organization
+func (h *Handlers) getOrganizationMembers(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+
+    rows, err := h.db.Query(ctx, `
+        SELECT m.org_id, m.user_id, u.email, m.role, m.created_at
+        FROM organization_members m
+        JOIN users u ON u.id = m.user_id
+        WHERE m.org_id = $1
+        ORDER BY u.email
+    `, selectedOrg(ctx).ID)
+    if err != nil {
+        logger.Error("Fetching organization members failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch organization members")
+        return
+    }
+    defer rows.Close()
+
+    members := []db.OrganizationMember{}
+    for rows.Next() {
+        var m db.OrganizationMember
+        if err := rows.Scan(&m.OrgID, &m.UserID, &m.Email, &m.Role, &m.CreatedAt); err != nil {
+            logger.Error("Scanning organization member failed", "error", err)
+            continue
+        }
+        members = append(members, m)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(members)
+}
+
+// setOrganizationMember adds a user to an organization or changes their
+// role. Only owners may grant or take away the owner role.
+func (h *Handlers) setOrganizationMember(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    org := selectedOrg(ctx)
+    userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+    if err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid user ID")
+        return
+    }
+
+    var req struct {
+        Role string `json:"role"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+    if _, ok := orgRoleRank[req.Role]; !ok {
+        writeError(w, r, http.StatusBadRequest, "Role must be viewer, member, admin or owner")
+        return
+    }
+
+    tx, err := h.db.Begin(ctx)
+    if err != nil {
+        logger.Error("Starting transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    defer tx.Rollback(ctx)
+
+    previous, ok := lockOrgMember(w, r, tx, org.ID, userID)
+    if !ok {
+        return
+    }
+    if (req.Role == orgRoleOwner || previous == orgRoleOwner) && org.Role != orgRoleOwner {
+        writeError(w, r, http.StatusForbidden, "Only owners can grant or revoke the owner role")
+        return
+    }
+    if previous == orgRoleOwner && req.Role != orgRoleOwner && !checkOtherOrgOwner(w, r, tx, org.ID, userID) {
+        return
+    }
+
+    var userExists bool
+    if err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)", userID).Scan(&userExists); err != nil {
+        logger.Error("Fetching user failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    if !userExists {
+        writeError(w, r, http.StatusNotFound, "User not found")
+        return
+    }
+
+    _, err = tx.Exec(ctx, `
+        INSERT INTO organization_members (org_id, user_id, role)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (org_id, user_id) DO UPDATE SET role = EXCLUDED.role
+    `, org.ID, userID, req.Role)
+    if err != nil {
+        logger.Error("Saving organization member failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to save organization member")
+        return
+    }
+
+    // Record audit log
+    var before interface{}
+    if previous != "" {
+        before = map[string]interface{}{"user_id": userID, "role": previous}
+    }
+    if err := writeAudit(ctx, tx, getUserIDFromContext(ctx), "set_member", "organization", org.ID, before,
+        map[string]interface{}{"user_id": userID, "role": req.Role}); err != nil {
+        logger.Error("Creating audit log failed", "error", err)
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        logger.Error("Committing transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    h.publishAudit(getUserIDFromContext(ctx), "set_member", "organization", org.ID)
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "org_id":  org.ID,
+        "user_id": userID,
+        "role":    req.Role,
+    })
+}
+
+// removeOrganizationMember takes a user out of an organization. Admins may
+// remove others; every member may leave.
+func (h *Handlers) removeOrganizationMember(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    org := selectedOrg(ctx)
+    userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+    if err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid user ID")
+        return
+    }
+    if userID != getUserIDFromContext(ctx) && orgRoleRank[org.Role] < orgRoleRank[orgRoleAdmin] {
+        writeError(w, r, http.StatusForbidden, "Requires the admin role in this organization")
+        return
+    }
+
+    tx, err := h.db.Begin(ctx)
+    if err != nil {
+        logger.Error("Starting transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    defer tx.Rollback(ctx)
+
+    previous, ok := lockOrgMember(w, r, tx, org.ID, userID)
+    if !ok {
+        return
+    }
+    if previous == "" {
+        writeError(w, r, http.StatusNotFound, "Member not found")
+        return
+    }
+    if previous == orgRoleOwner && userID != getUserIDFromContext(ctx) && org.Role != orgRoleOwner {
+        writeError(w, r, http.StatusForbidden, "Only owners can grant or revoke the owner role")
+        return
+    }
+    if previous == orgRoleOwner && !checkOtherOrgOwner(w, r, tx, org.ID, userID) {
+        return
+    }
+
+    if _, err := tx.Exec(ctx, `
+        DELETE FROM organization_members WHERE org_id = $1 AND user_id = $2
+    `, org.ID, userID); err != nil {
+        logger.Error("Removing organization member failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to remove organization member")
+        return
+    }
+
+    // Record audit log
+    if err := writeAudit(ctx, tx, getUserIDFromContext(ctx), "remove_member", "organization", org.ID,
+        map[string]interface{}{"user_id": userID, "role": previous}, nil); err != nil {
+        logger.Error("Creating audit log failed", "error", err)
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        logger.Error("Committing transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    h.publishAudit(getUserIDFromContext(ctx), "remove_member", "organization", org.ID)
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// lockOrgMember locks the organization's member list and returns the user's
+// current role in it, "" if they are not a member
+func lockOrgMember(w http.ResponseWriter, r *http.Request, tx pgx.Tx, orgID, userID int64) (string, bool) {
+    ctx := r.Context()
+
+    if _, err := tx.Exec(ctx, "SELECT id FROM organizations WHERE id = $1 FOR UPDATE", orgID); err != nil {
+        logger.Error("Locking organization failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return "", false
+    }
+
+    var role string
+    err := tx.QueryRow(ctx, `
+        SELECT role FROM organization_members WHERE org_id = $1 AND user_id = $2
+    `, orgID, userID).Scan(&role)
+    if err != nil && err != pgx.ErrNoRows {
+        logger.Error("Fetching organization member failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return "", false
+    }
+    return role, true
+}
+
+// checkOtherOrgOwner refuses to leave an organization without an owner
+func checkOtherOrgOwner(w http.ResponseWriter, r *http.Request, tx pgx.Tx, orgID, userID int64) bool {
+    var others int
+    err := tx.QueryRow(r.Context(), `
+        SELECT COUNT(*) FROM organization_members WHERE org_id = $1 AND user_id <> $2 AND role = $3
+    `, orgID, userID, orgRoleOwner).Scan(&others)
+    if err != nil {
+        logger.Error("Counting organization owners failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return false
+    }
+    if others == 0 {
+        writeError(w, r, http.StatusConflict, "An organization must keep at least one owner")
+        return false
+    }
+    return true
+}
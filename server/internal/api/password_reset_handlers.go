@@ -0,0 +1,274 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"viacortex/internal/mailer"
+
+	"github.com/jackc/pgx/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordResetTTL is how long a reset link stays valid
+const passwordResetTTL = time.Hour
+
+// SetPasswordReset enables the forgot-password flow. linkBase is the page
+// that accepts the token, e.g. "https://admin.example.com/reset-password";
+// the token is appended as ?token= unless linkBase contains "{token}".
+func (h *Handlers) SetPasswordReset(m mailer.Mailer, linkBase string) {
+    h.mailer = m
+    h.resetLinkBase = linkBase
+}
+
+// hashResetToken is what gets stored, so a database leak does not expose
+// usable reset links
+func hashResetToken(token string) string {
+    sum := sha256.Sum256([]byte(token))
+    return hex.EncodeToString(sum[:])
+}
+
+func (h *Handlers) resetLink(token string) string {
+    if strings.Contains(h.resetLinkBase, "{token}") {
+        return strings.ReplaceAll(h.resetLinkBase, "{token}", url.QueryEscape(token))
+    }
+    sep := "?"
+    if strings.Contains(h.resetLinkBase, "?") {
+        sep = "&"
+    }
+    return h.resetLinkBase + sep + "token=" + url.QueryEscape(token)
+}
+
+// requestPasswordReset emails a single-use reset link. It answers 202 whether
+// or not the address belongs to an account so it cannot be used to probe for
+// users.
+func (h *Handlers) requestPasswordReset(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+
+    if h.mailer == nil || h.resetLinkBase == "" {
+        writeError(w, r, http.StatusServiceUnavailable, "Password reset is not configured")
+        return
+    }
+
+    var req struct {
+        Email string `json:"email"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+        writeError(w, r, http.StatusBadRequest, "Email is required")
+        return
+    }
+    email := strings.ToLower(strings.TrimSpace(req.Email))
+
+    accepted := func() {
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(http.StatusAccepted)
+        json.NewEncoder(w).Encode(map[string]string{
+            "message": "If the address belongs to an account, a reset link has been sent",
+        })
+    }
+
+    // Limit mails per address regardless of which client asks
+    if !h.limits.resets.Allow("email:" + email) {
+        logger.Info("Password reset suppressed by rate limit", "email", email)
+        accepted()
+        return
+    }
+
+    var userID int64
+    var userEmail string
+    err := h.db.QueryRow(ctx, `
+        SELECT id, email FROM users WHERE lower(email) = $1 AND active = true
+    `, email).Scan(&userID, &userEmail)
+    if err == pgx.ErrNoRows {
+        accepted()
+        return
+    }
+    if err != nil {
+        logger.Error("Looking up user for password reset failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+
+    buf := make([]byte, 32)
+    if _, err := rand.Read(buf); err != nil {
+        logger.Error("Generating reset token failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    token := hex.EncodeToString(buf)
+
+    tx, err := h.db.Begin(ctx)
+    if err != nil {
+        logger.Error("Starting transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    defer tx.Rollback(ctx)
+
+    // A new link replaces any outstanding one; long expired rows are dropped
+    if _, err := tx.Exec(ctx, `
+        DELETE FROM password_resets
+        WHERE (user_id = $1 AND used_at IS NULL) OR expires_at < CURRENT_TIMESTAMP - INTERVAL '1 day'
+    `, userID); err != nil {
+        logger.Error("Clearing password resets failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+
+    info, _ := ctx.Value(auditRequestKey{}).(auditRequest)
+    var resetID int64
+    err = tx.QueryRow(ctx, `
+        INSERT INTO password_resets (user_id, token_hash, expires_at, requested_ip)
+        VALUES ($1, $2, $3, NULLIF($4, '')::inet)
+        RETURNING id
+    `, userID, hashResetToken(token), time.Now().Add(passwordResetTTL), info.IP).Scan(&resetID)
+    if err != nil {
+        logger.Error("Creating password reset failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+
+    // Record audit log
+    if err := writeAudit(ctx, tx, userID, "request_password_reset", "user", userID, nil, nil); err != nil {
+        logger.Error("Creating audit log failed", "error", err)
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        logger.Error("Committing transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    h.publishAudit(userID, "request_password_reset", "user", userID)
+
+    // Send in the background so response timing does not reveal whether the
+    // account exists
+    msg := mailer.Message{
+        To:      userEmail,
+        Subject: "Reset your ViaCortex password",
+        Body: fmt.Sprintf("A password reset was requested for your ViaCortex account.\n\n"+
+            "Open this link within %d minutes to choose a new password:\n\n%s\n\n"+
+            "If you did not request this, you can ignore this email.\n",
+            int(passwordResetTTL.Minutes()), h.resetLink(token)),
+    }
+    go func() {
+        sendCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+        defer cancel()
+        if err := h.mailer.Send(sendCtx, msg); err != nil {
+            logger.Error("Sending password reset failed", "reset_id", resetID, "error", err)
+        }
+    }()
+
+    accepted()
+}
+
+// confirmPasswordReset sets a new password using a reset token. The token is
+// consumed, other outstanding tokens are discarded and refresh tokens issued
+// before the reset stop working.
+func (h *Handlers) confirmPasswordReset(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+
+    var req struct {
+        Token       string `json:"token"`
+        NewPassword string `json:"new_password"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+        writeError(w, r, http.StatusBadRequest, "Token is required")
+        return
+    }
+    if len(req.NewPassword) < minPasswordLength {
+        writeError(w, r, http.StatusBadRequest, fmt.Sprintf("New password must be at least %d characters", minPasswordLength))
+        return
+    }
+
+    tx, err := h.db.Begin(ctx)
+    if err != nil {
+        logger.Error("Starting transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    defer tx.Rollback(ctx)
+
+    var resetID, userID int64
+    err = tx.QueryRow(ctx, `
+        SELECT pr.id, pr.user_id
+        FROM password_resets pr
+        JOIN users u ON u.id = pr.user_id
+        WHERE pr.token_hash = $1 AND pr.used_at IS NULL
+          AND pr.expires_at > CURRENT_TIMESTAMP AND u.active = true
+        FOR UPDATE OF pr
+    `, hashResetToken(req.Token)).Scan(&resetID, &userID)
+    if err == pgx.ErrNoRows {
+        writeError(w, r, http.StatusBadRequest, "Reset link is invalid or has expired")
+        return
+    }
+    if err != nil {
+        logger.Error("Looking up password reset failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+
+    hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+    if err != nil {
+        logger.Error("Hashing password failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+
+    // A successful reset also lifts any brute-force lockout
+    if _, err := tx.Exec(ctx, `
+        UPDATE users
+        SET password_hash = $1, updated_at = CURRENT_TIMESTAMP,
+            tokens_valid_after = date_trunc('second', CURRENT_TIMESTAMP),
+            failed_login_count = 0, locked_until = NULL
+        WHERE id = $2
+    `, string(hashedPassword), userID); err != nil {
+        logger.Error("Resetting password failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to reset password")
+        return
+    }
+
+    // Sign out every session that may have been opened with the old password
+    if _, err := deleteSessions(ctx, tx, userID, "", ""); err != nil {
+        logger.Error("Revoking sessions failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to reset password")
+        return
+    }
+
+    if _, err := tx.Exec(ctx, `
+        UPDATE password_resets SET used_at = CURRENT_TIMESTAMP WHERE id = $1
+    `, resetID); err != nil {
+        logger.Error("Consuming password reset failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to reset password")
+        return
+    }
+    if _, err := tx.Exec(ctx, `
+        DELETE FROM password_resets WHERE user_id = $1 AND used_at IS NULL
+    `, userID); err != nil {
+        logger.Error("Clearing password resets failed", "error", err)
+    }
+
+    // Record audit log
+    if err := writeAudit(ctx, tx, userID, "reset_password", "user", userID, nil, nil); err != nil {
+        logger.Error("Creating audit log failed", "error", err)
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        logger.Error("Committing transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    h.publishAudit(userID, "reset_password", "user", userID)
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]string{
+        "message": "Password has been reset; log in with the new password",
+    })
+}
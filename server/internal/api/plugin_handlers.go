@@ -0,0 +1,394 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"viacortex/internal/db"
+	"viacortex/internal/proxy"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxPluginSize bounds an uploaded WASM module, in bytes
+const maxPluginSize = 10 << 20
+
+// pluginRequest is the body of a plugin upload; Wasm is base64 in JSON
+type pluginRequest struct {
+    Name          string  `json:"name"`
+    Description   *string `json:"description"`
+    Wasm          []byte  `json:"wasm"`
+    MemoryLimitMB int     `json:"memory_limit_mb"`
+    TimeoutMs     int     `json:"timeout_ms"`
+    FailOpen      *bool   `json:"fail_open"`
+}
+
+// validate fills in defaults and checks the module, returning a message for
+// the client when the request is invalid
+func (req *pluginRequest) validate(ctx context.Context) string {
+    if req.MemoryLimitMB == 0 {
+        req.MemoryLimitMB = 16
+    }
+    if req.TimeoutMs == 0 {
+        req.TimeoutMs = 50
+    }
+    if req.FailOpen == nil {
+        failOpen := true
+        req.FailOpen = &failOpen
+    }
+    switch {
+    case req.Name == "" || len(req.Name) > 100:
+        return "name must be between 1 and 100 characters"
+    case len(req.Wasm) == 0:
+        return "wasm is required"
+    case req.MemoryLimitMB < 1 || req.MemoryLimitMB > 256:
+        return "memory_limit_mb must be between 1 and 256"
+    case req.TimeoutMs < 1 || time.Duration(req.TimeoutMs)*time.Millisecond > proxy.MaxPluginTimeout:
+        return "timeout_ms must be between 1 and 5000"
+    }
+    if err := proxy.ValidatePlugin(ctx, req.Wasm, req.MemoryLimitMB); err != nil {
+        return "Invalid plugin: " + err.Error()
+    }
+    return ""
+}
+
+// loadPlugin returns a plugin without its module, or nil when it does not
+// exist
+func (h *Handlers) loadPlugin(ctx context.Context, pluginID int64) (*db.Plugin, error) {
+    p := db.Plugin{ID: pluginID}
+    err := h.db.QueryRow(ctx, `
+        SELECT name, description, sha256, octet_length(wasm), memory_limit_mb, timeout_ms,
+               fail_open, created_by, created_at, updated_at
+        FROM plugins
+        WHERE id = $1
+    `, pluginID).Scan(
+        &p.Name, &p.Description, &p.SHA256, &p.Size, &p.MemoryLimitMB, &p.TimeoutMs,
+        &p.FailOpen, &p.CreatedBy, &p.CreatedAt, &p.UpdatedAt,
+    )
+    if err != nil {
+        if err.Error() == "no rows in result set" {
+            return nil, nil
+        }
+        return nil, err
+    }
+    return &p, nil
+}
+
+// getPlugins lists the uploaded plugins
+func (h *Handlers) getPlugins(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+
+    rows, err := h.db.Query(ctx, `
+        SELECT id, name, description, sha256, octet_length(wasm), memory_limit_mb, timeout_ms,
+               fail_open, created_by, created_at, updated_at
+        FROM plugins
+        ORDER BY name
+    `)
+    if err != nil {
+        logger.Error("Fetching plugins failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch plugins")
+        return
+    }
+    defer rows.Close()
+
+    plugins := []db.Plugin{}
+    for rows.Next() {
+        var p db.Plugin
+        if err := rows.Scan(&p.ID, &p.Name, &p.Description, &p.SHA256, &p.Size, &p.MemoryLimitMB,
+            &p.TimeoutMs, &p.FailOpen, &p.CreatedBy, &p.CreatedAt, &p.UpdatedAt); err != nil {
+            logger.Error("Scanning plugin failed", "error", err)
+            continue
+        }
+        plugins = append(plugins, p)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(plugins)
+}
+
+// getPlugin returns a plugin
+func (h *Handlers) getPlugin(w http.ResponseWriter, r *http.Request) {
+    p, err := h.loadPlugin(r.Context(), mustParseInt64(chi.URLParam(r, "pluginID")))
+    if err != nil {
+        logger.Error("Fetching plugin failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch plugin")
+        return
+    }
+    if p == nil {
+        writeError(w, r, http.StatusNotFound, "Plugin not found")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(p)
+}
+
+// createPlugin uploads a WASM module as a new plugin, after checking that it
+// implements the plugin interface
+func (h *Handlers) createPlugin(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+
+    var req pluginRequest
+    // Base64 takes a third more than the module itself
+    r.Body = http.MaxBytesReader(w, r.Body, maxPluginSize*4/3+4096)
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+    if msg := req.validate(ctx); msg != "" {
+        writeError(w, r, http.StatusBadRequest, msg)
+        return
+    }
+
+    sum := sha256.Sum256(req.Wasm)
+    userID := getUserIDFromContext(ctx)
+    var pluginID int64
+    err := h.db.QueryRow(ctx, `
+        INSERT INTO plugins (name, description, wasm, sha256, memory_limit_mb, timeout_ms, fail_open, created_by)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, 0))
+        ON CONFLICT (name) DO NOTHING
+        RETURNING id
+    `, req.Name, req.Description, req.Wasm, hex.EncodeToString(sum[:]), req.MemoryLimitMB,
+        req.TimeoutMs, *req.FailOpen, userID).Scan(&pluginID)
+    if err != nil {
+        if err.Error() == "no rows in result set" {
+            writeError(w, r, http.StatusConflict, "A plugin with this name already exists")
+            return
+        }
+        logger.Error("Creating plugin failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to create plugin")
+        return
+    }
+
+    p, err := h.loadPlugin(ctx, pluginID)
+    if err != nil {
+        logger.Error("Fetching plugin failed", "error", err)
+    }
+
+    // Record audit log
+    if err := h.recordAudit(ctx, userID, "create", "plugin", pluginID, nil, p); err != nil {
+        logger.Error("Recording audit failed", "error", err)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(p)
+}
+
+// updatePlugin replaces a plugin's module and settings. Domains running it
+// switch to the new module with their next reload.
+func (h *Handlers) updatePlugin(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    pluginID := mustParseInt64(chi.URLParam(r, "pluginID"))
+
+    var req pluginRequest
+    r.Body = http.MaxBytesReader(w, r.Body, maxPluginSize*4/3+4096)
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+    if msg := req.validate(ctx); msg != "" {
+        writeError(w, r, http.StatusBadRequest, msg)
+        return
+    }
+
+    before, err := h.loadPlugin(ctx, pluginID)
+    if err != nil {
+        logger.Error("Fetching plugin failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to update plugin")
+        return
+    }
+    if before == nil {
+        writeError(w, r, http.StatusNotFound, "Plugin not found")
+        return
+    }
+
+    sum := sha256.Sum256(req.Wasm)
+    _, err = h.db.Exec(ctx, `
+        UPDATE plugins
+        SET name = $2, description = $3, wasm = $4, sha256 = $5, memory_limit_mb = $6,
+            timeout_ms = $7, fail_open = $8, updated_at = CURRENT_TIMESTAMP
+        WHERE id = $1
+    `, pluginID, req.Name, req.Description, req.Wasm, hex.EncodeToString(sum[:]), req.MemoryLimitMB,
+        req.TimeoutMs, *req.FailOpen)
+    if err != nil {
+        logger.Error("Updating plugin failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to update plugin")
+        return
+    }
+
+    after, err := h.loadPlugin(ctx, pluginID)
+    if err != nil {
+        logger.Error("Fetching plugin failed", "error", err)
+    }
+
+    // Record audit log
+    userID := getUserIDFromContext(ctx)
+    if err := h.recordAudit(ctx, userID, "update", "plugin", pluginID, before, after); err != nil {
+        logger.Error("Recording audit failed", "error", err)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(after)
+}
+
+// deletePlugin removes a plugin and detaches it from every domain
+func (h *Handlers) deletePlugin(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    pluginID := mustParseInt64(chi.URLParam(r, "pluginID"))
+
+    before, err := h.loadPlugin(ctx, pluginID)
+    if err != nil {
+        logger.Error("Fetching plugin failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to delete plugin")
+        return
+    }
+    if before == nil {
+        writeError(w, r, http.StatusNotFound, "Plugin not found")
+        return
+    }
+
+    if _, err := h.db.Exec(ctx, "DELETE FROM plugins WHERE id = $1", pluginID); err != nil {
+        logger.Error("Deleting plugin failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to delete plugin")
+        return
+    }
+
+    // Record audit log
+    userID := getUserIDFromContext(ctx)
+    if err := h.recordAudit(ctx, userID, "delete", "plugin", pluginID, before, nil); err != nil {
+        logger.Error("Recording audit failed", "error", err)
+    }
+
+    json.NewEncoder(w).Encode(map[string]string{
+        "message": "Plugin deleted successfully",
+    })
+}
+
+// loadDomainPlugins returns the plugins a domain runs, in order
+func loadDomainPlugins(ctx context.Context, q sessionQuerier, domainID int64) ([]db.DomainPlugin, error) {
+    rows, err := q.Query(ctx, `
+        SELECT dp.plugin_id, p.name, dp.position, dp.config
+        FROM domain_plugins dp
+        JOIN plugins p ON p.id = dp.plugin_id
+        WHERE dp.domain_id = $1
+        ORDER BY dp.position, p.id
+    `, domainID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    plugins := []db.DomainPlugin{}
+    for rows.Next() {
+        p := db.DomainPlugin{DomainID: domainID}
+        if err := rows.Scan(&p.PluginID, &p.Name, &p.Position, &p.Config); err != nil {
+            return nil, err
+        }
+        plugins = append(plugins, p)
+    }
+    return plugins, rows.Err()
+}
+
+// getDomainPlugins returns the plugins a domain runs, in order
+func (h *Handlers) getDomainPlugins(w http.ResponseWriter, r *http.Request) {
+    plugins, err := loadDomainPlugins(r.Context(), h.db, mustParseInt64(chi.URLParam(r, "id")))
+    if err != nil {
+        logger.Error("Fetching domain plugins failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch domain plugins")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(plugins)
+}
+
+// setDomainPlugins replaces the plugins a domain runs. Requests pass through
+// them in the given order and responses in reverse.
+func (h *Handlers) setDomainPlugins(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    domainID := mustParseInt64(chi.URLParam(r, "id"))
+
+    var req []struct {
+        PluginID int64           `json:"plugin_id"`
+        Config   json.RawMessage `json:"config"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+    seen := make(map[int64]bool)
+    for _, p := range req {
+        if seen[p.PluginID] {
+            writeError(w, r, http.StatusBadRequest, "A plugin can only be listed once")
+            return
+        }
+        seen[p.PluginID] = true
+    }
+
+    tx, err := h.db.Begin(ctx)
+    if err != nil {
+        logger.Error("Starting transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    defer tx.Rollback(ctx)
+
+    before, err := loadDomainPlugins(ctx, tx, domainID)
+    if err != nil {
+        logger.Error("Fetching domain plugins failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to set domain plugins")
+        return
+    }
+
+    if _, err := tx.Exec(ctx, "DELETE FROM domain_plugins WHERE domain_id = $1", domainID); err != nil {
+        logger.Error("Clearing domain plugins failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to set domain plugins")
+        return
+    }
+    for i, p := range req {
+        var config interface{}
+        if len(p.Config) > 0 && string(p.Config) != "null" {
+            config = p.Config
+        }
+        result, err := tx.Exec(ctx, `
+            INSERT INTO domain_plugins (domain_id, plugin_id, position, config)
+            SELECT $1, id, $3, $4 FROM plugins WHERE id = $2
+        `, domainID, p.PluginID, i, config)
+        if err != nil {
+            logger.Error("Adding domain plugin failed", "error", err)
+            writeError(w, r, http.StatusInternalServerError, "Failed to set domain plugins")
+            return
+        }
+        if result.RowsAffected() == 0 {
+            writeError(w, r, http.StatusBadRequest, "Plugin not found")
+            return
+        }
+    }
+
+    after, err := loadDomainPlugins(ctx, tx, domainID)
+    if err != nil {
+        logger.Error("Fetching domain plugins failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to set domain plugins")
+        return
+    }
+
+    // Record audit log
+    if err := writeAudit(ctx, tx, getUserIDFromContext(ctx), "set_plugins", "domain", domainID, before, after); err != nil {
+        logger.Error("Creating audit log failed", "error", err)
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        logger.Error("Committing transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    h.publishAudit(getUserIDFromContext(ctx), "set_plugins", "domain", domainID)
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(after)
+}
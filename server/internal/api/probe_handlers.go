@@ -0,0 +1,181 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"viacortex/internal/proxy"
+)
+
+// minProbeInterval keeps synthetic probes from turning into load
+const minProbeInterval = 10 * time.Second
+
+// SetProber enables the synthetic latency probes
+func (h *Handlers) SetProber(p *proxy.Prober) {
+    h.prober = p
+}
+
+// probeBaseline summarizes a domain's probe latency over a time range
+type probeBaseline struct {
+    Domain     string  `json:"domain"`
+    Probes     int     `json:"probes"`
+    Failures   int     `json:"failures"`
+    AvgLatency float64 `json:"avg_latency_ms"`
+    P50Latency float64 `json:"p50_latency_ms"`
+    P95Latency float64 `json:"p95_latency_ms"`
+    MaxLatency float64 `json:"max_latency_ms"`
+}
+
+// getProbes returns whether probes are running on this node and the latest
+// result per domain
+func (h *Handlers) getProbes(w http.ResponseWriter, r *http.Request) {
+    if h.prober == nil {
+        writeError(w, r, http.StatusNotFound, "Synthetic probes are not available on this server")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(h.prober.Status())
+}
+
+// startProbes starts or reconfigures the periodic probes on this node
+func (h *Handlers) startProbes(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    if h.prober == nil {
+        writeError(w, r, http.StatusNotFound, "Synthetic probes are not available on this server")
+        return
+    }
+
+    var req struct {
+        IntervalSeconds int    `json:"interval_seconds"`
+        Path            string `json:"path"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+    interval := time.Duration(req.IntervalSeconds) * time.Second
+    if interval == 0 {
+        interval = time.Minute
+    }
+    if interval < minProbeInterval {
+        writeError(w, r, http.StatusBadRequest, "interval_seconds must be at least 10")
+        return
+    }
+    if req.Path == "" {
+        req.Path = "/"
+    }
+    if !strings.HasPrefix(req.Path, "/") {
+        writeError(w, r, http.StatusBadRequest, "path must start with /")
+        return
+    }
+
+    before := h.prober.Status()
+    h.prober.Start(interval, req.Path)
+    after := h.prober.Status()
+
+    // Record audit log
+    userID := getUserIDFromContext(ctx)
+    if err := h.recordAudit(ctx, userID, "start", "probes", 0, before, after); err != nil {
+        logger.Error("Recording audit failed", "error", err)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(after)
+}
+
+// stopProbes stops the periodic probes on this node
+func (h *Handlers) stopProbes(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    if h.prober == nil {
+        writeError(w, r, http.StatusNotFound, "Synthetic probes are not available on this server")
+        return
+    }
+
+    before := h.prober.Status()
+    h.prober.Stop()
+
+    if before.Running {
+        // Record audit log
+        userID := getUserIDFromContext(ctx)
+        if err := h.recordAudit(ctx, userID, "stop", "probes", 0, before, nil); err != nil {
+            logger.Error("Recording audit failed", "error", err)
+        }
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(h.prober.Status())
+}
+
+// runProbes probes every domain once and returns the results
+func (h *Handlers) runProbes(w http.ResponseWriter, r *http.Request) {
+    if h.prober == nil {
+        writeError(w, r, http.StatusNotFound, "Synthetic probes are not available on this server")
+        return
+    }
+
+    path := r.URL.Query().Get("path")
+    if path == "" {
+        path = "/"
+    }
+    if !strings.HasPrefix(path, "/") {
+        writeError(w, r, http.StatusBadRequest, "path must start with /")
+        return
+    }
+
+    results := h.prober.Run(r.Context(), path)
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(results)
+}
+
+// getProbeBaseline summarizes the stored probe latency per domain, across
+// all nodes, as a baseline to compare user traffic against
+func (h *Handlers) getProbeBaseline(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+
+    timeRange := r.URL.Query().Get("range")
+    if timeRange == "" {
+        timeRange = "24h"
+    }
+    duration, err := time.ParseDuration(timeRange)
+    if err != nil || duration <= 0 {
+        writeError(w, r, http.StatusBadRequest, "Invalid time range")
+        return
+    }
+
+    rows, err := h.reader().Query(ctx, `
+        SELECT domain, COUNT(*),
+               COUNT(*) FILTER (WHERE error IS NOT NULL OR status_code >= 500),
+               COALESCE(AVG(latency_ms), 0),
+               COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY latency_ms), 0),
+               COALESCE(percentile_cont(0.95) WITHIN GROUP (ORDER BY latency_ms), 0),
+               COALESCE(MAX(latency_ms), 0)
+        FROM probe_results
+        WHERE timestamp >= $1
+        GROUP BY domain
+        ORDER BY domain
+    `, time.Now().Add(-duration))
+    if err != nil {
+        logger.Error("Fetching probe baseline failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch probe baseline")
+        return
+    }
+    defer rows.Close()
+
+    baselines := []probeBaseline{}
+    for rows.Next() {
+        var b probeBaseline
+        if err := rows.Scan(&b.Domain, &b.Probes, &b.Failures, &b.AvgLatency,
+            &b.P50Latency, &b.P95Latency, &b.MaxLatency); err != nil {
+            logger.Error("Scanning probe baseline failed", "error", err)
+            continue
+        }
+        baselines = append(baselines, b)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(baselines)
+}
@@ -0,0 +1,398 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"viacortex/internal/db"
+	"viacortex/internal/middleware"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v4"
+)
+
+// Resources limited by quotas, named as in quota error details
+const (
+    quotaDomains  = "domains"
+    quotaBackends = "backends"
+    quotaIPRules  = "ip_rules"
+)
+
+// quotaLimits are the most of each resource a tenant may have; 0 means
+// unlimited
+type quotaLimits struct {
+    Domains  int `json:"domains"`
+    Backends int `json:"backends"`
+    IPRules  int `json:"ip_rules"`
+}
+
+func (l quotaLimits) of(resource string) int {
+    switch resource {
+    case quotaDomains:
+        return l.Domains
+    case quotaBackends:
+        return l.Backends
+    default:
+        return l.IPRules
+    }
+}
+
+// tenant owns domains and the quotas that come with them: an organization,
+// or the owning user for domains outside any organization
+type tenant struct {
+    UserID int64 `json:"user_id,omitempty"`
+    OrgID  int64 `json:"org_id,omitempty"`
+}
+
+func (t tenant) isZero() bool {
+    return t.UserID == 0 && t.OrgID == 0
+}
+
+// errQuotaExceeded is returned when a change would take a tenant past one
+// of its quotas
+type errQuotaExceeded struct {
+    resource string
+    limit    int
+    used     int
+}
+
+func (e errQuotaExceeded) Error() string {
+    return fmt.Sprintf("quota of %d %s reached", e.limit, e.resource)
+}
+
+// SetQuotaDefaults sets the limits of tenants without their own quota
+func (h *Handlers) SetQuotaDefaults(domains, backends, ipRules int) {
+    h.quotaDefaults = quotaLimits{Domains: domains, Backends: backends, IPRules: ipRules}
+}
+
+// requestTenant is the tenant new domains of the request belong to
+func requestTenant(ctx context.Context) tenant {
+    if org := selectedOrg(ctx); org.ID != 0 {
+        return tenant{OrgID: org.ID}
+    }
+    return tenant{UserID: getUserIDFromContext(ctx)}
+}
+
+// domainTenant returns the tenant a domain counts against, the zero value
+// for a domain without organization or owner
+func domainTenant(ctx context.Context, q auditQuerier, domainID int64) (tenant, error) {
+    var orgID, ownerID *int64
+    err := q.QueryRow(ctx, `
+        SELECT d.org_id,
+               (SELECT user_id FROM domain_members
+                WHERE domain_id = d.id AND role = 'owner'
+                ORDER BY user_id LIMIT 1)
+        FROM domains d
+        WHERE d.id = $1
+    `, domainID).Scan(&orgID, &ownerID)
+    if err != nil {
+        return tenant{}, err
+    }
+    if orgID != nil {
+        return tenant{OrgID: *orgID}, nil
+    }
+    if ownerID != nil {
+        return tenant{UserID: *ownerID}, nil
+    }
+    return tenant{}, nil
+}
+
+// tenantLimits returns a tenant's quotas: its own where set, else the
+// defaults
+func (h *Handlers) tenantLimits(ctx context.Context, q auditQuerier, t tenant) (quotaLimits, error) {
+    limits := h.quotaDefaults
+    var domains, backends, ipRules *int
+    err := q.QueryRow(ctx, `
+        SELECT max_domains, max_backends, max_ip_rules
+        FROM quotas
+        WHERE user_id = NULLIF($1, 0) OR org_id = NULLIF($2, 0)
+    `, t.UserID, t.OrgID).Scan(&domains, &backends, &ipRules)
+    if err == pgx.ErrNoRows {
+        return limits, nil
+    }
+    if err != nil {
+        return limits, err
+    }
+    if domains != nil {
+        limits.Domains = *domains
+    }
+    if backends != nil {
+        limits.Backends = *backends
+    }
+    if ipRules != nil {
+        limits.IPRules = *ipRules
+    }
+    return limits, nil
+}
+
+// tenantUsage counts how much of a resource a tenant has
+func tenantUsage(ctx context.Context, q auditQuerier, t tenant, resource string) (int, error) {
+    domains := `d.org_id = $1`
+    id := t.OrgID
+    if t.OrgID == 0 {
+        domains = `d.org_id IS NULL AND d.id IN (
+            SELECT domain_id FROM domain_members WHERE user_id = $1 AND role = 'owner')`
+        id = t.UserID
+    }
+
+    var from string
+    switch resource {
+    case quotaDomains:
+        from = "domains d"
+    case quotaBackends:
+        from = "backend_servers x JOIN domains d ON d.id = x.domain_id"
+    default:
+        from = "ip_rules x JOIN domains d ON d.id = x.domain_id"
+    }
+
+    var used int
+    err := q.QueryRow(ctx, `SELECT COUNT(*) FROM `+from+` WHERE `+domains, id).Scan(&used)
+    return used, err
+}
+
+// lockTenant serializes quota checks of a tenant until tx ends, so
+// concurrent requests cannot both pass the check and then together exceed
+// the quota
+func lockTenant(ctx context.Context, tx pgx.Tx, t tenant) error {
+    key := fmt.Sprintf("quota:user:%d", t.UserID)
+    if t.OrgID != 0 {
+        key = fmt.Sprintf("quota:org:%d", t.OrgID)
+    }
+    _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", key)
+    return err
+}
+
+// checkQuota returns errQuotaExceeded when adding more of a resource would
+// take the tenant past its quota. Admins are not limited. The tenant stays
+// locked until tx ends, so the rows must be added in the same transaction.
+func (h *Handlers) checkQuota(ctx context.Context, tx pgx.Tx, t tenant, resource string, adding int) error {
+    if adding <= 0 || t.isZero() || middleware.GetRoleFromContext(ctx) == middleware.RoleAdmin {
+        return nil
+    }
+    limits, err := h.tenantLimits(ctx, tx, t)
+    if err != nil {
+        return err
+    }
+    limit := limits.of(resource)
+    if limit == 0 {
+        return nil
+    }
+    if err := lockTenant(ctx, tx, t); err != nil {
+        return err
+    }
+    used, err := tenantUsage(ctx, tx, t, resource)
+    if err != nil {
+        return err
+    }
+    if used+adding > limit {
+        return errQuotaExceeded{resource: resource, limit: limit, used: used}
+    }
+    return nil
+}
+
+// checkBackendQuota checks the quota of a domain's tenant before its
+// backends are replaced by count backends in tx
+func (h *Handlers) checkBackendQuota(ctx context.Context, tx pgx.Tx, domainID int64, count int) error {
+    if middleware.GetRoleFromContext(ctx) == middleware.RoleAdmin {
+        return nil
+    }
+    var existing int
+    if err := tx.QueryRow(ctx, "SELECT COUNT(*) FROM backend_servers WHERE domain_id = $1", domainID).Scan(&existing); err != nil {
+        return err
+    }
+    if count <= existing {
+        return nil
+    }
+    t, err := domainTenant(ctx, tx, domainID)
+    if err != nil {
+        return err
+    }
+    return h.checkQuota(ctx, tx, t, quotaBackends, count-existing)
+}
+
+// writeQuotaError reports a quota error, or a failure to check the quota
+func writeQuotaError(w http.ResponseWriter, r *http.Request, err error) {
+    if e, ok := err.(errQuotaExceeded); ok {
+        writeErrorDetails(w, r, http.StatusForbidden, "quota_exceeded",
+            fmt.Sprintf("Quota exceeded: at most %d %s allowed", e.limit, e.resource),
+            map[string]interface{}{"resource": e.resource, "limit": e.limit, "used": e.used})
+        return
+    }
+    logger.Error("Checking quota failed", "error", err)
+    writeError(w, r, http.StatusInternalServerError, "Server error")
+}
+
+// quotaReport is a tenant's quotas next to its usage
+type quotaReport struct {
+    Tenant tenant      `json:"tenant"`
+    Limits quotaLimits `json:"limits"`
+    Usage  quotaLimits `json:"usage"`
+}
+
+func (h *Handlers) loadQuotaReport(ctx context.Context, t tenant) (quotaReport, error) {
+    report := quotaReport{Tenant: t}
+    limits, err := h.tenantLimits(ctx, h.db, t)
+    if err != nil {
+        return report, err
+    }
+    report.Limits = limits
+    for _, item := range []struct {
+        resource string
+        dst      *int
+    }{
+        {quotaDomains, &report.Usage.Domains},
+        {quotaBackends, &report.Usage.Backends},
+        {quotaIPRules, &report.Usage.IPRules},
+    } {
+        if *item.dst, err = tenantUsage(ctx, h.db, t, item.resource); err != nil {
+            return report, err
+        }
+    }
+    return report, nil
+}
+
+// getMyQuota returns the quotas and usage of the selected organization, or
+// of the current user outside organizations
+func (h *Handlers) getMyQuota(w http.ResponseWriter, r *http.Request) {
+    report, err := h.loadQuotaReport(r.Context(), requestTenant(r.Context()))
+    if err != nil {
+        logger.Error("Fetching quota failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch quota")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(report)
+}
+
+// quotaTenant returns the tenant named by the {userID} or {orgID} URL
+// parameter
+func quotaTenant(r *http.Request) tenant {
+    if id := chi.URLParam(r, "orgID"); id != "" {
+        return tenant{OrgID: mustParseInt64(id)}
+    }
+    return tenant{UserID: mustParseInt64(chi.URLParam(r, "userID"))}
+}
+
+// loadQuota returns a tenant's own quota row, or nil when it uses the
+// defaults
+func (h *Handlers) loadQuota(ctx context.Context, t tenant) (*db.Quota, error) {
+    var q db.Quota
+    err := h.db.QueryRow(ctx, `
+        SELECT user_id, org_id, max_domains, max_backends, max_ip_rules, updated_at
+        FROM quotas
+        WHERE user_id = NULLIF($1, 0) OR org_id = NULLIF($2, 0)
+    `, t.UserID, t.OrgID).Scan(&q.UserID, &q.OrgID, &q.MaxDomains, &q.MaxBackends, &q.MaxIPRules, &q.UpdatedAt)
+    if err == pgx.ErrNoRows {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    return &q, nil
+}
+
+// getTenantQuota returns a user's or organization's quotas and usage
+func (h *Handlers) getTenantQuota(w http.ResponseWriter, r *http.Request) {
+    report, err := h.loadQuotaReport(r.Context(), quotaTenant(r))
+    if err != nil {
+        logger.Error("Fetching quota failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch quota")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(report)
+}
+
+// setTenantQuota sets a user's or organization's own quotas
+func (h *Handlers) setTenantQuota(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    t := quotaTenant(r)
+
+    var req db.Quota
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+    for _, v := range []*int{req.MaxDomains, req.MaxBackends, req.MaxIPRules} {
+        if v != nil && *v < 0 {
+            writeError(w, r, http.StatusBadRequest, "Quotas must not be negative")
+            return
+        }
+    }
+
+    before, err := h.loadQuota(ctx, t)
+    if err != nil {
+        logger.Error("Fetching quota failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to set quota")
+        return
+    }
+
+    conflict := "user_id"
+    if t.OrgID != 0 {
+        conflict = "org_id"
+    }
+    _, err = h.db.Exec(ctx, `
+        INSERT INTO quotas (user_id, org_id, max_domains, max_backends, max_ip_rules)
+        VALUES (NULLIF($1, 0), NULLIF($2, 0), $3, $4, $5)
+        ON CONFLICT (`+conflict+`) DO UPDATE SET
+            max_domains = EXCLUDED.max_domains,
+            max_backends = EXCLUDED.max_backends,
+            max_ip_rules = EXCLUDED.max_ip_rules,
+            updated_at = CURRENT_TIMESTAMP
+    `, t.UserID, t.OrgID, req.MaxDomains, req.MaxBackends, req.MaxIPRules)
+    if err != nil {
+        logger.Error("Setting quota failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to set quota")
+        return
+    }
+
+    after, err := h.loadQuota(ctx, t)
+    if err != nil {
+        logger.Error("Fetching quota failed", "error", err)
+    }
+
+    // Record audit log
+    userID := getUserIDFromContext(ctx)
+    if err := h.recordAudit(ctx, userID, "update", "quota", t.UserID+t.OrgID, before, after); err != nil {
+        logger.Error("Recording audit failed", "error", err)
+    }
+
+    h.getTenantQuota(w, r)
+}
+
+// resetTenantQuota returns a user or organization to the default quotas
+func (h *Handlers) resetTenantQuota(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    t := quotaTenant(r)
+
+    before, err := h.loadQuota(ctx, t)
+    if err != nil {
+        logger.Error("Fetching quota failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to reset quota")
+        return
+    }
+
+    result, err := h.db.Exec(ctx, "DELETE FROM quotas WHERE user_id = NULLIF($1, 0) OR org_id = NULLIF($2, 0)",
+        t.UserID, t.OrgID)
+    if err != nil {
+        logger.Error("Resetting quota failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to reset quota")
+        return
+    }
+
+    if result.RowsAffected() > 0 {
+        // Record audit log
+        userID := getUserIDFromContext(ctx)
+        if err := h.recordAudit(ctx, userID, "delete", "quota", t.UserID+t.OrgID, before, nil); err != nil {
+            logger.Error("Recording audit failed", "error", err)
+        }
+    }
+
+    json.NewEncoder(w).Encode(map[string]string{
+        "message": "Quota reset to defaults",
+    })
+}
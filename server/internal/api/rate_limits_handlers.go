@@ -2,7 +2,6 @@ package api
 
 import (
     "encoding/json"
-    "log"
     "net/http"
 
     "github.com/go-chi/chi/v5"
@@ -22,8 +21,8 @@ func (h *Handlers) getRateLimits(w http.ResponseWriter, r *http.Request) {
     `, domainID)
     
     if err != nil {
-        log.Printf("Error fetching rate limits: %v", err)
-        http.Error(w, "Failed to fetch rate limits", http.StatusInternalServerError)
+        logger.Error("Fetching rate limits failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch rate limits")
         return
     }
     defer rows.Close()
@@ -36,7 +35,7 @@ func (h *Handlers) getRateLimits(w http.ResponseWriter, r *http.Request) {
             &limit.PerIP, &limit.CreatedAt, &limit.UpdatedAt,
         )
         if err != nil {
-            log.Printf("Error scanning rate limit: %v", err)
+            logger.Error("Scanning rate limit failed", "error", err)
             continue
         }
         limits = append(limits, limit)
@@ -53,13 +52,13 @@ func (h *Handlers) addRateLimit(w http.ResponseWriter, r *http.Request) {
 
     var limit db.RateLimit
     if err := json.NewDecoder(r.Body).Decode(&limit); err != nil {
-        http.Error(w, "Invalid request body", http.StatusBadRequest)
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
         return
     }
 
     // Validate rate limit values
     if limit.RequestsPerSecond <= 0 || limit.BurstSize <= 0 {
-        http.Error(w, "Invalid rate limit values", http.StatusBadRequest)
+        writeError(w, r, http.StatusBadRequest, "Invalid rate limit values")
         return
     }
 
@@ -71,15 +70,16 @@ func (h *Handlers) addRateLimit(w http.ResponseWriter, r *http.Request) {
     `, domainID, limit.RequestsPerSecond, limit.BurstSize, limit.PerIP).Scan(&limitID)
 
     if err != nil {
-        log.Printf("Error creating rate limit: %v", err)
-        http.Error(w, "Failed to create rate limit", http.StatusInternalServerError)
+        logger.Error("Creating rate limit failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to create rate limit")
         return
     }
 
     // Record audit log
     userID := getUserIDFromContext(ctx)
-    if err := h.recordAudit(ctx, userID, "create", "rate_limit", limitID, limit); err != nil {
-        log.Printf("Error recording audit: %v", err)
+    after, _ := snapshotEntity(ctx, h.db, "rate_limits", limitID)
+    if err := h.recordAudit(ctx, userID, "create", "rate_limit", limitID, nil, after); err != nil {
+        logger.Error("Recording audit failed", "error", err)
     }
 
     w.WriteHeader(http.StatusCreated)
@@ -96,55 +96,47 @@ func (h *Handlers) updateRateLimit(w http.ResponseWriter, r *http.Request) {
 
     var limit db.RateLimit
     if err := json.NewDecoder(r.Body).Decode(&limit); err != nil {
-        http.Error(w, "Invalid request body", http.StatusBadRequest)
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
         return
     }
 
     // Validate rate limit values
     if limit.RequestsPerSecond <= 0 || limit.BurstSize <= 0 {
-        http.Error(w, "Invalid rate limit values", http.StatusBadRequest)
+        writeError(w, r, http.StatusBadRequest, "Invalid rate limit values")
         return
     }
 
     // Get old values for audit log
-    var oldLimit db.RateLimit
-    err := h.db.QueryRow(ctx, `
-        SELECT requests_per_second, burst_size, per_ip 
-        FROM rate_limits WHERE id = $1
-    `, limitID).Scan(&oldLimit.RequestsPerSecond, &oldLimit.BurstSize, &oldLimit.PerIP)
-    
+    before, err := snapshotEntity(ctx, h.db, "rate_limits", limitID)
     if err != nil {
-        log.Printf("Error fetching rate limit: %v", err)
-        http.Error(w, "Rate limit not found", http.StatusNotFound)
+        logger.Error("Fetching rate limit failed", "error", err)
+        writeError(w, r, http.StatusNotFound, "Rate limit not found")
         return
     }
 
     result, err := h.db.Exec(ctx, `
         UPDATE rate_limits 
         SET requests_per_second = $1, burst_size = $2, per_ip = $3
-        WHERE id = $4
-    `, limit.RequestsPerSecond, limit.BurstSize, limit.PerIP, limitID)
+        WHERE id = $4 AND domain_id = $5
+    `, limit.RequestsPerSecond, limit.BurstSize, limit.PerIP, limitID, chi.URLParam(r, "id"))
 
     if err != nil {
-        log.Printf("Error updating rate limit: %v", err)
-        http.Error(w, "Failed to update rate limit", http.StatusInternalServerError)
+        logger.Error("Updating rate limit failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to update rate limit")
         return
     }
 
     if rowsAffected := result.RowsAffected(); rowsAffected == 0 {
-        http.Error(w, "Rate limit not found", http.StatusNotFound)
+        writeError(w, r, http.StatusNotFound, "Rate limit not found")
         return
     }
 
     // Record audit log
     userID := getUserIDFromContext(ctx)
-    changes := map[string]interface{}{
-        "old": oldLimit,
-        "new": limit,
-    }
-    if err := h.recordAudit(ctx, userID, "update", "rate_limit", 
-        mustParseInt64(limitID), changes); err != nil {
-        log.Printf("Error recording audit: %v", err)
+    after, _ := snapshotEntity(ctx, h.db, "rate_limits", limitID)
+    if err := h.recordAudit(ctx, userID, "update", "rate_limit",
+        mustParseInt64(limitID), before, after); err != nil {
+        logger.Error("Recording audit failed", "error", err)
     }
 
     w.WriteHeader(http.StatusOK)
@@ -159,35 +151,30 @@ func (h *Handlers) deleteRateLimit(w http.ResponseWriter, r *http.Request) {
     limitID := chi.URLParam(r, "limitID")
 
     // Get rate limit details for audit log before deletion
-    var oldLimit db.RateLimit
-    err := h.db.QueryRow(ctx, `
-        SELECT requests_per_second, burst_size, per_ip 
-        FROM rate_limits WHERE id = $1
-    `, limitID).Scan(&oldLimit.RequestsPerSecond, &oldLimit.BurstSize, &oldLimit.PerIP)
-    
+    before, err := snapshotEntity(ctx, h.db, "rate_limits", limitID)
     if err != nil {
-        log.Printf("Error fetching rate limit: %v", err)
-        http.Error(w, "Rate limit not found", http.StatusNotFound)
+        logger.Error("Fetching rate limit failed", "error", err)
+        writeError(w, r, http.StatusNotFound, "Rate limit not found")
         return
     }
 
-    result, err := h.db.Exec(ctx, "DELETE FROM rate_limits WHERE id = $1", limitID)
+    result, err := h.db.Exec(ctx, "DELETE FROM rate_limits WHERE id = $1 AND domain_id = $2", limitID, chi.URLParam(r, "id"))
     if err != nil {
-        log.Printf("Error deleting rate limit: %v", err)
-        http.Error(w, "Failed to delete rate limit", http.StatusInternalServerError)
+        logger.Error("Deleting rate limit failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to delete rate limit")
         return
     }
 
     if rowsAffected := result.RowsAffected(); rowsAffected == 0 {
-        http.Error(w, "Rate limit not found", http.StatusNotFound)
+        writeError(w, r, http.StatusNotFound, "Rate limit not found")
         return
     }
 
     // Record audit log
     userID := getUserIDFromContext(ctx)
-    if err := h.recordAudit(ctx, userID, "delete", "rate_limit", 
-        mustParseInt64(limitID), oldLimit); err != nil {
-        log.Printf("Error recording audit: %v", err)
+    if err := h.recordAudit(ctx, userID, "delete", "rate_limit",
+        mustParseInt64(limitID), before, nil); err != nil {
+        logger.Error("Recording audit failed", "error", err)
     }
 
     w.WriteHeader(http.StatusOK)
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"viacortex/internal/db"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxRewriteURLs bounds the extra URLs rewritten per domain; each is
+// searched for in every rewritten response
+const maxRewriteURLs = 20
+
+// loadDomainBodyRewrite returns a domain's body rewriting, or nil when it is
+// off
+func (h *Handlers) loadDomainBodyRewrite(ctx context.Context, domainID int64) (*db.DomainBodyRewrite, error) {
+    b := db.DomainBodyRewrite{DomainID: domainID}
+    err := h.db.QueryRow(ctx, `
+        SELECT extra_urls, updated_at FROM domain_body_rewrites WHERE domain_id = $1
+    `, domainID).Scan(&b.ExtraURLs, &b.UpdatedAt)
+    if err != nil {
+        if err.Error() == "no rows in result set" {
+            return nil, nil
+        }
+        return nil, err
+    }
+    return &b, nil
+}
+
+// getDomainBodyRewrite returns the body rewriting of a domain
+func (h *Handlers) getDomainBodyRewrite(w http.ResponseWriter, r *http.Request) {
+    b, err := h.loadDomainBodyRewrite(r.Context(), mustParseInt64(chi.URLParam(r, "id")))
+    if err != nil {
+        logger.Error("Fetching body rewriting failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch body rewriting")
+        return
+    }
+    if b == nil {
+        writeError(w, r, http.StatusNotFound, "Body rewriting is not enabled")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(b)
+}
+
+// setDomainBodyRewrite enables body rewriting on a domain. The backends'
+// URLs are always rewritten; extra_urls adds others the application uses.
+func (h *Handlers) setDomainBodyRewrite(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    domainID := mustParseInt64(chi.URLParam(r, "id"))
+
+    var req struct {
+        ExtraURLs []string `json:"extra_urls"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+    if len(req.ExtraURLs) > maxRewriteURLs {
+        writeError(w, r, http.StatusBadRequest, "At most 20 extra_urls are allowed")
+        return
+    }
+    extraURLs := make([]string, 0, len(req.ExtraURLs))
+    for _, raw := range req.ExtraURLs {
+        u, err := url.Parse(raw)
+        if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+            strings.TrimRight(u.Path, "/") != "" || u.RawQuery != "" || u.Fragment != "" {
+            writeError(w, r, http.StatusBadRequest, "extra_urls must be http or https origins, like http://localhost:3000")
+            return
+        }
+        extraURLs = append(extraURLs, u.Scheme+"://"+u.Host)
+    }
+
+    before, err := h.loadDomainBodyRewrite(ctx, domainID)
+    if err != nil {
+        logger.Error("Fetching body rewriting failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to set body rewriting")
+        return
+    }
+
+    _, err = h.db.Exec(ctx, `
+        INSERT INTO domain_body_rewrites (domain_id, extra_urls)
+        VALUES ($1, $2)
+        ON CONFLICT (domain_id) DO UPDATE SET
+            extra_urls = EXCLUDED.extra_urls,
+            updated_at = CURRENT_TIMESTAMP
+    `, domainID, extraURLs)
+    if err != nil {
+        logger.Error("Setting body rewriting failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to set body rewriting")
+        return
+    }
+
+    after, err := h.loadDomainBodyRewrite(ctx, domainID)
+    if err != nil {
+        logger.Error("Fetching body rewriting failed", "error", err)
+    }
+
+    // Record audit log
+    userID := getUserIDFromContext(ctx)
+    if err := h.recordAudit(ctx, userID, "update", "domain_body_rewrite", domainID, before, after); err != nil {
+        logger.Error("Recording audit failed", "error", err)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(after)
+}
+
+// clearDomainBodyRewrite turns body rewriting off for a domain
+func (h *Handlers) clearDomainBodyRewrite(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    domainID := mustParseInt64(chi.URLParam(r, "id"))
+
+    before, err := h.loadDomainBodyRewrite(ctx, domainID)
+    if err != nil {
+        logger.Error("Fetching body rewriting failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to disable body rewriting")
+        return
+    }
+
+    result, err := h.db.Exec(ctx, "DELETE FROM domain_body_rewrites WHERE domain_id = $1", domainID)
+    if err != nil {
+        logger.Error("Disabling body rewriting failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to disable body rewriting")
+        return
+    }
+
+    if result.RowsAffected() > 0 {
+        // Record audit log
+        userID := getUserIDFromContext(ctx)
+        if err := h.recordAudit(ctx, userID, "delete", "domain_body_rewrite", domainID, before, nil); err != nil {
+            logger.Error("Recording audit failed", "error", err)
+        }
+    }
+
+    json.NewEncoder(w).Encode(map[string]string{
+        "message": "Body rewriting disabled",
+    })
+}
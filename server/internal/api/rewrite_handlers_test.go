@@ -0,0 +1,40 @@
+package api
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+// TestSetDomainBodyRewriteValidation covers the requests rejected before
+// the database is touched
+func TestSetDomainBodyRewriteValidation(t *testing.T) {
+    origins := "extra_urls must be http or https origins, like http://localhost:3000"
+    tests := []struct {
+        name string
+        body string
+        want string
+    }{
+        {"invalid JSON", `{`, "Invalid request body"},
+        {"too many URLs", `{"extra_urls": [` + strings.Repeat(`"http://a",`, 20) + `"http://b"]}`, "At most 20 extra_urls are allowed"},
+        {"no scheme", `{"extra_urls": ["localhost:3000"]}`, origins},
+        {"other scheme", `{"extra_urls": ["ftp://localhost"]}`, origins},
+        {"with path", `{"extra_urls": ["http://localhost:3000/app"]}`, origins},
+        {"with query", `{"extra_urls": ["http://localhost:3000?a=1"]}`, origins},
+        {"with fragment", `{"extra_urls": ["http://localhost:3000#top"]}`, origins},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            h := &Handlers{}
+            rec := httptest.NewRecorder()
+            h.setDomainBodyRewrite(rec, httptest.NewRequest(http.MethodPut, "/api/v1/domains/1/rewrite", strings.NewReader(tt.body)))
+            if rec.Code != http.StatusBadRequest {
+                t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+            }
+            if !strings.Contains(rec.Body.String(), tt.want) {
+                t.Errorf("body = %s, want %q", rec.Body.String(), tt.want)
+            }
+        })
+    }
+}
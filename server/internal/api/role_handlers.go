@@ -0,0 +1,346 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"viacortex/internal/db"
+	"viacortex/internal/middleware"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v4"
+)
+
+// roleReloadInterval is how often roles edited on other nodes are picked up
+const roleReloadInterval = time.Minute
+
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,49}$`)
+
+// LoadRoles installs the built-in roles if missing and loads every role's
+// permissions into the authorization middleware. It must run before role
+// names are validated, for example in SSO role mappings.
+func (h *Handlers) LoadRoles(ctx context.Context) error {
+    for role, perms := range middleware.DefaultRolePermissions() {
+        if _, err := h.db.Exec(ctx, `
+            INSERT INTO roles (name, permissions, builtin)
+            VALUES ($1, $2, true)
+            ON CONFLICT (name) DO NOTHING
+        `, role, perms); err != nil {
+            return err
+        }
+    }
+    return h.loadRoles(ctx)
+}
+
+// StartRoleRefresh periodically reloads roles until ctx is cancelled
+func (h *Handlers) StartRoleRefresh(ctx context.Context) {
+    go func() {
+        ticker := time.NewTicker(roleReloadInterval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-ctx.Done():
+                return
+            case <-ticker.C:
+                if err := h.loadRoles(ctx); err != nil {
+                    logger.Error("Reloading roles failed", "error", err)
+                }
+            }
+        }
+    }()
+}
+
+func (h *Handlers) loadRoles(ctx context.Context) error {
+    rows, err := h.db.Query(ctx, "SELECT name, permissions FROM roles")
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+
+    perms := map[string][]string{}
+    for rows.Next() {
+        var name string
+        var granted []string
+        if err := rows.Scan(&name, &granted); err != nil {
+            return err
+        }
+        perms[name] = granted
+    }
+    if err := rows.Err(); err != nil {
+        return err
+    }
+
+    middleware.SetRolePermissions(perms)
+    return nil
+}
+
+// roleRequest is the body of create and update requests
+type roleRequest struct {
+    Name        string   `json:"name"`
+    Description string   `json:"description"`
+    Permissions []string `json:"permissions"`
+}
+
+// validPermissions reports whether every permission is known, writing the
+// error response if not
+func validPermissions(w http.ResponseWriter, r *http.Request, perms []string) bool {
+    for _, p := range perms {
+        if !middleware.IsValidPermission(p) {
+            writeError(w, r, http.StatusBadRequest, "Unknown permission "+p)
+            return false
+        }
+    }
+    return true
+}
+
+// getPermissions lists the permissions that can be granted to a role
+func (h *Handlers) getPermissions(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(middleware.Permissions)
+}
+
+// getRoles lists the roles with their permissions and number of users
+func (h *Handlers) getRoles(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+
+    rows, err := h.db.Query(ctx, `
+        SELECT ro.id, ro.name, ro.description, ro.permissions, ro.builtin,
+               COUNT(u.id), ro.created_at, ro.updated_at
+        FROM roles ro
+        LEFT JOIN users u ON u.role = ro.name
+        GROUP BY ro.id
+        ORDER BY ro.builtin DESC, ro.name
+    `)
+    if err != nil {
+        logger.Error("Fetching roles failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch roles")
+        return
+    }
+    defer rows.Close()
+
+    roles := []db.Role{}
+    for rows.Next() {
+        var role db.Role
+        if err := rows.Scan(
+            &role.ID, &role.Name, &role.Description, &role.Permissions, &role.Builtin,
+            &role.Users, &role.CreatedAt, &role.UpdatedAt,
+        ); err != nil {
+            logger.Error("Scanning role failed", "error", err)
+            continue
+        }
+        if role.Name == middleware.RoleAdmin {
+            role.Permissions = middleware.RolePermissions(role.Name)
+        }
+        roles = append(roles, role)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(roles)
+}
+
+// createRole adds a custom role
+func (h *Handlers) createRole(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+
+    var req roleRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+    if !roleNamePattern.MatchString(req.Name) {
+        writeError(w, r, http.StatusBadRequest, "Role name must be 2-50 lowercase letters, digits, dashes or underscores")
+        return
+    }
+    if req.Permissions == nil {
+        req.Permissions = []string{}
+    }
+    if !validPermissions(w, r, req.Permissions) {
+        return
+    }
+
+    var role db.Role
+    err := h.db.QueryRow(ctx, `
+        INSERT INTO roles (name, description, permissions)
+        VALUES ($1, $2, $3)
+        RETURNING id, name, description, permissions, builtin, created_at, updated_at
+    `, req.Name, strings.TrimSpace(req.Description), req.Permissions).Scan(
+        &role.ID, &role.Name, &role.Description, &role.Permissions, &role.Builtin,
+        &role.CreatedAt, &role.UpdatedAt,
+    )
+    if err != nil {
+        if strings.Contains(err.Error(), "roles_name_key") {
+            writeError(w, r, http.StatusConflict, "Role already exists")
+            return
+        }
+        logger.Error("Creating role failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to create role")
+        return
+    }
+
+    if err := h.loadRoles(ctx); err != nil {
+        logger.Error("Reloading roles failed", "error", err)
+    }
+
+    // Record audit log
+    if err := h.recordAudit(ctx, getUserIDFromContext(ctx), "create", "role", role.ID, nil,
+        map[string]interface{}{"name": role.Name, "permissions": role.Permissions}); err != nil {
+        logger.Error("Creating audit log failed", "error", err)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(role)
+}
+
+// updateRole changes a role's description and permissions. The admin role
+// always has every permission and cannot be changed.
+func (h *Handlers) updateRole(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    name := chi.URLParam(r, "name")
+    if name == middleware.RoleAdmin {
+        writeError(w, r, http.StatusBadRequest, "The admin role always has every permission")
+        return
+    }
+
+    var req roleRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+    if req.Permissions == nil {
+        req.Permissions = []string{}
+    }
+    if !validPermissions(w, r, req.Permissions) {
+        return
+    }
+
+    tx, err := h.db.Begin(ctx)
+    if err != nil {
+        logger.Error("Starting transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    defer tx.Rollback(ctx)
+
+    var role db.Role
+    var before []string
+    err = tx.QueryRow(ctx, "SELECT id, permissions FROM roles WHERE name = $1 FOR UPDATE", name).Scan(&role.ID, &before)
+    if err == pgx.ErrNoRows {
+        writeError(w, r, http.StatusNotFound, "Role not found")
+        return
+    }
+    if err != nil {
+        logger.Error("Fetching role failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to update role")
+        return
+    }
+
+    err = tx.QueryRow(ctx, `
+        UPDATE roles SET description = $1, permissions = $2, updated_at = CURRENT_TIMESTAMP
+        WHERE id = $3
+        RETURNING name, description, permissions, builtin, created_at, updated_at
+    `, strings.TrimSpace(req.Description), req.Permissions, role.ID).Scan(
+        &role.Name, &role.Description, &role.Permissions, &role.Builtin,
+        &role.CreatedAt, &role.UpdatedAt,
+    )
+    if err != nil {
+        logger.Error("Updating role failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to update role")
+        return
+    }
+
+    // Record audit log
+    userID := getUserIDFromContext(ctx)
+    if err := writeAudit(ctx, tx, userID, "update", "role", role.ID,
+        map[string]interface{}{"name": name, "permissions": before},
+        map[string]interface{}{"name": name, "permissions": role.Permissions}); err != nil {
+        logger.Error("Creating audit log failed", "error", err)
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        logger.Error("Committing transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    h.publishAudit(userID, "update", "role", role.ID)
+
+    if err := h.loadRoles(ctx); err != nil {
+        logger.Error("Reloading roles failed", "error", err)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(role)
+}
+
+// deleteRole removes a custom role no user holds any more
+func (h *Handlers) deleteRole(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    name := chi.URLParam(r, "name")
+
+    tx, err := h.db.Begin(ctx)
+    if err != nil {
+        logger.Error("Starting transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    defer tx.Rollback(ctx)
+
+    var id int64
+    var builtin bool
+    var perms []string
+    err = tx.QueryRow(ctx, "SELECT id, builtin, permissions FROM roles WHERE name = $1 FOR UPDATE", name).Scan(&id, &builtin, &perms)
+    if err == pgx.ErrNoRows {
+        writeError(w, r, http.StatusNotFound, "Role not found")
+        return
+    }
+    if err != nil {
+        logger.Error("Fetching role failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to delete role")
+        return
+    }
+    if builtin {
+        writeError(w, r, http.StatusBadRequest, "Built-in roles cannot be deleted")
+        return
+    }
+
+    var users int
+    if err := tx.QueryRow(ctx, "SELECT COUNT(*) FROM users WHERE role = $1", name).Scan(&users); err != nil {
+        logger.Error("Counting role users failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to delete role")
+        return
+    }
+    if users > 0 {
+        writeError(w, r, http.StatusConflict, "Role is still assigned to users")
+        return
+    }
+
+    if _, err := tx.Exec(ctx, "DELETE FROM roles WHERE id = $1", id); err != nil {
+        logger.Error("Deleting role failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to delete role")
+        return
+    }
+
+    // Record audit log
+    userID := getUserIDFromContext(ctx)
+    if err := writeAudit(ctx, tx, userID, "delete", "role", id,
+        map[string]interface{}{"name": name, "permissions": perms}, nil); err != nil {
+        logger.Error("Creating audit log failed", "error", err)
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        logger.Error("Committing transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    h.publishAudit(userID, "delete", "role", id)
+
+    if err := h.loadRoles(ctx); err != nil {
+        logger.Error("Reloading roles failed", "error", err)
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
@@ -12,120 +12,552 @@ import (
 	"github.com/go-chi/cors"
 )
 
+// CurrentAPIVersion is the version served under /api/v1. Breaking changes
+// (pagination envelopes, error formats, ...) ship under a new prefix while
+// older prefixes keep their existing behaviour.
+const CurrentAPIVersion = "v1"
+
+// requestTimeout bounds every API request except the event stream, which
+// stays open for as long as the client listens
+const requestTimeout = 60 * time.Second
+
 func SetupRoutes(r *chi.Mux, handlers *Handlers) {
     // Global middleware
     // r.Use(middleware.Logger) - removed to prevent duplicate logging
     r.Use(middleware.Recoverer)
-    r.Use(middleware.Timeout(60 * time.Second))
-    
+    r.Use(custommiddleware.ReportPanics)
+
     // Setup CORS
+    origins := handlers.corsOrigins
+    if len(origins) == 0 {
+        origins = []string{"*"}
+    }
     r.Use(cors.Handler(cors.Options{
-        AllowedOrigins:   []string{"*"},
-        AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-        AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-Refresh-Token"},
-        ExposedHeaders:   []string{"Link"},
+        AllowedOrigins:   origins,
+        AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+        AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-Refresh-Token", "X-API-Key", "X-Organization-ID", "If-Match", "If-None-Match"},
+        ExposedHeaders:   []string{"Link", "API-Version", "Deprecation", "ETag"},
         AllowCredentials: true,
         MaxAge:           300,
     }))
-    r.Route("/", func(r chi.Router) {
-        r.Get("/", func(w http.ResponseWriter, r *http.Request) {
-            w.WriteHeader(http.StatusOK)
+    if handlers.ui != nil {
+        // Everything outside /api is the dashboard
+        r.Handle("/*", handlers.ui)
+    } else {
+        r.Route("/", func(r chi.Router) {
+            r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+                w.WriteHeader(http.StatusOK)
+            })
         })
+    }
+
+    // Liveness and readiness probes for orchestrators and load balancers
+    r.Get("/healthz", handlers.healthz)
+    r.Get("/readyz", handlers.readyz)
+
+    // Versioned API
+    r.Route("/api/v1", func(apiRouter chi.Router) {
+        apiRouter.Use(apiVersion(CurrentAPIVersion))
+        registerAPIRoutes(apiRouter, handlers)
     })
 
+    // Compatibility layer: the unversioned /api prefix serves the v1 routes
+    // unchanged so the existing dashboard keeps working, but responses are
+    // flagged as deprecated and point at the versioned successor.
     r.Route("/api", func(apiRouter chi.Router) {
-        // Middleware for all API routes
-        apiRouter.Use(middleware.AllowContentType("application/json"))
-
-        // Public routes
-        apiRouter.Group(func(r chi.Router) {
-            r.Post("/register", handlers.handleRegister)
-            r.Post("/login", handlers.handleLogin)
-            r.Post("/refresh", handlers.handleRefresh)
-            r.Get("/check-users", handlers.checkUsers)
-            r.Get("/verify", handlers.verifyToken)
-        })
-
-        // Status endpoint (public)
-        apiRouter.Get("/status", func(w http.ResponseWriter, r *http.Request) {
-            json.NewEncoder(w).Encode(map[string]string{
-                "status":  "ok",
-                "version": "1.0.0",
+        apiRouter.Use(apiVersion(CurrentAPIVersion))
+        apiRouter.Use(deprecatedPrefix("/api", "/api/"+CurrentAPIVersion))
+        apiRouter.Use(custommiddleware.PlainErrors)
+        registerAPIRoutes(apiRouter, handlers)
+    })
+}
+
+// registerAPIRoutes mounts every API endpoint on the given router. It is shared
+// by all mounted API prefixes so they stay in sync.
+func registerAPIRoutes(apiRouter chi.Router, handlers *Handlers) {
+    // Middleware for all API routes
+    apiRouter.Use(middleware.AllowContentType(
+        "application/json", "application/yaml", "application/x-yaml", "text/yaml",
+    ))
+    apiRouter.Use(withAuditRequest)
+    timeout := middleware.Timeout(requestTimeout)
+
+    // Public routes. Credential and password reset endpoints get a tight per-IP budget on top
+    // of the global throttle.
+    apiRouter.Group(func(r chi.Router) {
+        r.Use(timeout)
+        authLimit := handlers.limits.auth.Middleware(custommiddleware.ClientIPKey)
+        r.With(authLimit).Post("/register", handlers.handleRegister)
+        r.With(authLimit).Post("/login", handlers.handleLogin)
+        r.With(authLimit).Post("/refresh", handlers.handleRefresh)
+        r.With(authLimit).Post("/password-reset", handlers.requestPasswordReset)
+        r.With(authLimit).Post("/password-reset/confirm", handlers.confirmPasswordReset)
+        r.With(authLimit).Post("/webauthn/login/begin", handlers.beginWebAuthnLogin)
+        r.With(authLimit).Post("/webauthn/login/finish", handlers.finishWebAuthnLogin)
+        r.With(authLimit).Get("/auth/oidc/login", handlers.beginSSOLogin)
+        r.With(authLimit).Get("/auth/oidc/callback", handlers.ssoCallback)
+        r.Post("/logout", handlers.logout)
+        r.Get("/check-users", handlers.checkUsers)
+        r.Get("/verify", handlers.verifyToken)
+    })
+
+    // Status endpoint (public)
+    apiRouter.With(timeout).Get("/status", func(w http.ResponseWriter, r *http.Request) {
+        json.NewEncoder(w).Encode(map[string]string{
+            "status":      "ok",
+            "version":     "1.0.0",
+            "api_version": CurrentAPIVersion,
+        })
+    })
+
+    // Live admin event stream. Registered on its own so the token can also
+    // come from the query string and the stream outlives requestTimeout.
+    apiRouter.Group(func(r chi.Router) {
+        r.Use(tokenFromQuery)
+        r.Use(custommiddleware.Authenticate(handlers.lookupAPIKey, handlers.sessionActive))
+        r.Use(custommiddleware.RequireRole(custommiddleware.RoleAdmin))
+        r.Get("/events", handlers.streamEvents)
+    })
+
+    // Protected routes
+    apiRouter.Group(func(r chi.Router) {
+        r.Use(timeout)
+        r.Use(custommiddleware.Authenticate(handlers.lookupAPIKey, handlers.sessionActive))
+        r.Use(handlers.limits.tokens.Middleware(custommiddleware.IdentityKey))
+        r.Use(handlers.withOrganization)
+
+        // Each area needs the matching permission of the user's role, and
+        // audit and server settings stay admin only. API keys additionally
+        // need the matching scope.
+        requireAdmin := custommiddleware.RequireRole(custommiddleware.RoleAdmin)
+        readDomains := chi.Chain(
+            custommiddleware.RequirePermission(custommiddleware.PermDomainsRead),
+            custommiddleware.RequireScope(custommiddleware.ScopeDomainsRead),
+        )
+        writeDomains := chi.Chain(
+            custommiddleware.RequirePermission(custommiddleware.PermDomainsWrite),
+            custommiddleware.RequireScope(custommiddleware.ScopeDomainsWrite),
+        )
+        manageCerts := chi.Chain(
+            custommiddleware.RequirePermission(custommiddleware.PermCertsManage),
+            custommiddleware.RequireScope(custommiddleware.ScopeDomainsWrite),
+        )
+        manageUsers := custommiddleware.RequirePermission(custommiddleware.PermUsersManage)
+
+        // Organizations group users and domains into tenants. Members of
+        // an organization inherit a role on each of its domains.
+        r.Route("/organizations", func(r chi.Router) {
+            r.Use(custommiddleware.RequireSession)
+            r.Get("/", handlers.getOrganizations)
+            r.With(custommiddleware.RequirePermission(custommiddleware.PermDomainsWrite)).Post("/", handlers.createOrganization)
+            r.Route("/{orgID}", func(r chi.Router) {
+                r.With(handlers.requireOrgRole(orgRoleViewer)).Get("/", handlers.getOrganization)
+                r.With(handlers.requireOrgRole(orgRoleAdmin)).Put("/", handlers.updateOrganization)
+                r.With(handlers.requireOrgRole(orgRoleOwner)).Delete("/", handlers.deleteOrganization)
+                r.With(handlers.requireOrgRole(orgRoleAdmin)).Get("/audit", handlers.getAuditLogs)
+                r.Route("/members", func(r chi.Router) {
+                    r.With(handlers.requireOrgRole(orgRoleViewer)).Get("/", handlers.getOrganizationMembers)
+                    r.With(handlers.requireOrgRole(orgRoleAdmin)).Put("/{userID}", handlers.setOrganizationMember)
+                    r.With(handlers.requireOrgRole(orgRoleViewer)).Delete("/{userID}", handlers.removeOrganizationMember)
+                })
             })
         })
 
-        // Protected routes
-        apiRouter.Group(func(r chi.Router) {
-            r.Use(custommiddleware.AuthMiddleware)
-            
-            // Domains
-            r.Route("/domains", func(r chi.Router) {
-                r.Get("/", handlers.getDomains)
-                r.Post("/", handlers.createDomain)
-                r.Route("/{id}", func(r chi.Router) {
-                    r.Put("/", handlers.updateDomain)
-                    r.Delete("/", handlers.deleteDomain)
-                    
-                    // Backend servers for a domain
-                    r.Route("/backends", func(r chi.Router) {
-                        r.Get("/", handlers.getBackendServers)
-                        r.Post("/", handlers.addBackendServer)
-                        r.Put("/{serverID}", handlers.updateBackendServer)
-                        r.Delete("/{serverID}", handlers.deleteBackendServer)
-                    })
-                    
-                    // IP rules for a domain
-                    r.Route("/ip-rules", func(r chi.Router) {
-                        r.Get("/", handlers.getIPRules)
-                        r.Post("/", handlers.addIPRule)
-                        r.Delete("/{ruleID}", handlers.deleteIPRule)
-                    })
-                    
-                    // Rate limits for a domain
-                    r.Route("/rate-limits", func(r chi.Router) {
-                        r.Get("/", handlers.getRateLimits)
-                        r.Post("/", handlers.addRateLimit)
-                        r.Put("/{limitID}", handlers.updateRateLimit)
-                        r.Delete("/{limitID}", handlers.deleteRateLimit)
-                    })
+        // Hostname ownership claims (TXT record verification)
+        r.Route("/domain-claims", func(r chi.Router) {
+            r.Use(readDomains...)
+            r.Get("/", handlers.getDomainClaims)
+            r.With(manageCerts...).Post("/", handlers.createDomainClaim)
+            r.Route("/{claimID}", func(r chi.Router) {
+                r.Get("/", handlers.getDomainClaim)
+                r.With(manageCerts...).Post("/verify", handlers.verifyDomainClaim)
+                r.With(manageCerts...).Delete("/", handlers.deleteDomainClaim)
+            })
+        })
+
+        // Read-only GraphQL over domains, certificates and metrics
+        r.With(readDomains...).Get("/graphql", handlers.graphqlQuery)
+        r.With(readDomains...).Post("/graphql", handlers.graphqlQuery)
+
+        // Domains
+        r.Route("/domains", func(r chi.Router) {
+            r.Use(readDomains...)
+            r.Get("/", handlers.getDomains)
+            r.With(writeDomains...).Post("/", handlers.createDomain)
+            r.Get("/by-name/{name}", handlers.getDomainByName)
+            r.With(writeDomains...).Put("/by-name/{name}", handlers.upsertDomainByName)
+            r.Route("/{id}", func(r chi.Router) {
+                // Non-admins only reach domains they are a member of, and
+                // changes need the editor role on the domain as well
+                r.Use(handlers.requireDomainRole(domainRoleViewer))
+                r.Use(handlers.domainPrecondition)
+                writeDomain := chi.Chain(
+                    custommiddleware.RequirePermission(custommiddleware.PermDomainsWrite),
+                    custommiddleware.RequireScope(custommiddleware.ScopeDomainsWrite),
+                    handlers.requireDomainRole(domainRoleEditor),
+                )
+                ownDomain := chi.Chain(
+                    custommiddleware.RequirePermission(custommiddleware.PermDomainsWrite),
+                    custommiddleware.RequireScope(custommiddleware.ScopeDomainsWrite),
+                    handlers.requireDomainRole(domainRoleOwner),
+                )
+                r.Get("/", handlers.getDomain)
+                r.With(writeDomain...).Put("/", handlers.updateDomain)
+                r.With(writeDomain...).Patch("/", handlers.patchDomain)
+                r.With(ownDomain...).Delete("/", handlers.deleteDomain)
+                r.Get("/dns-check", handlers.checkDomainDNS)
+                r.With(writeDomain...).Post("/enable", handlers.setDomainEnabled(true))
+                r.With(writeDomain...).Post("/disable", handlers.setDomainEnabled(false))
+
+                // Who may see and manage the domain
+                r.Route("/members", func(r chi.Router) {
+                    r.Get("/", handlers.getDomainMembers)
+                    r.With(ownDomain...).Put("/{userID}", handlers.setDomainMember)
+                    r.With(ownDomain...).Delete("/{userID}", handlers.removeDomainMember)
+                })
+
+                // Backend servers for a domain
+                r.Route("/backends", func(r chi.Router) {
+                    r.Get("/", handlers.getBackendServers)
+                    r.With(writeDomain...).Post("/", handlers.addBackendServer)
+                    r.With(writeDomain...).Put("/{serverID}", handlers.updateBackendServer)
+                    r.With(writeDomain...).Delete("/{serverID}", handlers.deleteBackendServer)
+                })
+
+                // Connection pool settings for reaching the backends
+                r.Route("/transport", func(r chi.Router) {
+                    r.Get("/", handlers.getDomainTransport)
+                    r.With(writeDomain...).Put("/", handlers.updateDomainTransport)
+                    r.With(writeDomain...).Delete("/", handlers.resetDomainTransport)
+                })
+
+                // Chaos testing: injected latency, errors and resets
+                r.Route("/faults", func(r chi.Router) {
+                    r.Get("/", handlers.getDomainFault)
+                    r.With(writeDomain...).Put("/", handlers.setDomainFault)
+                    r.With(writeDomain...).Delete("/", handlers.clearDomainFault)
+                })
+
+                // Backend URLs replaced with the public one in responses
+                r.Route("/rewrite", func(r chi.Router) {
+                    r.Get("/", handlers.getDomainBodyRewrite)
+                    r.With(writeDomain...).Put("/", handlers.setDomainBodyRewrite)
+                    r.With(writeDomain...).Delete("/", handlers.clearDomainBodyRewrite)
+                })
+
+                // Cache-Control for responses by path or content type
+                r.Route("/cache-rules", func(r chi.Router) {
+                    r.Get("/", handlers.getDomainCacheRules)
+                    r.With(writeDomain...).Put("/", handlers.setDomainCacheRules)
+                })
+
+                // Preload and preconnect hints sent as 103 Early Hints
+                r.Route("/early-hints", func(r chi.Router) {
+                    r.Get("/", handlers.getDomainEarlyHints)
+                    r.With(writeDomain...).Put("/", handlers.setDomainEarlyHints)
                 })
+
+                // Proxy timings in a Server-Timing response header
+                r.Get("/server-timing", handlers.getDomainServerTiming)
+                r.With(writeDomain...).Put("/server-timing", handlers.setDomainServerTiming)
+
+                // WASM plugins run on the domain's traffic, in order
+                r.Route("/plugins", func(r chi.Router) {
+                    r.Get("/", handlers.getDomainPlugins)
+                    r.With(writeDomain...).Put("/", handlers.setDomainPlugins)
+                })
+
+                // Additional hostnames the domain is served under
+                r.Route("/aliases", func(r chi.Router) {
+                    r.Get("/", handlers.getDomainAliases)
+                    r.With(writeDomain...).Post("/", handlers.addDomainAlias)
+                    r.With(writeDomain...).Delete("/{aliasID}", handlers.deleteDomainAlias)
+                })
+
+                // IP rules for a domain
+                r.Route("/ip-rules", func(r chi.Router) {
+                    r.Get("/", handlers.getIPRules)
+                    r.With(writeDomain...).Post("/", handlers.addIPRule)
+                    r.With(writeDomain...).Delete("/{ruleID}", handlers.deleteIPRule)
+                })
+
+                // Rate limits for a domain
+                r.Route("/rate-limits", func(r chi.Router) {
+                    r.Get("/", handlers.getRateLimits)
+                    r.With(writeDomain...).Post("/", handlers.addRateLimit)
+                    r.With(writeDomain...).Put("/{limitID}", handlers.updateRateLimit)
+                    r.With(writeDomain...).Delete("/{limitID}", handlers.deleteRateLimit)
+                })
+            })
+        })
+
+        // Bulk configuration export/import
+        r.Route("/config", func(r chi.Router) {
+            r.With(readDomains...).Get("/export", handlers.exportConfig)
+            r.Group(func(r chi.Router) {
+                r.Use(requireAdmin, custommiddleware.RequireScope(custommiddleware.ScopeDomainsWrite))
+                r.Post("/import", handlers.importConfig)
+                r.Post("/import/server-config", handlers.importServerConfig)
+            })
+        })
+
+        // Encrypted backup and restore of the whole configuration
+        r.Group(func(r chi.Router) {
+            r.Use(requireAdmin)
+            r.Use(custommiddleware.RequireSession)
+            r.Post("/backup", handlers.createBackup)
+            r.Post("/restore", handlers.restoreBackup)
+        })
+
+        // Nodes sharing this database and the traffic each served
+        r.Route("/cluster", func(r chi.Router) {
+            r.Use(requireAdmin)
+            r.Get("/nodes", handlers.getClusterNodes)
+            r.With(custommiddleware.RequireSession).Delete("/nodes/{nodeID}", handlers.deleteClusterNode)
+            r.Get("/overview", handlers.getClusterOverview)
+
+            // Drain this node before upgrading or restarting it
+            r.Get("/drain", handlers.getDrainStatus)
+            r.With(custommiddleware.RequireSession).Post("/drain", handlers.startDrain)
+            r.With(custommiddleware.RequireSession).Delete("/drain", handlers.stopDrain)
+        })
+
+        // Domain, backend and IP rule quotas of users and organizations
+        r.Route("/quotas", func(r chi.Router) {
+            r.Get("/", handlers.getMyQuota)
+            r.Group(func(r chi.Router) {
+                r.Use(requireAdmin)
+                r.Get("/users/{userID}", handlers.getTenantQuota)
+                r.With(custommiddleware.RequireSession).Put("/users/{userID}", handlers.setTenantQuota)
+                r.With(custommiddleware.RequireSession).Delete("/users/{userID}", handlers.resetTenantQuota)
+                r.Get("/orgs/{orgID}", handlers.getTenantQuota)
+                r.With(custommiddleware.RequireSession).Put("/orgs/{orgID}", handlers.setTenantQuota)
+                r.With(custommiddleware.RequireSession).Delete("/orgs/{orgID}", handlers.resetTenantQuota)
             })
-            
-            // Metrics and logs
-            r.Route("/metrics", func(r chi.Router) {
-                r.Get("/", handlers.getGlobalMetrics)
-                r.Get("/{domainID}", handlers.getDomainMetrics)
+        })
+
+        // WASM plugins that domains can run; they execute inside the proxy,
+        // so only admins manage them
+        r.Route("/plugins", func(r chi.Router) {
+            r.Use(requireAdmin)
+            r.Get("/", handlers.getPlugins)
+            r.Get("/{pluginID}", handlers.getPlugin)
+            r.With(custommiddleware.RequireSession).Post("/", handlers.createPlugin)
+            r.With(custommiddleware.RequireSession).Put("/{pluginID}", handlers.updatePlugin)
+            r.With(custommiddleware.RequireSession).Delete("/{pluginID}", handlers.deletePlugin)
+        })
+
+        // Abuse feeds blocked for every domain before its IP rules, and the
+        // ranges exempt from them
+        r.Route("/threat-feeds", func(r chi.Router) {
+            r.Use(requireAdmin)
+            r.Get("/", handlers.getThreatFeeds)
+            r.Get("/check", handlers.checkThreatFeeds)
+            r.Get("/{feedID}", handlers.getThreatFeed)
+            r.With(custommiddleware.RequireSession).Post("/", handlers.createThreatFeed)
+            r.With(custommiddleware.RequireSession).Put("/{feedID}", handlers.updateThreatFeed)
+            r.With(custommiddleware.RequireSession).Delete("/{feedID}", handlers.deleteThreatFeed)
+        })
+        r.Route("/threat-allowlist", func(r chi.Router) {
+            r.Use(requireAdmin)
+            r.Get("/", handlers.getThreatAllowlist)
+            r.With(custommiddleware.RequireSession).Post("/", handlers.addThreatAllowEntry)
+            r.With(custommiddleware.RequireSession).Delete("/{entryID}", handlers.deleteThreatAllowEntry)
+        })
+
+        // Monthly usage per domain, for billing
+        r.With(requireAdmin).Get("/usage", handlers.getUsage)
+
+        // Background jobs of this node, and running one on demand
+        r.Route("/jobs", func(r chi.Router) {
+            r.Use(requireAdmin)
+            r.Get("/", handlers.getJobs)
+            r.With(custommiddleware.RequireSession).Post("/{name}/run", handlers.runJob)
+        })
+
+        // Certificate storage usage and cleanup of stale challenge tokens
+        r.Route("/cert-storage", func(r chi.Router) {
+            r.Use(requireAdmin)
+            r.Get("/", handlers.getCertStorageUsage)
+            r.With(custommiddleware.RequireSession).Post("/clean", handlers.cleanCertStorage)
+        })
+
+        // Synthetic requests through this node's proxy, for a latency
+        // baseline apart from user traffic
+        r.Route("/probes", func(r chi.Router) {
+            r.Use(requireAdmin)
+            r.Get("/", handlers.getProbes)
+            r.Get("/baseline", handlers.getProbeBaseline)
+            r.With(custommiddleware.RequireSession).Put("/", handlers.startProbes)
+            r.With(custommiddleware.RequireSession).Delete("/", handlers.stopProbes)
+            r.With(custommiddleware.RequireSession).Post("/run", handlers.runProbes)
+        })
+
+        // Metrics and logs
+        r.Route("/metrics", func(r chi.Router) {
+            r.Use(custommiddleware.RequirePermission(custommiddleware.PermLogsRead))
+            r.Use(custommiddleware.RequireScope(custommiddleware.ScopeMetricsRead))
+            r.Get("/", handlers.getGlobalMetrics)
+            r.With(handlers.requireDomainRole(domainRoleViewer)).Get("/{domainID}", handlers.getDomainMetrics)
+        })
+
+        r.Route("/logs", func(r chi.Router) {
+            r.Use(custommiddleware.RequirePermission(custommiddleware.PermLogsRead))
+            r.Use(custommiddleware.RequireScope(custommiddleware.ScopeLogsRead))
+            r.Get("/", handlers.getGlobalLogs)
+            r.With(handlers.requireDomainRole(domainRoleViewer)).Get("/{domainID}", handlers.getDomainLogs)
+        })
+
+        // User management
+        r.Route("/users", func(r chi.Router) {
+            // Own password, available to every role
+            r.With(custommiddleware.RequireSession).Post("/me/password", handlers.changeOwnPassword)
+
+            // Own dashboard preferences
+            r.Route("/me/settings", func(r chi.Router) {
+                r.Use(custommiddleware.RequireSession)
+                r.Get("/", handlers.getOwnSettings)
+                r.Put("/", handlers.updateOwnSettings)
             })
-            
-            r.Route("/logs", func(r chi.Router) {
-                r.Get("/", handlers.getGlobalLogs)
-                r.Get("/{domainID}", handlers.getDomainLogs)
+
+            // Own login history
+            r.With(custommiddleware.RequireSession).Get("/me/logins", handlers.getOwnLogins)
+
+            // Own login sessions
+            r.Route("/me/sessions", func(r chi.Router) {
+                r.Use(custommiddleware.RequireSession)
+                r.Get("/", handlers.getOwnSessions)
+                r.Delete("/", handlers.deleteOwnSessions)
+                r.Delete("/{sessionID}", handlers.deleteOwnSession)
             })
-            
-            // User management
-            r.Route("/users", func(r chi.Router) {
+
+            r.Group(func(r chi.Router) {
+                r.Use(manageUsers)
+                r.Use(custommiddleware.RequireScope(custommiddleware.ScopeUsersRead))
                 r.Get("/", handlers.getUsers)
-                r.Post("/", handlers.createUser)
-                r.Route("/{id}", func(r chi.Router) {
-                    r.Put("/", handlers.updateUser)
-                    r.Delete("/", handlers.deleteUser)
-                    r.Put("/role", handlers.updateUserRole)
+                r.Get("/{id}/sessions", handlers.getUserSessions)
+                r.Group(func(r chi.Router) {
+                    r.Use(custommiddleware.RequireScope(custommiddleware.ScopeUsersWrite))
+                    r.Post("/", handlers.createUser)
+                    r.Route("/{id}", func(r chi.Router) {
+                        r.Put("/", handlers.updateUser)
+                        r.Delete("/", handlers.deleteUser)
+                        r.Put("/role", handlers.updateUserRole)
+                        r.Delete("/sessions", handlers.deleteUserSessions)
+                        r.Delete("/sessions/{sessionID}", handlers.deleteUserSession)
+                    })
                 })
             })
+        })
 
-            // Audit logs
-            r.Route("/audit", func(r chi.Router) {
-                r.Get("/", handlers.getAuditLogs)
-                r.Get("/{entityType}/{entityID}", handlers.getEntityAuditLogs)
+        // Roles and the permissions they grant
+        r.Route("/roles", func(r chi.Router) {
+            r.Use(manageUsers)
+            r.Use(custommiddleware.RequireScope(custommiddleware.ScopeUsersRead))
+            r.Get("/", handlers.getRoles)
+            r.Get("/permissions", handlers.getPermissions)
+            r.Group(func(r chi.Router) {
+                r.Use(custommiddleware.RequireSession)
+                r.Post("/", handlers.createRole)
+                r.Put("/{name}", handlers.updateRole)
+                r.Delete("/{name}", handlers.deleteRole)
             })
+        })
+
+        // Audit logs
+        r.Route("/audit", func(r chi.Router) {
+            r.Use(requireAdmin)
+            r.Use(custommiddleware.RequireScope(custommiddleware.ScopeAuditRead))
+            r.Get("/", handlers.getAuditLogs)
+            r.Get("/verify", handlers.verifyAuditLogs)
+            r.Get("/archives", handlers.getAuditArchives)
+            r.Get("/{entityType}/{entityID}", handlers.getEntityAuditLogs)
+        })
+
+        // Rate limiting and brute-force counters
+        r.With(requireAdmin).Get("/security/stats", handlers.getSecurityStats)
+
+        // Log levels, changed at runtime
+        r.Route("/logging", func(r chi.Router) {
+            r.Use(requireAdmin)
+            r.Get("/", handlers.getLogLevels)
+            r.With(custommiddleware.RequireSession).Put("/", handlers.updateLogLevels)
+        })
+
+        // JWT signing keys
+        r.Route("/security/signing-keys", func(r chi.Router) {
+            r.Use(requireAdmin)
+            r.Use(custommiddleware.RequireSession)
+            r.Get("/", handlers.getSigningKeys)
+            r.Post("/rotate", handlers.rotateSigningKey)
+        })
 
-            // Add this new route
-            r.Post("/profile", handlers.updateUserProfile)
+        // API keys are managed from a user session only
+        r.Route("/api-keys", func(r chi.Router) {
+            r.Use(custommiddleware.RequireSession)
+            r.Get("/", handlers.getAPIKeys)
+            r.Post("/", handlers.createAPIKey)
+            r.Delete("/{keyID}", handlers.revokeAPIKey)
         })
 
-        // In your routes setup
-        apiRouter.Options("/*", func(w http.ResponseWriter, r *http.Request) {
-            w.WriteHeader(http.StatusOK)
+        // Outbound webhooks
+        r.Route("/webhooks", func(r chi.Router) {
+            r.Use(requireAdmin)
+            r.Use(custommiddleware.RequireSession)
+            r.Get("/", handlers.getWebhooks)
+            r.Post("/", handlers.createWebhook)
+            r.Route("/{webhookID}", func(r chi.Router) {
+                r.Put("/", handlers.updateWebhook)
+                r.Delete("/", handlers.deleteWebhook)
+                r.Get("/deliveries", handlers.getWebhookDeliveries)
+            })
+        })
+
+        // Own security keys and passkeys
+        r.Route("/webauthn", func(r chi.Router) {
+            r.Use(custommiddleware.RequireSession)
+            r.Get("/credentials", handlers.getWebAuthnCredentials)
+            r.Delete("/credentials/{credentialID}", handlers.deleteWebAuthnCredential)
+            r.Post("/register/begin", handlers.beginWebAuthnRegistration)
+            r.Post("/register/finish", handlers.finishWebAuthnRegistration)
+            r.Put("/settings", handlers.updateWebAuthnSettings)
         })
+
+        // Own profile, available to every role
+        r.With(custommiddleware.RequireSession).Post("/profile", handlers.updateUserProfile)
     })
-}
\ No newline at end of file
+
+    // In your routes setup
+    apiRouter.Options("/*", func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusOK)
+    })
+
+    apiRouter.NotFound(func(w http.ResponseWriter, r *http.Request) {
+        writeError(w, r, http.StatusNotFound, "Not found")
+    })
+    apiRouter.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+        writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+    })
+}
+
+// apiVersion tags every response with the API version that served it
+func apiVersion(version string) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            w.Header().Set("API-Version", version)
+            next.ServeHTTP(w, r)
+        })
+    }
+}
+
+// deprecatedPrefix marks responses served from a legacy prefix as deprecated
+// and links to the same resource under its successor prefix
+func deprecatedPrefix(prefix, successor string) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            w.Header().Set("Deprecation", "true")
+            w.Header().Add("Link", "<"+successor+r.URL.Path[len(prefix):]+`>; rel="successor-version"`)
+            next.ServeHTTP(w, r)
+        })
+    }
+}
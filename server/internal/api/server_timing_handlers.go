@@ -0,0 +1,72 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// getDomainServerTiming returns whether a domain's responses carry the
+// proxy's Server-Timing header
+func (h *Handlers) getDomainServerTiming(w http.ResponseWriter, r *http.Request) {
+    domainID := mustParseInt64(chi.URLParam(r, "id"))
+
+    var enabled bool
+    err := h.db.QueryRow(r.Context(),
+        "SELECT EXISTS (SELECT 1 FROM domain_server_timing WHERE domain_id = $1)", domainID).Scan(&enabled)
+    if err != nil {
+        logger.Error("Fetching Server-Timing setting failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch Server-Timing setting")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "domain_id": domainID,
+        "enabled":   enabled,
+    })
+}
+
+// setDomainServerTiming turns the Server-Timing header on or off for a
+// domain. The timings reveal backend latency to every client, so it is
+// meant for debugging.
+func (h *Handlers) setDomainServerTiming(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    domainID := mustParseInt64(chi.URLParam(r, "id"))
+
+    var req struct {
+        Enabled *bool `json:"enabled"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Enabled == nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+
+    query := "DELETE FROM domain_server_timing WHERE domain_id = $1"
+    if *req.Enabled {
+        query = "INSERT INTO domain_server_timing (domain_id) VALUES ($1) ON CONFLICT (domain_id) DO NOTHING"
+    }
+    result, err := h.db.Exec(ctx, query, domainID)
+    if err != nil {
+        logger.Error("Setting Server-Timing failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to set Server-Timing")
+        return
+    }
+
+    if result.RowsAffected() > 0 {
+        // Record audit log
+        userID := getUserIDFromContext(ctx)
+        before := map[string]bool{"enabled": !*req.Enabled}
+        after := map[string]bool{"enabled": *req.Enabled}
+        if err := h.recordAudit(ctx, userID, "update", "domain_server_timing", domainID, before, after); err != nil {
+            logger.Error("Recording audit failed", "error", err)
+        }
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "domain_id": domainID,
+        "enabled":   *req.Enabled,
+    })
+}
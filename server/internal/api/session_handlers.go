@@ -0,0 +1,302 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"viacortex/internal/auth"
+	"viacortex/internal/db"
+	"viacortex/internal/middleware"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v4"
+)
+
+type sessionQuerier interface {
+    Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+}
+
+// newSession records a login session for a user. Expired sessions of the
+// same user are cleared out at the same time.
+func (h *Handlers) newSession(ctx context.Context, userID int64) (string, error) {
+    buf := make([]byte, 16)
+    if _, err := rand.Read(buf); err != nil {
+        return "", err
+    }
+    id := hex.EncodeToString(buf)
+
+    if _, err := h.db.Exec(ctx, `
+        DELETE FROM sessions WHERE user_id = $1 AND expires_at <= CURRENT_TIMESTAMP
+    `, userID); err != nil {
+        logger.Error("Clearing expired sessions failed", "error", err)
+    }
+
+    info, _ := ctx.Value(auditRequestKey{}).(auditRequest)
+    _, err := h.db.Exec(ctx, `
+        INSERT INTO sessions (id, user_id, user_agent, ip_address, expires_at)
+        VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, '')::inet, $5)
+    `, id, userID, info.UserAgent, info.IP, time.Now().Add(auth.RefreshTokenTTL))
+    if err != nil {
+        return "", err
+    }
+    return id, nil
+}
+
+// issueTokens starts a new session and returns its token pair
+func (h *Handlers) issueTokens(ctx context.Context, userID int64, email, role string) (*auth.TokenPair, error) {
+    sessionID, err := h.newSession(ctx, userID)
+    if err != nil {
+        return nil, err
+    }
+    return auth.GenerateSessionTokenPair(strconv.FormatInt(userID, 10), email, role, sessionID)
+}
+
+// deleteSessions removes a user's sessions: one when sessionID is set,
+// otherwise all of them except keep. It returns the IDs that were removed.
+// Their access tokens are rejected once the deletion is committed.
+func deleteSessions(ctx context.Context, q sessionQuerier, userID int64, sessionID, keep string) ([]string, error) {
+    rows, err := q.Query(ctx, `
+        DELETE FROM sessions
+        WHERE user_id = $1 AND ($2 = '' OR id = $2) AND id <> $3
+        RETURNING id
+    `, userID, sessionID, keep)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var ids []string
+    for rows.Next() {
+        var id string
+        if err := rows.Scan(&id); err != nil {
+            return nil, err
+        }
+        ids = append(ids, id)
+    }
+    return ids, rows.Err()
+}
+
+// sessionActive reports whether a login session still exists and has not
+// expired, for the auth middleware. It reads the primary, so a revocation
+// applies at once rather than after replication lag.
+func (h *Handlers) sessionActive(ctx context.Context, sessionID string) (bool, error) {
+    var active bool
+    err := h.db.QueryRow(ctx, `
+        SELECT EXISTS (SELECT 1 FROM sessions WHERE id = $1 AND expires_at > CURRENT_TIMESTAMP)
+    `, sessionID).Scan(&active)
+    return active, err
+}
+
+// logout ends the session of the presented refresh or access token
+func (h *Handlers) logout(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+
+    token := r.Header.Get("X-Refresh-Token")
+    if token == "" {
+        token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+    }
+    if token == "" {
+        writeError(w, r, http.StatusBadRequest, "Refresh or access token required")
+        return
+    }
+
+    claims, err := auth.ValidateToken(token)
+    if err != nil {
+        writeError(w, r, http.StatusUnauthorized, "Invalid token")
+        return
+    }
+    userID, err := strconv.ParseInt(claims.UserID, 10, 64)
+    if err != nil {
+        writeError(w, r, http.StatusUnauthorized, "Invalid token")
+        return
+    }
+
+    // Tokens from before sessions existed have nothing to end; they expire
+    // on their own
+    if claims.SessionID == "" {
+        w.WriteHeader(http.StatusNoContent)
+        return
+    }
+
+    ids, err := deleteSessions(ctx, h.db, userID, claims.SessionID, "")
+    if err != nil {
+        logger.Error("Ending session failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to log out")
+        return
+    }
+    if len(ids) > 0 {
+        // Record audit log
+        if err := h.recordAudit(ctx, userID, "logout", "user", userID, nil, map[string]string{
+            "session_id": claims.SessionID,
+        }); err != nil {
+            logger.Error("Creating audit log failed", "error", err)
+        }
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// getOwnSessions lists the caller's active sessions
+func (h *Handlers) getOwnSessions(w http.ResponseWriter, r *http.Request) {
+    h.writeSessions(w, r, getUserIDFromContext(r.Context()))
+}
+
+// deleteOwnSessions signs the caller out everywhere except the current session
+func (h *Handlers) deleteOwnSessions(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    h.revokeSessions(w, r, getUserIDFromContext(ctx), "", middleware.GetSessionIDFromContext(ctx))
+}
+
+// deleteOwnSession revokes one of the caller's sessions
+func (h *Handlers) deleteOwnSession(w http.ResponseWriter, r *http.Request) {
+    h.revokeSessions(w, r, getUserIDFromContext(r.Context()), chi.URLParam(r, "sessionID"), "")
+}
+
+// getUserSessions lists another user's active sessions (admin)
+func (h *Handlers) getUserSessions(w http.ResponseWriter, r *http.Request) {
+    userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+    if err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid user ID")
+        return
+    }
+    h.writeSessions(w, r, userID)
+}
+
+// deleteUserSessions signs another user out everywhere (admin)
+func (h *Handlers) deleteUserSessions(w http.ResponseWriter, r *http.Request) {
+    userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+    if err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid user ID")
+        return
+    }
+    h.revokeSessions(w, r, userID, "", "")
+}
+
+// deleteUserSession revokes one of another user's sessions (admin)
+func (h *Handlers) deleteUserSession(w http.ResponseWriter, r *http.Request) {
+    userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+    if err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid user ID")
+        return
+    }
+    h.revokeSessions(w, r, userID, chi.URLParam(r, "sessionID"), "")
+}
+
+func (h *Handlers) writeSessions(w http.ResponseWriter, r *http.Request, userID int64) {
+    ctx := r.Context()
+    current := middleware.GetSessionIDFromContext(ctx)
+
+    rows, err := h.db.Query(ctx, `
+        SELECT id, user_id, COALESCE(user_agent, ''), COALESCE(host(ip_address), ''),
+               last_used_at, expires_at, created_at
+        FROM sessions
+        WHERE user_id = $1 AND expires_at > CURRENT_TIMESTAMP
+        ORDER BY last_used_at DESC
+    `, userID)
+    if err != nil {
+        logger.Error("Fetching sessions failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch sessions")
+        return
+    }
+    defer rows.Close()
+
+    sessions := []db.Session{}
+    for rows.Next() {
+        var s db.Session
+        if err := rows.Scan(&s.ID, &s.UserID, &s.UserAgent, &s.IPAddress, &s.LastUsedAt, &s.ExpiresAt, &s.CreatedAt); err != nil {
+            logger.Error("Scanning session failed", "error", err)
+            continue
+        }
+        s.Device = describeDevice(s.UserAgent)
+        s.Current = s.ID == current
+        sessions = append(sessions, s)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(sessions)
+}
+
+func (h *Handlers) revokeSessions(w http.ResponseWriter, r *http.Request, userID int64, sessionID, keep string) {
+    ctx := r.Context()
+
+    ids, err := deleteSessions(ctx, h.db, userID, sessionID, keep)
+    if err != nil {
+        logger.Error("Revoking sessions failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to revoke sessions")
+        return
+    }
+    if sessionID != "" && len(ids) == 0 {
+        writeError(w, r, http.StatusNotFound, "Session not found")
+        return
+    }
+
+    // Record audit log
+    if err := h.recordAudit(ctx, getUserIDFromContext(ctx), "revoke_sessions", "user", userID, nil, map[string]interface{}{
+        "sessions": ids,
+    }); err != nil {
+        logger.Error("Creating audit log failed", "error", err)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "revoked": len(ids),
+    })
+}
+
+// describeDevice turns a user agent into a short label such as
+// "Firefox on macOS"
+func describeDevice(userAgent string) string {
+    if userAgent == "" {
+        return "Unknown device"
+    }
+
+    browser := ""
+    for _, b := range []struct{ token, name string }{
+        {"Edg/", "Edge"},
+        {"OPR/", "Opera"},
+        {"Firefox/", "Firefox"},
+        {"Chrome/", "Chrome"},
+        {"Safari/", "Safari"},
+        {"curl/", "curl"},
+    } {
+        if strings.Contains(userAgent, b.token) {
+            browser = b.name
+            break
+        }
+    }
+
+    platform := ""
+    for _, p := range []struct{ token, name string }{
+        {"iPhone", "iOS"},
+        {"iPad", "iPadOS"},
+        {"Android", "Android"},
+        {"Windows", "Windows"},
+        {"Mac OS X", "macOS"},
+        {"CrOS", "ChromeOS"},
+        {"Linux", "Linux"},
+    } {
+        if strings.Contains(userAgent, p.token) {
+            platform = p.name
+            break
+        }
+    }
+
+    switch {
+    case browser != "" && platform != "":
+        return browser + " on " + platform
+    case browser != "":
+        return browser
+    case platform != "":
+        return platform
+    }
+    if len(userAgent) > 40 {
+        return userAgent[:40] + "..."
+    }
+    return userAgent
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"viacortex/internal/auth"
+)
+
+// SetKeyRing enables listing and rotating the JWT signing keys
+func (h *Handlers) SetKeyRing(k *auth.KeyRing) {
+    h.keyRing = k
+}
+
+// getSigningKeys lists the JWT signing keys that still verify tokens
+func (h *Handlers) getSigningKeys(w http.ResponseWriter, r *http.Request) {
+    if h.keyRing == nil {
+        writeError(w, r, http.StatusNotFound, "Signing keys are not managed by this server")
+        return
+    }
+
+    keys, err := h.keyRing.Keys(r.Context())
+    if err != nil {
+        logger.Error("Fetching signing keys failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch signing keys")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(keys)
+}
+
+// rotateSigningKey adds a new signing key. With revoke_previous=true every
+// older key is dropped at once, signing everybody out; that is the response
+// to a leaked key.
+func (h *Handlers) rotateSigningKey(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    if h.keyRing == nil {
+        writeError(w, r, http.StatusNotFound, "Signing keys are not managed by this server")
+        return
+    }
+    revokePrevious := r.URL.Query().Get("revoke_previous") == "true"
+
+    key, err := h.keyRing.Rotate(ctx, revokePrevious)
+    if err != nil {
+        logger.Error("Rotating signing key failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to rotate signing key")
+        return
+    }
+
+    // Record audit log
+    if err := h.recordAudit(ctx, getUserIDFromContext(ctx), "rotate_signing_key", "security", 0, nil, map[string]interface{}{
+        "kid":             key.ID,
+        "activates_at":    key.ActivatesAt,
+        "revoke_previous": revokePrevious,
+    }); err != nil {
+        logger.Error("Creating audit log failed", "error", err)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(key)
+}
@@ -0,0 +1,471 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/netip"
+	"net/url"
+
+	"viacortex/internal/db"
+	"viacortex/internal/threatfeed"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// threatFeedRequest is the body of a threat feed create or update. APIKey
+// is kept on update when omitted.
+type threatFeedRequest struct {
+    Name           string  `json:"name"`
+    Kind           string  `json:"kind"`
+    URL            string  `json:"url"`
+    APIKey         *string `json:"api_key"`
+    MinConfidence  int     `json:"min_confidence"`
+    RefreshMinutes int     `json:"refresh_minutes"`
+    Enabled        *bool   `json:"enabled"`
+}
+
+// validate fills in defaults and returns a message for the client when the
+// request is invalid. hasKey tells whether the feed already has an API key.
+func (req *threatFeedRequest) validate(hasKey bool) string {
+    if req.MinConfidence == 0 {
+        req.MinConfidence = 90
+    }
+    if req.RefreshMinutes == 0 {
+        req.RefreshMinutes = 60
+    }
+    if req.Enabled == nil {
+        enabled := true
+        req.Enabled = &enabled
+    }
+    switch {
+    case req.Name == "" || len(req.Name) > 100:
+        return "name must be between 1 and 100 characters"
+    case !threatfeed.ValidKind(req.Kind):
+        return "kind must be spamhaus_drop, abuseipdb or url"
+    case req.Kind == threatfeed.KindURL && req.URL == "":
+        return "url is required for url feeds"
+    case req.Kind == threatfeed.KindAbuseIPDB && !hasKey && (req.APIKey == nil || *req.APIKey == ""):
+        return "api_key is required for abuseipdb feeds"
+    case req.MinConfidence < 25 || req.MinConfidence > 100:
+        return "min_confidence must be between 25 and 100"
+    case req.RefreshMinutes < 15 || req.RefreshMinutes > 10080:
+        return "refresh_minutes must be between 15 and 10080"
+    }
+    if req.URL != "" {
+        u, err := url.Parse(req.URL)
+        if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+            return "url must be an http or https URL"
+        }
+    }
+    return ""
+}
+
+const threatFeedColumns = `id, name, kind, url, api_key, min_confidence, refresh_minutes, enabled,
+               entry_count, blocked_requests, last_blocked_at, checked_at, fetched_at, last_error,
+               created_by, created_at, updated_at`
+
+type rowScanner interface {
+    Scan(dest ...interface{}) error
+}
+
+func scanThreatFeed(row rowScanner) (*db.ThreatFeed, error) {
+    var f db.ThreatFeed
+    err := row.Scan(&f.ID, &f.Name, &f.Kind, &f.URL, &f.APIKey, &f.MinConfidence, &f.RefreshMinutes,
+        &f.Enabled, &f.EntryCount, &f.BlockedRequests, &f.LastBlockedAt, &f.CheckedAt, &f.FetchedAt,
+        &f.LastError, &f.CreatedBy, &f.CreatedAt, &f.UpdatedAt)
+    if err != nil {
+        return nil, err
+    }
+    f.HasAPIKey = f.APIKey != ""
+    return &f, nil
+}
+
+// loadThreatFeed returns a threat feed, or nil when it does not exist
+func (h *Handlers) loadThreatFeed(ctx context.Context, feedID int64) (*db.ThreatFeed, error) {
+    f, err := scanThreatFeed(h.db.QueryRow(ctx, `
+        SELECT `+threatFeedColumns+`
+        FROM threat_feeds
+        WHERE id = $1
+    `, feedID))
+    if err != nil {
+        if err.Error() == "no rows in result set" {
+            return nil, nil
+        }
+        return nil, err
+    }
+    return f, nil
+}
+
+// getThreatFeeds lists the threat feeds with their fetch status and the
+// requests they blocked across the cluster
+func (h *Handlers) getThreatFeeds(w http.ResponseWriter, r *http.Request) {
+    rows, err := h.reader().Query(r.Context(), `
+        SELECT `+threatFeedColumns+`
+        FROM threat_feeds
+        ORDER BY name
+    `)
+    if err != nil {
+        logger.Error("Fetching threat feeds failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch threat feeds")
+        return
+    }
+    defer rows.Close()
+
+    feeds := []*db.ThreatFeed{}
+    for rows.Next() {
+        f, err := scanThreatFeed(rows)
+        if err != nil {
+            logger.Error("Scanning threat feed failed", "error", err)
+            continue
+        }
+        feeds = append(feeds, f)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(feeds)
+}
+
+// getThreatFeed returns a threat feed
+func (h *Handlers) getThreatFeed(w http.ResponseWriter, r *http.Request) {
+    f, err := h.loadThreatFeed(r.Context(), mustParseInt64(chi.URLParam(r, "feedID")))
+    if err != nil {
+        logger.Error("Fetching threat feed failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch threat feed")
+        return
+    }
+    if f == nil {
+        writeError(w, r, http.StatusNotFound, "Threat feed not found")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(f)
+}
+
+// createThreatFeed adds a threat feed. The leader fetches it within a few
+// minutes and every node blocks its addresses once loaded.
+func (h *Handlers) createThreatFeed(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+
+    var req threatFeedRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+    if msg := req.validate(false); msg != "" {
+        writeError(w, r, http.StatusBadRequest, msg)
+        return
+    }
+    apiKey := ""
+    if req.APIKey != nil {
+        apiKey = *req.APIKey
+    }
+
+    userID := getUserIDFromContext(ctx)
+    var feedID int64
+    err := h.db.QueryRow(ctx, `
+        INSERT INTO threat_feeds (name, kind, url, api_key, min_confidence, refresh_minutes, enabled, created_by)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, 0))
+        ON CONFLICT (name) DO NOTHING
+        RETURNING id
+    `, req.Name, req.Kind, req.URL, apiKey, req.MinConfidence, req.RefreshMinutes, *req.Enabled,
+        userID).Scan(&feedID)
+    if err != nil {
+        if err.Error() == "no rows in result set" {
+            writeError(w, r, http.StatusConflict, "A threat feed with this name already exists")
+            return
+        }
+        logger.Error("Creating threat feed failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to create threat feed")
+        return
+    }
+
+    f, err := h.loadThreatFeed(ctx, feedID)
+    if err != nil {
+        logger.Error("Fetching threat feed failed", "error", err)
+    }
+
+    // Record audit log
+    if err := h.recordAudit(ctx, userID, "create", "threat_feed", feedID, nil, f); err != nil {
+        logger.Error("Recording audit failed", "error", err)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(f)
+}
+
+// updateThreatFeed changes a threat feed's settings. It is fetched again
+// with the next sync, keeping its entries until then.
+func (h *Handlers) updateThreatFeed(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    feedID := mustParseInt64(chi.URLParam(r, "feedID"))
+
+    var req threatFeedRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+
+    before, err := h.loadThreatFeed(ctx, feedID)
+    if err != nil {
+        logger.Error("Fetching threat feed failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to update threat feed")
+        return
+    }
+    if before == nil {
+        writeError(w, r, http.StatusNotFound, "Threat feed not found")
+        return
+    }
+    if msg := req.validate(before.HasAPIKey); msg != "" {
+        writeError(w, r, http.StatusBadRequest, msg)
+        return
+    }
+
+    _, err = h.db.Exec(ctx, `
+        UPDATE threat_feeds
+        SET name = $2, kind = $3, url = $4, api_key = COALESCE($5, api_key), min_confidence = $6,
+            refresh_minutes = $7, enabled = $8, checked_at = NULL, updated_at = CURRENT_TIMESTAMP
+        WHERE id = $1
+    `, feedID, req.Name, req.Kind, req.URL, req.APIKey, req.MinConfidence, req.RefreshMinutes,
+        *req.Enabled)
+    if err != nil {
+        logger.Error("Updating threat feed failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to update threat feed")
+        return
+    }
+
+    after, err := h.loadThreatFeed(ctx, feedID)
+    if err != nil {
+        logger.Error("Fetching threat feed failed", "error", err)
+    }
+
+    // Record audit log
+    userID := getUserIDFromContext(ctx)
+    if err := h.recordAudit(ctx, userID, "update", "threat_feed", feedID, before, after); err != nil {
+        logger.Error("Recording audit failed", "error", err)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(after)
+}
+
+// deleteThreatFeed removes a threat feed; its addresses are unblocked when
+// the nodes next load the blocklist
+func (h *Handlers) deleteThreatFeed(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    feedID := mustParseInt64(chi.URLParam(r, "feedID"))
+
+    before, err := h.loadThreatFeed(ctx, feedID)
+    if err != nil {
+        logger.Error("Fetching threat feed failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to delete threat feed")
+        return
+    }
+    if before == nil {
+        writeError(w, r, http.StatusNotFound, "Threat feed not found")
+        return
+    }
+
+    if _, err := h.db.Exec(ctx, "DELETE FROM threat_feeds WHERE id = $1", feedID); err != nil {
+        logger.Error("Deleting threat feed failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to delete threat feed")
+        return
+    }
+
+    // Record audit log
+    userID := getUserIDFromContext(ctx)
+    if err := h.recordAudit(ctx, userID, "delete", "threat_feed", feedID, before, nil); err != nil {
+        logger.Error("Recording audit failed", "error", err)
+    }
+
+    json.NewEncoder(w).Encode(map[string]string{
+        "message": "Threat feed deleted successfully",
+    })
+}
+
+// checkThreatFeeds tells which feeds list an address and whether the
+// allowlist exempts it, e.g. to answer a user who is blocked
+func (h *Handlers) checkThreatFeeds(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+
+    ip, err := netip.ParseAddr(r.URL.Query().Get("ip"))
+    if err != nil {
+        writeError(w, r, http.StatusBadRequest, "ip must be an IP address")
+        return
+    }
+    ip = ip.Unmap().WithZone("")
+
+    type listing struct {
+        FeedID   int64  `json:"feed_id"`
+        FeedName string `json:"feed_name"`
+        CIDR     string `json:"cidr"`
+        Enabled  bool   `json:"enabled"`
+    }
+    rows, err := h.reader().Query(ctx, `
+        SELECT f.id, f.name, e.cidr::text, f.enabled
+        FROM threat_feed_entries e
+        JOIN threat_feeds f ON f.id = e.feed_id
+        WHERE e.cidr >>= $1::inet
+        ORDER BY f.name, e.cidr
+    `, ip.String())
+    if err != nil {
+        logger.Error("Checking threat feeds failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to check threat feeds")
+        return
+    }
+    defer rows.Close()
+
+    listings := []listing{}
+    for rows.Next() {
+        var l listing
+        if err := rows.Scan(&l.FeedID, &l.FeedName, &l.CIDR, &l.Enabled); err != nil {
+            logger.Error("Scanning threat feed entry failed", "error", err)
+            continue
+        }
+        listings = append(listings, l)
+    }
+    rows.Close()
+
+    allowed := []db.ThreatAllowEntry{}
+    rows, err = h.reader().Query(ctx, `
+        SELECT id, cidr::text, description, created_by, created_at
+        FROM threat_allowlist
+        WHERE cidr >>= $1::inet
+        ORDER BY cidr
+    `, ip.String())
+    if err != nil {
+        logger.Error("Checking threat allowlist failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to check threat feeds")
+        return
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var e db.ThreatAllowEntry
+        if err := rows.Scan(&e.ID, &e.CIDR, &e.Description, &e.CreatedBy, &e.CreatedAt); err != nil {
+            logger.Error("Scanning allowlist entry failed", "error", err)
+            continue
+        }
+        allowed = append(allowed, e)
+    }
+
+    listed := false
+    for _, l := range listings {
+        listed = listed || l.Enabled
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "ip":        ip.String(),
+        "blocked":   listed && len(allowed) == 0,
+        "listed_by": listings,
+        "allowed":   allowed,
+    })
+}
+
+// getThreatAllowlist lists the ranges exempt from the threat feeds
+func (h *Handlers) getThreatAllowlist(w http.ResponseWriter, r *http.Request) {
+    rows, err := h.reader().Query(r.Context(), `
+        SELECT id, cidr::text, description, created_by, created_at
+        FROM threat_allowlist
+        ORDER BY cidr
+    `)
+    if err != nil {
+        logger.Error("Fetching threat allowlist failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch allowlist")
+        return
+    }
+    defer rows.Close()
+
+    entries := []db.ThreatAllowEntry{}
+    for rows.Next() {
+        var e db.ThreatAllowEntry
+        if err := rows.Scan(&e.ID, &e.CIDR, &e.Description, &e.CreatedBy, &e.CreatedAt); err != nil {
+            logger.Error("Scanning allowlist entry failed", "error", err)
+            continue
+        }
+        entries = append(entries, e)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(entries)
+}
+
+// addThreatAllowEntry exempts an address or range from the threat feeds
+func (h *Handlers) addThreatAllowEntry(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+
+    var req struct {
+        CIDR        string `json:"cidr"`
+        Description string `json:"description"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+    prefix, err := threatfeed.ParsePrefix(req.CIDR)
+    if err != nil {
+        writeError(w, r, http.StatusBadRequest, "cidr must be an IP address or CIDR range")
+        return
+    }
+
+    userID := getUserIDFromContext(ctx)
+    var e db.ThreatAllowEntry
+    err = h.db.QueryRow(ctx, `
+        INSERT INTO threat_allowlist (cidr, description, created_by)
+        VALUES ($1::cidr, $2, NULLIF($3, 0))
+        ON CONFLICT (cidr) DO NOTHING
+        RETURNING id, cidr::text, description, created_by, created_at
+    `, prefix.String(), req.Description, userID).Scan(&e.ID, &e.CIDR, &e.Description, &e.CreatedBy, &e.CreatedAt)
+    if err != nil {
+        if err.Error() == "no rows in result set" {
+            writeError(w, r, http.StatusConflict, "This range is already on the allowlist")
+            return
+        }
+        logger.Error("Adding allowlist entry failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to add allowlist entry")
+        return
+    }
+
+    // Record audit log
+    if err := h.recordAudit(ctx, userID, "create", "threat_allowlist", e.ID, nil, e); err != nil {
+        logger.Error("Recording audit failed", "error", err)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(e)
+}
+
+// deleteThreatAllowEntry removes a range from the allowlist
+func (h *Handlers) deleteThreatAllowEntry(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    entryID := mustParseInt64(chi.URLParam(r, "entryID"))
+
+    var e db.ThreatAllowEntry
+    err := h.db.QueryRow(ctx, `
+        DELETE FROM threat_allowlist
+        WHERE id = $1
+        RETURNING id, cidr::text, description, created_by, created_at
+    `, entryID).Scan(&e.ID, &e.CIDR, &e.Description, &e.CreatedBy, &e.CreatedAt)
+    if err != nil {
+        if err.Error() == "no rows in result set" {
+            writeError(w, r, http.StatusNotFound, "Allowlist entry not found")
+            return
+        }
+        logger.Error("Deleting allowlist entry failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to delete allowlist entry")
+        return
+    }
+
+    // Record audit log
+    userID := getUserIDFromContext(ctx)
+    if err := h.recordAudit(ctx, userID, "delete", "threat_allowlist", entryID, e, nil); err != nil {
+        logger.Error("Recording audit failed", "error", err)
+    }
+
+    json.NewEncoder(w).Encode(map[string]string{
+        "message": "Allowlist entry deleted successfully",
+    })
+}
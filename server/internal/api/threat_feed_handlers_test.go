@@ -0,0 +1,44 @@
+package api
+
+import (
+    "testing"
+)
+
+func TestThreatFeedRequestValidate(t *testing.T) {
+    key := "secret"
+    tests := []struct {
+        name   string
+        req    threatFeedRequest
+        hasKey bool
+        want   string
+    }{
+        {"spamhaus", threatFeedRequest{Name: "DROP", Kind: "spamhaus_drop"}, false, ""},
+        {"abuseipdb", threatFeedRequest{Name: "AbuseIPDB", Kind: "abuseipdb", APIKey: &key}, false, ""},
+        {"abuseipdb keeps key", threatFeedRequest{Name: "AbuseIPDB", Kind: "abuseipdb"}, true, ""},
+        {"url", threatFeedRequest{Name: "Internal", Kind: "url", URL: "https://feeds.example.com/bad.txt"}, false, ""},
+        {"no name", threatFeedRequest{Kind: "spamhaus_drop"}, false, "name must be between 1 and 100 characters"},
+        {"unknown kind", threatFeedRequest{Name: "x", Kind: "firehol"}, false, "kind must be spamhaus_drop, abuseipdb or url"},
+        {"url missing", threatFeedRequest{Name: "x", Kind: "url"}, false, "url is required for url feeds"},
+        {"key missing", threatFeedRequest{Name: "x", Kind: "abuseipdb"}, false, "api_key is required for abuseipdb feeds"},
+        {"low confidence", threatFeedRequest{Name: "x", Kind: "spamhaus_drop", MinConfidence: 10}, false, "min_confidence must be between 25 and 100"},
+        {"refresh too often", threatFeedRequest{Name: "x", Kind: "spamhaus_drop", RefreshMinutes: 5}, false, "refresh_minutes must be between 15 and 10080"},
+        {"bad url", threatFeedRequest{Name: "x", Kind: "url", URL: "ftp://feeds.example.com"}, false, "url must be an http or https URL"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := tt.req.validate(tt.hasKey); got != tt.want {
+                t.Errorf("validate() = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestThreatFeedRequestDefaults(t *testing.T) {
+    req := threatFeedRequest{Name: "DROP", Kind: "spamhaus_drop"}
+    if msg := req.validate(false); msg != "" {
+        t.Fatal(msg)
+    }
+    if req.MinConfidence != 90 || req.RefreshMinutes != 60 || req.Enabled == nil || !*req.Enabled {
+        t.Errorf("defaults = %d, %d, %v", req.MinConfidence, req.RefreshMinutes, req.Enabled)
+    }
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"viacortex/internal/db"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxTransportTimeout bounds the idle and response header timeouts, in
+// seconds
+const maxTransportTimeout = 3600
+
+// loadDomainTransport returns a domain's connection pool settings, or the
+// defaults when it has none
+func (h *Handlers) loadDomainTransport(ctx context.Context, domainID int64) (db.DomainTransport, error) {
+    t := db.DomainTransport{DomainID: domainID, HTTP2Enabled: true}
+    err := h.db.QueryRow(ctx, `
+        SELECT max_idle_conns_per_host, max_conns_per_host, idle_conn_timeout_seconds,
+               response_header_timeout_seconds, http2_enabled, updated_at
+        FROM domain_transport
+        WHERE domain_id = $1
+    `, domainID).Scan(
+        &t.MaxIdleConnsPerHost, &t.MaxConnsPerHost, &t.IdleConnTimeoutSeconds,
+        &t.ResponseHeaderTimeoutSeconds, &t.HTTP2Enabled, &t.UpdatedAt,
+    )
+    if err != nil && err.Error() != "no rows in result set" {
+        return t, err
+    }
+    return t, nil
+}
+
+// validTransport reports whether every set value is within range
+func validTransport(t db.DomainTransport) bool {
+    for _, v := range []*int{t.MaxIdleConnsPerHost, t.MaxConnsPerHost} {
+        if v != nil && *v < 0 {
+            return false
+        }
+    }
+    for _, v := range []*int{t.IdleConnTimeoutSeconds, t.ResponseHeaderTimeoutSeconds} {
+        if v != nil && (*v < 0 || *v > maxTransportTimeout) {
+            return false
+        }
+    }
+    return true
+}
+
+// getDomainTransport returns the connection pool settings of a domain
+func (h *Handlers) getDomainTransport(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    domainID := mustParseInt64(chi.URLParam(r, "id"))
+
+    t, err := h.loadDomainTransport(ctx, domainID)
+    if err != nil {
+        logger.Error("Fetching transport settings failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch transport settings")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(t)
+}
+
+// updateDomainTransport replaces the connection pool settings of a domain
+func (h *Handlers) updateDomainTransport(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    domainID := mustParseInt64(chi.URLParam(r, "id"))
+
+    req := db.DomainTransport{HTTP2Enabled: true}
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+    if !validTransport(req) {
+        writeError(w, r, http.StatusBadRequest, "Invalid transport settings")
+        return
+    }
+
+    before, err := h.loadDomainTransport(ctx, domainID)
+    if err != nil {
+        logger.Error("Fetching transport settings failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to update transport settings")
+        return
+    }
+
+    _, err = h.db.Exec(ctx, `
+        INSERT INTO domain_transport (
+            domain_id, max_idle_conns_per_host, max_conns_per_host,
+            idle_conn_timeout_seconds, response_header_timeout_seconds, http2_enabled
+        ) VALUES ($1, $2, $3, $4, $5, $6)
+        ON CONFLICT (domain_id) DO UPDATE SET
+            max_idle_conns_per_host = EXCLUDED.max_idle_conns_per_host,
+            max_conns_per_host = EXCLUDED.max_conns_per_host,
+            idle_conn_timeout_seconds = EXCLUDED.idle_conn_timeout_seconds,
+            response_header_timeout_seconds = EXCLUDED.response_header_timeout_seconds,
+            http2_enabled = EXCLUDED.http2_enabled,
+            updated_at = CURRENT_TIMESTAMP
+    `, domainID, req.MaxIdleConnsPerHost, req.MaxConnsPerHost,
+        req.IdleConnTimeoutSeconds, req.ResponseHeaderTimeoutSeconds, req.HTTP2Enabled)
+    if err != nil {
+        logger.Error("Updating transport settings failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to update transport settings")
+        return
+    }
+
+    after, err := h.loadDomainTransport(ctx, domainID)
+    if err != nil {
+        logger.Error("Fetching transport settings failed", "error", err)
+    }
+
+    // Record audit log
+    userID := getUserIDFromContext(ctx)
+    if err := h.recordAudit(ctx, userID, "update", "domain_transport", domainID, before, after); err != nil {
+        logger.Error("Recording audit failed", "error", err)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(after)
+}
+
+// resetDomainTransport returns a domain to the default connection pool
+func (h *Handlers) resetDomainTransport(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    domainID := mustParseInt64(chi.URLParam(r, "id"))
+
+    before, err := h.loadDomainTransport(ctx, domainID)
+    if err != nil {
+        logger.Error("Fetching transport settings failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to reset transport settings")
+        return
+    }
+
+    result, err := h.db.Exec(ctx, "DELETE FROM domain_transport WHERE domain_id = $1", domainID)
+    if err != nil {
+        logger.Error("Resetting transport settings failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to reset transport settings")
+        return
+    }
+
+    if result.RowsAffected() > 0 {
+        // Record audit log
+        userID := getUserIDFromContext(ctx)
+        if err := h.recordAudit(ctx, userID, "delete", "domain_transport", domainID, before, nil); err != nil {
+            logger.Error("Recording audit failed", "error", err)
+        }
+    }
+
+    json.NewEncoder(w).Encode(map[string]string{
+        "message": "Transport settings reset to defaults",
+    })
+}
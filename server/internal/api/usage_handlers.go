@@ -0,0 +1,78 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"viacortex/internal/usage"
+)
+
+// getUsage exports the usage metered per domain in a month, as JSON or CSV
+// for billing systems. The month defaults to the current one, which is still
+// being metered.
+func (h *Handlers) getUsage(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+
+    month := usage.MonthStart(time.Now())
+    if m := r.URL.Query().Get("month"); m != "" {
+        t, err := time.Parse("2006-01", m)
+        if err != nil {
+            writeError(w, r, http.StatusBadRequest, "month must be formatted as YYYY-MM")
+            return
+        }
+        month = t
+    }
+    format := r.URL.Query().Get("format")
+    if format == "" {
+        format = "json"
+    }
+    if format != "json" && format != "csv" {
+        writeError(w, r, http.StatusBadRequest, "format must be json or csv")
+        return
+    }
+
+    rows, err := usage.Load(ctx, h.reader(), month)
+    if err != nil {
+        logger.Error("Fetching usage failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch usage")
+        return
+    }
+
+    if format == "json" {
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(rows)
+        return
+    }
+
+    filename := "usage-" + month.Format("2006-01") + ".csv"
+    w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+    w.Header().Set("Content-Type", "text/csv")
+    cw := csv.NewWriter(w)
+    cw.Write([]string{
+        "month", "domain", "org_id", "requests", "bytes_in", "bytes_out",
+        "tcp_connections", "tcp_connection_hours",
+    })
+    for _, row := range rows {
+        orgID := ""
+        if row.OrgID != nil {
+            orgID = strconv.FormatInt(*row.OrgID, 10)
+        }
+        cw.Write([]string{
+            row.Month,
+            row.Domain,
+            orgID,
+            strconv.FormatInt(row.Requests, 10),
+            strconv.FormatInt(row.BytesIn, 10),
+            strconv.FormatInt(row.BytesOut, 10),
+            strconv.FormatInt(row.TCPConnections, 10),
+            strconv.FormatFloat(row.TCPHours, 'f', 4, 64),
+        })
+    }
+    cw.Flush()
+    if err := cw.Error(); err != nil {
+        logger.Error("Writing usage export failed", "error", err)
+    }
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+// TestGetUsageValidation covers the requests rejected before the database
+// is touched
+func TestGetUsageValidation(t *testing.T) {
+    tests := []struct {
+        name  string
+        query string
+        want  string
+    }{
+        {"month not a date", "?month=march", "month must be formatted as YYYY-MM"},
+        {"month with day", "?month=2026-03-01", "month must be formatted as YYYY-MM"},
+        {"unknown format", "?format=xml", "format must be json or csv"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            h := &Handlers{}
+            rec := httptest.NewRecorder()
+            h.getUsage(rec, httptest.NewRequest(http.MethodGet, "/api/v1/usage"+tt.query, nil))
+            if rec.Code != http.StatusBadRequest {
+                t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+            }
+            if !strings.Contains(rec.Body.String(), tt.want) {
+                t.Errorf("body = %s, want %q", rec.Body.String(), tt.want)
+            }
+        })
+    }
+}
@@ -3,7 +3,7 @@ package api
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"fmt"
 	"net/http"
 	"strings"
 
@@ -11,6 +11,7 @@ import (
 	"viacortex/internal/middleware"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v4"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -24,8 +25,8 @@ func (h *Handlers) getUsers(w http.ResponseWriter, r *http.Request) {
         ORDER BY email
     `)
     if err != nil {
-        log.Printf("Error fetching users: %v", err)
-        http.Error(w, "Failed to fetch users", http.StatusInternalServerError)
+        logger.Error("Fetching users failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch users")
         return
     }
     defer rows.Close()
@@ -38,7 +39,7 @@ func (h *Handlers) getUsers(w http.ResponseWriter, r *http.Request) {
             &u.LastLogin, &u.CreatedAt, &u.UpdatedAt,
         )
         if err != nil {
-            log.Printf("Error scanning user: %v", err)
+            logger.Error("Scanning user failed", "error", err)
             continue
         }
         users = append(users, u)
@@ -60,13 +61,16 @@ func (h *Handlers) createUser(w http.ResponseWriter, r *http.Request) {
     }
 
     if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-        http.Error(w, "Invalid request body", http.StatusBadRequest)
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
         return
     }
 
     // Validate role
     if !isValidRole(req.Role) {
-        http.Error(w, "Invalid role", http.StatusBadRequest)
+        writeError(w, r, http.StatusBadRequest, "Invalid role")
+        return
+    }
+    if !checkAdminChange(w, r, "", req.Role) {
         return
     }
 
@@ -77,20 +81,20 @@ func (h *Handlers) createUser(w http.ResponseWriter, r *http.Request) {
         req.Email,
     ).Scan(&exists)
     if err != nil {
-        log.Printf("Error checking email existence: %v", err)
-        http.Error(w, "Server error", http.StatusInternalServerError)
+        logger.Error("Checking email existence failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
         return
     }
     if exists {
-        http.Error(w, "Email already exists", http.StatusConflict)
+        writeError(w, r, http.StatusConflict, "Email already exists")
         return
     }
 
     // Hash password
     hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
     if err != nil {
-        log.Printf("Error hashing password: %v", err)
-        http.Error(w, "Server error", http.StatusInternalServerError)
+        logger.Error("Hashing password failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
         return
     }
 
@@ -103,19 +107,15 @@ func (h *Handlers) createUser(w http.ResponseWriter, r *http.Request) {
     `, req.Email, string(hashedPassword), req.Role, req.Name).Scan(&userID)
 
     if err != nil {
-        log.Printf("Error creating user: %v", err)
-        http.Error(w, "Failed to create user", http.StatusInternalServerError)
+        logger.Error("Creating user failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to create user")
         return
     }
 
     // Add audit log
-    _, err = h.db.Exec(ctx, `
-        INSERT INTO audit_logs (user_id, action, entity_type, entity_id, changes)
-        VALUES ($1, 'create', 'user', $2, $3)
-    `, getUserIDFromContext(ctx), userID, json.RawMessage(`{"email": "`+req.Email+`", "role": "`+req.Role+`"}`))
-
-    if err != nil {
-        log.Printf("Error creating audit log: %v", err)
+    after, _ := snapshotEntity(ctx, h.db, "users", userID)
+    if err := h.recordAudit(ctx, getUserIDFromContext(ctx), "create", "user", userID, nil, after); err != nil {
+        logger.Error("Creating audit log failed", "error", err)
     }
 
     w.WriteHeader(http.StatusCreated)
@@ -137,36 +137,47 @@ func (h *Handlers) updateUser(w http.ResponseWriter, r *http.Request) {
     }
 
     if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-        http.Error(w, "Invalid request body", http.StatusBadRequest)
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
         return
     }
 
     // Start transaction
     tx, err := h.db.Begin(ctx)
     if err != nil {
-        log.Printf("Error starting transaction: %v", err)
-        http.Error(w, "Server error", http.StatusInternalServerError)
+        logger.Error("Starting transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
         return
     }
     defer tx.Rollback(ctx)
 
+    before, err := snapshotEntity(ctx, tx, "users", userID)
+    if err != nil {
+        logger.Error("Fetching user failed", "error", err)
+        writeError(w, r, http.StatusNotFound, "User not found")
+        return
+    }
+    if !checkAdminChange(w, r, snapshotRole(before), "") {
+        return
+    }
+
     // Update basic info
     if req.Password != "" {
         // Update with new password
         hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
         if err != nil {
-            log.Printf("Error hashing password: %v", err)
-            http.Error(w, "Server error", http.StatusInternalServerError)
+            logger.Error("Hashing password failed", "error", err)
+            writeError(w, r, http.StatusInternalServerError, "Server error")
             return
         }
 
         if _, err = tx.Exec(ctx, `
             UPDATE users 
-            SET email = $1, password_hash = $2, active = $3, updated_at = CURRENT_TIMESTAMP
+            SET email = $1, password_hash = $2, active = $3, updated_at = CURRENT_TIMESTAMP,
+                tokens_valid_after = date_trunc('second', CURRENT_TIMESTAMP)
             WHERE id = $4
         `, req.Email, string(hashedPassword), req.Active, userID); err != nil {
-            log.Printf("Error updating user: %v", err)
-            http.Error(w, "Failed to update user", http.StatusInternalServerError)
+            logger.Error("Updating user failed", "error", err)
+            writeError(w, r, http.StatusInternalServerError, "Failed to update user")
             return
         }
     } else {
@@ -179,36 +190,39 @@ func (h *Handlers) updateUser(w http.ResponseWriter, r *http.Request) {
     }
 
     if err != nil {
-        log.Printf("Error updating user: %v", err)
-        http.Error(w, "Failed to update user", http.StatusInternalServerError)
+        logger.Error("Updating user failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to update user")
         return
     }
 
-    // Add audit log
-    changes := map[string]interface{}{
-        "email":  req.Email,
-        "active": req.Active,
-    }
-    if req.Password != "" {
-        changes["password_changed"] = true
+    // A new password or deactivation signs the user out everywhere
+    if req.Password != "" || !req.Active {
+        if _, err = deleteSessions(ctx, tx, mustParseInt64(userID), "", ""); err != nil {
+            logger.Error("Revoking sessions failed", "error", err)
+            writeError(w, r, http.StatusInternalServerError, "Failed to update user")
+            return
+        }
     }
-    
-    changesJSON, _ := json.Marshal(changes)
-    _, err = tx.Exec(ctx, `
-        INSERT INTO audit_logs (user_id, action, entity_type, entity_id, changes)
-        VALUES ($1, 'update', 'user', $2, $3)
-    `, getUserIDFromContext(ctx), userID, changesJSON)
 
-    if err != nil {
-        log.Printf("Error creating audit log: %v", err)
+    // Add audit log
+    after, _ := snapshotEntity(ctx, tx, "users", userID)
+    if after != nil && req.Password != "" {
+        // The hash itself is redacted, record that it changed
+        after["password_changed"] = true
+    }
+    if err := writeAudit(ctx, tx, getUserIDFromContext(ctx), "update", "user",
+        mustParseInt64(userID), before, after); err != nil {
+        logger.Error("Creating audit log failed", "error", err)
     }
 
     if err := tx.Commit(ctx); err != nil {
-        log.Printf("Error committing transaction: %v", err)
-        http.Error(w, "Server error", http.StatusInternalServerError)
+        logger.Error("Committing transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
         return
     }
 
+    h.publishAudit(getUserIDFromContext(ctx), "update", "user", mustParseInt64(userID))
+
     w.WriteHeader(http.StatusOK)
     json.NewEncoder(w).Encode(map[string]string{
         "message": "User updated successfully",
@@ -225,24 +239,34 @@ func (h *Handlers) updateUserRole(w http.ResponseWriter, r *http.Request) {
     }
 
     if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-        http.Error(w, "Invalid request body", http.StatusBadRequest)
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
         return
     }
 
     if !isValidRole(req.Role) {
-        http.Error(w, "Invalid role", http.StatusBadRequest)
+        writeError(w, r, http.StatusBadRequest, "Invalid role")
         return
     }
 
     // Start transaction
     tx, err := h.db.Begin(ctx)
     if err != nil {
-        log.Printf("Error starting transaction: %v", err)
-        http.Error(w, "Server error", http.StatusInternalServerError)
+        logger.Error("Starting transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
         return
     }
     defer tx.Rollback(ctx)
 
+    before, err := snapshotEntity(ctx, tx, "users", userID)
+    if err != nil {
+        logger.Error("Fetching user failed", "error", err)
+        writeError(w, r, http.StatusNotFound, "User not found")
+        return
+    }
+    if !checkAdminChange(w, r, snapshotRole(before), req.Role) {
+        return
+    }
+
     // Update role
     _, err = tx.Exec(ctx, `
         UPDATE users 
@@ -251,28 +275,26 @@ func (h *Handlers) updateUserRole(w http.ResponseWriter, r *http.Request) {
     `, req.Role, userID)
 
     if err != nil {
-        log.Printf("Error updating user role: %v", err)
-        http.Error(w, "Failed to update user role", http.StatusInternalServerError)
+        logger.Error("Updating user role failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to update user role")
         return
     }
 
     // Add audit log
-    changes, _ := json.Marshal(map[string]string{"role": req.Role})
-    _, err = tx.Exec(ctx, `
-        INSERT INTO audit_logs (user_id, action, entity_type, entity_id, changes)
-        VALUES ($1, 'update_role', 'user', $2, $3)
-    `, getUserIDFromContext(ctx), userID, changes)
-
-    if err != nil {
-        log.Printf("Error creating audit log: %v", err)
+    after, _ := snapshotEntity(ctx, tx, "users", userID)
+    if err := writeAudit(ctx, tx, getUserIDFromContext(ctx), "update_role", "user",
+        mustParseInt64(userID), before, after); err != nil {
+        logger.Error("Creating audit log failed", "error", err)
     }
 
     if err := tx.Commit(ctx); err != nil {
-        log.Printf("Error committing transaction: %v", err)
-        http.Error(w, "Server error", http.StatusInternalServerError)
+        logger.Error("Committing transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
         return
     }
 
+    h.publishAudit(getUserIDFromContext(ctx), "update_role", "user", mustParseInt64(userID))
+
     w.WriteHeader(http.StatusOK)
     json.NewEncoder(w).Encode(map[string]string{
         "message": "User role updated successfully",
@@ -287,51 +309,50 @@ func (h *Handlers) deleteUser(w http.ResponseWriter, r *http.Request) {
     // Start transaction
     tx, err := h.db.Begin(ctx)
     if err != nil {
-        log.Printf("Error starting transaction: %v", err)
-        http.Error(w, "Server error", http.StatusInternalServerError)
+        logger.Error("Starting transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
         return
     }
     defer tx.Rollback(ctx)
 
     // Get user details for audit log
-    var email string
-    err = tx.QueryRow(ctx, "SELECT email FROM users WHERE id = $1", userID).Scan(&email)
+    before, err := snapshotEntity(ctx, tx, "users", userID)
     if err != nil {
-        log.Printf("Error fetching user details: %v", err)
-        http.Error(w, "User not found", http.StatusNotFound)
+        logger.Error("Fetching user details failed", "error", err)
+        writeError(w, r, http.StatusNotFound, "User not found")
+        return
+    }
+    if !checkAdminChange(w, r, snapshotRole(before), "") {
         return
     }
 
     // Delete user
     result, err := tx.Exec(ctx, "DELETE FROM users WHERE id = $1", userID)
     if err != nil {
-        log.Printf("Error deleting user: %v", err)
-        http.Error(w, "Failed to delete user", http.StatusInternalServerError)
+        logger.Error("Deleting user failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to delete user")
         return
     }
 
     if rowsAffected := result.RowsAffected(); rowsAffected == 0 {
-        http.Error(w, "User not found", http.StatusNotFound)
+        writeError(w, r, http.StatusNotFound, "User not found")
         return
     }
 
     // Add audit log
-    changes, _ := json.Marshal(map[string]string{"email": email})
-    _, err = tx.Exec(ctx, `
-        INSERT INTO audit_logs (user_id, action, entity_type, entity_id, changes)
-        VALUES ($1, 'delete', 'user', $2, $3)
-    `, getUserIDFromContext(ctx), userID, changes)
-
-    if err != nil {
-        log.Printf("Error creating audit log: %v", err)
+    if err := writeAudit(ctx, tx, getUserIDFromContext(ctx), "delete", "user",
+        mustParseInt64(userID), before, nil); err != nil {
+        logger.Error("Creating audit log failed", "error", err)
     }
 
     if err := tx.Commit(ctx); err != nil {
-        log.Printf("Error committing transaction: %v", err)
-        http.Error(w, "Server error", http.StatusInternalServerError)
+        logger.Error("Committing transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
         return
     }
 
+    h.publishAudit(getUserIDFromContext(ctx), "delete", "user", mustParseInt64(userID))
+
     w.WriteHeader(http.StatusOK)
     json.NewEncoder(w).Encode(map[string]string{
         "message": "User deleted successfully",
@@ -340,13 +361,12 @@ func (h *Handlers) deleteUser(w http.ResponseWriter, r *http.Request) {
 
 // updateUserProfile updates a user's profile
 func (h *Handlers) updateUserProfile(w http.ResponseWriter, r *http.Request) {
-    log.Println("updateUserProfile")
     ctx := r.Context()
     
     // Get userID from context
     userID := getUserIDFromContext(ctx)
     if userID == 0 {
-        http.Error(w, "Not authenticated", http.StatusUnauthorized)
+        writeError(w, r, http.StatusUnauthorized, "Not authenticated")
         return
     }
     
@@ -355,15 +375,15 @@ func (h *Handlers) updateUserProfile(w http.ResponseWriter, r *http.Request) {
     }
 
     if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-        log.Printf("Error decoding request: %v", err)
-        http.Error(w, "Invalid request body", http.StatusBadRequest)
+        logger.Error("Decoding request failed", "error", err)
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
         return
     }
 
     // Validate name
     req.Name = strings.TrimSpace(req.Name)
     if req.Name == "" {
-        http.Error(w, "Name cannot be empty", http.StatusBadRequest)
+        writeError(w, r, http.StatusBadRequest, "Name cannot be empty")
         return
     }
 
@@ -375,15 +395,15 @@ func (h *Handlers) updateUserProfile(w http.ResponseWriter, r *http.Request) {
     `, req.Name, userID)
 
     if err != nil {
-        log.Printf("Error updating user profile: %v", err)
-        http.Error(w, "Failed to update profile", http.StatusInternalServerError)
+        logger.Error("Updating user profile failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to update profile")
         return
     }
 
     // Check if user was found and updated
     rowsAffected := result.RowsAffected()
     if rowsAffected == 0 {
-        http.Error(w, "User not found", http.StatusNotFound)
+        writeError(w, r, http.StatusNotFound, "User not found")
         return
     }
 
@@ -397,8 +417,8 @@ func (h *Handlers) updateUserProfile(w http.ResponseWriter, r *http.Request) {
     )
 
     if err != nil {
-        log.Printf("Error fetching updated user: %v", err)
-        http.Error(w, "Failed to fetch updated profile", http.StatusInternalServerError)
+        logger.Error("Fetching updated user failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch updated profile")
         return
     }
 
@@ -409,15 +429,141 @@ func (h *Handlers) updateUserProfile(w http.ResponseWriter, r *http.Request) {
     })
 }
 
+// minPasswordLength is the shortest password accepted when users choose
+// their own
+const minPasswordLength = 8
+
+// changeOwnPassword lets the current user change their password. The current
+// password must be supplied; refresh tokens issued before the change stop
+// working and a fresh token pair is returned for this session.
+func (h *Handlers) changeOwnPassword(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    userID := getUserIDFromContext(ctx)
+    if userID == 0 {
+        writeError(w, r, http.StatusUnauthorized, "Not authenticated")
+        return
+    }
+
+    var req struct {
+        CurrentPassword string `json:"current_password"`
+        NewPassword     string `json:"new_password"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+    if len(req.NewPassword) < minPasswordLength {
+        writeError(w, r, http.StatusBadRequest, fmt.Sprintf("New password must be at least %d characters", minPasswordLength))
+        return
+    }
+
+    tx, err := h.db.Begin(ctx)
+    if err != nil {
+        logger.Error("Starting transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    defer tx.Rollback(ctx)
+
+    var email, role, currentHash string
+    err = tx.QueryRow(ctx, `
+        SELECT email, role, password_hash FROM users WHERE id = $1 AND active = true FOR UPDATE
+    `, userID).Scan(&email, &role, &currentHash)
+    if err == pgx.ErrNoRows {
+        writeError(w, r, http.StatusNotFound, "User not found")
+        return
+    }
+    if err != nil {
+        logger.Error("Fetching user failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+
+    if err := bcrypt.CompareHashAndPassword([]byte(currentHash), []byte(req.CurrentPassword)); err != nil {
+        writeError(w, r, http.StatusForbidden, "Current password is incorrect")
+        return
+    }
+    if req.NewPassword == req.CurrentPassword {
+        writeError(w, r, http.StatusBadRequest, "New password must differ from the current password")
+        return
+    }
+
+    hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+    if err != nil {
+        logger.Error("Hashing password failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+
+    // Refresh tokens issued before this second are rejected from now on
+    if _, err := tx.Exec(ctx, `
+        UPDATE users
+        SET password_hash = $1, updated_at = CURRENT_TIMESTAMP,
+            tokens_valid_after = date_trunc('second', CURRENT_TIMESTAMP)
+        WHERE id = $2
+    `, string(hashedPassword), userID); err != nil {
+        logger.Error("Updating password failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to change password")
+        return
+    }
+
+    // Sign out every session, including this one; the response carries the
+    // tokens of a fresh session
+    if _, err := deleteSessions(ctx, tx, userID, "", ""); err != nil {
+        logger.Error("Revoking sessions failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to change password")
+        return
+    }
+
+    // Record audit log. The hash itself is never recorded.
+    if err := writeAudit(ctx, tx, userID, "change_password", "user", userID, nil, nil); err != nil {
+        logger.Error("Creating audit log failed", "error", err)
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        logger.Error("Committing transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+
+    h.publishAudit(userID, "change_password", "user", userID)
+
+    tokens, err := h.issueTokens(ctx, userID, email, role)
+    if err != nil {
+        writeError(w, r, http.StatusInternalServerError, "Failed to generate tokens")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "message": "Password changed successfully",
+        "tokens":  tokens,
+    })
+}
+
 // Helper functions
 
 func isValidRole(role string) bool {
-    validRoles := map[string]bool{
-        "admin":    true,
-        "user":     true,
-        "readonly": true,
+    return middleware.IsValidRole(role)
+}
+
+// checkAdminChange lets only admins grant the admin role or change an
+// admin's account, so users.manage cannot be used to escalate to admin
+func checkAdminChange(w http.ResponseWriter, r *http.Request, currentRole, newRole string) bool {
+    if middleware.GetRoleFromContext(r.Context()) == middleware.RoleAdmin {
+        return true
     }
-    return validRoles[role]
+    if currentRole == middleware.RoleAdmin || newRole == middleware.RoleAdmin {
+        writeError(w, r, http.StatusForbidden, "Only admins can manage admin accounts")
+        return false
+    }
+    return true
+}
+
+// snapshotRole returns the role recorded in a user snapshot
+func snapshotRole(snapshot map[string]interface{}) string {
+    role, _ := snapshot["role"].(string)
+    return role
 }
 
 func getUserIDFromContext(ctx context.Context) int64 {
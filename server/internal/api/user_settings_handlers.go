@@ -0,0 +1,155 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"viacortex/internal/db"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// maxAvatarLength bounds an avatar given inline as a data: URL
+const maxAvatarLength = 256 << 10
+
+// avatarImageTypes are the inline avatar formats the dashboard can show
+var avatarImageTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+// defaultUserSettings applies to settings a user has not chosen
+func defaultUserSettings() db.UserSettings {
+    return db.UserSettings{
+        Timezone:         "UTC",
+        DefaultTimeRange: "24h",
+        Notifications:    db.NotificationSettings{LoginAlerts: true},
+    }
+}
+
+// loadUserSettings returns a user's settings with defaults filled in
+func (h *Handlers) loadUserSettings(ctx context.Context, userID int64) (db.UserSettings, error) {
+    settings := defaultUserSettings()
+    var raw []byte
+    if err := h.db.QueryRow(ctx, "SELECT user_settings FROM users WHERE id = $1", userID).Scan(&raw); err != nil {
+        return settings, err
+    }
+    if len(raw) > 0 {
+        if err := json.Unmarshal(raw, &settings); err != nil {
+            return defaultUserSettings(), err
+        }
+    }
+    return settings, nil
+}
+
+// validateUserSettings returns a message describing the first invalid
+// setting, or "" when all are valid
+func validateUserSettings(s db.UserSettings) string {
+    if _, err := time.LoadLocation(s.Timezone); err != nil || s.Timezone == "" {
+        return "Invalid timezone"
+    }
+    if d, err := time.ParseDuration(s.DefaultTimeRange); err != nil || d <= 0 {
+        return "Invalid default time range"
+    }
+    if s.Avatar != "" && !validAvatar(s.Avatar) {
+        return "Avatar must be an https URL or a PNG, JPEG, GIF or WebP data URL"
+    }
+    return ""
+}
+
+func validAvatar(avatar string) bool {
+    if strings.HasPrefix(avatar, "data:") {
+        if len(avatar) > maxAvatarLength {
+            return false
+        }
+        for _, t := range avatarImageTypes {
+            if strings.HasPrefix(avatar, "data:"+t+";base64,") {
+                return true
+            }
+        }
+        return false
+    }
+    u, err := url.Parse(avatar)
+    return err == nil && u.Scheme == "https" && u.Host != ""
+}
+
+// getOwnSettings returns the caller's dashboard preferences
+func (h *Handlers) getOwnSettings(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    settings, err := h.loadUserSettings(ctx, getUserIDFromContext(ctx))
+    if err == pgx.ErrNoRows {
+        writeError(w, r, http.StatusNotFound, "User not found")
+        return
+    }
+    if err != nil {
+        logger.Error("Fetching user settings failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch settings")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(settings)
+}
+
+// updateOwnSettings replaces the caller's dashboard preferences. Settings
+// left out of the request keep their current values.
+func (h *Handlers) updateOwnSettings(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    userID := getUserIDFromContext(ctx)
+
+    before, err := h.loadUserSettings(ctx, userID)
+    if err == pgx.ErrNoRows {
+        writeError(w, r, http.StatusNotFound, "User not found")
+        return
+    }
+    if err != nil {
+        logger.Error("Fetching user settings failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch settings")
+        return
+    }
+
+    settings := before
+    r.Body = http.MaxBytesReader(w, r.Body, maxAvatarLength+4096)
+    if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+    if msg := validateUserSettings(settings); msg != "" {
+        writeError(w, r, http.StatusBadRequest, msg)
+        return
+    }
+
+    raw, err := json.Marshal(settings)
+    if err != nil {
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    if _, err := h.db.Exec(ctx, `
+        UPDATE users SET user_settings = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2
+    `, raw, userID); err != nil {
+        logger.Error("Updating user settings failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to update settings")
+        return
+    }
+
+    // Record audit log
+    if err := h.recordAudit(ctx, userID, "update_settings", "user", userID,
+        auditSettings(before), auditSettings(settings)); err != nil {
+        logger.Error("Creating audit log failed", "error", err)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(settings)
+}
+
+// auditSettings is the audited form of settings; inline avatars are too
+// large to copy into the audit log
+func auditSettings(s db.UserSettings) map[string]interface{} {
+    return map[string]interface{}{
+        "timezone":           s.Timezone,
+        "default_time_range": s.DefaultTimeRange,
+        "notifications":      s.Notifications,
+        "avatar_set":         s.Avatar != "",
+    }
+}
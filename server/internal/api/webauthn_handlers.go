@@ -0,0 +1,500 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"viacortex/internal/db"
+	"viacortex/internal/webauthn"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v4"
+)
+
+// SetWebAuthn enables security key and passkey logins
+func (h *Handlers) SetWebAuthn(rp *webauthn.RelyingParty) {
+    h.webauthn = rp
+}
+
+// requireWebAuthn writes a 503 when WebAuthn is not configured
+func (h *Handlers) requireWebAuthn(w http.ResponseWriter, r *http.Request) bool {
+    if h.webauthn == nil {
+        writeError(w, r, http.StatusServiceUnavailable, "Security key login is not configured")
+        return false
+    }
+    return true
+}
+
+// userCredentials loads the credentials registered to a user for allow and
+// exclude lists
+func (h *Handlers) userCredentials(ctx context.Context, userID int64) ([]webauthn.Credential, error) {
+    rows, err := h.db.Query(ctx, `
+        SELECT credential_id, transports FROM webauthn_credentials WHERE user_id = $1
+    `, userID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var creds []webauthn.Credential
+    for rows.Next() {
+        var c webauthn.Credential
+        if err := rows.Scan(&c.ID, &c.Transports); err != nil {
+            return nil, err
+        }
+        creds = append(creds, c)
+    }
+    return creds, rows.Err()
+}
+
+// getWebAuthnCredentials lists the current user's security keys
+func (h *Handlers) getWebAuthnCredentials(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    userID := getUserIDFromContext(ctx)
+
+    var required bool
+    if err := h.db.QueryRow(ctx, "SELECT webauthn_required FROM users WHERE id = $1", userID).Scan(&required); err != nil {
+        logger.Error("Fetching user failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch security keys")
+        return
+    }
+
+    rows, err := h.db.Query(ctx, `
+        SELECT id, user_id, name, sign_count, transports, last_used_at, created_at
+        FROM webauthn_credentials
+        WHERE user_id = $1
+        ORDER BY created_at
+    `, userID)
+    if err != nil {
+        logger.Error("Fetching webauthn credentials failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch security keys")
+        return
+    }
+    defer rows.Close()
+
+    creds := []db.WebAuthnCredential{}
+    for rows.Next() {
+        var c db.WebAuthnCredential
+        if err := rows.Scan(&c.ID, &c.UserID, &c.Name, &c.SignCount, &c.Transports, &c.LastUsedAt, &c.CreatedAt); err != nil {
+            logger.Error("Scanning webauthn credential failed", "error", err)
+            continue
+        }
+        creds = append(creds, c)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "required":    required,
+        "credentials": creds,
+    })
+}
+
+// beginWebAuthnRegistration returns the options for navigator.credentials.create
+func (h *Handlers) beginWebAuthnRegistration(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    if !h.requireWebAuthn(w, r) {
+        return
+    }
+    userID := getUserIDFromContext(ctx)
+
+    var email string
+    var name sql.NullString
+    if err := h.db.QueryRow(ctx, "SELECT email, name FROM users WHERE id = $1", userID).Scan(&email, &name); err != nil {
+        logger.Error("Fetching user failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+
+    existing, err := h.userCredentials(ctx, userID)
+    if err != nil {
+        logger.Error("Fetching webauthn credentials failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+
+    displayName := name.String
+    if displayName == "" {
+        displayName = email
+    }
+    opts, err := h.webauthn.BeginRegistration(webauthn.User{ID: userID, Name: email, DisplayName: displayName}, existing)
+    if err != nil {
+        logger.Error("Beginning webauthn registration failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{"public_key": opts})
+}
+
+// finishWebAuthnRegistration verifies and stores a new security key
+func (h *Handlers) finishWebAuthnRegistration(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    if !h.requireWebAuthn(w, r) {
+        return
+    }
+    userID := getUserIDFromContext(ctx)
+
+    var req struct {
+        Name       string                        `json:"name"`
+        Credential webauthn.RegistrationResponse `json:"credential"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+    req.Name = strings.TrimSpace(req.Name)
+    if req.Name == "" {
+        req.Name = "Security key"
+    }
+
+    cred, err := h.webauthn.FinishRegistration(userID, req.Credential)
+    if err != nil {
+        writeError(w, r, http.StatusBadRequest, "Registration failed: "+err.Error())
+        return
+    }
+
+    var c db.WebAuthnCredential
+    err = h.db.QueryRow(ctx, `
+        INSERT INTO webauthn_credentials (user_id, name, credential_id, public_key, sign_count, aaguid, transports)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING id, user_id, name, sign_count, transports, last_used_at, created_at
+    `, userID, req.Name, cred.ID, cred.PublicKey, int64(cred.SignCount), cred.AAGUID, nonNilStrings(cred.Transports)).Scan(
+        &c.ID, &c.UserID, &c.Name, &c.SignCount, &c.Transports, &c.LastUsedAt, &c.CreatedAt,
+    )
+    if err != nil {
+        if strings.Contains(err.Error(), "webauthn_credentials_credential_id_key") {
+            writeError(w, r, http.StatusConflict, "This security key is already registered")
+            return
+        }
+        logger.Error("Storing webauthn credential failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to register security key")
+        return
+    }
+
+    // Record audit log
+    if err := h.recordAudit(ctx, userID, "create", "webauthn_credential", c.ID, nil, c); err != nil {
+        logger.Error("Recording audit failed", "error", err)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(c)
+}
+
+// deleteWebAuthnCredential removes one of the current user's security keys.
+// The last key cannot be removed while it is required for login.
+func (h *Handlers) deleteWebAuthnCredential(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    userID := getUserIDFromContext(ctx)
+    credentialID := chi.URLParam(r, "credentialID")
+
+    tx, err := h.db.Begin(ctx)
+    if err != nil {
+        logger.Error("Starting transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    defer tx.Rollback(ctx)
+
+    var required bool
+    var remaining int
+    err = tx.QueryRow(ctx, `
+        SELECT u.webauthn_required,
+               (SELECT COUNT(*) FROM webauthn_credentials WHERE user_id = u.id)
+        FROM users u WHERE u.id = $1
+        FOR UPDATE OF u
+    `, userID).Scan(&required, &remaining)
+    if err != nil {
+        logger.Error("Fetching user failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+
+    var c db.WebAuthnCredential
+    err = tx.QueryRow(ctx, `
+        DELETE FROM webauthn_credentials
+        WHERE id = $1 AND user_id = $2
+        RETURNING id, user_id, name, sign_count, transports, last_used_at, created_at
+    `, credentialID, userID).Scan(&c.ID, &c.UserID, &c.Name, &c.SignCount, &c.Transports, &c.LastUsedAt, &c.CreatedAt)
+    if err == pgx.ErrNoRows {
+        writeError(w, r, http.StatusNotFound, "Security key not found")
+        return
+    }
+    if err != nil {
+        logger.Error("Deleting webauthn credential failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to delete security key")
+        return
+    }
+    if required && remaining <= 1 {
+        writeError(w, r, http.StatusConflict, "Turn off the security key requirement before removing the last key")
+        return
+    }
+
+    // Record audit log
+    if err := writeAudit(ctx, tx, userID, "delete", "webauthn_credential", c.ID, c, nil); err != nil {
+        logger.Error("Creating audit log failed", "error", err)
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        logger.Error("Committing transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    h.publishAudit(userID, "delete", "webauthn_credential", c.ID)
+
+    w.WriteHeader(http.StatusOK)
+    json.NewEncoder(w).Encode(map[string]string{
+        "message": "Security key deleted successfully",
+    })
+}
+
+// updateWebAuthnSettings turns the security key requirement for password
+// logins on or off
+func (h *Handlers) updateWebAuthnSettings(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    userID := getUserIDFromContext(ctx)
+
+    var req struct {
+        Required bool `json:"required"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+    if req.Required && !h.requireWebAuthn(w, r) {
+        return
+    }
+
+    var before bool
+    err := h.db.QueryRow(ctx, `
+        UPDATE users u
+        SET webauthn_required = $2, updated_at = CURRENT_TIMESTAMP
+        FROM (SELECT webauthn_required FROM users WHERE id = $1) prev
+        WHERE u.id = $1
+          AND (NOT $2 OR EXISTS (SELECT 1 FROM webauthn_credentials WHERE user_id = $1))
+        RETURNING prev.webauthn_required
+    `, userID, req.Required).Scan(&before)
+    if err == pgx.ErrNoRows {
+        writeError(w, r, http.StatusConflict, "Register a security key before requiring one")
+        return
+    }
+    if err != nil {
+        logger.Error("Updating webauthn settings failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to update settings")
+        return
+    }
+
+    // Record audit log
+    if before != req.Required {
+        if err := h.recordAudit(ctx, userID, "update_webauthn", "user", userID,
+            map[string]bool{"webauthn_required": before},
+            map[string]bool{"webauthn_required": req.Required}); err != nil {
+            logger.Error("Recording audit failed", "error", err)
+        }
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]bool{"required": req.Required})
+}
+
+// beginSecondFactor answers a correct password for a user who requires a
+// security key. It clears failed attempts, commits tx and returns the
+// WebAuthn challenge to complete the login with.
+func (h *Handlers) beginSecondFactor(w http.ResponseWriter, r *http.Request, tx pgx.Tx, userID int64) {
+    ctx := r.Context()
+    if !h.requireWebAuthn(w, r) {
+        return
+    }
+
+    if _, err := tx.Exec(ctx, `
+        UPDATE users SET failed_login_count = 0, locked_until = NULL WHERE id = $1
+    `, userID); err != nil {
+        logger.Error("Clearing failed logins failed", "error", err)
+    }
+    if err := tx.Commit(ctx); err != nil {
+        logger.Error("Committing transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+
+    creds, err := h.userCredentials(ctx, userID)
+    if err != nil {
+        logger.Error("Fetching webauthn credentials failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    opts, err := h.webauthn.BeginLogin(userID, creds, false)
+    if err != nil {
+        logger.Error("Beginning webauthn login failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "webauthn_required": true,
+        "public_key":        opts,
+    })
+}
+
+// beginWebAuthnLogin starts a passwordless login. With an email only that
+// user's keys are offered; without one the browser offers any passkey
+// stored for this site. User verification is always required.
+func (h *Handlers) beginWebAuthnLogin(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    if !h.requireWebAuthn(w, r) {
+        return
+    }
+
+    var req struct {
+        Email string `json:"email"`
+    }
+    if r.ContentLength != 0 {
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            writeError(w, r, http.StatusBadRequest, "Invalid request body")
+            return
+        }
+    }
+
+    var userID int64
+    var creds []webauthn.Credential
+    if req.Email != "" {
+        // Unknown addresses get an empty allow list rather than an error so
+        // the endpoint cannot be used to probe for accounts
+        err := h.db.QueryRow(ctx, "SELECT id FROM users WHERE email = $1 AND active = true", req.Email).Scan(&userID)
+        if err != nil && err != pgx.ErrNoRows {
+            logger.Error("Querying user failed", "error", err)
+            writeError(w, r, http.StatusInternalServerError, "Server error")
+            return
+        }
+        if userID != 0 {
+            if creds, err = h.userCredentials(ctx, userID); err != nil {
+                logger.Error("Fetching webauthn credentials failed", "error", err)
+                writeError(w, r, http.StatusInternalServerError, "Server error")
+                return
+            }
+        }
+    }
+
+    opts, err := h.webauthn.BeginLogin(userID, creds, true)
+    if err != nil {
+        logger.Error("Beginning webauthn login failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{"public_key": opts})
+}
+
+// finishWebAuthnLogin verifies an assertion, either for a passwordless login
+// or as the second step after a password, and issues tokens
+func (h *Handlers) finishWebAuthnLogin(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    if !h.requireWebAuthn(w, r) {
+        return
+    }
+
+    var req struct {
+        Credential webauthn.AssertionResponse `json:"credential"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+
+    var credentialRowID int64
+    assertion, err := h.webauthn.FinishLogin(req.Credential, func(id []byte) (*webauthn.Credential, int64, error) {
+        var c webauthn.Credential
+        var signCount, ownerID int64
+        err := h.db.QueryRow(ctx, `
+            SELECT id, user_id, credential_id, public_key, sign_count
+            FROM webauthn_credentials WHERE credential_id = $1
+        `, id).Scan(&credentialRowID, &ownerID, &c.ID, &c.PublicKey, &signCount)
+        if err == pgx.ErrNoRows {
+            return nil, 0, errors.New("unknown credential")
+        }
+        c.SignCount = uint32(signCount)
+        return &c, ownerID, err
+    })
+    if err != nil {
+        h.limits.failedLogins.Add(1)
+        h.emitLoginFailed(r, "", "webauthn")
+        if errors.Is(err, webauthn.ErrCloned) {
+            logger.Warn("Rejected login with credential", "credential_id", credentialRowID, "error", err)
+        }
+        writeError(w, r, http.StatusUnauthorized, "Security key verification failed")
+        return
+    }
+
+    tx, err := h.db.Begin(ctx)
+    if err != nil {
+        logger.Error("Starting transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    defer tx.Rollback(ctx)
+
+    var user db.User
+    var nullableName sql.NullString
+    err = tx.QueryRow(ctx, `
+        SELECT id, email, role, active, name, last_login FROM users WHERE id = $1 FOR UPDATE
+    `, assertion.UserID).Scan(&user.ID, &user.Email, &user.Role, &user.Active, &nullableName, &user.LastLogin)
+    if err != nil {
+        logger.Error("Querying user failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    if !user.Active {
+        h.emitLoginFailed(r, user.Email, "deactivated")
+        writeError(w, r, http.StatusForbidden, "Account is deactivated")
+        return
+    }
+    user.Name = nullableName.String
+
+    if _, err := tx.Exec(ctx, `
+        UPDATE webauthn_credentials SET sign_count = $2, last_used_at = CURRENT_TIMESTAMP WHERE id = $1
+    `, credentialRowID, int64(assertion.SignCount)); err != nil {
+        logger.Error("Updating webauthn credential failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    if _, err := tx.Exec(ctx, `
+        UPDATE users
+        SET last_login = CURRENT_TIMESTAMP, failed_login_count = 0, locked_until = NULL
+        WHERE id = $1
+    `, user.ID); err != nil {
+        logger.Error("Updating last login failed", "error", err)
+    }
+
+    // Add audit log
+    if err := writeAudit(ctx, tx, user.ID, "login", "user", user.ID, nil, map[string]interface{}{
+        "method":        "webauthn",
+        "credential_id": credentialRowID,
+        "user_verified": assertion.UserVerified,
+    }); err != nil {
+        logger.Error("Creating audit log failed", "error", err)
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        logger.Error("Committing transaction failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Server error")
+        return
+    }
+    h.publishAudit(user.ID, "login", "user", user.ID)
+
+    h.writeLoginResponse(w, r, user)
+}
+
+// nonNilStrings keeps NULL out of NOT NULL array columns
+func nonNilStrings(s []string) []string {
+    if s == nil {
+        return []string{}
+    }
+    return s
+}
@@ -0,0 +1,292 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"viacortex/internal/db"
+	"viacortex/internal/webhooks"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v4"
+)
+
+type webhookRequest struct {
+    URL    string   `json:"url"`
+    Secret string   `json:"secret"`
+    Events []string `json:"events"`
+    Active *bool    `json:"active"`
+}
+
+// validate checks the webhook target and event filter
+func (req *webhookRequest) validate() error {
+    u, err := url.Parse(req.URL)
+    if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+        return fmt.Errorf("url must be an absolute http(s) URL")
+    }
+    if len(req.Events) == 0 {
+        return fmt.Errorf("at least one event is required")
+    }
+    for _, event := range req.Events {
+        if !webhooks.IsValidEvent(event) {
+            return fmt.Errorf("unknown event %q", event)
+        }
+    }
+    return nil
+}
+
+// getWebhooks returns all configured webhooks
+func (h *Handlers) getWebhooks(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+
+    rows, err := h.db.Query(ctx, `
+        SELECT id, url, events, active, created_at, updated_at
+        FROM webhooks
+        ORDER BY created_at DESC
+    `)
+    if err != nil {
+        logger.Error("Fetching webhooks failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch webhooks")
+        return
+    }
+    defer rows.Close()
+
+    hooks := []db.Webhook{}
+    for rows.Next() {
+        var hook db.Webhook
+        err := rows.Scan(
+            &hook.ID, &hook.URL, &hook.Events, &hook.Active,
+            &hook.CreatedAt, &hook.UpdatedAt,
+        )
+        if err != nil {
+            logger.Error("Scanning webhook failed", "error", err)
+            continue
+        }
+        hooks = append(hooks, hook)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(hooks)
+}
+
+// createWebhook registers a new webhook. When no secret is supplied one is
+// generated; the secret is only returned in this response.
+func (h *Handlers) createWebhook(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+
+    var req webhookRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+    if err := req.validate(); err != nil {
+        writeError(w, r, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    if req.Secret == "" {
+        buf := make([]byte, 32)
+        if _, err := rand.Read(buf); err != nil {
+            logger.Error("Generating webhook secret failed", "error", err)
+            writeError(w, r, http.StatusInternalServerError, "Server error")
+            return
+        }
+        req.Secret = hex.EncodeToString(buf)
+    }
+
+    active := true
+    if req.Active != nil {
+        active = *req.Active
+    }
+
+    var hook db.Webhook
+    err := h.db.QueryRow(ctx, `
+        INSERT INTO webhooks (url, secret, events, active)
+        VALUES ($1, $2, $3, $4)
+        RETURNING id, url, events, active, created_at, updated_at
+    `, req.URL, req.Secret, req.Events, active).Scan(
+        &hook.ID, &hook.URL, &hook.Events, &hook.Active,
+        &hook.CreatedAt, &hook.UpdatedAt,
+    )
+    if err != nil {
+        logger.Error("Creating webhook failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to create webhook")
+        return
+    }
+
+    // Record audit log
+    userID := getUserIDFromContext(ctx)
+    if err := h.recordAudit(ctx, userID, "create", "webhook", hook.ID, nil, hook); err != nil {
+        logger.Error("Recording audit failed", "error", err)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "webhook": hook,
+        "secret":  req.Secret,
+    })
+}
+
+// updateWebhook replaces a webhook's URL, events and active flag. The secret
+// is only changed when a new one is supplied.
+func (h *Handlers) updateWebhook(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    webhookID := chi.URLParam(r, "webhookID")
+
+    var req webhookRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+    if err := req.validate(); err != nil {
+        writeError(w, r, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    active := true
+    if req.Active != nil {
+        active = *req.Active
+    }
+
+    before, err := snapshotEntity(ctx, h.db, "webhooks", webhookID)
+    if err == pgx.ErrNoRows {
+        writeError(w, r, http.StatusNotFound, "Webhook not found")
+        return
+    }
+    if err != nil {
+        logger.Error("Fetching webhook failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to update webhook")
+        return
+    }
+
+    result, err := h.db.Exec(ctx, `
+        UPDATE webhooks
+        SET url = $1, events = $2, active = $3, secret = COALESCE(NULLIF($4, ''), secret)
+        WHERE id = $5
+    `, req.URL, req.Events, active, req.Secret, webhookID)
+    if err != nil {
+        logger.Error("Updating webhook failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to update webhook")
+        return
+    }
+
+    if rowsAffected := result.RowsAffected(); rowsAffected == 0 {
+        writeError(w, r, http.StatusNotFound, "Webhook not found")
+        return
+    }
+
+    // Record audit log
+    userID := getUserIDFromContext(ctx)
+    after, _ := snapshotEntity(ctx, h.db, "webhooks", webhookID)
+    if after != nil && req.Secret != "" {
+        // The secret itself is redacted, record that it changed
+        after["secret_rotated"] = true
+    }
+    if err := h.recordAudit(ctx, userID, "update", "webhook",
+        mustParseInt64(webhookID), before, after); err != nil {
+        logger.Error("Recording audit failed", "error", err)
+    }
+
+    w.WriteHeader(http.StatusOK)
+    json.NewEncoder(w).Encode(map[string]string{
+        "message": "Webhook updated successfully",
+    })
+}
+
+// deleteWebhook removes a webhook and its delivery log
+func (h *Handlers) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    webhookID := chi.URLParam(r, "webhookID")
+
+    before, err := snapshotEntity(ctx, h.db, "webhooks", webhookID)
+    if err == pgx.ErrNoRows {
+        writeError(w, r, http.StatusNotFound, "Webhook not found")
+        return
+    }
+    if err != nil {
+        logger.Error("Fetching webhook failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to delete webhook")
+        return
+    }
+
+    result, err := h.db.Exec(ctx, "DELETE FROM webhooks WHERE id = $1", webhookID)
+    if err != nil {
+        logger.Error("Deleting webhook failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to delete webhook")
+        return
+    }
+
+    if rowsAffected := result.RowsAffected(); rowsAffected == 0 {
+        writeError(w, r, http.StatusNotFound, "Webhook not found")
+        return
+    }
+
+    // Record audit log
+    userID := getUserIDFromContext(ctx)
+    if err := h.recordAudit(ctx, userID, "delete", "webhook",
+        mustParseInt64(webhookID), before, nil); err != nil {
+        logger.Error("Recording audit failed", "error", err)
+    }
+
+    w.WriteHeader(http.StatusOK)
+    json.NewEncoder(w).Encode(map[string]string{
+        "message": "Webhook deleted successfully",
+    })
+}
+
+// getWebhookDeliveries returns the delivery log of a webhook
+func (h *Handlers) getWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    webhookID := chi.URLParam(r, "webhookID")
+
+    limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+    if limit == 0 {
+        limit = 100
+    }
+
+    query := `
+        SELECT id, webhook_id, event, payload, status, attempts, response_code,
+               response_body, error, next_attempt_at, delivered_at, created_at
+        FROM webhook_deliveries
+        WHERE webhook_id = $1
+    `
+    args := []interface{}{webhookID}
+    if status := r.URL.Query().Get("status"); status != "" {
+        query += ` AND status = $2`
+        args = append(args, status)
+    }
+    query += ` ORDER BY created_at DESC LIMIT $` + strconv.Itoa(len(args)+1)
+    args = append(args, limit)
+
+    rows, err := h.db.Query(ctx, query, args...)
+    if err != nil {
+        logger.Error("Fetching webhook deliveries failed", "error", err)
+        writeError(w, r, http.StatusInternalServerError, "Failed to fetch webhook deliveries")
+        return
+    }
+    defer rows.Close()
+
+    deliveries := []db.WebhookDelivery{}
+    for rows.Next() {
+        var d db.WebhookDelivery
+        err := rows.Scan(
+            &d.ID, &d.WebhookID, &d.Event, &d.Payload, &d.Status, &d.Attempts,
+            &d.ResponseCode, &d.ResponseBody, &d.Error, &d.NextAttemptAt,
+            &d.DeliveredAt, &d.CreatedAt,
+        )
+        if err != nil {
+            logger.Error("Scanning webhook delivery failed", "error", err)
+            continue
+        }
+        deliveries = append(deliveries, d)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(deliveries)
+}
@@ -0,0 +1,187 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Archiver stores exported audit log batches before they are pruned
+type Archiver interface {
+	// Put writes data under name and returns the location it was stored at
+	Put(ctx context.Context, name string, data []byte) (string, error)
+}
+
+// NewArchiver builds an Archiver from a location URL:
+//
+//	file:///var/lib/viacortex/audit     local directory
+//	s3://bucket/prefix                  S3 or any S3 compatible object store
+//
+// S3 credentials come from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_REGION. S3_ENDPOINT selects a non-AWS endpoint (MinIO, R2, ...), which
+// is addressed path style.
+func NewArchiver(location string) (Archiver, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive location: %w", err)
+	}
+
+	switch u.Scheme {
+	case "file", "":
+		dir := u.Path
+		if u.Scheme == "" {
+			dir = location
+		}
+		if dir == "" {
+			return nil, fmt.Errorf("archive directory is required")
+		}
+		return &fileArchiver{dir: dir}, nil
+	case "s3":
+		if u.Host == "" {
+			return nil, fmt.Errorf("archive bucket is required")
+		}
+		a := &s3Archiver{
+			bucket:    u.Host,
+			prefix:    strings.Trim(u.Path, "/"),
+			region:    os.Getenv("AWS_REGION"),
+			accessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+			secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			endpoint:  strings.TrimSuffix(os.Getenv("S3_ENDPOINT"), "/"),
+			client:    &http.Client{Timeout: 60 * time.Second},
+		}
+		if a.region == "" {
+			a.region = "us-east-1"
+		}
+		if a.accessKey == "" || a.secretKey == "" {
+			return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required for s3 archives")
+		}
+		return a, nil
+	default:
+		return nil, fmt.Errorf("unsupported archive scheme %q", u.Scheme)
+	}
+}
+
+type fileArchiver struct {
+	dir string
+}
+
+func (a *fileArchiver) Put(ctx context.Context, name string, data []byte) (string, error) {
+	path := filepath.Join(a.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return "", err
+	}
+
+	// Write to a temporary file first so a crash never leaves a partial archive
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o640); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return "file://" + path, nil
+}
+
+// s3Archiver uploads objects with a single SigV4 signed PUT
+type s3Archiver struct {
+	bucket    string
+	prefix    string
+	region    string
+	accessKey string
+	secretKey string
+	endpoint  string
+	client    *http.Client
+}
+
+func (a *s3Archiver) Put(ctx context.Context, name string, data []byte) (string, error) {
+	key := name
+	if a.prefix != "" {
+		key = a.prefix + "/" + name
+	}
+
+	var target string
+	if a.endpoint != "" {
+		target = a.endpoint + "/" + a.bucket + "/" + key
+	} else {
+		target = fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", a.bucket, a.region, key)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+	a.sign(req, data, time.Now().UTC())
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("s3 upload failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return "s3://" + a.bucket + "/" + key, nil
+}
+
+// sign adds AWS Signature Version 4 headers to req
+func (a *s3Archiver) sign(req *http.Request, payload []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "content-type;host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "content-type:" + req.Header.Get("Content-Type") + "\n" +
+		"host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + a.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+a.secretKey), day)
+	key = hmacSHA256(key, a.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		a.accessKey, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
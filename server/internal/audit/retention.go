@@ -0,0 +1,250 @@
+package audit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"viacortex/internal/db"
+	"viacortex/internal/logging"
+	"viacortex/internal/reporting"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+var logger = logging.For("audit")
+
+const (
+	// RetentionInterval is how often expired entries are pruned
+	RetentionInterval = 6 * time.Hour
+	pruneBatchSize    = 5000
+)
+
+// Retention deletes audit log entries older than the retention period,
+// archiving each batch first when an Archiver is configured. Every pruned
+// batch is recorded in audit_log_archives together with the hash of its last
+// entry, so the chain of the remaining entries can still be verified.
+type Retention struct {
+	db       *pgxpool.Pool
+	maxAge   time.Duration
+	archiver Archiver
+	isLeader func() bool
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+func NewRetention(db *pgxpool.Pool, maxAge time.Duration, archiver Archiver) *Retention {
+	return &Retention{
+		db:       db,
+		maxAge:   maxAge,
+		archiver: archiver,
+		stopChan: make(chan struct{}),
+	}
+}
+
+// RetentionFromEnv configures a Retention from AUDIT_RETENTION_DAYS and
+// AUDIT_ARCHIVE_URL. It returns nil when no retention period is set, in which
+// case audit logs are kept forever.
+func RetentionFromEnv(db *pgxpool.Pool) (*Retention, error) {
+	days := os.Getenv("AUDIT_RETENTION_DAYS")
+	if days == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(days)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid AUDIT_RETENTION_DAYS %q", days)
+	}
+	if n == 0 {
+		return nil, nil
+	}
+
+	var archiver Archiver
+	if location := os.Getenv("AUDIT_ARCHIVE_URL"); location != "" {
+		archiver, err = NewArchiver(location)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return NewRetention(db, time.Duration(n)*24*time.Hour, archiver), nil
+}
+
+// SetLeader makes the job run only while isLeader reports true, so a cluster
+// prunes once. A nil Retention ignores it.
+func (r *Retention) SetLeader(isLeader func() bool) {
+	if r != nil {
+		r.isLeader = isLeader
+	}
+}
+
+// Start runs the retention job until ctx is cancelled or Stop is called.
+// A nil Retention does nothing.
+func (r *Retention) Start(ctx context.Context) {
+	if r == nil {
+		return
+	}
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(RetentionInterval)
+		defer ticker.Stop()
+
+		for {
+			if r.isLeader == nil || r.isLeader() {
+				r.Run(ctx)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-r.stopChan:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Run prunes expired entries once, logging how many were removed
+func (r *Retention) Run(ctx context.Context) error {
+	n, err := r.Prune(ctx)
+	if err != nil {
+		logger.Error("Pruning audit logs failed", "error", err)
+		reporting.Error(err, map[string]string{"job": "audit_retention"})
+		return err
+	}
+	if n > 0 {
+		logger.Info("Pruned audit log entries", "count", n)
+	}
+	return nil
+}
+
+func (r *Retention) Stop() {
+	if r == nil {
+		return
+	}
+	close(r.stopChan)
+	r.wg.Wait()
+}
+
+// Prune removes every entry older than the retention period in batches and
+// returns the number of deleted entries. A batch is only deleted after it
+// has been archived.
+func (r *Retention) Prune(ctx context.Context) (int, error) {
+	cutoff := time.Now().Add(-r.maxAge)
+	total := 0
+
+	for {
+		n, err := r.pruneBatch(ctx, cutoff)
+		total += n
+		if err != nil || n < pruneBatchSize {
+			return total, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return total, ctx.Err()
+		case <-r.stopChan:
+			return total, nil
+		default:
+		}
+	}
+}
+
+func (r *Retention) pruneBatch(ctx context.Context, cutoff time.Time) (int, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT a.id, a.hash, row_to_json(a)::text
+		FROM audit_logs a
+		WHERE a.timestamp < $1
+		ORDER BY a.id
+		LIMIT $2
+	`, cutoff, pruneBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		buf      bytes.Buffer
+		firstID  int64
+		lastID   int64
+		lastHash *string
+		count    int
+	)
+	gz := gzip.NewWriter(&buf)
+	for rows.Next() {
+		var (
+			id   int64
+			hash *string
+			line string
+		)
+		if err := rows.Scan(&id, &hash, &line); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if count == 0 {
+			firstID = id
+		}
+		lastID, lastHash = id, hash
+		count++
+		gz.Write([]byte(line))
+		gz.Write([]byte("\n"))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if err := gz.Close(); err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		return 0, nil
+	}
+
+	var location *string
+	if r.archiver != nil {
+		name := fmt.Sprintf("audit_logs/%s/%010d-%010d.ndjson.gz",
+			time.Now().UTC().Format("2006/01/02"), firstID, lastID)
+		loc, err := r.archiver.Put(ctx, name, buf.Bytes())
+		if err != nil {
+			return 0, fmt.Errorf("archiving audit logs %d-%d: %w", firstID, lastID, err)
+		}
+		location = &loc
+	}
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	// The append-only trigger lets deletes through only with this set
+	if _, err := tx.Exec(ctx, "SELECT set_config($1, 'on', true)", db.AuditPruneSetting); err != nil {
+		return 0, err
+	}
+
+	result, err := tx.Exec(ctx, `
+		DELETE FROM audit_logs WHERE id BETWEEN $1 AND $2 AND timestamp < $3
+	`, firstID, lastID, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO audit_log_archives (first_id, last_id, row_count, last_hash, location)
+		VALUES ($1, $2, $3, $4, $5)
+	`, firstID, lastID, result.RowsAffected(), lastHash, location)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return int(result.RowsAffected()), nil
+}
@@ -0,0 +1,121 @@
+package audit
+
+import (
+	"reflect"
+	"testing"
+)
+
+func strPtr(s string) *string { return &s }
+
+// linked returns chained entries with ids starting at firstID, the first
+// linking to prev
+func linked(firstID int64, prev *string, hashes ...string) []chainEntry {
+	var entries []chainEntry
+	for i, h := range hashes {
+		entries = append(entries, chainEntry{id: firstID + int64(i), prevHash: prev, hash: strPtr(h), computed: h})
+		prev = strPtr(h)
+	}
+	return entries
+}
+
+func TestChain(t *testing.T) {
+	unchained := func(id int64) chainEntry { return chainEntry{id: id, computed: "ignored"} }
+
+	tests := []struct {
+		name    string
+		anchor  *string
+		entries func() []chainEntry
+		want    VerifyResult
+	}{
+		{
+			name:    "empty log",
+			entries: func() []chainEntry { return nil },
+			want:    VerifyResult{Valid: true},
+		},
+		{
+			name:    "intact chain",
+			entries: func() []chainEntry { return linked(1, nil, "a", "b", "c") },
+			want:    VerifyResult{Valid: true, Checked: 3, FirstID: 1, LastID: 3, LastHash: "c"},
+		},
+		{
+			name: "entries from before chaining",
+			entries: func() []chainEntry {
+				return append([]chainEntry{unchained(1), unchained(2)}, linked(3, nil, "a", "b")...)
+			},
+			want: VerifyResult{Valid: true, Checked: 2, Unchained: 2, FirstID: 1, LastID: 4, LastHash: "b"},
+		},
+		{
+			name:    "links to the archived entries",
+			anchor:  strPtr("archived"),
+			entries: func() []chainEntry { return linked(101, strPtr("archived"), "a", "b") },
+			want:    VerifyResult{Valid: true, Checked: 2, FirstID: 101, LastID: 102, LastHash: "b"},
+		},
+		{
+			name:    "archived entries missing their successor",
+			anchor:  strPtr("archived"),
+			entries: func() []chainEntry { return linked(101, strPtr("other"), "a", "b") },
+			want: VerifyResult{Checked: 1, FirstID: 101, LastID: 101, BrokenAtID: 101,
+				Error: "entry 101 does not link to its predecessor, entries were removed or reordered"},
+		},
+		{
+			name: "modified entry",
+			entries: func() []chainEntry {
+				entries := linked(1, nil, "a", "b", "c")
+				entries[1].computed = "recomputed"
+				return entries
+			},
+			want: VerifyResult{Checked: 2, FirstID: 1, LastID: 2, LastHash: "a", BrokenAtID: 2, Error: "entry 2 was modified"},
+		},
+		{
+			name: "removed entry",
+			entries: func() []chainEntry {
+				entries := linked(1, nil, "a", "b", "c")
+				return append(entries[:1], entries[2])
+			},
+			want: VerifyResult{Checked: 2, FirstID: 1, LastID: 3, LastHash: "a", BrokenAtID: 3,
+				Error: "entry 3 does not link to its predecessor, entries were removed or reordered"},
+		},
+		{
+			name: "swapped entries",
+			entries: func() []chainEntry {
+				entries := linked(1, nil, "a", "b", "c")
+				entries[1], entries[2] = entries[2], entries[1]
+				entries[1].id, entries[2].id = 2, 3
+				return entries
+			},
+			want: VerifyResult{Checked: 2, FirstID: 1, LastID: 2, LastHash: "a", BrokenAtID: 2,
+				Error: "entry 2 does not link to its predecessor, entries were removed or reordered"},
+		},
+		{
+			name: "hash removed after chaining",
+			entries: func() []chainEntry {
+				return append(linked(1, nil, "a"), unchained(2))
+			},
+			want: VerifyResult{Checked: 1, FirstID: 1, LastID: 2, LastHash: "a", BrokenAtID: 2, Error: "entry 2 has no hash"},
+		},
+		{
+			name: "first chained entry claims a predecessor",
+			entries: func() []chainEntry {
+				return linked(1, strPtr("forged"), "a")
+			},
+			want: VerifyResult{Checked: 1, FirstID: 1, LastID: 1, BrokenAtID: 1,
+				Error: "entry 1 does not link to its predecessor, entries were removed or reordered"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := chain{expectedPrev: tt.anchor}
+			valid := true
+			for _, e := range tt.entries() {
+				if !c.add(e) {
+					valid = false
+					break
+				}
+			}
+			c.result.Valid = valid
+			if !reflect.DeepEqual(c.result, tt.want) {
+				t.Errorf("got  %+v\nwant %+v", c.result, tt.want)
+			}
+		})
+	}
+}
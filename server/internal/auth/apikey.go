@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// APIKeyPrefix marks a bearer credential as an API key rather than a JWT
+const APIKeyPrefix = "vc_"
+
+// GenerateAPIKey returns a new random API key, the short non-secret prefix
+// used to identify it in listings, and the hash that is stored in the DB.
+// The raw key is only ever shown to the user once.
+func GenerateAPIKey() (rawKey, displayPrefix, hash string, err error) {
+    buf := make([]byte, 32)
+    if _, err := rand.Read(buf); err != nil {
+        return "", "", "", fmt.Errorf("failed to generate api key: %v", err)
+    }
+
+    rawKey = APIKeyPrefix + base64.RawURLEncoding.EncodeToString(buf)
+    displayPrefix = rawKey[:len(APIKeyPrefix)+8]
+    return rawKey, displayPrefix, HashAPIKey(rawKey), nil
+}
+
+// HashAPIKey returns the hex encoded SHA-256 of a raw API key
+func HashAPIKey(rawKey string) string {
+    sum := sha256.Sum256([]byte(rawKey))
+    return hex.EncodeToString(sum[:])
+}
+
+// IsAPIKey reports whether a bearer credential looks like an API key
+func IsAPIKey(credential string) bool {
+    return strings.HasPrefix(credential, APIKeyPrefix)
+}
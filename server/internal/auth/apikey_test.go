@@ -0,0 +1,62 @@
+package auth
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestHashAPIKey(t *testing.T) {
+    tests := []struct {
+        key  string
+        want string
+    }{
+        {"", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+        {"vc_test", "55336d25327369ee6ecb7f0f0b67eb0fb0032cfbd0f6285aeef138f7bb1abcb7"},
+    }
+    for _, tt := range tests {
+        if got := HashAPIKey(tt.key); got != tt.want {
+            t.Errorf("HashAPIKey(%q) = %s, want %s", tt.key, got, tt.want)
+        }
+    }
+}
+
+func TestGenerateAPIKey(t *testing.T) {
+    seen := map[string]bool{}
+    for i := 0; i < 100; i++ {
+        raw, prefix, hash, err := GenerateAPIKey()
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !IsAPIKey(raw) || len(raw) != len(APIKeyPrefix)+43 {
+            t.Fatalf("raw key %q is not a vc_ key of 32 random bytes", raw)
+        }
+        if prefix != raw[:len(APIKeyPrefix)+8] || !strings.HasPrefix(raw, prefix) {
+            t.Errorf("display prefix %q does not start key %q", prefix, raw)
+        }
+        if hash != HashAPIKey(raw) || strings.Contains(hash, raw) {
+            t.Errorf("hash %q is not the hash of %q", hash, raw)
+        }
+        if seen[raw] {
+            t.Fatalf("key %q generated twice", raw)
+        }
+        seen[raw] = true
+    }
+}
+
+func TestIsAPIKey(t *testing.T) {
+    tests := []struct {
+        credential string
+        want       bool
+    }{
+        {"vc_abc", true},
+        {"vc_", true},
+        {"VC_abc", false},
+        {"eyJhbGciOiJIUzI1NiJ9.e30.sig", false},
+        {"", false},
+    }
+    for _, tt := range tests {
+        if got := IsAPIKey(tt.credential); got != tt.want {
+            t.Errorf("IsAPIKey(%q) = %v, want %v", tt.credential, got, tt.want)
+        }
+    }
+}
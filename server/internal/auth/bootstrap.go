@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// bootstrapLockID serializes creation of the first account across nodes
+const bootstrapLockID = 0x7663426f6f74
+
+// minBootstrapTokenLength keeps an operator supplied BOOTSTRAP_TOKEN from
+// being guessable
+const minBootstrapTokenLength = 16
+
+// ErrBootstrapToken is returned when the first account is created without
+// a valid bootstrap token
+var ErrBootstrapToken = errors.New("a valid bootstrap token is required to create the first admin")
+
+// Bootstrap guards creation of the first admin account on a fresh install.
+// The token is taken from BOOTSTRAP_TOKEN or, when unset, generated and
+// logged at startup while no users exist. Only a hash of a generated token
+// is stored, in the database so every node accepts it.
+type Bootstrap struct {
+    db    *pgxpool.Pool
+    token string
+
+    // OpenRegistration lets anyone register a regular account once the
+    // first admin exists
+    OpenRegistration bool
+}
+
+// BootstrapFromEnv configures the bootstrap token from BOOTSTRAP_TOKEN and
+// open registration from OPEN_REGISTRATION (default true)
+func BootstrapFromEnv(db *pgxpool.Pool) (*Bootstrap, error) {
+    b := &Bootstrap{db: db, token: os.Getenv("BOOTSTRAP_TOKEN"), OpenRegistration: true}
+    if b.token != "" && len(b.token) < minBootstrapTokenLength {
+        return nil, fmt.Errorf("BOOTSTRAP_TOKEN must be at least %d characters", minBootstrapTokenLength)
+    }
+    if v := os.Getenv("OPEN_REGISTRATION"); v != "" {
+        open, err := strconv.ParseBool(v)
+        if err != nil {
+            return nil, fmt.Errorf("invalid OPEN_REGISTRATION %q", v)
+        }
+        b.OpenRegistration = open
+    }
+    return b, nil
+}
+
+// Prepare issues a bootstrap token when no users exist yet. A generated
+// token replaces any issued by an earlier start and is written to the log.
+func (b *Bootstrap) Prepare(ctx context.Context) error {
+    var users int
+    if err := b.db.QueryRow(ctx, "SELECT COUNT(*) FROM users").Scan(&users); err != nil {
+        return err
+    }
+    if users > 0 {
+        _, err := b.db.Exec(ctx, "DELETE FROM bootstrap_tokens")
+        return err
+    }
+
+    if b.token != "" {
+        logger.Info("No users yet: register the first admin with the token from BOOTSTRAP_TOKEN")
+        return nil
+    }
+
+    raw := make([]byte, 24)
+    if _, err := rand.Read(raw); err != nil {
+        return err
+    }
+    token := hex.EncodeToString(raw)
+    hash := sha256.Sum256([]byte(token))
+    if _, err := b.db.Exec(ctx, `
+        INSERT INTO bootstrap_tokens (id, token_hash) VALUES (1, $1)
+        ON CONFLICT (id) DO UPDATE SET token_hash = EXCLUDED.token_hash, created_at = CURRENT_TIMESTAMP
+    `, hash[:]); err != nil {
+        return err
+    }
+
+    logger.Info("No users yet: register the first admin with the bootstrap token", "token", token)
+    return nil
+}
+
+// Claim reports whether the account being registered in tx is the first
+// one. The first account needs a valid token, which is used up by it. Claim
+// holds a transaction lock so two registrations cannot both be first.
+func (b *Bootstrap) Claim(ctx context.Context, tx pgx.Tx, token string) (bool, error) {
+    if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", bootstrapLockID); err != nil {
+        return false, err
+    }
+
+    var users int
+    if err := tx.QueryRow(ctx, "SELECT COUNT(*) FROM users").Scan(&users); err != nil {
+        return false, err
+    }
+    if users > 0 {
+        return false, nil
+    }
+
+    if token == "" {
+        return false, ErrBootstrapToken
+    }
+    if b.token != "" {
+        if subtle.ConstantTimeCompare([]byte(token), []byte(b.token)) != 1 {
+            return false, ErrBootstrapToken
+        }
+    } else {
+        var stored []byte
+        err := tx.QueryRow(ctx, "SELECT token_hash FROM bootstrap_tokens WHERE id = 1").Scan(&stored)
+        if err == pgx.ErrNoRows {
+            return false, ErrBootstrapToken
+        }
+        if err != nil {
+            return false, err
+        }
+        hash := sha256.Sum256([]byte(token))
+        if subtle.ConstantTimeCompare(hash[:], stored) != 1 {
+            return false, ErrBootstrapToken
+        }
+    }
+
+    if _, err := tx.Exec(ctx, "DELETE FROM bootstrap_tokens"); err != nil {
+        return false, err
+    }
+    return true, nil
+}
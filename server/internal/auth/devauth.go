@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// devAuthKeyFile is the file in the storage directory the development key
+// is written to, so it never appears in logs
+const devAuthKeyFile = "dev-auth-key"
+
+// DevAuthKeyFromEnv returns a freshly generated local API key when
+// VIACORTEX_DEV_AUTH is true, or "" when development authentication is off.
+// The key acts as the first admin, so it is refused when ENV=production.
+func DevAuthKeyFromEnv() (string, error) {
+    v := os.Getenv("VIACORTEX_DEV_AUTH")
+    if v == "" {
+        return "", nil
+    }
+    enabled, err := strconv.ParseBool(v)
+    if err != nil {
+        return "", fmt.Errorf("invalid VIACORTEX_DEV_AUTH %q", v)
+    }
+    if !enabled {
+        return "", nil
+    }
+    if os.Getenv("ENV") == "production" {
+        return "", fmt.Errorf("VIACORTEX_DEV_AUTH cannot be used with ENV=production")
+    }
+
+    rawKey, _, _, err := GenerateAPIKey()
+    return rawKey, err
+}
+
+// WriteDevAuthKey stores key in dir, readable only by the server's user, and
+// returns the file's path
+func WriteDevAuthKey(dir, key string) (string, error) {
+    if err := os.MkdirAll(dir, 0o700); err != nil {
+        return "", err
+    }
+    path := filepath.Join(dir, devAuthKeyFile)
+    if err := os.WriteFile(path, []byte(key+"\n"), 0o600); err != nil {
+        return "", err
+    }
+    // WriteFile keeps the mode of an existing file
+    if err := os.Chmod(path, 0o600); err != nil {
+        return "", err
+    }
+    return path, nil
+}
@@ -2,17 +2,24 @@ package auth
 
 import (
 	"fmt"
-	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// Token lifetimes. A session stays alive as long as its refresh token keeps
+// being used within RefreshTokenTTL.
+const (
+    AccessTokenTTL  = 15 * time.Minute
+    RefreshTokenTTL = 168 * time.Hour
+)
+
 type TokenPair struct {
     AccessToken  string `json:"access_token"`
 	AccessTokenValidUntil time.Time `json:"access_token_valid_until"`
     RefreshToken string `json:"refresh_token"`
 	RefreshTokenValidUntil time.Time `json:"refresh_token_valid_until"`
+    SessionID    string `json:"session_id,omitempty"`
 }
 
 type Claims struct {
@@ -20,37 +27,47 @@ type Claims struct {
     Email  string `json:"email"`
     Role   string `json:"role"`
     Type   string `json:"type"` // "access" or "refresh"
+    // SessionID ties both tokens of a pair to a row in the sessions table
+    SessionID string `json:"sid,omitempty"`
     jwt.RegisteredClaims
 }
 
 func GenerateTokenPair(userID, email, role string) (*TokenPair, error) {
+    return GenerateSessionTokenPair(userID, email, role, "")
+}
+
+// GenerateSessionTokenPair issues tokens bound to a login session, so they
+// can be listed and revoked
+func GenerateSessionTokenPair(userID, email, role, sessionID string) (*TokenPair, error) {
     // Access token - short lived (15 minutes)
-    accessToken, err := generateToken(userID, email, role, "access", 15*time.Minute)
+    accessToken, err := generateToken(userID, email, role, sessionID, "access", AccessTokenTTL)
     if err != nil {
         return nil, fmt.Errorf("failed to generate access token: %v", err)
     }
 
     // Refresh token - long lived (7 days)
-    refreshToken, err := generateToken(userID, email, role, "refresh", 168*time.Hour)
+    refreshToken, err := generateToken(userID, email, role, sessionID, "refresh", RefreshTokenTTL)
     if err != nil {
         return nil, fmt.Errorf("failed to generate refresh token: %v", err)
     }
 
     return &TokenPair{
         AccessToken:  accessToken,
-		AccessTokenValidUntil: time.Now().Add(15*time.Minute),
+		AccessTokenValidUntil: time.Now().Add(AccessTokenTTL),
         RefreshToken: refreshToken,
-		RefreshTokenValidUntil: time.Now().Add(168*time.Hour),
+		RefreshTokenValidUntil: time.Now().Add(RefreshTokenTTL),
+        SessionID:    sessionID,
     }, nil
 }
 
-func generateToken(userID, email, role, tokenType string, expiry time.Duration) (string, error) {
-    secret := []byte(os.Getenv("JWT_SECRET"))
+func generateToken(userID, email, role, sessionID, tokenType string, expiry time.Duration) (string, error) {
+    kid, secret := currentSigningKey()
     claims := Claims{
         UserID: userID,
         Email:  email,
         Role:   role,
         Type:   tokenType,
+        SessionID: sessionID,
         RegisteredClaims: jwt.RegisteredClaims{
             ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiry)),
             IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -58,6 +75,9 @@ func generateToken(userID, email, role, tokenType string, expiry time.Duration)
     }
 
     token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+    if kid != "" {
+        token.Header["kid"] = kid
+    }
     return token.SignedString(secret)
 }
 
@@ -66,7 +86,8 @@ func ValidateToken(tokenString string) (*Claims, error) {
         if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
             return nil, fmt.Errorf("unexpected signing method")
         }
-        return []byte(os.Getenv("JWT_SECRET")), nil
+        kid, _ := t.Header["kid"].(string)
+        return verificationKey(kid)
     })
 
     if err != nil {
@@ -0,0 +1,313 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"viacortex/internal/logging"
+	"viacortex/internal/reporting"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+var logger = logging.For("auth")
+
+const (
+    // keyReloadInterval is how often every node re-reads the key set
+    keyReloadInterval = time.Minute
+    // keyActivationDelay gives every node time to load a new key before
+    // tokens signed with it are handed out
+    keyActivationDelay = 2 * keyReloadInterval
+    // keyRingLockID serializes key changes across nodes
+    keyRingLockID = 0x76634a5754
+)
+
+// KeyInfo describes a signing key without its secret
+type KeyInfo struct {
+    ID          string     `json:"id"`
+    Active      bool       `json:"active"`
+    CreatedAt   time.Time  `json:"created_at"`
+    ActivatesAt time.Time  `json:"activates_at"`
+    RetiredAt   *time.Time `json:"retired_at,omitempty"`
+}
+
+// KeyRing keeps the JWT signing keys in the database so every node signs and
+// verifies with the same set. Rotating adds a key that becomes active after
+// keyActivationDelay; the previous key keeps verifying tokens until the
+// longest lived of them, a refresh token, has expired.
+type KeyRing struct {
+    db          *pgxpool.Pool
+    rotateEvery time.Duration
+    stopChan    chan struct{}
+    wg          sync.WaitGroup
+}
+
+// NewKeyRing creates a key ring. rotateEvery of zero disables scheduled
+// rotation.
+func NewKeyRing(db *pgxpool.Pool, rotateEvery time.Duration) *KeyRing {
+    return &KeyRing{db: db, rotateEvery: rotateEvery, stopChan: make(chan struct{})}
+}
+
+// KeyRingFromEnv creates a key ring rotating every JWT_KEY_ROTATION_DAYS
+// days, or only on request when it is unset or 0
+func KeyRingFromEnv(db *pgxpool.Pool) (*KeyRing, error) {
+    var rotateEvery time.Duration
+    if days := os.Getenv("JWT_KEY_ROTATION_DAYS"); days != "" {
+        n, err := strconv.Atoi(days)
+        if err != nil || n < 0 {
+            return nil, fmt.Errorf("invalid JWT_KEY_ROTATION_DAYS %q", days)
+        }
+        rotateEvery = time.Duration(n) * 24 * time.Hour
+    }
+    return NewKeyRing(db, rotateEvery), nil
+}
+
+// Load installs the current key set, creating the first key if there is none
+func (k *KeyRing) Load(ctx context.Context) error {
+    keys, err := k.load(ctx)
+    if err != nil {
+        return err
+    }
+    if len(keys) == 0 {
+        if err := k.createFirstKey(ctx); err != nil {
+            return fmt.Errorf("creating first signing key: %w", err)
+        }
+        if keys, err = k.load(ctx); err != nil {
+            return err
+        }
+        if len(keys) == 0 {
+            return fmt.Errorf("no signing keys")
+        }
+    }
+
+    var active *SigningKey
+    valid := make([]SigningKey, 0, len(keys))
+    now := time.Now()
+    for _, key := range keys {
+        valid = append(valid, key.SigningKey)
+        if !key.activatesAt.After(now) {
+            sk := key.SigningKey
+            active = &sk
+        }
+    }
+    if active == nil {
+        // Only keys that are not active yet; sign with the oldest
+        active = &valid[0]
+    }
+    SetSigningKeys(*active, valid)
+    return nil
+}
+
+// createFirstKey adds an immediately active key unless another node got
+// there first
+func (k *KeyRing) createFirstKey(ctx context.Context) error {
+    tx, err := k.db.Begin(ctx)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback(ctx)
+
+    if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", keyRingLockID); err != nil {
+        return err
+    }
+    var exists bool
+    if err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM jwt_signing_keys WHERE retired_at IS NULL)").Scan(&exists); err != nil {
+        return err
+    }
+    if !exists {
+        if _, err := rotate(ctx, tx, true); err != nil {
+            return err
+        }
+    }
+    return tx.Commit(ctx)
+}
+
+type storedKey struct {
+    SigningKey
+    activatesAt time.Time
+}
+
+// load returns the keys tokens may still be signed with, oldest first
+func (k *KeyRing) load(ctx context.Context) ([]storedKey, error) {
+    rows, err := k.db.Query(ctx, `
+        SELECT kid, secret, activates_at
+        FROM jwt_signing_keys
+        WHERE retired_at IS NULL OR retired_at > $1
+        ORDER BY activates_at
+    `, time.Now().Add(-RefreshTokenTTL))
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var keys []storedKey
+    for rows.Next() {
+        var key storedKey
+        if err := rows.Scan(&key.ID, &key.Secret, &key.activatesAt); err != nil {
+            return nil, err
+        }
+        keys = append(keys, key)
+    }
+    return keys, rows.Err()
+}
+
+// Keys lists the signing keys that still verify tokens
+func (k *KeyRing) Keys(ctx context.Context) ([]KeyInfo, error) {
+    rows, err := k.db.Query(ctx, `
+        SELECT kid, created_at, activates_at, retired_at
+        FROM jwt_signing_keys
+        WHERE retired_at IS NULL OR retired_at > $1
+        ORDER BY activates_at
+    `, time.Now().Add(-RefreshTokenTTL))
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    activeID, _ := currentSigningKey()
+    keys := []KeyInfo{}
+    for rows.Next() {
+        var key KeyInfo
+        if err := rows.Scan(&key.ID, &key.CreatedAt, &key.ActivatesAt, &key.RetiredAt); err != nil {
+            return nil, err
+        }
+        key.Active = key.ID == activeID
+        keys = append(keys, key)
+    }
+    return keys, rows.Err()
+}
+
+// Rotate adds a new signing key. With revokePrevious every other key is
+// deleted and the new one is active at once, which invalidates all issued
+// tokens; use it when a key may have leaked.
+func (k *KeyRing) Rotate(ctx context.Context, revokePrevious bool) (KeyInfo, error) {
+    tx, err := k.db.Begin(ctx)
+    if err != nil {
+        return KeyInfo{}, err
+    }
+    defer tx.Rollback(ctx)
+
+    if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", keyRingLockID); err != nil {
+        return KeyInfo{}, err
+    }
+    key, err := rotate(ctx, tx, revokePrevious)
+    if err != nil {
+        return KeyInfo{}, err
+    }
+    if err := tx.Commit(ctx); err != nil {
+        return KeyInfo{}, err
+    }
+
+    logger.Info("Added JWT signing key", "kid", key.ID, "active_from", key.ActivatesAt.Format(time.RFC3339))
+    return key, k.Load(ctx)
+}
+
+func rotate(ctx context.Context, tx pgx.Tx, revokePrevious bool) (KeyInfo, error) {
+    id := make([]byte, 8)
+    secret := make([]byte, 32)
+    if _, err := rand.Read(id); err != nil {
+        return KeyInfo{}, err
+    }
+    if _, err := rand.Read(secret); err != nil {
+        return KeyInfo{}, err
+    }
+
+    key := KeyInfo{ID: hex.EncodeToString(id), CreatedAt: time.Now(), ActivatesAt: time.Now()}
+    if revokePrevious {
+        if _, err := tx.Exec(ctx, "DELETE FROM jwt_signing_keys"); err != nil {
+            return KeyInfo{}, err
+        }
+    } else {
+        // The previous key keeps signing until the new one takes over
+        key.ActivatesAt = key.ActivatesAt.Add(keyActivationDelay)
+        if _, err := tx.Exec(ctx, `
+            UPDATE jwt_signing_keys SET retired_at = $1 WHERE retired_at IS NULL
+        `, key.ActivatesAt); err != nil {
+            return KeyInfo{}, err
+        }
+    }
+
+    _, err := tx.Exec(ctx, `
+        INSERT INTO jwt_signing_keys (kid, secret, created_at, activates_at)
+        VALUES ($1, $2, $3, $4)
+    `, key.ID, secret, key.CreatedAt, key.ActivatesAt)
+    return key, err
+}
+
+// Start reloads the key set every minute, rotating on schedule and removing
+// keys no token can refer to any more, until ctx is cancelled or Stop is
+// called
+func (k *KeyRing) Start(ctx context.Context) {
+    k.wg.Add(1)
+    go func() {
+        defer k.wg.Done()
+
+        ticker := time.NewTicker(keyReloadInterval)
+        defer ticker.Stop()
+
+        for {
+            select {
+            case <-ctx.Done():
+                return
+            case <-k.stopChan:
+                return
+            case <-ticker.C:
+            }
+
+            if err := k.maintain(ctx); err != nil {
+                logger.Error("Maintaining JWT signing keys failed", "error", err)
+                reporting.Error(err, map[string]string{"job": "signing_keys"})
+            }
+            if err := k.Load(ctx); err != nil {
+                logger.Error("Loading JWT signing keys failed", "error", err)
+            }
+        }
+    }()
+}
+
+func (k *KeyRing) Stop() {
+    close(k.stopChan)
+    k.wg.Wait()
+}
+
+// maintain rotates when the newest key is older than the rotation period
+// and deletes keys that retired more than a refresh token lifetime ago
+func (k *KeyRing) maintain(ctx context.Context) error {
+    tx, err := k.db.Begin(ctx)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback(ctx)
+
+    if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", keyRingLockID); err != nil {
+        return err
+    }
+
+    if _, err := tx.Exec(ctx, `
+        DELETE FROM jwt_signing_keys WHERE retired_at <= $1
+    `, time.Now().Add(-RefreshTokenTTL)); err != nil {
+        return err
+    }
+
+    if k.rotateEvery > 0 {
+        var newest *time.Time
+        if err := tx.QueryRow(ctx, "SELECT MAX(created_at) FROM jwt_signing_keys").Scan(&newest); err != nil {
+            return err
+        }
+        if newest != nil && time.Since(*newest) >= k.rotateEvery {
+            key, err := rotate(ctx, tx, false)
+            if err != nil {
+                return err
+            }
+            logger.Info("Rotated JWT signing key on schedule", "kid", key.ID, "active_from", key.ActivatesAt.Format(time.RFC3339))
+        }
+    }
+    return tx.Commit(ctx)
+}
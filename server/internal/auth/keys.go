@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"sync"
+)
+
+// SigningKey is an HMAC key used to sign JWTs, identified by the kid header
+type SigningKey struct {
+    ID     string
+    Secret []byte
+}
+
+// keySet holds the key new tokens are signed with and every key tokens are
+// still accepted from. It is installed by the KeyRing.
+var keySet struct {
+    sync.RWMutex
+    active *SigningKey
+    byID   map[string][]byte
+}
+
+// SetSigningKeys replaces the signing key set. active signs new tokens;
+// tokens signed by any key in valid are accepted.
+func SetSigningKeys(active SigningKey, valid []SigningKey) {
+    byID := make(map[string][]byte, len(valid)+1)
+    for _, k := range valid {
+        byID[k.ID] = k.Secret
+    }
+    byID[active.ID] = active.Secret
+
+    keySet.Lock()
+    keySet.active = &active
+    keySet.byID = byID
+    keySet.Unlock()
+}
+
+// currentSigningKey returns the key ID and secret for new tokens. Before a
+// key set is installed, tokens are signed with JWT_SECRET and carry no kid.
+func currentSigningKey() (string, []byte) {
+    keySet.RLock()
+    defer keySet.RUnlock()
+    if keySet.active != nil {
+        return keySet.active.ID, keySet.active.Secret
+    }
+    return "", []byte(os.Getenv("JWT_SECRET"))
+}
+
+// verificationKey returns the secret a token's kid refers to. Tokens without
+// a kid are only accepted until a key set is installed; after that a leaked
+// JWT_SECRET can no longer be used to mint tokens.
+func verificationKey(kid string) ([]byte, error) {
+    keySet.RLock()
+    defer keySet.RUnlock()
+
+    if kid == "" {
+        if keySet.active != nil {
+            return nil, errors.New("token has no key ID")
+        }
+        return []byte(os.Getenv("JWT_SECRET")), nil
+    }
+    secret, ok := keySet.byID[kid]
+    if !ok {
+        return nil, errors.New("unknown signing key")
+    }
+    return secret, nil
+}
@@ -0,0 +1,137 @@
+// Package backup writes and restores encrypted archives of the server's
+// configuration: organizations, users, domains with everything attached to
+// them, hostname claims and webhooks. Metrics, logs, sessions and the audit
+// trail are not included.
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// FormatVersion is the archive layout written by Seal
+const FormatVersion = 1
+
+// MinPassphraseLength is the shortest passphrase Seal accepts
+const MinPassphraseLength = 12
+
+// magic starts every archive, followed by the format version byte
+const magic = "VIACORTEXBAK"
+
+const (
+	saltSize = 16
+	keySize  = 32
+)
+
+// ErrBadPassphrase is returned by Open when the archive cannot be decrypted,
+// either because the passphrase is wrong or the archive was modified
+var ErrBadPassphrase = errors.New("wrong passphrase or corrupted archive")
+
+// Archive is the decrypted content of a backup
+type Archive struct {
+	CreatedAt     time.Time `json:"created_at"`
+	SchemaVersion int64     `json:"schema_version"`
+	// Tables holds the rows of each table as a JSON array of objects
+	Tables map[string]json.RawMessage `json:"tables"`
+	// Certificates describes the certificates stored when the backup was
+	// made. It is informational; certificates are requested again after a
+	// restore.
+	Certificates interface{} `json:"certificates,omitempty"`
+}
+
+// Seal compresses and encrypts an archive with a key derived from the
+// passphrase
+func Seal(a *Archive, passphrase string) ([]byte, error) {
+	if len(passphrase) < MinPassphraseLength {
+		return nil, fmt.Errorf("passphrase must be at least %d characters", MinPassphraseLength)
+	}
+
+	var plain bytes.Buffer
+	zw := gzip.NewWriter(&plain)
+	if err := json.NewEncoder(zw).Encode(a); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	header := append([]byte(magic), FormatVersion)
+	out := append(header, salt...)
+	out = append(out, nonce...)
+	// The header is authenticated so the version cannot be swapped
+	return aead.Seal(out, nonce, plain.Bytes(), header), nil
+}
+
+// Open decrypts and decodes an archive written by Seal
+func Open(data []byte, passphrase string) (*Archive, error) {
+	if len(data) < len(magic)+1 || string(data[:len(magic)]) != magic {
+		return nil, errors.New("not a viacortex backup")
+	}
+	header := data[:len(magic)+1]
+	if version := header[len(magic)]; version != FormatVersion {
+		return nil, fmt.Errorf("unsupported backup format version %d", version)
+	}
+	rest := data[len(header):]
+	if len(rest) < saltSize {
+		return nil, ErrBadPassphrase
+	}
+	salt, rest := rest[:saltSize], rest[saltSize:]
+
+	aead, err := newAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) < aead.NonceSize() {
+		return nil, ErrBadPassphrase
+	}
+	nonce, sealed := rest[:aead.NonceSize()], rest[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, sealed, header)
+	if err != nil {
+		return nil, ErrBadPassphrase
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(plain))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	var a Archive
+	if err := json.NewDecoder(zr).Decode(&a); err != nil {
+		return nil, fmt.Errorf("invalid backup content: %w", err)
+	}
+	return &a, nil
+}
+
+func newAEAD(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, keySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,191 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// table is a table included in backups and the columns of its primary key
+type table struct {
+	name string
+	key  []string
+}
+
+// tables are listed so that every table comes after the tables it
+// references
+var tables = []table{
+	{"organizations", []string{"id"}},
+	{"roles", []string{"id"}},
+	{"users", []string{"id"}},
+	{"organization_members", []string{"org_id", "user_id"}},
+	{"webauthn_credentials", []string{"id"}},
+	{"api_keys", []string{"id"}},
+	{"domain_claims", []string{"id"}},
+	{"domains", []string{"id"}},
+	{"domain_members", []string{"domain_id", "user_id"}},
+	{"domain_aliases", []string{"id"}},
+	{"domain_transport", []string{"domain_id"}},
+	{"domain_body_rewrites", []string{"domain_id"}},
+	{"domain_cache_rules", []string{"id"}},
+	{"domain_early_hints", []string{"id"}},
+	{"domain_server_timing", []string{"domain_id"}},
+	{"backend_servers", []string{"id"}},
+	{"ip_rules", []string{"id"}},
+	{"rate_limits", []string{"id"}},
+	{"webhooks", []string{"id"}},
+	{"threat_feeds", []string{"id"}},
+	{"threat_allowlist", []string{"id"}},
+}
+
+// Dump reads every backed up table into an archive. It should run in a
+// repeatable read transaction so the tables are consistent with each other.
+func Dump(ctx context.Context, tx pgx.Tx) (map[string]json.RawMessage, error) {
+	out := make(map[string]json.RawMessage, len(tables))
+	for _, t := range tables {
+		var rows json.RawMessage
+		err := tx.QueryRow(ctx, fmt.Sprintf(
+			`SELECT COALESCE(json_agg(t ORDER BY %s), '[]') FROM %s t`,
+			strings.Join(t.key, ", "), t.name,
+		)).Scan(&rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", t.name, err)
+		}
+		out[t.name] = rows
+	}
+	return out, nil
+}
+
+// RestoreSummary counts the rows a restore wrote and removed per table
+type RestoreSummary struct {
+	Restored    map[string]int64 `json:"restored"`
+	Removed     map[string]int64 `json:"removed"`
+	Deactivated int64            `json:"deactivated_users"`
+}
+
+// Restore replaces the backed up tables with the content of an archive.
+// Rows missing from the archive are removed, except users who appear in the
+// audit trail: they are deactivated so the trail keeps its authors. Tables
+// the archive does not contain, e.g. from an older version, are left as
+// they are.
+func Restore(ctx context.Context, tx pgx.Tx, data map[string]json.RawMessage) (*RestoreSummary, error) {
+	summary := &RestoreSummary{Restored: map[string]int64{}, Removed: map[string]int64{}}
+
+	// Remove stale rows first, dependents before the rows they reference,
+	// so they cannot collide with restored rows on unique columns
+	for i := len(tables) - 1; i >= 0; i-- {
+		t := tables[i]
+		rows, ok := data[t.name]
+		if !ok {
+			continue
+		}
+		key := strings.Join(t.key, ", ")
+		stale := fmt.Sprintf(`(%s) NOT IN (SELECT %s FROM json_populate_recordset(NULL::%s, $1::json))`, key, key, t.name)
+
+		if t.name == "users" {
+			tag, err := tx.Exec(ctx, `UPDATE users SET active = false
+				WHERE `+stale+` AND EXISTS (SELECT 1 FROM audit_logs a WHERE a.user_id = users.id)`, string(rows))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", t.name, err)
+			}
+			summary.Deactivated = tag.RowsAffected()
+			stale += ` AND NOT EXISTS (SELECT 1 FROM audit_logs a WHERE a.user_id = users.id)`
+		}
+
+		tag, err := tx.Exec(ctx, `DELETE FROM `+t.name+` WHERE `+stale, string(rows))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", t.name, err)
+		}
+		summary.Removed[t.name] = tag.RowsAffected()
+	}
+
+	for _, t := range tables {
+		rows, ok := data[t.name]
+		if !ok {
+			continue
+		}
+		n, err := upsert(ctx, tx, t, rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", t.name, err)
+		}
+		summary.Restored[t.name] = n
+
+		// Serial ids restored explicitly leave the sequence behind
+		if len(t.key) == 1 && t.key[0] == "id" {
+			_, err := tx.Exec(ctx, fmt.Sprintf(
+				`SELECT setval(pg_get_serial_sequence('%s', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM %s`,
+				t.name, t.name))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", t.name, err)
+			}
+		}
+	}
+	return summary, nil
+}
+
+// upsert writes the rows of a table by primary key. Only the columns present
+// in both the archive and the table are written, so columns added since the
+// backup was made take their defaults.
+func upsert(ctx context.Context, tx pgx.Tx, t table, rows json.RawMessage) (int64, error) {
+	var objects []map[string]json.RawMessage
+	if err := json.Unmarshal(rows, &objects); err != nil {
+		return 0, err
+	}
+	if len(objects) == 0 {
+		return 0, nil
+	}
+
+	existing, err := tx.Query(ctx, `
+		SELECT column_name FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1
+		ORDER BY ordinal_position
+	`, t.name)
+	if err != nil {
+		return 0, err
+	}
+	var columns, updates []string
+	for existing.Next() {
+		var column string
+		if err := existing.Scan(&column); err != nil {
+			existing.Close()
+			return 0, err
+		}
+		if _, ok := objects[0][column]; !ok {
+			continue
+		}
+		columns = append(columns, pgx.Identifier{column}.Sanitize())
+		if !isKey(t, column) {
+			updates = append(updates, fmt.Sprintf("%[1]s = EXCLUDED.%[1]s", pgx.Identifier{column}.Sanitize()))
+		}
+	}
+	existing.Close()
+	if err := existing.Err(); err != nil {
+		return 0, err
+	}
+
+	list := strings.Join(columns, ", ")
+	conflict := "DO NOTHING"
+	if len(updates) > 0 {
+		conflict = "DO UPDATE SET " + strings.Join(updates, ", ")
+	}
+	tag, err := tx.Exec(ctx, fmt.Sprintf(
+		`INSERT INTO %s (%s) SELECT %s FROM json_populate_recordset(NULL::%s, $1::json) ON CONFLICT (%s) %s`,
+		t.name, list, list, t.name, strings.Join(t.key, ", "), conflict,
+	), string(rows))
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+func isKey(t table, column string) bool {
+	for _, k := range t.key {
+		if k == column {
+			return true
+		}
+	}
+	return false
+}
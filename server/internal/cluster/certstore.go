@@ -0,0 +1,220 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// lockTTL is how long a certificate lock outlives a node that stopped
+// refreshing it, e.g. because it crashed mid-issuance
+const lockTTL = 2 * time.Minute
+
+// lockPoll is how often a node retries a lock held by another node
+const lockPoll = time.Second
+
+// CertStorage keeps certmagic's certificates, keys and ACME state in the
+// database, so every node of the cluster serves the same certificates and
+// only one of them requests each certificate
+type CertStorage struct {
+	db     *pgxpool.Pool
+	holder string
+
+	mu    sync.Mutex
+	locks map[string]context.CancelFunc // held locks and their refreshers
+}
+
+var _ certmagic.Storage = (*CertStorage)(nil)
+
+func NewCertStorage(db *pgxpool.Pool, nodeID string) *CertStorage {
+	return &CertStorage{
+		db:     db,
+		holder: nodeID,
+		locks:  make(map[string]context.CancelFunc),
+	}
+}
+
+func (s *CertStorage) Store(ctx context.Context, key string, value []byte) error {
+	_, err := s.db.Exec(ctx, `
+		INSERT INTO cert_storage (key, value, modified_at)
+		VALUES ($1, $2, now())
+		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, modified_at = EXCLUDED.modified_at
+	`, key, value)
+	return err
+}
+
+func (s *CertStorage) Load(ctx context.Context, key string) ([]byte, error) {
+	var value []byte
+	err := s.db.QueryRow(ctx, "SELECT value FROM cert_storage WHERE key = $1", key).Scan(&value)
+	if err != nil {
+		if err.Error() == "no rows in result set" {
+			return nil, fs.ErrNotExist
+		}
+		return nil, err
+	}
+	return value, nil
+}
+
+// Delete removes a key, or every key below it when it names a directory
+func (s *CertStorage) Delete(ctx context.Context, key string) error {
+	tag, err := s.db.Exec(ctx,
+		"DELETE FROM cert_storage WHERE key = $1 OR key LIKE $2 ESCAPE '\\'",
+		key, likePrefix(key))
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fs.ErrNotExist
+	}
+	return nil
+}
+
+func (s *CertStorage) Exists(ctx context.Context, key string) bool {
+	var exists bool
+	err := s.db.QueryRow(ctx,
+		"SELECT EXISTS (SELECT 1 FROM cert_storage WHERE key = $1 OR key LIKE $2 ESCAPE '\\')",
+		key, likePrefix(key)).Scan(&exists)
+	return err == nil && exists
+}
+
+// List returns the keys directly below path, or all keys below it when
+// recursive is set. Directories are implied by the keys beneath them.
+func (s *CertStorage) List(ctx context.Context, path string, recursive bool) ([]string, error) {
+	rows, err := s.db.Query(ctx,
+		"SELECT key FROM cert_storage WHERE key LIKE $1 ESCAPE '\\' ORDER BY key",
+		likePrefix(path))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prefix := ""
+	if path = strings.TrimSuffix(path, "/"); path != "" {
+		prefix = path + "/"
+	}
+	seen := make(map[string]bool)
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		if !recursive {
+			if i := strings.IndexByte(key[len(prefix):], '/'); i >= 0 {
+				key = key[:len(prefix)+i]
+			}
+		}
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fs.ErrNotExist
+	}
+	return keys, nil
+}
+
+func (s *CertStorage) Stat(ctx context.Context, key string) (certmagic.KeyInfo, error) {
+	info := certmagic.KeyInfo{Key: key, IsTerminal: true}
+	err := s.db.QueryRow(ctx,
+		"SELECT modified_at, length(value) FROM cert_storage WHERE key = $1",
+		key).Scan(&info.Modified, &info.Size)
+	if err == nil {
+		return info, nil
+	}
+	if err.Error() != "no rows in result set" {
+		return info, err
+	}
+	if s.Exists(ctx, key) {
+		return certmagic.KeyInfo{Key: key, IsTerminal: false}, nil
+	}
+	return info, fs.ErrNotExist
+}
+
+// Lock blocks until this node holds the named lock. A lock whose holder
+// stopped refreshing it is taken over once it expires.
+func (s *CertStorage) Lock(ctx context.Context, name string) error {
+	for {
+		tag, err := s.db.Exec(ctx, `
+			INSERT INTO cert_locks (name, holder, expires_at)
+			VALUES ($1, $2, now() + $3::interval)
+			ON CONFLICT (name) DO UPDATE SET holder = EXCLUDED.holder, expires_at = EXCLUDED.expires_at
+			WHERE cert_locks.expires_at < now()
+		`, name, s.holder, lockTTL.String())
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() > 0 {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(lockPoll):
+		}
+	}
+
+	refreshCtx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	if prev, ok := s.locks[name]; ok {
+		prev()
+	}
+	s.locks[name] = cancel
+	s.mu.Unlock()
+	go s.refresh(refreshCtx, name)
+	return nil
+}
+
+// refresh extends a held lock until it is released
+func (s *CertStorage) refresh(ctx context.Context, name string) {
+	ticker := time.NewTicker(lockTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.db.Exec(ctx,
+				"UPDATE cert_locks SET expires_at = now() + $3::interval WHERE name = $1 AND holder = $2",
+				name, s.holder, lockTTL.String())
+		}
+	}
+}
+
+func (s *CertStorage) Unlock(ctx context.Context, name string) error {
+	s.mu.Lock()
+	if cancel, ok := s.locks[name]; ok {
+		cancel()
+		delete(s.locks, name)
+	}
+	s.mu.Unlock()
+
+	tag, err := s.db.Exec(ctx, "DELETE FROM cert_locks WHERE name = $1 AND holder = $2", name, s.holder)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.New("lock " + name + " is not held by this node")
+	}
+	return nil
+}
+
+// likePrefix matches every key below path; the empty path matches all keys
+func likePrefix(path string) string {
+	path = strings.TrimSuffix(path, "/")
+	if path == "" {
+		return "%"
+	}
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(path) + "/%"
+}
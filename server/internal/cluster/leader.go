@@ -0,0 +1,125 @@
+package cluster
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// leaderLockID is the session advisory lock held by the cluster leader
+const leaderLockID = 0x76634c656164
+
+// Leader elects one node of the cluster to run singleton background jobs,
+// such as health checks, webhook delivery and retention. The node holding a
+// Postgres advisory lock is the leader; the lock is tied to its connection,
+// so it is released as soon as the node or its connection goes away and
+// another node takes over on its next attempt.
+type Leader struct {
+	db       *pgxpool.Pool
+	interval time.Duration
+
+	mu      sync.RWMutex
+	leading bool
+	paused  bool
+	conn    *pgxpool.Conn
+}
+
+func NewLeader(db *pgxpool.Pool, interval time.Duration) *Leader {
+	return &Leader{db: db, interval: interval}
+}
+
+// IsLeader reports whether this node currently runs the singleton jobs
+func (l *Leader) IsLeader() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.leading
+}
+
+// SetPaused keeps the node out of the election, e.g. while it drains ahead
+// of a restart. Pausing a leader hands leadership to another node.
+func (l *Leader) SetPaused(paused bool) {
+	l.mu.Lock()
+	l.paused = paused
+	l.mu.Unlock()
+	if paused {
+		l.stepDown()
+	}
+}
+
+// Start campaigns for leadership until ctx is cancelled, then steps down
+func (l *Leader) Start(ctx context.Context) {
+	l.campaign(ctx)
+	go func() {
+		ticker := time.NewTicker(l.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				l.stepDown()
+				return
+			case <-ticker.C:
+				l.campaign(ctx)
+			}
+		}
+	}()
+}
+
+// campaign takes the lock when it is free, or makes sure the connection
+// holding it is still alive
+func (l *Leader) campaign(ctx context.Context) {
+	l.mu.RLock()
+	conn, paused := l.conn, l.paused
+	l.mu.RUnlock()
+
+	if paused {
+		return
+	}
+	if conn != nil {
+		if _, err := conn.Exec(ctx, "SELECT 1"); err != nil && ctx.Err() == nil {
+			logger.Warn("Lost cluster leadership", "error", err)
+			l.stepDown()
+		}
+		return
+	}
+
+	conn, err := l.db.Acquire(ctx)
+	if err != nil {
+		return
+	}
+	var acquired bool
+	if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", leaderLockID).Scan(&acquired); err != nil || !acquired {
+		conn.Release()
+		return
+	}
+
+	l.mu.Lock()
+	if l.paused {
+		// Paused while the lock was being taken
+		l.mu.Unlock()
+		conn.Conn().Close(context.Background())
+		conn.Release()
+		return
+	}
+	l.conn = conn
+	l.leading = true
+	l.mu.Unlock()
+	logger.Info("This node is now the cluster leader")
+}
+
+// stepDown gives up leadership. The connection is closed rather than
+// returned to the pool, which also releases the lock if unlocking fails.
+func (l *Leader) stepDown() {
+	l.mu.Lock()
+	conn := l.conn
+	l.conn = nil
+	l.leading = false
+	l.mu.Unlock()
+
+	if conn == nil {
+		return
+	}
+	conn.Conn().Close(context.Background())
+	conn.Release()
+}
@@ -0,0 +1,54 @@
+package cluster
+
+import (
+	"fmt"
+
+	"viacortex/internal/proxy"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NATSBus carries config and certificate events between nodes over NATS
+type NATSBus struct {
+	conn *nats.Conn
+}
+
+var _ proxy.Bus = (*NATSBus)(nil)
+
+// ConnectNATS connects to the NATS server at url, reconnecting for as long
+// as the node runs. Events published while disconnected are buffered by the
+// client; the periodic domain reload covers anything lost beyond that.
+func ConnectNATS(url, nodeID string) (*NATSBus, error) {
+	conn, err := nats.Connect(url,
+		nats.Name("viacortex "+nodeID),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				logger.Warn("Disconnected from NATS", "error", err)
+			}
+		}),
+		nats.ReconnectHandler(func(c *nats.Conn) {
+			logger.Info("Reconnected to NATS", "url", c.ConnectedUrl())
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to nats: %w", err)
+	}
+	return &NATSBus{conn: conn}, nil
+}
+
+func (b *NATSBus) Publish(subject string, data []byte) error {
+	return b.conn.Publish(subject, data)
+}
+
+func (b *NATSBus) Subscribe(subject string, handle func(data []byte)) error {
+	_, err := b.conn.Subscribe(subject, func(m *nats.Msg) {
+		handle(m.Data)
+	})
+	return err
+}
+
+// Close flushes pending events and disconnects
+func (b *NATSBus) Close() {
+	b.conn.Drain()
+}
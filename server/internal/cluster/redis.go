@@ -0,0 +1,300 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"viacortex/internal/proxy"
+
+	"github.com/caddyserver/certmagic"
+	"github.com/redis/go-redis/v9"
+)
+
+// redisPrefix namespaces every key ViaCortex writes, so the Redis instance
+// can be shared with other applications
+const redisPrefix = "viacortex:"
+
+// ConnectRedis connects to the Redis server at url, e.g.
+// redis://:password@host:6379/0
+func ConnectRedis(ctx context.Context, url string) (*redis.Client, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis url: %w", err)
+	}
+	client := redis.NewClient(opts)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("connecting to redis: %w", err)
+	}
+	return client, nil
+}
+
+// tokenBucket refills a bucket at rate tokens per second up to burst and
+// takes one token if there is one. Redis' clock is used so nodes with
+// skewed clocks agree.
+var tokenBucket = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = t[1] * 1000 + math.floor(t[2] / 1000)
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + (now - ts) * rate / 1000)
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tokens, 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / math.max(rate, 1) * 1000) + 1000)
+return allowed
+`)
+
+// RedisRateLimiter enforces rate limits across every node using the same
+// Redis server
+type RedisRateLimiter struct {
+	client *redis.Client
+}
+
+var _ proxy.RateLimiter = (*RedisRateLimiter)(nil)
+
+func NewRedisRateLimiter(client *redis.Client) *RedisRateLimiter {
+	return &RedisRateLimiter{client: client}
+}
+
+func (l *RedisRateLimiter) Allow(ctx context.Context, key string, limit *proxy.RateLimit) (bool, error) {
+	// The limit is part of the key, so changing it starts a fresh bucket
+	// just like the per-node limiters
+	bucket := fmt.Sprintf("%sratelimit:%d:%d:%s", redisPrefix, limit.RequestsPerSecond, limit.BurstSize, key)
+	allowed, err := tokenBucket.Run(ctx, l.client, []string{bucket}, limit.RequestsPerSecond, limit.BurstSize).Int()
+	if err != nil {
+		return false, err
+	}
+	return allowed == 1, nil
+}
+
+// unlockScript releases a lock only if this node still holds it
+var unlockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// refreshScript extends a lock only if this node still holds it
+var refreshScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// RedisCertStorage keeps certmagic's certificates, keys and ACME state in
+// Redis. Each key is a hash of its value and modification time; a sorted
+// set indexes the keys so directories can be listed.
+type RedisCertStorage struct {
+	client *redis.Client
+	holder string
+
+	mu    sync.Mutex
+	locks map[string]context.CancelFunc // held locks and their refreshers
+}
+
+var _ certmagic.Storage = (*RedisCertStorage)(nil)
+
+func NewRedisCertStorage(client *redis.Client, nodeID string) *RedisCertStorage {
+	return &RedisCertStorage{
+		client: client,
+		holder: nodeID,
+		locks:  make(map[string]context.CancelFunc),
+	}
+}
+
+const (
+	certKeyPrefix  = redisPrefix + "certs:"
+	certIndexKey   = redisPrefix + "certs-index"
+	certLockPrefix = redisPrefix + "certs-lock:"
+)
+
+func (s *RedisCertStorage) Store(ctx context.Context, key string, value []byte) error {
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, certKeyPrefix+key, "value", value, "modified", time.Now().UnixMilli())
+		pipe.ZAdd(ctx, certIndexKey, redis.Z{Member: key})
+		return nil
+	})
+	return err
+}
+
+func (s *RedisCertStorage) Load(ctx context.Context, key string) ([]byte, error) {
+	value, err := s.client.HGet(ctx, certKeyPrefix+key, "value").Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, fs.ErrNotExist
+	}
+	return value, err
+}
+
+// Delete removes a key, or every key below it when it names a directory
+func (s *RedisCertStorage) Delete(ctx context.Context, key string) error {
+	keys, err := s.below(ctx, key)
+	if err != nil {
+		return err
+	}
+	exists, err := s.client.Exists(ctx, certKeyPrefix+key).Result()
+	if err != nil {
+		return err
+	}
+	if exists > 0 {
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return fs.ErrNotExist
+	}
+
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, k := range keys {
+			pipe.Del(ctx, certKeyPrefix+k)
+			pipe.ZRem(ctx, certIndexKey, k)
+		}
+		return nil
+	})
+	return err
+}
+
+func (s *RedisCertStorage) Exists(ctx context.Context, key string) bool {
+	if n, err := s.client.Exists(ctx, certKeyPrefix+key).Result(); err == nil && n > 0 {
+		return true
+	}
+	keys, err := s.below(ctx, key)
+	return err == nil && len(keys) > 0
+}
+
+// List returns the keys directly below path, or all keys below it when
+// recursive is set. Directories are implied by the keys beneath them.
+func (s *RedisCertStorage) List(ctx context.Context, path string, recursive bool) ([]string, error) {
+	keys, err := s.below(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := ""
+	if path = strings.TrimSuffix(path, "/"); path != "" {
+		prefix = path + "/"
+	}
+	seen := make(map[string]bool)
+	var list []string
+	for _, key := range keys {
+		if !recursive {
+			if i := strings.IndexByte(key[len(prefix):], '/'); i >= 0 {
+				key = key[:len(prefix)+i]
+			}
+		}
+		if !seen[key] {
+			seen[key] = true
+			list = append(list, key)
+		}
+	}
+	if len(list) == 0 {
+		return nil, fs.ErrNotExist
+	}
+	return list, nil
+}
+
+func (s *RedisCertStorage) Stat(ctx context.Context, key string) (certmagic.KeyInfo, error) {
+	info := certmagic.KeyInfo{Key: key, IsTerminal: true}
+	fields, err := s.client.HMGet(ctx, certKeyPrefix+key, "value", "modified").Result()
+	if err != nil {
+		return info, err
+	}
+	if value, ok := fields[0].(string); ok {
+		info.Size = int64(len(value))
+		if modified, ok := fields[1].(string); ok {
+			ms, _ := strconv.ParseInt(modified, 10, 64)
+			info.Modified = time.UnixMilli(ms)
+		}
+		return info, nil
+	}
+	if s.Exists(ctx, key) {
+		return certmagic.KeyInfo{Key: key, IsTerminal: false}, nil
+	}
+	return info, fs.ErrNotExist
+}
+
+// below returns every key under the directory path
+func (s *RedisCertStorage) below(ctx context.Context, path string) ([]string, error) {
+	min, max := "-", "+"
+	if path = strings.TrimSuffix(path, "/"); path != "" {
+		min, max = "["+path+"/", "["+path+"/\xff"
+	}
+	return s.client.ZRangeByLex(ctx, certIndexKey, &redis.ZRangeBy{Min: min, Max: max}).Result()
+}
+
+// Lock blocks until this node holds the named lock. A lock whose holder
+// stopped refreshing it expires after lockTTL.
+func (s *RedisCertStorage) Lock(ctx context.Context, name string) error {
+	for {
+		ok, err := s.client.SetNX(ctx, certLockPrefix+name, s.holder, lockTTL).Result()
+		if err != nil {
+			return err
+		}
+		if ok {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(lockPoll):
+		}
+	}
+
+	refreshCtx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	if prev, ok := s.locks[name]; ok {
+		prev()
+	}
+	s.locks[name] = cancel
+	s.mu.Unlock()
+	go s.refresh(refreshCtx, name)
+	return nil
+}
+
+// refresh extends a held lock until it is released
+func (s *RedisCertStorage) refresh(ctx context.Context, name string) {
+	ticker := time.NewTicker(lockTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			refreshScript.Run(ctx, s.client, []string{certLockPrefix + name}, s.holder, lockTTL.Milliseconds())
+		}
+	}
+}
+
+func (s *RedisCertStorage) Unlock(ctx context.Context, name string) error {
+	s.mu.Lock()
+	if cancel, ok := s.locks[name]; ok {
+		cancel()
+		delete(s.locks, name)
+	}
+	s.mu.Unlock()
+
+	released, err := unlockScript.Run(ctx, s.client, []string{certLockPrefix + name}, s.holder).Int()
+	if err != nil {
+		return err
+	}
+	if released == 0 {
+		return errors.New("lock " + name + " is not held by this node")
+	}
+	return nil
+}
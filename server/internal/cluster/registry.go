@@ -0,0 +1,191 @@
+// Package cluster lets several ViaCortex nodes share one database. Each node
+// registers itself and reports its status on a heartbeat, and certificates
+// can be kept in the database so every node serves the same ones.
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"viacortex/internal/logging"
+	"viacortex/internal/reporting"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+var logger = logging.For("cluster")
+
+// expiryHeartbeats is how many heartbeats a node may miss before it is
+// shown as offline
+const expiryHeartbeats = 3
+
+// pruneAfter removes nodes that have been offline this long
+const pruneAfter = 7 * 24 * time.Hour
+
+// Node is a cluster member as last reported by its heartbeat
+type Node struct {
+	ID         string                 `json:"id"`
+	Hostname   string                 `json:"hostname"`
+	StartedAt  time.Time              `json:"started_at"`
+	LastSeenAt time.Time              `json:"last_seen_at"`
+	Online     bool                   `json:"online"`
+	Self       bool                   `json:"self"`
+	Status     map[string]interface{} `json:"status"`
+}
+
+// Registry keeps this node's entry in the cluster_nodes table up to date
+type Registry struct {
+	db        *pgxpool.Pool
+	id        string
+	hostname  string
+	startedAt time.Time
+	interval  time.Duration
+
+	mu     sync.RWMutex
+	status func() map[string]interface{}
+}
+
+// DefaultNodeID identifies the node when no ID is configured
+func DefaultNodeID() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return "viacortex"
+	}
+	return hostname
+}
+
+func NewRegistry(db *pgxpool.Pool, nodeID string, interval time.Duration) *Registry {
+	hostname, _ := os.Hostname()
+	return &Registry{
+		db:        db,
+		id:        nodeID,
+		hostname:  hostname,
+		startedAt: time.Now(),
+		interval:  interval,
+	}
+}
+
+// ID returns this node's ID
+func (r *Registry) ID() string {
+	return r.id
+}
+
+// SetStatus sets the function reporting this node's status on each
+// heartbeat
+func (r *Registry) SetStatus(fn func() map[string]interface{}) {
+	r.mu.Lock()
+	r.status = fn
+	r.mu.Unlock()
+}
+
+// Start registers the node and sends heartbeats until ctx is cancelled
+func (r *Registry) Start(ctx context.Context) {
+	if err := r.heartbeat(ctx); err != nil {
+		logger.Error("Registering cluster node failed", "node_id", r.id, "error", err)
+	}
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := r.heartbeat(ctx); err != nil && ctx.Err() == nil {
+					logger.Error("Sending cluster heartbeat failed", "error", err)
+					reporting.Error(err, map[string]string{"job": "cluster_heartbeat"})
+				}
+			}
+		}
+	}()
+}
+
+// Leave removes the node from the registry on a clean shutdown
+func (r *Registry) Leave(ctx context.Context) error {
+	_, err := r.db.Exec(ctx, "DELETE FROM cluster_nodes WHERE id = $1", r.id)
+	return err
+}
+
+func (r *Registry) heartbeat(ctx context.Context) error {
+	status := map[string]interface{}{}
+	r.mu.RLock()
+	if r.status != nil {
+		status = r.status()
+	}
+	r.mu.RUnlock()
+	status["resources"] = resources()
+
+	encoded, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.Exec(ctx, `
+		INSERT INTO cluster_nodes (id, hostname, started_at, last_seen_at, expires_at, status)
+		VALUES ($1, $2, $3, now(), now() + $4::interval, $5)
+		ON CONFLICT (id) DO UPDATE SET
+			hostname = EXCLUDED.hostname,
+			started_at = EXCLUDED.started_at,
+			last_seen_at = EXCLUDED.last_seen_at,
+			expires_at = EXCLUDED.expires_at,
+			status = EXCLUDED.status
+	`, r.id, r.hostname, r.startedAt, (expiryHeartbeats * r.interval).String(), encoded)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.Exec(ctx, "DELETE FROM cluster_nodes WHERE expires_at < now() - $1::interval", pruneAfter.String())
+	return err
+}
+
+// resources reports the node's process resource usage
+func resources() map[string]interface{} {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return map[string]interface{}{
+		"goroutines":     runtime.NumGoroutine(),
+		"cpus":           runtime.NumCPU(),
+		"heap_bytes":     mem.HeapAlloc,
+		"sys_bytes":      mem.Sys,
+		"gc_cycles":      mem.NumGC,
+		"gc_pause_total": time.Duration(mem.PauseTotalNs).String(),
+	}
+}
+
+// Nodes lists the cluster members, this node included
+func (r *Registry) Nodes(ctx context.Context) ([]Node, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT id, hostname, started_at, last_seen_at, expires_at > now(), status
+		FROM cluster_nodes
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	nodes := []Node{}
+	for rows.Next() {
+		var n Node
+		if err := rows.Scan(&n.ID, &n.Hostname, &n.StartedAt, &n.LastSeenAt, &n.Online, &n.Status); err != nil {
+			return nil, err
+		}
+		n.Self = n.ID == r.id
+		nodes = append(nodes, n)
+	}
+	return nodes, rows.Err()
+}
+
+// Forget removes an offline node. It reports false when the node does not
+// exist or is still online.
+func (r *Registry) Forget(ctx context.Context, nodeID string) (bool, error) {
+	tag, err := r.db.Exec(ctx, "DELETE FROM cluster_nodes WHERE id = $1 AND expires_at <= now()", nodeID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
@@ -0,0 +1,277 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// envNames lists every variable applyEnv reads
+var envNames = []string{
+	"DATABASE_URL", "DB_AUTO_MIGRATE", "ADMIN_ADDR", "GRPC_ADDR", "CORS_ORIGINS",
+	"HTTP_PORT", "HTTPS_PORT", "TCP_PORTS", "ACME_EMAIL", "STORAGE_DIR",
+	"DOMAIN_RELOAD_INTERVAL", "HEALTH_CHECK_INTERVAL",
+}
+
+// clearEnv keeps the environment of the test process out of the results;
+// an empty variable is the same as an unset one
+func clearEnv(t *testing.T) {
+	t.Helper()
+	for _, name := range envNames {
+		t.Setenv(name, "")
+	}
+}
+
+func TestDefaultIsValid(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		check   func(*Config) bool
+		wantErr string
+	}{
+		{
+			name:  "empty file keeps defaults",
+			yaml:  "",
+			check: func(c *Config) bool { return reflect.DeepEqual(c, Default()) },
+		},
+		{
+			name: "durations",
+			yaml: "domain_reload_interval: 5m\nhealth_check_interval: 10s\n",
+			check: func(c *Config) bool {
+				return c.DomainReloadInterval == Duration(5*time.Minute) && c.HealthCheckInterval == Duration(10*time.Second)
+			},
+		},
+		{
+			name:  "tcp_ports replaces the default map",
+			yaml:  "tcp_ports:\n  ssh: 2222\n",
+			check: func(c *Config) bool { return reflect.DeepEqual(c.TCPPorts, map[string]int{"ssh": 2222}) },
+		},
+		{
+			name:  "omitted tcp_ports keeps the default map",
+			yaml:  "http_port: 8000\n",
+			check: func(c *Config) bool { return c.HTTPPort == 8000 && c.TCPPorts["minecraft"] == 25565 },
+		},
+		{
+			name:  "grpc_addr",
+			yaml:  "grpc_addr: \":9090\"\n",
+			check: func(c *Config) bool { return c.GRPCAddr == ":9090" },
+		},
+		{
+			name:    "unknown key",
+			yaml:    "http_prot: 80\n",
+			wantErr: "field http_prot not found",
+		},
+		{
+			name:    "invalid duration",
+			yaml:    "health_check_interval: soon\n",
+			wantErr: `line 1: invalid duration "soon"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			err := cfg.decode([]byte(tt.yaml))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(cfg) {
+				t.Errorf("unexpected config %+v", cfg)
+			}
+		})
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		check   func(*Config) bool
+		wantErr string
+	}{
+		{
+			name: "strings",
+			env:  map[string]string{"ADMIN_ADDR": "unix:/run/viacortex.sock", "GRPC_ADDR": ":9090", "ACME_EMAIL": "ops@example.com"},
+			check: func(c *Config) bool {
+				return c.AdminAddr == "unix:/run/viacortex.sock" && c.GRPCAddr == ":9090" && c.ACMEEmail == "ops@example.com"
+			},
+		},
+		{
+			name: "lists and maps",
+			env:  map[string]string{"CORS_ORIGINS": " https://a.example , ,https://b.example", "TCP_PORTS": "ssh=2222, dns = 53"},
+			check: func(c *Config) bool {
+				return reflect.DeepEqual(c.CORSOrigins, []string{"https://a.example", "https://b.example"}) && reflect.DeepEqual(c.TCPPorts, map[string]int{"ssh": 2222, "dns": 53})
+			},
+		},
+		{
+			name:  "TCP_PORTS none",
+			env:   map[string]string{"TCP_PORTS": "none"},
+			check: func(c *Config) bool { return len(c.TCPPorts) == 0 },
+		},
+		{
+			name: "numbers, booleans and durations",
+			env:  map[string]string{"HTTP_PORT": "8080", "DB_AUTO_MIGRATE": "false", "DOMAIN_RELOAD_INTERVAL": "1m"},
+			check: func(c *Config) bool {
+				return c.HTTPPort == 8080 && !c.AutoMigrate && c.DomainReloadInterval == Duration(time.Minute)
+			},
+		},
+		{
+			name:    "port not a number",
+			env:     map[string]string{"HTTPS_PORT": "https"},
+			wantErr: `invalid HTTPS_PORT "https": not a number`,
+		},
+		{
+			name:    "boolean",
+			env:     map[string]string{"DB_AUTO_MIGRATE": "sometimes"},
+			wantErr: `invalid DB_AUTO_MIGRATE "sometimes"`,
+		},
+		{
+			name:    "TCP_PORTS without port",
+			env:     map[string]string{"TCP_PORTS": "ssh"},
+			wantErr: `invalid TCP_PORTS entry "ssh": use name=port`,
+		},
+		{
+			name:    "TCP_PORTS port not a number",
+			env:     map[string]string{"TCP_PORTS": "ssh=twenty-two"},
+			wantErr: `invalid TCP_PORTS entry "ssh=twenty-two": port is not a number`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			cfg := Default()
+			err := cfg.applyEnv()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(cfg) {
+				t.Errorf("unexpected config %+v", cfg)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		want   []string
+	}{
+		{
+			name:   "database URL",
+			modify: func(c *Config) { c.DatabaseURL = "mysql://localhost/viacortex" },
+			want:   []string{"database_url must be a postgres:// URL"},
+		},
+		{
+			name: "ports",
+			modify: func(c *Config) {
+				c.HTTPPort = 0
+				c.HTTPSPort = 70000
+				c.TCPPorts = map[string]int{"ssh": 443}
+			},
+			want: []string{
+				"http_port must be between 1 and 65535, got 0",
+				"https_port must be between 1 and 65535, got 70000",
+			},
+		},
+		{
+			name:   "port used twice",
+			modify: func(c *Config) { c.TCPPorts = map[string]int{"web": 80} },
+			want:   []string{"http_port and tcp_ports.web both use port 80"},
+		},
+		{
+			name: "every problem at once",
+			modify: func(c *Config) {
+				c.AdminAddr = ""
+				c.CORSOrigins = nil
+				c.ACMEEmail = "admin"
+				c.StorageDir = ""
+				c.HealthCheckInterval = Duration(time.Millisecond)
+			},
+			want: []string{
+				"admin_addr must not be empty",
+				"cors_origins must list at least one origin",
+				`acme_email "admin" is not an email address`,
+				"storage_dir must not be empty",
+				"health_check_interval must be at least 1s",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			tt.modify(cfg)
+			err := cfg.Validate()
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			want := "invalid configuration:\n  - " + strings.Join(tt.want, "\n  - ")
+			if err.Error() != want {
+				t.Errorf("got  %s\nwant %s", err, want)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	clearEnv(t)
+	path := filepath.Join(t.TempDir(), "viacortex.yaml")
+	if err := os.WriteFile(path, []byte("http_port: 8000\nhttps_port: 8443\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HTTPS_PORT", "9443")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.HTTPPort != 8000 || cfg.HTTPSPort != 9443 {
+		t.Errorf("ports = %d/%d, want 8000 from the file and 9443 from the environment", cfg.HTTPPort, cfg.HTTPSPort)
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); err == nil || !strings.HasPrefix(err.Error(), "reading config file") {
+		t.Errorf("missing file error = %v", err)
+	}
+}
+
+func TestPrintRedactsPassword(t *testing.T) {
+	cfg := Default()
+	cfg.DatabaseURL = "postgres://viacortex:s3cret@db:5432/viacortex"
+
+	var out strings.Builder
+	if err := cfg.Print(&out); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "s3cret") {
+		t.Errorf("password printed:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "database_url: postgres://viacortex:xxxxx@db:5432/viacortex") {
+		t.Errorf("database_url missing:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "health_check_interval: 30s") {
+		t.Errorf("durations not printed as strings:\n%s", out.String())
+	}
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// appName names the per-application directories
+const appName = "viacortex"
+
+// legacyStorageDir is where certificates were kept before storage_dir
+// followed platform conventions
+const legacyStorageDir = "/root/.local/share/certmagic"
+
+// DefaultStorageDir returns the platform's place for the server's data:
+// %ProgramData%\viacortex on Windows, ~/Library/Application Support on
+// macOS, and /var/lib/viacortex for root or $XDG_DATA_HOME/viacortex
+// otherwise on other systems. An existing legacy directory is kept.
+func DefaultStorageDir() string {
+	switch runtime.GOOS {
+	case "windows":
+		if dir := os.Getenv("ProgramData"); dir != "" {
+			return filepath.Join(dir, appName)
+		}
+	case "darwin":
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, "Library", "Application Support", appName)
+		}
+	default:
+		if os.Geteuid() == 0 {
+			if isDir(legacyStorageDir) {
+				return legacyStorageDir
+			}
+			return filepath.Join("/var/lib", appName)
+		}
+		if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+			return filepath.Join(dir, appName)
+		}
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, ".local", "share", appName)
+		}
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(dir, appName)
+	}
+	return appName + "-data"
+}
+
+// DefaultPath returns the config file used when none is given: the first
+// existing viacortex.yaml in the user's config directory or, on Unix, in
+// /etc/viacortex. It is empty when there is none.
+func DefaultPath() string {
+	var candidates []string
+	if dir, err := os.UserConfigDir(); err == nil {
+		candidates = append(candidates, filepath.Join(dir, appName, appName+".yaml"))
+	}
+	switch runtime.GOOS {
+	case "windows":
+		if dir := os.Getenv("ProgramData"); dir != "" {
+			candidates = append(candidates, filepath.Join(dir, appName, appName+".yaml"))
+		}
+	default:
+		candidates = append(candidates, filepath.Join("/etc", appName, appName+".yaml"))
+	}
+
+	for _, path := range candidates {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,181 @@
+// Package configdoc defines the portable document used to export and import
+// the proxy configuration (domains, backends, IP rules and rate limits).
+package configdoc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CurrentVersion is the document format version written by exports
+const CurrentVersion = 1
+
+type Document struct {
+	Version    int       `json:"version" yaml:"version"`
+	ExportedAt time.Time `json:"exported_at" yaml:"exported_at"`
+	Domains    []Domain  `json:"domains" yaml:"domains"`
+}
+
+type Domain struct {
+	Name                string      `json:"name" yaml:"name"`
+	TargetURL           string      `json:"target_url" yaml:"target_url"`
+	SSLEnabled          bool        `json:"ssl_enabled" yaml:"ssl_enabled"`
+	Disabled            bool        `json:"disabled,omitempty" yaml:"disabled,omitempty"`
+	HealthCheckEnabled  bool        `json:"health_check_enabled" yaml:"health_check_enabled"`
+	HealthCheckInterval int         `json:"health_check_interval" yaml:"health_check_interval"`
+	CustomErrorPages    interface{} `json:"custom_error_pages,omitempty" yaml:"custom_error_pages,omitempty"`
+	Backends            []Backend   `json:"backends" yaml:"backends"`
+	IPRules             []IPRule    `json:"ip_rules,omitempty" yaml:"ip_rules,omitempty"`
+	RateLimits          []RateLimit `json:"rate_limits,omitempty" yaml:"rate_limits,omitempty"`
+}
+
+type Backend struct {
+	Scheme   string `json:"scheme" yaml:"scheme"`
+	IP       string `json:"ip" yaml:"ip"`
+	Port     int    `json:"port" yaml:"port"`
+	Weight   int    `json:"weight" yaml:"weight"`
+	IsActive bool   `json:"is_active" yaml:"is_active"`
+}
+
+type IPRule struct {
+	IPRange     string `json:"ip_range" yaml:"ip_range"`
+	RuleType    string `json:"rule_type" yaml:"rule_type"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+type RateLimit struct {
+	RequestsPerSecond int  `json:"requests_per_second" yaml:"requests_per_second"`
+	BurstSize         int  `json:"burst_size" yaml:"burst_size"`
+	PerIP             bool `json:"per_ip" yaml:"per_ip"`
+}
+
+// Decode parses a document in the given format ("json" or "yaml")
+func Decode(data []byte, format string) (*Document, error) {
+	var doc Document
+	switch format {
+	case "yaml":
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("invalid yaml: %w", err)
+		}
+	case "json", "":
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("invalid json: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+	return &doc, nil
+}
+
+// Encode renders the document in the given format ("json", "yaml", "caddy"
+// or "nginx"). Only json and yaml can be decoded again.
+func Encode(doc *Document, format string) ([]byte, error) {
+	switch format {
+	case "yaml":
+		return yaml.Marshal(doc)
+	case "caddy", "caddyfile":
+		return RenderCaddyfile(doc), nil
+	case "nginx":
+		return RenderNginx(doc), nil
+	case "json", "":
+		return json.MarshalIndent(doc, "", "  ")
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+// CustomErrorPagesJSON returns the domain's custom error pages as JSON for
+// storage, or nil when none are set
+func (d *Domain) CustomErrorPagesJSON() (json.RawMessage, error) {
+	if d.CustomErrorPages == nil {
+		return nil, nil
+	}
+	return json.Marshal(normalizeYAML(d.CustomErrorPages))
+}
+
+// Validate checks the whole document and returns every problem found
+func (doc *Document) Validate() []string {
+	var problems []string
+	if doc.Version != CurrentVersion {
+		problems = append(problems, fmt.Sprintf("unsupported version %d (expected %d)", doc.Version, CurrentVersion))
+	}
+
+	seen := map[string]bool{}
+	for i, d := range doc.Domains {
+		where := fmt.Sprintf("domains[%d]", i)
+		if strings.TrimSpace(d.Name) == "" {
+			problems = append(problems, where+": name is required")
+		} else if seen[d.Name] {
+			problems = append(problems, fmt.Sprintf("%s: duplicate domain name %q", where, d.Name))
+		}
+		seen[d.Name] = true
+
+		if strings.TrimSpace(d.TargetURL) == "" {
+			problems = append(problems, where+": target_url is required")
+		}
+		if d.HealthCheckInterval < 0 {
+			problems = append(problems, where+": health_check_interval must not be negative")
+		}
+
+		for j, b := range d.Backends {
+			bwhere := fmt.Sprintf("%s.backends[%d]", where, j)
+			if b.Scheme != "http" && b.Scheme != "https" && b.Scheme != "tcp" {
+				problems = append(problems, fmt.Sprintf("%s: invalid scheme %q", bwhere, b.Scheme))
+			}
+			if net.ParseIP(b.IP) == nil {
+				problems = append(problems, fmt.Sprintf("%s: invalid ip %q", bwhere, b.IP))
+			}
+			if b.Port < 1 || b.Port > 65535 {
+				problems = append(problems, fmt.Sprintf("%s: invalid port %d", bwhere, b.Port))
+			}
+		}
+
+		for j, rule := range d.IPRules {
+			rwhere := fmt.Sprintf("%s.ip_rules[%d]", where, j)
+			if _, _, err := net.ParseCIDR(rule.IPRange); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: invalid ip_range %q", rwhere, rule.IPRange))
+			}
+			if rule.RuleType != "whitelist" && rule.RuleType != "blacklist" {
+				problems = append(problems, fmt.Sprintf("%s: invalid rule_type %q", rwhere, rule.RuleType))
+			}
+		}
+
+		for j, limit := range d.RateLimits {
+			if limit.RequestsPerSecond <= 0 || limit.BurstSize <= 0 {
+				problems = append(problems, fmt.Sprintf("%s.rate_limits[%d]: requests_per_second and burst_size must be positive", where, j))
+			}
+		}
+	}
+
+	return problems
+}
+
+// normalizeYAML converts the map[string]interface{} / map[interface{}]interface{}
+// trees produced by YAML decoding into JSON encodable values
+func normalizeYAML(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			m[fmt.Sprint(k)] = normalizeYAML(val)
+		}
+		return m
+	case map[string]interface{}:
+		for k, val := range t {
+			t[k] = normalizeYAML(val)
+		}
+		return t
+	case []interface{}:
+		for i, val := range t {
+			t[i] = normalizeYAML(val)
+		}
+		return t
+	default:
+		return v
+	}
+}
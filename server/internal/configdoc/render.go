@@ -0,0 +1,211 @@
+package configdoc
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var unsafeIdent = regexp.MustCompile(`[^a-zA-Z0-9_]+`)
+
+// siteHost returns the host a domain is served under, taken from its target
+// URL the same way the proxy does
+func siteHost(d *Domain) (scheme, host string) {
+	for _, prefix := range []string{"tcp://", "https://", "http://"} {
+		if strings.HasPrefix(d.TargetURL, prefix) {
+			return strings.TrimSuffix(prefix, "://"), strings.TrimPrefix(d.TargetURL, prefix)
+		}
+	}
+	return "", d.TargetURL
+}
+
+// backendAddr renders a backend as scheme://ip:port
+func backendAddr(b Backend) string {
+	return b.Scheme + "://" + net.JoinHostPort(b.IP, strconv.Itoa(b.Port))
+}
+
+// splitIPRules groups a domain's CIDRs by rule type
+func splitIPRules(rules []IPRule) (allow, deny []string) {
+	for _, rule := range rules {
+		if rule.RuleType == "whitelist" {
+			allow = append(allow, rule.IPRange)
+		} else {
+			deny = append(deny, rule.IPRange)
+		}
+	}
+	return allow, deny
+}
+
+// RenderCaddyfile renders the document as an equivalent Caddyfile. Settings
+// without a stock Caddy equivalent (rate limits, TCP domains) are written as
+// comments so the output can still be reviewed.
+func RenderCaddyfile(doc *Document) []byte {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# Generated by ViaCortex on %s\n", doc.ExportedAt.Format("2006-01-02 15:04:05 MST"))
+
+	for i := range doc.Domains {
+		d := &doc.Domains[i]
+		scheme, host := siteHost(d)
+		sb.WriteString("\n# " + d.Name + "\n")
+
+		if scheme == "tcp" {
+			sb.WriteString("# TCP domain " + host + " requires the layer4 plugin and is not rendered\n")
+			continue
+		}
+
+		addr := host
+		if !d.SSLEnabled {
+			addr = "http://" + host
+		}
+		sb.WriteString(addr + " {\n")
+
+		if d.Disabled {
+			sb.WriteString("\trespond \"Site temporarily unavailable\" 503\n")
+			sb.WriteString("}\n")
+			continue
+		}
+
+		allow, deny := splitIPRules(d.IPRules)
+		if len(deny) > 0 {
+			sb.WriteString("\t@denied remote_ip " + strings.Join(deny, " ") + "\n")
+			sb.WriteString("\tabort @denied\n")
+		}
+		if len(allow) > 0 {
+			sb.WriteString("\t@not_allowed not remote_ip " + strings.Join(allow, " ") + "\n")
+			sb.WriteString("\tabort @not_allowed\n")
+		}
+		for _, limit := range d.RateLimits {
+			fmt.Fprintf(&sb, "\t# rate limit: %d req/s, burst %d, per_ip=%t (needs a rate limit plugin)\n",
+				limit.RequestsPerSecond, limit.BurstSize, limit.PerIP)
+		}
+
+		var upstreams []string
+		var weights []string
+		for _, b := range d.Backends {
+			if !b.IsActive {
+				sb.WriteString("\t# inactive backend " + backendAddr(b) + "\n")
+				continue
+			}
+			upstreams = append(upstreams, backendAddr(b))
+			weights = append(weights, strconv.Itoa(b.Weight))
+		}
+
+		if len(upstreams) == 0 {
+			sb.WriteString("\trespond 503\n")
+		} else {
+			sb.WriteString("\treverse_proxy " + strings.Join(upstreams, " ") + " {\n")
+			if len(upstreams) > 1 {
+				sb.WriteString("\t\tlb_policy weighted_round_robin " + strings.Join(weights, " ") + "\n")
+			}
+			if d.HealthCheckEnabled && d.HealthCheckInterval > 0 {
+				sb.WriteString("\t\thealth_uri /\n")
+				fmt.Fprintf(&sb, "\t\thealth_interval %ds\n", d.HealthCheckInterval)
+			}
+			sb.WriteString("\t}\n")
+		}
+		sb.WriteString("}\n")
+	}
+
+	return []byte(sb.String())
+}
+
+// RenderNginx renders the document as an equivalent nginx http context
+// include. Certificates are referenced by conventional paths that have to be
+// provisioned separately; active health checks have no open source nginx
+// equivalent and are written as comments.
+func RenderNginx(doc *Document) []byte {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# Generated by ViaCortex on %s\n", doc.ExportedAt.Format("2006-01-02 15:04:05 MST"))
+
+	for i := range doc.Domains {
+		d := &doc.Domains[i]
+		scheme, host := siteHost(d)
+		ident := "vc_" + strings.Trim(unsafeIdent.ReplaceAllString(d.Name, "_"), "_")
+
+		sb.WriteString("\n# " + d.Name + "\n")
+		if scheme == "tcp" {
+			sb.WriteString("# TCP domain " + host + " belongs in a stream {} block and is not rendered\n")
+			continue
+		}
+
+		for j, limit := range d.RateLimits {
+			key := "$binary_remote_addr"
+			if !limit.PerIP {
+				key = "$server_name"
+			}
+			fmt.Fprintf(&sb, "limit_req_zone %s zone=%s_%d:10m rate=%dr/s;\n", key, ident, j, limit.RequestsPerSecond)
+		}
+
+		upstreamScheme := "http"
+		active := 0
+		sb.WriteString("upstream " + ident + " {\n")
+		for _, b := range d.Backends {
+			line := fmt.Sprintf("server %s weight=%d;", net.JoinHostPort(b.IP, strconv.Itoa(b.Port)), b.Weight)
+			if !b.IsActive {
+				sb.WriteString("    # " + line + " (inactive)\n")
+				continue
+			}
+			if b.Scheme == "https" {
+				upstreamScheme = "https"
+			}
+			active++
+			sb.WriteString("    " + line + "\n")
+		}
+		if active == 0 {
+			sb.WriteString("    server 127.0.0.1:1 down;\n")
+		}
+		if d.HealthCheckEnabled && d.HealthCheckInterval > 0 {
+			fmt.Fprintf(&sb, "    # active health check every %ds is not available in open source nginx\n", d.HealthCheckInterval)
+		}
+		sb.WriteString("}\n\n")
+
+		sb.WriteString("server {\n")
+		if d.SSLEnabled {
+			sb.WriteString("    listen 443 ssl;\n")
+			sb.WriteString("    ssl_certificate /etc/ssl/" + host + "/fullchain.pem;\n")
+			sb.WriteString("    ssl_certificate_key /etc/ssl/" + host + "/privkey.pem;\n")
+		} else {
+			sb.WriteString("    listen 80;\n")
+		}
+		sb.WriteString("    server_name " + host + ";\n")
+
+		if d.Disabled {
+			sb.WriteString("    return 503;\n")
+		}
+
+		allow, deny := splitIPRules(d.IPRules)
+		for _, cidr := range deny {
+			sb.WriteString("    deny " + cidr + ";\n")
+		}
+		for _, cidr := range allow {
+			sb.WriteString("    allow " + cidr + ";\n")
+		}
+		if len(allow) > 0 {
+			sb.WriteString("    deny all;\n")
+		}
+		for j, limit := range d.RateLimits {
+			fmt.Fprintf(&sb, "    limit_req zone=%s_%d burst=%d nodelay;\n", ident, j, limit.BurstSize)
+		}
+
+		sb.WriteString("\n    location / {\n")
+		sb.WriteString("        proxy_pass " + upstreamScheme + "://" + ident + ";\n")
+		sb.WriteString("        proxy_set_header Host $host;\n")
+		sb.WriteString("        proxy_set_header X-Real-IP $remote_addr;\n")
+		sb.WriteString("        proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;\n")
+		sb.WriteString("        proxy_set_header X-Forwarded-Proto $scheme;\n")
+		sb.WriteString("    }\n")
+		sb.WriteString("}\n")
+
+		if d.SSLEnabled {
+			sb.WriteString("\nserver {\n")
+			sb.WriteString("    listen 80;\n")
+			sb.WriteString("    server_name " + host + ";\n")
+			sb.WriteString("    return 301 https://$host$request_uri;\n")
+			sb.WriteString("}\n")
+		}
+	}
+
+	return []byte(sb.String())
+}
@@ -0,0 +1,458 @@
+package configdoc
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Resolver turns a backend host name into an IP address. Backends are stored
+// by IP, so names found in imported configs have to be resolved.
+type Resolver func(host string) (net.IP, error)
+
+// directive is one statement of a brace structured config file together
+// with its nested block, if any
+type directive struct {
+	name  string
+	args  []string
+	block []*directive
+	line  int
+}
+
+type token struct {
+	text string
+	line int
+}
+
+// placeholderEnd returns the index of the brace closing the placeholder that
+// opens at runes[i], such as Caddy's {host} or {http.request.uri}, or -1 when
+// the brace at i opens a block instead. A placeholder is non-empty and holds
+// no whitespace, braces, semicolons or quotes.
+func placeholderEnd(runes []rune, i int) int {
+	for j := i + 1; j < len(runes); j++ {
+		switch r := runes[j]; {
+		case r == '}':
+			if j == i+1 {
+				return -1
+			}
+			return j
+		case unicode.IsSpace(r) || r == '{' || r == ';' || r == '"' || r == '\'':
+			return -1
+		}
+	}
+	return -1
+}
+
+// tokenize splits brace structured config text into words, treating braces
+// and semicolons as separate tokens and dropping # comments. Placeholders
+// inside or at the start of a word stay part of that word.
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	line := 1
+	runes := []rune(src)
+
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case c == '\n':
+			line++
+		case unicode.IsSpace(c):
+		case c == '#':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+			i--
+		case (c == '{' && placeholderEnd(runes, i) < 0) || c == '}' || c == ';':
+			tokens = append(tokens, token{text: string(c), line: line})
+		case c == '"' || c == '\'':
+			start := line
+			var sb strings.Builder
+			i++
+			for ; i < len(runes) && runes[i] != c; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				if runes[i] == '\n' {
+					line++
+				}
+				sb.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("line %d: unterminated quoted string", start)
+			}
+			tokens = append(tokens, token{text: sb.String(), line: start})
+		default:
+			var sb strings.Builder
+			for ; i < len(runes); i++ {
+				r := runes[i]
+				if r == '{' {
+					if end := placeholderEnd(runes, i); end >= 0 {
+						sb.WriteString(string(runes[i : end+1]))
+						i = end
+						continue
+					}
+				}
+				if unicode.IsSpace(r) || r == '{' || r == '}' || r == ';' || r == '"' || r == '\'' {
+					break
+				}
+				sb.WriteRune(r)
+			}
+			i--
+			tokens = append(tokens, token{text: sb.String(), line: line})
+		}
+	}
+
+	return tokens, nil
+}
+
+// parseBlocks builds a directive tree. With semicolons set a directive ends at
+// ";" (nginx); otherwise it ends at the end of its line (Caddyfile).
+func parseBlocks(tokens []token, semicolons bool) ([]*directive, error) {
+	pos := 0
+	var parse func(depth int) ([]*directive, error)
+	parse = func(depth int) ([]*directive, error) {
+		var out []*directive
+		for pos < len(tokens) {
+			t := tokens[pos]
+			switch t.text {
+			case "}":
+				if depth == 0 {
+					return nil, fmt.Errorf("line %d: unexpected }", t.line)
+				}
+				pos++
+				return out, nil
+			case ";":
+				pos++
+				continue
+			}
+
+			d := &directive{line: t.line}
+			if t.text != "{" {
+				d.name = t.text
+				pos++
+			}
+			for pos < len(tokens) {
+				next := tokens[pos]
+				if next.text == "{" {
+					pos++
+					block, err := parse(depth + 1)
+					if err != nil {
+						return nil, err
+					}
+					d.block = block
+					break
+				}
+				if next.text == "}" || next.text == ";" {
+					if next.text == ";" {
+						pos++
+					}
+					break
+				}
+				if !semicolons && next.line != d.line {
+					break
+				}
+				d.args = append(d.args, next.text)
+				pos++
+			}
+			out = append(out, d)
+		}
+		if depth > 0 {
+			return nil, fmt.Errorf("unexpected end of input, missing }")
+		}
+		return out, nil
+	}
+	return parse(0)
+}
+
+// ImportNginx converts the server blocks of an nginx config into a document.
+// It returns warnings for constructs it could not translate.
+func ImportNginx(src string, resolve Resolver) (*Document, []string, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	tree, err := parseBlocks(tokens, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Server and upstream blocks may sit at the top level or inside http {}
+	var servers []*directive
+	upstreams := map[string]*directive{}
+	var collect func(ds []*directive)
+	collect = func(ds []*directive) {
+		for _, d := range ds {
+			switch d.name {
+			case "http":
+				collect(d.block)
+			case "server":
+				if d.block != nil {
+					servers = append(servers, d)
+				}
+			case "upstream":
+				if len(d.args) > 0 {
+					upstreams[d.args[0]] = d
+				}
+			}
+		}
+	}
+	collect(tree)
+
+	doc := newImportDocument()
+	var warnings []string
+
+	for _, server := range servers {
+		var names []string
+		ssl := false
+		var proxyPass string
+
+		for _, d := range server.block {
+			switch d.name {
+			case "server_name":
+				for _, n := range d.args {
+					if n != "_" && n != "" {
+						names = append(names, n)
+					}
+				}
+			case "listen":
+				for _, a := range d.args {
+					if a == "ssl" || strings.HasSuffix(a, ":443") || a == "443" {
+						ssl = true
+					}
+				}
+			case "location":
+				if len(d.args) > 0 && d.args[len(d.args)-1] == "/" {
+					for _, ld := range d.block {
+						if ld.name == "proxy_pass" && len(ld.args) > 0 {
+							proxyPass = ld.args[0]
+						}
+					}
+				}
+			}
+		}
+
+		if len(names) == 0 {
+			warnings = append(warnings, fmt.Sprintf("line %d: server block without server_name skipped", server.line))
+			continue
+		}
+		if proxyPass == "" {
+			warnings = append(warnings, fmt.Sprintf("line %d: server %s has no proxy_pass in location /, skipped", server.line, names[0]))
+			continue
+		}
+
+		u, err := url.Parse(proxyPass)
+		if err != nil || u.Host == "" {
+			warnings = append(warnings, fmt.Sprintf("line %d: unsupported proxy_pass %q", server.line, proxyPass))
+			continue
+		}
+
+		var backends []Backend
+		if upstream, ok := upstreams[u.Hostname()]; ok && u.Port() == "" {
+			for _, d := range upstream.block {
+				if d.name != "server" || len(d.args) == 0 {
+					continue
+				}
+				weight := 1
+				for _, opt := range d.args[1:] {
+					if strings.HasPrefix(opt, "weight=") {
+						if n, err := strconv.Atoi(strings.TrimPrefix(opt, "weight=")); err == nil {
+							weight = n
+						}
+					}
+				}
+				b, warn := resolveBackend(u.Scheme, d.args[0], weight, resolve)
+				if warn != "" {
+					warnings = append(warnings, fmt.Sprintf("line %d: %s", d.line, warn))
+					continue
+				}
+				backends = append(backends, b)
+			}
+		} else {
+			b, warn := resolveBackend(u.Scheme, u.Host, 1, resolve)
+			if warn != "" {
+				warnings = append(warnings, fmt.Sprintf("line %d: %s", server.line, warn))
+				continue
+			}
+			backends = append(backends, b)
+		}
+
+		doc.addSite(names, ssl, backends)
+	}
+
+	return doc, warnings, nil
+}
+
+// ImportCaddyfile converts the site blocks of a Caddyfile that use
+// reverse_proxy into a document. It returns warnings for constructs it could
+// not translate.
+func ImportCaddyfile(src string, resolve Resolver) (*Document, []string, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	tree, err := parseBlocks(tokens, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	doc := newImportDocument()
+	var warnings []string
+
+	for _, site := range tree {
+		// Global options block and snippets carry no sites
+		if site.name == "" || strings.HasPrefix(site.name, "(") {
+			continue
+		}
+
+		ssl := true
+		var names []string
+		for _, addr := range append([]string{site.name}, site.args...) {
+			for _, a := range strings.Split(addr, ",") {
+				a = strings.TrimSpace(a)
+				if a == "" {
+					continue
+				}
+				if strings.HasPrefix(a, "http://") || strings.HasSuffix(a, ":80") {
+					ssl = false
+				}
+				host := strings.TrimPrefix(strings.TrimPrefix(a, "https://"), "http://")
+				if h, _, err := net.SplitHostPort(host); err == nil {
+					host = h
+				}
+				if host != "" {
+					names = append(names, host)
+				}
+			}
+		}
+		if len(names) == 0 {
+			continue
+		}
+
+		var backends []Backend
+		for _, d := range site.block {
+			switch d.name {
+			case "tls":
+				if len(d.args) > 0 && d.args[0] == "off" {
+					ssl = false
+				}
+			case "reverse_proxy":
+				upstreams := d.args
+				if len(upstreams) > 0 && strings.HasPrefix(upstreams[0], "/") {
+					// Path matcher, only the catch-all route is imported
+					if upstreams[0] != "/*" && upstreams[0] != "*" {
+						warnings = append(warnings, fmt.Sprintf("line %d: reverse_proxy with matcher %s skipped", d.line, upstreams[0]))
+						continue
+					}
+					upstreams = upstreams[1:]
+				}
+				for _, sub := range d.block {
+					if sub.name == "to" {
+						upstreams = append(upstreams, sub.args...)
+					}
+				}
+				for _, upstream := range upstreams {
+					scheme := "http"
+					if i := strings.Index(upstream, "://"); i >= 0 {
+						scheme = upstream[:i]
+						upstream = upstream[i+3:]
+					}
+					b, warn := resolveBackend(scheme, upstream, 1, resolve)
+					if warn != "" {
+						warnings = append(warnings, fmt.Sprintf("line %d: %s", d.line, warn))
+						continue
+					}
+					backends = append(backends, b)
+				}
+			}
+		}
+
+		if len(backends) == 0 {
+			warnings = append(warnings, fmt.Sprintf("line %d: site %s has no usable reverse_proxy, skipped", site.line, names[0]))
+			continue
+		}
+
+		doc.addSite(names, ssl, backends)
+	}
+
+	return doc, warnings, nil
+}
+
+func newImportDocument() *Document {
+	return &Document{
+		Version:    CurrentVersion,
+		ExportedAt: time.Now().UTC(),
+		Domains:    []Domain{},
+	}
+}
+
+// addSite adds one domain per host name, all sharing the same backends
+func (doc *Document) addSite(names []string, ssl bool, backends []Backend) {
+	scheme := "http://"
+	if ssl {
+		scheme = "https://"
+	}
+	for _, name := range names {
+		doc.Domains = append(doc.Domains, Domain{
+			Name:                name,
+			TargetURL:           scheme + name,
+			SSLEnabled:          ssl,
+			HealthCheckEnabled:  true,
+			HealthCheckInterval: 60,
+			Backends:            backends,
+		})
+	}
+}
+
+// resolveBackend turns "host[:port]" into a backend, resolving host names.
+// It returns a warning instead when the address cannot be used.
+func resolveBackend(scheme, hostport string, weight int, resolve Resolver) (Backend, string) {
+	switch scheme {
+	case "http", "https":
+	case "h2c", "":
+		scheme = "http"
+	default:
+		return Backend{}, fmt.Sprintf("unsupported upstream scheme %q", scheme)
+	}
+	if strings.HasPrefix(hostport, "unix:") || strings.HasPrefix(hostport, "unix/") {
+		return Backend{}, fmt.Sprintf("unix socket upstream %s is not supported", hostport)
+	}
+
+	host, portStr, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+		portStr = "80"
+		if scheme == "https" {
+			portStr = "443"
+		}
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return Backend{}, fmt.Sprintf("invalid upstream port in %s", hostport)
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		if resolve == nil {
+			return Backend{}, fmt.Sprintf("upstream host %s is not an IP address", host)
+		}
+		ip, err = resolve(host)
+		if err != nil {
+			return Backend{}, fmt.Sprintf("could not resolve upstream %s: %v", host, err)
+		}
+	}
+
+	if weight < 1 {
+		weight = 1
+	}
+	return Backend{
+		Scheme:   scheme,
+		IP:       ip.String(),
+		Port:     port,
+		Weight:   weight,
+		IsActive: true,
+	}, ""
+}
@@ -0,0 +1,305 @@
+package configdoc
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		want    []string
+		wantErr string
+	}{
+		{"words and semicolons", "listen 443 ssl;", []string{"listen", "443", "ssl", ";"}, ""},
+		{"block braces", "server {\n}", []string{"server", "{", "}"}, ""},
+		{"brace without space", "server{listen 80;}", []string{"server", "{", "listen", "80", ";", "}"}, ""},
+		{"empty block", "location / {}", []string{"location", "/", "{", "}"}, ""},
+		{"comment", "listen 80; # plain http\nlisten 443;", []string{"listen", "80", ";", "listen", "443", ";"}, ""},
+		{"quoted", `add_header X-Note "a b;{c}";`, []string{"add_header", "X-Note", "a b;{c}", ";"}, ""},
+		{"escaped quote", `return 200 'it\'s';`, []string{"return", "200", "it's", ";"}, ""},
+		{"placeholder word", "header_up Host {host}", []string{"header_up", "Host", "{host}"}, ""},
+		{"placeholder in word", "redir https://{host}{uri} permanent", []string{"redir", "https://{host}{uri}", "permanent"}, ""},
+		{"dotted placeholder", "rewrite * /app{http.request.uri}", []string{"rewrite", "*", "/app{http.request.uri}"}, ""},
+		{"env placeholder", "reverse_proxy {$UPSTREAM}", []string{"reverse_proxy", "{$UPSTREAM}"}, ""},
+		{"placeholder before block", "reverse_proxy {$UPSTREAM} {\n}", []string{"reverse_proxy", "{$UPSTREAM}", "{", "}"}, ""},
+		{"unterminated quote", "server_name \"example.com;", nil, "line 1: unterminated quoted string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := tokenize(tt.src)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, tok := range tokens {
+				got = append(got, tok.text)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got  %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// outline renders a directive tree as name(args){children} for comparison
+func outline(ds []*directive) string {
+	var parts []string
+	for _, d := range ds {
+		s := d.name
+		if len(d.args) > 0 {
+			s += "(" + strings.Join(d.args, " ") + ")"
+		}
+		if d.block != nil {
+			s += "{" + outline(d.block) + "}"
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestParseBlocks(t *testing.T) {
+	tests := []struct {
+		name       string
+		src        string
+		semicolons bool
+		want       string
+		wantErr    string
+	}{
+		{
+			name:       "nginx statements end at semicolons",
+			src:        "server {\n  server_name a.example\n    b.example;\n  location / { proxy_pass http://app; }\n}",
+			semicolons: true,
+			want:       "server{server_name(a.example b.example) location(/){proxy_pass(http://app)}}",
+		},
+		{
+			name: "Caddyfile statements end at the line",
+			src:  "example.com {\n  encode gzip\n  reverse_proxy 10.0.0.1:8080\n}",
+			want: "example.com{encode(gzip) reverse_proxy(10.0.0.1:8080)}",
+		},
+		{
+			name: "Caddyfile global options",
+			src:  "{\n  email admin@example.com\n}\nexample.com {\n  respond ok\n}",
+			want: "{email(admin@example.com)} example.com{respond(ok)}",
+		},
+		{
+			name: "Caddyfile placeholders are arguments",
+			src:  "example.com {\n  reverse_proxy 10.0.0.1:8080 {\n    header_up Host {host}\n  }\n  redir /old https://{host}{uri}\n}",
+			want: "example.com{reverse_proxy(10.0.0.1:8080){header_up(Host {host})} redir(/old https://{host}{uri})}",
+		},
+		{
+			name:       "unexpected close",
+			src:        "server { }\n}",
+			semicolons: true,
+			wantErr:    "line 2: unexpected }",
+		},
+		{
+			name:    "missing close",
+			src:     "example.com {\n  reverse_proxy 10.0.0.1",
+			wantErr: "unexpected end of input, missing }",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := tokenize(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tree, err := parseBlocks(tokens, tt.semicolons)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := outline(tree); got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func testResolver(host string) (net.IP, error) {
+	if host == "app.internal" {
+		return net.ParseIP("10.1.0.7"), nil
+	}
+	return nil, fmt.Errorf("no such host")
+}
+
+func site(name string, ssl bool, backends ...Backend) Domain {
+	scheme := "http://"
+	if ssl {
+		scheme = "https://"
+	}
+	return Domain{
+		Name:                name,
+		TargetURL:           scheme + name,
+		SSLEnabled:          ssl,
+		HealthCheckEnabled:  true,
+		HealthCheckInterval: 60,
+		Backends:            backends,
+	}
+}
+
+func backend(scheme, ip string, port, weight int) Backend {
+	return Backend{Scheme: scheme, IP: ip, Port: port, Weight: weight, IsActive: true}
+}
+
+func TestImportNginx(t *testing.T) {
+	tests := []struct {
+		name         string
+		src          string
+		want         []Domain
+		wantWarnings []string
+	}{
+		{
+			name: "proxy_pass to an address",
+			src: `server {
+				listen 443 ssl;
+				server_name example.com www.example.com;
+				location / { proxy_pass http://10.0.0.1:8080; }
+			}`,
+			want: []Domain{
+				site("example.com", true, backend("http", "10.0.0.1", 8080, 1)),
+				site("www.example.com", true, backend("http", "10.0.0.1", 8080, 1)),
+			},
+		},
+		{
+			name: "upstream inside http",
+			src: `http {
+				upstream app {
+					server 10.0.0.1:8080 weight=3;
+					server app.internal max_fails=2;
+				}
+				server {
+					listen 80;
+					server_name app.example.com;
+					location / { proxy_pass https://app; }
+				}
+			}`,
+			want: []Domain{
+				site("app.example.com", false, backend("https", "10.0.0.1", 8080, 3), backend("https", "10.1.0.7", 443, 1)),
+			},
+		},
+		{
+			name: "skipped servers",
+			src: `server {
+				server_name _;
+				location / { proxy_pass http://10.0.0.1; }
+			}
+			server {
+				server_name static.example.com;
+				location / { root /var/www; }
+			}
+			server {
+				server_name gone.example.com;
+				location / { proxy_pass http://missing.internal; }
+			}`,
+			want: []Domain{},
+			wantWarnings: []string{
+				"line 1: server block without server_name skipped",
+				"line 5: server static.example.com has no proxy_pass in location /, skipped",
+				"line 9: could not resolve upstream missing.internal: no such host",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, warnings, err := ImportNginx(tt.src, testResolver)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(doc.Domains, tt.want) {
+				t.Errorf("domains:\ngot  %+v\nwant %+v", doc.Domains, tt.want)
+			}
+			if !reflect.DeepEqual(warnings, tt.wantWarnings) {
+				t.Errorf("warnings:\ngot  %q\nwant %q", warnings, tt.wantWarnings)
+			}
+		})
+	}
+}
+
+func TestImportCaddyfile(t *testing.T) {
+	tests := []struct {
+		name         string
+		src          string
+		want         []Domain
+		wantWarnings []string
+	}{
+		{
+			name: "sites and global options",
+			src: `{
+				email admin@example.com
+			}
+			example.com, www.example.com {
+				reverse_proxy 10.0.0.1:8080 10.0.0.2:8080
+			}
+			http://plain.example.com {
+				reverse_proxy /* h2c://app.internal:9000
+			}`,
+			want: []Domain{
+				site("example.com", true, backend("http", "10.0.0.1", 8080, 1), backend("http", "10.0.0.2", 8080, 1)),
+				site("www.example.com", true, backend("http", "10.0.0.1", 8080, 1), backend("http", "10.0.0.2", 8080, 1)),
+				site("plain.example.com", false, backend("http", "10.1.0.7", 9000, 1)),
+			},
+		},
+		{
+			name: "placeholders and upstream block",
+			src: `api.example.com {
+				tls off
+				redir /docs https://{host}{uri}
+				reverse_proxy {
+					to https://10.0.0.3
+					header_up Host {host}
+					header_up X-Path {http.request.uri}
+				}
+			}`,
+			want: []Domain{
+				site("api.example.com", false, backend("https", "10.0.0.3", 443, 1)),
+			},
+		},
+		{
+			name: "skipped routes and sites",
+			src: `(common) {
+				encode gzip
+			}
+			example.com {
+				reverse_proxy /api/* 10.0.0.1:8080
+				reverse_proxy unix//run/app.sock
+			}`,
+			want: []Domain{},
+			wantWarnings: []string{
+				"line 5: reverse_proxy with matcher /api/* skipped",
+				"line 6: unix socket upstream unix//run/app.sock is not supported",
+				"line 4: site example.com has no usable reverse_proxy, skipped",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, warnings, err := ImportCaddyfile(tt.src, testResolver)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(doc.Domains, tt.want) {
+				t.Errorf("domains:\ngot  %+v\nwant %+v", doc.Domains, tt.want)
+			}
+			if !reflect.DeepEqual(warnings, tt.wantWarnings) {
+				t.Errorf("warnings:\ngot  %q\nwant %q", warnings, tt.wantWarnings)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// AuditPruneSetting is the transaction local setting that allows rows to be
+// deleted from audit_logs. Only the retention job sets it.
+const AuditPruneSetting = "viacortex.audit_prune"
+
+// createAuditChain installs the hash chain over audit_logs. Every inserted
+// row stores the hash of the previous row and a SHA-256 over its own content
+// and that previous hash, so editing or removing a row breaks the chain.
+// Updates are rejected outright and deletes only pass for the retention job.
+func createAuditChain(ctx context.Context, tx pgx.Tx) error {
+    queries := []string{
+        `
+        CREATE OR REPLACE FUNCTION audit_log_entry_hash(prev TEXT, a audit_logs)
+        RETURNS TEXT AS $$
+            SELECT encode(sha256(convert_to(concat_ws('|',
+                COALESCE(prev, ''),
+                a.id,
+                a.user_id,
+                a.action,
+                COALESCE(a.entity_type, ''),
+                COALESCE(a.entity_id::text, ''),
+                COALESCE(a.changes::text, ''),
+                COALESCE(host(a.ip_address), ''),
+                COALESCE(a.user_agent, ''),
+                to_char(a.timestamp AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.US')
+            ), 'UTF8')), 'hex')
+        $$ LANGUAGE sql STABLE;
+        `,
+        `
+        CREATE OR REPLACE FUNCTION audit_logs_chain()
+        RETURNS TRIGGER AS $$
+        BEGIN
+            -- Serialize writers so every row links to its true predecessor
+            PERFORM pg_advisory_xact_lock(hashtext('audit_logs_chain'));
+            IF NEW.timestamp IS NULL THEN
+                NEW.timestamp = CURRENT_TIMESTAMP;
+            END IF;
+            SELECT hash INTO NEW.prev_hash
+            FROM audit_logs
+            WHERE hash IS NOT NULL
+            ORDER BY id DESC
+            LIMIT 1;
+            NEW.hash = audit_log_entry_hash(NEW.prev_hash, NEW);
+            RETURN NEW;
+        END;
+        $$ LANGUAGE plpgsql;
+        `,
+        `
+        CREATE OR REPLACE FUNCTION audit_logs_protect()
+        RETURNS TRIGGER AS $$
+        BEGIN
+            IF TG_OP = 'DELETE' AND current_setting('` + AuditPruneSetting + `', true) = 'on' THEN
+                RETURN OLD;
+            END IF;
+            RAISE EXCEPTION 'audit_logs is append-only';
+        END;
+        $$ LANGUAGE plpgsql;
+        `,
+        `
+        DROP TRIGGER IF EXISTS audit_logs_chain ON audit_logs;
+        CREATE TRIGGER audit_logs_chain
+        BEFORE INSERT ON audit_logs
+        FOR EACH ROW
+        EXECUTE FUNCTION audit_logs_chain();
+        `,
+        `
+        DROP TRIGGER IF EXISTS audit_logs_protect ON audit_logs;
+        CREATE TRIGGER audit_logs_protect
+        BEFORE UPDATE OR DELETE ON audit_logs
+        FOR EACH ROW
+        EXECUTE FUNCTION audit_logs_protect();
+        `,
+    }
+
+    for _, query := range queries {
+        if _, err := tx.Exec(ctx, query); err != nil {
+            return err
+        }
+    }
+    return nil
+}
@@ -0,0 +1,82 @@
+package db
+
+import (
+    "context"
+
+    "github.com/jackc/pgx/v4"
+)
+
+// createDomainVersioning keeps domains.version in step with the domain's
+// configuration. It is bumped whenever a configuration column of the domain
+// changes or one of its backends, IP rules or rate limits is added, changed
+// or removed. Health check results do not count as configuration.
+func createDomainVersioning(ctx context.Context, tx pgx.Tx) error {
+    queries := []string{
+        `
+        CREATE OR REPLACE FUNCTION domains_bump_version()
+        RETURNS TRIGGER AS $$
+        BEGIN
+            IF NEW.version = OLD.version AND (
+                NEW.name, NEW.target_url, NEW.ssl_enabled, NEW.health_check_enabled,
+                NEW.health_check_interval, NEW.custom_error_pages, NEW.enabled
+            ) IS DISTINCT FROM (
+                OLD.name, OLD.target_url, OLD.ssl_enabled, OLD.health_check_enabled,
+                OLD.health_check_interval, OLD.custom_error_pages, OLD.enabled
+            ) THEN
+                NEW.version = OLD.version + 1;
+            END IF;
+            RETURN NEW;
+        END;
+        $$ LANGUAGE plpgsql;
+        `,
+        `
+        CREATE OR REPLACE FUNCTION domain_child_bump_version()
+        RETURNS TRIGGER AS $$
+        BEGIN
+            -- Nested IFs: plpgsql does not short-circuit, and NEW/OLD
+            -- fields only exist for some operations and tables
+            IF TG_OP = 'UPDATE' THEN
+                IF TG_TABLE_NAME = 'backend_servers' THEN
+                    IF (NEW.scheme, NEW.ip, NEW.port, NEW.weight, NEW.is_active)
+                        IS NOT DISTINCT FROM
+                        (OLD.scheme, OLD.ip, OLD.port, OLD.weight, OLD.is_active)
+                        AND NEW.domain_id = OLD.domain_id THEN
+                        RETURN NULL;
+                    END IF;
+                END IF;
+                UPDATE domains SET version = version + 1 WHERE id IN (OLD.domain_id, NEW.domain_id);
+            ELSIF TG_OP = 'INSERT' THEN
+                UPDATE domains SET version = version + 1 WHERE id = NEW.domain_id;
+            ELSE
+                UPDATE domains SET version = version + 1 WHERE id = OLD.domain_id;
+            END IF;
+            RETURN NULL;
+        END;
+        $$ LANGUAGE plpgsql;
+        `,
+        `
+        DROP TRIGGER IF EXISTS domains_bump_version ON domains;
+        CREATE TRIGGER domains_bump_version
+        BEFORE UPDATE ON domains
+        FOR EACH ROW
+        EXECUTE FUNCTION domains_bump_version();
+        `,
+    }
+
+    for _, table := range []string{"backend_servers", "ip_rules", "rate_limits"} {
+        queries = append(queries, `
+        DROP TRIGGER IF EXISTS `+table+`_bump_domain_version ON `+table+`;
+        CREATE TRIGGER `+table+`_bump_domain_version
+        AFTER INSERT OR UPDATE OR DELETE ON `+table+`
+        FOR EACH ROW
+        EXECUTE FUNCTION domain_child_bump_version();
+        `)
+    }
+
+    for _, query := range queries {
+        if _, err := tx.Exec(ctx, query); err != nil {
+            return err
+        }
+    }
+    return nil
+}
@@ -0,0 +1,315 @@
+package db
+
+import (
+    "context"
+    "embed"
+    "fmt"
+    "io/fs"
+    "regexp"
+    "sort"
+    "strconv"
+    "time"
+
+    "github.com/jackc/pgx/v4"
+    "github.com/jackc/pgx/v4/pgxpool"
+)
+
+// migrationLockID keeps nodes starting together from migrating at once
+const migrationLockID = 0x7663536368656d61
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationFileName matches migrations/<version>_<name>.<up|down>.sql, the
+// layout used by golang-migrate
+var migrationFileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is one versioned schema change. Down is nil when the change
+// cannot be rolled back.
+type Migration struct {
+    Version int64
+    Name    string
+    Up      func(ctx context.Context, tx pgx.Tx) error
+    Down    func(ctx context.Context, tx pgx.Tx) error
+}
+
+// MigrationStatus reports whether a migration has been applied
+type MigrationStatus struct {
+    Version   int64
+    Name      string
+    AppliedAt *time.Time
+}
+
+// Migrator applies and rolls back schema migrations, recording them in the
+// schema_migrations table. Each migration runs in its own transaction.
+type Migrator struct {
+    pool       *pgxpool.Pool
+    migrations []Migration
+}
+
+// NewMigrator loads the baseline schema and the SQL migrations embedded
+// from migrations/
+func NewMigrator(pool *pgxpool.Pool) (*Migrator, error) {
+    migrations, err := loadMigrations(migrationFiles)
+    if err != nil {
+        return nil, err
+    }
+    return &Migrator{pool: pool, migrations: migrations}, nil
+}
+
+// loadMigrations reads the migration files in fsys's migrations directory
+// and returns them in version order, after the baseline
+func loadMigrations(fsys fs.FS) ([]Migration, error) {
+    byVersion := map[int64]*Migration{
+        1: {Version: 1, Name: "baseline", Up: baselineSchema},
+    }
+
+    entries, err := fs.ReadDir(fsys, "migrations")
+    if err != nil {
+        return nil, err
+    }
+    for _, e := range entries {
+        match := migrationFileName.FindStringSubmatch(e.Name())
+        if match == nil {
+            return nil, fmt.Errorf("migration file %s is not named <version>_<name>.<up|down>.sql", e.Name())
+        }
+        version, err := strconv.ParseInt(match[1], 10, 64)
+        if err != nil || version < 2 {
+            return nil, fmt.Errorf("migration file %s: version must be 2 or higher", e.Name())
+        }
+        body, err := fs.ReadFile(fsys, "migrations/"+e.Name())
+        if err != nil {
+            return nil, err
+        }
+
+        m := byVersion[version]
+        if m == nil {
+            m = &Migration{Version: version, Name: match[2]}
+            byVersion[version] = m
+        } else if m.Name != match[2] {
+            return nil, fmt.Errorf("migration %d has files named %s and %s", version, m.Name, match[2])
+        }
+        step := sqlMigration(string(body))
+        if match[3] == "up" {
+            m.Up = step
+        } else {
+            m.Down = step
+        }
+    }
+
+    migrations := make([]Migration, 0, len(byVersion))
+    for _, m := range byVersion {
+        if m.Up == nil {
+            return nil, fmt.Errorf("migration %d_%s has no up file", m.Version, m.Name)
+        }
+        migrations = append(migrations, *m)
+    }
+    sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+    return migrations, nil
+}
+
+// sqlMigration runs a migration file. Without arguments the statements are
+// sent as one simple query, so a file may hold several of them.
+func sqlMigration(sql string) func(ctx context.Context, tx pgx.Tx) error {
+    return func(ctx context.Context, tx pgx.Tx) error {
+        _, err := tx.Exec(ctx, sql)
+        return err
+    }
+}
+
+// Latest is the version the schema has once every migration is applied
+func (m *Migrator) Latest() int64 {
+    return m.migrations[len(m.migrations)-1].Version
+}
+
+// Version returns the highest applied migration, or 0 for an empty database
+func (m *Migrator) Version(ctx context.Context) (int64, error) {
+    if exists, err := m.tableExists(ctx); err != nil || !exists {
+        return 0, err
+    }
+    var version int64
+    err := m.pool.QueryRow(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+    return version, err
+}
+
+// CheckCurrent returns an error when migrations are pending or the database
+// is newer than this build
+func (m *Migrator) CheckCurrent(ctx context.Context) error {
+    version, err := m.Version(ctx)
+    if err != nil {
+        return err
+    }
+    switch {
+    case version < m.Latest():
+        return fmt.Errorf("database schema is at version %d but %d is required; run \"viacortex migrate up\"", version, m.Latest())
+    case version > m.Latest():
+        return fmt.Errorf("database schema version %d is newer than this build supports (%d)", version, m.Latest())
+    }
+    return nil
+}
+
+// Status lists every known migration and when it was applied
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+    exists, err := m.tableExists(ctx)
+    if err != nil {
+        return nil, err
+    }
+    applied := map[int64]time.Time{}
+    if exists {
+        if applied, err = m.appliedAt(ctx); err != nil {
+            return nil, err
+        }
+    }
+
+    status := make([]MigrationStatus, 0, len(m.migrations))
+    for _, mig := range m.migrations {
+        s := MigrationStatus{Version: mig.Version, Name: mig.Name}
+        if at, ok := applied[mig.Version]; ok {
+            s.AppliedAt = &at
+        }
+        status = append(status, s)
+    }
+    return status, nil
+}
+
+// tableExists reports whether migrations have ever been run against the
+// database; reads do not create the table
+func (m *Migrator) tableExists(ctx context.Context) (bool, error) {
+    var exists bool
+    err := m.pool.QueryRow(ctx, "SELECT to_regclass('schema_migrations') IS NOT NULL").Scan(&exists)
+    return exists, err
+}
+
+func (m *Migrator) appliedAt(ctx context.Context) (map[int64]time.Time, error) {
+    rows, err := m.pool.Query(ctx, "SELECT version, applied_at FROM schema_migrations")
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    applied := map[int64]time.Time{}
+    for rows.Next() {
+        var version int64
+        var at time.Time
+        if err := rows.Scan(&version, &at); err != nil {
+            return nil, err
+        }
+        applied[version] = at
+    }
+    return applied, rows.Err()
+}
+
+// Up applies every pending migration in order
+func (m *Migrator) Up(ctx context.Context) error {
+    return m.locked(ctx, func(conn *pgxpool.Conn) error {
+        applied, err := appliedVersions(ctx, conn)
+        if err != nil {
+            return err
+        }
+        for _, mig := range m.migrations {
+            if applied[mig.Version] {
+                continue
+            }
+            logger.Info("Applying migration", "version", mig.Version, "name", mig.Name)
+            if err := runMigration(ctx, conn, mig.Up, func(tx pgx.Tx) error {
+                _, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", mig.Version, mig.Name)
+                return err
+            }); err != nil {
+                return fmt.Errorf("migration %d_%s: %w", mig.Version, mig.Name, err)
+            }
+        }
+        return nil
+    })
+}
+
+// Down rolls back the most recently applied migrations, steps of them
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+    return m.locked(ctx, func(conn *pgxpool.Conn) error {
+        applied, err := appliedVersions(ctx, conn)
+        if err != nil {
+            return err
+        }
+        for i := len(m.migrations) - 1; i >= 0 && steps > 0; i-- {
+            mig := m.migrations[i]
+            if !applied[mig.Version] {
+                continue
+            }
+            if mig.Down == nil {
+                return fmt.Errorf("migration %d_%s cannot be rolled back", mig.Version, mig.Name)
+            }
+            logger.Info("Rolling back migration", "version", mig.Version, "name", mig.Name)
+            if err := runMigration(ctx, conn, mig.Down, func(tx pgx.Tx) error {
+                _, err := tx.Exec(ctx, "DELETE FROM schema_migrations WHERE version = $1", mig.Version)
+                return err
+            }); err != nil {
+                return fmt.Errorf("migration %d_%s: %w", mig.Version, mig.Name, err)
+            }
+            steps--
+        }
+        return nil
+    })
+}
+
+// locked runs fn on one connection holding the migration lock
+func (m *Migrator) locked(ctx context.Context, fn func(conn *pgxpool.Conn) error) error {
+    conn, err := m.pool.Acquire(ctx)
+    if err != nil {
+        return err
+    }
+    defer conn.Release()
+
+    if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+        return err
+    }
+    defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID)
+
+    if err := ensureMigrationsTable(ctx, conn); err != nil {
+        return err
+    }
+    return fn(conn)
+}
+
+func runMigration(ctx context.Context, conn *pgxpool.Conn, step func(ctx context.Context, tx pgx.Tx) error, record func(tx pgx.Tx) error) error {
+    tx, err := conn.Begin(ctx)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback(ctx)
+
+    if err := step(ctx, tx); err != nil {
+        return err
+    }
+    if err := record(tx); err != nil {
+        return err
+    }
+    return tx.Commit(ctx)
+}
+
+func ensureMigrationsTable(ctx context.Context, conn *pgxpool.Conn) error {
+    _, err := conn.Exec(ctx, `
+        CREATE TABLE IF NOT EXISTS schema_migrations (
+            version BIGINT PRIMARY KEY,
+            name VARCHAR(255) NOT NULL,
+            applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+        )
+    `)
+    return err
+}
+
+func appliedVersions(ctx context.Context, conn *pgxpool.Conn) (map[int64]bool, error) {
+    rows, err := conn.Query(ctx, "SELECT version FROM schema_migrations")
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    applied := map[int64]bool{}
+    for rows.Next() {
+        var version int64
+        if err := rows.Scan(&version); err != nil {
+            return nil, err
+        }
+        applied[version] = true
+    }
+    return applied, rows.Err()
+}
@@ -0,0 +1,112 @@
+package db
+
+import (
+    "io/fs"
+    "strings"
+    "testing"
+    "testing/fstest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+    file := func(sql string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(sql)} }
+
+    tests := []struct {
+        name     string
+        files    fstest.MapFS
+        want     []int64 // versions in order
+        wantDown []bool
+        wantErr  string
+    }{
+        {
+            name:     "no files",
+            files:    fstest.MapFS{"migrations": &fstest.MapFile{Mode: fs.ModeDir}},
+            want:     []int64{1},
+            wantDown: []bool{false},
+        },
+        {
+            name: "ordered by version, not name",
+            files: fstest.MapFS{
+                "migrations/0010_ten.up.sql":    file("SELECT 10"),
+                "migrations/0010_ten.down.sql":  file("SELECT -10"),
+                "migrations/0002_two.up.sql":    file("SELECT 2"),
+                "migrations/0009_nine.up.sql":   file("SELECT 9"),
+                "migrations/100_hundred.up.sql": file("SELECT 100"),
+            },
+            want:     []int64{1, 2, 9, 10, 100},
+            wantDown: []bool{false, false, false, true, false},
+        },
+        {
+            name:    "badly named file",
+            files:   fstest.MapFS{"migrations/0002_Two.up.sql": file("")},
+            wantErr: "is not named <version>_<name>.<up|down>.sql",
+        },
+        {
+            name:    "not SQL",
+            files:   fstest.MapFS{"migrations/0002_two.up.txt": file("")},
+            wantErr: "is not named",
+        },
+        {
+            name:    "baseline version",
+            files:   fstest.MapFS{"migrations/0001_baseline.up.sql": file("")},
+            wantErr: "version must be 2 or higher",
+        },
+        {
+            name: "names disagree",
+            files: fstest.MapFS{
+                "migrations/0002_two.up.sql":      file(""),
+                "migrations/0002_second.down.sql": file(""),
+            },
+            wantErr: "migration 2 has files named",
+        },
+        {
+            name:    "down without up",
+            files:   fstest.MapFS{"migrations/0002_two.down.sql": file("")},
+            wantErr: "migration 2_two has no up file",
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            migrations, err := loadMigrations(tt.files)
+            if tt.wantErr != "" {
+                if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                    t.Fatalf("error = %v, want %q", err, tt.wantErr)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            if len(migrations) != len(tt.want) {
+                t.Fatalf("got %d migrations, want %d", len(migrations), len(tt.want))
+            }
+            for i, m := range migrations {
+                if m.Version != tt.want[i] {
+                    t.Errorf("migration %d has version %d, want %d", i, m.Version, tt.want[i])
+                }
+                if m.Up == nil {
+                    t.Errorf("migration %d has no up step", m.Version)
+                }
+                if (m.Down != nil) != tt.wantDown[i] {
+                    t.Errorf("migration %d down step set = %v, want %v", m.Version, m.Down != nil, tt.wantDown[i])
+                }
+            }
+        })
+    }
+}
+
+// TestEmbeddedMigrations checks the migrations shipped with the binary load,
+// follow each other without gaps and can all be rolled back
+func TestEmbeddedMigrations(t *testing.T) {
+    migrations, err := loadMigrations(migrationFiles)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for i, m := range migrations {
+        if m.Version != int64(i+1) {
+            t.Fatalf("migration %d_%s follows version %d", m.Version, m.Name, i)
+        }
+        if m.Version > 1 && m.Down == nil {
+            t.Errorf("migration %d_%s has no down file", m.Version, m.Name)
+        }
+    }
+}
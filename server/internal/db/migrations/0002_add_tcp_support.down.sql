@@ -0,0 +1,3 @@
+-- The tcp_metrics table and the tcp backend scheme are part of the baseline
+-- schema, so only the column comment is undone here
+COMMENT ON COLUMN backend_servers.health_status IS NULL;
@@ -0,0 +1,20 @@
+CREATE TRIGGER update_request_metrics_updated_at
+BEFORE UPDATE ON request_metrics
+FOR EACH ROW
+EXECUTE FUNCTION update_updated_at_column();
+
+CREATE TRIGGER update_request_logs_updated_at
+BEFORE UPDATE ON request_logs
+FOR EACH ROW
+EXECUTE FUNCTION update_updated_at_column();
+
+CREATE TRIGGER update_audit_logs_updated_at
+BEFORE UPDATE ON audit_logs
+FOR EACH ROW
+EXECUTE FUNCTION update_updated_at_column();
+
+-- Entries of users deleted in the meantime no longer have a user to point
+-- at, so the restored constraint only applies to new rows
+ALTER TABLE audit_logs
+    ADD CONSTRAINT audit_logs_user_id_fkey FOREIGN KEY (user_id)
+    REFERENCES users(id) ON DELETE SET NULL NOT VALID;
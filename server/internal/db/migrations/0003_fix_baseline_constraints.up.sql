@@ -0,0 +1,12 @@
+-- audit_logs.user_id is NOT NULL and every row is append-only and hashed
+-- over it, so the ON DELETE SET NULL action could never run: deleting a user
+-- with audit entries failed instead. Entries keep the ID of the user who
+-- acted, without a foreign key, so users can be deleted and the chain stays
+-- intact.
+ALTER TABLE audit_logs DROP CONSTRAINT IF EXISTS audit_logs_user_id_fkey;
+
+-- These tables have no updated_at column, so the baseline's update
+-- triggers made every UPDATE on them fail
+DROP TRIGGER IF EXISTS update_request_metrics_updated_at ON request_metrics;
+DROP TRIGGER IF EXISTS update_request_logs_updated_at ON request_logs;
+DROP TRIGGER IF EXISTS update_audit_logs_updated_at ON audit_logs;
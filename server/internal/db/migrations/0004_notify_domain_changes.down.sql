@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS domains_notify_change ON domains;
+DROP FUNCTION IF EXISTS notify_domain_change();
@@ -0,0 +1,20 @@
+-- Tell running proxies to reload as soon as a domain's configuration
+-- changes. Changes to backends, IP rules and rate limits bump the domain's
+-- version, so a trigger on domains covers them too.
+CREATE OR REPLACE FUNCTION notify_domain_change()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        PERFORM pg_notify('viacortex_domains', OLD.id::text);
+    ELSE
+        PERFORM pg_notify('viacortex_domains', NEW.id::text);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS domains_notify_change ON domains;
+CREATE TRIGGER domains_notify_change
+AFTER INSERT OR UPDATE OR DELETE ON domains
+FOR EACH ROW
+EXECUTE FUNCTION notify_domain_change();
@@ -0,0 +1,16 @@
+-- Extra hostnames a domain is served under, next to its name
+CREATE TABLE domain_aliases (
+    id SERIAL PRIMARY KEY,
+    domain_id INTEGER NOT NULL REFERENCES domains(id) ON DELETE CASCADE,
+    hostname VARCHAR(255) NOT NULL UNIQUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_domain_aliases_domain_id ON domain_aliases(domain_id);
+
+-- Alias changes bump the domain's version, like backend and rule changes,
+-- which also notifies running proxies
+CREATE TRIGGER domain_aliases_bump_domain_version
+AFTER INSERT OR UPDATE OR DELETE ON domain_aliases
+FOR EACH ROW
+EXECUTE FUNCTION domain_child_bump_version();
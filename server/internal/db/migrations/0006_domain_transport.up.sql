@@ -0,0 +1,16 @@
+-- Connection pool settings for reaching a domain's backends. Missing rows
+-- and NULL columns keep the proxy defaults.
+CREATE TABLE domain_transport (
+    domain_id INTEGER PRIMARY KEY REFERENCES domains(id) ON DELETE CASCADE,
+    max_idle_conns_per_host INTEGER CHECK (max_idle_conns_per_host >= 0),
+    max_conns_per_host INTEGER CHECK (max_conns_per_host >= 0),
+    idle_conn_timeout_seconds INTEGER CHECK (idle_conn_timeout_seconds >= 0),
+    response_header_timeout_seconds INTEGER CHECK (response_header_timeout_seconds >= 0),
+    http2_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TRIGGER domain_transport_bump_domain_version
+AFTER INSERT OR UPDATE OR DELETE ON domain_transport
+FOR EACH ROW
+EXECUTE FUNCTION domain_child_bump_version();
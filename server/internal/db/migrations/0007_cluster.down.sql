@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS cert_locks;
+DROP TABLE IF EXISTS cert_storage;
+DROP TABLE IF EXISTS cluster_nodes;
@@ -0,0 +1,25 @@
+-- Nodes of a cluster sharing this database. Each node refreshes its row on
+-- a heartbeat; a node whose row expired is shown as offline.
+CREATE TABLE cluster_nodes (
+    id VARCHAR(255) PRIMARY KEY,
+    hostname VARCHAR(255) NOT NULL,
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_seen_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    status JSONB NOT NULL DEFAULT '{}'
+);
+
+-- Certificates and ACME state, when nodes share them through the database
+-- instead of the local storage directory
+CREATE TABLE cert_storage (
+    key TEXT PRIMARY KEY,
+    value BYTEA NOT NULL,
+    modified_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Locks that keep nodes from requesting the same certificate at once
+CREATE TABLE cert_locks (
+    name TEXT PRIMARY KEY,
+    holder VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
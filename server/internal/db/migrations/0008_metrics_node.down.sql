@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_request_metrics_node_time;
+
+ALTER TABLE request_logs DROP COLUMN IF EXISTS node_id;
+ALTER TABLE tcp_metrics DROP COLUMN IF EXISTS node_id;
+ALTER TABLE request_metrics DROP COLUMN IF EXISTS node_id;
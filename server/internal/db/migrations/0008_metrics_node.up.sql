@@ -0,0 +1,7 @@
+-- The cluster node that recorded each metrics row, so per-node traffic can
+-- be compared. Rows from before clustering have no node.
+ALTER TABLE request_metrics ADD COLUMN node_id VARCHAR(255);
+ALTER TABLE tcp_metrics ADD COLUMN node_id VARCHAR(255);
+ALTER TABLE request_logs ADD COLUMN node_id VARCHAR(255);
+
+CREATE INDEX idx_request_metrics_node_time ON request_metrics(node_id, timestamp);
@@ -0,0 +1,17 @@
+-- Chaos testing: a share of a domain's requests is delayed, answered with
+-- an error or has its connection reset. Every fault expires on its own.
+CREATE TABLE domain_faults (
+    domain_id INTEGER PRIMARY KEY REFERENCES domains(id) ON DELETE CASCADE,
+    percent INTEGER NOT NULL CHECK (percent BETWEEN 1 AND 100),
+    delay_ms INTEGER NOT NULL DEFAULT 0 CHECK (delay_ms >= 0),
+    error_status INTEGER CHECK (error_status BETWEEN 400 AND 599),
+    reset_connection BOOLEAN NOT NULL DEFAULT FALSE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TRIGGER domain_faults_bump_domain_version
+AFTER INSERT OR UPDATE OR DELETE ON domain_faults
+FOR EACH ROW
+EXECUTE FUNCTION domain_child_bump_version();
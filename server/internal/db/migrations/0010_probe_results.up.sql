@@ -0,0 +1,13 @@
+-- Synthetic requests each node sends through its own proxy listeners, kept
+-- apart from user traffic as a latency baseline
+CREATE TABLE probe_results (
+    id BIGSERIAL PRIMARY KEY,
+    domain VARCHAR(255) NOT NULL,
+    node_id VARCHAR(255),
+    status_code INTEGER,
+    latency_ms DOUBLE PRECISION NOT NULL,
+    error TEXT,
+    timestamp TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_probe_results_domain_time ON probe_results(domain, timestamp);
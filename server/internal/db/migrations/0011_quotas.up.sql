@@ -0,0 +1,13 @@
+-- Per-tenant overrides of the configured quotas. A tenant is a user, for
+-- domains outside any organization, or an organization. NULL columns keep
+-- the configured default; 0 means unlimited.
+CREATE TABLE quotas (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+    org_id INTEGER UNIQUE REFERENCES organizations(id) ON DELETE CASCADE,
+    max_domains INTEGER CHECK (max_domains >= 0),
+    max_backends INTEGER CHECK (max_backends >= 0),
+    max_ip_rules INTEGER CHECK (max_ip_rules >= 0),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CHECK ((user_id IS NULL) <> (org_id IS NULL))
+);
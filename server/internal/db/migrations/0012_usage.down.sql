@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS usage_reports;
+DROP TABLE IF EXISTS usage_monthly;
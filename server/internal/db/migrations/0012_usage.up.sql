@@ -0,0 +1,20 @@
+-- Monthly usage per domain for billing, added to on every metrics flush.
+-- The domain name is kept so usage survives the domain's deletion.
+CREATE TABLE usage_monthly (
+    domain VARCHAR(255) NOT NULL,
+    month DATE NOT NULL,
+    domain_id INTEGER REFERENCES domains(id) ON DELETE SET NULL,
+    requests BIGINT NOT NULL DEFAULT 0,
+    bytes_in BIGINT NOT NULL DEFAULT 0,
+    bytes_out BIGINT NOT NULL DEFAULT 0,
+    tcp_connections BIGINT NOT NULL DEFAULT 0,
+    tcp_seconds DOUBLE PRECISION NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (domain, month)
+);
+
+-- Months whose usage was sent as a usage.report webhook event
+CREATE TABLE usage_reports (
+    month DATE PRIMARY KEY,
+    reported_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS domain_plugins;
+DROP TABLE IF EXISTS plugins;
+DROP FUNCTION IF EXISTS plugins_bump_domain_version();
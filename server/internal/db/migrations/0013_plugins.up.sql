@@ -0,0 +1,47 @@
+-- WASM plugins run on a domain's requests and responses in a sandbox
+CREATE TABLE plugins (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL UNIQUE,
+    description TEXT,
+    wasm BYTEA NOT NULL,
+    sha256 CHAR(64) NOT NULL,
+    memory_limit_mb INTEGER NOT NULL DEFAULT 16 CHECK (memory_limit_mb BETWEEN 1 AND 256),
+    timeout_ms INTEGER NOT NULL DEFAULT 50 CHECK (timeout_ms BETWEEN 1 AND 5000),
+    fail_open BOOLEAN NOT NULL DEFAULT TRUE,
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- The plugins each domain runs, in ascending position
+CREATE TABLE domain_plugins (
+    domain_id INTEGER NOT NULL REFERENCES domains(id) ON DELETE CASCADE,
+    plugin_id INTEGER NOT NULL REFERENCES plugins(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL DEFAULT 0,
+    config JSONB,
+    PRIMARY KEY (domain_id, plugin_id)
+);
+
+CREATE TRIGGER domain_plugins_bump_domain_version
+AFTER INSERT OR UPDATE OR DELETE ON domain_plugins
+FOR EACH ROW
+EXECUTE FUNCTION domain_child_bump_version();
+
+-- A changed plugin changes the configuration of every domain running it
+CREATE OR REPLACE FUNCTION plugins_bump_domain_version()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF (NEW.sha256, NEW.memory_limit_mb, NEW.timeout_ms, NEW.fail_open)
+        IS DISTINCT FROM
+        (OLD.sha256, OLD.memory_limit_mb, OLD.timeout_ms, OLD.fail_open) THEN
+        UPDATE domains SET version = version + 1
+        WHERE id IN (SELECT domain_id FROM domain_plugins WHERE plugin_id = NEW.id);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER plugins_bump_domain_version
+AFTER UPDATE ON plugins
+FOR EACH ROW
+EXECUTE FUNCTION plugins_bump_domain_version();
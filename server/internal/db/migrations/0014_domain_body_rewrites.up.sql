@@ -0,0 +1,12 @@
+-- Backend URLs in a domain's HTML and JSON responses are replaced with its
+-- public URL while a row exists
+CREATE TABLE domain_body_rewrites (
+    domain_id INTEGER PRIMARY KEY REFERENCES domains(id) ON DELETE CASCADE,
+    extra_urls TEXT[] NOT NULL DEFAULT '{}',
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TRIGGER domain_body_rewrites_bump_domain_version
+AFTER INSERT OR UPDATE OR DELETE ON domain_body_rewrites
+FOR EACH ROW
+EXECUTE FUNCTION domain_child_bump_version();
@@ -0,0 +1,19 @@
+-- Cache-Control set on a domain's responses by path prefix or content type;
+-- the first matching rule in ascending position applies
+CREATE TABLE domain_cache_rules (
+    id SERIAL PRIMARY KEY,
+    domain_id INTEGER NOT NULL REFERENCES domains(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL DEFAULT 0,
+    path_prefix VARCHAR(255),
+    content_type VARCHAR(255),
+    cache_control VARCHAR(255) NOT NULL,
+    override BOOLEAN NOT NULL DEFAULT FALSE,
+    CHECK (path_prefix IS NOT NULL OR content_type IS NOT NULL)
+);
+
+CREATE INDEX idx_domain_cache_rules_domain ON domain_cache_rules(domain_id, position);
+
+CREATE TRIGGER domain_cache_rules_bump_domain_version
+AFTER INSERT OR UPDATE OR DELETE ON domain_cache_rules
+FOR EACH ROW
+EXECUTE FUNCTION domain_child_bump_version();
@@ -0,0 +1,16 @@
+-- Link headers sent in a 103 Early Hints response to requests for paths
+-- under path_prefix, while the backend prepares the real response
+CREATE TABLE domain_early_hints (
+    id SERIAL PRIMARY KEY,
+    domain_id INTEGER NOT NULL REFERENCES domains(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL DEFAULT 0,
+    path_prefix VARCHAR(255) NOT NULL DEFAULT '/',
+    link TEXT NOT NULL
+);
+
+CREATE INDEX idx_domain_early_hints_domain ON domain_early_hints(domain_id, position);
+
+CREATE TRIGGER domain_early_hints_bump_domain_version
+AFTER INSERT OR UPDATE OR DELETE ON domain_early_hints
+FOR EACH ROW
+EXECUTE FUNCTION domain_child_bump_version();
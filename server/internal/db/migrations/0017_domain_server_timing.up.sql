@@ -0,0 +1,11 @@
+-- Responses of the domain carry the proxy's Server-Timing header while a
+-- row exists
+CREATE TABLE domain_server_timing (
+    domain_id INTEGER PRIMARY KEY REFERENCES domains(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TRIGGER domain_server_timing_bump_domain_version
+AFTER INSERT OR UPDATE OR DELETE ON domain_server_timing
+FOR EACH ROW
+EXECUTE FUNCTION domain_child_bump_version();
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS threat_blocklist_version;
+DROP TABLE IF EXISTS threat_allowlist;
+DROP TABLE IF EXISTS threat_feed_entries;
+DROP TABLE IF EXISTS threat_feeds;
+DROP FUNCTION IF EXISTS threat_blocklist_bump_version();
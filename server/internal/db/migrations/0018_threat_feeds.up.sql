@@ -0,0 +1,71 @@
+-- Abuse feeds pulled into a global blocklist, applied to every domain before
+-- its own IP rules. The leader fetches each enabled feed every
+-- refresh_minutes and replaces its entries; a feed that fails keeps them.
+CREATE TABLE threat_feeds (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL UNIQUE,
+    kind VARCHAR(20) NOT NULL CHECK (kind IN ('spamhaus_drop', 'abuseipdb', 'url')),
+    url TEXT NOT NULL DEFAULT '',
+    api_key TEXT NOT NULL DEFAULT '',
+    min_confidence INTEGER NOT NULL DEFAULT 90 CHECK (min_confidence BETWEEN 25 AND 100),
+    refresh_minutes INTEGER NOT NULL DEFAULT 60 CHECK (refresh_minutes BETWEEN 15 AND 10080),
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    entry_count INTEGER NOT NULL DEFAULT 0,
+    blocked_requests BIGINT NOT NULL DEFAULT 0,
+    last_blocked_at TIMESTAMP WITH TIME ZONE,
+    checked_at TIMESTAMP WITH TIME ZONE,
+    fetched_at TIMESTAMP WITH TIME ZONE,
+    last_error TEXT,
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE threat_feed_entries (
+    feed_id INTEGER NOT NULL REFERENCES threat_feeds(id) ON DELETE CASCADE,
+    cidr CIDR NOT NULL,
+    PRIMARY KEY (feed_id, cidr)
+);
+
+CREATE INDEX idx_threat_feed_entries_cidr ON threat_feed_entries USING gist (cidr inet_ops);
+
+-- Ranges never blocked by the feeds, e.g. a partner listed by mistake
+CREATE TABLE threat_allowlist (
+    id SERIAL PRIMARY KEY,
+    cidr CIDR NOT NULL UNIQUE,
+    description TEXT NOT NULL DEFAULT '',
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Nodes reload the blocklist when this changes
+CREATE TABLE threat_blocklist_version (
+    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    version BIGINT NOT NULL DEFAULT 0
+);
+
+INSERT INTO threat_blocklist_version (id, version) VALUES (TRUE, 0);
+
+CREATE OR REPLACE FUNCTION threat_blocklist_bump_version()
+RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE threat_blocklist_version SET version = version + 1;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER threat_feed_entries_bump_version
+AFTER INSERT OR UPDATE OR DELETE ON threat_feed_entries
+FOR EACH STATEMENT
+EXECUTE FUNCTION threat_blocklist_bump_version();
+
+-- Counters and fetch times change often and do not affect the blocklist
+CREATE TRIGGER threat_feeds_bump_version
+AFTER DELETE OR UPDATE OF enabled ON threat_feeds
+FOR EACH STATEMENT
+EXECUTE FUNCTION threat_blocklist_bump_version();
+
+CREATE TRIGGER threat_allowlist_bump_version
+AFTER INSERT OR UPDATE OR DELETE ON threat_allowlist
+FOR EACH STATEMENT
+EXECUTE FUNCTION threat_blocklist_bump_version();
@@ -15,6 +15,8 @@ type Domain struct {
     HealthCheckEnabled bool            `json:"health_check_enabled" db:"health_check_enabled"`
     HealthCheckInterval int            `json:"health_check_interval" db:"health_check_interval"`
     CustomErrorPages   json.RawMessage `json:"custom_error_pages" db:"custom_error_pages"`
+    Enabled            bool            `json:"enabled" db:"enabled"`
+    Version            int64           `json:"version" db:"version"`
     CreatedAt          time.Time       `json:"created_at" db:"created_at"`
     UpdatedAt          time.Time       `json:"updated_at" db:"updated_at"`
 	BackendServers     []BackendServer `json:"backend_servers,omitempty"`
@@ -44,6 +46,107 @@ type IPRule struct {
     UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
 }
 
+// DomainTransport tunes the connection pool used to reach a domain's
+// backends. Nil fields keep the proxy defaults.
+type DomainTransport struct {
+    DomainID                     int64      `json:"domain_id" db:"domain_id"`
+    MaxIdleConnsPerHost          *int       `json:"max_idle_conns_per_host" db:"max_idle_conns_per_host"`
+    MaxConnsPerHost              *int       `json:"max_conns_per_host" db:"max_conns_per_host"`
+    IdleConnTimeoutSeconds       *int       `json:"idle_conn_timeout_seconds" db:"idle_conn_timeout_seconds"`
+    ResponseHeaderTimeoutSeconds *int       `json:"response_header_timeout_seconds" db:"response_header_timeout_seconds"`
+    HTTP2Enabled                 bool       `json:"http2_enabled" db:"http2_enabled"`
+    UpdatedAt                    *time.Time `json:"updated_at,omitempty" db:"updated_at"`
+}
+
+// DomainFault injects failures into a share of a domain's requests for
+// chaos testing until it expires
+type DomainFault struct {
+    DomainID        int64      `json:"domain_id" db:"domain_id"`
+    Percent         int        `json:"percent" db:"percent"`
+    DelayMs         int        `json:"delay_ms" db:"delay_ms"`
+    ErrorStatus     *int       `json:"error_status" db:"error_status"`
+    ResetConnection bool       `json:"reset_connection" db:"reset_connection"`
+    ExpiresAt       time.Time  `json:"expires_at" db:"expires_at"`
+    Active          bool       `json:"active" db:"-"`
+    CreatedBy       *int64     `json:"created_by,omitempty" db:"created_by"`
+    CreatedAt       *time.Time `json:"created_at,omitempty" db:"created_at"`
+}
+
+// DomainBodyRewrite replaces backend URLs in a domain's HTML and JSON
+// responses with its public URL
+type DomainBodyRewrite struct {
+    DomainID  int64      `json:"domain_id" db:"domain_id"`
+    ExtraURLs []string   `json:"extra_urls" db:"extra_urls"`
+    UpdatedAt *time.Time `json:"updated_at,omitempty" db:"updated_at"`
+}
+
+// DomainCacheRule sets Cache-Control on a domain's responses matching a
+// path prefix and/or content type
+type DomainCacheRule struct {
+    ID           int64   `json:"id" db:"id"`
+    DomainID     int64   `json:"domain_id" db:"domain_id"`
+    Position     int     `json:"position" db:"position"`
+    PathPrefix   *string `json:"path_prefix,omitempty" db:"path_prefix"`
+    ContentType  *string `json:"content_type,omitempty" db:"content_type"`
+    CacheControl string  `json:"cache_control" db:"cache_control"`
+    Override     bool    `json:"override" db:"override"`
+}
+
+// DomainEarlyHint is a Link header sent in a 103 Early Hints response to
+// requests for paths under PathPrefix
+type DomainEarlyHint struct {
+    ID         int64  `json:"id" db:"id"`
+    DomainID   int64  `json:"domain_id" db:"domain_id"`
+    Position   int    `json:"position" db:"position"`
+    PathPrefix string `json:"path_prefix" db:"path_prefix"`
+    Link       string `json:"link" db:"link"`
+}
+
+// Plugin is an uploaded WASM module that domains can run on their requests
+// and responses. The module itself is not part of the JSON form.
+type Plugin struct {
+    ID            int64      `json:"id" db:"id"`
+    Name          string     `json:"name" db:"name"`
+    Description   *string    `json:"description,omitempty" db:"description"`
+    SHA256        string     `json:"sha256" db:"sha256"`
+    Size          int        `json:"size" db:"-"`
+    MemoryLimitMB int        `json:"memory_limit_mb" db:"memory_limit_mb"`
+    TimeoutMs     int        `json:"timeout_ms" db:"timeout_ms"`
+    FailOpen      bool       `json:"fail_open" db:"fail_open"`
+    CreatedBy     *int64     `json:"created_by,omitempty" db:"created_by"`
+    CreatedAt     *time.Time `json:"created_at,omitempty" db:"created_at"`
+    UpdatedAt     *time.Time `json:"updated_at,omitempty" db:"updated_at"`
+}
+
+// DomainPlugin attaches a plugin to a domain, with settings passed to the
+// plugin on every call
+type DomainPlugin struct {
+    DomainID int64           `json:"domain_id" db:"domain_id"`
+    PluginID int64           `json:"plugin_id" db:"plugin_id"`
+    Name     string          `json:"name" db:"-"`
+    Position int             `json:"position" db:"position"`
+    Config   json.RawMessage `json:"config,omitempty" db:"config"`
+}
+
+// Quota overrides the default limits of a tenant, either a user or an
+// organization. Nil fields keep the default; 0 means unlimited.
+type Quota struct {
+    UserID      *int64     `json:"user_id,omitempty" db:"user_id"`
+    OrgID       *int64     `json:"org_id,omitempty" db:"org_id"`
+    MaxDomains  *int       `json:"max_domains" db:"max_domains"`
+    MaxBackends *int       `json:"max_backends" db:"max_backends"`
+    MaxIPRules  *int       `json:"max_ip_rules" db:"max_ip_rules"`
+    UpdatedAt   *time.Time `json:"updated_at,omitempty" db:"updated_at"`
+}
+
+// DomainAlias is an additional hostname served with its domain's configuration
+type DomainAlias struct {
+    ID        int64     `json:"id" db:"id"`
+    DomainID  int64     `json:"domain_id" db:"domain_id"`
+    Hostname  string    `json:"hostname" db:"hostname"`
+    CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
 type RateLimit struct {
     ID                int64     `json:"id" db:"id"`
     DomainID         int64     `json:"domain_id" db:"domain_id"`
@@ -90,6 +193,19 @@ type User struct {
     UpdatedAt  time.Time     `json:"updated_at" db:"updated_at"`
 }
 
+// UserSettings are a user's dashboard preferences
+type UserSettings struct {
+    Timezone         string               `json:"timezone"`
+    DefaultTimeRange string               `json:"default_time_range"`
+    Notifications    NotificationSettings `json:"notifications"`
+    Avatar           string               `json:"avatar,omitempty"`
+}
+
+// NotificationSettings choose which emails a user receives
+type NotificationSettings struct {
+    LoginAlerts bool `json:"login_alerts"`
+}
+
 type AuditLog struct {
     ID         int64           `json:"id" db:"id"`
     UserID     int64           `json:"user_id" db:"user_id"`
@@ -98,4 +214,174 @@ type AuditLog struct {
     EntityID   int64           `json:"entity_id" db:"entity_id"`
     Changes    json.RawMessage `json:"changes" db:"changes"`
     Timestamp  time.Time       `json:"timestamp" db:"timestamp"`
-}
\ No newline at end of file
+}
+
+// AuditLogArchive records a batch of audit log entries removed by the
+// retention job. LastHash anchors the hash chain of the remaining entries.
+type AuditLogArchive struct {
+    ID        int64     `json:"id" db:"id"`
+    FirstID   int64     `json:"first_id" db:"first_id"`
+    LastID    int64     `json:"last_id" db:"last_id"`
+    RowCount  int       `json:"row_count" db:"row_count"`
+    LastHash  *string   `json:"last_hash" db:"last_hash"`
+    Location  *string   `json:"location" db:"location"`
+    CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+type APIKey struct {
+    ID         int64      `json:"id" db:"id"`
+    UserID     int64      `json:"user_id" db:"user_id"`
+    Name       string     `json:"name" db:"name"`
+    KeyPrefix  string     `json:"key_prefix" db:"key_prefix"`
+    KeyHash    string     `json:"-" db:"key_hash"`
+    Scopes     []string   `json:"scopes" db:"scopes"`
+    ExpiresAt  *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+    LastUsedAt *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+    RevokedAt  *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+    CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+    UpdatedAt  time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// WebAuthnCredential is a security key or passkey registered to a user
+type WebAuthnCredential struct {
+    ID         int64      `json:"id" db:"id"`
+    UserID     int64      `json:"user_id" db:"user_id"`
+    Name       string     `json:"name" db:"name"`
+    SignCount  int64      `json:"sign_count" db:"sign_count"`
+    Transports []string   `json:"transports" db:"transports"`
+    LastUsedAt *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+    CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+}
+
+// DomainMember grants a user a role on one domain
+type DomainMember struct {
+    DomainID  int64     `json:"domain_id" db:"domain_id"`
+    UserID    int64     `json:"user_id" db:"user_id"`
+    Email     string    `json:"email"`
+    Role      string    `json:"role" db:"role"`
+    CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// Role is a named set of permissions users can be assigned
+type Role struct {
+    ID          int64     `json:"id" db:"id"`
+    Name        string    `json:"name" db:"name"`
+    Description string    `json:"description" db:"description"`
+    Permissions []string  `json:"permissions" db:"permissions"`
+    Builtin     bool      `json:"builtin" db:"builtin"`
+    Users       int       `json:"users"`
+    CreatedAt   time.Time `json:"created_at" db:"created_at"`
+    UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Organization groups users and domains into a tenant
+type Organization struct {
+    ID        int64     `json:"id" db:"id"`
+    Name      string    `json:"name" db:"name"`
+    Slug      string    `json:"slug" db:"slug"`
+    Role      string    `json:"role,omitempty"`
+    CreatedAt time.Time `json:"created_at" db:"created_at"`
+    UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// OrganizationMember is a user's role within an organization
+type OrganizationMember struct {
+    OrgID     int64     `json:"org_id" db:"org_id"`
+    UserID    int64     `json:"user_id" db:"user_id"`
+    Email     string    `json:"email"`
+    Role      string    `json:"role" db:"role"`
+    CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// Session is a login that can be refreshed until it expires or is revoked
+type Session struct {
+    ID         string    `json:"id" db:"id"`
+    UserID     int64     `json:"user_id" db:"user_id"`
+    Device     string    `json:"device"`
+    UserAgent  string    `json:"user_agent" db:"user_agent"`
+    IPAddress  string    `json:"ip_address" db:"ip_address"`
+    Current    bool      `json:"current"`
+    LastUsedAt time.Time `json:"last_used_at" db:"last_used_at"`
+    ExpiresAt  time.Time `json:"expires_at" db:"expires_at"`
+    CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+// LoginRecord is one successful login, kept for the user's security review
+type LoginRecord struct {
+    ID         int64     `json:"id" db:"id"`
+    SessionID  string    `json:"session_id,omitempty" db:"session_id"`
+    Device     string    `json:"device" db:"device"`
+    UserAgent  string    `json:"user_agent" db:"user_agent"`
+    IPAddress  string    `json:"ip_address" db:"ip_address"`
+    Country    string    `json:"country,omitempty" db:"country"`
+    NewDevice  bool      `json:"new_device" db:"new_device"`
+    NewCountry bool      `json:"new_country" db:"new_country"`
+    Current    bool      `json:"current"`
+    CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+type DomainClaim struct {
+    ID            int64      `json:"id" db:"id"`
+    UserID        int64      `json:"user_id" db:"user_id"`
+    Hostname      string     `json:"hostname" db:"hostname"`
+    Token         string     `json:"token" db:"token"`
+    VerifiedAt    *time.Time `json:"verified_at,omitempty" db:"verified_at"`
+    LastCheckedAt *time.Time `json:"last_checked_at,omitempty" db:"last_checked_at"`
+    CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+    UpdatedAt     time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+type Webhook struct {
+    ID        int64     `json:"id" db:"id"`
+    URL       string    `json:"url" db:"url"`
+    Secret    string    `json:"-" db:"secret"`
+    Events    []string  `json:"events" db:"events"`
+    Active    bool      `json:"active" db:"active"`
+    CreatedAt time.Time `json:"created_at" db:"created_at"`
+    UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type WebhookDelivery struct {
+    ID            int64           `json:"id" db:"id"`
+    WebhookID     int64           `json:"webhook_id" db:"webhook_id"`
+    Event         string          `json:"event" db:"event"`
+    Payload       json.RawMessage `json:"payload" db:"payload"`
+    Status        string          `json:"status" db:"status"`
+    Attempts      int             `json:"attempts" db:"attempts"`
+    ResponseCode  *int            `json:"response_code,omitempty" db:"response_code"`
+    ResponseBody  *string         `json:"response_body,omitempty" db:"response_body"`
+    Error         *string         `json:"error,omitempty" db:"error"`
+    NextAttemptAt *time.Time      `json:"next_attempt_at,omitempty" db:"next_attempt_at"`
+    DeliveredAt   *time.Time      `json:"delivered_at,omitempty" db:"delivered_at"`
+    CreatedAt     time.Time       `json:"created_at" db:"created_at"`
+}
+// ThreatFeed is an abuse feed pulled into the global blocklist
+type ThreatFeed struct {
+    ID              int64      `json:"id" db:"id"`
+    Name            string     `json:"name" db:"name"`
+    Kind            string     `json:"kind" db:"kind"` // spamhaus_drop, abuseipdb or url
+    URL             string     `json:"url" db:"url"`
+    APIKey          string     `json:"-" db:"api_key"`
+    HasAPIKey       bool       `json:"has_api_key" db:"-"`
+    MinConfidence   int        `json:"min_confidence" db:"min_confidence"`
+    RefreshMinutes  int        `json:"refresh_minutes" db:"refresh_minutes"`
+    Enabled         bool       `json:"enabled" db:"enabled"`
+    EntryCount      int        `json:"entry_count" db:"entry_count"`
+    BlockedRequests int64      `json:"blocked_requests" db:"blocked_requests"`
+    LastBlockedAt   *time.Time `json:"last_blocked_at,omitempty" db:"last_blocked_at"`
+    CheckedAt       *time.Time `json:"checked_at,omitempty" db:"checked_at"`
+    FetchedAt       *time.Time `json:"fetched_at,omitempty" db:"fetched_at"`
+    LastError       *string    `json:"last_error,omitempty" db:"last_error"`
+    CreatedBy       *int64     `json:"created_by,omitempty" db:"created_by"`
+    CreatedAt       *time.Time `json:"created_at,omitempty" db:"created_at"`
+    UpdatedAt       *time.Time `json:"updated_at,omitempty" db:"updated_at"`
+}
+
+// ThreatAllowEntry is a range the threat feeds never block
+type ThreatAllowEntry struct {
+    ID          int64      `json:"id" db:"id"`
+    CIDR        string     `json:"cidr" db:"cidr"`
+    Description string     `json:"description" db:"description"`
+    CreatedBy   *int64     `json:"created_by,omitempty" db:"created_by"`
+    CreatedAt   *time.Time `json:"created_at,omitempty" db:"created_at"`
+}
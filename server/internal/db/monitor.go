@@ -0,0 +1,101 @@
+package db
+
+import (
+    "context"
+    "sync"
+    "time"
+
+    "github.com/jackc/pgx/v4/pgxpool"
+)
+
+// monitorTimeout bounds a single health ping
+const monitorTimeout = 5 * time.Second
+
+// MonitorStatus describes the database connection as last seen by a Monitor
+type MonitorStatus struct {
+    Healthy   bool      `json:"healthy"`
+    Since     time.Time `json:"since"`
+    LastError string    `json:"last_error,omitempty"`
+}
+
+// Monitor pings the database on an interval and logs when the connection is
+// lost and restored. The pool reconnects on its own; the monitor lets the
+// rest of the server react, e.g. by reloading state once the database is
+// back.
+type Monitor struct {
+    pool     *pgxpool.Pool
+    interval time.Duration
+
+    mu        sync.RWMutex
+    status    MonitorStatus
+    onRecover []func()
+}
+
+func NewMonitor(pool *pgxpool.Pool, interval time.Duration) *Monitor {
+    return &Monitor{
+        pool:     pool,
+        interval: interval,
+        status:   MonitorStatus{Healthy: true, Since: time.Now()},
+    }
+}
+
+// OnRecover registers fn to run after the database becomes reachable again.
+// It must be called before Start.
+func (m *Monitor) OnRecover(fn func()) {
+    m.onRecover = append(m.onRecover, fn)
+}
+
+// Status returns the last observed state of the connection
+func (m *Monitor) Status() MonitorStatus {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    return m.status
+}
+
+// Start pings the database until ctx is cancelled
+func (m *Monitor) Start(ctx context.Context) {
+    go func() {
+        ticker := time.NewTicker(m.interval)
+        defer ticker.Stop()
+
+        for {
+            select {
+            case <-ctx.Done():
+                return
+            case <-ticker.C:
+                m.check(ctx)
+            }
+        }
+    }()
+}
+
+func (m *Monitor) check(ctx context.Context) {
+    pingCtx, cancel := context.WithTimeout(ctx, monitorTimeout)
+    err := m.pool.Ping(pingCtx)
+    cancel()
+    if ctx.Err() != nil {
+        return
+    }
+
+    m.mu.Lock()
+    was := m.status
+    switch {
+    case err != nil && was.Healthy:
+        m.status = MonitorStatus{Since: time.Now(), LastError: err.Error()}
+    case err != nil:
+        m.status.LastError = err.Error()
+    case !was.Healthy:
+        m.status = MonitorStatus{Healthy: true, Since: time.Now()}
+    }
+    m.mu.Unlock()
+
+    switch {
+    case err != nil && was.Healthy:
+        logger.Error("Database connection lost, serving last loaded configuration", "error", err)
+    case err == nil && !was.Healthy:
+        logger.Info("Database connection restored", "after", time.Since(was.Since).Round(time.Second))
+        for _, fn := range m.onRecover {
+            fn()
+        }
+    }
+}
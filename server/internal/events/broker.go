@@ -0,0 +1,128 @@
+package events
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// replaySize is how many recent events are kept for clients resuming
+	// with Last-Event-ID
+	replaySize = 256
+	// subscriberBuffer is how many events may queue for a slow subscriber
+	// before further events are dropped for it
+	subscriberBuffer = 64
+)
+
+// Event is a single admin event delivered to live subscribers
+type Event struct {
+	ID   int64       `json:"id"`
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+	Time time.Time   `json:"time"`
+}
+
+// Matches reports whether the event passes a type filter. Filters are exact
+// event types or a prefix followed by ".*"; an empty filter matches all.
+func (e Event) Matches(filter []string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, f := range filter {
+		if f == "*" || f == e.Type {
+			return true
+		}
+		if strings.HasSuffix(f, ".*") && strings.HasPrefix(e.Type, strings.TrimSuffix(f, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+type subscriber struct {
+	ch     chan Event
+	filter []string
+}
+
+// Broker fans events out to in-process subscribers such as the SSE feed.
+// A nil Broker is valid and drops every event.
+type Broker struct {
+	mu     sync.Mutex
+	nextID int64
+	recent []Event
+	subs   map[*subscriber]struct{}
+}
+
+func NewBroker() *Broker {
+	// Seeding ids from the clock keeps them increasing across restarts, so a
+	// client resuming after a restart does not skip new events
+	return &Broker{
+		nextID: time.Now().UnixMilli(),
+		subs:   make(map[*subscriber]struct{}),
+	}
+}
+
+// Publish delivers an event to every matching subscriber without blocking.
+// Subscribers that have fallen behind miss the event.
+func (b *Broker) Publish(eventType string, data interface{}) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	event := Event{ID: b.nextID, Type: eventType, Data: data, Time: time.Now().UTC()}
+
+	b.recent = append(b.recent, event)
+	if len(b.recent) > replaySize {
+		b.recent = b.recent[len(b.recent)-replaySize:]
+	}
+
+	for sub := range b.subs {
+		if !event.Matches(sub.filter) {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe registers a subscriber for events matching filter. Events newer
+// than lastID that are still in the replay buffer are returned as backlog.
+// The returned function unsubscribes and must be called. A nil Broker
+// returns a closed channel.
+func (b *Broker) Subscribe(filter []string, lastID int64) (<-chan Event, []Event, func()) {
+	if b == nil {
+		ch := make(chan Event)
+		close(ch)
+		return ch, nil, func() {}
+	}
+
+	sub := &subscriber{ch: make(chan Event, subscriberBuffer), filter: filter}
+
+	b.mu.Lock()
+	var backlog []Event
+	if lastID > 0 {
+		for _, event := range b.recent {
+			if event.ID > lastID && event.Matches(filter) {
+				backlog = append(backlog, event)
+			}
+		}
+	}
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return sub.ch, backlog, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, sub)
+			b.mu.Unlock()
+		})
+	}
+}
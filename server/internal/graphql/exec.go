@@ -0,0 +1,334 @@
+// Package graphql implements the subset of GraphQL needed to serve read-only
+// queries over resolver functions: operations, variables, aliases, arguments,
+// fragments and the @skip/@include directives. There is no type system or
+// introspection; unknown fields are reported as errors at execution time.
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// maxDepth bounds how deeply selections may nest
+const maxDepth = 12
+
+// Resolver computes the value of a field from its arguments
+type Resolver func(ctx context.Context, args map[string]interface{}) (interface{}, error)
+
+// Object is a GraphQL object. Its values are static values or Resolvers;
+// nested objects are Objects or slices of them.
+type Object map[string]interface{}
+
+// Request is a GraphQL request as sent over HTTP
+type Request struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+// Error is a GraphQL error with the response path of the failing field
+type Error struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// Response is the result of executing a request. Data is omitted when the
+// request could not be executed at all.
+type Response struct {
+	Data   interface{} `json:"data,omitempty"`
+	Errors []Error     `json:"errors,omitempty"`
+}
+
+// orderedMap keeps response fields in the order they were requested
+type orderedMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (m *orderedMap) set(key string, value interface{}) {
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		buf.Write(k)
+		buf.WriteByte(':')
+		v, err := json.Marshal(m.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+type executor struct {
+	doc    *document
+	vars   map[string]interface{}
+	errors []Error
+}
+
+// Execute runs a query operation of req against the root query object.
+// Mutations and subscriptions are rejected.
+func Execute(ctx context.Context, query Object, req Request) Response {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return Response{Errors: []Error{{Message: "Syntax error: " + err.Error()}}}
+	}
+
+	var op *operation
+	for _, candidate := range doc.operations {
+		if req.OperationName == "" || candidate.name == req.OperationName {
+			if op != nil {
+				return Response{Errors: []Error{{Message: "operationName is required when the document has several operations"}}}
+			}
+			op = candidate
+		}
+	}
+	if op == nil {
+		return Response{Errors: []Error{{Message: fmt.Sprintf("Unknown operation %q", req.OperationName)}}}
+	}
+	if op.kind != "query" {
+		return Response{Errors: []Error{{Message: fmt.Sprintf("%s operations are not supported", op.kind)}}}
+	}
+
+	vars := map[string]interface{}{}
+	for _, def := range op.vars {
+		v, ok := req.Variables[def.name]
+		if !ok && def.hasDefault {
+			v, ok = def.def, true
+		}
+		if (!ok || v == nil) && def.nonNull {
+			return Response{Errors: []Error{{Message: fmt.Sprintf("Variable $%s is required", def.name)}}}
+		}
+		if ok {
+			vars[def.name] = v
+		}
+	}
+
+	e := &executor{doc: doc, vars: vars}
+	data := e.selectObject(ctx, query, op.selections, nil, 0)
+	return Response{Data: data, Errors: e.errors}
+}
+
+func (e *executor) fail(path []interface{}, format string, args ...interface{}) {
+	e.errors = append(e.errors, Error{
+		Message: fmt.Sprintf(format, args...),
+		Path:    append([]interface{}{}, path...),
+	})
+}
+
+// collectFields flattens fragments and groups fields by response key
+func (e *executor) collectFields(selections []selection, keys *[]string, groups map[string][]*field, visited map[string]bool) error {
+	for _, sel := range selections {
+		switch s := sel.(type) {
+		case *field:
+			include, err := e.included(s.directives)
+			if err != nil {
+				return err
+			}
+			if !include {
+				continue
+			}
+			key := s.responseKey()
+			if _, ok := groups[key]; !ok {
+				*keys = append(*keys, key)
+			}
+			groups[key] = append(groups[key], s)
+		case *fragmentSpread:
+			include, err := e.included(s.directives)
+			if err != nil {
+				return err
+			}
+			if !include || visited[s.name] {
+				continue
+			}
+			frag, ok := e.doc.fragments[s.name]
+			if !ok {
+				return fmt.Errorf("Unknown fragment %q", s.name)
+			}
+			visited[s.name] = true
+			if err := e.collectFields(frag.selections, keys, groups, visited); err != nil {
+				return err
+			}
+		case *inlineFragment:
+			include, err := e.included(s.directives)
+			if err != nil {
+				return err
+			}
+			if !include {
+				continue
+			}
+			if err := e.collectFields(s.selections, keys, groups, visited); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// included evaluates @skip and @include
+func (e *executor) included(dirs []directive) (bool, error) {
+	for _, d := range dirs {
+		if d.name != "skip" && d.name != "include" {
+			continue
+		}
+		v, err := e.resolveValue(d.args["if"])
+		if err != nil {
+			return false, err
+		}
+		cond, ok := v.(bool)
+		if !ok {
+			return false, fmt.Errorf("@%s requires a Boolean \"if\" argument", d.name)
+		}
+		if (d.name == "skip" && cond) || (d.name == "include" && !cond) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (e *executor) selectObject(ctx context.Context, obj Object, selections []selection, path []interface{}, depth int) interface{} {
+	if depth > maxDepth {
+		e.fail(path, "Query is nested too deeply")
+		return nil
+	}
+
+	var keys []string
+	groups := map[string][]*field{}
+	if err := e.collectFields(selections, &keys, groups, map[string]bool{}); err != nil {
+		e.fail(path, "%v", err)
+		return nil
+	}
+
+	result := &orderedMap{values: make(map[string]interface{}, len(keys))}
+	for _, key := range keys {
+		fields := groups[key]
+		f := fields[0]
+		fieldPath := append(append([]interface{}{}, path...), key)
+
+		var subSelections []selection
+		for _, other := range fields {
+			subSelections = append(subSelections, other.selections...)
+		}
+
+		value, ok := obj[f.name]
+		if !ok {
+			e.fail(fieldPath, "Cannot query field %q", f.name)
+			result.set(key, nil)
+			continue
+		}
+
+		if resolve, isResolver := value.(Resolver); isResolver {
+			args, err := e.resolveArgs(f.args)
+			if err != nil {
+				e.fail(fieldPath, "%v", err)
+				result.set(key, nil)
+				continue
+			}
+			value, err = resolve(ctx, args)
+			if err != nil {
+				e.fail(fieldPath, "%v", err)
+				result.set(key, nil)
+				continue
+			}
+		}
+
+		result.set(key, e.complete(ctx, value, f.name, subSelections, fieldPath, depth))
+	}
+	return result
+}
+
+// complete applies the sub-selection to a resolved value
+func (e *executor) complete(ctx context.Context, value interface{}, name string, selections []selection, path []interface{}, depth int) interface{} {
+	if value == nil {
+		return nil
+	}
+
+	if obj, ok := value.(Object); ok {
+		if len(selections) == 0 {
+			e.fail(path, "Field %q of object type must have a selection of subfields", name)
+			return nil
+		}
+		return e.selectObject(ctx, obj, selections, path, depth+1)
+	}
+
+	rv := reflect.ValueOf(value)
+	isBytes := rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8
+	if (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array) && !isBytes {
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return nil
+		}
+		list := make([]interface{}, rv.Len())
+		for i := range list {
+			list[i] = e.complete(ctx, rv.Index(i).Interface(), name, selections, append(path, i), depth)
+		}
+		return list
+	}
+
+	if len(selections) > 0 {
+		e.fail(path, "Field %q is a scalar and cannot have a selection", name)
+		return nil
+	}
+	return value
+}
+
+func (e *executor) resolveArgs(args map[string]interface{}) (map[string]interface{}, error) {
+	resolved := make(map[string]interface{}, len(args))
+	for name, v := range args {
+		value, err := e.resolveValue(v)
+		if err != nil {
+			return nil, err
+		}
+		resolved[name] = value
+	}
+	return resolved, nil
+}
+
+// resolveValue substitutes variables and turns enum values into strings
+func (e *executor) resolveValue(v interface{}) (interface{}, error) {
+	switch val := v.(type) {
+	case variable:
+		value, ok := e.vars[string(val)]
+		if !ok {
+			return nil, nil
+		}
+		return value, nil
+	case enumValue:
+		return string(val), nil
+	case []interface{}:
+		list := make([]interface{}, len(val))
+		for i, item := range val {
+			resolved, err := e.resolveValue(item)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = resolved
+		}
+		return list, nil
+	case map[string]interface{}:
+		obj := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			resolved, err := e.resolveValue(item)
+			if err != nil {
+				return nil, err
+			}
+			obj[k] = resolved
+		}
+		return obj, nil
+	}
+	return v, nil
+}
@@ -0,0 +1,232 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testRoot is a small schema: a list of domains, a domain looked up by ID
+// and a node whose child is itself, for nesting as deep as a query wants
+func testRoot() Object {
+	domains := []Object{
+		{"id": 1, "name": "a.example.com", "enabled": true},
+		{"id": 2, "name": "b.example.com", "enabled": false},
+	}
+	node := Object{"name": "n"}
+	node["child"] = Resolver(func(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+		return node, nil
+	})
+	return Object{
+		"domains": domains,
+		"domain": Resolver(func(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+			id, ok, err := IntArg(args, "id")
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				return nil, errors.New("id is required")
+			}
+			for _, d := range domains {
+				if int64(d["id"].(int)) == id {
+					return d, nil
+				}
+			}
+			return nil, nil
+		}),
+		"echo": Resolver(func(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+			return args["value"], nil
+		}),
+		"node": node,
+	}
+}
+
+// nested returns a query selecting node and depth levels of child below it
+func nested(depth int) string {
+	return "{ node { " + strings.Repeat("child { ", depth) + "name" + strings.Repeat(" }", depth) + " } }"
+}
+
+func TestExecute(t *testing.T) {
+	tests := []struct {
+		name string
+		req  Request
+		want string
+	}{
+		{
+			name: "fields in requested order",
+			req:  Request{Query: `{ domains { name id } }`},
+			want: `{"data":{"domains":[{"name":"a.example.com","id":1},{"name":"b.example.com","id":2}]}}`,
+		},
+		{
+			name: "aliases and arguments",
+			req:  Request{Query: `{ first: domain(id: 1) { name } second: domain(id: 2) { name } }`},
+			want: `{"data":{"first":{"name":"a.example.com"},"second":{"name":"b.example.com"}}}`,
+		},
+		{
+			name: "variables",
+			req: Request{
+				Query: `query One($id: Int!) { domain(id: $id) { name } }`,
+				// Variables arrive decoded from JSON
+				Variables: map[string]interface{}{"id": float64(2)},
+			},
+			want: `{"data":{"domain":{"name":"b.example.com"}}}`,
+		},
+		{
+			name: "variable default",
+			req:  Request{Query: `query ($id: Int = 1) { domain(id: $id) { name } }`},
+			want: `{"data":{"domain":{"name":"a.example.com"}}}`,
+		},
+		{
+			name: "literal values",
+			req:  Request{Query: `{ a: echo(value: "xé\n") b: echo(value: [1, -2.5, true, null, ENUM]) c: echo(value: {k: "v"}) }`},
+			want: `{"data":{"a":"xé\n","b":[1,-2.5,true,null,"ENUM"],"c":{"k":"v"}}}`,
+		},
+		{
+			name: "fragments",
+			req: Request{Query: `
+				query { domains { ...Names ... { enabled } } }
+				fragment Names on Domain { name }
+			`},
+			want: `{"data":{"domains":[{"name":"a.example.com","enabled":true},{"name":"b.example.com","enabled":false}]}}`,
+		},
+		{
+			name: "skip and include",
+			req: Request{
+				Query:     `query ($yes: Boolean!) { domain(id: 1) { id @skip(if: $yes) name @include(if: $yes) enabled @include(if: false) } }`,
+				Variables: map[string]interface{}{"yes": true},
+			},
+			want: `{"data":{"domain":{"name":"a.example.com"}}}`,
+		},
+		{
+			name: "operation by name",
+			req: Request{
+				Query:         `query A { domain(id: 1) { name } } query B { domain(id: 2) { name } }`,
+				OperationName: "B",
+			},
+			want: `{"data":{"domain":{"name":"b.example.com"}}}`,
+		},
+		{
+			name: "unknown field",
+			req:  Request{Query: `{ domains { name missing } }`},
+			want: `{"data":{"domains":[{"name":"a.example.com","missing":null},{"name":"b.example.com","missing":null}]},"errors":[{"message":"Cannot query field \"missing\"","path":["domains",0,"missing"]},{"message":"Cannot query field \"missing\"","path":["domains",1,"missing"]}]}`,
+		},
+		{
+			name: "resolver error",
+			req:  Request{Query: `{ domain { name } }`},
+			want: `{"data":{"domain":null},"errors":[{"message":"id is required","path":["domain"]}]}`,
+		},
+		{
+			name: "object without selection",
+			req:  Request{Query: `{ node }`},
+			want: `{"data":{"node":null},"errors":[{"message":"Field \"node\" of object type must have a selection of subfields","path":["node"]}]}`,
+		},
+		{
+			name: "scalar with selection",
+			req:  Request{Query: `{ node { name { x } } }`},
+			want: `{"data":{"node":{"name":null}},"errors":[{"message":"Field \"name\" is a scalar and cannot have a selection","path":["node","name"]}]}`,
+		},
+		{
+			name: "missing required variable",
+			req:  Request{Query: `query ($id: Int!) { domain(id: $id) { name } }`},
+			want: `{"errors":[{"message":"Variable $id is required"}]}`,
+		},
+		{
+			name: "mutation",
+			req:  Request{Query: `mutation { domain(id: 1) { name } }`},
+			want: `{"errors":[{"message":"mutation operations are not supported"}]}`,
+		},
+		{
+			name: "several operations without a name",
+			req:  Request{Query: `query A { node { name } } query B { node { name } }`},
+			want: `{"errors":[{"message":"operationName is required when the document has several operations"}]}`,
+		},
+		{
+			name: "unknown operation",
+			req:  Request{Query: `query A { node { name } }`, OperationName: "B"},
+			want: `{"errors":[{"message":"Unknown operation \"B\""}]}`,
+		},
+		{
+			name: "unknown fragment",
+			req:  Request{Query: `{ node { ...Missing } }`},
+			want: `{"data":{"node":null},"errors":[{"message":"Unknown fragment \"Missing\"","path":["node"]}]}`,
+		},
+		{
+			name: "syntax error",
+			req:  Request{Query: `{ domains { name }`},
+			want: `{"errors":[{"message":"Syntax error: unexpected end of document"}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(Execute(context.Background(), testRoot(), tt.req))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecuteDepthLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		wantErr bool
+	}{
+		{"at the limit", nested(maxDepth - 1), false},
+		{"one past the limit", nested(maxDepth), true},
+		{"far past the limit", nested(10 * maxDepth), true},
+		{
+			name: "through fragments",
+			query: `{ node { ...Deep } } fragment Deep on Node { child { child { child { child { child { child {
+				child { child { child { child { child { child { child { name } } } } } } } } } } } } } }`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := Execute(context.Background(), testRoot(), Request{Query: tt.query})
+			tooDeep := false
+			for _, e := range resp.Errors {
+				if e.Message == "Query is nested too deeply" {
+					tooDeep = true
+				} else {
+					t.Errorf("unexpected error %q", e.Message)
+				}
+			}
+			if tooDeep != tt.wantErr {
+				t.Errorf("nested too deeply = %v, want %v", tooDeep, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"empty document", ``, "document contains no operation"},
+		{"only a fragment", `fragment F on T { a }`, "document contains no operation"},
+		{"duplicate fragment", `{ a } fragment F on T { a } fragment F on T { b }`, `fragment "F" is defined more than once`},
+		{"unterminated string", `{ a(s: "abc) }`, "unterminated string"},
+		{"unexpected token", `{ a } }`, `unexpected "}" at 6`},
+		{"unclosed selection", `{ a { b }`, "unexpected end of document"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parse(tt.query)
+			if err == nil {
+				t.Fatalf("parse(%q) succeeded", tt.query)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %q, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
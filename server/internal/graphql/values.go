@@ -0,0 +1,136 @@
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+var jsonMarshaler = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// ObjectOf converts a struct or string-keyed map, typically a model, into an
+// Object keyed by its JSON field names. Fields tagged omitempty are kept so
+// they can always be selected. Nested structs and maps become Objects; types
+// with their own JSON encoding (time.Time, json.RawMessage, ...) stay scalars.
+func ObjectOf(v interface{}) (Object, error) {
+	value := convert(reflect.ValueOf(v))
+	obj, ok := value.(Object)
+	if !ok {
+		return nil, fmt.Errorf("cannot convert %T to an object", v)
+	}
+	return obj, nil
+}
+
+func convert(rv reflect.Value) interface{} {
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return nil
+	}
+	if rv.Type().Implements(jsonMarshaler) || reflect.PointerTo(rv.Type()).Implements(jsonMarshaler) {
+		return rv.Interface()
+	}
+
+	switch rv.Kind() {
+	case reflect.Struct:
+		obj := Object{}
+		addFields(obj, rv)
+		return obj
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return rv.Interface()
+		}
+		if rv.IsNil() {
+			return nil
+		}
+		obj := make(Object, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			obj[iter.Key().String()] = convert(iter.Value())
+		}
+		return obj
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return rv.Interface()
+		}
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return nil
+		}
+		list := make([]interface{}, rv.Len())
+		for i := range list {
+			list[i] = convert(rv.Index(i))
+		}
+		return list
+	}
+	return rv.Interface()
+}
+
+// addFields copies the exported fields of a struct into obj, flattening
+// embedded structs like encoding/json does
+func addFields(obj Object, rv reflect.Value) {
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			addFields(obj, rv.Field(i))
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		obj[name] = convert(rv.Field(i))
+	}
+}
+
+// IntArg reads an integer argument. ok is false when it was not given.
+func IntArg(args map[string]interface{}, name string) (n int64, ok bool, err error) {
+	switch v := args[name].(type) {
+	case nil:
+		return 0, false, nil
+	case int64:
+		return v, true, nil
+	case float64:
+		// Variables arrive as JSON numbers
+		if v != math.Trunc(v) {
+			return 0, false, fmt.Errorf("argument %q must be an integer", name)
+		}
+		return int64(v), true, nil
+	}
+	return 0, false, fmt.Errorf("argument %q must be an integer", name)
+}
+
+// StringArg reads a string argument. ok is false when it was not given.
+func StringArg(args map[string]interface{}, name string) (s string, ok bool, err error) {
+	switch v := args[name].(type) {
+	case nil:
+		return "", false, nil
+	case string:
+		return v, true, nil
+	}
+	return "", false, fmt.Errorf("argument %q must be a string", name)
+}
+
+// BoolArg reads a boolean argument. ok is false when it was not given.
+func BoolArg(args map[string]interface{}, name string) (b bool, ok bool, err error) {
+	switch v := args[name].(type) {
+	case nil:
+		return false, false, nil
+	case bool:
+		return v, true, nil
+	}
+	return false, false, fmt.Errorf("argument %q must be a boolean", name)
+}
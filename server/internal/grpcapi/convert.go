@@ -0,0 +1,188 @@
+package grpcapi
+
+import (
+	"encoding/json"
+	"net"
+	"time"
+
+	"viacortex/internal/db"
+	"viacortex/internal/grpcapi/viacortexv1"
+	"viacortex/internal/proxy"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// domainDetail is the body of GET /domains/{id}
+type domainDetail struct {
+	Domain         db.Domain                `json:"domain"`
+	BackendServers []db.BackendServer       `json:"backend_servers"`
+	IPRules        []db.IPRule              `json:"ip_rules"`
+	RateLimits     []db.RateLimit           `json:"rate_limits"`
+	Certificate    *proxy.CertificateStatus `json:"certificate"`
+}
+
+// metricsSummary is an entry of GET /metrics
+type metricsSummary struct {
+	DomainID      int64   `json:"domain_id"`
+	TotalRequests int64   `json:"total_requests"`
+	TotalErrors   int64   `json:"total_errors"`
+	ErrorRate     float64 `json:"error_rate"`
+	AvgLatency    float64 `json:"avg_latency_ms"`
+	MaxP95Latency float64 `json:"max_p95_latency_ms"`
+	MaxP99Latency float64 `json:"max_p99_latency_ms"`
+}
+
+// metricPoint is an entry of GET /metrics/{domainID}
+type metricPoint struct {
+	Timestamp  time.Time `json:"timestamp"`
+	Requests   int64     `json:"requests"`
+	Errors     int64     `json:"errors"`
+	ErrorRate  float64   `json:"error_rate"`
+	AvgLatency float64   `json:"avg_latency"`
+	P95Latency float64   `json:"p95_latency"`
+	P99Latency float64   `json:"p99_latency"`
+}
+
+func timestampOrNil(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+func domainToProto(d db.Domain) *viacortexv1.Domain {
+	return &viacortexv1.Domain{
+		Id:                   d.ID,
+		Name:                 d.Name,
+		TargetUrl:            d.TargetURL,
+		SslEnabled:           d.SSLEnabled,
+		HealthCheckEnabled:   d.HealthCheckEnabled,
+		HealthCheckInterval:  int32(d.HealthCheckInterval),
+		Enabled:              d.Enabled,
+		CustomErrorPagesJson: string(d.CustomErrorPages),
+		CreatedAt:            timestamppb.New(d.CreatedAt),
+		UpdatedAt:            timestamppb.New(d.UpdatedAt),
+	}
+}
+
+// domainFromProto converts a domain sent by a client. The custom error pages
+// must be a JSON object when set.
+func domainFromProto(d *viacortexv1.Domain) (db.Domain, error) {
+	if d == nil {
+		return db.Domain{}, status.Error(codes.InvalidArgument, "domain is required")
+	}
+	domain := db.Domain{
+		ID:                  d.Id,
+		Name:                d.Name,
+		TargetURL:           d.TargetUrl,
+		SSLEnabled:          d.SslEnabled,
+		HealthCheckEnabled:  d.HealthCheckEnabled,
+		HealthCheckInterval: int(d.HealthCheckInterval),
+		Enabled:             d.Enabled,
+	}
+	if d.CustomErrorPagesJson != "" {
+		var pages map[string]interface{}
+		if err := json.Unmarshal([]byte(d.CustomErrorPagesJson), &pages); err != nil {
+			return db.Domain{}, status.Error(codes.InvalidArgument, "custom_error_pages_json must be a JSON object")
+		}
+		domain.CustomErrorPages = json.RawMessage(d.CustomErrorPagesJson)
+	}
+	return domain, nil
+}
+
+func backendToProto(b db.BackendServer) *viacortexv1.Backend {
+	backend := &viacortexv1.Backend{
+		Id:              b.ID,
+		DomainId:        b.DomainID,
+		Scheme:          b.Scheme,
+		Ip:              b.IP.String(),
+		Port:            int32(b.Port),
+		Weight:          int32(b.Weight),
+		IsActive:        b.IsActive,
+		LastHealthCheck: timestampOrNil(b.LastHealthCheck),
+	}
+	if b.HealthStatus != nil {
+		backend.HealthStatus = *b.HealthStatus
+	}
+	return backend
+}
+
+func backendsToProto(backends []db.BackendServer) []*viacortexv1.Backend {
+	out := make([]*viacortexv1.Backend, len(backends))
+	for i, b := range backends {
+		out[i] = backendToProto(b)
+	}
+	return out
+}
+
+// backendFromProto converts a backend sent by a client
+func backendFromProto(b *viacortexv1.Backend) (db.BackendServer, error) {
+	if b == nil {
+		return db.BackendServer{}, status.Error(codes.InvalidArgument, "backend is required")
+	}
+	ip := net.ParseIP(b.Ip)
+	if ip == nil {
+		return db.BackendServer{}, status.Errorf(codes.InvalidArgument, "invalid backend IP %q", b.Ip)
+	}
+	return db.BackendServer{
+		ID:       b.Id,
+		DomainID: b.DomainId,
+		Scheme:   b.Scheme,
+		IP:       ip,
+		Port:     int(b.Port),
+		Weight:   int(b.Weight),
+		IsActive: b.IsActive,
+	}, nil
+}
+
+func backendsFromProto(backends []*viacortexv1.Backend) ([]db.BackendServer, error) {
+	out := make([]db.BackendServer, len(backends))
+	for i, b := range backends {
+		var err error
+		if out[i], err = backendFromProto(b); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func certificateToProto(c proxy.CertificateStatus) *viacortexv1.Certificate {
+	return &viacortexv1.Certificate{
+		Domain:    c.Domain,
+		Present:   c.Present,
+		Issuer:    c.Issuer,
+		Names:     c.Names,
+		NotBefore: timestampOrNil(c.NotBefore),
+		NotAfter:  timestampOrNil(c.NotAfter),
+		Expired:   c.Expired,
+	}
+}
+
+func detailToProto(d domainDetail) *viacortexv1.DomainDetail {
+	detail := &viacortexv1.DomainDetail{
+		Domain:         domainToProto(d.Domain),
+		BackendServers: backendsToProto(d.BackendServers),
+	}
+	for _, rule := range d.IPRules {
+		detail.IpRules = append(detail.IpRules, &viacortexv1.IPRule{
+			Id:          rule.ID,
+			IpRange:     rule.IPRange.String(),
+			RuleType:    rule.RuleType,
+			Description: rule.Description,
+		})
+	}
+	for _, limit := range d.RateLimits {
+		detail.RateLimits = append(detail.RateLimits, &viacortexv1.RateLimit{
+			Id:                limit.ID,
+			RequestsPerSecond: int32(limit.RequestsPerSecond),
+			BurstSize:         int32(limit.BurstSize),
+			PerIp:             limit.PerIP,
+		})
+	}
+	if d.Certificate != nil {
+		detail.Certificate = certificateToProto(*d.Certificate)
+	}
+	return detail
+}
@@ -0,0 +1,12 @@
+// Package grpcapi serves the Management service defined in
+// proto/viacortex/v1/management.proto for tooling that prefers typed clients
+// over REST.
+//
+// Each call is dispatched in-process to the REST API under /api/v1 with the
+// caller's "authorization", "x-api-key" and "x-organization-id" metadata as
+// request headers. Authentication, authorization, audit logging and webhooks
+// are therefore exactly those of the matching REST endpoint, and HTTP errors
+// map to gRPC status codes.
+package grpcapi
+
+//go:generate protoc -I ../../proto --go_out=. --go_opt=module=viacortex/internal/grpcapi --go-grpc_out=. --go-grpc_opt=module=viacortex/internal/grpcapi viacortex/v1/management.proto
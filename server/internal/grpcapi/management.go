@@ -0,0 +1,324 @@
+package grpcapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"viacortex/internal/db"
+	"viacortex/internal/grpcapi/viacortexv1"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// defaultStreamInterval and minStreamInterval bound how often
+	// StreamMetrics sends points
+	defaultStreamInterval = 10 * time.Second
+	minStreamInterval     = 5 * time.Second
+	// streamWindow is how far back StreamMetrics looks for a domain's latest
+	// point; metrics are flushed every minute while a domain has traffic
+	streamWindow = "1h"
+)
+
+// ListDomains returns the domains visible to the caller with their backends.
+// IP rules, rate limits and certificates are only part of GetDomain.
+func (s *Server) ListDomains(ctx context.Context, req *viacortexv1.ListDomainsRequest) (*viacortexv1.ListDomainsResponse, error) {
+	var domains []db.Domain
+	if err := s.call(ctx, http.MethodGet, "/domains", nil, &domains); err != nil {
+		return nil, err
+	}
+	resp := &viacortexv1.ListDomainsResponse{}
+	for _, d := range domains {
+		if req.Enabled != nil && d.Enabled != *req.Enabled {
+			continue
+		}
+		for i := range d.BackendServers {
+			d.BackendServers[i].DomainID = d.ID
+		}
+		resp.Domains = append(resp.Domains, &viacortexv1.DomainDetail{
+			Domain:         domainToProto(d),
+			BackendServers: backendsToProto(d.BackendServers),
+		})
+	}
+	return resp, nil
+}
+
+// GetDomain returns a domain by ID or name with all of its configuration
+func (s *Server) GetDomain(ctx context.Context, req *viacortexv1.GetDomainRequest) (*viacortexv1.DomainDetail, error) {
+	switch key := req.Key.(type) {
+	case *viacortexv1.GetDomainRequest_Id:
+		return s.domainDetail(ctx, key.Id)
+	case *viacortexv1.GetDomainRequest_Name:
+		var detail domainDetail
+		if err := s.call(ctx, http.MethodGet, "/domains/by-name/"+url.PathEscape(key.Name), nil, &detail); err != nil {
+			return nil, err
+		}
+		return detailToProto(detail), nil
+	}
+	return nil, status.Error(codes.InvalidArgument, "id or name is required")
+}
+
+// CreateDomain creates a domain with optional backends
+func (s *Server) CreateDomain(ctx context.Context, req *viacortexv1.CreateDomainRequest) (*viacortexv1.DomainDetail, error) {
+	domain, err := domainFromProto(req.Domain)
+	if err != nil {
+		return nil, err
+	}
+	backends, err := backendsFromProto(req.BackendServers)
+	if err != nil {
+		return nil, err
+	}
+
+	var created struct {
+		Domain db.Domain `json:"domain"`
+	}
+	body := map[string]interface{}{"domain": domain, "backend_servers": backends}
+	if err := s.call(ctx, http.MethodPost, "/domains", body, &created); err != nil {
+		return nil, err
+	}
+	return s.domainDetail(ctx, created.Domain.ID)
+}
+
+// UpdateDomain replaces a domain's settings, and its backends when any are
+// given
+func (s *Server) UpdateDomain(ctx context.Context, req *viacortexv1.UpdateDomainRequest) (*viacortexv1.DomainDetail, error) {
+	domain, err := domainFromProto(req.Domain)
+	if err != nil {
+		return nil, err
+	}
+	backends, err := backendsFromProto(req.BackendServers)
+	if err != nil {
+		return nil, err
+	}
+	// The REST API reconciles the backends with the list it is sent, so an
+	// empty list would remove them all
+	if len(backends) == 0 {
+		if backends, err = s.backends(ctx, domain.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	body := map[string]interface{}{"domain": domain, "backend_servers": backends}
+	if err := s.call(ctx, http.MethodPut, domainPath(domain.ID), body, nil); err != nil {
+		return nil, err
+	}
+	return s.domainDetail(ctx, domain.ID)
+}
+
+// DeleteDomain deletes a domain and everything configured for it
+func (s *Server) DeleteDomain(ctx context.Context, req *viacortexv1.DeleteDomainRequest) (*emptypb.Empty, error) {
+	if err := s.call(ctx, http.MethodDelete, domainPath(req.Id), nil, nil); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// SetDomainEnabled pauses or resumes a domain
+func (s *Server) SetDomainEnabled(ctx context.Context, req *viacortexv1.SetDomainEnabledRequest) (*viacortexv1.Domain, error) {
+	action := "/disable"
+	if req.Enabled {
+		action = "/enable"
+	}
+	if err := s.call(ctx, http.MethodPost, domainPath(req.Id)+action, nil, nil); err != nil {
+		return nil, err
+	}
+	detail, err := s.domainDetail(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return detail.Domain, nil
+}
+
+// ListBackends returns the backends of a domain
+func (s *Server) ListBackends(ctx context.Context, req *viacortexv1.ListBackendsRequest) (*viacortexv1.ListBackendsResponse, error) {
+	backends, err := s.backends(ctx, req.DomainId)
+	if err != nil {
+		return nil, err
+	}
+	return &viacortexv1.ListBackendsResponse{Backends: backendsToProto(backends)}, nil
+}
+
+// AddBackend adds a backend to a domain
+func (s *Server) AddBackend(ctx context.Context, req *viacortexv1.AddBackendRequest) (*viacortexv1.Backend, error) {
+	backend, err := backendFromProto(req.Backend)
+	if err != nil {
+		return nil, err
+	}
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	if err := s.call(ctx, http.MethodPost, backendsPath(req.DomainId), backend, &created); err != nil {
+		return nil, err
+	}
+	return s.backend(ctx, req.DomainId, created.ID)
+}
+
+// UpdateBackend replaces the settings of a domain's backend
+func (s *Server) UpdateBackend(ctx context.Context, req *viacortexv1.UpdateBackendRequest) (*viacortexv1.Backend, error) {
+	backend, err := backendFromProto(req.Backend)
+	if err != nil {
+		return nil, err
+	}
+	path := fmt.Sprintf("%s/%d", backendsPath(req.DomainId), backend.ID)
+	if err := s.call(ctx, http.MethodPut, path, backend, nil); err != nil {
+		return nil, err
+	}
+	return s.backend(ctx, req.DomainId, backend.ID)
+}
+
+// DeleteBackend removes a backend from a domain
+func (s *Server) DeleteBackend(ctx context.Context, req *viacortexv1.DeleteBackendRequest) (*emptypb.Empty, error) {
+	path := fmt.Sprintf("%s/%d", backendsPath(req.DomainId), req.Id)
+	if err := s.call(ctx, http.MethodDelete, path, nil, nil); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// GetCertificate returns the certificate status of a domain. Domains without
+// SSL report no certificate.
+func (s *Server) GetCertificate(ctx context.Context, req *viacortexv1.GetCertificateRequest) (*viacortexv1.Certificate, error) {
+	var detail domainDetail
+	if err := s.call(ctx, http.MethodGet, domainPath(req.DomainId), nil, &detail); err != nil {
+		return nil, err
+	}
+	if detail.Certificate == nil {
+		return &viacortexv1.Certificate{Domain: detail.Domain.Name}, nil
+	}
+	return certificateToProto(*detail.Certificate), nil
+}
+
+// GetMetricsSummary aggregates the request metrics of the visible domains
+func (s *Server) GetMetricsSummary(ctx context.Context, req *viacortexv1.MetricsRequest) (*viacortexv1.MetricsSummaryResponse, error) {
+	path := "/metrics"
+	if req.Range != "" {
+		path += "?range=" + url.QueryEscape(req.Range)
+	}
+	var summary []metricsSummary
+	if err := s.call(ctx, http.MethodGet, path, nil, &summary); err != nil {
+		return nil, err
+	}
+	resp := &viacortexv1.MetricsSummaryResponse{}
+	for _, m := range summary {
+		resp.Domains = append(resp.Domains, &viacortexv1.DomainMetricsSummary{
+			DomainId:        m.DomainID,
+			TotalRequests:   m.TotalRequests,
+			TotalErrors:     m.TotalErrors,
+			ErrorRate:       m.ErrorRate,
+			AvgLatencyMs:    m.AvgLatency,
+			MaxP95LatencyMs: m.MaxP95Latency,
+			MaxP99LatencyMs: m.MaxP99Latency,
+		})
+	}
+	return resp, nil
+}
+
+// StreamMetrics sends the latest point of every requested domain, or of all
+// visible domains, each interval until the client cancels. Every round is
+// authorized anew, so a revoked session or key ends the stream.
+func (s *Server) StreamMetrics(req *viacortexv1.StreamMetricsRequest, stream viacortexv1.Management_StreamMetricsServer) error {
+	interval := defaultStreamInterval
+	if req.IntervalSeconds != 0 {
+		interval = time.Duration(req.IntervalSeconds) * time.Second
+	}
+	if interval < minStreamInterval {
+		return status.Errorf(codes.InvalidArgument, "interval_seconds must be at least %d", int(minStreamInterval.Seconds()))
+	}
+
+	ctx := stream.Context()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		ids := req.DomainIds
+		if len(ids) == 0 {
+			var domains []db.Domain
+			if err := s.call(ctx, http.MethodGet, "/domains", nil, &domains); err != nil {
+				return err
+			}
+			for _, d := range domains {
+				ids = append(ids, d.ID)
+			}
+		}
+
+		for _, id := range ids {
+			var points []metricPoint
+			path := fmt.Sprintf("/metrics/%d?range=%s", id, streamWindow)
+			if err := s.call(ctx, http.MethodGet, path, nil, &points); err != nil {
+				return err
+			}
+			// Newest first
+			if len(points) == 0 {
+				continue
+			}
+			p := points[0]
+			if err := stream.Send(&viacortexv1.MetricPoint{
+				DomainId:     id,
+				Timestamp:    timestamppb.New(p.Timestamp),
+				Requests:     p.Requests,
+				Errors:       p.Errors,
+				ErrorRate:    p.ErrorRate,
+				AvgLatencyMs: p.AvgLatency,
+				P95LatencyMs: p.P95Latency,
+				P99LatencyMs: p.P99Latency,
+			}); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// domainDetail loads a domain with all of its configuration
+func (s *Server) domainDetail(ctx context.Context, id int64) (*viacortexv1.DomainDetail, error) {
+	var detail domainDetail
+	if err := s.call(ctx, http.MethodGet, domainPath(id), nil, &detail); err != nil {
+		return nil, err
+	}
+	return detailToProto(detail), nil
+}
+
+// backends returns the backends of a domain
+func (s *Server) backends(ctx context.Context, domainID int64) ([]db.BackendServer, error) {
+	var backends []db.BackendServer
+	if err := s.call(ctx, http.MethodGet, backendsPath(domainID), nil, &backends); err != nil {
+		return nil, err
+	}
+	// The list leaves out the domain it belongs to
+	for i := range backends {
+		backends[i].DomainID = domainID
+	}
+	return backends, nil
+}
+
+// backend returns one backend of a domain
+func (s *Server) backend(ctx context.Context, domainID, id int64) (*viacortexv1.Backend, error) {
+	backends, err := s.backends(ctx, domainID)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range backends {
+		if b.ID == id {
+			return backendToProto(b), nil
+		}
+	}
+	return nil, status.Error(codes.NotFound, "Backend server not found")
+}
+
+func domainPath(id int64) string {
+	return fmt.Sprintf("/domains/%d", id)
+}
+
+func backendsPath(domainID int64) string {
+	return domainPath(domainID) + "/backends"
+}
@@ -0,0 +1,175 @@
+package grpcapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"viacortex/internal/grpcapi/viacortexv1"
+	"viacortex/internal/logging"
+	"viacortex/internal/middleware"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+var logger = logging.For("grpc")
+
+// apiPrefix is the REST API version the service is served from
+const apiPrefix = "/api/v1"
+
+// forwardedHeaders are copied from the call's metadata to the REST request
+var forwardedHeaders = []string{"Authorization", "X-API-Key", "X-Organization-ID", "User-Agent"}
+
+// Server implements the Management service on top of the REST API
+type Server struct {
+	viacortexv1.UnimplementedManagementServer
+
+	api http.Handler
+}
+
+// NewServer creates the service. api is the admin API router, which serves
+// the REST endpoints under /api/v1.
+func NewServer(api http.Handler) *Server {
+	return &Server{api: api}
+}
+
+// NewGRPCServer returns a gRPC server offering the Management service. It
+// uses TLS when tlsConfig is non-nil.
+func NewGRPCServer(api http.Handler, tlsConfig *tls.Config) *grpc.Server {
+	var opts []grpc.ServerOption
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	s := grpc.NewServer(opts...)
+	viacortexv1.RegisterManagementServer(s, NewServer(api))
+	return s
+}
+
+// call sends a request to the REST API as the caller and decodes the JSON
+// response into out, which may be nil. Error responses are returned as a
+// gRPC status.
+func (s *Server) call(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader = http.NoBody
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "encoding request: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, apiPrefix+path, reader)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid request: %v", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, name := range forwardedHeaders {
+			if values := md.Get(strings.ToLower(name)); len(values) > 0 {
+				req.Header.Set(name, values[0])
+			}
+		}
+	}
+	// Rate limits, login alerts and the audit log see the client's address
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		req.RemoteAddr = p.Addr.String()
+	}
+
+	rec := &recorder{header: http.Header{}, status: http.StatusOK}
+	s.api.ServeHTTP(rec, req)
+
+	if rec.status >= http.StatusBadRequest {
+		return apiError(rec.status, rec.body.Bytes())
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(rec.body.Bytes(), out); err != nil {
+		logger.Error("Decoding API response failed", "method", method, "path", path, "error", err)
+		return status.Error(codes.Internal, "unexpected response from the API")
+	}
+	return nil
+}
+
+// apiError converts a REST error response to a gRPC status. The v1 API
+// answers with an error envelope, whose message becomes the status message.
+func apiError(code int, body []byte) error {
+	var envelope middleware.ErrorResponse
+	if err := json.Unmarshal(body, &envelope); err == nil && envelope.Message != "" {
+		if envelope.Code == "quota_exceeded" {
+			return status.Error(codes.ResourceExhausted, envelope.Message)
+		}
+		return status.Error(statusCode(code), envelope.Message)
+	}
+	message := strings.TrimSpace(string(body))
+	if message == "" {
+		message = http.StatusText(code)
+	}
+	return status.Error(statusCode(code), message)
+}
+
+// statusCode maps an HTTP status to the closest gRPC code
+func statusCode(code int) codes.Code {
+	switch code {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity, http.StatusRequestEntityTooLarge:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusPreconditionFailed, http.StatusPreconditionRequired:
+		return codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	}
+	if code >= http.StatusInternalServerError {
+		return codes.Internal
+	}
+	return codes.Unknown
+}
+
+// recorder keeps the response of an in-process API request
+type recorder struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *recorder) Header() http.Header {
+	return r.header
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.wroteHeader {
+		return
+	}
+	r.status, r.wroteHeader = status, true
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(p)
+}
@@ -0,0 +1,134 @@
+package grpcapi
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+
+	"viacortex/internal/grpcapi/viacortexv1"
+	"viacortex/internal/middleware"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// dial serves the Management service on top of api and returns a client
+func dial(t *testing.T, api http.Handler) viacortexv1.ManagementClient {
+	t.Helper()
+	ln := bufconn.Listen(1 << 20)
+	srv := NewGRPCServer(api, nil)
+	go srv.Serve(ln)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return ln.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return viacortexv1.NewManagementClient(conn)
+}
+
+func TestCallForwardsCredentials(t *testing.T) {
+	var got http.Header
+	var path string
+	api := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, path = r.Header, r.URL.Path
+		json.NewEncoder(w).Encode([]map[string]interface{}{
+			{"id": 1, "name": "a.example.com", "enabled": true,
+				"backend_servers": []map[string]interface{}{{"id": 7, "scheme": "http", "ip": "10.0.0.1", "port": 8080}}},
+			{"id": 2, "name": "b.example.com", "enabled": false},
+		})
+	})
+	client := dial(t, api)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(),
+		"authorization", "Bearer token", "x-organization-id", "3")
+	enabled := true
+	resp, err := client.ListDomains(ctx, &viacortexv1.ListDomainsRequest{Enabled: &enabled})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if path != "/api/v1/domains" {
+		t.Errorf("path = %q, want /api/v1/domains", path)
+	}
+	if got.Get("Authorization") != "Bearer token" || got.Get("X-Organization-ID") != "3" {
+		t.Errorf("credentials not forwarded: %v", got)
+	}
+	if len(resp.Domains) != 1 || resp.Domains[0].Domain.Name != "a.example.com" {
+		t.Fatalf("domains = %v, want only the enabled one", resp.Domains)
+	}
+	backends := resp.Domains[0].BackendServers
+	if len(backends) != 1 || backends[0].Ip != "10.0.0.1" || backends[0].DomainId != 1 {
+		t.Errorf("backends = %v", backends)
+	}
+}
+
+func TestCallMapsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		code    string
+		message string
+		want    codes.Code
+	}{
+		{"unauthenticated", http.StatusUnauthorized, "", "Invalid token", codes.Unauthenticated},
+		{"missing scope", http.StatusForbidden, "", "API key missing scope domains:read", codes.PermissionDenied},
+		{"quota", http.StatusForbidden, "quota_exceeded", "Quota exceeded: at most 5 domains allowed", codes.ResourceExhausted},
+		{"not found", http.StatusNotFound, "", "Domain not found", codes.NotFound},
+		{"precondition", http.StatusPreconditionFailed, "", "Domain was changed", codes.FailedPrecondition},
+		{"server error", http.StatusInternalServerError, "", "Failed to fetch domain", codes.Internal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				code := tt.code
+				if code == "" {
+					code = middleware.ErrorCode(tt.status)
+				}
+				middleware.WriteError(w, r, tt.status, code, tt.message, nil)
+			})
+			client := dial(t, api)
+
+			_, err := client.GetDomain(context.Background(), &viacortexv1.GetDomainRequest{
+				Key: &viacortexv1.GetDomainRequest_Id{Id: 1},
+			})
+			st := status.Convert(err)
+			if st.Code() != tt.want || st.Message() != tt.message {
+				t.Errorf("got %v %q, want %v %q", st.Code(), st.Message(), tt.want, tt.message)
+			}
+		})
+	}
+}
+
+func TestAPIErrorPlainText(t *testing.T) {
+	st := status.Convert(apiError(http.StatusServiceUnavailable, []byte("upstream down\n")))
+	if st.Code() != codes.Unavailable || st.Message() != "upstream down" {
+		t.Errorf("got %v %q", st.Code(), st.Message())
+	}
+	if st := status.Convert(apiError(http.StatusNotFound, nil)); st.Message() != "Not Found" {
+		t.Errorf("empty body message = %q, want the status text", st.Message())
+	}
+}
+
+func TestStreamMetricsRejectsShortInterval(t *testing.T) {
+	client := dial(t, http.NotFoundHandler())
+	stream, err := client.StreamMetrics(context.Background(), &viacortexv1.StreamMetricsRequest{IntervalSeconds: 1})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got %v, want InvalidArgument", err)
+	}
+}
@@ -3,21 +3,29 @@ package healthcheck
 import (
     "context"
     "fmt"
-    "log"
     "net"
     "net/http"
     "net/netip"
     "sync"
     "time"
 
+    "viacortex/internal/logging"
+    "viacortex/internal/reporting"
+    "viacortex/internal/webhooks"
+
     "github.com/jackc/pgx/v4/pgxpool"
 )
 
+var logger = logging.For("healthcheck")
+
 type Checker struct {
     db        *pgxpool.Pool
     client    *http.Client
     stopChan  chan struct{}
     wg        sync.WaitGroup
+    webhooks  *webhooks.Dispatcher
+    interval  time.Duration
+    isLeader  func() bool
 }
 
 func NewChecker(db *pgxpool.Pool) *Checker {
@@ -34,19 +42,43 @@ func NewChecker(db *pgxpool.Pool) *Checker {
             },
         },
         stopChan: make(chan struct{}),
+        interval: 30 * time.Second,
     }
 }
 
+// SetInterval sets how often backends are checked. It must be called
+// before Start.
+func (c *Checker) SetInterval(d time.Duration) {
+    c.interval = d
+}
+
+// SetWebhooks makes the checker emit backend health transitions as webhook events
+func (c *Checker) SetWebhooks(d *webhooks.Dispatcher) {
+    c.webhooks = d
+}
+
+// SetLeader makes the checker run only while isLeader reports true, so a
+// cluster checks each backend once
+func (c *Checker) SetLeader(isLeader func() bool) {
+    c.isLeader = isLeader
+}
+
+func (c *Checker) leading() bool {
+    return c.isLeader == nil || c.isLeader()
+}
+
 func (c *Checker) Start(ctx context.Context) {
     c.wg.Add(1)
     go func() {
         defer c.wg.Done()
         
         // Check immediately on startup
-        c.checkAllBackends(ctx)
+        if c.leading() {
+            c.CheckAll(ctx)
+        }
         
         // Then set up periodic checks
-        ticker := time.NewTicker(30 * time.Second)
+        ticker := time.NewTicker(c.interval)
         defer ticker.Stop()
 
         for {
@@ -56,7 +88,9 @@ func (c *Checker) Start(ctx context.Context) {
             case <-c.stopChan:
                 return
             case <-ticker.C:
-                c.checkAllBackends(ctx)
+                if c.leading() {
+                    c.CheckAll(ctx)
+                }
             }
         }
     }()
@@ -80,7 +114,7 @@ func (c *Checker) checkTCPHealth(ctx context.Context, ip string, port int) strin
         var d net.Dialer
         conn, err := d.DialContext(timeoutCtx, "tcp", address)
         if err != nil {
-            log.Printf("TCP health check failed for %s (attempt %d): %v", address, attempts+1, err)
+            logger.Debug("TCP health check failed", "address", address, "attempt", attempts+1, "error", err)
             if attempts < 1 {
                 time.Sleep(time.Second)
                 continue
@@ -109,7 +143,7 @@ func (c *Checker) checkBackendHealth(ctx context.Context, scheme string, ip neti
     for attempts := 0; attempts < 2; attempts++ {
         req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
         if err != nil {
-            log.Printf("Error creating health check request: %v", err)
+            logger.Error("Creating health check request failed", "error", err)
             continue
         }
         
@@ -119,7 +153,7 @@ func (c *Checker) checkBackendHealth(ctx context.Context, scheme string, ip neti
 
         resp, err := c.client.Do(req)
         if err != nil {
-            log.Printf("Health check failed for %s (attempt %d): %v", url, attempts+1, err)
+            logger.Debug("Health check failed", "url", url, "attempt", attempts+1, "error", err)
             if attempts < 1 {
                 time.Sleep(time.Second)
                 continue
@@ -141,38 +175,42 @@ func (c *Checker) checkBackendHealth(ctx context.Context, scheme string, ip neti
     return "unhealthy"
 }
 
-func (c *Checker) checkAllBackends(ctx context.Context) {
+// CheckAll checks every active backend of the enabled domains once. It
+// fails only when the backends cannot be listed.
+func (c *Checker) CheckAll(ctx context.Context) error {
     rows, err := c.db.Query(ctx, `
         SELECT 
             d.id, d.health_check_interval,
             b.id, b.scheme, 
             host(b.ip), -- Use host() to get just the IP without CIDR
-            b.port
+            b.port, COALESCE(b.health_status, '')
         FROM domains d
         JOIN backend_servers b ON b.domain_id = d.id
         WHERE d.health_check_enabled = true 
+        AND d.enabled = true
         AND b.is_active = true
     `)
     if err != nil {
-        log.Printf("Health check query error: %v", err)
-        return
+        logger.Error("Listing backends to check failed", "error", err)
+        reporting.Error(err, map[string]string{"job": "healthcheck"})
+        return err
     }
     defer rows.Close()
 
     for rows.Next() {
         var domainID, interval, serverID, port int
-        var scheme, ipStr string
+        var scheme, ipStr, previousStatus string
 
-        err := rows.Scan(&domainID, &interval, &serverID, &scheme, &ipStr, &port)
+        err := rows.Scan(&domainID, &interval, &serverID, &scheme, &ipStr, &port, &previousStatus)
         if err != nil {
-            log.Printf("Error scanning health check row: %v", err)
+            logger.Error("Reading backend to check failed", "error", err)
             continue
         }
 
         // Parse IP address
         ip, err := netip.ParseAddr(ipStr)
         if err != nil {
-            log.Printf("Error parsing IP address %s: %v", ipStr, err)
+            logger.Warn("Invalid backend IP address", "ip", ipStr, "error", err)
             continue
         }
 
@@ -189,12 +227,31 @@ func (c *Checker) checkAllBackends(ctx context.Context) {
         `, status, serverID)
         
         if err != nil {
-            log.Printf("Error updating backend status: %v", err)
+            logger.Error("Updating backend status failed", "error", err)
+            reporting.Error(err, map[string]string{"job": "healthcheck"})
         }
 
         // Log status changes
         if err == nil {
-            log.Printf("Backend %s:%d health status: %s", ip.String(), port, status)
+            logger.Debug("Backend checked", "backend", ip.String(), "port", port, "status", status)
+        }
+
+        // Notify webhooks when a backend changes state
+        if previousStatus != "" && previousStatus != status {
+            event := webhooks.EventBackendHealthy
+            if status == "unhealthy" {
+                event = webhooks.EventBackendUnhealthy
+            }
+            c.webhooks.Emit(event, map[string]interface{}{
+                "domain_id":       domainID,
+                "backend_id":      serverID,
+                "scheme":          scheme,
+                "ip":              ip.String(),
+                "port":            port,
+                "status":          status,
+                "previous_status": previousStatus,
+            })
         }
     }
-}
\ No newline at end of file
+    return rows.Err()
+}
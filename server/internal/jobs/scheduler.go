@@ -0,0 +1,258 @@
+// Package jobs runs the server's periodic background work, such as domain
+// reloads and health checks, and reports how each job last went.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"viacortex/internal/logging"
+	"viacortex/internal/reporting"
+)
+
+var logger = logging.For("jobs")
+
+var (
+	// ErrUnknownJob is returned when triggering a job that is not registered
+	ErrUnknownJob = errors.New("unknown job")
+	// ErrRunning is returned when triggering a job that is already running
+	ErrRunning = errors.New("job is already running")
+)
+
+// Option configures a job when it is added
+type Option func(*job)
+
+// WithJitter delays each run by a random duration of up to d, so nodes
+// started together do not hit the database at the same moment
+func WithJitter(d time.Duration) Option {
+	return func(j *job) { j.jitter = d }
+}
+
+// LeaderOnly runs the job only on the cluster leader, so a cluster does the
+// work once. Manual triggers run on any node.
+func LeaderOnly() Option {
+	return func(j *job) { j.leaderOnly = true }
+}
+
+// Delayed skips the run at start, for jobs whose first run happens elsewhere
+func Delayed() Option {
+	return func(j *job) { j.delayed = true }
+}
+
+// Status describes a job and how its last run went
+type Status struct {
+	Name         string     `json:"name"`
+	Interval     string     `json:"interval"`
+	Jitter       string     `json:"jitter,omitempty"`
+	LeaderOnly   bool       `json:"leader_only"`
+	Running      bool       `json:"running"`
+	Runs         int64      `json:"runs"`
+	Failures     int64      `json:"failures"`
+	LastStart    *time.Time `json:"last_start,omitempty"`
+	LastDuration string     `json:"last_duration,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+	NextRun      *time.Time `json:"next_run,omitempty"`
+}
+
+type job struct {
+	name       string
+	interval   time.Duration
+	jitter     time.Duration
+	leaderOnly bool
+	delayed    bool
+	run        func(context.Context) error
+
+	mu     sync.Mutex
+	status Status
+}
+
+// Scheduler runs jobs at their intervals. A run that is still going when
+// the next one is due is not overlapped; the next run is skipped instead.
+type Scheduler struct {
+	isLeader func() bool
+
+	mu      sync.Mutex
+	jobs    map[string]*job
+	ctx     context.Context // set by Start, used by manual triggers
+	wg      sync.WaitGroup
+	started bool
+}
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{jobs: make(map[string]*job)}
+}
+
+// SetLeader tells leader-only jobs whether this node leads the cluster
+func (s *Scheduler) SetLeader(isLeader func() bool) {
+	s.isLeader = isLeader
+}
+
+// Add registers a job. It must be called before Start.
+func (s *Scheduler) Add(name string, interval time.Duration, run func(context.Context) error, opts ...Option) {
+	j := &job{name: name, interval: interval, run: run}
+	for _, opt := range opts {
+		opt(j)
+	}
+	j.status = Status{Name: name, Interval: interval.String(), LeaderOnly: j.leaderOnly}
+	if j.jitter > 0 {
+		j.status.Jitter = j.jitter.String()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		panic("jobs: Add called after Start")
+	}
+	if _, exists := s.jobs[name]; exists {
+		panic("jobs: duplicate job " + name)
+	}
+	s.jobs[name] = j
+}
+
+// Start runs every job until ctx is cancelled
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	s.ctx = ctx
+	s.started = true
+	for _, j := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(ctx, j)
+	}
+	s.mu.Unlock()
+}
+
+// Wait blocks until the job loops have stopped after ctx was cancelled
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
+
+func (s *Scheduler) loop(ctx context.Context, j *job) {
+	defer s.wg.Done()
+
+	if !j.delayed {
+		s.scheduled(ctx, j)
+	}
+	for {
+		wait := j.interval
+		if j.jitter > 0 {
+			wait += time.Duration(rand.Int63n(int64(j.jitter)))
+		}
+		next := time.Now().Add(wait)
+		j.mu.Lock()
+		j.status.NextRun = &next
+		j.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		s.scheduled(ctx, j)
+	}
+}
+
+// scheduled runs a job on its schedule, unless it belongs to the leader
+// or is still running
+func (s *Scheduler) scheduled(ctx context.Context, j *job) {
+	if j.leaderOnly && s.isLeader != nil && !s.isLeader() {
+		return
+	}
+	if !j.begin() {
+		logger.Warn("Skipping job run, the previous one is still running", "job", j.name)
+		return
+	}
+	s.execute(ctx, j)
+}
+
+// Trigger runs a job now, in the background
+func (s *Scheduler) Trigger(name string) (Status, error) {
+	s.mu.Lock()
+	j, ok := s.jobs[name]
+	ctx := s.ctx
+	s.mu.Unlock()
+	if !ok {
+		return Status{}, ErrUnknownJob
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if !j.begin() {
+		return j.snapshot(), ErrRunning
+	}
+	go s.execute(ctx, j)
+	return j.snapshot(), nil
+}
+
+// Status lists every job, sorted by name
+func (s *Scheduler) Status() []Status {
+	s.mu.Lock()
+	list := make([]Status, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		list = append(list, j.snapshot())
+	}
+	s.mu.Unlock()
+
+	sort.Slice(list, func(a, b int) bool { return list[a].Name < list[b].Name })
+	return list
+}
+
+// begin marks the job as running, unless it already is
+func (j *job) begin() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.status.Running {
+		return false
+	}
+	now := time.Now()
+	j.status.Running = true
+	j.status.LastStart = &now
+	return true
+}
+
+// execute runs a job that begin marked as running and records the outcome
+func (s *Scheduler) execute(ctx context.Context, j *job) {
+	start := time.Now()
+	err := j.safeRun(ctx)
+	duration := time.Since(start)
+
+	j.mu.Lock()
+	j.status.Running = false
+	j.status.Runs++
+	j.status.LastDuration = duration.String()
+	j.status.LastError = ""
+	if err != nil {
+		j.status.Failures++
+		j.status.LastError = err.Error()
+	}
+	j.mu.Unlock()
+
+	if err != nil {
+		logger.Error("Job failed", "job", j.name, "duration", duration, "error", err)
+		return
+	}
+	logger.Debug("Job finished", "job", j.name, "duration", duration)
+}
+
+// safeRun turns a panicking job into a failed run, so the loop continues
+func (j *job) safeRun(ctx context.Context) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			reporting.Panic(v, map[string]string{"job": j.name})
+			err = fmt.Errorf("panic: %v", v)
+		}
+	}()
+	return j.run(ctx)
+}
+
+func (j *job) snapshot() Status {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status
+}
@@ -0,0 +1,157 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitIdle waits until the named job has finished n runs
+func waitIdle(t *testing.T, s *Scheduler, name string, n int64) Status {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, status := range s.Status() {
+			if status.Name == name && !status.Running && status.Runs >= n {
+				return status
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish %d runs", name, n)
+	return Status{}
+}
+
+func TestStartRunsJobs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ran := make(chan string, 3)
+	record := func(name string) func(context.Context) error {
+		return func(context.Context) error {
+			ran <- name
+			return nil
+		}
+	}
+
+	s := NewScheduler()
+	s.SetLeader(func() bool { return false })
+	s.Add("immediate", time.Hour, record("immediate"))
+	s.Add("delayed", time.Hour, record("delayed"), Delayed())
+	s.Add("leader", time.Hour, record("leader"), LeaderOnly())
+	s.Start(ctx)
+
+	if got := <-ran; got != "immediate" {
+		t.Errorf("first run was %s, want immediate", got)
+	}
+	waitIdle(t, s, "immediate", 1)
+	cancel()
+	s.Wait()
+
+	select {
+	case got := <-ran:
+		t.Errorf("%s ran, want only the immediate job to run at start", got)
+	default:
+	}
+	for _, status := range s.Status() {
+		if status.Name != "immediate" && status.Runs != 0 {
+			t.Errorf("%s has %d runs, want 0", status.Name, status.Runs)
+		}
+		if status.NextRun == nil {
+			t.Errorf("%s has no next run", status.Name)
+		}
+	}
+}
+
+func TestTrigger(t *testing.T) {
+	release := make(chan struct{})
+	s := NewScheduler()
+	s.Add("slow", time.Hour, func(context.Context) error {
+		<-release
+		return nil
+	})
+	s.Add("failing", time.Hour, func(context.Context) error { return errors.New("backend unreachable") })
+	s.Add("panicking", time.Hour, func(context.Context) error { panic("nil map") })
+
+	if _, err := s.Trigger("missing"); err != ErrUnknownJob {
+		t.Errorf("unknown job error = %v, want ErrUnknownJob", err)
+	}
+
+	status, err := s.Trigger("slow")
+	if err != nil || !status.Running {
+		t.Fatalf("Trigger = %+v, %v, want a running job", status, err)
+	}
+	if _, err := s.Trigger("slow"); err != ErrRunning {
+		t.Errorf("second trigger error = %v, want ErrRunning", err)
+	}
+	close(release)
+	if status := waitIdle(t, s, "slow", 1); status.Failures != 0 || status.LastError != "" {
+		t.Errorf("slow job status = %+v, want a successful run", status)
+	}
+
+	s.Trigger("failing")
+	if status := waitIdle(t, s, "failing", 1); status.Failures != 1 || status.LastError != "backend unreachable" {
+		t.Errorf("failing job status = %+v", status)
+	}
+
+	s.Trigger("panicking")
+	if status := waitIdle(t, s, "panicking", 1); status.Failures != 1 || !strings.Contains(status.LastError, "panic: nil map") {
+		t.Errorf("panicking job status = %+v", status)
+	}
+}
+
+func TestStatusSorted(t *testing.T) {
+	s := NewScheduler()
+	noop := func(context.Context) error { return nil }
+	s.Add("reload", time.Minute, noop, WithJitter(10*time.Second))
+	s.Add("audit_retention", time.Hour, noop, LeaderOnly())
+	s.Add("health_checks", 30*time.Second, noop)
+
+	var names []string
+	for _, status := range s.Status() {
+		names = append(names, status.Name)
+	}
+	if want := []string{"audit_retention", "health_checks", "reload"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("names = %v, want %v", names, want)
+	}
+
+	status := s.Status()[2]
+	if status.Interval != "1m0s" || status.Jitter != "10s" || status.LeaderOnly {
+		t.Errorf("reload status = %+v", status)
+	}
+	if !s.Status()[0].LeaderOnly {
+		t.Error("audit_retention is not marked leader only")
+	}
+}
+
+func TestAddPanics(t *testing.T) {
+	noop := func(context.Context) error { return nil }
+	tests := []struct {
+		name  string
+		setup func(*Scheduler)
+	}{
+		{"duplicate", func(s *Scheduler) {
+			s.Add("reload", time.Minute, noop)
+			s.Add("reload", time.Minute, noop)
+		}},
+		{"after start", func(s *Scheduler) {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			s.Start(ctx)
+			s.Add("reload", time.Minute, noop)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("Add did not panic")
+				}
+			}()
+			tt.setup(NewScheduler())
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// maxMessageSize bounds a single LDAP message read from the server
+const maxMessageSize = 4 << 20
+
+// Universal BER tags used by LDAP
+const (
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x30
+	tagSet         = 0x31
+)
+
+var errMalformed = errors.New("ldap: malformed BER data")
+
+// element is one decoded BER tag-length-value
+type element struct {
+	tag     byte
+	content []byte
+}
+
+func encode(tag byte, content ...[]byte) []byte {
+	n := 0
+	for _, c := range content {
+		n += len(c)
+	}
+	out := append([]byte{tag}, encodeLength(n)...)
+	for _, c := range content {
+		out = append(out, c...)
+	}
+	return out
+}
+
+func encodeLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	return append([]byte{0x80 | byte(len(b))}, b...)
+}
+
+// encodeInt encodes a non-negative integer
+func encodeInt(tag byte, v int) []byte {
+	b := []byte{byte(v)}
+	for v >>= 8; v > 0; v >>= 8 {
+		b = append([]byte{byte(v)}, b...)
+	}
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return encode(tag, b)
+}
+
+func encodeString(tag byte, s string) []byte {
+	return encode(tag, []byte(s))
+}
+
+func encodeBool(v bool) []byte {
+	if v {
+		return encode(tagBoolean, []byte{0xff})
+	}
+	return encode(tagBoolean, []byte{0})
+}
+
+// readElement reads one complete element from the connection
+func readElement(r *bufio.Reader) (element, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return element{}, err
+	}
+	n, err := readLength(r)
+	if err != nil {
+		return element{}, err
+	}
+	if n > maxMessageSize {
+		return element{}, fmt.Errorf("ldap: message of %d bytes is too large", n)
+	}
+	content := make([]byte, n)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return element{}, err
+	}
+	return element{tag: tag, content: content}, nil
+}
+
+func readLength(r io.ByteReader) (int, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if first < 0x80 {
+		return int(first), nil
+	}
+	size := int(first & 0x7f)
+	if size == 0 || size > 4 {
+		return 0, errMalformed
+	}
+	n := 0
+	for i := 0; i < size; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		n = n<<8 | int(b)
+	}
+	return n, nil
+}
+
+// children decodes the elements inside a constructed element
+func (e element) children() ([]element, error) {
+	var out []element
+	buf := e.content
+	for len(buf) > 0 {
+		if len(buf) < 2 {
+			return nil, errMalformed
+		}
+		tag := buf[0]
+		r := &sliceReader{buf: buf[1:]}
+		n, err := readLength(r)
+		if err != nil {
+			return nil, errMalformed
+		}
+		rest := buf[1+r.off:]
+		if n > len(rest) {
+			return nil, errMalformed
+		}
+		out = append(out, element{tag: tag, content: rest[:n]})
+		buf = rest[n:]
+	}
+	return out, nil
+}
+
+func (e element) int() int {
+	v := 0
+	for _, b := range e.content {
+		v = v<<8 | int(b)
+	}
+	return v
+}
+
+func (e element) string() string {
+	return string(e.content)
+}
+
+type sliceReader struct {
+	buf []byte
+	off int
+}
+
+func (s *sliceReader) ReadByte() (byte, error) {
+	if s.off >= len(s.buf) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	b := s.buf[s.off]
+	s.off++
+	return b, nil
+}
@@ -0,0 +1,297 @@
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// LDAP protocol operations (RFC 4511 section 4)
+const (
+	appBindRequest     = 0x60
+	appBindResponse    = 0x61
+	appUnbindRequest   = 0x42
+	appSearchRequest   = 0x63
+	appSearchEntry     = 0x64
+	appSearchDone      = 0x65
+	appSearchReference = 0x73
+	appExtendedRequest = 0x77
+	appExtendedResp    = 0x78
+
+	authSimple = 0x80
+	extName    = 0x80
+
+	filterAndTag   = 0xa0
+	filterOrTag    = 0xa1
+	filterEqualTag = 0xa3
+
+	scopeSubtree     = 2
+	derefNever       = 0
+	oidStartTLS      = "1.3.6.1.4.1.1466.20037"
+	resultSuccess    = 0
+	resultSizeLimit  = 4
+	resultInvalidCre = 49
+)
+
+// ResultError is a non-success result returned by the server
+type ResultError struct {
+	Code    int
+	Message string
+}
+
+func (e *ResultError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("ldap: result code %d", e.Code)
+	}
+	return fmt.Sprintf("ldap: result code %d: %s", e.Code, e.Message)
+}
+
+type conn struct {
+	nc    net.Conn
+	r     *bufio.Reader
+	msgID int
+}
+
+// dial connects to the directory, upgrading to TLS as configured. The
+// connection's deadline covers the whole exchange.
+func (d *Directory) dial(ctx context.Context) (*conn, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok || time.Until(deadline) > d.Timeout {
+		deadline = time.Now().Add(d.Timeout)
+	}
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+
+	host := d.URL.Hostname()
+	port := d.URL.Port()
+	secure := d.URL.Scheme == "ldaps"
+	if port == "" {
+		port = "389"
+		if secure {
+			port = "636"
+		}
+	}
+	addr := net.JoinHostPort(host, port)
+
+	var nc net.Conn
+	var err error
+	if secure {
+		nc, err = (&tls.Dialer{Config: d.tlsConfig()}).DialContext(ctx, "tcp", addr)
+	} else {
+		nc, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	nc.SetDeadline(deadline)
+
+	c := &conn{nc: nc, r: bufio.NewReader(nc)}
+	if d.StartTLS && !secure {
+		if err := c.startTLS(d.tlsConfig()); err != nil {
+			nc.Close()
+			return nil, fmt.Errorf("starttls: %w", err)
+		}
+	}
+	return c, nil
+}
+
+func (d *Directory) tlsConfig() *tls.Config {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if d.TLSConfig != nil {
+		cfg = d.TLSConfig.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = d.URL.Hostname()
+	}
+	return cfg
+}
+
+func (c *conn) close() {
+	c.send(encode(appUnbindRequest))
+	c.nc.Close()
+}
+
+func (c *conn) send(op []byte) error {
+	c.msgID++
+	_, err := c.nc.Write(encode(tagSequence, encodeInt(tagInteger, c.msgID), op))
+	return err
+}
+
+// receive reads the next response to the current request
+func (c *conn) receive() (element, error) {
+	msg, err := readElement(c.r)
+	if err != nil {
+		return element{}, err
+	}
+	parts, err := msg.children()
+	if err != nil || msg.tag != tagSequence || len(parts) < 2 {
+		return element{}, errMalformed
+	}
+	if id := parts[0].int(); id != c.msgID {
+		// Message ID 0 is an unsolicited notice, usually a disconnect
+		if id == 0 {
+			return element{}, fmt.Errorf("ldap: server closed the connection: %w", result(parts[1]))
+		}
+		return element{}, fmt.Errorf("ldap: unexpected message id %d", id)
+	}
+	return parts[1], nil
+}
+
+// result decodes an LDAPResult, returning nil on success
+func result(op element) error {
+	parts, err := op.children()
+	if err != nil || len(parts) < 3 {
+		return errMalformed
+	}
+	if code := parts[0].int(); code != resultSuccess {
+		return &ResultError{Code: code, Message: strings.TrimRight(parts[2].string(), "\x00")}
+	}
+	return nil
+}
+
+func (c *conn) startTLS(cfg *tls.Config) error {
+	if err := c.send(encode(appExtendedRequest, encodeString(extName, oidStartTLS))); err != nil {
+		return err
+	}
+	op, err := c.receive()
+	if err != nil {
+		return err
+	}
+	if op.tag != appExtendedResp {
+		return errMalformed
+	}
+	if err := result(op); err != nil {
+		return err
+	}
+
+	tc := tls.Client(c.nc, cfg)
+	if err := tc.Handshake(); err != nil {
+		return err
+	}
+	c.nc = tc
+	c.r = bufio.NewReader(tc)
+	return nil
+}
+
+func (c *conn) bind(dn, password string) error {
+	err := c.send(encode(appBindRequest,
+		encodeInt(tagInteger, 3),
+		encodeString(tagOctetString, dn),
+		encodeString(authSimple, password),
+	))
+	if err != nil {
+		return err
+	}
+	op, err := c.receive()
+	if err != nil {
+		return err
+	}
+	if op.tag != appBindResponse {
+		return errMalformed
+	}
+	return result(op)
+}
+
+type searchEntry struct {
+	dn    string
+	attrs map[string][]string
+}
+
+func (e searchEntry) first(attr string) string {
+	if v := e.attrs[strings.ToLower(attr)]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// search runs a subtree search returning at most limit entries
+func (c *conn) search(base string, filter []byte, attrs []string, limit int, timeout time.Duration) ([]searchEntry, error) {
+	var attrList [][]byte
+	for _, a := range attrs {
+		attrList = append(attrList, encodeString(tagOctetString, a))
+	}
+	err := c.send(encode(appSearchRequest,
+		encodeString(tagOctetString, base),
+		encodeInt(tagEnumerated, scopeSubtree),
+		encodeInt(tagEnumerated, derefNever),
+		encodeInt(tagInteger, limit),
+		encodeInt(tagInteger, int(timeout.Seconds())),
+		encodeBool(false),
+		filter,
+		encode(tagSequence, attrList...),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []searchEntry
+	for {
+		op, err := c.receive()
+		if err != nil {
+			return nil, err
+		}
+		switch op.tag {
+		case appSearchEntry:
+			entry, err := parseEntry(op)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		case appSearchReference:
+			// Referrals to other servers are not followed
+		case appSearchDone:
+			err := result(op)
+			if re, ok := err.(*ResultError); ok && re.Code == resultSizeLimit {
+				err = nil
+			}
+			return entries, err
+		default:
+			return nil, errMalformed
+		}
+	}
+}
+
+func parseEntry(op element) (searchEntry, error) {
+	parts, err := op.children()
+	if err != nil || len(parts) < 2 {
+		return searchEntry{}, errMalformed
+	}
+	entry := searchEntry{dn: parts[0].string(), attrs: make(map[string][]string)}
+	attrs, err := parts[1].children()
+	if err != nil {
+		return searchEntry{}, errMalformed
+	}
+	for _, a := range attrs {
+		fields, err := a.children()
+		if err != nil || len(fields) < 2 {
+			return searchEntry{}, errMalformed
+		}
+		values, err := fields[1].children()
+		if err != nil {
+			return searchEntry{}, errMalformed
+		}
+		name := strings.ToLower(fields[0].string())
+		for _, v := range values {
+			entry.attrs[name] = append(entry.attrs[name], v.string())
+		}
+	}
+	return entry, nil
+}
+
+func filterAnd(filters ...[]byte) []byte {
+	return encode(filterAndTag, filters...)
+}
+
+func filterOr(filters ...[]byte) []byte {
+	return encode(filterOrTag, filters...)
+}
+
+// filterEqual builds (attr=value). Values are sent as-is in the encoded
+// filter, so user input needs no escaping.
+func filterEqual(attr, value string) []byte {
+	return encode(filterEqualTag, encodeString(tagOctetString, attr), encodeString(tagOctetString, value))
+}
@@ -0,0 +1,242 @@
+// Package ldap authenticates admin users against an LDAP directory such as
+// Active Directory. It speaks just enough of the protocol (RFC 4511) to look
+// a user up with a service account and verify their password with a bind.
+package ldap
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"viacortex/internal/oidc"
+)
+
+// defaultTimeout bounds each round trip to the directory
+const defaultTimeout = 10 * time.Second
+
+var (
+	// ErrNotFound means no directory entry matches the username
+	ErrNotFound = errors.New("user not found in directory")
+	// ErrInvalidCredentials means the directory rejected the password. Active
+	// Directory also answers this way for disabled and locked accounts.
+	ErrInvalidCredentials = errors.New("invalid directory credentials")
+)
+
+// Entry is a user found in the directory
+type Entry struct {
+	DN     string
+	Email  string
+	Name   string
+	Groups []string
+}
+
+// Directory is the connection and schema settings for one LDAP server
+type Directory struct {
+	URL       *url.URL
+	StartTLS  bool
+	TLSConfig *tls.Config
+	Timeout   time.Duration
+
+	// BindDN and BindPassword are the service account used for searches;
+	// empty means anonymous
+	BindDN       string
+	BindPassword string
+
+	BaseDN     string
+	UserClass  string
+	LoginAttrs []string
+	EmailAttr  string
+	NameAttr   string
+	GroupAttr  string
+
+	Roles oidc.RoleMapping
+	// FallbackLocal lets users the directory does not know, or everyone
+	// while it is unreachable, log in with local accounts
+	FallbackLocal bool
+}
+
+// FromEnv configures the directory from LDAP_* variables. It returns nil
+// unless AUTH_BACKEND is "ldap".
+//
+//	LDAP_URL            ldap://host[:port] or ldaps://host[:port]
+//	LDAP_START_TLS      "true" to upgrade ldap:// connections with StartTLS
+//	LDAP_CA_FILE        PEM bundle to verify the server against
+//	LDAP_BIND_DN        service account for user lookups
+//	LDAP_BIND_PASSWORD  its password
+//	LDAP_BASE_DN        where users are searched, e.g. "DC=corp,DC=example,DC=com"
+//	LDAP_USER_CLASS     objectClass of user entries (default "person")
+//	LDAP_LOGIN_ATTRS    attributes matched against the login name
+//	                    (default "sAMAccountName,userPrincipalName,mail")
+//	LDAP_EMAIL_ATTR     attribute holding the email address (default "mail")
+//	LDAP_NAME_ATTR      attribute holding the display name (default "displayName")
+//	LDAP_GROUP_ATTR     attribute listing group DNs (default "memberOf")
+//	LDAP_ROLE_MAP       "group=role,...,*=role", matching group CNs or full DNs
+//	LDAP_FALLBACK_LOCAL "false" to refuse local accounts (default "true")
+func FromEnv(validRole func(string) bool) (*Directory, error) {
+	switch backend := strings.ToLower(os.Getenv("AUTH_BACKEND")); backend {
+	case "", "local":
+		return nil, nil
+	case "ldap":
+	default:
+		return nil, fmt.Errorf("unknown AUTH_BACKEND %q", backend)
+	}
+
+	u, err := url.Parse(os.Getenv("LDAP_URL"))
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Hostname() == "" {
+		return nil, errors.New("LDAP_URL must be ldap://host or ldaps://host")
+	}
+	baseDN := os.Getenv("LDAP_BASE_DN")
+	if baseDN == "" {
+		return nil, errors.New("LDAP_BASE_DN is required")
+	}
+
+	roles, err := oidc.ParseRoleMapping(os.Getenv("LDAP_ROLE_MAP"), validRole)
+	if err != nil {
+		return nil, err
+	}
+	if len(roles) == 0 {
+		return nil, errors.New("LDAP_ROLE_MAP is required, e.g. \"ViaCortex Admins=admin,*=readonly\"")
+	}
+
+	d := &Directory{
+		URL:           u,
+		StartTLS:      os.Getenv("LDAP_START_TLS") == "true",
+		Timeout:       defaultTimeout,
+		BindDN:        os.Getenv("LDAP_BIND_DN"),
+		BindPassword:  os.Getenv("LDAP_BIND_PASSWORD"),
+		BaseDN:        baseDN,
+		UserClass:     envOr("LDAP_USER_CLASS", "person"),
+		EmailAttr:     envOr("LDAP_EMAIL_ATTR", "mail"),
+		NameAttr:      envOr("LDAP_NAME_ATTR", "displayName"),
+		GroupAttr:     envOr("LDAP_GROUP_ATTR", "memberOf"),
+		Roles:         roles,
+		FallbackLocal: os.Getenv("LDAP_FALLBACK_LOCAL") != "false",
+	}
+	for _, a := range strings.Split(envOr("LDAP_LOGIN_ATTRS", "sAMAccountName,userPrincipalName,mail"), ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			d.LoginAttrs = append(d.LoginAttrs, a)
+		}
+	}
+
+	if caFile := os.Getenv("LDAP_CA_FILE"); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading LDAP_CA_FILE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		d.TLSConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	return d, nil
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// Lookup finds the entry for a login name using the service account
+func (d *Directory) Lookup(ctx context.Context, username string) (*Entry, error) {
+	if username == "" {
+		return nil, ErrNotFound
+	}
+
+	c, err := d.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.close()
+
+	if d.BindDN != "" {
+		if err := c.bind(d.BindDN, d.BindPassword); err != nil {
+			return nil, fmt.Errorf("service account bind: %w", err)
+		}
+	}
+
+	var alternatives [][]byte
+	for _, attr := range d.LoginAttrs {
+		alternatives = append(alternatives, filterEqual(attr, username))
+	}
+	filter := filterAnd(filterEqual("objectClass", d.UserClass), filterOr(alternatives...))
+
+	// Ask for two entries so an ambiguous login name is detected
+	entries, err := c.search(d.BaseDN, filter, []string{d.EmailAttr, d.NameAttr, d.GroupAttr}, 2, d.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	switch len(entries) {
+	case 0:
+		return nil, ErrNotFound
+	case 1:
+	default:
+		return nil, fmt.Errorf("%q matches more than one directory entry", username)
+	}
+
+	e := entries[0]
+	entry := &Entry{DN: e.dn, Email: e.first(d.EmailAttr), Name: e.first(d.NameAttr)}
+	for _, group := range e.attrs[strings.ToLower(d.GroupAttr)] {
+		entry.Groups = append(entry.Groups, group)
+		if cn := commonName(group); cn != "" {
+			entry.Groups = append(entry.Groups, cn)
+		}
+	}
+	return entry, nil
+}
+
+// Verify checks a password by binding as the user
+func (d *Directory) Verify(ctx context.Context, dn, password string) error {
+	// An empty password would be an unauthenticated bind, which many
+	// servers accept without checking anything
+	if password == "" {
+		return ErrInvalidCredentials
+	}
+
+	c, err := d.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	err = c.bind(dn, password)
+	var re *ResultError
+	if errors.As(err, &re) && re.Code == resultInvalidCre {
+		return ErrInvalidCredentials
+	}
+	return err
+}
+
+// commonName returns the value of a DN's leading CN, e.g. "Admins" for
+// "CN=Admins,OU=Groups,DC=corp,DC=example,DC=com"
+func commonName(dn string) string {
+	var b strings.Builder
+	escaped := false
+	for i := 0; i < len(dn); i++ {
+		ch := dn[i]
+		switch {
+		case escaped:
+			b.WriteByte(ch)
+			escaped = false
+		case ch == '\\':
+			escaped = true
+		case ch == ',' || ch == '+':
+			i = len(dn)
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	attr, value, ok := strings.Cut(b.String(), "=")
+	if !ok || !strings.EqualFold(strings.TrimSpace(attr), "cn") {
+		return ""
+	}
+	return strings.TrimSpace(value)
+}
@@ -0,0 +1,264 @@
+package ldap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEncodeLength(t *testing.T) {
+	tests := []struct {
+		n    int
+		want string
+	}{
+		{0, "00"},
+		{0x7f, "7f"},
+		{0x80, "8180"},
+		{0xff, "81ff"},
+		{0x100, "820100"},
+		{0x12345, "83012345"},
+	}
+	for _, tt := range tests {
+		got := hex.EncodeToString(encodeLength(tt.n))
+		if got != tt.want {
+			t.Errorf("encodeLength(%d) = %s, want %s", tt.n, got, tt.want)
+		}
+		n, err := readLength(bytes.NewReader(encodeLength(tt.n)))
+		if err != nil || n != tt.n {
+			t.Errorf("readLength(encodeLength(%d)) = %d, %v", tt.n, n, err)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name string
+		got  []byte
+		want string
+	}{
+		{"zero", encodeInt(tagInteger, 0), "020100"},
+		{"small int", encodeInt(tagInteger, 3), "020103"},
+		{"int with high bit", encodeInt(tagInteger, 0x80), "02020080"},
+		{"two byte int", encodeInt(tagInteger, 0x1234), "02021234"},
+		{"enumerated", encodeInt(tagEnumerated, scopeSubtree), "0a0102"},
+		{"string", encodeString(tagOctetString, "cn"), "0402636e"},
+		{"empty string", encodeString(tagOctetString, ""), "0400"},
+		{"true", encodeBool(true), "0101ff"},
+		{"false", encodeBool(false), "010100"},
+		{"sequence", encode(tagSequence, encodeInt(tagInteger, 1), encodeString(tagOctetString, "a")), "3006020101040161"},
+		{"long content", encode(tagOctetString, make([]byte, 200)), "0481c8" + strings.Repeat("00", 200)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hex.EncodeToString(tt.got); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadElement(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    element
+		wantErr error
+	}{
+		{name: "short form", in: "0403616263", want: element{tag: tagOctetString, content: []byte("abc")}},
+		{name: "long form", in: "04820003616263", want: element{tag: tagOctetString, content: []byte("abc")}},
+		{name: "indefinite length", in: "3080", wantErr: errMalformed},
+		{name: "length of length too long", in: "3085ffffffffff", wantErr: errMalformed},
+		{name: "truncated", in: "040561", wantErr: errors.New("unexpected EOF")},
+		{name: "too large", in: "0484ffffffff", wantErr: errors.New("too large")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in, _ := hex.DecodeString(tt.in)
+			got, err := readElement(bufio.NewReader(bytes.NewReader(in)))
+			if tt.wantErr != nil {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr.Error()) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChildren(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []element
+		wantErr bool
+	}{
+		{name: "empty", content: ""},
+		{
+			name:    "two children",
+			content: "020105" + "0402636e",
+			want:    []element{{tag: tagInteger, content: []byte{5}}, {tag: tagOctetString, content: []byte("cn")}},
+		},
+		{name: "length past end", content: "0405636e", wantErr: true},
+		{name: "lone tag", content: "02", wantErr: true},
+		{name: "bad length", content: "0480", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, _ := hex.DecodeString(tt.content)
+			got, err := element{tag: tagSequence, content: content}.children()
+			if tt.wantErr {
+				if err != errMalformed {
+					t.Fatalf("error = %v, want errMalformed", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestFilterEqual checks that values with filter syntax in them are sent as
+// the literal value of an equality match rather than changing the filter
+func TestFilterEqual(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"plain", "alice"},
+		{"wildcard", "*"},
+		{"injected clause", "*)(uid=*"},
+		{"parentheses", "a(b)c"},
+		{"backslash escape", `\2a`},
+		{"nul", "alice\x00"},
+		{"non-ASCII", "jürgen"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := filterEqual("uid", tt.value)
+			if f[0] != filterEqualTag {
+				t.Fatalf("tag = %#x, want equality match", f[0])
+			}
+			el, err := readElement(bufio.NewReader(bytes.NewReader(f)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			parts, err := el.children()
+			if err != nil || len(parts) != 2 {
+				t.Fatalf("children = %v, %v", parts, err)
+			}
+			if parts[0].string() != "uid" || parts[1].string() != tt.value {
+				t.Errorf("assertion = (%s=%q), want (uid=%q)", parts[0].string(), parts[1].string(), tt.value)
+			}
+		})
+	}
+}
+
+func TestFilterComposition(t *testing.T) {
+	filter := filterAnd(filterEqual("objectClass", "person"), filterOr(filterEqual("uid", "a"), filterEqual("mail", "a")))
+	want := "a0" + "2e" +
+		"a3" + "15" + "040b" + hex.EncodeToString([]byte("objectClass")) + "0406" + hex.EncodeToString([]byte("person")) +
+		"a1" + "15" +
+		"a3" + "08" + "0403" + hex.EncodeToString([]byte("uid")) + "040161" +
+		"a3" + "09" + "0404" + hex.EncodeToString([]byte("mail")) + "040161"
+	if got := hex.EncodeToString(filter); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestParseEntry(t *testing.T) {
+	attr := func(name string, values ...string) []byte {
+		var encoded [][]byte
+		for _, v := range values {
+			encoded = append(encoded, encodeString(tagOctetString, v))
+		}
+		return encode(tagSequence, encodeString(tagOctetString, name), encode(tagSet, encoded...))
+	}
+	op := encode(appSearchEntry,
+		encodeString(tagOctetString, "uid=alice,dc=example,dc=com"),
+		encode(tagSequence, attr("mail", "alice@example.com"), attr("memberOf", "cn=Admins,dc=example,dc=com", "cn=Ops,dc=example,dc=com")),
+	)
+	el, err := readElement(bufio.NewReader(bytes.NewReader(op)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := parseEntry(el)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.dn != "uid=alice,dc=example,dc=com" || entry.first("MAIL") != "alice@example.com" {
+		t.Errorf("entry = %+v", entry)
+	}
+	if got := entry.attrs["memberof"]; len(got) != 2 {
+		t.Errorf("memberOf = %v", got)
+	}
+}
+
+func TestResult(t *testing.T) {
+	ldapResult := func(code int, message string) element {
+		return element{tag: appBindResponse, content: bytes.Join([][]byte{
+			encodeInt(tagEnumerated, code),
+			encodeString(tagOctetString, ""),
+			encodeString(tagOctetString, message),
+		}, nil)}
+	}
+	tests := []struct {
+		name    string
+		op      element
+		wantErr string
+	}{
+		{name: "success", op: ldapResult(resultSuccess, "")},
+		{name: "invalid credentials", op: ldapResult(resultInvalidCre, "80090308: AcceptSecurityContext error\x00"), wantErr: "ldap: result code 49: 80090308: AcceptSecurityContext error"},
+		{name: "no message", op: ldapResult(resultSizeLimit, ""), wantErr: "ldap: result code 4"},
+		{name: "missing fields", op: element{tag: appBindResponse, content: encodeInt(tagEnumerated, 0)}, wantErr: errMalformed.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := result(tt.op)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCommonName(t *testing.T) {
+	tests := []struct {
+		dn   string
+		want string
+	}{
+		{"CN=Admins,OU=Groups,DC=corp,DC=example,DC=com", "Admins"},
+		{"cn=Ops Team,dc=example,dc=com", "Ops Team"},
+		{" cn = Ops ,dc=example", "Ops"},
+		{`CN=Smith\, John,OU=Users`, "Smith, John"},
+		{`CN=a\+b+OU=x,DC=example`, "a+b"},
+		{"OU=Groups,DC=example", ""},
+		{"Admins", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := commonName(tt.dn); got != tt.want {
+			t.Errorf("commonName(%q) = %q, want %q", tt.dn, got, tt.want)
+		}
+	}
+}
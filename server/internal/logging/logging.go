@@ -0,0 +1,158 @@
+// Package logging provides leveled, structured loggers on top of log/slog.
+// Each module logs through its own logger whose level can be changed at
+// runtime, and output is text or JSON. Messages that libraries write with the
+// standard log package are routed through the same handler.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	mu       sync.RWMutex
+	output   slog.Handler = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
+	defLevel              = slog.LevelInfo
+	levels                = map[string]slog.Level{} // per-module overrides
+)
+
+// Setup selects the output format ("text" or "json") and the default level,
+// and routes the standard log package through the structured handler
+func Setup(w io.Writer, format string, level slog.Level) error {
+	// Levels are filtered per module, so the handler itself passes all
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var h slog.Handler
+	switch format {
+	case "", "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+
+	mu.Lock()
+	output = h
+	defLevel = level
+	mu.Unlock()
+
+	log.SetFlags(0)
+	log.SetOutput(stdlogBridge{})
+	return nil
+}
+
+// For returns the logger of a module, e.g. "proxy". It may be called before
+// Setup; the logger follows later configuration changes.
+func For(module string) *slog.Logger {
+	return slog.New(&moduleHandler{module: module})
+}
+
+// ParseLevel parses debug, info, warn or error
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q: use debug, info, warn or error", s)
+	}
+	return l, nil
+}
+
+// SetLevel changes the default level
+func SetLevel(level slog.Level) {
+	mu.Lock()
+	defLevel = level
+	mu.Unlock()
+}
+
+// SetModuleLevel overrides the level of one module
+func SetModuleLevel(module string, level slog.Level) {
+	mu.Lock()
+	levels[module] = level
+	mu.Unlock()
+}
+
+// ResetModuleLevel makes a module follow the default level again
+func ResetModuleLevel(module string) {
+	mu.Lock()
+	delete(levels, module)
+	mu.Unlock()
+}
+
+// Levels returns the default level and the per-module overrides
+func Levels() (slog.Level, map[string]slog.Level) {
+	mu.RLock()
+	defer mu.RUnlock()
+	modules := make(map[string]slog.Level, len(levels))
+	for m, l := range levels {
+		modules[m] = l
+	}
+	return defLevel, modules
+}
+
+func enabled(module string, level slog.Level) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	min, ok := levels[module]
+	if !ok {
+		min = defLevel
+	}
+	return level >= min
+}
+
+func current() slog.Handler {
+	mu.RLock()
+	defer mu.RUnlock()
+	return output
+}
+
+// moduleHandler filters by its module's level and writes to the handler
+// configured at the time of each record, replaying attributes and groups
+// added with With and WithGroup
+type moduleHandler struct {
+	module string
+	ops    []func(slog.Handler) slog.Handler
+}
+
+func (h *moduleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return enabled(h.module, level)
+}
+
+func (h *moduleHandler) Handle(ctx context.Context, r slog.Record) error {
+	out := current().WithAttrs([]slog.Attr{slog.String("module", h.module)})
+	for _, op := range h.ops {
+		out = op(out)
+	}
+	return out.Handle(ctx, r)
+}
+
+func (h *moduleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(out slog.Handler) slog.Handler { return out.WithAttrs(attrs) })
+}
+
+func (h *moduleHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(out slog.Handler) slog.Handler { return out.WithGroup(name) })
+}
+
+func (h *moduleHandler) with(op func(slog.Handler) slog.Handler) *moduleHandler {
+	ops := make([]func(slog.Handler) slog.Handler, len(h.ops), len(h.ops)+1)
+	copy(ops, h.ops)
+	return &moduleHandler{module: h.module, ops: append(ops, op)}
+}
+
+// stdlog is the module of messages that libraries write with the standard
+// log package, such as net/http's server errors
+var stdlog = For("stdlog")
+
+// stdlogBridge logs lines written through the standard log package as
+// warnings
+type stdlogBridge struct{}
+
+func (stdlogBridge) Write(p []byte) (int, error) {
+	stdlog.Warn(strings.TrimSpace(string(p)))
+	return len(p), nil
+}
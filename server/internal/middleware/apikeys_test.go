@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHasScope(t *testing.T) {
+	tests := []struct {
+		name    string
+		granted []string
+		scope   string
+		want    bool
+	}{
+		{"exact read", []string{ScopeDomainsRead}, ScopeDomainsRead, true},
+		{"exact write", []string{ScopeDomainsWrite}, ScopeDomainsWrite, true},
+		{"write implies read", []string{ScopeDomainsWrite}, ScopeDomainsRead, true},
+		{"read does not imply write", []string{ScopeDomainsRead}, ScopeDomainsWrite, false},
+		{"other resource's write", []string{ScopeUsersWrite}, ScopeDomainsRead, false},
+		{"one of several", []string{ScopeLogsRead, ScopeMetricsRead}, ScopeMetricsRead, true},
+		{"none granted", nil, ScopeAuditRead, false},
+		{"prefix is not a match", []string{"domains"}, ScopeDomainsRead, false},
+		{"case sensitive", []string{"Domains:Read"}, ScopeDomainsRead, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HasScope(tt.granted, tt.scope); got != tt.want {
+				t.Errorf("HasScope(%v, %q) = %v, want %v", tt.granted, tt.scope, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsValidScope(t *testing.T) {
+	for _, scope := range AllScopes() {
+		if !IsValidScope(scope) {
+			t.Errorf("AllScopes lists %q but IsValidScope rejects it", scope)
+		}
+	}
+	for _, scope := range []string{"", "domains", "domains:delete", "logs:write", "*"} {
+		if IsValidScope(scope) {
+			t.Errorf("IsValidScope(%q) = true", scope)
+		}
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	apiKey := func(scopes ...string) context.Context {
+		ctx := context.WithValue(context.Background(), apiKeyIDKey, int64(1))
+		return context.WithValue(ctx, scopesKey, scopes)
+	}
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		scope string
+		want  int
+	}{
+		{"session", context.Background(), ScopeUsersWrite, http.StatusOK},
+		{"key with scope", apiKey(ScopeDomainsRead), ScopeDomainsRead, http.StatusOK},
+		{"key with write scope", apiKey(ScopeDomainsWrite), ScopeDomainsRead, http.StatusOK},
+		{"key without scope", apiKey(ScopeDomainsRead), ScopeDomainsWrite, http.StatusForbidden},
+		{"key without scopes", apiKey(), ScopeMetricsRead, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := RequireScope(tt.scope)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/domains", nil).WithContext(tt.ctx))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireSession(t *testing.T) {
+	h := RequireSession(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want int
+	}{
+		{"session", context.Background(), http.StatusOK},
+		{"API key", context.WithValue(context.Background(), apiKeyIDKey, int64(1)), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/api-keys", nil).WithContext(tt.ctx))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
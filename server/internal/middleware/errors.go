@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+)
+
+const plainErrorsKey contextKey = "plainErrors"
+
+// ErrorResponse is the error body returned by the API
+type ErrorResponse struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+// PlainErrors keeps the historic error format for requests under a legacy
+// prefix: a plain-text message, or {"error": ...} where an endpoint already
+// answered with JSON
+func PlainErrors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), plainErrorsKey, true)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ErrorCode derives the default machine-readable code for a status, e.g.
+// "not_found" for 404
+func ErrorCode(status int) string {
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// Error writes an error response with the default code for status
+func Error(w http.ResponseWriter, r *http.Request, status int, message string) {
+	WriteError(w, r, status, ErrorCode(status), message, nil)
+}
+
+// WriteError writes an error response. details carries structured context
+// such as validation problems and may be nil.
+func WriteError(w http.ResponseWriter, r *http.Request, status int, code, message string, details interface{}) {
+	if plain, _ := r.Context().Value(plainErrorsKey).(bool); plain {
+		writeLegacyError(w, status, message, details)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: chimiddleware.GetReqID(r.Context()),
+	})
+}
+
+func writeLegacyError(w http.ResponseWriter, status int, message string, details interface{}) {
+	fields, ok := details.(map[string]interface{})
+	if !ok {
+		http.Error(w, message, status)
+		return
+	}
+
+	body := map[string]interface{}{"error": message}
+	for k, v := range fields {
+		body[k] = v
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Permissions that can be granted to a role
+const (
+	PermDomainsRead  = "domains.read"
+	PermDomainsWrite = "domains.write"
+	PermUsersManage  = "users.manage"
+	PermLogsRead     = "logs.read"
+	PermCertsManage  = "certs.manage"
+)
+
+// Permissions lists every permission in display order
+var Permissions = []string{
+	PermDomainsRead,
+	PermDomainsWrite,
+	PermUsersManage,
+	PermLogsRead,
+	PermCertsManage,
+}
+
+var (
+	rolesMu sync.RWMutex
+	roles   = DefaultRolePermissions()
+)
+
+// DefaultRolePermissions returns the permission sets of the built-in roles.
+// They match what each role could do before permissions were configurable.
+func DefaultRolePermissions() map[string][]string {
+	return map[string][]string{
+		RoleReadonly: {PermDomainsRead, PermLogsRead},
+		RoleUser:     {PermDomainsRead, PermDomainsWrite, PermLogsRead, PermCertsManage},
+		RoleAdmin:    append([]string(nil), Permissions...),
+	}
+}
+
+// IsValidPermission reports whether perm is a known permission
+func IsValidPermission(perm string) bool {
+	for _, p := range Permissions {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// SetRolePermissions replaces the known roles and their permissions. The
+// admin role always keeps every permission so it cannot be locked out.
+func SetRolePermissions(perms map[string][]string) {
+	next := make(map[string][]string, len(perms)+1)
+	for role, granted := range perms {
+		next[role] = append([]string(nil), granted...)
+	}
+	next[RoleAdmin] = append([]string(nil), Permissions...)
+
+	rolesMu.Lock()
+	roles = next
+	rolesMu.Unlock()
+}
+
+// RolePermissions returns the permissions granted to role, sorted
+func RolePermissions(role string) []string {
+	rolesMu.RLock()
+	granted := append([]string{}, roles[role]...)
+	rolesMu.RUnlock()
+	sort.Strings(granted)
+	return granted
+}
+
+// HasPermission reports whether role grants perm
+func HasPermission(role, perm string) bool {
+	rolesMu.RLock()
+	defer rolesMu.RUnlock()
+	for _, p := range roles[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// RequirePermission rejects requests whose authenticated role lacks perm.
+// It must run after AuthMiddleware so the role is present in the context.
+func RequirePermission(perm string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role := GetRoleFromContext(r.Context())
+			if role == "" {
+				Error(w, r, http.StatusUnauthorized, "Unauthorized")
+				return
+			}
+			if !HasPermission(role, perm) {
+				Error(w, r, http.StatusForbidden, "Missing permission "+perm)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package middleware
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// limiterIdleTTL is how long an identity's limiter is kept after its last
+// request
+const limiterIdleTTL = 15 * time.Minute
+
+type limiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+	limited  bool
+}
+
+// IdentityLimiter applies a token bucket per identity (client IP, user or
+// API key) on top of the global request throttle
+type IdentityLimiter struct {
+	name      string
+	limit     rate.Limit
+	burst     int
+	mu        sync.Mutex
+	entries   map[string]*limiterEntry
+	lastSweep time.Time
+	rejected  atomic.Int64
+
+	// OnLimited is called once when an identity starts being rejected, not
+	// for every rejected request
+	OnLimited func(r *http.Request, key string)
+}
+
+// NewIdentityLimiter allows each identity perMinute requests per minute with
+// bursts of up to burst requests
+func NewIdentityLimiter(name string, perMinute float64, burst int) *IdentityLimiter {
+	return &IdentityLimiter{
+		name:      name,
+		limit:     rate.Limit(perMinute / 60),
+		burst:     burst,
+		entries:   make(map[string]*limiterEntry),
+		lastSweep: time.Now(),
+	}
+}
+
+// allow reports whether key may make a request now. When it may not, it
+// also returns how long until the next request would be allowed and whether
+// this is the first rejection since the identity was last allowed.
+func (l *IdentityLimiter) allow(key string) (ok bool, retryAfter time.Duration, first bool) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > limiterIdleTTL {
+		for k, e := range l.entries {
+			if now.Sub(e.lastSeen) > limiterIdleTTL {
+				delete(l.entries, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	e, found := l.entries[key]
+	if !found {
+		e = &limiterEntry{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.entries[key] = e
+	}
+	e.lastSeen = now
+
+	if e.limiter.AllowN(now, 1) {
+		e.limited = false
+		return true, 0, false
+	}
+
+	first = !e.limited
+	e.limited = true
+	reservation := e.limiter.ReserveN(now, 1)
+	retryAfter = reservation.DelayFrom(now)
+	reservation.CancelAt(now)
+	return false, retryAfter, first
+}
+
+// Allow reports whether key may proceed, for limits keyed on something only
+// the handler knows, such as an email address in the request body
+func (l *IdentityLimiter) Allow(key string) bool {
+	ok, _, _ := l.allow(key)
+	if !ok {
+		l.rejected.Add(1)
+	}
+	return ok
+}
+
+// Rejected returns how many requests the limiter has rejected
+func (l *IdentityLimiter) Rejected() int64 {
+	return l.rejected.Load()
+}
+
+// Name identifies the limiter in metrics
+func (l *IdentityLimiter) Name() string {
+	return l.name
+}
+
+// Middleware rejects requests over the limit with 429 and Retry-After. key
+// returns the identity to limit; requests with an empty key are not limited.
+func (l *IdentityLimiter) Middleware(key func(r *http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			k := key(r)
+			if k == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ok, retryAfter, first := l.allow(k)
+			if !ok {
+				l.rejected.Add(1)
+				if first && l.OnLimited != nil {
+					l.OnLimited(r, k)
+				}
+				seconds := int(math.Ceil(retryAfter.Seconds()))
+				if seconds < 1 {
+					seconds = 1
+				}
+				w.Header().Set("Retry-After", strconv.Itoa(seconds))
+				Error(w, r, http.StatusTooManyRequests, "Too many requests")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ClientIPKey identifies a request by client address. RemoteAddr has already
+// been rewritten by RealIP.
+func ClientIPKey(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return "ip:" + host
+	}
+	return "ip:" + r.RemoteAddr
+}
+
+// IdentityKey identifies an authenticated request by API key or user, so
+// every credential gets its own budget regardless of where it is used from.
+// It must run after Authenticate.
+func IdentityKey(r *http.Request) string {
+	if id := GetAPIKeyIDFromContext(r.Context()); id != 0 {
+		return "key:" + strconv.FormatInt(id, 10)
+	}
+	if id := GetUserIDFromContext(r.Context()); id != 0 {
+		return "user:" + strconv.FormatInt(id, 10)
+	}
+	return ""
+}
@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"net/http"
+
+	"viacortex/internal/reporting"
+
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+)
+
+// ReportPanics sends handler panics to the error tracker. It goes after
+// chi's Recoverer, which still logs the panic and answers 500.
+func ReportPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if v := recover(); v != nil {
+				// An aborted response is how handlers cancel a request
+				if v != http.ErrAbortHandler {
+					reporting.Panic(v, map[string]string{
+						"method":     r.Method,
+						"route":      r.URL.Path,
+						"request_id": chimiddleware.GetReqID(r.Context()),
+					})
+				}
+				panic(v)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"net/http"
+)
+
+// Roles understood by the admin API, from least to most privileged
+const (
+	RoleReadonly = "readonly"
+	RoleUser     = "user"
+	RoleAdmin    = "admin"
+)
+
+var roleRank = map[string]int{
+	RoleReadonly: 1,
+	RoleUser:     2,
+	RoleAdmin:    3,
+}
+
+// IsValidRole reports whether role is a built-in role or a custom role
+// installed with SetRolePermissions
+func IsValidRole(role string) bool {
+	rolesMu.RLock()
+	defer rolesMu.RUnlock()
+	_, ok := roles[role]
+	return ok
+}
+
+// IsBuiltinRole reports whether role is one of the three built-in roles
+func IsBuiltinRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// HasRole reports whether role grants at least the privileges of minRole.
+// Custom roles have no rank and are checked with HasPermission instead.
+func HasRole(role, minRole string) bool {
+	rank, ok := roleRank[role]
+	if !ok {
+		return false
+	}
+	return rank >= roleRank[minRole]
+}
+
+// RequireRole rejects requests whose authenticated role is below minRole.
+// It must run after AuthMiddleware so the role is present in the context.
+func RequireRole(minRole string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role := GetRoleFromContext(r.Context())
+			if role == "" {
+				Error(w, r, http.StatusUnauthorized, "Unauthorized")
+				return
+			}
+			if !HasRole(role, minRole) {
+				Error(w, r, http.StatusForbidden, "Insufficient permissions")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
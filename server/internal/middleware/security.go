@@ -2,17 +2,18 @@ package middleware
 
 import (
 	"context"
-	"log"
 	"net/http"
 	"strconv"
 	"strings"
-	"os"
 
 	"viacortex/internal/auth"
+	"viacortex/internal/logging"
 
 	"github.com/go-chi/cors"
 )
 
+var logger = logging.For("middleware")
+
 // Define context keys to avoid string-based keys
 type contextKey string
 const (
@@ -43,57 +44,109 @@ func Cors() func(http.Handler) http.Handler {
     })
 }
 
+// AuthMiddleware authenticates requests with a JWT access token
 func AuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if env := os.Getenv("ENV"); env != "production" {
-			// For development, still set a test user ID
-			ctx := context.WithValue(r.Context(), UserIDKey, int64(1))
+	return Authenticate(nil, nil)(next)
+}
+
+// Authenticate accepts JWT access tokens and, when lookup is non-nil, API keys
+// sent either as a bearer token or in the X-API-Key header. When sessions is
+// non-nil, access tokens of revoked or expired sessions, and tokens without a
+// session, are rejected.
+func Authenticate(lookup APIKeyLookup, sessions SessionCheck) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			credential := r.Header.Get("X-API-Key")
+			if credential == "" {
+				authHeader := r.Header.Get("Authorization")
+				if authHeader == "" {
+					Error(w, r, http.StatusUnauthorized, "Unauthorized")
+					return
+				}
+
+				tokenParts := strings.Split(authHeader, " ")
+				if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
+					Error(w, r, http.StatusUnauthorized, "Invalid authorization header")
+					return
+				}
+				credential = tokenParts[1]
+			}
+
+			if auth.IsAPIKey(credential) {
+				if lookup == nil {
+					Error(w, r, http.StatusUnauthorized, "API keys are not accepted here")
+					return
+				}
+
+				principal, err := lookup(r.Context(), credential)
+				if err != nil {
+					logger.Warn("API key rejected", "error", err)
+					Error(w, r, http.StatusUnauthorized, "Invalid API key")
+					return
+				}
+
+				ctx := r.Context()
+				ctx = context.WithValue(ctx, UserIDKey, principal.UserID)
+				ctx = context.WithValue(ctx, EmailKey, principal.Email)
+				ctx = context.WithValue(ctx, RoleKey, principal.Role)
+				ctx = context.WithValue(ctx, apiKeyIDKey, principal.KeyID)
+				ctx = context.WithValue(ctx, scopesKey, principal.Scopes)
+
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
+			claims, err := auth.ValidateToken(credential)
+			if err != nil {
+				Error(w, r, http.StatusUnauthorized, "Invalid token")
+				return
+			}
+
+			// Verify it's an access token, not a refresh token
+			if claims.Type != "access" {
+				Error(w, r, http.StatusUnauthorized, "Invalid token type")
+				return
+			}
+
+			if sessions != nil {
+				// Tokens issued before sessions existed cannot be revoked, so
+				// they are refused; refreshing moves the client to a session
+				if claims.SessionID == "" {
+					Error(w, r, http.StatusUnauthorized, "Token is not bound to a session")
+					return
+				}
+				active, err := sessions(r.Context(), claims.SessionID)
+				if err != nil {
+					logger.Error("Checking session failed", "error", err)
+					Error(w, r, http.StatusServiceUnavailable, "Unable to verify session")
+					return
+				}
+				if !active {
+					Error(w, r, http.StatusUnauthorized, "Session has been revoked")
+					return
+				}
+			}
+
+			// Convert user ID from string to int64
+			userID, err := strconv.ParseInt(claims.UserID, 10, 64)
+			if err != nil {
+				logger.Error("Converting user ID failed", "error", err)
+				Error(w, r, http.StatusUnauthorized, "Invalid user ID")
+				return
+			}
+
+			logger.Debug("Authenticated request", "user_id", userID)
+
+			// Add claims to request context
+			ctx := r.Context()
+			ctx = context.WithValue(ctx, UserIDKey, userID)
+			ctx = context.WithValue(ctx, EmailKey, claims.Email)
+			ctx = context.WithValue(ctx, RoleKey, claims.Role)
+			ctx = context.WithValue(ctx, sessionIDKey, claims.SessionID)
+
 			next.ServeHTTP(w, r.WithContext(ctx))
-			return
-		}
-
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		tokenParts := strings.Split(authHeader, " ")
-		if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
-			http.Error(w, "Invalid authorization header", http.StatusUnauthorized)
-			return
-		}
-
-		claims, err := auth.ValidateToken(tokenParts[1])
-		if err != nil {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
-			return
-		}
-
-		// Verify it's an access token, not a refresh token
-		if claims.Type != "access" {
-			http.Error(w, "Invalid token type", http.StatusUnauthorized)
-			return
-		}
-
-		// Convert user ID from string to int64
-		userID, err := strconv.ParseInt(claims.UserID, 10, 64)
-		if err != nil {
-			log.Printf("Error converting user ID: %v", err)
-			http.Error(w, "Invalid user ID", http.StatusUnauthorized)
-			return
-		}
-
-		log.Printf("Setting userID in context: %d", userID) // Debug log
-
-		// Add claims to request context
-		ctx := r.Context()
-		ctx = context.WithValue(ctx, UserIDKey, userID)
-		ctx = context.WithValue(ctx, EmailKey, claims.Email)
-		ctx = context.WithValue(ctx, RoleKey, claims.Role)
-		
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+		})
+	}
 }
 
 // Update helper functions to return correct types
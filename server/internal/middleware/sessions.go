@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"context"
+)
+
+const sessionIDKey contextKey = "sessionID"
+
+// SessionCheck reports whether a login session still exists and has not
+// expired. Logging out or revoking a session deletes it, so checking every
+// access token against the sessions table rejects the tokens of revoked
+// sessions on every node, not only the one that revoked them.
+type SessionCheck func(ctx context.Context, sessionID string) (bool, error)
+
+// GetSessionIDFromContext returns the login session of a token-authenticated
+// request, or "" for API keys
+func GetSessionIDFromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(sessionIDKey).(string); ok {
+		return id
+	}
+	return ""
+}
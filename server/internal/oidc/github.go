@@ -0,0 +1,106 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	githubAuthorizeURL = "https://github.com/login/oauth/authorize"
+	githubTokenURL     = "https://github.com/login/oauth/access_token"
+	githubAPI          = "https://api.github.com"
+)
+
+// GitHubProvider signs users in with a GitHub OAuth app. GitHub is not an
+// OpenID Connect issuer, so the identity comes from its REST API. Groups are
+// the user's organizations ("acme") and teams ("acme/platform").
+type GitHubProvider struct {
+	client clientConfig
+}
+
+// NewGitHubProvider creates a GitHub provider
+func NewGitHubProvider(client clientConfig) *GitHubProvider {
+	return &GitHubProvider{client: client}
+}
+
+// AuthCodeURL implements Provider
+func (p *GitHubProvider) AuthCodeURL(ctx context.Context, state, nonce, challenge string) (string, error) {
+	scopes := append([]string{"read:user", "user:email", "read:org"}, p.client.Scopes...)
+	q := url.Values{
+		"client_id":             {p.client.ClientID},
+		"redirect_uri":          {p.client.RedirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+		"allow_signup":          {"false"},
+	}
+	return githubAuthorizeURL + "?" + q.Encode(), nil
+}
+
+// Exchange implements Provider. GitHub has no ID token, so there is no nonce
+// to check; state and PKCE bind the callback to the login.
+func (p *GitHubProvider) Exchange(ctx context.Context, code, verifier, nonce string) (*Identity, error) {
+	tok, err := p.client.exchangeCode(ctx, githubTokenURL, code, verifier)
+	if err != nil {
+		return nil, err
+	}
+
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := getJSON(ctx, githubAPI+"/user", tok.AccessToken, &user); err != nil {
+		return nil, fmt.Errorf("fetching GitHub user: %w", err)
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSON(ctx, githubAPI+"/user/emails", tok.AccessToken, &emails); err != nil {
+		return nil, fmt.Errorf("fetching GitHub emails: %w", err)
+	}
+
+	id := &Identity{Subject: fmt.Sprintf("github:%d", user.ID), Name: user.Name}
+	if id.Name == "" {
+		id.Name = user.Login
+	}
+	for _, e := range emails {
+		if e.Primary && e.Verified {
+			id.Email, id.EmailVerified = e.Email, true
+		}
+	}
+	if id.Email == "" {
+		return nil, errors.New("GitHub account has no verified primary email")
+	}
+
+	var orgs []struct {
+		Login string `json:"login"`
+	}
+	if err := getJSON(ctx, githubAPI+"/user/orgs?per_page=100", tok.AccessToken, &orgs); err != nil {
+		return nil, fmt.Errorf("fetching GitHub organizations: %w", err)
+	}
+	for _, o := range orgs {
+		id.Groups = append(id.Groups, o.Login)
+	}
+
+	var teams []struct {
+		Slug         string `json:"slug"`
+		Organization struct {
+			Login string `json:"login"`
+		} `json:"organization"`
+	}
+	if err := getJSON(ctx, githubAPI+"/user/teams?per_page=100", tok.AccessToken, &teams); err != nil {
+		return nil, fmt.Errorf("fetching GitHub teams: %w", err)
+	}
+	for _, t := range teams {
+		id.Groups = append(id.Groups, t.Organization.Login+"/"+t.Slug)
+	}
+	return id, nil
+}
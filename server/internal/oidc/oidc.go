@@ -0,0 +1,257 @@
+// Package oidc signs admin users in through an external identity provider:
+// any OpenID Connect issuer (Google Workspace, Okta, ...) or GitHub's OAuth
+// apps, with group-to-role mapping for just-in-time provisioning.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// loginTimeout is how long a user has to complete the IdP login
+const loginTimeout = 10 * time.Minute
+
+var (
+	// ErrUnknownState means the callback does not belong to a login started
+	// here, was already used or has expired
+	ErrUnknownState = errors.New("unknown or expired login state")
+	// ErrNotAllowed means the identity is valid but may not sign in
+	ErrNotAllowed = errors.New("identity is not allowed to sign in")
+)
+
+// Identity is the verified user returned by the provider
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Groups        []string
+}
+
+// Provider performs the authorization code exchange with one IdP
+type Provider interface {
+	// AuthCodeURL is where the browser is sent to log in
+	AuthCodeURL(ctx context.Context, state, nonce, challenge string) (string, error)
+	// Exchange redeems the authorization code and returns the identity
+	Exchange(ctx context.Context, code, verifier, nonce string) (*Identity, error)
+}
+
+// RoleMapping maps IdP groups to roles. Rules are tried in order; the group
+// "*" matches everyone.
+type RoleMapping []RoleRule
+
+// RoleRule grants Role to members of Group
+type RoleRule struct {
+	Group string
+	Role  string
+}
+
+// ParseRoleMapping parses "group=role,group=role,*=role"
+func ParseRoleMapping(s string, validRole func(string) bool) (RoleMapping, error) {
+	var m RoleMapping
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		group, role, ok := strings.Cut(part, "=")
+		group, role = strings.TrimSpace(group), strings.TrimSpace(role)
+		if !ok || group == "" || !validRole(role) {
+			return nil, fmt.Errorf("invalid role mapping %q", part)
+		}
+		m = append(m, RoleRule{Group: group, Role: role})
+	}
+	return m, nil
+}
+
+// Role returns the role for a set of groups, or false when no rule matches
+func (m RoleMapping) Role(groups []string) (string, bool) {
+	for _, rule := range m {
+		if rule.Group == "*" {
+			return rule.Role, true
+		}
+		for _, g := range groups {
+			if strings.EqualFold(g, rule.Group) {
+				return rule.Role, true
+			}
+		}
+	}
+	return "", false
+}
+
+type pendingLogin struct {
+	nonce    string
+	verifier string
+	expires  time.Time
+}
+
+// Flow runs logins against a provider and decides who may sign in and with
+// which role
+type Flow struct {
+	provider       Provider
+	Roles          RoleMapping
+	AllowedDomains []string
+	// LoginRedirect is the dashboard page that receives tokens after a
+	// successful login. Empty means the callback answers with JSON.
+	LoginRedirect string
+
+	mu      sync.Mutex
+	pending map[string]pendingLogin
+}
+
+// NewFlow creates a login flow for provider
+func NewFlow(provider Provider, roles RoleMapping) *Flow {
+	return &Flow{provider: provider, Roles: roles, pending: make(map[string]pendingLogin)}
+}
+
+// FromEnv configures single sign-on from OIDC_* variables. It returns nil
+// when OIDC_CLIENT_ID is not set.
+//
+//	OIDC_PROVIDER        "oidc" (default) or "github"
+//	OIDC_ISSUER          issuer URL, for OpenID Connect providers
+//	OIDC_CLIENT_ID       OAuth client ID
+//	OIDC_CLIENT_SECRET   OAuth client secret
+//	OIDC_REDIRECT_URL    the admin API's /auth/oidc/callback URL
+//	OIDC_SCOPES          extra scopes, space separated
+//	OIDC_GROUPS_CLAIM    ID token claim holding groups (default "groups")
+//	OIDC_ROLE_MAP        "group=role,...,*=role"; users matching no rule are refused
+//	OIDC_ALLOWED_DOMAINS email domains allowed to sign in, comma separated
+//	OIDC_LOGIN_REDIRECT  dashboard URL that receives the tokens
+func FromEnv(validRole func(string) bool) (*Flow, error) {
+	clientID := os.Getenv("OIDC_CLIENT_ID")
+	if clientID == "" {
+		return nil, nil
+	}
+	redirectURL := os.Getenv("OIDC_REDIRECT_URL")
+	if redirectURL == "" {
+		return nil, errors.New("OIDC_REDIRECT_URL is required")
+	}
+	if _, err := url.Parse(redirectURL); err != nil {
+		return nil, fmt.Errorf("invalid OIDC_REDIRECT_URL: %w", err)
+	}
+
+	roles, err := ParseRoleMapping(os.Getenv("OIDC_ROLE_MAP"), validRole)
+	if err != nil {
+		return nil, err
+	}
+	if len(roles) == 0 {
+		return nil, errors.New("OIDC_ROLE_MAP is required, e.g. \"admins=admin,*=readonly\"")
+	}
+
+	client := clientConfig{
+		ClientID:     clientID,
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  redirectURL,
+		Scopes:       strings.Fields(os.Getenv("OIDC_SCOPES")),
+	}
+
+	var provider Provider
+	switch strings.ToLower(os.Getenv("OIDC_PROVIDER")) {
+	case "", "oidc":
+		issuer := os.Getenv("OIDC_ISSUER")
+		if issuer == "" {
+			return nil, errors.New("OIDC_ISSUER is required")
+		}
+		groupsClaim := os.Getenv("OIDC_GROUPS_CLAIM")
+		if groupsClaim == "" {
+			groupsClaim = "groups"
+		}
+		provider = NewOIDCProvider(issuer, groupsClaim, client)
+	case "github":
+		provider = NewGitHubProvider(client)
+	default:
+		return nil, fmt.Errorf("unknown OIDC_PROVIDER %q", os.Getenv("OIDC_PROVIDER"))
+	}
+
+	flow := NewFlow(provider, roles)
+	flow.LoginRedirect = os.Getenv("OIDC_LOGIN_REDIRECT")
+	for _, d := range strings.Split(os.Getenv("OIDC_ALLOWED_DOMAINS"), ",") {
+		if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+			flow.AllowedDomains = append(flow.AllowedDomains, d)
+		}
+	}
+	return flow, nil
+}
+
+// Begin starts a login and returns the IdP URL to redirect the browser to
+func (f *Flow) Begin(ctx context.Context) (string, error) {
+	state, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	verifier, err := randomString()
+	if err != nil {
+		return "", err
+	}
+
+	f.mu.Lock()
+	now := time.Now()
+	for k, p := range f.pending {
+		if now.After(p.expires) {
+			delete(f.pending, k)
+		}
+	}
+	f.pending[state] = pendingLogin{nonce: nonce, verifier: verifier, expires: now.Add(loginTimeout)}
+	f.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(verifier))
+	return f.provider.AuthCodeURL(ctx, state, nonce, base64.RawURLEncoding.EncodeToString(sum[:]))
+}
+
+// Complete finishes a login from the callback's state and code. It returns
+// the identity and the role it maps to.
+func (f *Flow) Complete(ctx context.Context, state, code string) (*Identity, string, error) {
+	f.mu.Lock()
+	p, ok := f.pending[state]
+	delete(f.pending, state)
+	f.mu.Unlock()
+	if !ok || time.Now().After(p.expires) {
+		return nil, "", ErrUnknownState
+	}
+
+	id, err := f.provider.Exchange(ctx, code, p.verifier, p.nonce)
+	if err != nil {
+		return nil, "", err
+	}
+	if id.Email == "" || !id.EmailVerified {
+		return nil, "", fmt.Errorf("%w: the provider did not return a verified email address", ErrNotAllowed)
+	}
+
+	if len(f.AllowedDomains) > 0 {
+		_, domain, _ := strings.Cut(strings.ToLower(id.Email), "@")
+		allowed := false
+		for _, d := range f.AllowedDomains {
+			allowed = allowed || d == domain
+		}
+		if !allowed {
+			return nil, "", fmt.Errorf("%w: email domain %s", ErrNotAllowed, domain)
+		}
+	}
+
+	role, ok := f.Roles.Role(id.Groups)
+	if !ok {
+		return nil, "", fmt.Errorf("%w: no role is mapped to the user's groups", ErrNotAllowed)
+	}
+	return id, role, nil
+}
+
+func randomString() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
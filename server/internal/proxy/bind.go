@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+)
+
+// Bind opens the HTTP, HTTPS and TCP proxy listeners ahead of Run, so the
+// process can bind privileged ports and then drop to an unprivileged user.
+// Run opens any listener that was not bound here itself.
+func (p *ProxyServer) Bind(httpPort, httpsPort int) error {
+	addrs := map[string]string{
+		"http":  fmt.Sprintf(":%d", httpPort),
+		"https": fmt.Sprintf(":%d", httpsPort),
+	}
+	for protocol, port := range p.tcpPorts {
+		addrs["tcp/"+protocol] = fmt.Sprintf(":%d", port)
+	}
+
+	for name, addr := range addrs {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			p.bound.Range(func(_, v interface{}) bool {
+				v.(net.Listener).Close()
+				return true
+			})
+			return fmt.Errorf("%s listener on %s: %w", name, addr, err)
+		}
+		p.bound.Store(name, ln)
+	}
+	return nil
+}
+
+// listen returns the listener bound for name by Bind, or opens one on addr
+func (p *ProxyServer) listen(name, addr string) (net.Listener, error) {
+	if ln, ok := p.bound.LoadAndDelete(name); ok {
+		return ln.(net.Listener), nil
+	}
+	return net.Listen("tcp", addr)
+}
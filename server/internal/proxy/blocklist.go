@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"net"
+	"net/netip"
+	"sort"
+)
+
+// BlockedPrefix is an address range listed by a threat feed
+type BlockedPrefix struct {
+	FeedID int64
+	Prefix netip.Prefix
+}
+
+// Blocklist holds the addresses listed by threat feeds. It applies to every
+// domain and listener, before the domains' own IP rules; ranges on the
+// allowlist are exempt.
+type Blocklist struct {
+	ranges []blockRange // sorted by first address, not overlapping
+	allow  []netip.Prefix
+}
+
+type blockRange struct {
+	first, last netip.Addr
+	feedID      int64
+}
+
+// NewBlocklist builds a blocklist from the feeds' ranges and the allowlist.
+// Overlapping ranges are merged; a blocked address is counted against the
+// feed of the range starting first.
+func NewBlocklist(blocked []BlockedPrefix, allow []netip.Prefix) *Blocklist {
+	ranges := make([]blockRange, 0, len(blocked))
+	for _, b := range blocked {
+		if !b.Prefix.IsValid() {
+			continue
+		}
+		p := unmapPrefix(b.Prefix).Masked()
+		ranges = append(ranges, blockRange{first: p.Addr(), last: lastAddr(p), feedID: b.FeedID})
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].first.Less(ranges[j].first) })
+
+	merged := ranges[:0]
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && !merged[n-1].last.Less(r.first) {
+			if merged[n-1].last.Less(r.last) {
+				merged[n-1].last = r.last
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+
+	b := &Blocklist{ranges: merged}
+	for _, p := range allow {
+		if p.IsValid() {
+			b.allow = append(b.allow, unmapPrefix(p).Masked())
+		}
+	}
+	return b
+}
+
+// Len returns the number of blocked ranges after merging
+func (b *Blocklist) Len() int {
+	return len(b.ranges)
+}
+
+// match returns the feed blocking ip, if any
+func (b *Blocklist) match(ip netip.Addr) (feedID int64, ok bool) {
+	ip = ip.Unmap().WithZone("")
+	i := sort.Search(len(b.ranges), func(i int) bool { return ip.Less(b.ranges[i].first) })
+	if i == 0 || b.ranges[i-1].last.Less(ip) {
+		return 0, false
+	}
+	for _, p := range b.allow {
+		if p.Contains(ip) {
+			return 0, false
+		}
+	}
+	return b.ranges[i-1].feedID, true
+}
+
+// unmapPrefix turns an IPv4-mapped IPv6 prefix into the IPv4 one
+func unmapPrefix(p netip.Prefix) netip.Prefix {
+	if p.Addr().Is4In6() && p.Bits() >= 96 {
+		return netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+	}
+	return p
+}
+
+// lastAddr returns the highest address in a masked prefix
+func lastAddr(p netip.Prefix) netip.Addr {
+	if p.Addr().Is4() {
+		a := p.Addr().As4()
+		for i := p.Bits(); i < 32; i++ {
+			a[i/8] |= 0x80 >> (i % 8)
+		}
+		return netip.AddrFrom4(a)
+	}
+	a := p.Addr().As16()
+	for i := p.Bits(); i < 128; i++ {
+		a[i/8] |= 0x80 >> (i % 8)
+	}
+	return netip.AddrFrom16(a)
+}
+
+// SetBlocklist replaces the addresses blocked by threat feeds; nil blocks
+// none
+func (p *ProxyServer) SetBlocklist(b *Blocklist) {
+	p.blocklist.Store(b)
+}
+
+// BlocklistSize returns the number of ranges blocked by threat feeds
+func (p *ProxyServer) BlocklistSize() int {
+	if b := p.blocklist.Load(); b != nil {
+		return b.Len()
+	}
+	return 0
+}
+
+// blockedByFeed reports whether a client address is on the blocklist, and
+// counts the block against the feed listing it
+func (p *ProxyServer) blockedByFeed(remoteAddr string) bool {
+	b := p.blocklist.Load()
+	if b == nil {
+		return false
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	feedID, ok := b.match(ip)
+	if ok {
+		p.metrics.RecordBlocked(feedID)
+	}
+	return ok
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"net/netip"
+	"sync/atomic"
+	"testing"
+)
+
+func TestBlocklist(t *testing.T) {
+	b := NewBlocklist([]BlockedPrefix{
+		{FeedID: 1, Prefix: netip.MustParsePrefix("192.0.2.0/24")},
+		{FeedID: 2, Prefix: netip.MustParsePrefix("192.0.2.128/25")}, // inside feed 1's range
+		{FeedID: 2, Prefix: netip.MustParsePrefix("198.51.100.7/32")},
+		{FeedID: 3, Prefix: netip.MustParsePrefix("::ffff:203.0.113.0/120")},
+		{FeedID: 3, Prefix: netip.MustParsePrefix("2001:db8::/32")},
+		{FeedID: 4},
+	}, []netip.Prefix{netip.MustParsePrefix("192.0.2.10/32")})
+
+	if b.Len() != 4 {
+		t.Errorf("Len() = %d, want 4 after merging", b.Len())
+	}
+
+	tests := []struct {
+		ip      string
+		feedID  int64
+		blocked bool
+	}{
+		{"192.0.2.1", 1, true},
+		{"192.0.2.200", 1, true},
+		{"192.0.2.10", 0, false}, // allowlisted
+		{"192.0.3.0", 0, false},
+		{"198.51.100.7", 2, true},
+		{"198.51.100.8", 0, false},
+		{"203.0.113.9", 3, true},
+		{"::ffff:198.51.100.7", 2, true},
+		{"2001:db8:ffff::1", 3, true},
+		{"2001:db9::1", 0, false},
+		{"10.0.0.1", 0, false},
+	}
+	for _, tt := range tests {
+		feedID, ok := b.match(netip.MustParseAddr(tt.ip))
+		if ok != tt.blocked || feedID != tt.feedID {
+			t.Errorf("match(%s) = %d, %v, want %d, %v", tt.ip, feedID, ok, tt.feedID, tt.blocked)
+		}
+	}
+}
+
+func TestBlockedByFeed(t *testing.T) {
+	p := &ProxyServer{metrics: &MetricsCollector{}}
+	if p.blockedByFeed("192.0.2.1:443") {
+		t.Error("blocked without a blocklist")
+	}
+
+	p.SetBlocklist(NewBlocklist([]BlockedPrefix{{FeedID: 7, Prefix: netip.MustParsePrefix("192.0.2.0/24")}}, nil))
+	if !p.blockedByFeed("192.0.2.1:443") || !p.blockedByFeed("192.0.2.2") {
+		t.Error("listed address not blocked")
+	}
+	if p.blockedByFeed("[2001:db8::1]:443") || p.blockedByFeed("not an address") {
+		t.Error("unlisted address blocked")
+	}
+	if n, ok := p.metrics.blocked.Load(int64(7)); !ok || n.(*atomic.Int64).Load() != 2 {
+		t.Errorf("blocks counted against feed 7 = %v, want 2", n)
+	}
+	if p.BlocklistSize() != 1 {
+		t.Errorf("BlocklistSize() = %d, want 1", p.BlocklistSize())
+	}
+}
@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Subjects published on the cluster bus
+const (
+	// SubjectConfigChanged asks every node to reload its domains
+	SubjectConfigChanged = "viacortex.config.changed"
+	// SubjectCertIssued announces a certificate one node obtained, so the
+	// others load it from shared storage
+	SubjectCertIssued = "viacortex.cert.issued"
+)
+
+// Bus carries change events between the nodes of a cluster, so they
+// converge as soon as something changes instead of on their next poll of
+// the database
+type Bus interface {
+	Publish(subject string, data []byte) error
+	Subscribe(subject string, handle func(data []byte)) error
+}
+
+// certIssued is the payload of SubjectCertIssued
+type certIssued struct {
+	Domain string `json:"domain"`
+}
+
+// SetBus makes the loader reload on SubjectConfigChanged instead of
+// listening for notifications from Postgres. It must be called before
+// Start.
+func (l *Loader) SetBus(b Bus) error {
+	if err := b.Subscribe(SubjectConfigChanged, func([]byte) { l.requestReload() }); err != nil {
+		return err
+	}
+	l.bus = b
+	return nil
+}
+
+// SetBus announces certificates this node obtains and, with shared
+// certificate storage, picks up those obtained by other nodes. It must be
+// called after ConfigureCertmagic.
+func (p *ProxyServer) SetBus(b Bus) error {
+	p.bus = b
+	if p.certStorage == nil {
+		// Other nodes' certificates are not reachable from here
+		return nil
+	}
+	return b.Subscribe(SubjectCertIssued, func(data []byte) {
+		var msg certIssued
+		if err := json.Unmarshal(data, &msg); err != nil || msg.Domain == "" {
+			return
+		}
+		if _, err := p.certManager.CacheManagedCertificate(context.Background(), msg.Domain); err != nil {
+			acmeLog.Error("Loading certificate issued by another node failed", "domain", msg.Domain, "error", err)
+			return
+		}
+		p.certPending.Delete(HostKey(msg.Domain))
+	})
+}
+
+// publishCertIssued tells the other nodes about a new certificate
+func (p *ProxyServer) publishCertIssued(domain string) {
+	if p.bus == nil || domain == "" {
+		return
+	}
+	data, _ := json.Marshal(certIssued{Domain: domain})
+	if err := p.bus.Publish(SubjectCertIssued, data); err != nil {
+		acmeLog.Warn("Publishing certificate failed", "domain", domain, "error", err)
+	}
+}
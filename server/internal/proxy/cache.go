@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CacheRule sets Cache-Control on responses whose path starts with
+// PathPrefix and whose media type starts with ContentType, e.g. "image/".
+// An empty criterion matches everything. Unless Override is set, responses
+// that already have a Cache-Control header are left as they are.
+type CacheRule struct {
+	ID           int64
+	PathPrefix   string
+	ContentType  string
+	CacheControl string
+	Override     bool
+}
+
+func (c *CacheRule) equal(o *CacheRule) bool {
+	return *c == *o
+}
+
+func (c *CacheRule) matches(path, mediaType string) bool {
+	return strings.HasPrefix(path, c.PathPrefix) && strings.HasPrefix(mediaType, c.ContentType)
+}
+
+// maxAge returns the max-age directive of a Cache-Control value
+func maxAge(cacheControl string) (time.Duration, bool) {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if strings.EqualFold(name, "max-age") {
+			seconds, err := strconv.Atoi(strings.Trim(value, `"`))
+			if err != nil || seconds < 0 {
+				return 0, false
+			}
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+	return 0, false
+}
+
+// applyCacheRules sets the caching headers of the first rule matching a
+// successful response. Expires follows max-age for HTTP/1.0 caches.
+func applyCacheRules(resp *http.Response, config *DomainConfig) {
+	if len(config.CacheRules) == 0 {
+		return
+	}
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotModified {
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	for _, rule := range config.CacheRules {
+		if !rule.matches(resp.Request.URL.Path, mediaType) {
+			continue
+		}
+		if !rule.Override && resp.Header.Get("Cache-Control") != "" {
+			return
+		}
+		resp.Header.Set("Cache-Control", rule.CacheControl)
+		if age, ok := maxAge(rule.CacheControl); ok {
+			resp.Header.Set("Expires", time.Now().Add(age).UTC().Format(http.TimeFormat))
+		} else {
+			resp.Header.Del("Expires")
+		}
+		return
+	}
+}
@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestMaxAge(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"public, max-age=3600", time.Hour, true},
+		{"MAX-AGE=60", time.Minute, true},
+		{`max-age="10"`, 10 * time.Second, true},
+		{"s-maxage=60, max-age=0", 0, true},
+		{"no-store", 0, false},
+		{"max-age=soon", 0, false},
+		{"max-age=-1", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := maxAge(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("maxAge(%q) = %v, %v, want %v, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestApplyCacheRules(t *testing.T) {
+	config := &DomainConfig{CacheRules: []*CacheRule{
+		{PathPrefix: "/static/", CacheControl: "public, max-age=86400", Override: true},
+		{ContentType: "image/", CacheControl: "public, max-age=3600"},
+		{PathPrefix: "/api/", ContentType: "application/json", CacheControl: "no-store"},
+	}}
+	tests := []struct {
+		name        string
+		path        string
+		status      int
+		contentType string
+		existing    string
+		want        string
+		expires     bool
+	}{
+		{"path prefix", "/static/app.js", 200, "text/javascript", "", "public, max-age=86400", true},
+		{"override", "/static/app.js", 200, "text/javascript", "no-cache", "public, max-age=86400", true},
+		{"content type", "/logo.png", 200, "image/png", "", "public, max-age=3600", true},
+		{"backend header kept", "/logo.png", 200, "image/png", "private", "private", false},
+		{"both criteria", "/api/users", 200, "application/json; charset=utf-8", "", "no-store", false},
+		{"one criterion", "/users", 200, "application/json", "", "", false},
+		{"not modified", "/logo.png", 304, "image/png", "", "public, max-age=3600", true},
+		{"error", "/static/missing.js", 404, "text/html", "", "", false},
+		{"redirect", "/static/", 301, "", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{
+				StatusCode: tt.status,
+				Header:     http.Header{},
+				Request:    &http.Request{URL: &url.URL{Path: tt.path}},
+			}
+			resp.Header.Set("Content-Type", tt.contentType)
+			if tt.existing != "" {
+				resp.Header.Set("Cache-Control", tt.existing)
+			}
+			applyCacheRules(resp, config)
+			if got := resp.Header.Get("Cache-Control"); got != tt.want {
+				t.Errorf("Cache-Control = %q, want %q", got, tt.want)
+			}
+			if got := resp.Header.Get("Expires") != ""; got != tt.expires {
+				t.Errorf("Expires set = %v, want %v", got, tt.expires)
+			}
+		})
+	}
+}
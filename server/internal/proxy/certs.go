@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+)
+
+// CertificateStatus describes the certificate stored for a domain
+type CertificateStatus struct {
+	Domain    string     `json:"domain"`
+	Present   bool       `json:"present"`
+	Issuer    string     `json:"issuer,omitempty"`
+	Names     []string   `json:"names,omitempty"`
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	NotAfter  *time.Time `json:"not_after,omitempty"`
+	Expired   bool       `json:"expired"`
+}
+
+// DomainKey returns the host a domain is served and certified under, derived
+// from its target URL by stripping any protocol prefix
+func DomainKey(targetURL string) string {
+	for _, prefix := range []string{"tcp://", "https://", "http://"} {
+		if strings.HasPrefix(targetURL, prefix) {
+			return strings.TrimPrefix(targetURL, prefix)
+		}
+	}
+	return targetURL
+}
+
+// HostKey normalizes a hostname into the key requests are routed by:
+// lower case, without scheme, port or trailing dot
+func HostKey(host string) string {
+	host = strings.ToLower(strings.TrimSpace(DomainKey(host)))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(host, ".")
+}
+
+// CertificateStatus reads the certificate for domain from certmagic storage
+// without triggering issuance
+func (p *ProxyServer) CertificateStatus(ctx context.Context, domain string) CertificateStatus {
+	status := CertificateStatus{Domain: domain}
+
+	for _, issuer := range p.certManager.Issuers {
+		certPEM, err := p.certManager.Storage.Load(ctx, certmagic.StorageKeys.SiteCert(issuer.IssuerKey(), domain))
+		if err != nil {
+			continue
+		}
+
+		block, _ := pem.Decode(certPEM)
+		if block == nil {
+			continue
+		}
+		leaf, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+
+		notBefore, notAfter := leaf.NotBefore, leaf.NotAfter
+		status.Present = true
+		status.Issuer = leaf.Issuer.CommonName
+		status.Names = leaf.DNSNames
+		status.NotBefore = &notBefore
+		status.NotAfter = &notAfter
+		status.Expired = time.Now().After(notAfter)
+		return status
+	}
+
+	return status
+}
+
+// ACMEDirectory returns the directory URL of the ACME CA certificates are
+// requested from
+func ACMEDirectory() string {
+	return certmagic.DefaultACME.CA
+}
@@ -0,0 +1,28 @@
+package proxy
+
+import "testing"
+
+func TestHostKey(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"example.com", "example.com"},
+		{"Example.COM", "example.com"},
+		{"example.com:8080", "example.com"},
+		{"example.com.", "example.com"},
+		{"example.com.:443", "example.com"},
+		{" example.com ", "example.com"},
+		{"https://example.com", "example.com"},
+		{"http://Example.com:80", "example.com"},
+		{"tcp://mc.example.com:25565", "mc.example.com"},
+		{"10.0.0.1:8080", "10.0.0.1"},
+		{"[::1]:8080", "::1"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := HostKey(tt.host); got != tt.want {
+			t.Errorf("HostKey(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
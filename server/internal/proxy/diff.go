@@ -0,0 +1,89 @@
+package proxy
+
+// Equal reports whether two domain configurations would route traffic the
+// same way. Runtime state, such as the round-robin position, pooled
+// connections and when a backend was last checked, is ignored.
+func (c *DomainConfig) Equal(o *DomainConfig) bool {
+	if c == nil || o == nil {
+		return c == o
+	}
+	if c.Domain != o.Domain || c.SSLEnabled != o.SSLEnabled ||
+		c.HealthCheckEnabled != o.HealthCheckEnabled || c.Enabled != o.Enabled ||
+		c.ServerTiming != o.ServerTiming {
+		return false
+	}
+	if !c.RateLimit.equal(o.RateLimit) || !c.Transport.equal(o.Transport) ||
+		!c.Faults.equal(o.Faults) || !c.Rewrite.equal(o.Rewrite) {
+		return false
+	}
+
+	if len(c.Backends) != len(o.Backends) {
+		return false
+	}
+	for i := range c.Backends {
+		if !c.Backends[i].equal(o.Backends[i]) {
+			return false
+		}
+	}
+
+	if len(c.CacheRules) != len(o.CacheRules) {
+		return false
+	}
+	for i := range c.CacheRules {
+		if !c.CacheRules[i].equal(o.CacheRules[i]) {
+			return false
+		}
+	}
+
+	if len(c.EarlyHints) != len(o.EarlyHints) {
+		return false
+	}
+	for i := range c.EarlyHints {
+		if !c.EarlyHints[i].equal(o.EarlyHints[i]) {
+			return false
+		}
+	}
+
+	if len(c.Plugins) != len(o.Plugins) {
+		return false
+	}
+	for i := range c.Plugins {
+		if !c.Plugins[i].equal(o.Plugins[i]) {
+			return false
+		}
+	}
+
+	if len(c.IPRules) != len(o.IPRules) {
+		return false
+	}
+	for i := range c.IPRules {
+		if !c.IPRules[i].equal(o.IPRules[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *BackendServer) equal(o *BackendServer) bool {
+	return b.ID == o.ID && b.Scheme == o.Scheme && b.IP.Equal(o.IP) && b.Port == o.Port &&
+		b.Weight == o.Weight && b.IsActive == o.IsActive && equalStringPtr(b.HealthStatus, o.HealthStatus)
+}
+
+func (r *IPRule) equal(o *IPRule) bool {
+	return r.ID == o.ID && r.RuleType == o.RuleType && r.Description == o.Description &&
+		r.IPRange.String() == o.IPRange.String()
+}
+
+func (r *RateLimit) equal(o *RateLimit) bool {
+	if r == nil || o == nil {
+		return r == o
+	}
+	return *r == *o
+}
+
+func equalStringPtr(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
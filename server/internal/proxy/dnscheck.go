@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// DNS check outcomes
+const (
+	DNSStatusOK         = "ok"
+	DNSStatusMismatch   = "mismatch"
+	DNSStatusUnresolved = "unresolved"
+	DNSStatusUnknown    = "unknown" // no public IPs known, check not possible
+)
+
+// DNSStatus is the result of checking that a domain resolves to this proxy
+type DNSStatus struct {
+	Domain    string    `json:"domain"`
+	Status    string    `json:"status"`
+	Resolved  []string  `json:"resolved"`
+	Expected  []string  `json:"expected"`
+	Message   string    `json:"message"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Ready reports whether certificate issuance should be attempted
+func (s DNSStatus) Ready() bool {
+	return s.Status == DNSStatusOK || s.Status == DNSStatusUnknown
+}
+
+// SetPublicIPs sets the addresses A/AAAA records of SSL domains must point at
+func (p *ProxyServer) SetPublicIPs(ips []net.IP) {
+	p.publicIPs = ips
+}
+
+// PublicIPsFromEnv reads PUBLIC_IPS (comma separated). When unset, globally
+// routable addresses of the local interfaces are used instead.
+func PublicIPsFromEnv() []net.IP {
+	var ips []net.IP
+	if env := os.Getenv("PUBLIC_IPS"); env != "" {
+		for _, s := range strings.Split(env, ",") {
+			if ip := net.ParseIP(strings.TrimSpace(s)); ip != nil {
+				ips = append(ips, ip)
+			} else {
+				logger.Warn("Ignoring invalid PUBLIC_IPS entry", "entry", s)
+			}
+		}
+		return ips
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		logger.Warn("Listing interface addresses failed", "error", err)
+		return nil
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipNet.IP
+		if ip.IsGlobalUnicast() && !ip.IsPrivate() {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// CheckDNS resolves domain and compares its A/AAAA records with the proxy's
+// public IPs. Every resolved address must belong to the proxy, otherwise the
+// ACME HTTP-01 validation may hit another host.
+func (p *ProxyServer) CheckDNS(ctx context.Context, domain string) DNSStatus {
+	status := DNSStatus{
+		Domain:    domain,
+		Resolved:  []string{},
+		Expected:  []string{},
+		CheckedAt: time.Now().UTC(),
+	}
+
+	expected := make(map[string]bool, len(p.publicIPs))
+	for _, ip := range p.publicIPs {
+		expected[ip.String()] = true
+		status.Expected = append(status.Expected, ip.String())
+	}
+
+	lookupCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(lookupCtx, domain)
+	if err != nil || len(addrs) == 0 {
+		status.Status = DNSStatusUnresolved
+		status.Message = fmt.Sprintf("%s has no A/AAAA records", domain)
+		if err != nil {
+			status.Message = fmt.Sprintf("%s could not be resolved: %v", domain, err)
+		}
+		p.dnsStatus.Store(domain, status)
+		return status
+	}
+
+	var foreign []string
+	for _, addr := range addrs {
+		status.Resolved = append(status.Resolved, addr.IP.String())
+		if !expected[addr.IP.String()] {
+			foreign = append(foreign, addr.IP.String())
+		}
+	}
+
+	switch {
+	case len(expected) == 0:
+		status.Status = DNSStatusUnknown
+		status.Message = "public IPs of this proxy are unknown, set PUBLIC_IPS to enable the check"
+	case len(foreign) > 0:
+		status.Status = DNSStatusMismatch
+		status.Message = fmt.Sprintf("%s resolves to %s which is not this proxy (%s)",
+			domain, strings.Join(foreign, ", "), strings.Join(status.Expected, ", "))
+	default:
+		status.Status = DNSStatusOK
+		status.Message = fmt.Sprintf("%s points to this proxy", domain)
+	}
+
+	p.dnsStatus.Store(domain, status)
+	return status
+}
+
+// LastDNSStatus returns the result of the most recent DNS check for domain
+func (p *ProxyServer) LastDNSStatus(domain string) (DNSStatus, bool) {
+	v, ok := p.dnsStatus.Load(domain)
+	if !ok {
+		return DNSStatus{}, false
+	}
+	return v.(DNSStatus), true
+}
@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"net/http"
+	"time"
+)
+
+// drainPoll is how often a draining proxy checks for remaining requests
+const drainPoll = 500 * time.Millisecond
+
+// DrainStatus describes a node being taken out of rotation
+type DrainStatus struct {
+	Draining bool      `json:"draining"`
+	Since    time.Time `json:"since,omitempty"`
+	// Drained is set once in-flight requests have finished and the final
+	// metrics were flushed; the node can then be stopped without loss
+	Drained  bool  `json:"drained"`
+	InFlight int64 `json:"in_flight"`
+}
+
+// Drain takes the proxy out of rotation ahead of a restart. Readiness
+// reports the node as unavailable so load balancers stop sending traffic,
+// client connections are no longer kept alive, and once in-flight requests
+// and TCP connections finish the metrics are flushed a final time.
+func (p *ProxyServer) Drain() {
+	p.drainMu.Lock()
+	defer p.drainMu.Unlock()
+	if p.drain.Draining {
+		return
+	}
+	p.drain = DrainStatus{Draining: true, Since: time.Now()}
+	p.setKeepAlives(false)
+	logger.Info("Draining: waiting for in-flight requests to finish")
+
+	since := p.drain.Since
+	go func() {
+		for p.inFlight.Load() > 0 {
+			time.Sleep(drainPoll)
+		}
+		p.metrics.Flush()
+
+		p.drainMu.Lock()
+		defer p.drainMu.Unlock()
+		// Drain may have been cancelled and started again meanwhile
+		if p.drain.Draining && p.drain.Since == since {
+			p.drain.Drained = true
+			logger.Info("Drained: the node can be stopped")
+		}
+	}()
+}
+
+// Undrain puts a draining proxy back into rotation
+func (p *ProxyServer) Undrain() {
+	p.drainMu.Lock()
+	defer p.drainMu.Unlock()
+	if !p.drain.Draining {
+		return
+	}
+	p.drain = DrainStatus{}
+	p.setKeepAlives(true)
+	logger.Info("Drain cancelled: serving traffic again")
+}
+
+// Draining reports whether the proxy is out of rotation
+func (p *ProxyServer) Draining() bool {
+	p.drainMu.Lock()
+	defer p.drainMu.Unlock()
+	return p.drain.Draining
+}
+
+// DrainStatus returns the drain state and the requests still in flight
+func (p *ProxyServer) DrainStatus() DrainStatus {
+	p.drainMu.Lock()
+	status := p.drain
+	p.drainMu.Unlock()
+	status.InFlight = p.inFlight.Load()
+	return status
+}
+
+// setKeepAlives toggles keep-alives on the HTTP and HTTPS servers. Turning
+// them off also closes idle connections.
+func (p *ProxyServer) setKeepAlives(enabled bool) {
+	p.serversMu.Lock()
+	defer p.serversMu.Unlock()
+	for _, s := range p.servers {
+		s.SetKeepAlivesEnabled(enabled)
+	}
+}
+
+// addServer registers a server whose keep-alives follow the drain state
+func (p *ProxyServer) addServer(s *http.Server) {
+	p.serversMu.Lock()
+	defer p.serversMu.Unlock()
+	p.servers = append(p.servers, s)
+	if p.drain.Draining {
+		s.SetKeepAlivesEnabled(false)
+	}
+}
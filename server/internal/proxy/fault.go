@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Fault injects failures into a share of a domain's requests so teams can
+// check how their clients retry. A fault stops applying at ExpiresAt even
+// if the configuration is not reloaded.
+type Fault struct {
+	Percent     int           // share of requests affected, 1-100
+	Delay       time.Duration // added before the request is handled
+	ErrorStatus int           // answer with this status instead of proxying, if set
+	Reset       bool          // drop the connection instead of answering
+	ExpiresAt   time.Time
+}
+
+// faultHeader marks responses produced by fault injection, so they are not
+// mistaken for real failures
+const faultHeader = "X-ViaCortex-Fault"
+
+func (f *Fault) equal(o *Fault) bool {
+	if f == nil || o == nil {
+		return f == o
+	}
+	return f.Percent == o.Percent && f.Delay == o.Delay && f.ErrorStatus == o.ErrorStatus &&
+		f.Reset == o.Reset && f.ExpiresAt.Equal(o.ExpiresAt)
+}
+
+// active reports whether the fault still applies
+func (f *Fault) active() bool {
+	return f != nil && time.Now().Before(f.ExpiresAt)
+}
+
+// injectFault applies the domain's fault to a request it selects. It
+// reports whether the request was answered, in which case it must not be
+// proxied.
+func (p *ProxyServer) injectFault(w http.ResponseWriter, r *http.Request, config *DomainConfig, start time.Time) bool {
+	f := config.Faults
+	if !f.active() || rand.Intn(100) >= f.Percent {
+		return false
+	}
+
+	if f.Delay > 0 {
+		select {
+		case <-time.After(f.Delay):
+		case <-r.Context().Done():
+			return true
+		}
+	}
+
+	switch {
+	case f.Reset:
+		logger.Debug("Injected connection reset", "domain", config.Domain)
+		if !p.isProbe(r) {
+			p.metrics.RecordError(config.Domain)
+		}
+		// net/http closes the connection without writing a response
+		panic(http.ErrAbortHandler)
+	case f.ErrorStatus != 0:
+		logger.Debug("Injected error response", "domain", config.Domain, "status", f.ErrorStatus)
+		w.Header().Set(faultHeader, "error")
+		http.Error(w, "Injected fault: "+strconv.Itoa(f.ErrorStatus), f.ErrorStatus)
+		if !p.isProbe(r) {
+			p.metrics.RecordRequest(config.Domain, f.ErrorStatus, time.Since(start))
+		}
+		return true
+	}
+	if f.Delay > 0 {
+		w.Header().Set(faultHeader, "delay")
+	}
+	return false
+}
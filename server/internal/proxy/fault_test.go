@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFaultEqual(t *testing.T) {
+	expires := time.Now().Add(time.Hour)
+	f := &Fault{Percent: 50, Delay: time.Second, ErrorStatus: 503, ExpiresAt: expires}
+	tests := []struct {
+		name string
+		a, b *Fault
+		want bool
+	}{
+		{"both nil", nil, nil, true},
+		{"one nil", f, nil, false},
+		{"same values", f, &Fault{Percent: 50, Delay: time.Second, ErrorStatus: 503, ExpiresAt: expires}, true},
+		{"same instant in another zone", f, &Fault{Percent: 50, Delay: time.Second, ErrorStatus: 503, ExpiresAt: expires.UTC()}, true},
+		{"different percent", f, &Fault{Percent: 10, Delay: time.Second, ErrorStatus: 503, ExpiresAt: expires}, false},
+		{"different expiry", f, &Fault{Percent: 50, Delay: time.Second, ErrorStatus: 503, ExpiresAt: expires.Add(time.Minute)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.a.equal(tt.b); got != tt.want {
+				t.Errorf("equal = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInjectFault(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	tests := []struct {
+		name        string
+		fault       *Fault
+		wantHandled bool
+		wantStatus  int
+		wantHeader  string
+	}{
+		{"no fault", nil, false, http.StatusOK, ""},
+		{"expired", &Fault{Percent: 100, ErrorStatus: 503, ExpiresAt: time.Now().Add(-time.Second)}, false, http.StatusOK, ""},
+		{"error status", &Fault{Percent: 100, ErrorStatus: 503, ExpiresAt: future}, true, http.StatusServiceUnavailable, "error"},
+		{"delay only", &Fault{Percent: 100, Delay: time.Millisecond, ExpiresAt: future}, false, http.StatusOK, "delay"},
+		{"delay then error", &Fault{Percent: 100, Delay: time.Millisecond, ErrorStatus: 429, ExpiresAt: future}, true, http.StatusTooManyRequests, "error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &ProxyServer{metrics: NewMetricsCollector()}
+			config := &DomainConfig{Domain: "example.com", Faults: tt.fault}
+			rec := httptest.NewRecorder()
+			handled := p.injectFault(rec, httptest.NewRequest(http.MethodGet, "/", nil), config, time.Now())
+			if handled != tt.wantHandled {
+				t.Fatalf("handled = %v, want %v", handled, tt.wantHandled)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get(faultHeader); got != tt.wantHeader {
+				t.Errorf("%s = %q, want %q", faultHeader, got, tt.wantHeader)
+			}
+		})
+	}
+}
+
+func TestInjectFaultReset(t *testing.T) {
+	p := &ProxyServer{metrics: NewMetricsCollector()}
+	config := &DomainConfig{Domain: "example.com", Faults: &Fault{Percent: 100, Reset: true, ExpiresAt: time.Now().Add(time.Hour)}}
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler", v)
+		}
+	}()
+	p.injectFault(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil), config, time.Now())
+	t.Error("reset did not abort the handler")
+}
+
+func TestInjectFaultCanceledDuringDelay(t *testing.T) {
+	p := &ProxyServer{metrics: NewMetricsCollector()}
+	config := &DomainConfig{Domain: "example.com", Faults: &Fault{Percent: 100, Delay: time.Hour, ExpiresAt: time.Now().Add(time.Hour)}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	if !p.injectFault(httptest.NewRecorder(), r, config, time.Now()) {
+		t.Error("a request canceled during the delay must not be proxied")
+	}
+}
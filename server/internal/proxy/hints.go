@@ -0,0 +1,42 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+)
+
+// EarlyHint is a Link header, e.g. "</app.css>; rel=preload; as=style", sent
+// as a 103 Early Hints response to requests for paths under PathPrefix so
+// browsers start loading while the backend is still working
+type EarlyHint struct {
+	ID         int64
+	PathPrefix string
+	Link       string
+}
+
+func (e *EarlyHint) equal(o *EarlyHint) bool {
+	return *e == *o
+}
+
+// sendEarlyHints writes a 103 response with the hints matching a page
+// request. The Link headers stay set for the final response as well.
+func sendEarlyHints(w http.ResponseWriter, r *http.Request, config *DomainConfig) {
+	if len(config.EarlyHints) == 0 || r.Method != http.MethodGet {
+		return
+	}
+	// HTTP/1.0 clients do not expect informational responses
+	if !r.ProtoAtLeast(1, 1) {
+		return
+	}
+
+	sent := false
+	for _, hint := range config.EarlyHints {
+		if strings.HasPrefix(r.URL.Path, hint.PathPrefix) {
+			w.Header().Add("Link", hint.Link)
+			sent = true
+		}
+	}
+	if sent {
+		w.WriteHeader(http.StatusEarlyHints)
+	}
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"reflect"
+	"testing"
+)
+
+func TestSendEarlyHints(t *testing.T) {
+	config := &DomainConfig{EarlyHints: []*EarlyHint{
+		{PathPrefix: "/", Link: "</app.css>; rel=preload; as=style"},
+		{PathPrefix: "/docs/", Link: "</docs.js>; rel=modulepreload"},
+	}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sendEarlyHints(w, r, config)
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		want   []string
+	}{
+		{"all pages", http.MethodGet, "/", []string{"</app.css>; rel=preload; as=style"}},
+		{"path prefix", http.MethodGet, "/docs/intro", []string{"</app.css>; rel=preload; as=style", "</docs.js>; rel=modulepreload"}},
+		{"not a page request", http.MethodPost, "/docs/intro", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hints []string
+			trace := &httptrace.ClientTrace{
+				Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+					if code == http.StatusEarlyHints {
+						hints = header["Link"]
+					}
+					return nil
+				},
+			}
+			ctx := httptrace.WithClientTrace(context.Background(), trace)
+			req, _ := http.NewRequestWithContext(ctx, tt.method, srv.URL+tt.path, nil)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if !reflect.DeepEqual(hints, tt.want) {
+				t.Errorf("early hints = %q, want %q", hints, tt.want)
+			}
+			// The final response carries the hints too
+			if got := resp.Header["Link"]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("final Link = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendEarlyHintsHTTP10(t *testing.T) {
+	config := &DomainConfig{EarlyHints: []*EarlyHint{{PathPrefix: "/", Link: "</app.css>; rel=preload"}}}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.ProtoMajor, r.ProtoMinor = 1, 0
+	rec := httptest.NewRecorder()
+	sendEarlyHints(rec, r, config)
+	if got := rec.Header().Get("Link"); got != "" {
+		t.Errorf("Link = %q, want no hints for HTTP/1.0", got)
+	}
+}
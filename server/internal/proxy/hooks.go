@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// RequestHook sees a request after the proxy's own checks, before it is sent
+// to a backend. It may change the request, or answer it and return true to
+// stop it from being proxied.
+type RequestHook func(w http.ResponseWriter, r *http.Request, domain string) bool
+
+// ResponseHook sees a backend's response before it is sent to the client and
+// may change it. An error turns the response into a 502.
+type ResponseHook func(resp *http.Response, domain string) error
+
+// ErrorHook is told about requests that failed to reach a backend, or whose
+// response a hook rejected
+type ErrorHook func(r *http.Request, domain string, err error)
+
+type hookEntry struct {
+	order int
+	seq   uint64
+	fn    interface{}
+}
+
+// hookSet is replaced as a whole on every change, so requests read it
+// without locking
+type hookSet struct {
+	request  []hookEntry
+	response []hookEntry
+	errors   []hookEntry
+}
+
+type hookRegistry struct {
+	mu  sync.Mutex
+	seq uint64
+	set atomic.Pointer[hookSet]
+}
+
+func (h *hookRegistry) current() *hookSet {
+	if s := h.set.Load(); s != nil {
+		return s
+	}
+	return &hookSet{}
+}
+
+// update applies change to a copy of the hooks and installs it
+func (h *hookRegistry) update(change func(*hookSet)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	old := h.current()
+	next := &hookSet{
+		request:  append([]hookEntry(nil), old.request...),
+		response: append([]hookEntry(nil), old.response...),
+		errors:   append([]hookEntry(nil), old.errors...),
+	}
+	change(next)
+	h.set.Store(next)
+}
+
+// add registers a hook in one of the lists and returns its removal
+func (h *hookRegistry) add(list func(*hookSet) *[]hookEntry, order int, fn interface{}, descending bool) func() {
+	var seq uint64
+	h.update(func(s *hookSet) {
+		h.seq++
+		seq = h.seq
+		l := list(s)
+		*l = append(*l, hookEntry{order: order, seq: seq, fn: fn})
+		sort.SliceStable(*l, func(i, j int) bool {
+			if descending {
+				return (*l)[i].order > (*l)[j].order
+			}
+			return (*l)[i].order < (*l)[j].order
+		})
+	})
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			h.update(func(s *hookSet) {
+				l := list(s)
+				for i, e := range *l {
+					if e.seq == seq {
+						*l = append((*l)[:i], (*l)[i+1:]...)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+// OnRequest registers a request hook for every domain. It returns a
+// function that removes the hook.
+//
+// Request and error hooks run in ascending order and response hooks in
+// descending order, so a hook with a lower order sees the request earlier
+// and its response later. Hooks with the same order run in the order they
+// were added. All hooks run outside the domain's WASM plugins: before them
+// on the request and after them on the response.
+func (p *ProxyServer) OnRequest(order int, hook RequestHook) (remove func()) {
+	return p.hooks.add(func(s *hookSet) *[]hookEntry { return &s.request }, order, hook, false)
+}
+
+// OnResponse registers a response hook for every domain. It returns a
+// function that removes the hook.
+func (p *ProxyServer) OnResponse(order int, hook ResponseHook) (remove func()) {
+	return p.hooks.add(func(s *hookSet) *[]hookEntry { return &s.response }, order, hook, true)
+}
+
+// OnError registers an error hook for every domain. It returns a function
+// that removes the hook.
+func (p *ProxyServer) OnError(order int, hook ErrorHook) (remove func()) {
+	return p.hooks.add(func(s *hookSet) *[]hookEntry { return &s.errors }, order, hook, false)
+}
+
+// runRequestHooks reports whether a hook answered the request
+func (p *ProxyServer) runRequestHooks(w http.ResponseWriter, r *http.Request, domain string) bool {
+	for _, e := range p.hooks.current().request {
+		if e.fn.(RequestHook)(w, r, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *ProxyServer) runResponseHooks(resp *http.Response, domain string) error {
+	for _, e := range p.hooks.current().response {
+		if err := e.fn.(ResponseHook)(resp, domain); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *ProxyServer) runErrorHooks(r *http.Request, domain string, err error) {
+	for _, e := range p.hooks.current().errors {
+		e.fn.(ErrorHook)(r, domain, err)
+	}
+}
@@ -0,0 +1,264 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"viacortex/internal/reporting"
+
+	"github.com/caddyserver/certmagic"
+)
+
+// challengeDirs are where HTTP-01 key authorizations are kept, both under
+// storage_dir and as certificate storage keys
+var challengeDirs = []string{path.Join("acme", "http-01"), "acme-http-01"}
+
+// StorageUsage describes how much certificate storage is used and what the
+// janitor last removed
+type StorageUsage struct {
+	Bytes            int64      `json:"bytes"`
+	Keys             int        `json:"keys"`
+	Challenges       int        `json:"challenges"`
+	QuotaBytes       int64      `json:"quota_bytes,omitempty"`
+	OverQuota        bool       `json:"over_quota"`
+	PrunedChallenges int        `json:"pruned_challenges"`
+	PrunedTotal      int64      `json:"pruned_total"`
+	LastRun          *time.Time `json:"last_run,omitempty"`
+	LastError        string     `json:"last_error,omitempty"`
+}
+
+// StorageJanitor removes HTTP-01 challenge tokens left behind after their
+// ACME order, and keeps certificate storage within a quota by removing
+// expired certificates early
+type StorageJanitor struct {
+	proxy        *ProxyServer
+	interval     time.Duration
+	challengeTTL time.Duration
+	quota        int64 // bytes, 0 for none
+	isLeader     func() bool
+
+	mu    sync.Mutex
+	usage StorageUsage
+}
+
+func NewStorageJanitor(p *ProxyServer, interval, challengeTTL time.Duration, quota int64) *StorageJanitor {
+	return &StorageJanitor{
+		proxy:        p,
+		interval:     interval,
+		challengeTTL: challengeTTL,
+		quota:        quota,
+		usage:        StorageUsage{QuotaBytes: quota},
+	}
+}
+
+// SetLeader makes only the leader clean storage shared between nodes; each
+// node still cleans its own storage_dir
+func (j *StorageJanitor) SetLeader(isLeader func() bool) {
+	j.isLeader = isLeader
+}
+
+func (j *StorageJanitor) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+		for {
+			j.Run(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Usage returns the storage usage found by the last run
+func (j *StorageJanitor) Usage() StorageUsage {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.usage
+}
+
+// Run prunes stale challenges, measures the storage and enforces the quota
+func (j *StorageJanitor) Run(ctx context.Context) StorageUsage {
+	pruned := j.pruneChallengeFiles()
+
+	// Shared storage is cleaned once for the cluster
+	cleans := j.proxy.certStorage == nil || j.isLeader == nil || j.isLeader()
+	var err error
+	if cleans {
+		var n int
+		n, err = j.pruneChallengeKeys(ctx)
+		pruned += n
+	}
+
+	usage := StorageUsage{QuotaBytes: j.quota, PrunedChallenges: pruned}
+	if err == nil {
+		usage, err = j.measure(ctx, usage)
+	}
+	if err == nil && cleans && usage.OverQuota {
+		usage, err = j.enforceQuota(ctx, usage)
+	}
+
+	now := time.Now()
+	usage.LastRun = &now
+	if err != nil {
+		usage.LastError = err.Error()
+		acmeLog.Error("Certificate storage cleanup failed", "error", err)
+		reporting.Error(err, map[string]string{"job": "storage_janitor"})
+	}
+	if pruned > 0 {
+		acmeLog.Info("Pruned stale challenge tokens", "count", pruned)
+	}
+
+	j.mu.Lock()
+	usage.PrunedTotal = j.usage.PrunedTotal + int64(pruned)
+	j.usage = usage
+	j.mu.Unlock()
+	return usage
+}
+
+// Clean is Run for a job scheduler, failing when the run did
+func (j *StorageJanitor) Clean(ctx context.Context) error {
+	if usage := j.Run(ctx); usage.LastError != "" {
+		return errors.New(usage.LastError)
+	}
+	return nil
+}
+
+// pruneChallengeFiles removes token files under storage_dir older than the
+// challenge TTL, and the domain directories they leave empty
+func (j *StorageJanitor) pruneChallengeFiles() int {
+	if j.proxy.dataDir == "" {
+		return 0
+	}
+	cutoff := time.Now().Add(-j.challengeTTL)
+	pruned := 0
+	for _, dir := range challengeDirs {
+		root := filepath.Join(j.proxy.dataDir, filepath.FromSlash(dir))
+		domains, err := os.ReadDir(root)
+		if err != nil {
+			continue
+		}
+		for _, domain := range domains {
+			if !domain.IsDir() {
+				continue
+			}
+			domainDir := filepath.Join(root, domain.Name())
+			tokens, err := os.ReadDir(domainDir)
+			if err != nil {
+				continue
+			}
+			remaining := len(tokens)
+			for _, token := range tokens {
+				info, err := token.Info()
+				if err != nil || token.IsDir() || info.ModTime().After(cutoff) {
+					continue
+				}
+				if err := os.Remove(filepath.Join(domainDir, token.Name())); err != nil {
+					acmeLog.Warn("Failed to remove challenge token", "path", filepath.Join(domainDir, token.Name()), "error", err)
+					continue
+				}
+				remaining--
+				pruned++
+			}
+			// ObtainCertificate recreates the directory when it is needed
+			if remaining == 0 {
+				if info, err := os.Stat(domainDir); err == nil && info.ModTime().Before(cutoff) {
+					os.Remove(domainDir)
+				}
+			}
+		}
+	}
+	return pruned
+}
+
+// pruneChallengeKeys removes challenge tokens older than the challenge TTL
+// from certificate storage
+func (j *StorageJanitor) pruneChallengeKeys(ctx context.Context) (int, error) {
+	storage := j.proxy.certManager.Storage
+	cutoff := time.Now().Add(-j.challengeTTL)
+	pruned := 0
+	for _, dir := range challengeDirs {
+		keys, err := storage.List(ctx, dir, true)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return pruned, fmt.Errorf("listing %s: %w", dir, err)
+		}
+		for _, key := range keys {
+			info, err := storage.Stat(ctx, key)
+			if err != nil || !info.IsTerminal || info.Modified.After(cutoff) {
+				continue
+			}
+			if err := storage.Delete(ctx, key); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return pruned, fmt.Errorf("deleting %s: %w", key, err)
+			}
+			pruned++
+		}
+	}
+	return pruned, nil
+}
+
+// measure adds up the size of every key in certificate storage
+func (j *StorageJanitor) measure(ctx context.Context, usage StorageUsage) (StorageUsage, error) {
+	storage := j.proxy.certManager.Storage
+	keys, err := storage.List(ctx, "", true)
+	if errors.Is(err, fs.ErrNotExist) {
+		return usage, nil
+	}
+	if err != nil {
+		return usage, fmt.Errorf("listing certificate storage: %w", err)
+	}
+
+	usage.Bytes, usage.Keys, usage.Challenges = 0, 0, 0
+	for _, key := range keys {
+		info, err := storage.Stat(ctx, key)
+		if err != nil || !info.IsTerminal {
+			continue
+		}
+		usage.Bytes += info.Size
+		usage.Keys++
+		for _, dir := range challengeDirs {
+			if strings.HasPrefix(key, dir+"/") {
+				usage.Challenges++
+			}
+		}
+	}
+	usage.OverQuota = j.quota > 0 && usage.Bytes > j.quota
+	return usage, nil
+}
+
+// enforceQuota removes expired certificates and OCSP staples without the
+// usual grace period, then reports an overrun that remains
+func (j *StorageJanitor) enforceQuota(ctx context.Context, usage StorageUsage) (StorageUsage, error) {
+	acmeLog.Warn("Certificate storage is over its quota, removing expired certificates",
+		"bytes", usage.Bytes, "quota_bytes", j.quota)
+	err := certmagic.CleanStorage(ctx, j.proxy.certManager.Storage, certmagic.CleanStorageOptions{
+		ExpiredCerts: true,
+		OCSPStaples:  true,
+	})
+	if err != nil {
+		return usage, fmt.Errorf("removing expired certificates: %w", err)
+	}
+
+	usage, err = j.measure(ctx, usage)
+	if err != nil {
+		return usage, err
+	}
+	if usage.OverQuota {
+		err := fmt.Errorf("certificate storage uses %d bytes, over its quota of %d", usage.Bytes, j.quota)
+		acmeLog.Warn("Certificate storage is still over its quota", "bytes", usage.Bytes, "quota_bytes", j.quota)
+		reporting.Throttled("storage_janitor:quota", err, map[string]string{"job": "storage_janitor"})
+	}
+	return usage, nil
+}
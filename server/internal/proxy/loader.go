@@ -3,49 +3,124 @@ package proxy
 import (
 	"context"
 	"database/sql"
-	"log"
+	"fmt"
 	"net"
-	"strings"
+	"sync"
 	"time"
 
+	"viacortex/internal/logging"
+	"viacortex/internal/reporting"
+
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
+var loaderLog = logging.For("loader")
+
 type Loader struct {
-    db    *pgxpool.Pool
-    proxy *ProxyServer
+    db       *pgxpool.Pool
+    proxy    *ProxyServer
+    interval time.Duration
+    reload   chan struct{}
+    bus      Bus
+
+    mu          sync.RWMutex
+    lastAttempt time.Time
+    lastSuccess time.Time
+    lastErr     error
+}
+
+// LoaderStatus describes the outcome of recent domain loads
+type LoaderStatus struct {
+    LastAttempt time.Time `json:"last_attempt"`
+    LastSuccess time.Time `json:"last_success"`
+    LastError   string    `json:"last_error,omitempty"`
+}
+
+// Loaded reports whether domains have been loaded successfully at least once
+func (s LoaderStatus) Loaded() bool {
+    return !s.LastSuccess.IsZero()
 }
 
 func NewLoader(dbPool *pgxpool.Pool, proxy *ProxyServer) *Loader {
     return &Loader{
-        db:    dbPool,
-        proxy: proxy,
+        db:       dbPool,
+        proxy:    proxy,
+        interval: 30 * time.Second,
+        reload:   make(chan struct{}, 1),
     }
 }
 
+// SetInterval sets how often domains are reloaded from the database. It must
+// be called before Start. Zero leaves periodic reloads to the caller, e.g.
+// a job scheduler calling LoadAllDomains.
+func (l *Loader) SetInterval(d time.Duration) {
+    l.interval = d
+}
+
 func (l *Loader) Start(ctx context.Context) {
     // Initial load
     if err := l.LoadAllDomains(); err != nil {  // Changed this line
-        log.Printf("Initial domain load error: %v", err)
+        loaderLog.Error("Initial domain load failed", "error", err)
     }
 
-    // Periodic reload every 30 seconds
-    ticker := time.NewTicker(30 * time.Second)
-    defer ticker.Stop()
+    // Reload as soon as the database (or the cluster bus) reports a change,
+    // with periodic reloads as a fallback
+    if l.bus == nil {
+        go l.listen(ctx)
+    }
+    var tick <-chan time.Time
+    if l.interval > 0 {
+        ticker := time.NewTicker(l.interval)
+        defer ticker.Stop()
+        tick = ticker.C
+    }
 
     for {
         select {
         case <-ctx.Done():
             return
-        case <-ticker.C:
+        case <-tick:
             if err := l.LoadAllDomains(); err != nil {  // Changed this line
-                log.Printf("Domain reload error: %v", err)
+                loaderLog.Error("Domain reload failed", "error", err)
+            }
+        case <-l.reload:
+            if err := l.LoadAllDomains(); err != nil {
+                loaderLog.Error("Domain reload failed", "error", err)
             }
         }
     }
 }
 
+// LoadAllDomains syncs the proxy's domain table with the database
 func (l *Loader) LoadAllDomains() error {
+    err := l.loadAllDomains()
+
+    l.mu.Lock()
+    l.lastAttempt = time.Now()
+    l.lastErr = err
+    if err == nil {
+        l.lastSuccess = l.lastAttempt
+    }
+    l.mu.Unlock()
+
+    reporting.Error(err, map[string]string{"job": "domain_loader"})
+
+    return err
+}
+
+// Status returns the outcome of recent domain loads
+func (l *Loader) Status() LoaderStatus {
+    l.mu.RLock()
+    defer l.mu.RUnlock()
+
+    status := LoaderStatus{LastAttempt: l.lastAttempt, LastSuccess: l.lastSuccess}
+    if l.lastErr != nil {
+        status.LastError = l.lastErr.Error()
+    }
+    return status
+}
+
+func (l *Loader) loadAllDomains() error {
 
     ctx := context.Background()
 
@@ -57,7 +132,8 @@ func (l *Loader) LoadAllDomains() error {
             d.target_url,
             d.ssl_enabled,
             d.health_check_enabled,
-            d.health_check_interval
+            d.health_check_interval,
+            d.enabled
         FROM domains d
     `)
     if err != nil {
@@ -66,6 +142,8 @@ func (l *Loader) LoadAllDomains() error {
     defer rows.Close()
 
     loadedDomains := make(map[string]struct{})
+    // The configuration in effect for each domain ID, shared by its aliases
+    installed := make(map[int64]*DomainConfig)
 
     for rows.Next() {
         var (
@@ -75,6 +153,7 @@ func (l *Loader) LoadAllDomains() error {
             sslEnabled         bool
             healthCheckEnabled bool
             healthCheckInterval int
+            enabled            bool
         )
 
         err := rows.Scan(
@@ -84,35 +163,37 @@ func (l *Loader) LoadAllDomains() error {
             &sslEnabled,
             &healthCheckEnabled,
             &healthCheckInterval,
+            &enabled,
         )
         if err != nil {
             return err
         }
 
-        // For TCP domains, use the name instead of targetURL to avoid protocol prefix issues
-        domainKey := targetURL
-        // Extract domain from URL by removing protocol prefixes
-        if strings.HasPrefix(targetURL, "tcp://") {
-            domainKey = strings.TrimPrefix(targetURL, "tcp://")
-            log.Printf("Using extracted domain %s from TCP target URL %s", domainKey, targetURL)
-        } else if strings.HasPrefix(targetURL, "https://") {
-            domainKey = strings.TrimPrefix(targetURL, "https://")
-            log.Printf("Using extracted domain %s from HTTPS target URL %s", domainKey, targetURL)
-        } else if strings.HasPrefix(targetURL, "http://") {
-            domainKey = strings.TrimPrefix(targetURL, "http://")
-            log.Printf("Using extracted domain %s from HTTP target URL %s", domainKey, targetURL)
-        }
+        // Requests are routed by the domain's name; target_url only
+        // describes the upstream
+        domainKey := HostKey(name)
 
         config := &DomainConfig{
             Domain:             domainKey,
             SSLEnabled:        sslEnabled,
             HealthCheckEnabled: healthCheckEnabled,
+            Enabled:           enabled,
+        }
+
+        // A domain that cannot be loaded completely keeps its last loaded
+        // configuration rather than losing its backends or IP rules
+        keep := func(what string, err error) {
+            loaderLog.Error("Loading domain settings failed, keeping the previous configuration", "settings", what, "domain", name, "error", err)
+            loadedDomains[domainKey] = struct{}{}
+            if current, ok := l.proxy.Domain(domainKey); ok {
+                installed[domainID] = current
+            }
         }
 
         // Load backends
         backends, err := l.loadBackends(ctx, domainID)
         if err != nil {
-            log.Printf("Error loading backends for domain %s: %v", name, err)
+            keep("backends", err)
             continue
         }
         config.Backends = backends
@@ -120,28 +201,113 @@ func (l *Loader) LoadAllDomains() error {
         // Load IP rules
         ipRules, err := l.loadIPRules(ctx, domainID)
         if err != nil {
-            log.Printf("Error loading IP rules for domain %s: %v", name, err)
+            keep("IP rules", err)
+            continue
         }
         config.IPRules = ipRules
 
         // Load rate limit
         rateLimit, err := l.loadRateLimit(ctx, domainID)
         if err != nil {
-            log.Printf("Error loading rate limit for domain %s: %v", name, err)
+            keep("rate limit", err)
+            continue
         }
         config.RateLimit = rateLimit
 
-        // Update proxy configuration
-        l.proxy.UpdateDomain(config.Domain, config)
-        log.Printf("Loaded domain %s with SSL enabled: %v", config.Domain, config.SSLEnabled)
+        // Load connection pool settings
+        transport, err := l.loadTransport(ctx, domainID)
+        if err != nil {
+            keep("transport settings", err)
+            continue
+        }
+        config.Transport = transport
+
+        // Load fault injection
+        faults, err := l.loadFaults(ctx, domainID)
+        if err != nil {
+            keep("fault injection", err)
+            continue
+        }
+        config.Faults = faults
+
+        // Load plugins
+        plugins, err := l.loadPlugins(ctx, domainID)
+        if err != nil {
+            keep("plugins", err)
+            continue
+        }
+        config.Plugins = plugins
+
+        // Load body rewriting
+        rewrite, err := l.loadBodyRewrite(ctx, domainID)
+        if err != nil {
+            keep("body rewriting", err)
+            continue
+        }
+        if rewrite != nil {
+            rewrite.prepare(config)
+        }
+        config.Rewrite = rewrite
+
+        // Load cache rules
+        cacheRules, err := l.loadCacheRules(ctx, domainID)
+        if err != nil {
+            keep("cache rules", err)
+            continue
+        }
+        config.CacheRules = cacheRules
+
+        // Load early hints
+        earlyHints, err := l.loadEarlyHints(ctx, domainID)
+        if err != nil {
+            keep("early hints", err)
+            continue
+        }
+        config.EarlyHints = earlyHints
+
+        // Load the Server-Timing toggle
+        if err := l.db.QueryRow(ctx,
+            "SELECT EXISTS (SELECT 1 FROM domain_server_timing WHERE domain_id = $1)",
+            domainID).Scan(&config.ServerTiming); err != nil {
+            keep("Server-Timing", err)
+            continue
+        }
+
+        // Only apply actual changes, so unchanged domains keep their
+        // round-robin and rate limiter state
         loadedDomains[config.Domain] = struct{}{}
+        if current, ok := l.proxy.Domain(config.Domain); ok && current.Equal(config) {
+            installed[domainID] = current
+            if config.SSLEnabled {
+                l.proxy.RetryCertificate(config.Domain)
+            }
+            continue
+        }
+        installed[domainID] = config
+        l.proxy.UpdateDomain(config.Domain, config)
+        loaderLog.Debug("Loaded domain", "domain", config.Domain, "ssl", config.SSLEnabled)
+        if config.Faults != nil {
+            loaderLog.Warn("Fault injection active", "domain", config.Domain,
+                "percent", config.Faults.Percent, "expires_at", config.Faults.ExpiresAt)
+        }
+    }
+    // A partial result, e.g. from a dropped connection, must not remove the
+    // domains that were not read
+    if err := rows.Err(); err != nil {
+        return err
     }
 
+    if err := l.loadAliases(ctx, installed, loadedDomains); err != nil {
+        return err
+    }
+    l.proxy.plugins.prune(installed)
+
     // Remove domains that no longer exist
     l.proxy.domains.Range(func(key, _ interface{}) bool {
         domain := key.(string)
         if _, exists := loadedDomains[domain]; !exists {
             l.proxy.DeleteDomain(domain)
+            loaderLog.Info("Removed domain", "domain", domain)
         }
         return true
     })
@@ -149,6 +315,45 @@ func (l *Loader) LoadAllDomains() error {
     return nil
 }
 
+// loadAliases serves each alias with the configuration of its domain. A
+// domain's own name takes precedence over an alias of another domain.
+func (l *Loader) loadAliases(ctx context.Context, installed map[int64]*DomainConfig, loadedDomains map[string]struct{}) error {
+    rows, err := l.db.Query(ctx, "SELECT domain_id, hostname FROM domain_aliases ORDER BY id")
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var domainID int64
+        var hostname string
+        if err := rows.Scan(&domainID, &hostname); err != nil {
+            return err
+        }
+
+        config := installed[domainID]
+        if config == nil {
+            continue
+        }
+        key := HostKey(hostname)
+        if _, taken := loadedDomains[key]; taken {
+            loaderLog.Warn("Ignoring alias: the hostname is already served", "alias", key, "domain", config.Domain)
+            continue
+        }
+        loadedDomains[key] = struct{}{}
+
+        if current, ok := l.proxy.Domain(key); ok && current == config {
+            if config.SSLEnabled {
+                l.proxy.RetryCertificate(key)
+            }
+            continue
+        }
+        l.proxy.UpdateDomain(key, config)
+        loaderLog.Debug("Loaded alias", "alias", key, "domain", config.Domain)
+    }
+    return rows.Err()
+}
+
 func (l *Loader) loadBackends(ctx context.Context, domainID int64) ([]*BackendServer, error) {
     rows, err := l.db.Query(ctx, `
         SELECT 
@@ -156,6 +361,7 @@ func (l *Loader) loadBackends(ctx context.Context, domainID int64) ([]*BackendSe
             last_health_check, health_status
         FROM backend_servers
         WHERE domain_id = $1
+        ORDER BY id
     `, domainID)
     if err != nil {
         return nil, err
@@ -188,22 +394,22 @@ func (l *Loader) loadBackends(ctx context.Context, domainID int64) ([]*BackendSe
         }
 
         b.IP = net.ParseIP(ipStr).To4()
-		log.Printf("Loaded backend %d with IP: %s", b.ID, b.IP)
         if b.IP == nil {
-            log.Printf("Warning: Invalid IP address for backend %d: %s", b.ID, ipStr)
+            loaderLog.Warn("Invalid backend IP address", "backend_id", b.ID, "ip", ipStr)
             continue
         }
 
         backends = append(backends, &b)
     }
 
-    return backends, nil
+    return backends, rows.Err()
 }
 func (l *Loader) loadIPRules(ctx context.Context, domainID int64) ([]*IPRule, error) {
     rows, err := l.db.Query(ctx, `
         SELECT id, ip_range, rule_type, description
         FROM ip_rules
         WHERE domain_id = $1
+        ORDER BY id
     `, domainID)
     if err != nil {
         return nil, err
@@ -221,7 +427,7 @@ func (l *Loader) loadIPRules(ctx context.Context, domainID int64) ([]*IPRule, er
 
         _, ipNet, err := net.ParseCIDR(ipRangeStr)
         if err != nil {
-            log.Printf("Warning: Invalid CIDR for rule %d: %s", r.ID, ipRangeStr)
+            loaderLog.Warn("Invalid IP rule range", "rule_id", r.ID, "range", ipRangeStr)
             continue
         }
         r.IPRange = *ipNet
@@ -229,7 +435,7 @@ func (l *Loader) loadIPRules(ctx context.Context, domainID int64) ([]*IPRule, er
         rules = append(rules, &r)
     }
 
-    return rules, nil
+    return rules, rows.Err()
 }
 
 func (l *Loader) loadRateLimit(ctx context.Context, domainID int64) (*RateLimit, error) {
@@ -250,3 +456,175 @@ func (l *Loader) loadRateLimit(ctx context.Context, domainID int64) (*RateLimit,
 
     return &r, nil
 }
+
+// loadFaults returns a domain's fault injection, ignoring an expired one
+func (l *Loader) loadFaults(ctx context.Context, domainID int64) (*Fault, error) {
+    var f Fault
+    var delayMs int
+    var errorStatus *int
+    err := l.db.QueryRow(ctx, `
+        SELECT percent, delay_ms, error_status, reset_connection, expires_at
+        FROM domain_faults
+        WHERE domain_id = $1 AND expires_at > CURRENT_TIMESTAMP
+    `, domainID).Scan(&f.Percent, &delayMs, &errorStatus, &f.Reset, &f.ExpiresAt)
+
+    if err != nil {
+        if err.Error() == "no rows in result set" {
+            return nil, nil
+        }
+        return nil, err
+    }
+
+    f.Delay = time.Duration(delayMs) * time.Millisecond
+    if errorStatus != nil {
+        f.ErrorStatus = *errorStatus
+    }
+    return &f, nil
+}
+
+// loadPlugins returns a domain's plugins in order, compiling the modules
+// that are not compiled yet
+func (l *Loader) loadPlugins(ctx context.Context, domainID int64) ([]*Plugin, error) {
+    rows, err := l.db.Query(ctx, `
+        SELECT p.id, p.name, p.sha256, p.memory_limit_mb, p.timeout_ms, p.fail_open, dp.config
+        FROM domain_plugins dp
+        JOIN plugins p ON p.id = dp.plugin_id
+        WHERE dp.domain_id = $1
+        ORDER BY dp.position, p.id
+    `, domainID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var plugins []*Plugin
+    for rows.Next() {
+        var pl Plugin
+        var timeoutMs int
+        if err := rows.Scan(&pl.ID, &pl.Name, &pl.SHA256, &pl.MemoryMB, &timeoutMs,
+            &pl.FailOpen, &pl.Config); err != nil {
+            return nil, err
+        }
+        pl.Timeout = time.Duration(timeoutMs) * time.Millisecond
+        plugins = append(plugins, &pl)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    for _, pl := range plugins {
+        id, sha256 := pl.ID, pl.SHA256
+        pl.module, err = l.proxy.plugins.module(ctx, sha256, pl.MemoryMB, func() ([]byte, error) {
+            // A module replaced since is loaded with the next reload
+            var wasm []byte
+            err := l.db.QueryRow(ctx, "SELECT wasm FROM plugins WHERE id = $1 AND sha256 = $2",
+                id, sha256).Scan(&wasm)
+            return wasm, err
+        })
+        if err != nil {
+            return nil, fmt.Errorf("plugin %s: %w", pl.Name, err)
+        }
+    }
+    return plugins, nil
+}
+
+// loadBodyRewrite returns a domain's body rewriting, or nil when it is off
+func (l *Loader) loadBodyRewrite(ctx context.Context, domainID int64) (*BodyRewrite, error) {
+    var b BodyRewrite
+    err := l.db.QueryRow(ctx, `
+        SELECT extra_urls FROM domain_body_rewrites WHERE domain_id = $1
+    `, domainID).Scan(&b.ExtraURLs)
+
+    if err != nil {
+        if err.Error() == "no rows in result set" {
+            return nil, nil
+        }
+        return nil, err
+    }
+    return &b, nil
+}
+
+// loadCacheRules returns a domain's cache rules in the order they are tried
+func (l *Loader) loadCacheRules(ctx context.Context, domainID int64) ([]*CacheRule, error) {
+    rows, err := l.db.Query(ctx, `
+        SELECT id, COALESCE(path_prefix, ''), COALESCE(content_type, ''), cache_control, override
+        FROM domain_cache_rules
+        WHERE domain_id = $1
+        ORDER BY position, id
+    `, domainID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var rules []*CacheRule
+    for rows.Next() {
+        var rule CacheRule
+        if err := rows.Scan(&rule.ID, &rule.PathPrefix, &rule.ContentType,
+            &rule.CacheControl, &rule.Override); err != nil {
+            return nil, err
+        }
+        rules = append(rules, &rule)
+    }
+    return rules, rows.Err()
+}
+
+// loadEarlyHints returns a domain's early hints in the order they are sent
+func (l *Loader) loadEarlyHints(ctx context.Context, domainID int64) ([]*EarlyHint, error) {
+    rows, err := l.db.Query(ctx, `
+        SELECT id, path_prefix, link
+        FROM domain_early_hints
+        WHERE domain_id = $1
+        ORDER BY position, id
+    `, domainID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var hints []*EarlyHint
+    for rows.Next() {
+        var hint EarlyHint
+        if err := rows.Scan(&hint.ID, &hint.PathPrefix, &hint.Link); err != nil {
+            return nil, err
+        }
+        hints = append(hints, &hint)
+    }
+    return hints, rows.Err()
+}
+
+func (l *Loader) loadTransport(ctx context.Context, domainID int64) (*Transport, error) {
+    var maxIdle, maxConns, idleTimeout, headerTimeout *int
+    var http2 bool
+    err := l.db.QueryRow(ctx, `
+        SELECT max_idle_conns_per_host, max_conns_per_host,
+               idle_conn_timeout_seconds, response_header_timeout_seconds, http2_enabled
+        FROM domain_transport
+        WHERE domain_id = $1
+    `, domainID).Scan(&maxIdle, &maxConns, &idleTimeout, &headerTimeout, &http2)
+
+    if err != nil {
+        if err.Error() == "no rows in result set" {
+            return nil, nil
+        }
+        return nil, err
+    }
+
+    t := Transport{DisableHTTP2: !http2}
+    if maxIdle != nil {
+        t.MaxIdleConnsPerHost = *maxIdle
+    }
+    if maxConns != nil {
+        t.MaxConnsPerHost = *maxConns
+    }
+    if idleTimeout != nil {
+        t.IdleConnTimeout = time.Duration(*idleTimeout) * time.Second
+    }
+    if headerTimeout != nil {
+        t.ResponseHeaderTimeout = time.Duration(*headerTimeout) * time.Second
+    }
+    if t == (Transport{}) {
+        return nil, nil
+    }
+    return &t, nil
+}
@@ -2,18 +2,25 @@ package proxy
 
 import (
 	"context"
-	"fmt"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"viacortex/internal/logging"
+	"viacortex/internal/reporting"
+
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
+var metricsLog = logging.For("metrics")
+
 type MetricsCollector struct {
     db        *pgxpool.Pool
+    nodeID    string
     metrics   sync.Map // map[string]*DomainMetrics
+    blocked   sync.Map // map[int64]*atomic.Int64, requests blocked per threat feed
     flushChan chan struct{}
 }
 
@@ -23,6 +30,9 @@ type DomainMetrics struct {
     TCPCount     int
     Latencies    []float64
     TCPLatencies []float64
+    BytesIn      int64   // request and client-sent bytes
+    BytesOut     int64   // response and backend-sent bytes
+    TCPSeconds   float64 // total duration of the TCP connections
     mu           sync.Mutex
 }
 
@@ -30,14 +40,24 @@ func NewMetricsCollector() *MetricsCollector {
     m := &MetricsCollector{
         flushChan: make(chan struct{}),
     }
-    go m.periodicFlush()
     return m
 }
 
+// Start flushes the collected metrics every minute. Servers with a job
+// scheduler run Flush as a job instead.
+func (m *MetricsCollector) Start() {
+    go m.periodicFlush()
+}
+
 func (m *MetricsCollector) SetDB(db *pgxpool.Pool) {
     m.db = db
 }
 
+// SetNodeID tags flushed metrics with the cluster node that recorded them
+func (m *MetricsCollector) SetNodeID(id string) {
+    m.nodeID = id
+}
+
 func (m *MetricsCollector) RecordRequest(domain string, statusCode int, duration time.Duration) {
     metricsVal, _ := m.metrics.LoadOrStore(domain, &DomainMetrics{})
     metrics := metricsVal.(*DomainMetrics)
@@ -62,6 +82,20 @@ func (m *MetricsCollector) RecordTCPRequest(domain string, duration time.Duratio
 
     metrics.TCPCount++
     metrics.TCPLatencies = append(metrics.TCPLatencies, float64(duration.Milliseconds()))
+    metrics.TCPSeconds += duration.Seconds()
+}
+
+// RecordBytes adds to the traffic a domain received and sent, for usage
+// metering
+func (m *MetricsCollector) RecordBytes(domain string, in, out int64) {
+    metricsVal, _ := m.metrics.LoadOrStore(domain, &DomainMetrics{})
+    metrics := metricsVal.(*DomainMetrics)
+
+    metrics.mu.Lock()
+    defer metrics.mu.Unlock()
+
+    metrics.BytesIn += in
+    metrics.BytesOut += out
 }
 
 func (m *MetricsCollector) RecordError(domain string) {
@@ -74,6 +108,17 @@ func (m *MetricsCollector) RecordError(domain string) {
     metrics.ErrorCount++
 }
 
+// RecordBlocked counts a request or connection blocked by a threat feed
+func (m *MetricsCollector) RecordBlocked(feedID int64) {
+    counter, _ := m.blocked.LoadOrStore(feedID, new(atomic.Int64))
+    counter.(*atomic.Int64).Add(1)
+}
+
+// Flush writes the metrics collected so far, e.g. before the node stops
+func (m *MetricsCollector) Flush() {
+    m.flush()
+}
+
 func (m *MetricsCollector) periodicFlush() {
     ticker := time.NewTicker(1 * time.Minute)
     defer ticker.Stop()
@@ -156,10 +201,10 @@ func (m *MetricsCollector) flush() {
 
         if err != nil {
             if err == pgx.ErrNoRows {
-                fmt.Printf("Warning: Skipping metrics for unknown domain: %s\n", domain)
+                metricsLog.Debug("Skipping metrics for unknown domain", "domain", domain)
                 return true
             }
-            fmt.Printf("Error querying domain: %v\n", err)
+            metricsLog.Error("Looking up metrics domain failed", "domain", domain, "error", err)
             return true
         }
 
@@ -167,8 +212,8 @@ func (m *MetricsCollector) flush() {
         if metrics.RequestCount > 0 {
             _, err = m.db.Exec(ctx,
                 `INSERT INTO request_metrics 
-                (domain_id, timestamp, request_count, error_count, avg_latency_ms, p95_latency_ms, p99_latency_ms)
-                VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+                (domain_id, timestamp, request_count, error_count, avg_latency_ms, p95_latency_ms, p99_latency_ms, node_id)
+                VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''))`,
                 domainID,
                 time.Now(),
                 metrics.RequestCount,
@@ -176,10 +221,12 @@ func (m *MetricsCollector) flush() {
                 avgLatency,
                 p95,
                 p99,
+                m.nodeID,
             )
 
             if err != nil {
-                fmt.Printf("Error flushing HTTP metrics: %v\n", err)
+                metricsLog.Error("Flushing HTTP metrics failed", "domain", domain, "error", err)
+                reporting.Error(err, map[string]string{"job": "metrics_flush"})
             }
         }
 
@@ -187,28 +234,88 @@ func (m *MetricsCollector) flush() {
         if metrics.TCPCount > 0 {
             _, err = m.db.Exec(ctx,
                 `INSERT INTO tcp_metrics 
-                (domain_id, timestamp, connection_count, avg_latency_ms, p95_latency_ms, p99_latency_ms)
-                VALUES ($1, $2, $3, $4, $5, $6)`,
+                (domain_id, timestamp, connection_count, avg_latency_ms, p95_latency_ms, p99_latency_ms, node_id)
+                VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''))`,
                 domainID,
                 time.Now(),
                 metrics.TCPCount,
                 avgTCPLatency,
                 tcpP95,
                 tcpP99,
+                m.nodeID,
             )
 
             if err != nil {
-                fmt.Printf("Error flushing TCP metrics: %v\n", err)
+                metricsLog.Error("Flushing TCP metrics failed", "domain", domain, "error", err)
+                reporting.Error(err, map[string]string{"job": "metrics_flush"})
             }
         }
 
+        // Add to the month's usage; unlike the metrics above it is kept
+        // for billing
+        _, err = m.db.Exec(ctx,
+            `INSERT INTO usage_monthly
+            (domain, month, domain_id, requests, bytes_in, bytes_out, tcp_connections, tcp_seconds)
+            VALUES ($1, date_trunc('month', now() AT TIME ZONE 'UTC')::date, $2, $3, $4, $5, $6, $7)
+            ON CONFLICT (domain, month) DO UPDATE SET
+                domain_id = EXCLUDED.domain_id,
+                requests = usage_monthly.requests + EXCLUDED.requests,
+                bytes_in = usage_monthly.bytes_in + EXCLUDED.bytes_in,
+                bytes_out = usage_monthly.bytes_out + EXCLUDED.bytes_out,
+                tcp_connections = usage_monthly.tcp_connections + EXCLUDED.tcp_connections,
+                tcp_seconds = usage_monthly.tcp_seconds + EXCLUDED.tcp_seconds,
+                updated_at = CURRENT_TIMESTAMP`,
+            domain,
+            domainID,
+            metrics.RequestCount,
+            metrics.BytesIn,
+            metrics.BytesOut,
+            metrics.TCPCount,
+            metrics.TCPSeconds,
+        )
+        if err != nil {
+            metricsLog.Error("Recording usage failed", "domain", domain, "error", err)
+            reporting.Error(err, map[string]string{"job": "metrics_flush"})
+        }
+
         // Reset metrics
         metrics.RequestCount = 0
         metrics.ErrorCount = 0
         metrics.TCPCount = 0
         metrics.Latencies = metrics.Latencies[:0]
         metrics.TCPLatencies = metrics.TCPLatencies[:0]
+        metrics.BytesIn = 0
+        metrics.BytesOut = 0
+        metrics.TCPSeconds = 0
+
+        return true
+    })
+
+    m.flushBlocked()
+}
 
+// flushBlocked adds the blocks counted since the last flush to the threat
+// feeds' totals
+func (m *MetricsCollector) flushBlocked() {
+    ctx := context.Background()
+    m.blocked.Range(func(key, value interface{}) bool {
+        counter := value.(*atomic.Int64)
+        n := counter.Swap(0)
+        if n == 0 {
+            return true
+        }
+        _, err := m.db.Exec(ctx,
+            `UPDATE threat_feeds
+            SET blocked_requests = blocked_requests + $2, last_blocked_at = CURRENT_TIMESTAMP
+            WHERE id = $1`,
+            key.(int64), n,
+        )
+        if err != nil {
+            // Counted again with the next flush
+            counter.Add(n)
+            metricsLog.Error("Flushing blocked requests failed", "feed_id", key, "error", err)
+            reporting.Error(err, map[string]string{"job": "metrics_flush"})
+        }
         return true
     })
 }
\ No newline at end of file
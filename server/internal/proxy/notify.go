@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"context"
+	"time"
+)
+
+// domainsChannel is the Postgres notification channel a trigger on the
+// domains table signals when a domain's configuration changes
+const domainsChannel = "viacortex_domains"
+
+// reloadDebounce collects the notifications of a bulk change, such as a
+// config import, into a single reload
+const reloadDebounce = 100 * time.Millisecond
+
+// maxListenBackoff caps the wait before re-establishing a lost LISTEN
+const maxListenBackoff = 30 * time.Second
+
+// listen holds a connection listening for domain changes and requests a
+// reload for each burst of them. It reconnects when the connection drops
+// and reloads afterwards, since notifications sent meanwhile are lost.
+func (l *Loader) listen(ctx context.Context) {
+    backoff := time.Second
+    for {
+        err := l.listenOnce(ctx)
+        if ctx.Err() != nil {
+            return
+        }
+        loaderLog.Warn("Domain change listener stopped, retrying", "retry_in", backoff, "error", err)
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-time.After(backoff):
+        }
+        backoff *= 2
+        if backoff > maxListenBackoff {
+            backoff = maxListenBackoff
+        }
+        l.requestReload()
+    }
+}
+
+func (l *Loader) listenOnce(ctx context.Context) error {
+    conn, err := l.db.Acquire(ctx)
+    if err != nil {
+        return err
+    }
+    // The connection is left in LISTEN state, so it must not go back into
+    // the pool
+    defer func() {
+        conn.Conn().Close(context.Background())
+        conn.Release()
+    }()
+
+    if _, err := conn.Exec(ctx, "LISTEN "+domainsChannel); err != nil {
+        return err
+    }
+
+    for {
+        if _, err := conn.Conn().WaitForNotification(ctx); err != nil {
+            return err
+        }
+
+        // Let the rest of a bulk change arrive before reloading
+        waitCtx, cancel := context.WithTimeout(ctx, reloadDebounce)
+        for {
+            if _, err := conn.Conn().WaitForNotification(waitCtx); err != nil {
+                break
+            }
+        }
+        cancel()
+        if ctx.Err() != nil {
+            return ctx.Err()
+        }
+        l.requestReload()
+    }
+}
+
+// requestReload asks the loader to reload soon; requests made while one is
+// pending are merged
+func (l *Loader) requestReload() {
+    select {
+    case l.reload <- struct{}{}:
+    default:
+    }
+}
@@ -0,0 +1,374 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"viacortex/internal/reporting"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// Plugins are WASM modules run on a domain's requests and responses. A
+// module exports its linear memory, an allocator and at least one hook:
+//
+//	vc_alloc(size i32) i32
+//	vc_on_request(ptr i32, len i32) i64
+//	vc_on_response(ptr i32, len i32) i64
+//
+// The proxy writes the JSON-encoded request or response to memory from
+// vc_alloc and calls the hook, which returns the location of its JSON
+// pluginAction packed as ptr<<32 | len, or 0 to change nothing. Each call
+// runs in a fresh instance with no filesystem, network or clock beyond
+// WASI's, within the plugin's memory limit and timeout.
+const (
+	pluginAlloc      = "vc_alloc"
+	pluginOnRequest  = "vc_on_request"
+	pluginOnResponse = "vc_on_response"
+)
+
+// MaxPluginTimeout is the longest a plugin may run per call
+const MaxPluginTimeout = 5 * time.Second
+
+// pluginHeader names the plugin that answered a request
+const pluginHeader = "X-ViaCortex-Plugin"
+
+// Plugin is a WASM module attached to a domain
+type Plugin struct {
+	ID       int64
+	Name     string
+	SHA256   string
+	MemoryMB int
+	Timeout  time.Duration
+	FailOpen bool            // proxy unchanged when the plugin fails, instead of answering 502
+	Config   json.RawMessage // the domain's settings for the plugin
+	module   *pluginModule
+}
+
+func (pl *Plugin) equal(o *Plugin) bool {
+	return pl.ID == o.ID && pl.SHA256 == o.SHA256 && pl.MemoryMB == o.MemoryMB &&
+		pl.Timeout == o.Timeout && pl.FailOpen == o.FailOpen && bytes.Equal(pl.Config, o.Config)
+}
+
+// pluginRequest is what vc_on_request receives
+type pluginRequest struct {
+	Method     string              `json:"method"`
+	Host       string              `json:"host"`
+	Path       string              `json:"path"`
+	Query      string              `json:"query,omitempty"`
+	RemoteAddr string              `json:"remote_addr"`
+	Headers    map[string][]string `json:"headers"`
+	Config     json.RawMessage     `json:"config,omitempty"`
+}
+
+// pluginResponse is what vc_on_response receives
+type pluginResponse struct {
+	Status  int                 `json:"status"`
+	Headers map[string][]string `json:"headers"`
+	Request *pluginRequest      `json:"request"`
+	Config  json.RawMessage     `json:"config,omitempty"`
+}
+
+// pluginAction is what a hook returns. From vc_on_request, a status answers
+// the request with it and the body instead of proxying; from
+// vc_on_response it replaces the backend's status.
+type pluginAction struct {
+	SetHeaders    map[string]string `json:"set_headers,omitempty"`
+	RemoveHeaders []string          `json:"remove_headers,omitempty"`
+	Status        int               `json:"status,omitempty"`
+	Body          string            `json:"body,omitempty"`
+}
+
+// pluginModule is a compiled plugin, shared by the domains running it
+type pluginModule struct {
+	runtime    wazero.Runtime
+	compiled   wazero.CompiledModule
+	onRequest  bool
+	onResponse bool
+}
+
+// pluginHost caches compiled plugins by content and memory limit, so
+// reloads do not compile them again
+type pluginHost struct {
+	mu      sync.Mutex
+	modules map[string]*pluginModule
+}
+
+func pluginKey(sha256 string, memoryMB int) string {
+	return sha256 + ":" + strconv.Itoa(memoryMB)
+}
+
+// compilePlugin compiles a module in a runtime of its own, which enforces
+// its memory limit and aborts calls whose context is done
+func compilePlugin(ctx context.Context, wasm []byte, memoryMB int) (*pluginModule, error) {
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(uint32(memoryMB)*16). // 64 KiB pages
+		WithCloseOnContextDone(true))
+	// Modules built for WASI, e.g. by TinyGo or Rust, import it; nothing
+	// from the host is exposed through it
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+		runtime.Close(ctx)
+		return nil, err
+	}
+
+	compiled, err := runtime.CompileModule(ctx, wasm)
+	if err != nil {
+		runtime.Close(ctx)
+		return nil, err
+	}
+	exports := compiled.ExportedFunctions()
+	m := &pluginModule{runtime: runtime, compiled: compiled}
+	alloc, hasAlloc := exports[pluginAlloc]
+	_, m.onRequest = exports[pluginOnRequest]
+	_, m.onResponse = exports[pluginOnResponse]
+	_, hasMemory := compiled.ExportedMemories()["memory"]
+	switch {
+	case !hasMemory:
+		err = errors.New("module does not export its memory")
+	case !hasAlloc:
+		err = fmt.Errorf("module does not export %s", pluginAlloc)
+	case !m.onRequest && !m.onResponse:
+		err = fmt.Errorf("module exports neither %s nor %s", pluginOnRequest, pluginOnResponse)
+	default:
+		err = checkSignature(alloc, []api.ValueType{api.ValueTypeI32}, api.ValueTypeI32)
+		for _, hook := range []string{pluginOnRequest, pluginOnResponse} {
+			if def, ok := exports[hook]; ok && err == nil {
+				err = checkSignature(def, []api.ValueType{api.ValueTypeI32, api.ValueTypeI32}, api.ValueTypeI64)
+			}
+		}
+	}
+	if err != nil {
+		runtime.Close(ctx)
+		return nil, err
+	}
+	return m, nil
+}
+
+func checkSignature(def api.FunctionDefinition, params []api.ValueType, result api.ValueType) error {
+	if !bytes.Equal(def.ParamTypes(), params) || !bytes.Equal(def.ResultTypes(), []api.ValueType{result}) {
+		return fmt.Errorf("%s has signature %v -> %v, expected %v -> %v",
+			def.Name(), def.ParamTypes(), def.ResultTypes(), params, []api.ValueType{result})
+	}
+	return nil
+}
+
+// ValidatePlugin checks that a module compiles and implements the plugin
+// interface
+func ValidatePlugin(ctx context.Context, wasm []byte, memoryMB int) error {
+	m, err := compilePlugin(ctx, wasm, memoryMB)
+	if err != nil {
+		return err
+	}
+	return m.runtime.Close(ctx)
+}
+
+// module returns the compiled plugin, compiling the module from load on
+// first use
+func (h *pluginHost) module(ctx context.Context, sha256 string, memoryMB int, load func() ([]byte, error)) (*pluginModule, error) {
+	key := pluginKey(sha256, memoryMB)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if m, ok := h.modules[key]; ok {
+		return m, nil
+	}
+
+	wasm, err := load()
+	if err != nil {
+		return nil, err
+	}
+	m, err := compilePlugin(ctx, wasm, memoryMB)
+	if err != nil {
+		return nil, err
+	}
+	if h.modules == nil {
+		h.modules = make(map[string]*pluginModule)
+	}
+	h.modules[key] = m
+	return m, nil
+}
+
+// prune releases compiled plugins that no installed domain runs anymore
+func (h *pluginHost) prune(installed map[int64]*DomainConfig) {
+	inUse := make(map[string]bool)
+	for _, config := range installed {
+		for _, pl := range config.Plugins {
+			inUse[pluginKey(pl.SHA256, pl.MemoryMB)] = true
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for key, m := range h.modules {
+		if !inUse[key] {
+			// Closing the runtime ends its instances; wait out the calls
+			// in flight, which the plugin timeout bounds
+			time.AfterFunc(MaxPluginTimeout, func() { m.runtime.Close(context.Background()) })
+			delete(h.modules, key)
+		}
+	}
+}
+
+// call runs one hook in a fresh instance of the plugin
+func (pl *Plugin) call(ctx context.Context, hook string, input interface{}) (*pluginAction, error) {
+	in, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pl.Timeout)
+	defer cancel()
+
+	mod, err := pl.module.runtime.InstantiateModule(ctx, pl.module.compiled,
+		wazero.NewModuleConfig().
+			WithName(""). // instances run side by side
+			WithStartFunctions("_initialize").
+			WithStdout(io.Discard).
+			WithStderr(io.Discard))
+	if err != nil {
+		return nil, fmt.Errorf("instantiating: %w", err)
+	}
+	defer mod.Close(context.Background())
+
+	res, err := mod.ExportedFunction(pluginAlloc).Call(ctx, uint64(len(in)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", pluginAlloc, err)
+	}
+	ptr := uint32(res[0])
+	if !mod.Memory().Write(ptr, in) {
+		return nil, fmt.Errorf("%s returned memory out of range", pluginAlloc)
+	}
+
+	res, err = mod.ExportedFunction(hook).Call(ctx, uint64(ptr), uint64(len(in)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", hook, err)
+	}
+	if res[0] == 0 {
+		return nil, nil
+	}
+	out, ok := mod.Memory().Read(uint32(res[0]>>32), uint32(res[0]))
+	if !ok {
+		return nil, fmt.Errorf("%s returned memory out of range", hook)
+	}
+	var action pluginAction
+	if err := json.Unmarshal(out, &action); err != nil {
+		return nil, fmt.Errorf("%s returned invalid JSON: %w", hook, err)
+	}
+	return &action, nil
+}
+
+func newPluginRequest(r *http.Request, domain string) *pluginRequest {
+	return &pluginRequest{
+		Method:     r.Method,
+		Host:       domain,
+		Path:       r.URL.Path,
+		Query:      r.URL.RawQuery,
+		RemoteAddr: r.RemoteAddr,
+		Headers:    r.Header,
+	}
+}
+
+// pluginFailed logs a failed hook and reports whether the request may go on
+func pluginFailed(pl *Plugin, domain, hook string, err error) bool {
+	logger.Warn("Plugin failed", "plugin", pl.Name, "domain", domain, "hook", hook, "error", err)
+	reporting.Throttled("plugin:"+pl.Name, err, map[string]string{
+		"plugin": pl.Name,
+		"domain": domain,
+	})
+	return pl.FailOpen
+}
+
+// runRequestPlugins runs the domain's request hooks in order. It reports
+// whether a plugin answered the request, in which case it must not be
+// proxied.
+func (p *ProxyServer) runRequestPlugins(w http.ResponseWriter, r *http.Request, config *DomainConfig, start time.Time, probe bool) bool {
+	for _, pl := range config.Plugins {
+		if !pl.module.onRequest {
+			continue
+		}
+		input := newPluginRequest(r, config.Domain)
+		input.Config = pl.Config
+		action, err := pl.call(r.Context(), pluginOnRequest, input)
+		if err != nil {
+			if pluginFailed(pl, config.Domain, pluginOnRequest, err) {
+				continue
+			}
+			http.Error(w, "Plugin error", http.StatusBadGateway)
+			if !probe {
+				p.metrics.RecordRequest(config.Domain, http.StatusBadGateway, time.Since(start))
+			}
+			return true
+		}
+		if action == nil {
+			continue
+		}
+
+		if action.Status != 0 {
+			for name, value := range action.SetHeaders {
+				w.Header().Set(name, value)
+			}
+			w.Header().Set(pluginHeader, pl.Name)
+			w.WriteHeader(action.Status)
+			io.WriteString(w, action.Body)
+			if !probe {
+				p.metrics.RecordRequest(config.Domain, action.Status, time.Since(start))
+			}
+			return true
+		}
+		for _, name := range action.RemoveHeaders {
+			r.Header.Del(name)
+		}
+		for name, value := range action.SetHeaders {
+			r.Header.Set(name, value)
+		}
+	}
+	return false
+}
+
+// runResponsePlugins runs the domain's response hooks, in reverse order so
+// the first plugin sees the request first and the response last
+func runResponsePlugins(resp *http.Response, config *DomainConfig) error {
+	for i := len(config.Plugins) - 1; i >= 0; i-- {
+		pl := config.Plugins[i]
+		if !pl.module.onResponse {
+			continue
+		}
+		input := &pluginResponse{
+			Status:  resp.StatusCode,
+			Headers: resp.Header,
+			Request: newPluginRequest(resp.Request, config.Domain),
+			Config:  pl.Config,
+		}
+		action, err := pl.call(resp.Request.Context(), pluginOnResponse, input)
+		if err != nil {
+			if pluginFailed(pl, config.Domain, pluginOnResponse, err) {
+				continue
+			}
+			return fmt.Errorf("plugin %s: %w", pl.Name, err)
+		}
+		if action == nil {
+			continue
+		}
+
+		for _, name := range action.RemoveHeaders {
+			resp.Header.Del(name)
+		}
+		for name, value := range action.SetHeaders {
+			resp.Header.Set(name, value)
+		}
+		if action.Status != 0 {
+			resp.StatusCode = action.Status
+			resp.Status = strconv.Itoa(action.Status) + " " + http.StatusText(action.Status)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"viacortex/internal/logging"
+	"viacortex/internal/reporting"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+var probeLog = logging.For("probe")
+
+// probeHeader carries the prober's token, so the proxy can tell its
+// synthetic requests from user traffic. It is removed before the request
+// reaches a backend.
+const probeHeader = "X-ViaCortex-Probe"
+
+// probeRetention is how long probe results are kept
+const probeRetention = 7 * 24 * time.Hour
+
+// ProbeResult is the outcome of one synthetic request
+type ProbeResult struct {
+	Domain     string    `json:"domain"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Latency    float64   `json:"latency_ms"`
+	Error      string    `json:"error,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// ProbeStatus describes whether the prober is running and what it found
+type ProbeStatus struct {
+	Running  bool          `json:"running"`
+	Interval string        `json:"interval,omitempty"`
+	Path     string        `json:"path,omitempty"`
+	Started  *time.Time    `json:"started,omitempty"`
+	Results  []ProbeResult `json:"results"`
+}
+
+// Prober periodically sends a request through the proxy's own listeners to
+// every enabled domain, so the whole path from listener to backend is
+// measured. Its requests are left out of the traffic metrics and stored as
+// probe results instead.
+type Prober struct {
+	db        *pgxpool.Pool
+	proxy     *ProxyServer
+	nodeID    string
+	httpPort  int
+	httpsPort int
+	client    *http.Client
+
+	mu       sync.Mutex
+	cancel   context.CancelFunc // stops the running probe loop, if any
+	interval time.Duration
+	path     string
+	started  time.Time
+	results  map[string]ProbeResult // latest result per domain
+}
+
+func NewProber(db *pgxpool.Pool, p *ProxyServer, nodeID string, httpPort, httpsPort int) *Prober {
+	token := make([]byte, 16)
+	rand.Read(token)
+	p.probeToken = hex.EncodeToString(token)
+
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	return &Prober{
+		db:        db,
+		proxy:     p,
+		nodeID:    nodeID,
+		httpPort:  httpPort,
+		httpsPort: httpsPort,
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				// Whatever the domain resolves to, the request goes to
+				// this node's own listener
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					_, port, err := net.SplitHostPort(addr)
+					if err != nil {
+						return nil, err
+					}
+					return dialer.DialContext(ctx, network, net.JoinHostPort("127.0.0.1", port))
+				},
+				// Latency is measured even while a certificate is pending
+				TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+				DisableKeepAlives: true,
+			},
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		results: make(map[string]ProbeResult),
+	}
+}
+
+// Start probes every domain each interval, replacing a running probe loop
+func (pr *Prober) Start(interval time.Duration, path string) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	if pr.cancel != nil {
+		pr.cancel()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	pr.cancel = cancel
+	pr.interval = interval
+	pr.path = path
+	pr.started = time.Now()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			pr.Run(ctx, path)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	probeLog.Info("Started synthetic probes", "interval", interval, "path", path)
+}
+
+// Stop ends the probe loop
+func (pr *Prober) Stop() {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	if pr.cancel == nil {
+		return
+	}
+	pr.cancel()
+	pr.cancel = nil
+	probeLog.Info("Stopped synthetic probes")
+}
+
+// Status reports whether probes are running and the latest result per domain
+func (pr *Prober) Status() ProbeStatus {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	status := ProbeStatus{Running: pr.cancel != nil, Results: make([]ProbeResult, 0, len(pr.results))}
+	if status.Running {
+		started := pr.started
+		status.Interval = pr.interval.String()
+		status.Path = pr.path
+		status.Started = &started
+	}
+	for _, result := range pr.results {
+		status.Results = append(status.Results, result)
+	}
+	sort.Slice(status.Results, func(i, j int) bool {
+		return status.Results[i].Domain < status.Results[j].Domain
+	})
+	return status
+}
+
+// Run probes every enabled domain once and stores the results
+func (pr *Prober) Run(ctx context.Context, path string) []ProbeResult {
+	var domains []*DomainConfig
+	pr.proxy.domains.Range(func(key, value interface{}) bool {
+		config := value.(*DomainConfig)
+		// Aliases share their domain's configuration
+		if config.Enabled && key.(string) == config.Domain {
+			domains = append(domains, config)
+		}
+		return true
+	})
+
+	results := make([]ProbeResult, 0, len(domains))
+	for _, config := range domains {
+		if ctx.Err() != nil {
+			break
+		}
+		result := pr.probe(ctx, config, path)
+		results = append(results, result)
+
+		pr.mu.Lock()
+		pr.results[result.Domain] = result
+		pr.mu.Unlock()
+	}
+	pr.store(ctx, results)
+	return results
+}
+
+// probe sends one request to a domain through the listener its users reach
+func (pr *Prober) probe(ctx context.Context, config *DomainConfig, path string) ProbeResult {
+	result := ProbeResult{Domain: config.Domain, Time: time.Now()}
+
+	scheme, port := "http", pr.httpPort
+	if config.SSLEnabled {
+		scheme, port = "https", pr.httpsPort
+	}
+	url := fmt.Sprintf("%s://%s:%d%s", scheme, config.Domain, port, path)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	req.Header.Set("User-Agent", "ViaCortex-Probe")
+	req.Header.Set(probeHeader, pr.proxy.probeToken)
+
+	start := time.Now()
+	resp, err := pr.client.Do(req)
+	result.Latency = float64(time.Since(start).Microseconds()) / 1000
+	if err != nil {
+		result.Error = err.Error()
+		probeLog.Debug("Probe failed", "domain", config.Domain, "error", err)
+		return result
+	}
+	resp.Body.Close()
+	result.StatusCode = resp.StatusCode
+	probeLog.Debug("Probed domain", "domain", config.Domain, "status", resp.StatusCode, "latency_ms", result.Latency)
+	return result
+}
+
+// store records results and prunes those past the retention period
+func (pr *Prober) store(ctx context.Context, results []ProbeResult) {
+	if pr.db == nil {
+		return
+	}
+	for _, result := range results {
+		_, err := pr.db.Exec(ctx, `
+			INSERT INTO probe_results (domain, node_id, status_code, latency_ms, error, timestamp)
+			VALUES ($1, NULLIF($2, ''), NULLIF($3, 0), $4, NULLIF($5, ''), $6)
+		`, result.Domain, pr.nodeID, result.StatusCode, result.Latency, result.Error, result.Time)
+		if err != nil {
+			probeLog.Error("Storing probe result failed", "error", err)
+			reporting.Throttled("probe:store", err, map[string]string{"job": "probe"})
+			return
+		}
+	}
+
+	if _, err := pr.db.Exec(ctx, "DELETE FROM probe_results WHERE timestamp < $1",
+		time.Now().Add(-probeRetention)); err != nil {
+		probeLog.Warn("Pruning probe results failed", "error", err)
+	}
+}
+
+// isProbe reports whether a request was sent by the prober
+func (p *ProxyServer) isProbe(r *http.Request) bool {
+	return p.probeToken != "" && r.Header.Get(probeHeader) == p.probeToken
+}
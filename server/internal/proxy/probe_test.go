@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestProberRun(t *testing.T) {
+	p := &ProxyServer{}
+	var seen []string
+	listener := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !p.isProbe(r) {
+			t.Errorf("request to %s does not carry the probe token", r.Host)
+		}
+		seen = append(seen, r.Host+r.URL.Path)
+		if host, _, _ := net.SplitHostPort(r.Host); host == "down.example.com" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer listener.Close()
+
+	_, portStr, _ := net.SplitHostPort(listener.Listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+	prober := NewProber(nil, p, "node-1", port, port)
+
+	up := &DomainConfig{Domain: "up.example.com", Enabled: true}
+	p.domains.Store("up.example.com", up)
+	p.domains.Store("www.up.example.com", up) // alias, probed once through its domain
+	p.domains.Store("down.example.com", &DomainConfig{Domain: "down.example.com", Enabled: true})
+	p.domains.Store("paused.example.com", &DomainConfig{Domain: "paused.example.com"})
+
+	results := prober.Run(context.Background(), "/healthz")
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2: %+v", len(results), results)
+	}
+	if len(seen) != 2 {
+		t.Errorf("listener saw %v, want one request per enabled domain", seen)
+	}
+
+	status := prober.Status()
+	if status.Running {
+		t.Error("Status reports a probe loop that was never started")
+	}
+	want := map[string]int{"down.example.com": http.StatusBadGateway, "up.example.com": http.StatusOK}
+	if len(status.Results) != 2 || status.Results[0].Domain != "down.example.com" || status.Results[1].Domain != "up.example.com" {
+		t.Fatalf("results = %+v, want both domains sorted by name", status.Results)
+	}
+	for _, result := range status.Results {
+		if result.Error != "" || result.StatusCode != want[result.Domain] {
+			t.Errorf("%s: status %d, error %q, want status %d", result.Domain, result.StatusCode, result.Error, want[result.Domain])
+		}
+	}
+}
+
+func TestIsProbe(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		header string
+		want   bool
+	}{
+		{"matching token", "secret", "secret", true},
+		{"wrong token", "secret", "guess", false},
+		{"no header", "secret", "", false},
+		{"prober not started", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &ProxyServer{probeToken: tt.token}
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				r.Header.Set(probeHeader, tt.header)
+			}
+			if got := p.isProbe(r); got != tt.want {
+				t.Errorf("isProbe = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
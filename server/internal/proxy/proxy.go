@@ -2,9 +2,9 @@ package proxy
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"net/http"
 	"net/http/httputil"
@@ -12,20 +12,57 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"viacortex/internal/logging"
+	"viacortex/internal/reporting"
+	"viacortex/internal/webhooks"
+
 	"github.com/caddyserver/certmagic"
 	"golang.org/x/time/rate"
 	"crypto/tls"
 )
 
+// Loggers of the proxy's modules; each can be set to its own level
+var (
+	logger  = logging.For("proxy")
+	tcpLog  = logging.For("tcp")
+	acmeLog = logging.For("acme")
+)
+
 type ProxyServer struct {
 	domains     sync.Map // map[string]*DomainConfig
 	rateLimits  sync.Map // map[string]*rate.Limiter
+	limiter     RateLimiter // shared rate limit state, if set
+	bus         Bus         // cluster events, if set
+	startTraces bool        // start a trace for requests arriving without one
+
+	inFlight  atomic.Int64 // requests and TCP connections being served
+	drainMu   sync.Mutex
+	drain     DrainStatus
+	serversMu sync.Mutex
+	servers   []*http.Server // the HTTP and HTTPS servers started by Run
 	metrics     *MetricsCollector
 	certManager *certmagic.Config
+	webhooks    *webhooks.Dispatcher
+	publicIPs   []net.IP
+	dnsStatus   sync.Map // map[string]DNSStatus
+	listeners   sync.Map // map[string]bool, keyed by listener name
+	bound       sync.Map // map[string]net.Listener, opened by Bind before Run
+	dataDir     string   // certmagic storage, set by ConfigureCertmagic
+	certStorage certmagic.Storage // shared storage replacing dataDir, if set
+	certPending sync.Map // map[string]struct{}, domains whose certificate request failed
+	acmeHTTPPort   int    // port certmagic answers HTTP-01 challenges on
+	acmeListenHost string // address certmagic's challenge listener binds to
+	tcpPorts    map[string]int
+	probeToken  string // marks the prober's requests, set by NewProber
+	plugins     pluginHost
+	hooks       hookRegistry // registered by code embedding the proxy
+	blocklist   atomic.Pointer[Blocklist] // threat feeds, set by SetBlocklist
 }
 
 type DomainConfig struct {
@@ -33,9 +70,18 @@ type DomainConfig struct {
 	Backends          []*BackendServer
 	IPRules           []*IPRule
 	RateLimit         *RateLimit
+	Transport         *Transport // nil uses the default connection pool
+	Faults            *Fault     // chaos testing, if set
+	Plugins           []*Plugin  // WASM plugins, in the order they see requests
+	Rewrite           *BodyRewrite // backend URLs replaced in responses, if set
+	CacheRules        []*CacheRule // the first matching rule sets Cache-Control
+	EarlyHints        []*EarlyHint // sent as 103 before proxying
+	ServerTiming      bool         // add the proxy's timings to responses
 	SSLEnabled        bool
 	HealthCheckEnabled bool
+	Enabled           bool // false while the domain is paused
 	currentBackend    int
+	transport        *http.Transport // built from Transport on first use
 	mu               sync.Mutex
 }
 
@@ -71,13 +117,15 @@ func NewProxyServer() (*ProxyServer, error) {
 	return &ProxyServer{
 		certManager: certConfig,
 		metrics:     NewMetricsCollector(),
+		tcpPorts:    map[string]int{"minecraft": 25565},
+		acmeHTTPPort: 80,
 	}, nil
 }
 
 // storeACMEChallenge is a helper to manually create an ACME challenge token file if needed
 func (p *ProxyServer) storeACMEChallenge(domain, token, keyAuth string) error {
 	// Ensure base directories exist
-	dataDir := "/root/.local/share/certmagic"
+	dataDir := p.dataDir
 	
 	// Store in multiple possible locations for compatibility
 	locations := []string{
@@ -88,24 +136,24 @@ func (p *ProxyServer) storeACMEChallenge(domain, token, keyAuth string) error {
 	for _, location := range locations {
 		// Ensure parent directory exists
 		if err := os.MkdirAll(filepath.Dir(location), 0700); err != nil {
-			log.Printf("Warning: failed to create directory for challenge token at %s: %v", location, err)
+			acmeLog.Warn("Failed to create challenge token directory", "path", location, "error", err)
 			continue
 		}
 		
 		// Write the token
 		if err := os.WriteFile(location, []byte(keyAuth), 0600); err != nil {
-			log.Printf("Warning: failed to write challenge token to %s: %v", location, err)
+			acmeLog.Warn("Failed to write challenge token", "path", location, "error", err)
 			continue
 		}
 		
-		log.Printf("Successfully stored ACME challenge token at %s", location)
+		acmeLog.Debug("Stored challenge token", "path", location)
 	}
 	
 	// Also try to store via the storage interface
 	if err := p.certManager.Storage.Store(context.Background(), path.Join("acme", "http-01", domain, token), []byte(keyAuth)); err != nil {
-		log.Printf("Warning: failed to store challenge token via storage interface: %v", err)
+		acmeLog.Warn("Failed to store challenge token in certificate storage", "error", err)
 	} else {
-		log.Printf("Successfully stored ACME challenge token via storage interface")
+		acmeLog.Debug("Stored challenge token in certificate storage")
 	}
 	
 	return nil
@@ -117,28 +165,39 @@ func (p *ProxyServer) handleACMEChallenge(w http.ResponseWriter, r *http.Request
 		return false
 	}
 
-	// Get the token from the path
+	// Challenges solved by certmagic, which leaves them to this listener
+	// when its own cannot bind the port
+	for _, issuer := range p.certManager.Issuers {
+		if am, ok := issuer.(*certmagic.ACMEIssuer); ok && am.HandleHTTPChallenge(w, r) {
+			acmeLog.Debug("Served challenge from certmagic", "host", r.Host)
+			return true
+		}
+	}
+
+	// Get the token from the path. The host is normalized before it is
+	// used in storage keys and file paths.
 	token := path.Base(r.URL.Path)
+	host := HostKey(r.Host)
 	
-	log.Printf("Handling ACME challenge for token: %s, host: %s", token, r.Host)
+	acmeLog.Debug("Handling challenge", "token", token, "host", r.Host)
 	
 	// Get the key authorization from certmagic's storage
-	challengePath := path.Join("acme", "http-01", r.Host, token)
+	challengePath := path.Join("acme", "http-01", host, token)
 	keyAuth, err := p.certManager.Storage.Load(context.Background(), challengePath)
 	if err != nil {
 		// Try alternate path format used by some certmagic versions
-		challengePath = path.Join("acme-http-01", r.Host, token)
+		challengePath = path.Join("acme-http-01", host, token)
 		keyAuth, err = p.certManager.Storage.Load(context.Background(), challengePath)
 		if err != nil {
-			log.Printf("ACME challenge error for token %s: %v", token, err)
+			acmeLog.Debug("Challenge not in certificate storage", "token", token, "error", err)
 			
 			// As a fallback, check if token exists directly in the storage directory
-			dataDir := "/root/.local/share/certmagic"
-			tokenPath := filepath.Join(dataDir, "acme", "http-01", r.Host, token)
-			log.Printf("Trying to read token directly from: %s", tokenPath)
+			dataDir := p.dataDir
+			tokenPath := filepath.Join(dataDir, "acme", "http-01", host, token)
+			acmeLog.Debug("Trying challenge token file", "path", tokenPath)
 			
 			if content, err := os.ReadFile(tokenPath); err == nil {
-				log.Printf("Successfully read token from direct file: %s", tokenPath)
+				acmeLog.Debug("Read challenge token file", "path", tokenPath)
 				w.Header().Set("Content-Type", "text/plain")
 				w.Write(content)
 				return true
@@ -149,7 +208,7 @@ func (p *ProxyServer) handleACMEChallenge(w http.ResponseWriter, r *http.Request
 		}
 	}
 
-	log.Printf("Successfully serving ACME challenge for %s: %s", r.Host, string(keyAuth))
+	acmeLog.Info("Serving challenge", "host", r.Host, "token", token)
 	
 	// Serve the challenge response
 	w.Header().Set("Content-Type", "text/plain")
@@ -158,18 +217,32 @@ func (p *ProxyServer) handleACMEChallenge(w http.ResponseWriter, r *http.Request
 }
 
 func (p *ProxyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		// net/http recovers and logs the panic; report it as well
+		if v := recover(); v != nil {
+			if v != http.ErrAbortHandler {
+				reporting.Panic(v, map[string]string{"domain": HostKey(r.Host)})
+			}
+			panic(v)
+		}
+	}()
+
 	// Check for ACME challenge first
 	if p.handleACMEChallenge(w, r) {
 		return
 	}
 
-	start := time.Now()
-	domain := r.Host
-
-	// Strip port from domain if present
-	if host, _, err := net.SplitHostPort(domain); err == nil {
-		domain = host
+	p.inFlight.Add(1)
+	defer p.inFlight.Add(-1)
+	if p.Draining() {
+		// Send the client's next request through the load balancer
+		w.Header().Set("Connection", "close")
 	}
+
+	start := time.Now()
+	domain := HostKey(r.Host)
+	// Synthetic probes are recorded by the prober, not as traffic
+	probe := p.isProbe(r)
 	
 	// Get domain config
 	configVal, ok := p.domains.Load(domain)
@@ -178,7 +251,20 @@ func (p *ProxyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	config := configVal.(*DomainConfig)
+
+	// Paused domains keep their configuration but serve no traffic
+	if !config.Enabled {
+		servePausedPage(w)
+		return
+	}
 	
+	// Addresses from threat feeds are blocked for every domain, before its
+	// own IP rules
+	if p.blockedByFeed(r.RemoteAddr) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
 	// Check IP rules
 	if !p.checkIPRules(r, config) {
 		http.Error(w, "Access denied", http.StatusForbidden)
@@ -190,6 +276,19 @@ func (p *ProxyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
 		return
 	}
+
+	// Chaos testing, if enabled for the domain
+	if p.injectFault(w, r, config, start) {
+		return
+	}
+
+	// Custom logic from the embedding program and the domain's plugins
+	if p.runRequestHooks(w, r, domain) {
+		return
+	}
+	if p.runRequestPlugins(w, r, config, start, probe) {
+		return
+	}
 	
 	// Select backend using round-robin
 	backend := p.selectBackend(config)
@@ -201,14 +300,35 @@ func (p *ProxyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Create the reverse proxy
 	targetURL := &url.URL{
 		Scheme: backend.Scheme,
-		Host:   fmt.Sprintf("%s:%d", backend.IP.String(), backend.Port),
+		Host:   net.JoinHostPort(backend.IP.String(), strconv.Itoa(backend.Port)),
 	}
 	
+	// The proxy's hop of a distributed trace
+	span := p.startSpan(r)
+	status := 0
+
+	// Metered request and response bytes
+	var bytesIn, bytesOut *countingBody
+	r.Body, bytesIn = countBody(r.Body)
+
+	var timing *serverTiming
+	if config.ServerTiming {
+		timing = &serverTiming{start: start}
+		r = r.WithContext(timing.trace(r.Context()))
+	}
+
 	proxy := &httputil.ReverseProxy{
 		Director: func(req *http.Request) {
 			req.URL.Scheme = targetURL.Scheme
 			req.URL.Host = targetURL.Host
 			req.Host = domain
+			setTraceHeaders(req, span)
+			req.Header.Del(probeHeader)
+			if config.Rewrite != nil {
+				// Bodies are rewritten uncompressed; the transport
+				// still compresses them on the way from the backend
+				req.Header.Del("Accept-Encoding")
+			}
 
 			// Preserve original client IP if behind another proxy
 			if clientIP := req.Header.Get("X-Forwarded-For"); clientIP != "" {
@@ -218,30 +338,56 @@ func (p *ProxyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			}
 		},
 		ModifyResponse: func(resp *http.Response) error {
-			duration := time.Since(start)
-			p.metrics.RecordRequest(domain, resp.StatusCode, duration)
+			if err := rewriteResponse(resp, config); err != nil {
+				return err
+			}
+			applyCacheRules(resp, config)
+			if err := runResponsePlugins(resp, config); err != nil {
+				return err
+			}
+			if err := p.runResponseHooks(resp, domain); err != nil {
+				return err
+			}
+			addServerTiming(resp, timing)
+			status = resp.StatusCode
+			resp.Body, bytesOut = countBody(resp.Body)
+			if !probe {
+				p.metrics.RecordRequest(domain, resp.StatusCode, time.Since(start))
+			}
 			return nil
 		},
 		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
-			log.Printf("Proxy error for %s: %v", domain, err)
-			p.metrics.RecordError(domain)
+			logger.Warn("Backend error", "domain", domain, "backend", backend.IP.String(), "error", err)
+			p.runErrorHooks(r, domain, err)
+			if !probe {
+				p.metrics.RecordError(domain)
+			}
+			// Clients going away are not backend failures
+			if !errors.Is(err, context.Canceled) {
+				reporting.Throttled("proxy:"+domain, err, map[string]string{
+					"domain":  domain,
+					"backend": backend.IP.String(),
+				})
+			}
+			status = http.StatusBadGateway
 			http.Error(w, "Backend error", http.StatusBadGateway)
 		},
-		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			DialContext: (&net.Dialer{
-				Timeout:   30 * time.Second,
-				KeepAlive: 30 * time.Second,
-			}).DialContext,
-			ForceAttemptHTTP2:     true,
-			MaxIdleConns:          100,
-			IdleConnTimeout:       90 * time.Second,
-			TLSHandshakeTimeout:   10 * time.Second,
-			ExpectContinueTimeout: 1 * time.Second,
-		},
+		Transport: config.roundTripper(),
 	}
-	
+
+	// Let the browser start on the page's assets while the backend works
+	sendEarlyHints(w, r, config)
 	proxy.ServeHTTP(w, r)
+	if !probe {
+		out := int64(0)
+		if bytesOut != nil {
+			out = bytesOut.n
+		}
+		p.metrics.RecordBytes(domain, bytesIn.n, out)
+	}
+	if span != nil {
+		span.end(r, domain, status)
+	}
 }
 
 func (p *ProxyServer) checkIPRules(r *http.Request, config *DomainConfig) bool {
@@ -276,11 +422,20 @@ func (p *ProxyServer) checkRateLimit(r *http.Request, config *DomainConfig) bool
 		if err != nil {
 			host = r.RemoteAddr
 		}
-		key = fmt.Sprintf("%s-%s", config.Domain, host)
+		key = config.Domain + "|" + host
 	} else {
 		key = config.Domain
 	}
 	
+	if p.limiter != nil {
+		allowed, err := p.limiter.Allow(r.Context(), key, config.RateLimit)
+		if err == nil {
+			return allowed
+		}
+		// Enforce the limit per node until the shared store is back
+		logger.Warn("Shared rate limiter failed, limiting locally", "error", err)
+	}
+
 	limiter, _ := p.rateLimits.LoadOrStore(key, rate.NewLimiter(
 		rate.Limit(config.RateLimit.RequestsPerSecond),
 		config.RateLimit.BurstSize,
@@ -310,19 +465,98 @@ func (p *ProxyServer) selectBackend(config *DomainConfig) *BackendServer {
 	return nil
 }
 
+// UpdateDomain installs a domain's configuration. Replacing an existing one
+// keeps its round-robin position; rate limiters are only reset when the
+// rate limit changes, and a certificate is only requested when SSL is newly
+// enabled or an earlier request failed.
 func (p *ProxyServer) UpdateDomain(domain string, config *DomainConfig) {
-	p.domains.Store(domain, config)
-	
-	// If SSL is enabled, ensure we have a certificate
-	if config.SSLEnabled {
-		if err := p.ObtainCertificate(domain); err != nil {
-			log.Printf("Error obtaining certificate for %s: %v", domain, err)
+	previous, existed := p.domains.Load(domain)
+	if existed {
+		old := previous.(*DomainConfig)
+		if old != config {
+			old.mu.Lock()
+			position := old.currentBackend
+			pool := old.transport
+			old.mu.Unlock()
+			// Keep pooled backend connections unless the pool settings
+			// changed
+			if pool != nil && !old.Transport.equal(config.Transport) {
+				pool.CloseIdleConnections()
+				pool = nil
+			}
+			config.mu.Lock()
+			config.currentBackend = position
+			if config.transport == nil {
+				config.transport = pool
+			}
+			config.mu.Unlock()
+		}
+
+		if !old.RateLimit.equal(config.RateLimit) {
+			p.resetRateLimits(domain)
 		}
 	}
+	p.domains.Store(domain, config)
+
+	if !config.SSLEnabled {
+		p.certPending.Delete(domain)
+		return
+	}
+	_, pending := p.certPending.Load(domain)
+	if !existed || !previous.(*DomainConfig).SSLEnabled || pending {
+		p.ensureCertificate(domain)
+	}
+}
+
+// RetryCertificate requests the certificate of an SSL domain again if the
+// last request failed, e.g. because DNS did not point here yet
+func (p *ProxyServer) RetryCertificate(domain string) {
+	if _, pending := p.certPending.Load(domain); pending {
+		p.ensureCertificate(domain)
+	}
+}
+
+func (p *ProxyServer) ensureCertificate(domain string) {
+	if err := p.ObtainCertificate(domain); err != nil {
+		acmeLog.Error("Obtaining certificate failed", "domain", domain, "error", err)
+		p.certPending.Store(domain, struct{}{})
+		return
+	}
+	p.certPending.Delete(domain)
 }
 
 func (p *ProxyServer) DeleteDomain(domain string) {
-	p.domains.Delete(domain)
+	if previous, ok := p.domains.LoadAndDelete(domain); ok {
+		old := previous.(*DomainConfig)
+		old.mu.Lock()
+		if old.transport != nil {
+			old.transport.CloseIdleConnections()
+		}
+		old.mu.Unlock()
+	}
+	p.certPending.Delete(domain)
+	p.resetRateLimits(domain)
+}
+
+// Domain returns the configuration currently installed for a domain
+func (p *ProxyServer) Domain(domain string) (*DomainConfig, bool) {
+	v, ok := p.domains.Load(domain)
+	if !ok {
+		return nil, false
+	}
+	return v.(*DomainConfig), true
+}
+
+// resetRateLimits drops a domain's limiters so they are rebuilt from its
+// current rate limit
+func (p *ProxyServer) resetRateLimits(domain string) {
+	p.rateLimits.Range(func(key, _ interface{}) bool {
+		k := key.(string)
+		if k == domain || strings.HasPrefix(k, domain+"|") {
+			p.rateLimits.Delete(k)
+		}
+		return true
+	})
 }
 
 func (p *ProxyServer) ObtainCertificate(domain string) error {
@@ -340,20 +574,35 @@ func (p *ProxyServer) ObtainCertificate(domain string) error {
 	
 	// Log the domain transformation for debugging
 	if cleanDomain != domain {
-		log.Printf("Requesting certificate for %s (stripped from %s)", cleanDomain, domain)
+		acmeLog.Debug("Normalized certificate domain", "domain", cleanDomain, "from", domain)
+	}
+
+	// Refuse to start ACME when DNS does not point here, it would only fail
+	previous, checked := p.LastDNSStatus(cleanDomain)
+	dnsStatus := p.CheckDNS(ctx, cleanDomain)
+	if !dnsStatus.Ready() {
+		// Domains are reloaded periodically, only notify when the outcome changes
+		if !checked || previous.Status != dnsStatus.Status {
+			p.webhooks.Emit(webhooks.EventCertificateFailed, map[string]interface{}{
+				"identifier": cleanDomain,
+				"reason":     "dns_precheck",
+				"dns":        dnsStatus,
+			})
+		}
+		return fmt.Errorf("DNS pre-check failed for %s: %s", cleanDomain, dnsStatus.Message)
 	}
 	
 	// Ensure challenge directories exist for this specific domain
-	dataDir := "/root/.local/share/certmagic"
+	dataDir := p.dataDir
 	httpChallengeDomainDir := filepath.Join(dataDir, "acme", "http-01", cleanDomain)
 	if err := os.MkdirAll(httpChallengeDomainDir, 0700); err != nil {
-		log.Printf("Warning: could not create challenge directory for %s: %v", cleanDomain, err)
+		acmeLog.Warn("Failed to create challenge directory", "domain", cleanDomain, "error", err)
 	}
 	
 	// Also create the alternative path used by some certmagic versions
 	altChallengeDomainDir := filepath.Join(dataDir, "acme-http-01", cleanDomain)
 	if err := os.MkdirAll(altChallengeDomainDir, 0700); err != nil {
-		log.Printf("Warning: could not create alt challenge directory for %s: %v", cleanDomain, err)
+		acmeLog.Warn("Failed to create alternate challenge directory", "domain", cleanDomain, "error", err)
 	}
 	
 	// Configure with HTTP-01 only for this request
@@ -363,7 +612,8 @@ func (p *ProxyServer) ObtainCertificate(domain string) error {
 		Agreed:                  true,
 		DisableHTTPChallenge:    false,
 		DisableTLSALPNChallenge: true,
-		AltHTTPPort:             80, // Ensure we're using standard HTTP port
+		AltHTTPPort:             p.acmeHTTPPort,
+		ListenHost:              p.acmeListenHost,
 		Logger:                  certmagic.DefaultACME.Logger,
 	})
 	
@@ -371,18 +621,19 @@ func (p *ProxyServer) ObtainCertificate(domain string) error {
 	p.certManager.Issuers = []certmagic.Issuer{issuer}
 	
 	// Request certificate management
-	log.Printf("Requesting certificate management for %s", cleanDomain)
+	acmeLog.Debug("Requesting certificate management", "domain", cleanDomain)
 	if err := p.certManager.ManageAsync(ctx, []string{cleanDomain}); err != nil {
 		return fmt.Errorf("failed to obtain certificate for %s: %w", cleanDomain, err)
 	}
 	
-	log.Printf("Certificate request initiated for %s", cleanDomain)
+	acmeLog.Info("Certificate requested", "domain", cleanDomain)
 	return nil
 }
 
-func (p *ProxyServer) ConfigureCertmagic(email string) error {
-	// Configure storage location
-	dataDir := "/root/.local/share/certmagic"
+// ConfigureCertmagic sets up ACME certificates for the given account email,
+// keeping certificates and ACME state under dataDir
+func (p *ProxyServer) ConfigureCertmagic(email, dataDir string) error {
+	p.dataDir = dataDir
 	
 	// Ensure directories exist
 	if err := os.MkdirAll(dataDir, 0700); err != nil {
@@ -402,7 +653,10 @@ func (p *ProxyServer) ConfigureCertmagic(email string) error {
 	}
 	
 	// Configure storage for certmagic
-	storage := &certmagic.FileStorage{Path: dataDir}
+	var storage certmagic.Storage = &certmagic.FileStorage{Path: dataDir}
+	if p.certStorage != nil {
+		storage = p.certStorage
+	}
 	certmagic.Default.Storage = storage
 	
 	// Set up the certmagic instance
@@ -422,17 +676,21 @@ func (p *ProxyServer) ConfigureCertmagic(email string) error {
 		Agreed:                  true,
 		DisableHTTPChallenge:    false,
 		DisableTLSALPNChallenge: true,
-		AltHTTPPort:             80, // Ensure we're using standard HTTP port
+		AltHTTPPort:             p.acmeHTTPPort,
+		ListenHost:              p.acmeListenHost,
 		Logger:                  certmagic.DefaultACME.Logger,
 	})
 	
 	// Set issuer for the config
 	certConfig.Issuers = []certmagic.Issuer{acmeIssuer}
+
+	// Forward certificate lifecycle events to webhooks
+	certConfig.OnEvent = p.handleCertEvent
 	
 	// Store the configured certmagic instance
 	p.certManager = certConfig
 	
-	log.Printf("Certmagic configured with email: %s, storage path: %s", email, dataDir)
+	acmeLog.Info("Certificate management configured", "email", email, "storage_dir", dataDir)
 	
 	// For testing/debugging purposes, uncomment to use staging environment
 	// certmagic.DefaultACME.CA = certmagic.LetsEncryptStagingCA
@@ -441,7 +699,7 @@ func (p *ProxyServer) ConfigureCertmagic(email string) error {
 }
 
 func (p *ProxyServer) Run(httpPort, httpsPort int) error {
-	log.Printf("Starting proxy server with HTTP port %d, HTTPS port %d, and TCP proxies", httpPort, httpsPort)
+	logger.Info("Starting proxy", "http_port", httpPort, "https_port", httpsPort)
 
 	// Start TCP proxy listeners for different protocols
 	// Important: Start this first, before HTTP/HTTPS
@@ -469,18 +727,37 @@ func (p *ProxyServer) Run(httpPort, httpsPort int) error {
 		IdleTimeout:  120 * time.Second,
 	}
 
+	p.addServer(httpServer)
+	p.addServer(httpsServer)
+	p.setListener("http", false)
+	p.setListener("https", false)
+
 	// Start the servers in goroutines
 	go func() {
-		log.Printf("Starting HTTP server on port %d", httpPort)
-		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Printf("HTTP server error: %v", err)
+		logger.Info("Starting HTTP listener", "port", httpPort)
+		ln, err := p.listen("http", httpServer.Addr)
+		if err != nil {
+			logger.Error("HTTP listener failed", "error", err)
+			return
+		}
+		p.setListener("http", true)
+		defer p.setListener("http", false)
+		if err := httpServer.Serve(ln); err != nil && err != http.ErrServerClosed {
+			logger.Error("HTTP listener failed", "error", err)
 		}
 	}()
 
 	go func() {
-		log.Printf("Starting HTTPS server on port %d", httpsPort)
-		if err := httpsServer.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
-			log.Printf("HTTPS server error: %v", err)
+		logger.Info("Starting HTTPS listener", "port", httpsPort)
+		ln, err := p.listen("https", httpsServer.Addr)
+		if err != nil {
+			logger.Error("HTTPS listener failed", "error", err)
+			return
+		}
+		p.setListener("https", true)
+		defer p.setListener("https", false)
+		if err := httpsServer.ServeTLS(ln, "", ""); err != nil && err != http.ErrServerClosed {
+			logger.Error("HTTPS listener failed", "error", err)
 		}
 	}()
 
@@ -488,20 +765,64 @@ func (p *ProxyServer) Run(httpPort, httpsPort int) error {
 	select {}
 }
 
+// ManageCertificate obtains and renews a certificate for a hostname that is
+// not a proxied domain, such as the admin API's. HTTP-01 challenges are
+// answered by the proxy's HTTP listener.
+func (p *ProxyServer) ManageCertificate(ctx context.Context, hostname string) error {
+	if p.certManager == nil {
+		return fmt.Errorf("certmagic is not configured")
+	}
+	return p.certManager.ManageAsync(ctx, []string{hostname})
+}
+
+// GetCertificate returns a certificate managed by certmagic for the TLS
+// handshake
+func (p *ProxyServer) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return p.certManager.GetCertificate(hello)
+}
+
+// setListener records whether the named listener is accepting connections
+func (p *ProxyServer) setListener(name string, up bool) {
+	p.listeners.Store(name, up)
+}
+
+// Listeners reports which proxy listeners are accepting connections
+func (p *ProxyServer) Listeners() map[string]bool {
+	status := make(map[string]bool)
+	p.listeners.Range(func(key, value interface{}) bool {
+		status[key.(string)] = value.(bool)
+		return true
+	})
+	return status
+}
+
+// DomainCount returns how many domains are being served; aliases are not
+// counted separately
+func (p *ProxyServer) DomainCount() int {
+	seen := make(map[*DomainConfig]bool)
+	p.domains.Range(func(_, value interface{}) bool {
+		seen[value.(*DomainConfig)] = true
+		return true
+	})
+	return len(seen)
+}
+
+// SetTCPPorts sets the TCP proxy listener port for each protocol. It must
+// be called before Run.
+func (p *ProxyServer) SetTCPPorts(ports map[string]int) {
+	p.tcpPorts = ports
+}
+
 // startTCPProxies starts TCP proxy listeners for configured protocols
 func (p *ProxyServer) startTCPProxies() {
-	// Default TCP ports for various protocols
-	protocolPorts := map[string]int{
-		"minecraft": 25565,
-		// Add other protocol-specific ports as needed
-	}
+	protocolPorts := p.tcpPorts
 	
-	log.Printf("Starting TCP proxies for protocols: %v", protocolPorts)
+	tcpLog.Info("Starting TCP proxies", "ports", protocolPorts)
 	
 	// Start a listener for each protocol
 	for protocol, port := range protocolPorts {
 		go func(proto string, portNum int) {
-			log.Printf("Starting TCP proxy for %s on port %d in goroutine", proto, portNum)
+			tcpLog.Debug("Starting TCP proxy", "protocol", proto, "port", portNum)
 			p.startTCPProxy(proto, portNum)
 		}(protocol, port)
 	}
@@ -509,25 +830,29 @@ func (p *ProxyServer) startTCPProxies() {
 
 // startTCPProxy starts a TCP proxy listener on the specified port for a specific protocol
 func (p *ProxyServer) startTCPProxy(protocol string, port int) {
-	addr := fmt.Sprintf("0.0.0.0:%d", port)
-	log.Printf("Setting up TCP proxy listener for %s on %s", protocol, addr)
+	addr := fmt.Sprintf(":%d", port)
+	tcpLog.Debug("Opening TCP listener", "protocol", protocol, "addr", addr)
 	
-	listener, err := net.Listen("tcp", addr)
+	name := "tcp/" + protocol
+	p.setListener(name, false)
+
+	listener, err := p.listen(name, addr)
 	if err != nil {
-		log.Printf("TCP proxy listen error for %s on port %d: %v", protocol, port, err)
+		tcpLog.Error("TCP listener failed", "protocol", protocol, "port", port, "error", err)
 		return
 	}
+	p.setListener(name, true)
 	
-	log.Printf("Successfully started TCP proxy for %s on port %d", protocol, port)
+	tcpLog.Info("TCP proxy listening", "protocol", protocol, "port", port)
 	
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			log.Printf("TCP accept error on port %d: %v", port, err)
+			tcpLog.Warn("TCP accept failed", "port", port, "error", err)
 			continue
 		}
 		
-		log.Printf("Accepted new TCP connection on port %d from %s", port, conn.RemoteAddr().String())
+		tcpLog.Debug("Accepted TCP connection", "port", port, "client", conn.RemoteAddr().String())
 		go p.handleTCPConnection(conn, protocol)
 	}
 }
@@ -535,10 +860,16 @@ func (p *ProxyServer) startTCPProxy(protocol string, port int) {
 // handleTCPConnection handles a TCP connection by determining the target and proxying data
 func (p *ProxyServer) handleTCPConnection(clientConn net.Conn, protocol string) {
 	defer clientConn.Close()
+	p.inFlight.Add(1)
+	defer p.inFlight.Add(-1)
 	
 	// Get client address
 	clientAddr := clientConn.RemoteAddr().String()
-	log.Printf("New %s TCP connection from %s", protocol, clientAddr)
+	tcpLog.Debug("New TCP connection", "protocol", protocol, "client", clientAddr)
+	if p.blockedByFeed(clientAddr) {
+		tcpLog.Debug("Closing TCP connection from blocklisted address", "protocol", protocol, "client", clientAddr)
+		return
+	}
 	
 	// Log all available domains for debugging
 	var availableDomains []string
@@ -547,7 +878,7 @@ func (p *ProxyServer) handleTCPConnection(clientConn net.Conn, protocol string)
 		availableDomains = append(availableDomains, domain)
 		return true
 	})
-	log.Printf("Available domains: %v", availableDomains)
+	tcpLog.Debug("Looking for a TCP backend", "domains", availableDomains)
 	
 	// Find the first domain with TCP backends for this protocol
 	var domain string
@@ -556,17 +887,19 @@ func (p *ProxyServer) handleTCPConnection(clientConn net.Conn, protocol string)
 	p.domains.Range(func(key, value interface{}) bool {
 		domainName := key.(string)
 		config := value.(*DomainConfig)
+		if !config.Enabled {
+			return true
+		}
 		
-		log.Printf("Checking domain %s for TCP backends", domainName)
+		tcpLog.Debug("Checking domain for TCP backends", "domain", domainName)
 		
 		// Check if this domain has any TCP backends
 		hasTcpBackend := false
 		for _, backend := range config.Backends {
 			if backend.Scheme == "tcp" {
 				hasTcpBackend = true
-				log.Printf("Domain %s has TCP backend: %s:%d (active: %v, health: %v)", 
-					domainName, backend.IP, backend.Port, backend.IsActive, 
-					backend.HealthStatus)
+				tcpLog.Debug("Found TCP backend", "domain", domainName, "backend", backend.IP.String(),
+					"port", backend.Port, "active", backend.IsActive, "health", backend.HealthStatus)
 				
 				if backend.IsActive && (backend.HealthStatus == nil || *backend.HealthStatus == "healthy") {
 					domain = domainName
@@ -577,43 +910,43 @@ func (p *ProxyServer) handleTCPConnection(clientConn net.Conn, protocol string)
 		}
 		
 		if !hasTcpBackend {
-			log.Printf("Domain %s has no TCP backends", domainName)
+			tcpLog.Debug("Domain has no TCP backends", "domain", domainName)
 		}
 		
 		return true // Continue iterating
 	})
 	
 	if domain == "" || tcpConfig == nil {
-		log.Printf("No domain with active TCP backends found for %s", protocol)
+		tcpLog.Warn("No domain with active TCP backends", "protocol", protocol)
 		return
 	}
 	
-	log.Printf("Using domain %s for %s TCP connection", domain, protocol)
+	tcpLog.Debug("Routing TCP connection", "domain", domain, "protocol", protocol)
 	
 	// Select backend using round-robin
 	backend := p.selectBackend(tcpConfig)
 	if backend == nil {
-		log.Printf("No healthy TCP backends available for %s on %s", domain, protocol)
+		tcpLog.Warn("No healthy TCP backend", "domain", domain, "protocol", protocol)
 		return
 	}
 	
 	// Only proxy to TCP backends
 	if backend.Scheme != "tcp" {
-		log.Printf("Backend for %s is not TCP", domain)
+		tcpLog.Warn("Selected backend is not TCP", "domain", domain)
 		return
 	}
 	
 	// Connect to backend
-	backendAddr := fmt.Sprintf("%s:%d", backend.IP.String(), backend.Port)
-	log.Printf("Connecting to backend %s", backendAddr)
+	backendAddr := net.JoinHostPort(backend.IP.String(), strconv.Itoa(backend.Port))
+	tcpLog.Debug("Connecting to backend", "backend", backendAddr)
 	backendConn, err := net.Dial("tcp", backendAddr)
 	if err != nil {
-		log.Printf("TCP backend connection error: %v", err)
+		tcpLog.Warn("Connecting to TCP backend failed", "backend", backendAddr, "error", err)
 		return
 	}
 	defer backendConn.Close()
 	
-	log.Printf("Established %s connection to backend at %s", protocol, backendAddr)
+	tcpLog.Debug("Connected to backend", "protocol", protocol, "backend", backendAddr)
 	
 	// Start proxying data in both directions
 	start := time.Now()
@@ -625,6 +958,10 @@ func (p *ProxyServer) handleTCPConnection(clientConn net.Conn, protocol string)
 	// Create a WaitGroup to wait for both goroutines to finish
 	var wg sync.WaitGroup
 	wg.Add(2)
+
+	// Bytes sent each way, for usage metering; each is written by one
+	// goroutine and read after wg.Wait
+	var bytesIn, bytesOut int64
 	
 	// Client to backend
 	go func() {
@@ -641,15 +978,16 @@ func (p *ProxyServer) handleTCPConnection(clientConn net.Conn, protocol string)
 				n, err := clientConn.Read(buf)
 				if err != nil {
 					if err != io.EOF {
-						log.Printf("TCP client read error: %v", err)
+						tcpLog.Debug("TCP client read failed", "error", err)
 					}
 					return
 				}
 				
 				backendConn.SetWriteDeadline(time.Now().Add(10 * time.Second))
 				_, err = backendConn.Write(buf[:n])
+				bytesIn += int64(n)
 				if err != nil {
-					log.Printf("TCP backend write error: %v", err)
+					tcpLog.Debug("TCP backend write failed", "error", err)
 					return
 				}
 			}
@@ -671,15 +1009,16 @@ func (p *ProxyServer) handleTCPConnection(clientConn net.Conn, protocol string)
 				n, err := backendConn.Read(buf)
 				if err != nil {
 					if err != io.EOF {
-						log.Printf("TCP backend read error: %v", err)
+						tcpLog.Debug("TCP backend read failed", "error", err)
 					}
 					return
 				}
 				
 				clientConn.SetWriteDeadline(time.Now().Add(10 * time.Second))
 				_, err = clientConn.Write(buf[:n])
+				bytesOut += int64(n)
 				if err != nil {
-					log.Printf("TCP client write error: %v", err)
+					tcpLog.Debug("TCP client write failed", "error", err)
 					return
 				}
 			}
@@ -692,32 +1031,95 @@ func (p *ProxyServer) handleTCPConnection(clientConn net.Conn, protocol string)
 	// Record metrics
 	duration := time.Since(start)
 	p.metrics.RecordTCPRequest(domain, duration)
+	p.metrics.RecordBytes(domain, bytesIn, bytesOut)
 	
-	log.Printf("TCP connection closed: %s -> %s, duration: %v", clientAddr, backendAddr, duration)
+	tcpLog.Debug("TCP connection closed", "client", clientAddr, "backend", backendAddr, "duration", duration)
+}
+
+// RateLimiter tracks rate limits in a store shared by several nodes, so a
+// load-balanced group of proxies enforces each limit once rather than per
+// node. Keys are "domain" or "domain|client IP".
+type RateLimiter interface {
+	Allow(ctx context.Context, key string, limit *RateLimit) (bool, error)
+}
+
+// SetRateLimiter makes rate limits shared through l. Limits fall back to the
+// per-node limiters while l returns errors.
+func (p *ProxyServer) SetRateLimiter(l RateLimiter) {
+	p.limiter = l
+}
+
+// SetTraceStart makes the proxy start a sampled W3C trace for requests
+// that arrive without a traceparent header. Traces that arrive are always
+// continued.
+func (p *ProxyServer) SetTraceStart(start bool) {
+	p.startTraces = start
+}
+
+// SetCertStorage keeps certificates in shared storage rather than the local
+// data directory. It must be called before ConfigureCertmagic.
+func (p *ProxyServer) SetCertStorage(s certmagic.Storage) {
+	p.certStorage = s
+}
+
+// SetACMEChallenge sets where HTTP-01 challenges are answered: port is the
+// local port public port 80 reaches, and listenHost the address to bind
+// when nothing else listens on it. With port set to the HTTP port, the
+// proxy's HTTP listener answers the challenges. Call it before
+// ConfigureCertmagic.
+func (p *ProxyServer) SetACMEChallenge(listenHost string, port int) {
+	p.acmeListenHost = listenHost
+	p.acmeHTTPPort = port
+}
+
+// SetWebhooks makes the proxy emit certificate events as webhooks
+func (p *ProxyServer) SetWebhooks(d *webhooks.Dispatcher) {
+	p.webhooks = d
+}
+
+// handleCertEvent maps certmagic events onto webhook events
+func (p *ProxyServer) handleCertEvent(ctx context.Context, event string, data map[string]any) error {
+	switch event {
+	case "cert_obtained":
+		p.webhooks.Emit(webhooks.EventCertificateIssued, data)
+		identifier, _ := data["identifier"].(string)
+		p.publishCertIssued(identifier)
+	case "cert_failed":
+		p.webhooks.Emit(webhooks.EventCertificateFailed, data)
+	}
+	return nil
 }
 
 func (p *ProxyServer) Metrics() *MetricsCollector {
 	return p.metrics
 }
 
+// servePausedPage answers requests for a disabled domain
+func servePausedPage(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Retry-After", "3600")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	io.WriteString(w, `<!DOCTYPE html>
+<html><head><title>Site unavailable</title></head>
+<body><h1>Site temporarily unavailable</h1><p>This site has been paused by its operator.</p></body></html>
+`)
+}
+
 // httpHandler handles HTTP requests, primarily for redirecting to HTTPS
 func (p *ProxyServer) httpHandler(w http.ResponseWriter, r *http.Request) {
 	// First and most important, check for ACME challenges
 	if p.handleACMEChallenge(w, r) {
-		log.Printf("Served ACME challenge for %s", r.Host)
+		acmeLog.Debug("Served challenge", "host", r.Host)
 		return
 	}
 
-	// Get the host from the request
-	host := r.Host
-	if h, _, err := net.SplitHostPort(host); err == nil {
-		host = h
-	}
+	// Domains are stored under their host key, as in ServeHTTP
+	host := HostKey(r.Host)
 	
 	// Check if this domain is configured
 	configVal, ok := p.domains.Load(host)
 	if !ok {
-		log.Printf("Domain not found: %s", host)
+		logger.Debug("Domain not found", "host", host)
 		http.Error(w, "Domain not found", http.StatusNotFound)
 		return
 	}
@@ -0,0 +1,239 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxBufferedRewrite is the largest body rewritten as a whole, keeping an
+// exact Content-Length; larger and chunked bodies are rewritten as they
+// stream, without one
+const maxBufferedRewrite = 1 << 20
+
+// BodyRewrite replaces absolute backend URLs in HTML and JSON responses
+// with the domain's public URL, for applications unaware of their public
+// hostname. The backends' own URLs are always replaced; ExtraURLs adds
+// others, e.g. "http://localhost:3000".
+type BodyRewrite struct {
+	ExtraURLs []string
+	pairs     []rewritePair // built by prepare, longest first
+	first     [256]bool     // first bytes of the patterns
+}
+
+type rewritePair struct {
+	old, new []byte
+}
+
+func (b *BodyRewrite) equal(o *BodyRewrite) bool {
+	if b == nil || o == nil {
+		return b == o
+	}
+	if len(b.ExtraURLs) != len(o.ExtraURLs) {
+		return false
+	}
+	for i := range b.ExtraURLs {
+		if b.ExtraURLs[i] != o.ExtraURLs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// prepare builds the replacements for a domain from its backends and
+// public scheme
+func (b *BodyRewrite) prepare(config *DomainConfig) {
+	public := "http://" + config.Domain
+	if config.SSLEnabled {
+		public = "https://" + config.Domain
+	}
+
+	olds := make(map[string]bool)
+	for _, u := range b.ExtraURLs {
+		olds[strings.TrimRight(u, "/")] = true
+	}
+	for _, backend := range config.Backends {
+		host := backend.IP.String()
+		if backend.IP.To4() == nil {
+			host = "[" + host + "]"
+		}
+		olds[backend.Scheme+"://"+host+":"+strconv.Itoa(backend.Port)] = true
+		if (backend.Scheme == "http" && backend.Port == 80) || (backend.Scheme == "https" && backend.Port == 443) {
+			olds[backend.Scheme+"://"+host] = true
+		}
+	}
+	delete(olds, public)
+
+	b.pairs = b.pairs[:0]
+	for old := range olds {
+		if old == "" {
+			continue
+		}
+		b.pairs = append(b.pairs, rewritePair{[]byte(old), []byte(public)})
+		// JSON encoders may escape the slashes
+		escaped := strings.ReplaceAll(old, "/", `\/`)
+		b.pairs = append(b.pairs, rewritePair{[]byte(escaped), []byte(strings.ReplaceAll(public, "/", `\/`))})
+	}
+	sort.Slice(b.pairs, func(i, j int) bool { return len(b.pairs[i].old) > len(b.pairs[j].old) })
+	b.first = [256]bool{}
+	for _, p := range b.pairs {
+		b.first[p.old[0]] = true
+	}
+}
+
+// isHostByte reports whether c can continue a host or port, in which case a
+// pattern ending before it is part of a different URL
+func isHostByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '.' || c == '-' || c == ':'
+}
+
+// rewrite appends src to dst with the URLs replaced. Unless final, a
+// possible match cut off at the end of src is not written but returned, to
+// be rewritten with the data that follows.
+func (b *BodyRewrite) rewrite(dst, src []byte, final bool) (out, rest []byte) {
+	start := 0
+	i := 0
+scan:
+	for i < len(src) {
+		if !b.first[src[i]] {
+			i++
+			continue
+		}
+		// Longest first, so a longer URL is preferred over its prefix
+		for _, p := range b.pairs {
+			end := i + len(p.old)
+			if end > len(src) {
+				if !final && bytes.HasPrefix(p.old, src[i:]) {
+					break scan
+				}
+				continue
+			}
+			if !bytes.Equal(src[i:end], p.old) {
+				continue
+			}
+			if end == len(src) && !final {
+				// The next byte decides whether the URL ends here
+				break scan
+			}
+			if end < len(src) && isHostByte(src[end]) {
+				continue
+			}
+			dst = append(dst, src[start:i]...)
+			dst = append(dst, p.new...)
+			i, start = end, end
+			continue scan
+		}
+		i++
+	}
+	if i < len(src) {
+		return append(dst, src[start:i]...), src[i:]
+	}
+	return append(dst, src[start:]...), nil
+}
+
+// rewriteBody is a response body with the URLs replaced as it is read
+type rewriteBody struct {
+	src  io.ReadCloser
+	rw   *BodyRewrite
+	buf  []byte
+	in   []byte // read from src, not rewritten yet
+	out  []byte // rewritten, not returned yet
+	done bool
+	err  error // returned once out is drained, io.EOF at the end
+}
+
+func (r *rewriteBody) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, r.err
+		}
+		if r.buf == nil {
+			r.buf = make([]byte, 32*1024)
+		}
+		n, err := r.src.Read(r.buf)
+		r.in = append(r.in, r.buf[:n]...)
+		if err != nil {
+			r.done, r.err = true, err
+		}
+		var rest []byte
+		r.out, rest = r.rw.rewrite(r.out[:0], r.in, r.done)
+		r.in = append(r.in[:0], rest...)
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+func (r *rewriteBody) Close() error {
+	return r.src.Close()
+}
+
+// rewritable reports whether a response's body is HTML or JSON and not
+// compressed
+func rewritable(resp *http.Response) bool {
+	if enc := resp.Header.Get("Content-Encoding"); enc != "" && enc != "identity" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	switch {
+	case mediaType == "text/html", mediaType == "application/xhtml+xml",
+		mediaType == "application/json", strings.HasSuffix(mediaType, "+json"):
+		return true
+	}
+	return false
+}
+
+// rewriteResponse applies the domain's body rewrite to a response, and to
+// its redirect location
+func rewriteResponse(resp *http.Response, config *DomainConfig) error {
+	rw := config.Rewrite
+	if rw == nil || len(rw.pairs) == 0 {
+		return nil
+	}
+	for _, name := range []string{"Location", "Content-Location"} {
+		if v := resp.Header.Get(name); v != "" {
+			out, _ := rw.rewrite(nil, []byte(v), true)
+			resp.Header.Set(name, string(out))
+		}
+	}
+	if !rewritable(resp) {
+		return nil
+	}
+	// The body no longer matches the backend's byte for byte
+	if etag := resp.Header.Get("ETag"); strings.HasPrefix(etag, `"`) {
+		resp.Header.Set("ETag", "W/"+etag)
+	}
+
+	if resp.Request.Method == http.MethodHead {
+		// The length of the rewritten body is not known without it
+		resp.Header.Del("Content-Length")
+		return nil
+	}
+
+	if resp.ContentLength >= 0 && resp.ContentLength <= maxBufferedRewrite {
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		out, _ := rw.rewrite(make([]byte, 0, len(body)), body, true)
+		resp.Body = io.NopCloser(bytes.NewReader(out))
+		resp.ContentLength = int64(len(out))
+		resp.Header.Set("Content-Length", strconv.Itoa(len(out)))
+		return nil
+	}
+
+	// The client receives it chunked
+	resp.Body = &rewriteBody{src: resp.Body, rw: rw}
+	resp.ContentLength = -1
+	resp.Header.Del("Content-Length")
+	return nil
+}
@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// testRewrite returns the rewrite of a domain served over HTTPS from two
+// backends, one on the scheme's default port
+func testRewrite(extra ...string) *BodyRewrite {
+	rw := &BodyRewrite{ExtraURLs: extra}
+	rw.prepare(&DomainConfig{
+		Domain:     "example.com",
+		SSLEnabled: true,
+		Backends: []*BackendServer{
+			{Scheme: "http", IP: net.ParseIP("10.0.0.1"), Port: 8080},
+			{Scheme: "http", IP: net.ParseIP("fd00::1"), Port: 80},
+		},
+	})
+	return rw
+}
+
+func TestRewrite(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"backend URL", `<a href="http://10.0.0.1:8080/login">`, `<a href="https://example.com/login">`},
+		{"default port omitted", `see http://[fd00::1]/docs`, `see https://example.com/docs`},
+		{"default port given", `http://[fd00::1]:80/`, `https://example.com/`},
+		{"escaped JSON", `{"next":"http:\/\/10.0.0.1:8080\/page"}`, `{"next":"https:\/\/example.com\/page"}`},
+		{"extra URL", `http://localhost:3000/api`, `https://example.com/api`},
+		{"extra URL trailing slash", `http://internal.local/x`, `https://example.com/x`},
+		{"other port", `http://10.0.0.1:80801/`, `http://10.0.0.1:80801/`},
+		{"longer host", `http://10.0.0.1:8080.evil.com/`, `http://10.0.0.1:8080.evil.com/`},
+		{"at the end", `http://10.0.0.1:8080`, `https://example.com`},
+		{"no match", `nothing to see here`, `nothing to see here`},
+	}
+	rw := testRewrite("http://localhost:3000", "http://internal.local/")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, rest := rw.rewrite(nil, []byte(tt.in), true)
+			if string(out) != tt.want || rest != nil {
+				t.Errorf("rewrite(%q) = %q, %q, want %q", tt.in, out, rest, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewritePublicURLUnchanged(t *testing.T) {
+	rw := testRewrite("https://example.com")
+	in := "https://example.com/ and http://10.0.0.1:8080/"
+	if out, _ := rw.rewrite(nil, []byte(in), true); string(out) != "https://example.com/ and https://example.com/" {
+		t.Errorf("rewrite = %q", out)
+	}
+}
+
+func TestRewriteBodyStreaming(t *testing.T) {
+	in := strings.Repeat(`<a href="http://10.0.0.1:8080/p">x</a> `, 50) + "http://10.0.0.1:8080"
+	want := strings.Repeat(`<a href="https://example.com/p">x</a> `, 50) + "https://example.com"
+
+	// Reading a byte at a time splits every URL across reads
+	body := &rewriteBody{src: io.NopCloser(iotest.OneByteReader(strings.NewReader(in))), rw: testRewrite()}
+	out, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != want {
+		t.Errorf("streamed rewrite = %q, want %q", out, want)
+	}
+}
+
+func TestRewritable(t *testing.T) {
+	tests := []struct {
+		contentType string
+		encoding    string
+		want        bool
+	}{
+		{"text/html; charset=utf-8", "", true},
+		{"application/json", "identity", true},
+		{"application/problem+json", "", true},
+		{"application/xhtml+xml", "", true},
+		{"text/html", "gzip", false},
+		{"image/png", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{Header: http.Header{}}
+		resp.Header.Set("Content-Type", tt.contentType)
+		resp.Header.Set("Content-Encoding", tt.encoding)
+		if got := rewritable(resp); got != tt.want {
+			t.Errorf("rewritable(%q, %q) = %v, want %v", tt.contentType, tt.encoding, got, tt.want)
+		}
+	}
+}
+
+func TestRewriteResponse(t *testing.T) {
+	config := &DomainConfig{Rewrite: testRewrite()}
+	body := `{"url":"http://10.0.0.1:8080/a"}`
+	resp := &http.Response{
+		Header:        http.Header{},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       &http.Request{Method: http.MethodGet},
+	}
+	resp.Header.Set("Content-Type", "application/json")
+	resp.Header.Set("Location", "http://10.0.0.1:8080/next")
+	resp.Header.Set("ETag", `"abc"`)
+
+	if err := rewriteResponse(resp, config); err != nil {
+		t.Fatal(err)
+	}
+	out, _ := io.ReadAll(resp.Body)
+	if want := `{"url":"https://example.com/a"}`; string(out) != want {
+		t.Errorf("body = %q, want %q", out, want)
+	}
+	if resp.ContentLength != int64(len(out)) || resp.Header.Get("Content-Length") != "31" {
+		t.Errorf("length = %d, header %q, want %d", resp.ContentLength, resp.Header.Get("Content-Length"), len(out))
+	}
+	if got := resp.Header.Get("Location"); got != "https://example.com/next" {
+		t.Errorf("Location = %q", got)
+	}
+	if got := resp.Header.Get("ETag"); got != `W/"abc"` {
+		t.Errorf("ETag = %q, want a weak ETag", got)
+	}
+}
@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"time"
+)
+
+// serverTiming records where a proxied request spent its time, for the
+// Server-Timing header
+type serverTiming struct {
+	start     time.Time // the request arrived
+	getConn   time.Time
+	gotConn   time.Time
+	reused    bool
+	firstByte time.Time
+}
+
+// trace returns ctx with hooks filling in the timing of the backend request
+func (t *serverTiming) trace(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn: func(string) { t.getConn = time.Now() },
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.gotConn = time.Now()
+			t.reused = info.Reused
+		},
+		GotFirstResponseByte: func() { t.firstByte = time.Now() },
+	})
+}
+
+func timingMs(d time.Duration) string {
+	return fmt.Sprintf("%.1f", float64(d.Microseconds())/1000)
+}
+
+// header formats the timings: the time to get a backend connection,
+// including dialing and the TLS handshake unless it was reused; the
+// backend's time to first byte; and the total time in the proxy until the
+// response headers
+func (t *serverTiming) header() string {
+	var metrics []string
+	if !t.gotConn.IsZero() {
+		desc := "backend dial"
+		if t.reused {
+			desc = "backend connection reused"
+		}
+		metrics = append(metrics, `dial;dur=`+timingMs(t.gotConn.Sub(t.getConn))+`;desc="`+desc+`"`)
+		if !t.firstByte.IsZero() {
+			metrics = append(metrics, `ttfb;dur=`+timingMs(t.firstByte.Sub(t.gotConn))+`;desc="backend time to first byte"`)
+		}
+	}
+	metrics = append(metrics, `total;dur=`+timingMs(time.Since(t.start))+`;desc="proxy total"`)
+	return strings.Join(metrics, ", ")
+}
+
+// addServerTiming adds the proxy's timings to a response, next to any the
+// backend reports
+func addServerTiming(resp *http.Response, t *serverTiming) {
+	if t != nil {
+		resp.Header.Add("Server-Timing", t.header())
+	}
+}
@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestServerTimingHeader(t *testing.T) {
+	base := time.Now()
+	tests := []struct {
+		name   string
+		timing serverTiming
+		want   string
+	}{
+		{
+			"dialed",
+			serverTiming{start: base, getConn: base, gotConn: base.Add(2500 * time.Microsecond), firstByte: base.Add(12500 * time.Microsecond)},
+			`^dial;dur=2\.5;desc="backend dial", ttfb;dur=10\.0;desc="backend time to first byte", total;dur=[0-9.]+;desc="proxy total"$`,
+		},
+		{
+			"reused",
+			serverTiming{start: base, getConn: base, gotConn: base, reused: true},
+			`^dial;dur=0\.0;desc="backend connection reused", total;dur=[0-9.]+;desc="proxy total"$`,
+		},
+		{
+			"no backend connection",
+			serverTiming{start: base},
+			`^total;dur=[0-9.]+;desc="proxy total"$`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.timing.header(); !regexp.MustCompile(tt.want).MatchString(got) {
+				t.Errorf("header() = %q, want a match for %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServerTimingTrace(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	timing := &serverTiming{start: time.Now()}
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	resp, err := http.DefaultClient.Do(req.WithContext(timing.trace(req.Context())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if timing.getConn.IsZero() || timing.gotConn.IsZero() || timing.firstByte.IsZero() {
+		t.Errorf("timing = %+v, want every hook to have run", timing)
+	}
+}
+
+func TestAddServerTiming(t *testing.T) {
+	resp := &http.Response{Header: http.Header{}}
+	resp.Header.Set("Server-Timing", "db;dur=4")
+	addServerTiming(resp, nil)
+	if got := resp.Header.Values("Server-Timing"); len(got) != 1 {
+		t.Fatalf("Server-Timing = %q, want only the backend's when disabled", got)
+	}
+
+	addServerTiming(resp, &serverTiming{start: time.Now()})
+	if got := resp.Header.Values("Server-Timing"); len(got) != 2 || got[0] != "db;dur=4" {
+		t.Errorf("Server-Timing = %q, want the backend's followed by the proxy's", got)
+	}
+}
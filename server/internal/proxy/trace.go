@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"viacortex/internal/logging"
+)
+
+var traceLog = logging.For("trace")
+
+// span is the proxy's hop in a W3C Trace Context trace. Spans are not
+// exported; they are logged at debug level on the "trace" module, and their
+// IDs are passed to the backend so the backend's spans attach to the proxy
+// hop instead of the trace breaking there.
+type span struct {
+	traceID  string
+	parentID string // empty when the proxy started the trace
+	spanID   string
+	flags    string
+	start    time.Time
+}
+
+// startSpan continues the trace of an incoming traceparent header. Without
+// a valid one a new trace is started if p.startTraces is set; otherwise nil
+// is returned and the request is forwarded without trace headers.
+func (p *ProxyServer) startSpan(r *http.Request) *span {
+	s := &span{spanID: randomHex(8), start: time.Now()}
+	if traceID, parentID, flags, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+		s.traceID, s.parentID, s.flags = traceID, parentID, flags
+		return s
+	}
+	if !p.startTraces {
+		return nil
+	}
+	s.traceID, s.flags = randomHex(16), "01"
+	return s
+}
+
+// traceparent is the header passing this span on as the backend's parent
+func (s *span) traceparent() string {
+	return "00-" + s.traceID + "-" + s.spanID + "-" + s.flags
+}
+
+// end logs the finished span
+func (s *span) end(r *http.Request, domain string, status int) {
+	traceLog.Debug("Proxy span",
+		"trace_id", s.traceID,
+		"span_id", s.spanID,
+		"parent_span_id", s.parentID,
+		"sampled", s.flags == "01",
+		"domain", domain,
+		"method", r.Method,
+		"path", r.URL.Path,
+		"status", status,
+		"duration", time.Since(s.start),
+	)
+}
+
+// setTraceHeaders rewrites the trace headers of an outgoing request. A
+// header that does not parse is dropped together with tracestate, as the
+// spec requires; baggage is passed through unchanged.
+func setTraceHeaders(req *http.Request, s *span) {
+	if s == nil {
+		if _, _, _, ok := parseTraceparent(req.Header.Get("traceparent")); !ok {
+			req.Header.Del("traceparent")
+			req.Header.Del("tracestate")
+		}
+		return
+	}
+	if s.parentID == "" {
+		// A trace started here has no vendor state to carry on
+		req.Header.Del("tracestate")
+	}
+	req.Header.Set("traceparent", s.traceparent())
+}
+
+// parseTraceparent parses a W3C traceparent header, e.g.
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01. Only the
+// fields shared by all versions are used, so future versions are accepted.
+func parseTraceparent(h string) (traceID, parentID, flags string, ok bool) {
+	h = strings.TrimSpace(h)
+	if len(h) < 55 || (len(h) > 55 && h[55] != '-') {
+		return "", "", "", false
+	}
+	version, traceID, parentID, flags := h[0:2], h[3:35], h[36:52], h[53:55]
+	if h[2] != '-' || h[35] != '-' || h[52] != '-' {
+		return "", "", "", false
+	}
+	if !isLowerHex(version) || version == "ff" || (version == "00" && len(h) != 55) {
+		return "", "", "", false
+	}
+	if !isLowerHex(traceID) || traceID == strings.Repeat("0", 32) ||
+		!isLowerHex(parentID) || parentID == strings.Repeat("0", 16) || !isLowerHex(flags) {
+		return "", "", "", false
+	}
+	// Of the flags only "sampled" is defined; pass on just that bit
+	if b, _ := hex.DecodeString(flags); b[0]&1 == 1 {
+		flags = "01"
+	} else {
+		flags = "00"
+	}
+	return traceID, parentID, flags, true
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const (
+	testTraceID  = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testParentID = "00f067aa0ba902b7"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    string
+		wantFlags string
+		wantOK    bool
+	}{
+		{"sampled", "00-" + testTraceID + "-" + testParentID + "-01", "01", true},
+		{"not sampled", "00-" + testTraceID + "-" + testParentID + "-00", "00", true},
+		{"unknown flags keep only sampled", "00-" + testTraceID + "-" + testParentID + "-0b", "01", true},
+		{"surrounding space", " 00-" + testTraceID + "-" + testParentID + "-01 ", "01", true},
+		{"future version with extra fields", "cc-" + testTraceID + "-" + testParentID + "-01-what-the-future-holds", "01", true},
+		{"empty", "", "", false},
+		{"version 00 with extra fields", "00-" + testTraceID + "-" + testParentID + "-01-extra", "", false},
+		{"invalid version ff", "ff-" + testTraceID + "-" + testParentID + "-01", "", false},
+		{"upper case", "00-" + strings.ToUpper(testTraceID) + "-" + testParentID + "-01", "", false},
+		{"zero trace ID", "00-" + strings.Repeat("0", 32) + "-" + testParentID + "-01", "", false},
+		{"zero parent ID", "00-" + testTraceID + "-" + strings.Repeat("0", 16) + "-01", "", false},
+		{"short trace ID", "00-" + testTraceID[:30] + "-" + testParentID + "-01", "", false},
+		{"wrong separator", "00_" + testTraceID + "-" + testParentID + "-01", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			traceID, parentID, flags, ok := parseTraceparent(tt.header)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if traceID != testTraceID || parentID != testParentID || flags != tt.wantFlags {
+				t.Errorf("got %s %s %s, want %s %s %s", traceID, parentID, flags, testTraceID, testParentID, tt.wantFlags)
+			}
+		})
+	}
+}
+
+func TestStartSpan(t *testing.T) {
+	valid := "00-" + testTraceID + "-" + testParentID + "-01"
+	tests := []struct {
+		name        string
+		traceparent string
+		startTraces bool
+		wantSpan    bool
+		wantTraceID string
+		wantParent  string
+	}{
+		{"continues incoming trace", valid, false, true, testTraceID, testParentID},
+		{"no trace and not starting", "", false, false, "", ""},
+		{"invalid trace and not starting", "garbage", false, false, "", ""},
+		{"starts a new trace", "", true, true, "", ""},
+		{"invalid trace is replaced", "garbage", true, true, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &ProxyServer{startTraces: tt.startTraces}
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.traceparent != "" {
+				r.Header.Set("traceparent", tt.traceparent)
+			}
+			s := p.startSpan(r)
+			if (s != nil) != tt.wantSpan {
+				t.Fatalf("span = %v, want span %v", s, tt.wantSpan)
+			}
+			if s == nil {
+				return
+			}
+			if tt.wantTraceID != "" && s.traceID != tt.wantTraceID {
+				t.Errorf("trace ID = %s, want %s", s.traceID, tt.wantTraceID)
+			}
+			if s.parentID != tt.wantParent {
+				t.Errorf("parent ID = %q, want %q", s.parentID, tt.wantParent)
+			}
+			if len(s.spanID) != 16 || s.spanID == testParentID {
+				t.Errorf("span ID = %q, want a new 16 digit ID", s.spanID)
+			}
+			// The outgoing header must itself parse
+			traceID, parentID, _, ok := parseTraceparent(s.traceparent())
+			if !ok || traceID != s.traceID || parentID != s.spanID {
+				t.Errorf("traceparent %q does not carry the span", s.traceparent())
+			}
+		})
+	}
+}
+
+func TestSetTraceHeaders(t *testing.T) {
+	valid := "00-" + testTraceID + "-" + testParentID + "-01"
+	continued := &span{traceID: testTraceID, parentID: testParentID, spanID: "b7ad6b7169203331", flags: "01"}
+	started := &span{traceID: testTraceID, spanID: "b7ad6b7169203331", flags: "01"}
+
+	tests := []struct {
+		name            string
+		traceparent     string
+		span            *span
+		wantTraceparent string
+		wantTracestate  string
+	}{
+		{"continued span replaces parent", valid, continued, "00-" + testTraceID + "-b7ad6b7169203331-01", "vendor=1"},
+		{"started span drops tracestate", "", started, "00-" + testTraceID + "-b7ad6b7169203331-01", ""},
+		{"no span passes valid header on", valid, nil, valid, "vendor=1"},
+		{"no span drops invalid header", "garbage", nil, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.traceparent != "" {
+				req.Header.Set("traceparent", tt.traceparent)
+			}
+			req.Header.Set("tracestate", "vendor=1")
+			req.Header.Set("baggage", "user=42")
+
+			setTraceHeaders(req, tt.span)
+
+			if got := req.Header.Get("traceparent"); got != tt.wantTraceparent {
+				t.Errorf("traceparent = %q, want %q", got, tt.wantTraceparent)
+			}
+			if got := req.Header.Get("tracestate"); got != tt.wantTracestate {
+				t.Errorf("tracestate = %q, want %q", got, tt.wantTracestate)
+			}
+			if got := req.Header.Get("baggage"); got != "user=42" {
+				t.Errorf("baggage = %q, want it unchanged", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Transport tunes the connection pool a domain uses to reach its backends.
+// Zero values keep the defaults.
+type Transport struct {
+	MaxIdleConnsPerHost   int
+	MaxConnsPerHost       int
+	IdleConnTimeout       time.Duration
+	ResponseHeaderTimeout time.Duration
+	DisableHTTP2          bool
+}
+
+// defaultIdleConnTimeout applies when a domain does not set its own
+const defaultIdleConnTimeout = 90 * time.Second
+
+func (t *Transport) equal(o *Transport) bool {
+	if t == nil || o == nil {
+		return t == o
+	}
+	return *t == *o
+}
+
+// newTransport builds the http.Transport for a domain's settings, which may
+// be nil
+func newTransport(settings *Transport) *http.Transport {
+	var s Transport
+	if settings != nil {
+		s = *settings
+	}
+	if s.IdleConnTimeout == 0 {
+		s.IdleConnTimeout = defaultIdleConnTimeout
+	}
+
+	t := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     !s.DisableHTTP2,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   s.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       s.MaxConnsPerHost,
+		IdleConnTimeout:       s.IdleConnTimeout,
+		ResponseHeaderTimeout: s.ResponseHeaderTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	if s.MaxIdleConnsPerHost > t.MaxIdleConns {
+		t.MaxIdleConns = s.MaxIdleConnsPerHost
+	}
+	if s.DisableHTTP2 {
+		// A non-nil, empty map keeps the transport from negotiating h2
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return t
+}
+
+// roundTripper returns the domain's transport, creating it on first use so
+// that its pooled connections are shared by all requests to the domain
+func (c *DomainConfig) roundTripper() *http.Transport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.transport == nil {
+		c.transport = newTransport(c.Transport)
+	}
+	return c.transport
+}
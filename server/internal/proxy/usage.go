@@ -0,0 +1,29 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+)
+
+// countingBody counts the bytes read through a request or response body,
+// for usage metering
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// countBody wraps a body so the bytes read through it can be counted; a
+// missing body counts as zero
+func countBody(body io.ReadCloser) (io.ReadCloser, *countingBody) {
+	if body == nil || body == http.NoBody {
+		return body, &countingBody{}
+	}
+	c := &countingBody{ReadCloser: body}
+	return c, c
+}
@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCountBody(t *testing.T) {
+	body, counter := countBody(io.NopCloser(strings.NewReader("hello, world")))
+	if _, err := io.Copy(io.Discard, body); err != nil {
+		t.Fatal(err)
+	}
+	if counter.n != 12 {
+		t.Errorf("counted %d bytes, want 12", counter.n)
+	}
+
+	for _, empty := range []io.ReadCloser{nil, http.NoBody} {
+		body, counter := countBody(empty)
+		if body != empty {
+			t.Errorf("countBody(%v) replaced the body", empty)
+		}
+		if counter.n != 0 {
+			t.Errorf("countBody(%v) counted %d bytes", empty, counter.n)
+		}
+	}
+}
+
+func TestRecordBytes(t *testing.T) {
+	m := &MetricsCollector{}
+	m.RecordBytes("example.com", 100, 2000)
+	m.RecordBytes("example.com", 50, 500)
+	m.RecordBytes("other.example.com", 1, 2)
+
+	v, ok := m.metrics.Load("example.com")
+	if !ok {
+		t.Fatal("no metrics recorded for example.com")
+	}
+	metrics := v.(*DomainMetrics)
+	if metrics.BytesIn != 150 || metrics.BytesOut != 2500 {
+		t.Errorf("bytes = %d in, %d out, want 150 in, 2500 out", metrics.BytesIn, metrics.BytesOut)
+	}
+}
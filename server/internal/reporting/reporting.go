@@ -0,0 +1,125 @@
+// Package reporting forwards errors and panics to an external error tracker
+// such as Sentry. Until a Reporter is installed every call is a no-op, so
+// packages can report unconditionally.
+package reporting
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a report
+type Level string
+
+const (
+	LevelError Level = "error"
+	LevelFatal Level = "fatal"
+)
+
+// Report is a single error or recovered panic
+type Report struct {
+	Level Level
+	Err   error
+	Stack []byte            // goroutine stack, for panics
+	Tags  map[string]string // indexed, searchable values such as the job or domain
+	Extra map[string]interface{}
+	Time  time.Time
+	// Fingerprint groups reports in the tracker and limits how often the
+	// same problem is sent; it defaults to the error message
+	Fingerprint []string
+}
+
+// Reporter delivers reports to an error tracker
+type Reporter interface {
+	Send(r Report)
+	// Flush waits until queued reports are sent or ctx is done
+	Flush(ctx context.Context)
+}
+
+// repeatInterval is how often reports sharing a fingerprint are sent, so a
+// failing backend or job does not flood the tracker
+const repeatInterval = time.Minute
+
+var (
+	mu       sync.Mutex
+	reporter Reporter
+	lastSent = map[string]time.Time{}
+)
+
+// SetReporter installs the Reporter that receives every report
+func SetReporter(r Reporter) {
+	mu.Lock()
+	reporter = r
+	mu.Unlock()
+}
+
+// Error reports err with the given tags, e.g. {"job": "healthcheck"}. Nil
+// errors are ignored.
+func Error(err error, tags map[string]string) {
+	if err == nil {
+		return
+	}
+	send(Report{Level: LevelError, Err: err, Tags: tags})
+}
+
+// Throttled reports err like Error, but at most once per repeatInterval for
+// a given key. Use it on hot paths such as proxied requests.
+func Throttled(key string, err error, tags map[string]string) {
+	if err == nil {
+		return
+	}
+	send(Report{Level: LevelError, Err: err, Tags: tags, Fingerprint: []string{key}})
+}
+
+// Panic reports a recovered panic value together with the current stack
+func Panic(v interface{}, tags map[string]string) {
+	err, ok := v.(error)
+	if !ok {
+		err = fmt.Errorf("panic: %v", v)
+	}
+	send(Report{Level: LevelFatal, Err: err, Stack: debug.Stack(), Tags: tags})
+}
+
+// Flush waits for queued reports, e.g. before the process exits
+func Flush(ctx context.Context) {
+	mu.Lock()
+	r := reporter
+	mu.Unlock()
+	if r != nil {
+		r.Flush(ctx)
+	}
+}
+
+func send(report Report) {
+	mu.Lock()
+	r := reporter
+	if r == nil {
+		mu.Unlock()
+		return
+	}
+	if len(report.Fingerprint) == 0 {
+		report.Fingerprint = []string{report.Err.Error()}
+	}
+	key := fmt.Sprint(report.Fingerprint)
+	now := time.Now()
+	if last, ok := lastSent[key]; ok && now.Sub(last) < repeatInterval {
+		mu.Unlock()
+		return
+	}
+	lastSent[key] = now
+	// Forget old fingerprints so the map stays small
+	if len(lastSent) > 1000 {
+		for k, t := range lastSent {
+			if now.Sub(t) >= repeatInterval {
+				delete(lastSent, k)
+			}
+		}
+	}
+	mu.Unlock()
+
+	report.Time = now
+	r.Send(report)
+}
@@ -0,0 +1,156 @@
+package reporting
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"viacortex/internal/logging"
+)
+
+var logger = logging.For("reporting")
+
+// sentryQueue is how many reports may wait for delivery before new ones are
+// dropped
+const sentryQueue = 100
+
+// Sentry sends reports to a Sentry project through its store endpoint
+type Sentry struct {
+	endpoint    string
+	auth        string
+	environment string
+	release     string
+	serverName  string
+	client      *http.Client
+
+	queue   chan Report
+	pending sync.WaitGroup
+}
+
+// NewSentry creates a reporter for the project identified by dsn, e.g.
+// https://<key>@o0.ingest.sentry.io/<project>. Reports are tagged with the
+// environment and release.
+func NewSentry(dsn, environment, release string) (*Sentry, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("invalid sentry dsn")
+	}
+	project := strings.TrimPrefix(u.Path, "/")
+	prefix := ""
+	if i := strings.LastIndex(project, "/"); i >= 0 {
+		prefix, project = "/"+project[:i], project[i+1:]
+	}
+	if project == "" {
+		return nil, fmt.Errorf("invalid sentry dsn: no project id")
+	}
+
+	hostname, _ := os.Hostname()
+	s := &Sentry{
+		endpoint:    fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, prefix, project),
+		auth:        fmt.Sprintf("Sentry sentry_version=7, sentry_client=viacortex/1.0, sentry_key=%s", u.User.Username()),
+		environment: environment,
+		release:     release,
+		serverName:  hostname,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		queue:       make(chan Report, sentryQueue),
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *Sentry) Send(r Report) {
+	s.pending.Add(1)
+	select {
+	case s.queue <- r:
+	default:
+		s.pending.Done()
+		logger.Warn("Error report queue full, dropping report", "error", r.Err)
+	}
+}
+
+func (s *Sentry) Flush(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		s.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+func (s *Sentry) run() {
+	for r := range s.queue {
+		if err := s.post(r); err != nil {
+			logger.Error("Sending error report failed", "error", err)
+		}
+		s.pending.Done()
+	}
+}
+
+func (s *Sentry) post(r Report) error {
+	body, err := json.Marshal(s.event(r))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", s.auth)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("sentry answered %s", resp.Status)
+	}
+	return nil
+}
+
+// event builds the Sentry event payload for a report
+func (s *Sentry) event(r Report) map[string]interface{} {
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	extra := map[string]interface{}{}
+	for k, v := range r.Extra {
+		extra[k] = v
+	}
+	if len(r.Stack) > 0 {
+		extra["stack"] = string(r.Stack)
+	}
+
+	return map[string]interface{}{
+		"event_id":    hex.EncodeToString(id),
+		"timestamp":   r.Time.UTC().Format(time.RFC3339Nano),
+		"level":       string(r.Level),
+		"platform":    "go",
+		"logger":      "viacortex",
+		"server_name": s.serverName,
+		"environment": s.environment,
+		"release":     s.release,
+		"tags":        r.Tags,
+		"extra":       extra,
+		"fingerprint": r.Fingerprint,
+		"exception": map[string]interface{}{
+			"values": []map[string]interface{}{{
+				"type":  fmt.Sprintf("%T", r.Err),
+				"value": r.Err.Error(),
+			}},
+		},
+	}
+}
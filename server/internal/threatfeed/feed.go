@@ -0,0 +1,275 @@
+// Package threatfeed pulls abuse feeds such as Spamhaus DROP and the
+// AbuseIPDB blacklist into a global blocklist, which the proxy applies to
+// every domain before its own IP rules. The leader fetches the feeds and
+// stores their entries; every node loads them from the database.
+package threatfeed
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"viacortex/internal/logging"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+var logger = logging.For("threatfeed")
+
+// SyncInterval is how often the leader looks for feeds due for a refresh
+const SyncInterval = 5 * time.Minute
+
+// Feed kinds
+const (
+	KindSpamhausDROP = "spamhaus_drop"
+	KindAbuseIPDB    = "abuseipdb"
+	KindURL          = "url" // any list with one address or range per line
+)
+
+const (
+	// maxFeedSize bounds a downloaded feed, in bytes
+	maxFeedSize = 64 << 20
+	// maxEntries bounds the ranges taken from one feed
+	maxEntries = 1000000
+)
+
+// DefaultURL returns the address a feed of the given kind is fetched from
+// when it has none
+func DefaultURL(kind string) string {
+	switch kind {
+	case KindSpamhausDROP:
+		return "https://www.spamhaus.org/drop/drop.txt"
+	case KindAbuseIPDB:
+		return "https://api.abuseipdb.com/api/v2/blacklist"
+	}
+	return ""
+}
+
+// ValidKind reports whether kind is a known feed kind
+func ValidKind(kind string) bool {
+	return kind == KindSpamhausDROP || kind == KindAbuseIPDB || kind == KindURL
+}
+
+// ParsePrefix parses an address or CIDR range. IPv4-mapped IPv6 addresses
+// become IPv4 and host bits are cleared.
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		if p.Addr().Is4In6() && p.Bits() >= 96 {
+			p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+		return p.Masked(), nil
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	a = a.Unmap().WithZone("")
+	return netip.PrefixFrom(a, a.BitLen()), nil
+}
+
+// tooBroad reports whether a feed range is too large to be an abuse listing
+// rather than a mistake, e.g. 0.0.0.0/0 blocking everyone
+func tooBroad(p netip.Prefix) bool {
+	if p.Addr().Is4() {
+		return p.Bits() < 8
+	}
+	return p.Bits() < 16
+}
+
+// Parse reads a list with one address or CIDR range per line, the format of
+// Spamhaus DROP, AbuseIPDB's plain text blacklist and most other feeds. Text
+// after "#" or ";" is a comment, as is anything after the first field.
+// Lines without an address and ranges broader than /8 (IPv4) or /16 (IPv6)
+// are skipped, and duplicates are removed.
+func Parse(r io.Reader) ([]netip.Prefix, error) {
+	sc := bufio.NewScanner(r)
+	seen := make(map[netip.Prefix]struct{})
+	var list []netip.Prefix
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		p, err := ParsePrefix(fields[0])
+		if err != nil || tooBroad(p) {
+			continue
+		}
+		if _, ok := seen[p]; ok {
+			continue
+		}
+		if len(list) == maxEntries {
+			return nil, fmt.Errorf("feed lists more than %d ranges", maxEntries)
+		}
+		seen[p] = struct{}{}
+		list = append(list, p)
+	}
+	return list, sc.Err()
+}
+
+// feed is what the syncer needs to fetch a feed
+type feed struct {
+	id            int64
+	name          string
+	kind          string
+	url           string
+	apiKey        string
+	minConfidence int
+}
+
+// Syncer refreshes the entries of the threat feeds that are due
+type Syncer struct {
+	db     *pgxpool.Pool
+	client *http.Client
+}
+
+func NewSyncer(db *pgxpool.Pool) *Syncer {
+	return &Syncer{db: db, client: &http.Client{Timeout: 2 * time.Minute}}
+}
+
+// Run fetches every enabled feed whose refresh interval has passed since it
+// was last tried. A feed that fails keeps its previous entries and is tried
+// again after the interval.
+func (s *Syncer) Run(ctx context.Context) error {
+	rows, err := s.db.Query(ctx, `
+		SELECT id, name, kind, url, api_key, min_confidence
+		FROM threat_feeds
+		WHERE enabled
+		  AND (checked_at IS NULL OR checked_at <= now() - make_interval(mins => refresh_minutes))
+		ORDER BY id
+	`)
+	if err != nil {
+		return err
+	}
+	var due []feed
+	for rows.Next() {
+		var f feed
+		if err := rows.Scan(&f.id, &f.name, &f.kind, &f.url, &f.apiKey, &f.minConfidence); err != nil {
+			rows.Close()
+			return err
+		}
+		due = append(due, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, f := range due {
+		if err := s.sync(ctx, f); err != nil {
+			failed++
+			logger.Warn("Fetching threat feed failed", "feed", f.name, "error", err)
+			if _, err := s.db.Exec(ctx, `
+				UPDATE threat_feeds SET checked_at = CURRENT_TIMESTAMP, last_error = $2 WHERE id = $1
+			`, f.id, err.Error()); err != nil {
+				return err
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d threat feeds failed", failed, len(due))
+	}
+	return nil
+}
+
+// sync fetches a feed and replaces its entries
+func (s *Syncer) sync(ctx context.Context, f feed) error {
+	prefixes, err := s.fetch(ctx, f)
+	if err != nil {
+		return err
+	}
+	cidrs := make([]string, len(prefixes))
+	for i, p := range prefixes {
+		cidrs[i] = p.String()
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM threat_feed_entries WHERE feed_id = $1`, f.id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO threat_feed_entries (feed_id, cidr)
+		SELECT $1, e::cidr FROM unnest($2::text[]) e
+	`, f.id, cidrs); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE threat_feeds
+		SET entry_count = $2, checked_at = CURRENT_TIMESTAMP, fetched_at = CURRENT_TIMESTAMP, last_error = NULL
+		WHERE id = $1
+	`, f.id, len(cidrs)); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	logger.Info("Fetched threat feed", "feed", f.name, "ranges", len(cidrs))
+	return nil
+}
+
+// fetch downloads and parses a feed
+func (s *Syncer) fetch(ctx context.Context, f feed) ([]netip.Prefix, error) {
+	url := f.url
+	if url == "" {
+		url = DefaultURL(f.kind)
+	}
+	if url == "" {
+		return nil, errors.New("feed has no URL")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "viacortex-threatfeed")
+	if f.kind == KindAbuseIPDB {
+		q := req.URL.Query()
+		q.Set("confidenceMinimum", strconv.Itoa(f.minConfidence))
+		q.Set("plaintext", "")
+		req.URL.RawQuery = q.Encode()
+		req.Header.Set("Key", f.apiKey)
+		req.Header.Set("Accept", "text/plain")
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", req.URL.Host, resp.Status)
+	}
+
+	body := &io.LimitedReader{R: resp.Body, N: maxFeedSize + 1}
+	prefixes, err := Parse(body)
+	if err != nil {
+		return nil, err
+	}
+	if body.N == 0 {
+		return nil, fmt.Errorf("feed is larger than %d MiB", maxFeedSize>>20)
+	}
+	// Most likely an error page; keep the entries from the last fetch
+	if len(prefixes) == 0 {
+		return nil, errors.New("feed lists no addresses")
+	}
+	return prefixes, nil
+}
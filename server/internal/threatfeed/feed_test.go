@@ -0,0 +1,118 @@
+package threatfeed
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePrefix(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"192.0.2.1", "192.0.2.1/32", false},
+		{"192.0.2.77/24", "192.0.2.0/24", false},
+		{"::ffff:192.0.2.1", "192.0.2.1/32", false},
+		{"::ffff:192.0.2.0/120", "192.0.2.0/24", false},
+		{"2001:db8::1/48", "2001:db8::/48", false},
+		{"fe80::1%eth0", "fe80::1/128", false},
+		{"192.0.2.1/33", "", true},
+		{"example.com", "", true},
+	}
+	for _, tt := range tests {
+		p, err := ParsePrefix(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParsePrefix(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && p.String() != tt.want {
+			t.Errorf("ParsePrefix(%q) = %s, want %s", tt.in, p, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	feed := `; Spamhaus DROP List
+; Last-Modified: Tue, 14 Oct 2026 00:00:00 GMT
+192.0.2.0/24 ; SBL000001
+198.51.100.7
+# AbuseIPDB style
+203.0.113.5	# seen scanning
+192.0.2.0/24 ; listed twice
+0.0.0.0/0
+2001:db8::/8
+2001:db8:1::/48
+not an address
+`
+	got, err := Parse(strings.NewReader(feed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []netip.Prefix{
+		netip.MustParsePrefix("192.0.2.0/24"),
+		netip.MustParsePrefix("198.51.100.7/32"),
+		netip.MustParsePrefix("203.0.113.5/32"),
+		netip.MustParsePrefix("2001:db8:1::/48"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse() = %v, want %v", got, want)
+	}
+}
+
+func TestDefaultURL(t *testing.T) {
+	for _, kind := range []string{KindSpamhausDROP, KindAbuseIPDB} {
+		if !ValidKind(kind) || DefaultURL(kind) == "" {
+			t.Errorf("%s is not a valid kind with a default URL", kind)
+		}
+	}
+	if !ValidKind(KindURL) || DefaultURL(KindURL) != "" {
+		t.Errorf("url feeds must be valid and have no default URL")
+	}
+	if ValidKind("firehol") {
+		t.Error("unknown kind is valid")
+	}
+}
+
+func TestFetch(t *testing.T) {
+	var query, key string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, key = r.URL.RawQuery, r.Header.Get("Key")
+		switch r.URL.Path {
+		case "/drop.txt":
+			w.Write([]byte("192.0.2.0/24 ; SBL1\n"))
+		case "/empty":
+			w.Write([]byte("<html>maintenance</html>\n"))
+		default:
+			http.Error(w, "rate limited", http.StatusTooManyRequests)
+		}
+	}))
+	defer srv.Close()
+	s := &Syncer{client: srv.Client()}
+	ctx := context.Background()
+
+	prefixes, err := s.fetch(ctx, feed{kind: KindURL, url: srv.URL + "/drop.txt"})
+	if err != nil || len(prefixes) != 1 {
+		t.Errorf("fetch = %v, %v, want one range", prefixes, err)
+	}
+
+	s.fetch(ctx, feed{kind: KindAbuseIPDB, url: srv.URL + "/drop.txt", apiKey: "secret", minConfidence: 75})
+	if key != "secret" || !strings.Contains(query, "confidenceMinimum=75") || !strings.Contains(query, "plaintext") {
+		t.Errorf("AbuseIPDB request had key %q and query %q", key, query)
+	}
+
+	if _, err := s.fetch(ctx, feed{kind: KindURL, url: srv.URL + "/empty"}); err == nil || !strings.Contains(err.Error(), "no addresses") {
+		t.Errorf("fetch of a page without addresses error = %v", err)
+	}
+	if _, err := s.fetch(ctx, feed{kind: KindURL, url: srv.URL + "/limited"}); err == nil || !strings.Contains(err.Error(), "429") {
+		t.Errorf("fetch of a failing feed error = %v", err)
+	}
+	if _, err := s.fetch(ctx, feed{kind: KindURL}); err == nil {
+		t.Error("fetch of a feed without a URL succeeded")
+	}
+}
@@ -0,0 +1,97 @@
+package threatfeed
+
+import (
+	"context"
+	"net/netip"
+	"time"
+
+	"viacortex/internal/proxy"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// LoadInterval is how often each node checks whether the blocklist changed
+const LoadInterval = time.Minute
+
+// Refresher keeps a proxy's blocklist in step with the stored feed entries
+// and allowlist
+type Refresher struct {
+	db      *pgxpool.Pool
+	proxy   *proxy.ProxyServer
+	version int64
+	loaded  bool
+}
+
+func NewRefresher(db *pgxpool.Pool, p *proxy.ProxyServer) *Refresher {
+	return &Refresher{db: db, proxy: p}
+}
+
+// Run reloads the blocklist when a feed's entries, a feed being enabled or
+// the allowlist changed since the last load
+func (r *Refresher) Run(ctx context.Context) error {
+	var version int64
+	if err := r.db.QueryRow(ctx, `SELECT version FROM threat_blocklist_version`).Scan(&version); err != nil {
+		return err
+	}
+	if r.loaded && version == r.version {
+		return nil
+	}
+
+	rows, err := r.db.Query(ctx, `
+		SELECT e.feed_id, e.cidr::text
+		FROM threat_feed_entries e
+		JOIN threat_feeds f ON f.id = e.feed_id
+		WHERE f.enabled
+		ORDER BY e.feed_id
+	`)
+	if err != nil {
+		return err
+	}
+	var blocked []proxy.BlockedPrefix
+	for rows.Next() {
+		var b proxy.BlockedPrefix
+		var cidr string
+		if err := rows.Scan(&b.FeedID, &cidr); err != nil {
+			rows.Close()
+			return err
+		}
+		if b.Prefix, err = netip.ParsePrefix(cidr); err != nil {
+			logger.Warn("Invalid threat feed entry", "feed_id", b.FeedID, "cidr", cidr)
+			continue
+		}
+		blocked = append(blocked, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = r.db.Query(ctx, `SELECT cidr::text FROM threat_allowlist`)
+	if err != nil {
+		return err
+	}
+	var allow []netip.Prefix
+	for rows.Next() {
+		var cidr string
+		if err := rows.Scan(&cidr); err != nil {
+			rows.Close()
+			return err
+		}
+		p, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			logger.Warn("Invalid allowlist entry", "cidr", cidr)
+			continue
+		}
+		allow = append(allow, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	list := proxy.NewBlocklist(blocked, allow)
+	r.proxy.SetBlocklist(list)
+	r.version, r.loaded = version, true
+	logger.Info("Loaded threat feed blocklist", "ranges", list.Len(), "allowed", len(allow))
+	return nil
+}
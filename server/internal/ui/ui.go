@@ -0,0 +1,82 @@
+// Package ui serves the dashboard's static build embedded in the binary, so
+// a single binary gives a working admin UI. The build is copied into dist/
+// by "pnpm build:embed" in the client; a placeholder page is served until
+// then.
+package ui
+
+import (
+	"bytes"
+	"embed"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+//go:embed all:dist
+var embedded embed.FS
+
+// placeholderMarker identifies the page shipped when no build was embedded
+var placeholderMarker = []byte(`name="viacortex-ui" content="placeholder"`)
+
+// Built reports whether a dashboard build, rather than the placeholder, is
+// embedded
+func Built() bool {
+	index, err := embedded.ReadFile("dist/index.html")
+	return err == nil && !bytes.Contains(index, placeholderMarker)
+}
+
+// Handler serves the embedded files. Exported pages are found with or
+// without their .html extension, and unknown paths get index.html so
+// client-side routes load the app.
+func Handler() http.Handler {
+	dist, err := fs.Sub(embedded, "dist")
+	if err != nil {
+		panic(err)
+	}
+	files := http.FileServer(http.FS(dist))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		switch {
+		case name == "":
+		case exists(dist, name):
+		case exists(dist, name+".html"):
+			name += ".html"
+		case exists(dist, path.Join(name, "index.html")):
+			name = path.Join(name, "index.html")
+		case strings.HasPrefix(name, "_next/"):
+			http.NotFound(w, r)
+			return
+		default:
+			name = "index.html"
+		}
+
+		// Hashed build assets never change; pages must be revalidated so a
+		// new release is picked up
+		if strings.HasPrefix(name, "_next/static/") {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = "/" + name
+		// FileServer redirects requests for index.html to the directory
+		if name == "index.html" || strings.HasSuffix(name, "/index.html") {
+			r2.URL.Path = "/" + strings.TrimSuffix(name, "index.html")
+		}
+		files.ServeHTTP(w, r2)
+	})
+}
+
+func exists(fsys fs.FS, name string) bool {
+	info, err := fs.Stat(fsys, name)
+	return err == nil && !info.IsDir()
+}
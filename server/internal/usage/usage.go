@@ -0,0 +1,119 @@
+// Package usage reads the monthly usage metered per domain by the proxy and
+// reports each finished month to webhooks, for billing.
+package usage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"viacortex/internal/logging"
+	"viacortex/internal/webhooks"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+var logger = logging.For("usage")
+
+// ReportInterval is how often the reporter checks for a month to report
+const ReportInterval = time.Hour
+
+// Row is one domain's usage in a month
+type Row struct {
+	Month          string  `json:"month"` // YYYY-MM
+	Domain         string  `json:"domain"`
+	DomainID       *int64  `json:"domain_id,omitempty"`
+	OrgID          *int64  `json:"org_id,omitempty"`
+	Requests       int64   `json:"requests"`
+	BytesIn        int64   `json:"bytes_in"`
+	BytesOut       int64   `json:"bytes_out"`
+	TCPConnections int64   `json:"tcp_connections"`
+	TCPHours       float64 `json:"tcp_connection_hours"`
+}
+
+// MonthStart returns the first day of t's month, in UTC
+func MonthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// Load returns the usage of every domain in the month starting at month,
+// sorted by domain. Deleted domains keep their usage, without an ID.
+func Load(ctx context.Context, db *pgxpool.Pool, month time.Time) ([]Row, error) {
+	rows, err := db.Query(ctx, `
+		SELECT u.domain, u.domain_id, d.org_id, u.requests, u.bytes_in, u.bytes_out,
+		       u.tcp_connections, u.tcp_seconds / 3600
+		FROM usage_monthly u
+		LEFT JOIN domains d ON d.id = u.domain_id
+		WHERE u.month = $1
+		ORDER BY u.domain
+	`, month)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Row{}
+	for rows.Next() {
+		row := Row{Month: month.Format("2006-01")}
+		if err := rows.Scan(&row.Domain, &row.DomainID, &row.OrgID, &row.Requests,
+			&row.BytesIn, &row.BytesOut, &row.TCPConnections, &row.TCPHours); err != nil {
+			return nil, err
+		}
+		list = append(list, row)
+	}
+	return list, rows.Err()
+}
+
+// Reporter emits a usage.report webhook event once for each finished month
+type Reporter struct {
+	db       *pgxpool.Pool
+	webhooks *webhooks.Dispatcher
+}
+
+func NewReporter(db *pgxpool.Pool, dispatcher *webhooks.Dispatcher) *Reporter {
+	return &Reporter{db: db, webhooks: dispatcher}
+}
+
+// Run reports the previous month unless it was reported already. Usage
+// flushed in the first minutes of a month may still belong to the previous
+// one, so a month is reported an hour after it ends.
+func (r *Reporter) Run(ctx context.Context) error {
+	month := MonthStart(time.Now().Add(-ReportInterval)).AddDate(0, -1, 0)
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Claim the month, so the report is sent once even across leader changes
+	var claimed time.Time
+	err = tx.QueryRow(ctx, `
+		INSERT INTO usage_reports (month) VALUES ($1)
+		ON CONFLICT (month) DO NOTHING
+		RETURNING month
+	`, month).Scan(&claimed)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil
+		}
+		return fmt.Errorf("claiming usage report: %w", err)
+	}
+
+	rows, err := Load(ctx, r.db, month)
+	if err != nil {
+		return fmt.Errorf("loading usage: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	r.webhooks.Emit(webhooks.EventUsageReport, map[string]interface{}{
+		"month":   month.Format("2006-01"),
+		"domains": rows,
+	})
+	logger.Info("Reported monthly usage", "month", month.Format("2006-01"), "domains", len(rows))
+	return nil
+}
@@ -0,0 +1,28 @@
+package usage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMonthStart(t *testing.T) {
+	est := time.FixedZone("EST", -5*3600)
+	tests := []struct {
+		name string
+		in   time.Time
+		want time.Time
+	}{
+		{"mid month", time.Date(2026, 3, 17, 14, 30, 0, 0, time.UTC), time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"first instant", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"last instant", time.Date(2026, 12, 31, 23, 59, 59, 999, time.UTC), time.Date(2026, 12, 1, 0, 0, 0, 0, time.UTC)},
+		// Months are metered in UTC, where this is already April
+		{"other zone", time.Date(2026, 3, 31, 21, 0, 0, 0, est), time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MonthStart(tt.in); !got.Equal(tt.want) || got.Location() != time.UTC {
+				t.Errorf("MonthStart(%v) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
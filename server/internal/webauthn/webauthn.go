@@ -0,0 +1,521 @@
+// Package webauthn implements the relying party side of WebAuthn for
+// passkey and security key logins: "none" attestation on registration and
+// signature verification on login, with ES256, EdDSA and RS256 credentials.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ceremonyTimeout is how long a begun registration or login stays valid
+const ceremonyTimeout = 5 * time.Minute
+
+// Authenticator data flags
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+	flagExtensions   = 0x80
+)
+
+var (
+	// ErrUnknownChallenge means the ceremony was never begun, already
+	// finished or has expired
+	ErrUnknownChallenge = errors.New("unknown or expired challenge")
+	// ErrCloned means the authenticator's signature counter went backwards,
+	// which suggests a cloned credential
+	ErrCloned = errors.New("signature counter did not increase; the authenticator may be cloned")
+)
+
+// Bytes is binary data encoded as unpadded base64url in JSON, as used by
+// the browser WebAuthn API
+type Bytes []byte
+
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+	*b = decoded
+	return nil
+}
+
+// Credential is a registered public key credential
+type Credential struct {
+	ID         []byte
+	PublicKey  []byte // COSE_Key
+	SignCount  uint32
+	AAGUID     []byte
+	Transports []string
+}
+
+// User identifies the account a credential is registered to
+type User struct {
+	ID          int64
+	Name        string
+	DisplayName string
+}
+
+// Handle is the opaque user handle stored on the authenticator
+func (u User) Handle() []byte {
+	return UserHandle(u.ID)
+}
+
+// UserHandle encodes a user ID as a WebAuthn user handle
+func UserHandle(userID int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(userID))
+}
+
+// CredentialDescriptor names a credential in allow and exclude lists
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         Bytes    `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+// Descriptor returns the descriptor used to refer to c in options
+func (c Credential) Descriptor() CredentialDescriptor {
+	return CredentialDescriptor{Type: "public-key", ID: c.ID, Transports: c.Transports}
+}
+
+// CreationOptions is passed to navigator.credentials.create as publicKey
+type CreationOptions struct {
+	Challenge Bytes `json:"challenge"`
+	RP        struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User struct {
+		ID          Bytes  `json:"id"`
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+	} `json:"user"`
+	PubKeyCredParams       []credentialParam      `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection struct {
+		ResidentKey      string `json:"residentKey"`
+		UserVerification string `json:"userVerification"`
+	} `json:"authenticatorSelection"`
+	Attestation string `json:"attestation"`
+}
+
+type credentialParam struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+// RequestOptions is passed to navigator.credentials.get as publicKey
+type RequestOptions struct {
+	Challenge        Bytes                  `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	Timeout          int64                  `json:"timeout"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// RegistrationResponse is the JSON form of the PublicKeyCredential returned
+// by navigator.credentials.create
+type RegistrationResponse struct {
+	ID       string `json:"id"`
+	RawID    Bytes  `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    Bytes    `json:"clientDataJSON"`
+		AttestationObject Bytes    `json:"attestationObject"`
+		Transports        []string `json:"transports"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the JSON form of the PublicKeyCredential returned by
+// navigator.credentials.get
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	RawID    Bytes  `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    Bytes `json:"clientDataJSON"`
+		AuthenticatorData Bytes `json:"authenticatorData"`
+		Signature         Bytes `json:"signature"`
+		UserHandle        Bytes `json:"userHandle"`
+	} `json:"response"`
+}
+
+// session is the server side state of a begun ceremony
+type session struct {
+	kind      string // "webauthn.create" or "webauthn.get"
+	userID    int64  // zero for discoverable (usernameless) logins
+	requireUV bool
+	allowed   [][]byte
+	expires   time.Time
+}
+
+// RelyingParty runs registration and login ceremonies for one RP ID
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+
+	mu       sync.Mutex
+	sessions map[string]session
+}
+
+// New creates a relying party. origins are the exact origins (scheme, host
+// and port) the admin panel is served from.
+func New(id, name string, origins []string) *RelyingParty {
+	return &RelyingParty{ID: id, Name: name, Origins: origins, sessions: make(map[string]session)}
+}
+
+// FromEnv configures the relying party from WEBAUTHN_RP_ID and
+// WEBAUTHN_ORIGINS (comma separated). The RP ID defaults to ADMIN_HOSTNAME,
+// then localhost; origins default to https://<rp id>, plus the dashboard's
+// development origin for localhost.
+func FromEnv() *RelyingParty {
+	id := os.Getenv("WEBAUTHN_RP_ID")
+	if id == "" {
+		id = os.Getenv("ADMIN_HOSTNAME")
+	}
+	if id == "" {
+		id = "localhost"
+	}
+
+	var origins []string
+	for _, o := range strings.Split(os.Getenv("WEBAUTHN_ORIGINS"), ",") {
+		if o = strings.TrimSuffix(strings.TrimSpace(o), "/"); o != "" {
+			origins = append(origins, o)
+		}
+	}
+	if len(origins) == 0 {
+		origins = []string{"https://" + id}
+		if id == "localhost" {
+			origins = append(origins, "http://localhost:3000")
+		}
+	}
+
+	return New(id, "ViaCortex", origins)
+}
+
+func (rp *RelyingParty) newChallenge(s session) ([]byte, error) {
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	s.expires = time.Now().Add(ceremonyTimeout)
+
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	now := time.Now()
+	for k, v := range rp.sessions {
+		if now.After(v.expires) {
+			delete(rp.sessions, k)
+		}
+	}
+	rp.sessions[string(challenge)] = s
+	return challenge, nil
+}
+
+// takeSession consumes the session a ceremony response refers to
+func (rp *RelyingParty) takeSession(challenge []byte, kind string) (session, error) {
+	rp.mu.Lock()
+	s, ok := rp.sessions[string(challenge)]
+	delete(rp.sessions, string(challenge))
+	rp.mu.Unlock()
+
+	if !ok || s.kind != kind || time.Now().After(s.expires) {
+		return session{}, ErrUnknownChallenge
+	}
+	return s, nil
+}
+
+// BeginRegistration starts registering a new credential for user. exclude
+// lists credentials the user already has so the same authenticator is not
+// registered twice.
+func (rp *RelyingParty) BeginRegistration(user User, exclude []Credential) (*CreationOptions, error) {
+	challenge, err := rp.newChallenge(session{kind: "webauthn.create", userID: user.ID})
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &CreationOptions{
+		Challenge: challenge,
+		PubKeyCredParams: []credentialParam{
+			{Type: "public-key", Alg: algES256},
+			{Type: "public-key", Alg: algEdDSA},
+			{Type: "public-key", Alg: algRS256},
+		},
+		Timeout:            ceremonyTimeout.Milliseconds(),
+		ExcludeCredentials: []CredentialDescriptor{},
+		Attestation:        "none",
+	}
+	opts.RP.ID, opts.RP.Name = rp.ID, rp.Name
+	opts.User.ID, opts.User.Name, opts.User.DisplayName = user.Handle(), user.Name, user.DisplayName
+	opts.AuthenticatorSelection.ResidentKey = "preferred"
+	opts.AuthenticatorSelection.UserVerification = "preferred"
+	for _, c := range exclude {
+		opts.ExcludeCredentials = append(opts.ExcludeCredentials, c.Descriptor())
+	}
+	return opts, nil
+}
+
+// FinishRegistration verifies a registration response for userID and returns
+// the new credential. Attestation statements are not verified; the server
+// asks for "none" attestation.
+func (rp *RelyingParty) FinishRegistration(userID int64, resp RegistrationResponse) (*Credential, error) {
+	challenge, err := rp.verifyClientData(resp.Response.ClientDataJSON, "webauthn.create")
+	if err != nil {
+		return nil, err
+	}
+	s, err := rp.takeSession(challenge, "webauthn.create")
+	if err != nil {
+		return nil, err
+	}
+	if s.userID != userID {
+		return nil, ErrUnknownChallenge
+	}
+
+	v, _, err := decodeCBOR(resp.Response.AttestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("decoding attestation object: %w", err)
+	}
+	att, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("attestation object is not a map")
+	}
+	rawAuthData, ok := att["authData"].([]byte)
+	if !ok {
+		return nil, errors.New("attestation object has no authenticator data")
+	}
+
+	authData, err := rp.parseAuthData(rawAuthData, false)
+	if err != nil {
+		return nil, err
+	}
+	if authData.credential == nil {
+		return nil, errors.New("authenticator data has no attested credential")
+	}
+	if len(resp.RawID) > 0 && !bytes.Equal(resp.RawID, authData.credential.ID) {
+		return nil, errors.New("credential ID does not match authenticator data")
+	}
+
+	authData.credential.Transports = resp.Response.Transports
+	return authData.credential, nil
+}
+
+// BeginLogin starts a login. With userID zero the authenticator offers any
+// discoverable credential for this RP. requireUV demands user verification
+// (PIN or biometrics), which is what makes a passkey alone sufficient.
+func (rp *RelyingParty) BeginLogin(userID int64, allow []Credential, requireUV bool) (*RequestOptions, error) {
+	s := session{kind: "webauthn.get", userID: userID, requireUV: requireUV}
+	opts := &RequestOptions{
+		RPID:             rp.ID,
+		Timeout:          ceremonyTimeout.Milliseconds(),
+		AllowCredentials: []CredentialDescriptor{},
+		UserVerification: "preferred",
+	}
+	if requireUV {
+		opts.UserVerification = "required"
+	}
+	for _, c := range allow {
+		s.allowed = append(s.allowed, c.ID)
+		opts.AllowCredentials = append(opts.AllowCredentials, c.Descriptor())
+	}
+
+	challenge, err := rp.newChallenge(s)
+	if err != nil {
+		return nil, err
+	}
+	opts.Challenge = challenge
+	return opts, nil
+}
+
+// Assertion is a verified login
+type Assertion struct {
+	UserID       int64
+	CredentialID []byte
+	SignCount    uint32
+	UserVerified bool
+}
+
+// FinishLogin verifies a login response. lookup returns the stored
+// credential and its owner for a credential ID. The caller must persist the
+// returned sign count.
+func (rp *RelyingParty) FinishLogin(resp AssertionResponse, lookup func(credentialID []byte) (*Credential, int64, error)) (*Assertion, error) {
+	challenge, err := rp.verifyClientData(resp.Response.ClientDataJSON, "webauthn.get")
+	if err != nil {
+		return nil, err
+	}
+	s, err := rp.takeSession(challenge, "webauthn.get")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(s.allowed) > 0 {
+		allowed := false
+		for _, id := range s.allowed {
+			allowed = allowed || bytes.Equal(id, resp.RawID)
+		}
+		if !allowed {
+			return nil, errors.New("credential was not offered for this login")
+		}
+	}
+
+	cred, ownerID, err := lookup(resp.RawID)
+	if err != nil {
+		return nil, err
+	}
+	if s.userID != 0 && s.userID != ownerID {
+		return nil, errors.New("credential belongs to another user")
+	}
+	if len(resp.Response.UserHandle) > 0 && !bytes.Equal(resp.Response.UserHandle, UserHandle(ownerID)) {
+		return nil, errors.New("user handle does not match credential")
+	}
+
+	authData, err := rp.parseAuthData(resp.Response.AuthenticatorData, s.requireUV)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := parsePublicKey(cred.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	clientHash := sha256.Sum256(resp.Response.ClientDataJSON)
+	signed := append(append([]byte(nil), resp.Response.AuthenticatorData...), clientHash[:]...)
+	if err := key.verify(signed, resp.Response.Signature); err != nil {
+		return nil, err
+	}
+
+	// Authenticators without a counter always report zero
+	if (authData.signCount != 0 || cred.SignCount != 0) && authData.signCount <= cred.SignCount {
+		return nil, ErrCloned
+	}
+
+	return &Assertion{
+		UserID:       ownerID,
+		CredentialID: cred.ID,
+		SignCount:    authData.signCount,
+		UserVerified: authData.flags&flagUserVerified != 0,
+	}, nil
+}
+
+// verifyClientData checks the ceremony type and origin and returns the
+// challenge the client signed
+func (rp *RelyingParty) verifyClientData(raw []byte, ceremony string) ([]byte, error) {
+	var cd struct {
+		Type        string `json:"type"`
+		Challenge   string `json:"challenge"`
+		Origin      string `json:"origin"`
+		CrossOrigin bool   `json:"crossOrigin"`
+	}
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return nil, fmt.Errorf("decoding client data: %w", err)
+	}
+	if cd.Type != ceremony {
+		return nil, fmt.Errorf("unexpected client data type %q", cd.Type)
+	}
+	if cd.CrossOrigin {
+		return nil, errors.New("cross-origin ceremonies are not allowed")
+	}
+
+	originOK := false
+	for _, o := range rp.Origins {
+		originOK = originOK || o == cd.Origin
+	}
+	if !originOK {
+		return nil, fmt.Errorf("origin %q is not allowed", cd.Origin)
+	}
+
+	challenge, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(cd.Challenge, "="))
+	if err != nil || len(challenge) == 0 {
+		return nil, ErrUnknownChallenge
+	}
+	return challenge, nil
+}
+
+type authenticatorData struct {
+	flags      byte
+	signCount  uint32
+	credential *Credential
+}
+
+func (rp *RelyingParty) parseAuthData(data []byte, requireUV bool) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, errors.New("authenticator data too short")
+	}
+
+	rpHash := sha256.Sum256([]byte(rp.ID))
+	if subtle.ConstantTimeCompare(data[:32], rpHash[:]) != 1 {
+		return nil, errors.New("authenticator data is for another relying party")
+	}
+
+	ad := &authenticatorData{flags: data[32], signCount: binary.BigEndian.Uint32(data[33:37])}
+	if ad.flags&flagUserPresent == 0 {
+		return nil, errors.New("user presence was not confirmed")
+	}
+	if requireUV && ad.flags&flagUserVerified == 0 {
+		return nil, errors.New("user verification is required")
+	}
+
+	rest := data[37:]
+	if ad.flags&flagAttested != 0 {
+		if len(rest) < 18 {
+			return nil, errors.New("attested credential data too short")
+		}
+		aaguid := rest[:16]
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLen == 0 || idLen > 1023 || len(rest) < idLen {
+			return nil, errors.New("invalid credential ID length")
+		}
+		credID := rest[:idLen]
+		rest = rest[idLen:]
+
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("decoding credential public key: %w", err)
+		}
+		coseKey := rest[:len(rest)-len(after)]
+		if _, err := parsePublicKey(coseKey); err != nil {
+			return nil, err
+		}
+		rest = after
+
+		ad.credential = &Credential{
+			ID:        append([]byte(nil), credID...),
+			PublicKey: append([]byte(nil), coseKey...),
+			SignCount: ad.signCount,
+			AAGUID:    append([]byte(nil), aaguid...),
+		}
+	}
+	if ad.flags&flagExtensions != 0 {
+		if _, after, err := decodeCBOR(rest); err != nil {
+			return nil, fmt.Errorf("decoding extensions: %w", err)
+		} else {
+			rest = after
+		}
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("trailing bytes in authenticator data")
+	}
+	return ad, nil
+}
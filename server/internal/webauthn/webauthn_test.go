@@ -0,0 +1,460 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       string
+		want     interface{}
+		wantRest string
+		wantErr  string
+	}{
+		{name: "small uint", in: "17", want: int64(23)},
+		{name: "uint8", in: "18 18", want: int64(24)},
+		{name: "uint16", in: "19 03e8", want: int64(1000)},
+		{name: "uint32", in: "1a 000f4240", want: int64(1000000)},
+		{name: "uint64", in: "1b 000000e8d4a51000", want: int64(1000000000000)},
+		{name: "negative", in: "20", want: int64(-1)},
+		{name: "negative uint16", in: "39 0100", want: int64(-257)},
+		{name: "byte string", in: "43 010203", want: []byte{1, 2, 3}},
+		{name: "text string", in: "64 49455446", want: "IETF"},
+		{name: "array", in: "83 01 02 03", want: []interface{}{int64(1), int64(2), int64(3)}},
+		{name: "map", in: "a2 01 02 61 61 f5", want: map[interface{}]interface{}{int64(1): int64(2), "a": true}},
+		{name: "simple values", in: "83 f4 f5 f6", want: []interface{}{false, true, nil}},
+		{name: "trailing bytes", in: "01 02 03", want: int64(1), wantRest: "0203"},
+		{name: "empty", in: "", wantErr: "unexpected end of data"},
+		{name: "truncated argument", in: "19 03", wantErr: "unexpected end of data"},
+		{name: "truncated string", in: "45 0102", wantErr: "unexpected end of data"},
+		{name: "array longer than data", in: "9a ffffffff 01", wantErr: "unexpected end of data"},
+		{name: "map longer than data", in: "ba ffffffff 01", wantErr: "unexpected end of data"},
+		{name: "overflow", in: "1b ffffffffffffffff", wantErr: "integer overflow"},
+		{name: "negative overflow", in: "3b ffffffffffffffff", wantErr: "integer overflow"},
+		{name: "indefinite length", in: "5f 41 01 ff", wantErr: "unsupported additional info 31"},
+		{name: "tag", in: "c1 1a 514b67b0", wantErr: "unsupported major type 6"},
+		{name: "float", in: "fb 3ff199999999999a", wantErr: "unsupported simple value 27"},
+		{name: "array map key", in: "a1 80 01", wantErr: "unsupported map key type"},
+		{name: "too deep", in: strings.Repeat("81", maxCBORDepth+1) + "01", wantErr: "nesting too deep"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, rest, err := decodeCBOR(mustHex(t, tt.in))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+			if hex.EncodeToString(rest) != tt.wantRest {
+				t.Errorf("rest = %x, want %s", rest, tt.wantRest)
+			}
+		})
+	}
+}
+
+// encodeCBOR is the inverse of decodeCBOR for the types tests need. Map keys
+// are written in sorted order so encodings are stable.
+func encodeCBOR(v interface{}) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n <= 0xff:
+			return []byte{major<<5 | 24, byte(n)}
+		case n <= 0xffff:
+			return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+		}
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+	}
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case map[interface{}]interface{}:
+		var keys [][]byte
+		values := map[string][]byte{}
+		for k, val := range v {
+			ek := encodeCBOR(k)
+			keys = append(keys, ek)
+			values[string(ek)] = encodeCBOR(val)
+		}
+		sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+		out := head(5, uint64(len(v)))
+		for _, k := range keys {
+			out = append(append(out, k...), values[string(k)]...)
+		}
+		return out
+	}
+	panic("encodeCBOR: unsupported type")
+}
+
+// authenticator is a software authenticator with a single credential
+type authenticator struct {
+	id        []byte
+	cose      []byte
+	sign      func(data []byte) []byte
+	signCount uint32
+}
+
+func newES256Authenticator(t *testing.T) *authenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &authenticator{
+		id: []byte("es256-credential"),
+		cose: encodeCBOR(map[interface{}]interface{}{
+			coseKty: ktyEC2, coseAlg: algES256, coseCrv: crvP256,
+			coseX: key.X.FillBytes(make([]byte, 32)), coseY: key.Y.FillBytes(make([]byte, 32)),
+		}),
+		sign: func(data []byte) []byte {
+			digest := sha256.Sum256(data)
+			sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			return sig
+		},
+	}
+}
+
+func newEd25519Authenticator(t *testing.T) *authenticator {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &authenticator{
+		id: []byte("ed25519-credential"),
+		cose: encodeCBOR(map[interface{}]interface{}{
+			coseKty: ktyOKP, coseAlg: algEdDSA, coseCrv: crvEd25519, coseX: []byte(pub),
+		}),
+		sign: func(data []byte) []byte { return ed25519.Sign(priv, data) },
+	}
+}
+
+// authData builds authenticator data for rpID, with the attested credential
+// when flags has flagAttested set
+func (a *authenticator) authData(rpID string, flags byte) []byte {
+	rpHash := sha256.Sum256([]byte(rpID))
+	data := append(rpHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	if flags&flagAttested != 0 {
+		data = append(data, make([]byte, 16)...)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.id)))
+		data = append(append(data, a.id...), a.cose...)
+	}
+	return data
+}
+
+func clientData(t *testing.T, ceremony, origin string, challenge []byte) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    origin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+const testOrigin = "https://admin.example.com"
+
+func newTestRP() *RelyingParty {
+	return New("admin.example.com", "ViaCortex", []string{testOrigin})
+}
+
+func TestParsePublicKey(t *testing.T) {
+	es := newES256Authenticator(t)
+	ed := newEd25519Authenticator(t)
+	tests := []struct {
+		name    string
+		cose    []byte
+		wantErr string
+	}{
+		{name: "ES256", cose: es.cose},
+		{name: "EdDSA", cose: ed.cose},
+		{name: "trailing data", cose: append(append([]byte(nil), ed.cose...), 0), wantErr: "trailing data"},
+		{name: "not a map", cose: encodeCBOR("key"), wantErr: "not a map"},
+		{name: "unsupported algorithm", cose: encodeCBOR(map[interface{}]interface{}{coseKty: ktyEC2, coseAlg: -35}), wantErr: "unsupported key type 2 with algorithm -35"},
+		{
+			name: "point not on curve",
+			cose: encodeCBOR(map[interface{}]interface{}{
+				coseKty: ktyEC2, coseAlg: algES256, coseCrv: crvP256,
+				coseX: bytes.Repeat([]byte{1}, 32), coseY: bytes.Repeat([]byte{2}, 32),
+			}),
+			wantErr: "invalid P-256 key",
+		},
+		{
+			name:    "short Ed25519 key",
+			cose:    encodeCBOR(map[interface{}]interface{}{coseKty: ktyOKP, coseAlg: algEdDSA, coseCrv: crvEd25519, coseX: []byte{1, 2}}),
+			wantErr: "invalid Ed25519 key",
+		},
+		{
+			name:    "short RSA modulus",
+			cose:    encodeCBOR(map[interface{}]interface{}{coseKty: ktyRSA, coseAlg: algRS256, coseCrv: make([]byte, 128), coseX: []byte{1, 0, 1}}),
+			wantErr: "invalid RSA key",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parsePublicKey(tt.cose)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFinishRegistration(t *testing.T) {
+	const userID = 42
+	tests := []struct {
+		name    string
+		modify  func(a *authenticator, challenge []byte, resp *RegistrationResponse)
+		wantErr string
+	}{
+		{name: "valid"},
+		{
+			name: "wrong origin",
+			modify: func(a *authenticator, challenge []byte, resp *RegistrationResponse) {
+				resp.Response.ClientDataJSON = clientData(t, "webauthn.create", "https://evil.example.com", challenge)
+			},
+			wantErr: `origin "https://evil.example.com" is not allowed`,
+		},
+		{
+			name: "login client data",
+			modify: func(a *authenticator, challenge []byte, resp *RegistrationResponse) {
+				resp.Response.ClientDataJSON = clientData(t, "webauthn.get", testOrigin, challenge)
+			},
+			wantErr: `unexpected client data type "webauthn.get"`,
+		},
+		{
+			name: "unknown challenge",
+			modify: func(a *authenticator, challenge []byte, resp *RegistrationResponse) {
+				resp.Response.ClientDataJSON = clientData(t, "webauthn.create", testOrigin, []byte("other"))
+			},
+			wantErr: ErrUnknownChallenge.Error(),
+		},
+		{
+			name: "other relying party",
+			modify: func(a *authenticator, challenge []byte, resp *RegistrationResponse) {
+				resp.Response.AttestationObject = encodeCBOR(map[interface{}]interface{}{
+					"fmt": "none", "attStmt": map[interface{}]interface{}{},
+					"authData": a.authData("evil.example.com", flagUserPresent|flagAttested),
+				})
+			},
+			wantErr: "another relying party",
+		},
+		{
+			name: "user not present",
+			modify: func(a *authenticator, challenge []byte, resp *RegistrationResponse) {
+				resp.Response.AttestationObject = encodeCBOR(map[interface{}]interface{}{
+					"fmt": "none", "attStmt": map[interface{}]interface{}{},
+					"authData": a.authData("admin.example.com", flagAttested),
+				})
+			},
+			wantErr: "user presence was not confirmed",
+		},
+		{
+			name: "no attested credential",
+			modify: func(a *authenticator, challenge []byte, resp *RegistrationResponse) {
+				resp.Response.AttestationObject = encodeCBOR(map[interface{}]interface{}{
+					"fmt": "none", "attStmt": map[interface{}]interface{}{},
+					"authData": a.authData("admin.example.com", flagUserPresent),
+				})
+			},
+			wantErr: "no attested credential",
+		},
+		{
+			name: "trailing authenticator data",
+			modify: func(a *authenticator, challenge []byte, resp *RegistrationResponse) {
+				resp.Response.AttestationObject = encodeCBOR(map[interface{}]interface{}{
+					"fmt": "none", "attStmt": map[interface{}]interface{}{},
+					"authData": append(a.authData("admin.example.com", flagUserPresent|flagAttested), 0),
+				})
+			},
+			wantErr: "trailing bytes",
+		},
+		{
+			name: "mismatched raw ID",
+			modify: func(a *authenticator, challenge []byte, resp *RegistrationResponse) {
+				resp.RawID = []byte("another-credential")
+			},
+			wantErr: "credential ID does not match",
+		},
+		{
+			name: "attestation object not a map",
+			modify: func(a *authenticator, challenge []byte, resp *RegistrationResponse) {
+				resp.Response.AttestationObject = encodeCBOR("none")
+			},
+			wantErr: "attestation object is not a map",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp := newTestRP()
+			a := newES256Authenticator(t)
+			opts, err := rp.BeginRegistration(User{ID: userID, Name: "admin"}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var resp RegistrationResponse
+			resp.RawID = a.id
+			resp.Response.ClientDataJSON = clientData(t, "webauthn.create", testOrigin, opts.Challenge)
+			resp.Response.AttestationObject = encodeCBOR(map[interface{}]interface{}{
+				"fmt": "none", "attStmt": map[interface{}]interface{}{},
+				"authData": a.authData("admin.example.com", flagUserPresent|flagAttested),
+			})
+			resp.Response.Transports = []string{"usb"}
+			if tt.modify != nil {
+				tt.modify(a, opts.Challenge, &resp)
+			}
+
+			cred, err := rp.FinishRegistration(userID, resp)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(cred.ID, a.id) || !bytes.Equal(cred.PublicKey, a.cose) {
+				t.Errorf("credential = %+v", cred)
+			}
+			if !reflect.DeepEqual(cred.Transports, []string{"usb"}) {
+				t.Errorf("transports = %v", cred.Transports)
+			}
+
+			// The challenge is consumed
+			if _, err := rp.FinishRegistration(userID, resp); !errors.Is(err, ErrUnknownChallenge) {
+				t.Errorf("replay error = %v, want ErrUnknownChallenge", err)
+			}
+		})
+	}
+}
+
+func TestFinishLogin(t *testing.T) {
+	const userID = 42
+	tests := []struct {
+		name       string
+		newAuth    func(t *testing.T) *authenticator
+		storedID   int64 // owner of the stored credential
+		loginUser  int64 // user the login was begun for, zero for usernameless
+		requireUV  bool
+		flags      byte
+		signCount  uint32
+		storedSign uint32
+		tamper     bool
+		wantErr    string
+	}{
+		{name: "ES256", newAuth: newES256Authenticator, flags: flagUserPresent, signCount: 5, storedSign: 4},
+		{name: "EdDSA", newAuth: newEd25519Authenticator, flags: flagUserPresent, signCount: 1},
+		{name: "usernameless with UV", newAuth: newES256Authenticator, requireUV: true, flags: flagUserPresent | flagUserVerified},
+		{name: "UV required", newAuth: newES256Authenticator, requireUV: true, flags: flagUserPresent, wantErr: "user verification is required"},
+		{name: "bad signature", newAuth: newEd25519Authenticator, flags: flagUserPresent, tamper: true, wantErr: errBadSignature.Error()},
+		{name: "counter went backwards", newAuth: newES256Authenticator, flags: flagUserPresent, signCount: 3, storedSign: 3, wantErr: ErrCloned.Error()},
+		{name: "credential of another user", newAuth: newES256Authenticator, storedID: 7, loginUser: userID, flags: flagUserPresent, wantErr: "belongs to another user"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp := newTestRP()
+			a := tt.newAuth(t)
+			a.signCount = tt.signCount
+			owner := tt.storedID
+			if owner == 0 {
+				owner = userID
+			}
+			stored := &Credential{ID: a.id, PublicKey: a.cose, SignCount: tt.storedSign}
+
+			var allow []Credential
+			if tt.loginUser != 0 {
+				allow = []Credential{*stored}
+			}
+			opts, err := rp.BeginLogin(tt.loginUser, allow, tt.requireUV)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var resp AssertionResponse
+			resp.RawID = a.id
+			resp.Response.ClientDataJSON = clientData(t, "webauthn.get", testOrigin, opts.Challenge)
+			resp.Response.AuthenticatorData = a.authData("admin.example.com", tt.flags)
+			clientHash := sha256.Sum256(resp.Response.ClientDataJSON)
+			resp.Response.Signature = a.sign(append(append([]byte(nil), resp.Response.AuthenticatorData...), clientHash[:]...))
+			if tt.tamper {
+				resp.Response.Signature[len(resp.Response.Signature)-1] ^= 1
+			}
+
+			assertion, err := rp.FinishLogin(resp, func(id []byte) (*Credential, int64, error) {
+				if !bytes.Equal(id, a.id) {
+					return nil, 0, errors.New("unknown credential")
+				}
+				return stored, owner, nil
+			})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if assertion.UserID != owner || assertion.SignCount != tt.signCount {
+				t.Errorf("assertion = %+v", assertion)
+			}
+			if assertion.UserVerified != (tt.flags&flagUserVerified != 0) {
+				t.Errorf("UserVerified = %v", assertion.UserVerified)
+			}
+		})
+	}
+}
@@ -0,0 +1,298 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"viacortex/internal/events"
+	"viacortex/internal/logging"
+	"viacortex/internal/reporting"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+var logger = logging.For("webhooks")
+
+// Events that can be subscribed to
+const (
+	EventDomainCreated     = "domain.created"
+	EventDomainUpdated     = "domain.updated"
+	EventDomainDeleted     = "domain.deleted"
+	EventCertificateIssued = "certificate.issued"
+	EventCertificateFailed = "certificate.failed"
+	EventBackendUnhealthy  = "backend.unhealthy"
+	EventBackendHealthy    = "backend.healthy"
+	EventUserLogin         = "user.login"
+	EventUserLoginFailed   = "user.login_failed"
+	EventUsageReport       = "usage.report"
+	EventAll               = "*"
+)
+
+const (
+	maxDeliveryAttempts  = 6
+	baseRetryDelay       = 30 * time.Second
+	deliveryTimeout      = 10 * time.Second
+	deliveryPollInterval = 15 * time.Second
+	maxResponseBodyInLog = 1024
+
+	deliveryStatusPending   = "pending"
+	deliveryStatusDelivered = "delivered"
+	deliveryStatusFailed    = "failed"
+)
+
+var knownEvents = map[string]bool{
+	EventDomainCreated:     true,
+	EventDomainUpdated:     true,
+	EventDomainDeleted:     true,
+	EventCertificateIssued: true,
+	EventCertificateFailed: true,
+	EventBackendUnhealthy:  true,
+	EventBackendHealthy:    true,
+	EventUserLogin:         true,
+	EventUserLoginFailed:   true,
+	EventUsageReport:       true,
+	EventAll:               true,
+}
+
+// IsValidEvent reports whether event can be used in a webhook's event filter
+func IsValidEvent(event string) bool {
+	return knownEvents[event]
+}
+
+// Dispatcher queues webhook deliveries in the database and sends them in the
+// background, retrying failures with exponential backoff. A nil Dispatcher
+// is valid and drops every event.
+type Dispatcher struct {
+	db       *pgxpool.Pool
+	client   *http.Client
+	broker   *events.Broker
+	isLeader func() bool
+	wake     chan struct{}
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+func NewDispatcher(db *pgxpool.Pool) *Dispatcher {
+	return &Dispatcher{
+		db:       db,
+		client:   &http.Client{Timeout: deliveryTimeout},
+		wake:     make(chan struct{}, 1),
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Start runs the delivery worker until ctx is cancelled or Stop is called
+func (d *Dispatcher) Start(ctx context.Context) {
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+
+		ticker := time.NewTicker(deliveryPollInterval)
+		defer ticker.Stop()
+
+		for {
+			if d.isLeader == nil || d.isLeader() {
+				d.deliverDue(ctx)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-d.stopChan:
+				return
+			case <-ticker.C:
+			case <-d.wake:
+			}
+		}
+	}()
+}
+
+func (d *Dispatcher) Stop() {
+	close(d.stopChan)
+	d.wg.Wait()
+}
+
+// SetLeader makes the worker deliver only while isLeader reports true, so a
+// cluster sends each delivery once. Every node still queues events.
+func (d *Dispatcher) SetLeader(isLeader func() bool) {
+	d.isLeader = isLeader
+}
+
+// SetBroker makes every emitted event also available to live subscribers
+func (d *Dispatcher) SetBroker(b *events.Broker) {
+	d.broker = b
+}
+
+// Emit queues a delivery of event to every active webhook subscribed to it
+// and publishes it to live subscribers. It never blocks the caller.
+func (d *Dispatcher) Emit(event string, data interface{}) {
+	if d == nil {
+		return
+	}
+	d.broker.Publish(event, data)
+
+	go func() {
+		if err := d.enqueue(context.Background(), event, data); err != nil {
+			logger.Error("Queueing webhook event failed", "event", event, "error", err)
+		}
+	}()
+}
+
+func (d *Dispatcher) enqueue(ctx context.Context, event string, data interface{}) error {
+	dataJSON, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	result, err := d.db.Exec(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload, status, next_attempt_at)
+		SELECT id, $1, $2, $3, CURRENT_TIMESTAMP
+		FROM webhooks
+		WHERE active = true AND ($1 = ANY(events) OR '*' = ANY(events))
+	`, event, dataJSON, deliveryStatusPending)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() > 0 {
+		select {
+		case d.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+type pendingDelivery struct {
+	id        int64
+	webhookID int64
+	url       string
+	secret    string
+	event     string
+	payload   json.RawMessage
+	attempts  int
+	createdAt time.Time
+}
+
+func (d *Dispatcher) deliverDue(ctx context.Context) {
+	rows, err := d.db.Query(ctx, `
+		SELECT dl.id, dl.webhook_id, w.url, w.secret, dl.event, dl.payload,
+		       dl.attempts, dl.created_at
+		FROM webhook_deliveries dl
+		JOIN webhooks w ON w.id = dl.webhook_id
+		WHERE dl.status = $1 AND dl.next_attempt_at <= CURRENT_TIMESTAMP
+		ORDER BY dl.next_attempt_at
+		LIMIT 100
+	`, deliveryStatusPending)
+	if err != nil {
+		logger.Error("Listing due webhook deliveries failed", "error", err)
+		reporting.Error(err, map[string]string{"job": "webhook_delivery"})
+		return
+	}
+
+	var due []pendingDelivery
+	for rows.Next() {
+		var p pendingDelivery
+		if err := rows.Scan(&p.id, &p.webhookID, &p.url, &p.secret, &p.event,
+			&p.payload, &p.attempts, &p.createdAt); err != nil {
+			logger.Error("Reading webhook delivery failed", "error", err)
+			continue
+		}
+		due = append(due, p)
+	}
+	rows.Close()
+
+	for _, p := range due {
+		d.deliver(ctx, p)
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, p pendingDelivery) {
+	body, err := json.Marshal(map[string]interface{}{
+		"id":         p.id,
+		"event":      p.event,
+		"created_at": p.createdAt,
+		"data":       p.payload,
+	})
+	if err != nil {
+		logger.Error("Encoding webhook delivery failed", "delivery_id", p.id, "error", err)
+		return
+	}
+
+	statusCode, respBody, sendErr := d.send(ctx, p, body)
+	attempts := p.attempts + 1
+
+	status := deliveryStatusDelivered
+	var errMsg *string
+	var nextAttempt *time.Time
+	if sendErr != nil {
+		msg := sendErr.Error()
+		errMsg = &msg
+		if attempts >= maxDeliveryAttempts {
+			status = deliveryStatusFailed
+		} else {
+			status = deliveryStatusPending
+			next := time.Now().Add(baseRetryDelay * time.Duration(1<<(attempts-1)))
+			nextAttempt = &next
+		}
+		logger.Warn("Webhook delivery failed", "delivery_id", p.id, "url", p.url, "attempt", attempts, "error", sendErr)
+	}
+
+	_, err = d.db.Exec(ctx, `
+		UPDATE webhook_deliveries
+		SET status = $1, attempts = $2, response_code = $3, response_body = $4,
+		    error = $5, next_attempt_at = $6,
+		    delivered_at = CASE WHEN $1 = 'delivered' THEN CURRENT_TIMESTAMP ELSE NULL END
+		WHERE id = $7
+	`, status, attempts, statusCode, respBody, errMsg, nextAttempt, p.id)
+	if err != nil {
+		logger.Error("Updating webhook delivery failed", "delivery_id", p.id, "error", err)
+	}
+}
+
+// send POSTs body to the webhook URL, signed with the webhook secret
+func (d *Dispatcher) send(ctx context.Context, p pendingDelivery, body []byte) (int, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+
+	timestamp := fmt.Sprintf("%d", time.Now().Unix())
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ViaCortex-Webhook")
+	req.Header.Set("X-ViaCortex-Event", p.event)
+	req.Header.Set("X-ViaCortex-Delivery", fmt.Sprintf("%d", p.id))
+	req.Header.Set("X-ViaCortex-Timestamp", timestamp)
+	req.Header.Set("X-ViaCortex-Signature", "sha256="+Sign(p.secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBodyInLog))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, string(respBody), fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, string(respBody), nil
+}
+
+// Sign returns the hex HMAC-SHA256 of "timestamp.body" keyed with secret.
+// Receivers recompute it to verify a delivery came from ViaCortex.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}